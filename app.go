@@ -1,171 +1,1687 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"github.com/alexflint/go-arg"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/compact"
 	"s3backup/download"
+	"s3backup/encrypt"
 	"s3backup/log"
+	"s3backup/migrate"
+	"s3backup/reconcile"
 	"s3backup/rotate"
 	"s3backup/rpolicy"
 	"s3backup/s3client"
+	"s3backup/status"
+	"s3backup/tracing"
 	"s3backup/upload"
 	"s3backup/util"
+	"s3backup/version"
+	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
 type args struct {
-	Action                 string `arg:"help:The intended action for the tool to run [backup|upload|download|rotate]"`
-	Region                 string `arg:"required,help:The AWS region to upload the specified file to"`
-	Bucket                 string `arg:"required,help:The S3 bucket to upload the specified file to"`
-	CredFile               string `arg:"help:The full path to the AWS CLI credential file if environment variables are not being used to provide the access id and key"`
-	Profile                string `arg:"help:The profile to use for the AWS CLI credential file"`
-	PathToFile             string `arg:"help:The full path to the file to upload to the specified S3 bucket. Must be specified unless --rotateonly=true"`
-	S3FileName             string `arg:"help:The name of the file as it should appear in the S3 bucket. Must be specified unless --rotateonly=true"`
-	BucketDir              string `arg:"help:The directory chain in the bucket in which to upload the S3 object to. Must include the trailing slash"`
-	Endpoint               string `arg:"help:s3 provider endpoint amazonaws.com or storage.yandexcloud.net"`
-	Timeout                int    `arg:"help:The timeout to upload the specified file (seconds)"`
-	DryRun                 bool   `arg:"help:If enabled then no upload or rotation actions will be executed [default: false]"`
-	ConcurrentWorkers      int    `arg:"help:The number of threads to use when uploading the file to S3"`
-	PartSize               int    `arg:"help:The part size to use when performing a multipart upload or download (MB)"`
-	EnforceRetentionPeriod bool   `arg:"help:If enabled then objects in the S3 bucket will only be rotated if they are older then the retention period"`
-	DailyRetentionCount    int    `arg:"help:The number of daily objects to keep in S3"`
-	DailyRetentionPeriod   int    `arg:"help:The retention period (hours) that a daily object should be kept in S3"`
-	WeeklyRetentionCount   int    `arg:"help:The number of weekly objects to keep in S3"`
-	WeeklyRetentionPeriod  int    `arg:"help:The retention period (hours) that a weekly object should be kept in S3"`
+	Action                 string   `arg:"help:The intended action for the tool to run [backup|upload|download|rotate|simulate|legalhold|migrate|reconcile|list|export|etag|verify|strays|compact]"`
+	CheckPerms             bool     `arg:"help:If enabled then the permissions required by the action are checked before it runs. Every permission is checked if no action is specified [default: false]"`
+	Validate               bool     `arg:"help:If enabled then the credentials resolve and the endpoint is reachable and the bucket exists in --region and the permissions required by the action are checked. s3backup exits with a combined pass or fail without performing the action [default: false]"`
+	NoopExitCode           int      `arg:"help:The exit code when the run succeeds without performing any work e.g. the upload was skipped and rotation deleted nothing. A summary of noop:true or noop:false is always logged [default: 0]"`
+	PartialExitCode        int      `arg:"help:The exit code when only some of the destinations of an upload or the keys of --downloadprefix succeed. The destinations which received the upload are still rotated. If 0 then the run exits with 1 as soon as any of them fails [default: 0]"`
+	Region                 string   `arg:"required,env:S3BACKUP_REGION,help:The AWS region to upload the specified file to"`
+	Bucket                 string   `arg:"required,env:S3BACKUP_BUCKET,help:The S3 bucket to upload the specified file to"`
+	CredFile               string   `arg:"help:The full path to the AWS CLI credential file if environment variables are not being used to provide the access id and key"`
+	Profile                string   `arg:"help:The profile to use for the AWS CLI credential file"`
+	UseInstanceRole        bool     `arg:"help:If enabled then credentials are retrieved from the EC2 instance profile or ECS task role instead of the credential file. The role is also used when the credential file cannot be loaded [default: false]"`
+	AssumeRoleARN          string   `arg:"help:The ARN of a role to assume with the credentials before making any request e.g. a role of the account which owns the bucket. The assumed credentials are refreshed before they expire"`
+	RoleSessionName        string   `arg:"help:The session name of the assumed role which identifies the backup in CloudTrail"`
+	CredentialExpiry       string   `arg:"help:What happens when the credentials expire before the run is estimated to finish once --timeout has elapsed and cannot be refreshed [warn|fail]. fail exits before the action runs rather than failing with access denied partway through [default: warn]"`
+	PathToFile             string   `arg:"help:The full path to the file to upload to the specified S3 bucket or - to upload the content read from stdin. Must be specified unless --rotateonly=true"`
+	Archive                string   `arg:"help:Upload the directory at --pathtofile as a single archive of the specified format [zip]. The extension of the format is appended to the key"`
+	IncludeDotfiles        bool     `arg:"help:If enabled then hidden files and directories beginning with '.' are included when uploading a directory [default: false]"`
+	FollowSymlinks         bool     `arg:"help:If enabled then the files and directories symlinks link to are uploaded under the path of the symlink when uploading every file of a directory. Symlinks are skipped by default [default: false]"`
+	FollowPathSymlink      bool     `arg:"help:If enabled then the file or directory --pathtofile links to is uploaded when --pathtofile is a symlink. If disabled then the upload of a symlink is skipped with a warning. A broken symlink always fails the upload"`
+	Ledger                 string   `arg:"help:The full path to a local ledger of the files of a directory upload which completed. A re-run skips every file the ledger records as uploaded and unchanged without any request to S3. Only supported with the upload action"`
+	DeadLetter             string   `arg:"help:The full path to a local list of the files of a directory upload which still failed once retried with --fileretries. It is written once the remaining files have been uploaded and removed once no file fails"`
+	RetryDeadLetter        bool     `arg:"help:If enabled then only the files of the directory recorded in --deadletter are uploaded so a follow-up run retries just the files which failed [default: false]"`
+	DirManifest            bool     `arg:"help:If enabled then a directory upload also uploads a manifest of every file with the Merkle root of their sha256 checksums to <bucketdir><s3filename>.manifest.json. With --action=download and --verifyonly every file of the directory is verified against it [default: false]"`
+	ExpectedRoot           string   `arg:"help:The Merkle root logged when a directory was uploaded with --dirmanifest which the manifest must record when it is verified"`
+	S3FileName             string   `arg:"help:The name of the file as it should appear in the S3 bucket. Must be specified unless --rotateonly=true"`
+	BucketDir              string   `arg:"help:The directory chain in the bucket in which to upload the S3 object to. Must include the trailing slash"`
+	Endpoint               string   `arg:"help:s3 provider endpoint amazonaws.com or storage.yandexcloud.net"`
+	Partition              string   `arg:"help:The AWS partition to resolve endpoints in [aws|aws-cn|aws-us-gov]. Derived from the region if not specified"`
+	ServiceEndpoints       string   `arg:"help:Route individual AWS services to their own endpoint as service=endpoint pairs separated by a comma e.g. s3=https://gateway:9000. Takes precedence over --endpoint"`
+	Accelerate             bool     `arg:"help:If enabled then S3 requests are sent to the S3 Transfer Acceleration endpoint of the bucket. Only supported with AWS endpoints [default: false]"`
+	Destinations           string   `arg:"help:Additional buckets to upload to as bucket@region entries separated by a comma. The region defaults to --region. An entry prefixed with failover: only receives the upload if the bucket before it failed"`
+	DestinationRetries     int      `arg:"help:The number of times a failed upload to a destination is retried before failing over to the next destination [default: 0]"`
+	DestinationConcurrency int      `arg:"help:The maximum number of destinations uploaded to at once. A failover destination shares the slot of its primary. 0 uploads to every destination at once [default: 0]"`
+	TimeSource             string   `arg:"help:The time used to classify the rotation tier and timestamp the key of a backup [now|filemtime]. filemtime uses the modification time of --pathtofile"`
+	KeyTimeLayout          string   `arg:"help:The Go time layout of the timestamp appended to the key of a backup which rotation orders the keys of each tier by e.g. 2006-01-02_1504. Only the numeric elements 2006 01 02 15 04 05 and .000 are supported and the layout must record the date [default: 20060102T150405]"`
+	Timezone               string   `arg:"help:The IANA time zone e.g. Australia/Sydney or UTC which the date of a backup is classified into its rotation tier and the timestamp of its key formatted in. Defaults to the local time zone of the host"`
+	DateRounding           string   `arg:"help:Round the timestamp of the key of a backup down to the start of the [day|hour] so that a backup which runs again within it overwrites the earlier backup rather than adding another backup to the tier"`
+	Timeout                int      `arg:"help:The timeout to upload the specified file (seconds). Hashing and verifying the file and rotation each have their own timeout which does not count towards it"`
+	HashTimeout            int      `arg:"help:The timeout to hash the file for --skipifunchanged and --skipifexists before it is uploaded (seconds). 0 disables the timeout [default: 0]"`
+	VerifyTimeout          int      `arg:"help:The timeout to verify the uploaded object with --verifychecksum or --quickverify or --checksumalgorithm once the upload has completed (seconds). The uploaded object is kept if verification times out. 0 disables the timeout [default: 0]"`
+	RotateTimeout          int      `arg:"help:The timeout of rotation (seconds). Once it has elapsed no further keys are deleted and the remaining keys are deleted by the next rotation. 0 disables the timeout [default: 0]"`
+	MaxRetries             int      `arg:"help:The number of times an upload which fails with a transient error e.g. a 5xx response or throttling or a reset connection is retried with exponential backoff. Errors such as 400 and 403 and uploads which time out are never retried [default: 3]"`
+	FileRetries            int      `arg:"help:The number of times a file of a directory upload which still fails after --maxretries is uploaded again with exponential backoff. Every error is retried including timeouts [default: 0]"`
+	OnTimeout              string   `arg:"help:What happens to the parts of a multipart upload which times out [abort|preserve]. preserve keeps the parts and writes the state needed to resume the upload to --resumestatefile"`
+	ResumeStateFile        string   `arg:"help:The full path to the file the state of a timed out upload is written to with --ontimeout=preserve or of a failed upload with --resume [default: <pathtofile>.resume.json]"`
+	Resume                 bool     `arg:"help:If enabled then an interrupted multipart upload of the file is resumed by uploading only its missing parts. The upload is read from --resumestatefile or found by its key and the file is uploaded from the start if it changed since. The parts of a failed upload are kept. With --action=download an interrupted download of the key to --pathtofile is resumed by downloading only its remaining bytes unless the key changed since [default: false]"`
+	DryRun                 bool     `arg:"help:If enabled then no upload or rotation actions will be executed [default: false]"`
+	Interactive            bool     `arg:"help:If enabled then --action=rotate prints the keys it would delete and only deletes them once the deletion is confirmed with y. A run without a terminal must confirm the deletion with --yes instead [default: false]"`
+	Yes                    bool     `arg:"help:If enabled then the deletion of the keys printed by --interactive is confirmed without prompting [default: false]"`
+	ConcurrentWorkers      string   `arg:"help:The number of threads to use when uploading the file to S3. 'auto' uses 2 threads per CPU (maximum of 32)"`
+	PartSize               int      `arg:"help:The part size to use when performing a multipart upload or download (MB)"`
+	BufferSize             int      `arg:"help:The size of the buffer each part is read through when uploading or written through when downloading (KB). 0 disables buffering [default: 0]"`
+	UploadPartOrdered      bool     `arg:"help:If enabled then the parts of a multipart upload are uploaded strictly in order of their part number by a single worker for providers which require parts in order or limit concurrent uploads of parts [default: false]"`
+	ProfileTransfer        string   `arg:"help:The named transfer profile which sets --partsize --concurrentworkers and --buffersize [lan|wan|highlatency|lowmem]. Any of these flags or their env-vars specified explicitly take precedence over the profile"`
+	MaxUploadRate          string   `arg:"help:The maximum bytes per second sent by all uploads combined e.g. 10MB or 512KB. The workers of an upload and the files and destinations uploaded at once share the rate. Uploads are not limited if not specified"`
+	ResultsFile            string   `arg:"help:The full path to a file which a newline delimited JSON result is appended to for each uploaded file"`
+	StatusFile             string   `arg:"help:The full path to a JSON status file recording the phase and progress of the run. It is updated periodically and removed on exit"`
+	PidFile                string   `arg:"help:The full path to a file which the process id is written to. It is removed on exit"`
+	Quiet                  bool     `arg:"help:If enabled then the percentage and throughput of uploads are not logged as the file is uploaded [default: false]"`
+	OtlpEndpoint           string   `arg:"help:The OTLP/HTTP endpoint of an OpenTelemetry collector which a trace of the run and each of its phases is exported to e.g. http://collector:4318. Tracing is disabled if not specified [default: $OTEL_EXPORTER_OTLP_ENDPOINT]"`
+	MaxFileSize            string   `arg:"help:The maximum size of the file to upload e.g. 500MB or 2GB. The upload fails if the file is larger unless --force is enabled"`
+	Force                  bool     `arg:"help:If enabled then the file is uploaded even if it exceeds --maxfilesize [default: false]"`
+	StrongVerify           bool     `arg:"help:If enabled then the ETag of every uploaded part is verified against the md5sum of the corresponding part of the file which is hashed as it is uploaded [default: false]"`
+	VerifyChecksum         bool     `arg:"help:If enabled then the ETag of the uploaded object is verified against the md5sum of the file which is read again once the upload has completed [default: false]"`
+	QuickVerify            bool     `arg:"help:If enabled then the first and last 4KiB of the uploaded object are fetched with ranged GETs and compared with the file once the upload has completed. Cheaper than --verifychecksum but only catches a truncated or grossly corrupted object [default: false]"`
+	ChecksumAlgorithm      string   `arg:"help:Upload with a checksum of the algorithm [CRC32C|SHA256] and verify the checksum reported by S3 instead of the ETag. SHA256 is used automatically with --strongverify or --verifychecksum when objects are encrypted with SSE-KMS by --sse or by default"`
+	ChunkSize              int      `arg:"help:Split the file into content defined chunks averaging this size (MB) and only upload the chunks which are not already stored. A manifest of the chunks is uploaded to the key of the backup. 0 disables chunking [default: 0]"`
+	SplitSize              int      `arg:"help:Split the file into volumes of this size (MB) which are each uploaded as a separate object under <bucketdir>.volumes/ with an index of the volumes uploaded to the key of the backup. Downloads reassemble the volumes and rotation deletes them with the index. 0 disables splitting [default: 0]"`
+	LegalHold              string   `arg:"help:The legal hold status to place on uploaded objects or to set on --s3filename with --action=legalhold [ON|OFF]"`
+	Tags                   string   `arg:"help:Tags to place on uploaded objects as key=value pairs separated by a comma. Values may contain the tokens {date} {host} and {tier} which are rendered at upload time e.g. host={host}"`
+	Tag                    []string `arg:"separate,help:A tag to place on uploaded objects as key=value. Repeat the flag for each tag e.g. --tag env=prod --tag owner=backups. Values may contain the same tokens as --tags and a tag replaces the tag of the same key in --tags"`
+	ACL                    string   `arg:"help:The canned ACL to apply to uploaded objects e.g. bucket-owner-full-control"`
+	StorageClass           string   `arg:"help:The storage class of uploaded objects e.g. STANDARD_IA. Objects are stored in STANDARD if unset"`
+	DailyStorageClass      string   `arg:"help:The storage class of daily backups. Takes precedence over --storageclass with --action=backup"`
+	WeeklyStorageClass     string   `arg:"help:The storage class of weekly backups. Takes precedence over --storageclass with --action=backup"`
+	MonthlyStorageClass    string   `arg:"help:The storage class of monthly backups e.g. GLACIER or DEEP_ARCHIVE. Takes precedence over --storageclass with --action=backup"`
+	YearlyStorageClass     string   `arg:"help:The storage class of yearly backups e.g. DEEP_ARCHIVE. Takes precedence over --storageclass with --action=backup"`
+	StorageClassMismatch   string   `arg:"help:What happens when the final key already exists in another storage class [overwrite|keep|fail]. keep uploads in the storage class of the existing object and fail leaves it as it is"`
+	SSE                    string   `arg:"help:The server side encryption to encrypt uploaded objects with [AES256|aws:kms]. Objects are left to the default encryption of the bucket if unset"`
+	KMSKeyID               string   `arg:"help:The ID or ARN of the KMS key to encrypt uploaded objects with. Requires --sse=aws:kms and the AWS managed key is used if unset"`
+	AllowSSEFallback       bool     `arg:"help:If enabled then an upload which fails as the KMS key is disabled or throttled is uploaded again encrypted with SSE-S3 (AES256) instead of failing. Only use for data which is not required to be encrypted with the KMS key [default: false]"`
+	FinalizeAttributes     bool     `arg:"help:If enabled then the attributes of multipart uploaded objects are checked once the upload completes and any the provider did not apply are applied [default: false]"`
+	WebsiteRedirect        string   `arg:"help:Redirect requests for uploaded objects made to the website endpoint of the bucket to this path beginning with / or URL beginning with http:// or https://"`
+	ContentType            string   `arg:"help:The content type of uploaded objects e.g. text/csv [default: detected from the extension of the file or its contents if the extension is unknown]"`
+	Compression            string   `arg:"help:The algorithm to compress the file with before upload [gzip|zstd]. The extension of the algorithm is appended to the key"`
+	CompressionLevel       int      `arg:"help:The compression level to use [gzip: 1-9 | zstd: 1-22]. The default level of the algorithm is used if not specified"`
+	Encrypt                bool     `arg:"help:If enabled then the file is encrypted client side with AES-256-GCM after it is compressed with a key derived from --keyfile or S3BACKUP_PASSPHRASE. The .enc extension is appended to the key [default: false]"`
+	KeyFile                string   `arg:"help:The path of the file containing the key material uploads are encrypted with and encrypted downloads are decrypted with. S3BACKUP_PASSPHRASE is used if unset"`
+	SkipIfUnchanged        bool     `arg:"help:If enabled then the upload is skipped when the file is unchanged since the most recent backup [default: false]"`
+	SkipIfExists           bool     `arg:"help:If enabled then the upload is skipped when an object with the same size and checksum as the file already exists under the final key [default: false]"`
+	PostUploadDelay        int      `arg:"help:The time to wait after a backup upload before confirming the object and starting rotation (seconds)"`
+	DurabilityTimeout      int      `arg:"help:The maximum time to poll for the uploaded object to be confirmed present before rotation is aborted (seconds)"`
+	RequireReplication     bool     `arg:"help:If enabled then rotation only starts once the uploaded object reports a replication status of COMPLETED [default: false]"`
+	EnforceRetentionPeriod bool     `arg:"help:If enabled then objects in the S3 bucket will only be rotated if they are older then the retention period"`
+	DailyRetentionCount    int      `arg:"help:The number of daily objects to keep in S3"`
+	DailyRetentionPeriod   int      `arg:"help:The retention period (hours) that a daily object should be kept in S3"`
+	WeeklyRetentionCount   int      `arg:"help:The number of weekly objects to keep in S3"`
+	WeeklyRetentionPeriod  int      `arg:"help:The retention period (hours) that a weekly object should be kept in S3"`
+	MonthlyRetentionCount  int      `arg:"help:The number of monthly objects to keep in S3. If 0 then monthly objects are never rotated [default: 0]"`
+	MonthlyRetentionPeriod int      `arg:"help:The retention period (hours) that a monthly object should be kept in S3"`
+	YearlyRetentionCount   int      `arg:"help:The number of yearly objects to keep in S3. A backup on January 1st is yearly rather than monthly so the 7 newest are kept by default instead of never being rotated. If 0 then yearly objects are never rotated"`
+	YearlyRetentionPeriod  int      `arg:"help:The retention period (hours) that a yearly object should be kept in S3"`
+	MinExpectedObjects     int      `arg:"help:Fail before rotating if fewer than this many backups are stored under --bucketdir in every tier combined e.g. because a failed mount left nothing to back up. 0 disables the check [default: 0]"`
+	MinKeep                int      `arg:"help:The newest backups of each tier which rotation always keeps even if its retention count is lower e.g. a misconfigured retention count of 0. Only rotation applies the floor as --action=compact and --action=migrate replace the keys they remove with an archive or a new key. 0 disables the floor [default: 0]"`
+	DeleteConfirmAttempts  int      `arg:"help:The number of times a key deleted by rotation is checked with HeadObject until it is no longer found. A deleted key which is still listed is never deleted again or counted as retained. 0 disables the check"`
+	DeleteConfirmInterval  int      `arg:"help:The time to wait between each check of a key deleted by rotation (seconds)"`
+	RestoreLockWait        int      `arg:"help:The time rotation waits for restores holding a lock under --bucketdir to finish. Rotation is skipped if a restore still holds a lock once it has elapsed (seconds) [default: 0]"`
+	ForceTier              string   `arg:"help:Classify the backup into this rotation tier regardless of its date [daily|weekly|monthly|yearly] e.g. monthly for an ad-hoc backup which should be kept"`
+	TagFilter              string   `arg:"help:Only rotate objects with every specified tag as key=value pairs separated by a comma e.g. app=myservice. Objects without the tags are ignored"`
+	TagConcurrency         int      `arg:"help:The number of objects whose tags are fetched at once when rotating with --tagfilter [default: 10]"`
+	TagCacheFile           string   `arg:"help:The full path to a local file which the tags fetched by rotation with --tagfilter are cached in by key and ETag so that later rotations only fetch the tags of new or changed objects"`
+	TagCacheTTL            int      `arg:"help:The time the cached tags of an object are reused before they are fetched again. Tags changed without changing the object are only seen once it has elapsed (seconds) [default: 86400]"`
+	UnparseableKeys        string   `arg:"help:What happens to keys in a rotation tier which do not end with a key timestamp e.g. objects uploaded manually [ignore|lastmodified]. ignore never deletes them and lastmodified rotates them by their last modified time [default: ignore]"`
+	WriteRotationAudit     bool     `arg:"help:If enabled then an audit object recording every key deleted by rotation and why is written after each rotation [default: false]"`
+	RotationAuditKey       string   `arg:"help:The key of the rotation audit object [default: <bucketdir>rotation_audit.json or <bucketdir>rotation_audit.ndjson.gz if compressed]"`
+	CompressRotationAudit  bool     `arg:"help:If enabled then the rotation audit is stored as a gzip compressed history with a line of JSON for each rotation [default: false]"`
+	RotationAuditMaxSize   string   `arg:"help:The maximum size of the rotation audit object e.g. 10MB. Once exceeded the history is moved to a key with the time of its last rotation and a new history is started"`
+	BackupIndex            bool     `arg:"help:If enabled then an index of every backup with its tier size and timestamp is updated after each backup and pruned of the keys deleted by rotation. --action=list reads the index instead of listing the bucket [default: false]"`
+	BackupIndexKey         string   `arg:"help:The key of the backup index [default: <bucketdir>index.json]"`
+	WriteLastSuccess       bool     `arg:"help:If enabled then the time of each successful backup is written to --lastsuccesskey for monitors which alert once it goes stale. Rotation never deletes the marker [default: false]"`
+	LastSuccessKey         string   `arg:"help:The key of the last success marker. It must not be in a rotation tier [default: <bucketdir>.last-success]"`
+	SimulateRuns           int      `arg:"help:The number of backup runs to project when simulating rotation"`
+	SimulateCadence        int      `arg:"help:The hypothetical time between backup runs (hours) when simulating rotation"`
+	MigrateSourceDir       string   `arg:"help:The bucket dir of the existing backups to migrate to --bucketdir with --action=migrate [default: <bucketdir>]"`
+	MigrateSourceName      string   `arg:"help:The S3 file name of the existing backups to migrate to --s3filename with --action=migrate [default: <s3filename>]"`
+	DownloadRemoteOnly     bool     `arg:"help:If enabled then --action=reconcile downloads the files stored under --bucketdir<s3filename>/ which are missing from --pathtofile [default: false]"`
+	Delimiter              string   `arg:"help:Group the keys listed under --bucketdir with --action=list into folders by the delimiter e.g. / [default: every key is listed]"`
+	ByTier                 bool     `arg:"help:If enabled then --action=list lists the backups of each rotation tier under --bucketdir with their sizes and last modified times. The backups of each tier are listed newest first in the order rotation keeps them [default: false]"`
+	JSON                   bool     `arg:"help:If enabled then --action=list writes the listing and --action=rotate with --dryrun writes the rotation report to stdout as a single JSON document and every log is written to stderr [default: false]"`
+	CleanStrays            bool     `arg:"help:If enabled then --action=strays deletes the objects under --bucketdir which are not in any rotation tier rather than only reporting them [default: false]"`
+	CompactTier            string   `arg:"help:The rotation tier whose backups --action=compact bundles into a single archive under --bucketdir.compacted/ [daily|weekly|monthly|yearly]"`
+	CompactOlderThan       int      `arg:"help:The minimum time since a backup was modified for it to be compacted with --action=compact (hours)"`
+	Latest                 bool     `arg:"help:If enabled then --action=download downloads the most recent backup of --s3filename under --bucketdir in any rotation tier [default: false]"`
+	MinAge                 int      `arg:"help:The minimum time since a backup was modified for it to be downloaded with --latest. Newer backups which may still be in flight are ignored (seconds) [default: 0]"`
+	DownloadPrefix         bool     `arg:"help:If enabled then --action=download downloads every key under --bucketdir beginning with --s3filename into the directory --pathtofile keyed by its path relative to --bucketdir. An empty --s3filename downloads every key under --bucketdir [default: false]"`
+	PreserveMetadata       bool     `arg:"help:If enabled then the permissions and modification times of the directories and files of a downloaded zip archive are restored [default: false]"`
+	VerifyOnly             bool     `arg:"help:If enabled then the download is verified against the checksum recorded on upload and its ETag by streaming it through a hasher. Nothing is written to --pathtofile [default: false]"`
+	CheckInodes            bool     `arg:"help:If enabled then a downloaded zip archive is only extracted if the filesystem of --pathtofile has enough free inodes for its entries [default: false]"`
+	RestoreLock            bool     `arg:"help:If enabled then --action=download holds a lock under --bucketdir while the backup is read so that rotation does not delete backups during the restore [default: false]"`
+	RestoreLockTTL         int      `arg:"help:The time after which a restore lock is treated as released if the restore did not release it e.g. because it was killed (seconds)"`
+	RestoreTier            string   `arg:"help:The retrieval tier of the restore --action=download requests for a backup archived in GLACIER or DEEP_ARCHIVE or an archive tier of INTELLIGENT_TIERING [Expedited|Standard|Bulk]"`
+	RestoreDays            int      `arg:"help:The number of days the restored copy of an archived backup is kept before it expires"`
+	RestoreWait            int      `arg:"help:The maximum time --action=download waits for the restore of an archived backup to complete polling every minute. 0 requests the restore and exits with 1 reporting that the restore is in progress so the download can be run again later (seconds) [default: 0]"`
+}
+
+// Version is printed and s3backup exits when --version is specified
+func (args) Version() string {
+	return version.String()
 }
 
 func init() {
 	log.Init(os.Stdout, os.Stdout, os.Stderr)
 }
 
+// Reports the progress of the run to the status file. Nil unless a status file or PID file has been specified
+var runStatus *status.Reporter
+
+// How often the progress of an upload is logged unless --quiet is enabled
+const progressLogInterval = time.Second * 10
+
+// Traces the run and each of its phases. Both are nil unless an OTLP endpoint has been specified
+var runTracer *tracing.Tracer
+var runSpan *tracing.Span
+
+// The prompt of --interactive reads the confirmation from the input and writes the plan to the output. The prompt is
+// only shown if the input is a terminal
+var promptInput io.Reader = os.Stdin
+var promptOutput io.Writer = os.Stdout
+var promptIsTerminal = func() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// Set once only some of the destinations of an upload or the keys of a prefix download succeeded so that the run exits
+// with --partialexitcode rather than 0 or --noopexitcode once every phase has completed
+var partialSuccess bool
+
+// The JSON listing of --action=list with --json and the JSON rotation report of --action=rotate with --dryrun and --json
+// are written to the output
+var listOutput io.Writer = os.Stdout
+
+// EnvPrefix is the prefix of the env-var of every flag, e.g. S3BACKUP_PATHTOFILE for --pathtofile.
+// Required flags also declare their env-var in their tag so that setting it satisfies the requirement
+const EnvPrefix = "S3BACKUP_"
+
 func main() {
+	args, err := defaultArgs()
+	if err != nil {
+		log.Error.Printf("Invalid environment configuration. Reason: %v\n", err)
+		exit(1)
+	}
+
+	// Parse args from command line, which take precedence over the environment
+	arg.MustParse(&args)
+
+	if err = applyTransferProfile(&args, os.Args[1:]); err != nil {
+		log.Error.Printf("Invalid transfer profile specified. Reason: %v\n", err)
+		exit(1)
+	}
+
+	// The tar, JSON listing or JSON rotation report is written to stdout so every log is written to stderr
+	if args.Action == "export" || (args.Action == "list" && args.JSON) || (args.Action == "rotate" && args.DryRun && args.JSON) {
+		log.Init(os.Stderr, os.Stderr, os.Stderr)
+	}
+
+	logArgs(args)
+
+	startStatus(args)
+	startTracing(args)
+
+	log.Info.Println(`
+	######################################
+	#        s3backup started            #
+	######################################
+	`)
+
+	authSpan := runTracer.Start("auth", runSpan)
+	authSpan.SetAttribute("region", args.Region)
+	svc := createClient(args, args.Region)
+	authSpan.End()
+
+	if args.Validate {
+		if !runValidation(svc, args) {
+			exit(1)
+		}
+		stopTracing()
+		runStatus.Stop()
+		return
+	}
+
+	if args.CheckPerms {
+		runPermissionCheck(svc, args)
+	}
+
+	workPerformed := false
+	if !args.CheckPerms || args.Action != "" {
+		workPerformed = runAction(svc, args)
+	}
+
+	log.Info.Println("Finished s3backup!")
+
+	log.Info.Println(`
+	######################################
+	#      s3backup finished             #
+	######################################
+	`)
+
+	// Schedulers can distinguish a run which had nothing to do from one which performed work
+	log.Info.Println("noop:" + strconv.FormatBool(!workPerformed))
+	runSpan.SetAttribute("noop", !workPerformed)
+	log.Info.Println("partial:" + strconv.FormatBool(partialSuccess))
+	runSpan.SetAttribute("partial", partialSuccess)
+	if code := runExitCode(args, workPerformed); code != 0 {
+		exit(code)
+	}
+	stopTracing()
+	runStatus.Stop()
+}
+
+// Returns the exit code of a run which completed. A partial success takes precedence over a run which performed no work
+func runExitCode(arguments args, workPerformed bool) int {
+	switch {
+	case partialSuccess:
+		return arguments.PartialExitCode
+	case !workPerformed:
+		return arguments.NoopExitCode
+	}
+	return 0
+}
+
+// Logs how many of the destinations or keys of the operation succeeded. Exits with 1 if none of them succeeded or no
+// partial exit code has been specified, otherwise the run continues with those which succeeded and exits with the
+// partial exit code once it completes
+func checkPartialSuccess(arguments args, operation string, succeeded int, failed int) {
+	log.Info.Printf("%d of %d %s succeeded and %d failed\n", succeeded, succeeded+failed, operation, failed)
+	if failed == 0 {
+		return
+	}
+	if succeeded == 0 || arguments.PartialExitCode == 0 {
+		exit(1)
+	}
+	log.Warn.Printf("Continuing with the %d %s which succeeded. The run will exit with %d\n", succeeded, operation, arguments.PartialExitCode)
+	partialSuccess = true
+}
+
+// Exits the process once the trace has been exported and the status file and PID file have been removed
+func exit(code int) {
+	runSpan.SetAttribute("exit.code", code)
+	stopTracing()
+	runStatus.Stop()
+	os.Exit(code)
+}
+
+// Starts the span of the run if an OTLP endpoint has been specified. Every phase of the run is traced as a child span
+func startTracing(arguments args) {
+	if arguments.OtlpEndpoint == "" {
+		return
+	}
+
+	runTracer = tracing.NewTracer(tracing.NewOTLPExporter(arguments.OtlpEndpoint, "s3backup", version.Version))
+	runSpan = runTracer.Start("run", nil)
+	runSpan.SetAttribute("action", arguments.Action)
+	runSpan.SetAttribute("bucket", arguments.Bucket)
+}
+
+// Ends the span of the run and exports the trace. A trace which cannot be exported does not fail the run
+func stopTracing() {
+	runSpan.End()
+	if err := runTracer.Flush(); err != nil {
+		log.Warn.Printf("Failed to export the trace of the run. Reason: %v\n", err)
+	}
+}
+
+// Starts reporting the progress of the run if a status file or PID file has been specified. The files are also
+// removed if the run is interrupted
+func startStatus(arguments args) {
+	if arguments.StatusFile == "" && arguments.PidFile == "" {
+		return
+	}
+
+	reporter, err := status.Start(arguments.StatusFile, arguments.PidFile, arguments.Action, time.Second*5)
+	if err != nil {
+		log.Error.Printf("Failed to write the status file or PID file. Reason: %v\n", err)
+		exit(1)
+	}
+	runStatus = reporter
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Error.Printf("Received %v, exiting\n", sig)
+		exit(1)
+	}()
+}
+
+// Returns the default args overridden by any S3BACKUP_* env-vars. The AWS_* env-vars are still supported
+// but an S3BACKUP_* env-var takes precedence over them
+func defaultArgs() (args, error) {
 	// Set default args
 	args := args{}
 	args.Timeout = 3600 // Default timeout to 1 hour for file upload
+	args.MaxRetries = 3
 	args.CredFile = util.GetEnvString("AWS_CRED_FILE", "")
 	args.Profile = util.GetEnvString("AWS_PROFILE", "default")
+	args.RoleSessionName = version.Name
+	args.CredentialExpiry = s3client.CredentialExpiryWarn
 	args.BucketDir = util.GetEnvString("AWS_BUCKET", "")
 	args.Endpoint = util.GetEnvString("AWS_ENDPOINT", "amazonaws.com")
+	args.Partition = util.GetEnvString("AWS_PARTITION", "")
+	args.TimeSource = "now"
+	args.KeyTimeLayout = util.DefaultKeyTimeLayout
+	args.OnTimeout = upload.OnTimeoutAbort
+	args.StorageClassMismatch = upload.StorageClassMismatchOverwrite
+	args.UnparseableKeys = rotate.UnparseableKeysIgnore
 	args.EnforceRetentionPeriod = true
+	args.FollowPathSymlink = true
 	args.DryRun = false
-	args.ConcurrentWorkers = 5
+	args.ConcurrentWorkers = "5"
 	args.PartSize = 50
 	args.DailyRetentionCount = 6
 	args.DailyRetentionPeriod = 168
 	args.WeeklyRetentionCount = 4
 	args.WeeklyRetentionPeriod = 672
+	args.MonthlyRetentionPeriod = 8760
+	args.YearlyRetentionCount = 7
+	args.YearlyRetentionPeriod = 61320
+	args.PostUploadDelay = 0
+	args.DurabilityTimeout = 300
+	args.DeleteConfirmAttempts = 3
+	args.DeleteConfirmInterval = 1
+	args.RestoreLockTTL = 21600
+	args.RestoreTier = s3.TierStandard
+	args.RestoreDays = 1
+	args.TagConcurrency = 10
+	args.TagCacheTTL = 86400
+	args.SimulateRuns = 7
+	args.SimulateCadence = 24
+	args.CompactTier = "daily"
+	args.CompactOlderThan = 720
+	args.OtlpEndpoint = util.GetEnvString("OTEL_EXPORTER_OTLP_ENDPOINT", "")
 
-	// Parse args from command line
-	arg.MustParse(&args)
-
-	logArgs(args)
+	err := util.SetFieldsFromEnv(EnvPrefix, &args)
+	return args, err
+}
 
-	log.Info.Println(`
-	######################################
-	#        s3backup started            #
-	######################################
-	`)
+// Replaces the transfer settings of the args with those of the transfer profile if one has been specified. A setting
+// whose flag is on the command line or whose env-var is set takes precedence over the profile
+func applyTransferProfile(arguments *args, commandLine []string) error {
+	if arguments.ProfileTransfer == "" {
+		return nil
+	}
 
-	svc, err := s3client.CreateS3Client(args.CredFile, args.Profile, args.Region, args.Endpoint)
+	profile, err := util.GetTransferProfile(arguments.ProfileTransfer)
 	if err != nil {
-		log.Error.Println(err)
-		os.Exit(1)
+		return err
 	}
 
-	runAction(svc, args)
+	if !transferSettingSpecified(commandLine, "PartSize") {
+		arguments.PartSize = profile.PartSize
+	}
+	if !transferSettingSpecified(commandLine, "ConcurrentWorkers") {
+		arguments.ConcurrentWorkers = profile.ConcurrentWorkers
+	}
+	if !transferSettingSpecified(commandLine, "BufferSize") {
+		arguments.BufferSize = profile.BufferSize
+	}
 
-	log.Info.Println("Finished s3backup!")
+	log.Info.Printf("Using transfer profile '%s': --partsize=%d --concurrentworkers=%s --buffersize=%d\n",
+		arguments.ProfileTransfer, arguments.PartSize, arguments.ConcurrentWorkers, arguments.BufferSize)
+	return nil
+}
 
-	log.Info.Println(`
-	######################################
-	#      s3backup finished             #
-	######################################
-	`)
+// Returns true if the flag of the field is on the command line or its env-var is set
+func transferSettingSpecified(commandLine []string, fieldName string) bool {
+	if _, ok := os.LookupEnv(EnvPrefix + strings.ToUpper(fieldName)); ok {
+		return true
+	}
 
+	flag := "--" + strings.ToLower(fieldName)
+	for _, argument := range commandLine {
+		if argument == flag || strings.HasPrefix(argument, flag+"=") {
+			return true
+		}
+	}
+	return false
 }
 
-func runAction(svc *s3.S3, args args) {
+// Runs the action and returns whether any work was performed. Read only actions such as simulate never perform work
+func runAction(svc *s3.S3, args args) bool {
+	runStatus.SetPhase(status.PhaseRunning)
+
 	switch args.Action {
 	case "backup":
-		runBackupAction(svc, args)
+		return runBackupAction(svc, args)
 	case "upload":
-		runUploadAction(svc, args)
+		return runUploadAction(svc, args)
 	case "download":
-		runDownloadAction(svc, args)
+		return runDownloadAction(svc, args)
 	case "rotate":
-		runRotateAction(svc, args)
+		return runRotateAction(svc, args)
+	case "simulate":
+		runSimulateAction(svc, args)
+	case "legalhold":
+		return runLegalHoldAction(svc, args)
+	case "migrate":
+		return runMigrateAction(svc, args)
+	case "reconcile":
+		return runReconcileAction(svc, args)
+	case "list":
+		runListAction(svc, args)
+	case "export":
+		return runExportAction(svc, args)
+	case "etag":
+		runETagAction(args)
+	case "verify":
+		return runVerifyAction(svc, args)
+	case "strays":
+		return runStraysAction(svc, args)
+	case "compact":
+		return runCompactAction(svc, args)
 	default:
 		log.Error.Println("unexpected action specified: " + args.Action)
 	}
+	return false
+}
+
+// Checks the configuration of the action and logs the result of every check. Returns true if every check passed
+func runValidation(svc *s3.S3, arguments args) bool {
+	log.Info.Println("Validating the configuration of action: '" + arguments.Action + "'")
+
+	report := s3client.ValidateConfig(svc, arguments.Bucket, arguments.Region, arguments.BucketDir, getRequiredPermissions(arguments))
+	for _, check := range report.Checks {
+		switch {
+		case check.Passed:
+			log.Info.Printf("PASS %-11s %s\n", check.Name, check.Detail)
+		case check.Skipped:
+			log.Warn.Printf("SKIP %-11s %s\n", check.Name, check.Detail)
+		default:
+			log.Error.Printf("FAIL %-11s %s\n", check.Name, check.Detail)
+		}
+	}
+
+	if !report.Passed() {
+		log.Error.Printf("Validation failed: %s check failed. The action was not run\n", strings.Join(report.Failed(), ", "))
+		return false
+	}
+
+	log.Info.Printf("Validation passed: all %d checks passed. The action was not run\n", len(report.Checks))
+	return true
+}
+
+func runPermissionCheck(svc *s3.S3, arguments args) {
+	log.Info.Println("Checking the permissions required for action: '" + arguments.Action + "'")
+
+	report, err := s3client.CheckPermissions(svc, arguments.Bucket, arguments.BucketDir, getRequiredPermissions(arguments))
+	if report.LeftoverKey != "" {
+		log.Warn.Printf("Failed to remove the permission check object: '%s'. It must be removed manually\n", report.LeftoverKey)
+	}
+	if err != nil {
+		log.Error.Printf("Failed to check permissions. Reason: %v\n", err)
+		exit(1)
+	}
+
+	for _, check := range report.Checks {
+		if check.Allowed {
+			log.Info.Printf("Permission '%s' is allowed\n", check.Action)
+		} else {
+			log.Error.Printf("Permission '%s' is missing. %s was denied: %v\n", check.Action, check.Operation, check.Error)
+		}
+	}
+
+	missing := report.Missing()
+	if len(missing) > 0 {
+		log.Error.Printf("Missing required permissions: %s\n", strings.Join(missing, ", "))
+		exit(1)
+	}
+
+	log.Info.Println("All required permissions are allowed")
+}
+
+// Returns the IAM actions required to run the action with the specified arguments
+func getRequiredPermissions(arguments args) []string {
+	multipart := []string{
+		s3client.PermissionPutObject,
+		s3client.PermissionListBucketMultipartUploads,
+		s3client.PermissionListMultipartUploadParts,
+		s3client.PermissionAbortMultipartUpload,
+	}
+	rotation := []string{s3client.PermissionListBucket, s3client.PermissionDeleteObject, s3client.PermissionGetObjectLegalHold}
+	if arguments.WriteRotationAudit || arguments.BackupIndex {
+		rotation = append(rotation, s3client.PermissionGetObject, s3client.PermissionPutObject)
+	}
+	if arguments.TagFilter != "" {
+		rotation = append(rotation, s3client.PermissionGetObjectTagging)
+	}
+
+	var permissions []string
+	switch arguments.Action {
+	case "backup":
+		permissions = append(append(multipart, rotation...), s3client.PermissionGetObject)
+	case "upload":
+		permissions = multipart
+		if arguments.SkipIfUnchanged {
+			permissions = append(permissions, s3client.PermissionListBucket, s3client.PermissionGetObject)
+		} else if arguments.SkipIfExists {
+			permissions = append(permissions, s3client.PermissionGetObject)
+		}
+	case "download":
+		permissions = []string{s3client.PermissionGetObject}
+		if arguments.Latest || arguments.DownloadPrefix {
+			permissions = append(permissions, s3client.PermissionListBucket)
+		}
+		if arguments.RestoreLock {
+			permissions = append(permissions, s3client.PermissionPutObject, s3client.PermissionDeleteObject)
+		}
+	case "rotate":
+		permissions = rotation
+	case "simulate":
+		permissions = []string{s3client.PermissionListBucket}
+	case "legalhold":
+		permissions = []string{s3client.PermissionPutObjectLegalHold}
+	case "migrate":
+		permissions = []string{s3client.PermissionListBucket, s3client.PermissionGetObject, s3client.PermissionPutObject,
+			s3client.PermissionDeleteObject, s3client.PermissionGetObjectTagging}
+	case "reconcile":
+		permissions = append(multipart, s3client.PermissionListBucket, s3client.PermissionGetObject)
+	case "list":
+		permissions = []string{s3client.PermissionListBucket}
+		if arguments.BackupIndex {
+			permissions = append(permissions, s3client.PermissionGetObject)
+		}
+	case "export":
+		permissions = []string{s3client.PermissionListBucket, s3client.PermissionGetObject}
+	case "etag":
+		// The ETag is computed from the local file without any request to S3
+	case "verify":
+		permissions = []string{s3client.PermissionGetObject}
+	case "strays":
+		permissions = []string{s3client.PermissionListBucket}
+		if arguments.CleanStrays {
+			permissions = append(permissions, s3client.PermissionDeleteObject)
+		}
+	case "compact":
+		permissions = append(multipart, s3client.PermissionListBucket, s3client.PermissionGetObject, s3client.PermissionDeleteObject,
+			s3client.PermissionGetObjectLegalHold)
+	default:
+		permissions = append(append(multipart, rotation...), s3client.PermissionGetObject, s3client.PermissionPutObjectLegalHold)
+	}
+
+	if arguments.LegalHold != "" && (arguments.Action == "backup" || arguments.Action == "upload") {
+		permissions = append(permissions, s3client.PermissionPutObjectLegalHold)
+	}
+
+	// Checksums are verified with GetObjectAttributes which is allowed by s3:GetObject
+	if arguments.ChecksumAlgorithm != "" && (arguments.Action == "backup" || arguments.Action == "upload") {
+		permissions = append(permissions, s3client.PermissionGetObject)
+	}
+
+	// Chunked uploads list the chunks which are already stored
+	if arguments.ChunkSize > 0 && (arguments.Action == "backup" || arguments.Action == "upload") {
+		permissions = append(permissions, s3client.PermissionListBucket)
+	}
+
+	return permissions
 }
 
-func runBackupAction(svc *s3.S3, arguments args) {
+func runBackupAction(svc *s3.S3, arguments args) bool {
 	log.Info.Println("Backup action specified, backing up file")
 
 	rotationPolicy := getRotationPolicy(arguments)
 
 	log.Info.Println("Starting standard GFS upload and rotation")
-	prefix := util.GetKeyType(rotationPolicy, time.Now())
-	_, err := upload.UploadFile(svc, getUploadObject(arguments, true), prefix, arguments.DryRun)
+	// The time is fixed once so that the tier and the key and tags of every destination use the same time
+	uploadObject, keyTime, err := upload.WithKeyTime(getUploadObject(arguments, true))
 	if err != nil {
-		log.Error.Printf("Failed to upload file. Aborting backup. Reason: %v\n", err)
-		os.Exit(1)
+		log.Error.Printf("Failed to determine the time of the backup. Aborting backup. Reason: %v\n", err)
+		exit(1)
 	}
+	prefix := util.GetKeyType(rotationPolicy, keyTime)
+	uploadObject.StorageClass = getStorageClass(arguments, rotationPolicy, prefix)
+	runStatus.SetPhase(status.PhaseUploading)
+	results := uploadToDestinations(svc, arguments, uploadObject, prefix)
+
+	durabilityPolicy := rotate.DurabilityPolicy{
+		PostUploadDelay:    time.Second * time.Duration(arguments.PostUploadDelay),
+		PollInterval:       time.Second * 5,
+		Timeout:            time.Second * time.Duration(arguments.DurabilityTimeout),
+		RequireReplication: arguments.RequireReplication,
+	}
+
+	// Every destination which received the backup is rotated
+	runStatus.SetPhase(status.PhaseRotating)
+	workPerformed := false
+	for _, result := range results {
+		destination := result.Destination
+		if result.Key == "" {
+			log.Warn.Printf("Nothing was uploaded to bucket: '%s', skipping rotation\n", destination.Bucket)
+			continue
+		}
 
-	rotate.StartRotation(svc, arguments.Bucket, rotationPolicy, arguments.BucketDir, arguments.DryRun)
+		verifySpan := runTracer.Start("verify", runSpan)
+		verifySpan.SetAttribute("bucket", destination.Bucket)
+		verifySpan.SetAttribute("key", result.Key)
+		err := rotate.ConfirmDurable(destination.Svc, destination.Bucket, result.Key, durabilityPolicy, arguments.DryRun)
+		verifySpan.RecordError(err)
+		verifySpan.End()
+		if err != nil {
+			log.Error.Printf("Failed to confirm uploaded file in bucket: '%s'. Aborting rotation. Reason: %v\n", destination.Bucket, err)
+			exit(1)
+		}
+
+		if result.Uploaded {
+			updateBackupIndex(destination, arguments, result.Key, prefix)
+		}
+
+		rotateSpan := runTracer.Start("rotate", runSpan)
+		rotateSpan.SetAttribute("bucket", destination.Bucket)
+		checkMinExpectedObjects(destination.Svc, destination.Bucket, rotationPolicy, arguments, rotateSpan)
+		deletedKeys := []string{}
+		if checkRestoreLocks(destination.Svc, destination.Bucket, rotationPolicy, arguments, rotateSpan) {
+			deletedKeys = rotate.StartRotation(destination.Svc, destination.Bucket, rotationPolicy, arguments.BucketDir, arguments.DryRun)
+		}
+		rotateSpan.SetAttribute("deleted", len(deletedKeys))
+		rotateSpan.End()
+
+		writeLastSuccess(destination, arguments)
+
+		workPerformed = workPerformed || result.Uploaded || len(deletedKeys) > 0
+	}
 	log.Info.Println("Upload and Rotation Complete!")
 
+	return workPerformed
 }
 
-func runUploadAction(svc *s3.S3, arguments args) {
+// Adds the uploaded backup to the backup index of the destination. A backup which could not be added is still rotated
+// as the index is only used to find backups, the bucket can always be listed instead
+func updateBackupIndex(destination upload.Destination, arguments args, key string, prefix string) {
+	indexKey := getBackupIndexKey(arguments)
+	if indexKey == "" {
+		return
+	}
+	if arguments.DryRun {
+		log.Info.Printf("Skipping update of backup index: '%s' as dry run has been enabled\n", indexKey)
+		return
+	}
+
+	entry, err := s3client.AddToBackupIndex(destination.Svc, destination.Bucket, indexKey, key, strings.TrimSuffix(prefix, "_"))
+	if err != nil {
+		log.Error.Printf("Failed to add key: '%s' to backup index: '%s' in bucket: '%s'. Reason: %v\n", key, indexKey, destination.Bucket, err)
+		return
+	}
+	log.Info.Printf("Added key: '%s' (%s, %d bytes) to backup index: '%s'\n", entry.Key, entry.Tier, entry.Size, indexKey)
+}
+
+// Writes the time of the backup to the last success marker of the destination once the backup is confirmed and rotated.
+// A backup which was skipped as it is unchanged is a success as the destination holds a current backup
+func writeLastSuccess(destination upload.Destination, arguments args) {
+	markerKey := getLastSuccessKey(arguments)
+	if markerKey == "" {
+		return
+	}
+	if arguments.DryRun {
+		log.Info.Printf("Skipping write of last success marker: '%s' as dry run has been enabled\n", markerKey)
+		return
+	}
+
+	if err := s3client.WriteLastSuccess(destination.Svc, destination.Bucket, markerKey, time.Now()); err != nil {
+		log.Error.Printf("Failed to write the last success marker to bucket: '%s'. Reason: %v\n", destination.Bucket, err)
+		return
+	}
+	log.Info.Printf("Wrote last success marker: '%s' to bucket: '%s'\n", markerKey, destination.Bucket)
+}
+
+// Returns the key of the last success marker or an empty key if the marker is not enabled
+func getLastSuccessKey(arguments args) string {
+	if !arguments.WriteLastSuccess {
+		return ""
+	}
+	if arguments.LastSuccessKey != "" {
+		return arguments.LastSuccessKey
+	}
+	return arguments.BucketDir + ".last-success"
+}
+
+// Returns the key of the backup index or an empty key if the index is not enabled
+func getBackupIndexKey(arguments args) string {
+	if !arguments.BackupIndex {
+		return ""
+	}
+	if arguments.BackupIndexKey != "" {
+		return arguments.BackupIndexKey
+	}
+	return arguments.BucketDir + "index.json"
+}
+
+// Returns the storage class of a backup of the tier of the prefix. The storage class of the tier takes precedence over
+// --storageclass. The storage class of every tier is validated so that a typo is found before the first backup of the
+// tier is uploaded rather than when it is
+func getStorageClass(arguments args, rotationPolicy rpolicy.RotationPolicy, prefix string) string {
+	storageClasses := []struct {
+		flag         string
+		prefix       string
+		storageClass string
+	}{
+		{"dailystorageclass", rotationPolicy.DailyPrefix, arguments.DailyStorageClass},
+		{"weeklystorageclass", rotationPolicy.WeeklyPrefix, arguments.WeeklyStorageClass},
+		{"monthlystorageclass", rotationPolicy.MonthlyPrefix, arguments.MonthlyStorageClass},
+		{"yearlystorageclass", rotationPolicy.YearlyPrefix, arguments.YearlyStorageClass},
+		{"storageclass", "", arguments.StorageClass},
+	}
+
+	for _, tier := range storageClasses {
+		if err := upload.ValidateStorageClass(tier.storageClass); err != nil {
+			log.Error.Printf("Invalid --%s specified. Reason: %v\n", tier.flag, err)
+			exit(1)
+		}
+	}
+
+	for _, tier := range storageClasses {
+		if tier.prefix == prefix && tier.storageClass != "" {
+			log.Info.Printf("Uploading the backup with prefix '%s' in storage class: %s\n", prefix, tier.storageClass)
+			return tier.storageClass
+		}
+	}
+	return arguments.StorageClass
+}
+
+func runUploadAction(svc *s3.S3, arguments args) bool {
 	log.Info.Println("Upload action specified, uploading file")
+	runStatus.SetPhase(status.PhaseUploading)
+
+	uploaded := false
+	for _, result := range uploadToDestinations(svc, arguments, getUploadObject(arguments, false), "") {
+		uploaded = uploaded || result.Uploaded
+	}
+	return uploaded
+}
+
+// Uploads the path to the bucket and every destination and reports which destination received each upload. Exits if
+// any primary failed on every one of its destinations unless --partialexitcode is specified, in which case only the
+// results of the primaries which succeeded are returned
+func uploadToDestinations(svc *s3.S3, arguments args, uploadObject upload.UploadObject, prefix string) []upload.DestinationResult {
+	uploadSpan := runTracer.Start("upload", runSpan)
+	defer uploadSpan.End()
+	uploadSpan.SetAttribute("bucket", arguments.Bucket)
+	if info, err := os.Stat(uploadObject.PathToFile); err == nil && info.Mode().IsRegular() {
+		uploadSpan.SetAttribute("bytes", info.Size())
+	}
+
+	results, err := upload.UploadToDestinations(getDestinations(svc, arguments), uploadObject,
+		func(destinationSvc *s3.S3, destinationObject upload.UploadObject) (string, bool, error) {
+			return uploadPath(destinationSvc, arguments, destinationObject, prefix)
+		})
+	if err != nil {
+		log.Error.Printf("Invalid destinations specified. Reason: %v\n", err)
+		uploadSpan.RecordError(err)
+		exit(1)
+	}
+	uploadSpan.SetAttribute("destinations", len(results))
+	if len(results) > 0 {
+		uploadSpan.SetAttribute("key", results[0].Key)
+	}
+
+	succeeded := []upload.DestinationResult{}
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			log.Error.Printf("Failed to upload file to '%s'. Reason: %v\n", result.Primary.Bucket, result.Err)
+			uploadSpan.RecordError(result.Err)
+			continue
+		case result.FailedOver:
+			log.Warn.Printf("Failover destination '%s' in region '%s' received '%s' in place of primary destination '%s'\n",
+				result.Destination.Bucket, result.Destination.Region, result.Key, result.Primary.Bucket)
+		default:
+			log.Info.Printf("Destination '%s' in region '%s' received '%s'\n", result.Destination.Bucket, result.Destination.Region, result.Key)
+		}
+		succeeded = append(succeeded, result)
+	}
+	if len(results) > 1 || len(succeeded) == 0 {
+		checkPartialSuccess(arguments, "destinations", len(succeeded), len(results)-len(succeeded))
+	}
+
+	return succeeded
+}
+
+// Returns the bucket followed by the destinations specified with --destinations. A client is created for each region
+func getDestinations(svc *s3.S3, arguments args) []upload.Destination {
+	destinations, err := upload.ParseDestinations(arguments.Destinations, arguments.Region)
+	if err != nil {
+		log.Error.Printf("Invalid destinations specified. Reason: %v\n", err)
+		exit(1)
+	}
+	destinations = append([]upload.Destination{{Bucket: arguments.Bucket, Region: arguments.Region}}, destinations...)
+
+	clients := map[string]*s3.S3{arguments.Region: svc}
+	for i := range destinations {
+		if _, ok := clients[destinations[i].Region]; !ok {
+			clients[destinations[i].Region] = createClient(arguments, destinations[i].Region)
+		}
+		destinations[i].Svc = clients[destinations[i].Region]
+		destinations[i].Retries = arguments.DestinationRetries
+	}
+
+	return destinations
+}
+
+// Creates a client for the region with the credentials and endpoints of the arguments
+func createClient(arguments args, region string) *s3.S3 {
+	serviceEndpoints, err := util.ParseKeyValues(arguments.ServiceEndpoints)
+	if err != nil {
+		log.Error.Printf("Invalid service endpoints specified. Reason: %v\n", err)
+		exit(1)
+	}
+
+	switch arguments.CredentialExpiry {
+	case s3client.CredentialExpiryWarn, s3client.CredentialExpiryFail:
+	default:
+		log.Error.Printf("Invalid credential expiry specified. Expected either '%s' or '%s' but got: '%s'\n",
+			s3client.CredentialExpiryWarn, s3client.CredentialExpiryFail, arguments.CredentialExpiry)
+		exit(1)
+	}
 
-	_, err := upload.UploadFile(svc, getUploadObject(arguments, false), "", arguments.DryRun)
+	svc, err := s3client.CreateS3Client(arguments.CredFile, arguments.Profile, arguments.UseInstanceRole, arguments.AssumeRoleARN, arguments.RoleSessionName, region, arguments.Endpoint, arguments.Partition, serviceEndpoints, arguments.Accelerate)
 	if err != nil {
-		log.Error.Printf("Failed to upload file. Reason: %v\n", err)
-		os.Exit(1)
+		log.Error.Println(err)
+		exit(1)
+	}
+
+	// The run is estimated to take no longer than the timeout of the upload
+	if arguments.Timeout > 0 {
+		err = s3client.CheckCredentialExpiry(svc.Config.Credentials, time.Second*time.Duration(arguments.Timeout))
+		if err != nil && arguments.CredentialExpiry == s3client.CredentialExpiryFail {
+			log.Error.Printf("Refusing to run as the credentials would expire partway through. Reason: %v\n", err)
+			exit(1)
+		} else if err != nil {
+			log.Warn.Printf("The credentials may expire partway through the run. Reason: %v\n", err)
+		}
 	}
+	return svc
 }
 
-func runRotateAction(svc *s3.S3, arguments args) {
+func runRotateAction(svc *s3.S3, arguments args) bool {
 	log.Info.Println("Rotate action specified, proceeding with rotation only")
-	rotate.StartRotation(svc, arguments.Bucket, getRotationPolicy(arguments), arguments.BucketDir, arguments.DryRun)
+	runStatus.SetPhase(status.PhaseRotating)
+
+	rotateSpan := runTracer.Start("rotate", runSpan)
+	defer rotateSpan.End()
+	rotateSpan.SetAttribute("bucket", arguments.Bucket)
+
+	rotationPolicy := getRotationPolicy(arguments)
+	checkMinExpectedObjects(svc, arguments.Bucket, rotationPolicy, arguments, rotateSpan)
+	if !checkRestoreLocks(svc, arguments.Bucket, rotationPolicy, arguments, rotateSpan) {
+		return false
+	}
+
+	if arguments.Interactive && !arguments.DryRun {
+		plannedKeys := rotate.StartRotation(svc, arguments.Bucket, rotationPolicy, arguments.BucketDir, true)
+		confirmed, err := confirmRotation(arguments, plannedKeys)
+		if err != nil {
+			log.Error.Printf("Refusing to rotate bucket: '%s'. Reason: %v\n", arguments.Bucket, err)
+			rotateSpan.RecordError(err)
+			rotateSpan.End()
+			exit(1)
+		}
+		if !confirmed {
+			rotateSpan.SetAttribute("deleted", 0)
+			return false
+		}
+
+		// Only the confirmed keys are deleted even if the keys to rotate change before the rotation runs
+		rotationPolicy.ConfirmedKeys = make(map[string]bool)
+		for _, key := range plannedKeys {
+			rotationPolicy.ConfirmedKeys[key] = true
+		}
+	}
+
+	deletedKeys, report := rotate.StartRotationWithReport(svc, arguments.Bucket, rotationPolicy, arguments.BucketDir, arguments.DryRun)
+	rotateSpan.SetAttribute("deleted", len(deletedKeys))
+	if arguments.DryRun && arguments.JSON {
+		writeListing(report)
+	}
+	return len(deletedKeys) > 0
+}
+
+// Prints the keys rotation would delete and returns true if their deletion is confirmed, either by --yes or by answering
+// y at the prompt. Anything other than y declines the deletion. Returns an error if the prompt cannot be shown as the
+// input is not a terminal and --yes has not been specified
+func confirmRotation(arguments args, plannedKeys []string) (bool, error) {
+	if len(plannedKeys) == 0 {
+		log.Info.Println("Rotation would not delete any keys, nothing to confirm")
+		return false, nil
+	}
+
+	fmt.Fprintf(promptOutput, "Rotation of bucket '%s' will delete %d key(s):\n", arguments.Bucket, len(plannedKeys))
+	for _, key := range plannedKeys {
+		fmt.Fprintf(promptOutput, "  %s\n", key)
+	}
+
+	if arguments.Yes {
+		log.Info.Printf("Deletion of %d key(s) confirmed by --yes\n", len(plannedKeys))
+		return true, nil
+	}
+	if !promptIsTerminal() {
+		return false, errors.New("--interactive requires a terminal to confirm the deletion, specify --yes to confirm it without one")
+	}
+
+	fmt.Fprintf(promptOutput, "Delete these %d key(s)? [y/N]: ", len(plannedKeys))
+	answer, _ := bufio.NewReader(promptInput).ReadString('\n')
+	if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+		log.Info.Println("Rotation aborted, no keys were deleted")
+		return false, nil
+	}
+	log.Info.Printf("Deletion of %d key(s) confirmed\n", len(plannedKeys))
+	return true, nil
+}
+
+// Exits before rotating the bucket if fewer backups than expected are stored under the bucket dir
+func checkMinExpectedObjects(svc *s3.S3, bucket string, rotationPolicy rpolicy.RotationPolicy, arguments args, span *tracing.Span) {
+	if err := rotate.CheckMinExpectedObjects(svc, bucket, rotationPolicy, arguments.BucketDir); err != nil {
+		log.Error.Printf("Refusing to rotate bucket: '%s' as it may be missing backups. Reason: %v\n", bucket, err)
+		span.RecordError(err)
+		span.End()
+		exit(1)
+	}
+}
+
+// Returns false if rotation of the bucket should be skipped as a restore still holds a lock under the bucket dir
+func checkRestoreLocks(svc *s3.S3, bucket string, rotationPolicy rpolicy.RotationPolicy, arguments args, span *tracing.Span) bool {
+	if err := rotate.WaitForRestores(svc, bucket, rotationPolicy, arguments.BucketDir); err != nil {
+		log.Warn.Printf("Skipping rotation of bucket: '%s' as a restore may be in progress. Reason: %v\n", bucket, err)
+		span.RecordError(err)
+		return false
+	}
+	return true
+}
+
+func runSimulateAction(svc *s3.S3, arguments args) {
+	log.Info.Println("Simulate action specified, projecting rotation without modifying the bucket")
+
+	rotationPolicy := getRotationPolicy(arguments)
+	cadence := time.Hour * time.Duration(arguments.SimulateCadence)
+
+	simulatedRuns, err := rotate.SimulateRotation(svc, arguments.Bucket, rotationPolicy, arguments.BucketDir,
+		arguments.S3FileName, arguments.SimulateRuns, cadence)
+	if err != nil {
+		log.Error.Printf("Failed to simulate rotation. Reason: %v\n", err)
+		exit(1)
+	}
+
+	for i, run := range simulatedRuns {
+		log.Info.Printf("Simulated run %d at %s\n", i+1, run.RunTime.Format(time.RFC3339))
+		if run.UploadedKey != "" {
+			log.Info.Printf("Key uploaded in simulated run: '%s'\n", run.UploadedKey)
+		}
+		for _, key := range run.DeletedKeys {
+			log.Info.Printf("Key deleted in simulated run: '%s'\n", key)
+		}
+		log.Info.Printf("Keys remaining after simulated run: %d daily, %d weekly, %d monthly, %d yearly\n",
+			len(run.RemainingKeys[rotationPolicy.DailyPrefix]), len(run.RemainingKeys[rotationPolicy.WeeklyPrefix]),
+			len(run.RemainingKeys[rotationPolicy.MonthlyPrefix]), len(run.RemainingKeys[rotationPolicy.YearlyPrefix]))
+	}
+}
+
+func runLegalHoldAction(svc *s3.S3, arguments args) bool {
+	log.Info.Println("Legal hold action specified, setting legal hold status")
+
+	var hold bool
+	switch arguments.LegalHold {
+	case s3.ObjectLockLegalHoldStatusOn:
+		hold = true
+	case s3.ObjectLockLegalHoldStatusOff:
+		hold = false
+	default:
+		log.Error.Println("legal hold status must be either ON or OFF: " + arguments.LegalHold)
+		exit(1)
+	}
+
+	key := arguments.BucketDir + arguments.S3FileName
+	if arguments.DryRun {
+		log.Info.Printf("Skipping setting legal hold status '%s' on key: '%s' as dry run has been enabled\n", arguments.LegalHold, key)
+		return false
+	}
+
+	err := s3client.SetLegalHold(svc, arguments.Bucket, key, hold)
+	if err != nil {
+		log.Error.Printf("Failed to set legal hold status on key: '%s'. Reason: %v\n", key, err)
+		exit(1)
+	}
+
+	log.Info.Printf("Legal hold status '%s' set on key: '%s'\n", arguments.LegalHold, key)
+	return true
+}
+
+func runMigrateAction(svc *s3.S3, arguments args) bool {
+	log.Info.Println("Migrate action specified, migrating existing backups")
+
+	migrateObject := migrate.MigrateObject{
+		Bucket:           arguments.Bucket,
+		SourceDir:        arguments.MigrateSourceDir,
+		SourceFileName:   arguments.MigrateSourceName,
+		BucketDir:        arguments.BucketDir,
+		S3FileName:       arguments.S3FileName,
+		Compression:      arguments.Compression,
+		CompressionLevel: arguments.CompressionLevel,
+		NumWorkers:       getConcurrentWorkers(arguments),
+		PartSize:         arguments.PartSize,
+	}
+	if migrateObject.SourceDir == "" {
+		migrateObject.SourceDir = arguments.BucketDir
+	}
+	if migrateObject.SourceFileName == "" {
+		migrateObject.SourceFileName = arguments.S3FileName
+	}
+
+	migratedKeys, err := migrate.MigrateBackups(svc, migrateObject, arguments.DryRun)
+	for _, migratedKey := range migratedKeys {
+		log.Info.Printf("Key migrated: '%s' -> '%s'\n", migratedKey.OldKey, migratedKey.NewKey)
+	}
+	if err != nil {
+		log.Error.Printf("Failed to migrate backups. Reason: %v\n", err)
+		exit(1)
+	}
+	return len(migratedKeys) > 0
+}
+
+func runReconcileAction(svc *s3.S3, arguments args) bool {
+	log.Info.Println("Reconcile action specified, reconciling local backups against the bucket")
+	runStatus.SetPhase(status.PhaseUploading)
+
+	reconcileObject := reconcile.ReconcileObject{
+		Upload:             getUploadObject(arguments, false),
+		DownloadRemoteOnly: arguments.DownloadRemoteOnly,
+	}
+
+	reconciledFiles, err := reconcile.Reconcile(svc, reconcileObject, arguments.DryRun)
+	for _, file := range reconciledFiles {
+		log.Info.Printf("File reconciled by %s as it was %s: '%s' -> key: '%s'\n", file.Action, file.Reason, file.Path, file.Key)
+	}
+	if err != nil {
+		log.Error.Printf("Failed to reconcile backups. Reason: %v\n", err)
+		exit(1)
+	}
+	return len(reconciledFiles) > 0
+}
+
+// Logs the ETag S3 assigns to the file at the path to file when it is uploaded with the part size, so that an ETag
+// which fails verification can be compared against the ETag expected of the local file
+func runETagAction(arguments args) {
+	log.Info.Println("ETag action specified, computing the ETag of the local file")
+
+	partSize := int64(arguments.PartSize * 1024 * 1024)
+	etag, err := upload.ComputeETag(arguments.PathToFile, partSize)
+	if err != nil {
+		log.Error.Printf("Failed to compute the ETag of '%s'. Reason: %v\n", arguments.PathToFile, err)
+		exit(1)
+	}
+
+	if etag.PartSize != partSize {
+		log.Warn.Printf("The part size was increased to %d bytes to upload '%s' within the maximum number of parts\n", etag.PartSize, arguments.PathToFile)
+	}
+	if etag.Multipart {
+		for i, partMD5 := range etag.PartMD5s {
+			log.Info.Printf("Part %d: %s\n", i+1, partMD5)
+		}
+	}
+	log.Info.Printf("ETag of '%s' with a part size of %d bytes: %s\n", arguments.PathToFile, etag.PartSize, etag.ETag)
+}
+
+// Compares the local file with the object in S3 and exits if they do not match so that a monitoring check fails
+func runVerifyAction(svc *s3.S3, arguments args) bool {
+	log.Info.Println("Verify action specified, comparing the local file with the object in S3")
+
+	key := arguments.BucketDir + arguments.S3FileName
+	comparison, err := download.CompareFile(svc, arguments.Bucket, key, arguments.PathToFile)
+	if err != nil {
+		log.Error.Printf("Failed to compare '%s' with key: '%s'. Reason: %v\n", arguments.PathToFile, key, err)
+		exit(1)
+	}
+
+	if !comparison.Match {
+		log.Error.Printf("MISMATCH '%s' does not match key: '%s'. The %s of the local file '%s' differs from the object '%s'\n",
+			arguments.PathToFile, key, comparison.Method, comparison.Local, comparison.Remote)
+		exit(1)
+	}
+
+	log.Info.Printf("MATCH '%s' matches key: '%s' by %s: %s\n", arguments.PathToFile, key, comparison.Method, comparison.Remote)
+	return true
+}
+
+func runListAction(svc *s3.S3, arguments args) {
+	log.Info.Println("List action specified, listing keys under the bucket dir")
+
+	if arguments.ByTier {
+		runListByTier(svc, arguments)
+		return
+	}
+
+	if indexKey := getBackupIndexKey(arguments); indexKey != "" {
+		index, found, err := s3client.GetBackupIndex(svc, arguments.Bucket, indexKey)
+		if err != nil {
+			log.Error.Printf("Failed to read the backup index. Reason: %v\n", err)
+			exit(1)
+		}
+		if found {
+			if arguments.JSON {
+				writeListing(index)
+			}
+			for _, backup := range index.Backups {
+				log.Info.Printf("%s %10d %-7s %s\n", backup.Timestamp.Format(time.RFC3339), backup.Size, backup.Tier, backup.Key)
+			}
+			log.Info.Printf("Listed %d backups from backup index: '%s' updated at %s\n", len(index.Backups), indexKey, index.UpdatedAt.Format(time.RFC3339))
+			return
+		}
+		log.Warn.Printf("No backup index found at key: '%s', listing the bucket instead\n", indexKey)
+	}
+
+	listing, err := s3client.ListByDelimiter(svc, arguments.Bucket, arguments.BucketDir, arguments.Delimiter)
+	if err != nil {
+		log.Error.Printf("Failed to list keys. Reason: %v\n", err)
+		exit(1)
+	}
+
+	if arguments.JSON {
+		writeListing(listing)
+	}
+
+	// Folders are listed before objects in the same way as the S3 console
+	for _, prefix := range listing.CommonPrefixes {
+		log.Info.Printf("%30s %s\n", "PRE", prefix)
+	}
+	for _, obj := range listing.Objects {
+		log.Info.Printf("%s %10d %s\n", obj.ModifiedTime.Format(time.RFC3339), obj.Size, obj.Key)
+	}
+
+	log.Info.Printf("Listed %d folders and %d objects under '%s'\n", len(listing.CommonPrefixes), len(listing.Objects), arguments.BucketDir)
+}
+
+// Lists the backups of each rotation tier under the bucket dir newest first
+func runListByTier(svc *s3.S3, arguments args) {
+	if arguments.Delimiter != "" {
+		log.Error.Println("--delimiter cannot be combined with --bytier as the backups of each tier are listed by their prefix")
+		exit(1)
+	}
+
+	tiers, err := rotate.ListTiers(svc, arguments.Bucket, getRotationPolicy(arguments), arguments.BucketDir)
+	if err != nil {
+		log.Error.Printf("Failed to list the rotation tiers. Reason: %v\n", err)
+		exit(1)
+	}
+
+	if arguments.JSON {
+		writeListing(struct {
+			BucketDir string               `json:"bucketDir"`
+			Tiers     []rotate.TierListing `json:"tiers"`
+		}{arguments.BucketDir, tiers})
+	}
+
+	backups := 0
+	for _, tier := range tiers {
+		log.Info.Printf("%s (%d backups)\n", tier.Tier, len(tier.Backups))
+		for _, backup := range tier.Backups {
+			log.Info.Printf("  %s %10d %s\n", backup.LastModified.Format(time.RFC3339), backup.Size, backup.Key)
+		}
+		backups += len(tier.Backups)
+	}
+
+	log.Info.Printf("Listed %d backups in %d rotation tiers under '%s'\n", backups, len(tiers), arguments.BucketDir)
+}
+
+// Writes the listing or rotation report to the list output as indented JSON
+func writeListing(listing interface{}) {
+	body, err := json.MarshalIndent(listing, "", "  ")
+	if err != nil {
+		log.Error.Printf("Failed to encode the listing as JSON. Reason: %v\n", err)
+		exit(1)
+	}
+	if _, err = listOutput.Write(append(body, '\n')); err != nil {
+		log.Error.Printf("Failed to write the listing. Reason: %v\n", err)
+		exit(1)
+	}
+}
+
+// Reports the objects under the bucket dir which are not in any rotation tier and deletes them if clean strays is
+// enabled. Returns true if any stray was deleted
+func runStraysAction(svc *s3.S3, arguments args) bool {
+	log.Info.Println("Strays action specified, finding objects under the bucket dir which are not in any rotation tier")
+
+	// The backup index and last success marker are never strays even if they are not enabled for this run
+	rotationPolicy := getRotationPolicy(arguments)
+	if rotationPolicy.IndexKey == "" && arguments.BackupIndexKey != "" {
+		rotationPolicy.IndexKey = arguments.BackupIndexKey
+	} else if rotationPolicy.IndexKey == "" {
+		rotationPolicy.IndexKey = arguments.BucketDir + "index.json"
+	}
+	if rotationPolicy.LastSuccessKey == "" && arguments.LastSuccessKey != "" {
+		rotationPolicy.LastSuccessKey = arguments.LastSuccessKey
+	} else if rotationPolicy.LastSuccessKey == "" {
+		rotationPolicy.LastSuccessKey = arguments.BucketDir + ".last-success"
+	}
+
+	strays, err := rotate.FindStrays(svc, arguments.Bucket, rotationPolicy, arguments.BucketDir)
+	if err != nil {
+		log.Error.Printf("Failed to find stray objects. Reason: %v\n", err)
+		exit(1)
+	}
+	for _, stray := range strays {
+		log.Info.Printf("Stray object: %s %10d %s\n", stray.ModifiedTime.Format(time.RFC3339), stray.Size, stray.Key)
+	}
+
+	if !arguments.CleanStrays || len(strays) == 0 {
+		return false
+	}
+
+	deletedKeys, err := rotate.DeleteStrays(svc, arguments.Bucket, arguments.BucketDir, strays, arguments.DryRun)
+	if err != nil {
+		log.Error.Printf("Failed to delete stray objects. Reason: %v\n", err)
+		exit(1)
+	}
+	log.Info.Printf("Deleted %d stray objects under '%s'\n", len(deletedKeys), arguments.BucketDir)
+	return len(deletedKeys) > 0
 }
 
-func runDownloadAction(svc *s3.S3, arguments args) {
+// Bundles the old backups of the compact tier into a single archive and deletes them once the archive is verified
+func runCompactAction(svc *s3.S3, arguments args) bool {
+	log.Info.Println("Compact action specified, bundling old backups into a single archive")
+
+	rotationPolicy := getRotationPolicy(arguments)
+	prefix, err := util.ResolveTier(rotationPolicy, arguments.CompactTier)
+	if err != nil {
+		log.Error.Printf("Failed to compact backups. Reason: %v\n", err)
+		exit(1)
+	}
+
+	compactObject := compact.CompactObject{
+		Bucket:     arguments.Bucket,
+		BucketDir:  arguments.BucketDir,
+		Prefix:     prefix,
+		OlderThan:  time.Duration(arguments.CompactOlderThan) * time.Hour,
+		IndexKey:   rotationPolicy.IndexKey,
+		NumWorkers: getConcurrentWorkers(arguments),
+		PartSize:   arguments.PartSize,
+	}
+
+	archive, err := compact.CompactBackups(svc, compactObject, arguments.DryRun)
+	if err != nil {
+		log.Error.Printf("Failed to compact backups. Reason: %v\n", err)
+		exit(1)
+	}
+	if archive == nil {
+		return false
+	}
+
+	for _, backup := range archive.Backups {
+		log.Info.Printf("Key compacted: '%s' -> '%s'\n", backup.Key, archive.Key)
+	}
+	log.Info.Printf("Compacted %d backups into key: '%s'\n", len(archive.Backups), archive.Key)
+	return true
+}
+
+func runExportAction(svc *s3.S3, arguments args) bool {
+	log.Info.Println("Export action specified, writing every object under the bucket dir to stdout as a tar")
+	runStatus.SetPhase(status.PhaseDownloading)
+
+	exportedKeys, err := download.ExportTar(svc, arguments.Bucket, arguments.BucketDir, int64(arguments.PartSize*1024*1024), os.Stdout)
+	if err != nil {
+		log.Error.Printf("Failed to export keys. Reason: %v\n", err)
+		exit(1)
+	}
+
+	log.Info.Printf("Exported %d objects under '%s'\n", len(exportedKeys), arguments.BucketDir)
+	return len(exportedKeys) > 0
+}
+
+func runDownloadAction(svc *s3.S3, arguments args) bool {
 	log.Info.Println("Download action specified, downloading file")
+	runStatus.SetPhase(status.PhaseDownloading)
+
+	if arguments.DownloadPrefix {
+		return runDownloadPrefix(svc, arguments)
+	}
+
+	s3FileKey := arguments.S3FileName
+	if arguments.Latest {
+		var err error
+		s3FileKey, err = download.FindLatest(svc, arguments.Bucket, arguments.BucketDir, arguments.S3FileName, time.Second*time.Duration(arguments.MinAge))
+		if err != nil {
+			log.Error.Printf("Failed to find the latest backup to download. Aborting. Reason: %v\n", err)
+			exit(1)
+		}
+	}
+
+	downloadSpan := runTracer.Start("download", runSpan)
+	defer downloadSpan.End()
+	downloadSpan.SetAttribute("bucket", arguments.Bucket)
+	downloadSpan.SetAttribute("key", s3FileKey)
+
+	if arguments.DirManifest && arguments.VerifyOnly {
+		manifestKey := arguments.BucketDir + arguments.S3FileName + upload.DirManifestSuffix
+		root, err := download.VerifyDirManifest(svc, arguments.Bucket, manifestKey, arguments.ExpectedRoot)
+		if err != nil {
+			log.Error.Printf("Failed to verify directory against manifest: '%s'. Aborting. Reason: %v\n", manifestKey, err)
+			downloadSpan.RecordError(err)
+			exit(1)
+		}
+		downloadSpan.SetAttribute("root", root)
+		return true
+	}
 
+	downloadObject := getDownloadObject(arguments, s3FileKey)
+	err := download.DownloadFile(svc, downloadObject)
+	if err != nil {
+		log.Error.Printf("Failed to download file. Aborting. Reason: %v\n", err)
+		downloadSpan.RecordError(err)
+		exit(1)
+	}
+	if arguments.VerifyOnly {
+		return true
+	}
+	if info, err := os.Stat(arguments.PathToFile); err == nil && info.Mode().IsRegular() {
+		downloadSpan.SetAttribute("bytes", info.Size())
+	}
+	return true
+}
+
+// Downloads every key under the bucket dir beginning with the s3 file name into the directory of the path to file.
+// Every key is downloaded even if some keys fail, in which case the keys which failed are logged and the run exits with 1
+// or with --partialexitcode if some keys were downloaded
+func runDownloadPrefix(svc *s3.S3, arguments args) bool {
+	prefix := arguments.BucketDir + arguments.S3FileName
+
+	downloadSpan := runTracer.Start("download", runSpan)
+	defer downloadSpan.End()
+	downloadSpan.SetAttribute("bucket", arguments.Bucket)
+	downloadSpan.SetAttribute("prefix", prefix)
+
+	downloaded, err := download.DownloadPrefix(svc, getDownloadObject(arguments, ""), arguments.S3FileName)
+	log.Info.Printf("Downloaded %d keys under '%s' to '%s'\n", len(downloaded), prefix, arguments.PathToFile)
+	downloadSpan.SetAttribute("keys", len(downloaded))
+	if err != nil {
+		log.Error.Printf("Failed to download keys. Reason: %v\n", err)
+		downloadSpan.RecordError(err)
+		prefixErr, ok := err.(*download.PrefixDownloadError)
+		if !ok {
+			exit(1)
+		}
+		checkPartialSuccess(arguments, "keys", len(downloaded), len(prefixErr.Failed))
+	}
+	return len(downloaded) > 0
+}
+
+func getDownloadObject(arguments args, s3FileKey string) download.DownloadObject {
 	downloadObject := download.DownloadObject{
 		DownloadLocation: arguments.PathToFile,
-		S3FileKey:        arguments.S3FileName,
+		S3FileKey:        s3FileKey,
 		BucketDir:        arguments.BucketDir,
 		Endpoint:         arguments.Endpoint,
 		Bucket:           arguments.Bucket,
-		NumWorkers:       arguments.ConcurrentWorkers,
+		NumWorkers:       getConcurrentWorkers(arguments),
 		PartSize:         arguments.PartSize,
+		BufferSize:       arguments.BufferSize,
+
+		PreserveMetadata: arguments.PreserveMetadata,
+		VerifyOnly:       arguments.VerifyOnly,
+		CheckInodes:      arguments.CheckInodes,
+		Resume:           arguments.Resume,
+
+		Restore: download.RestoreObject{
+			Tier: arguments.RestoreTier,
+			Days: arguments.RestoreDays,
+			Wait: time.Second * time.Duration(arguments.RestoreWait),
+		},
 	}
-	err := download.DownloadFile(svc, downloadObject)
-	if err != nil {
-		log.Error.Printf("Failed to download file. Aborting. Reason: %v\n", err)
-		os.Exit(1)
+	// Encrypted objects are decrypted whenever key material is available, objects which are not encrypted do not need any
+	if arguments.KeyFile != "" || os.Getenv(encrypt.PassphraseEnv) != "" {
+		downloadObject.EncryptionKey = getEncryptionKey(arguments)
 	}
+	if arguments.RestoreLock {
+		if arguments.RestoreLockTTL <= 0 {
+			log.Error.Printf("Invalid restore lock ttl specified. It must be greater than 0: %d\n", arguments.RestoreLockTTL)
+			exit(1)
+		}
+		downloadObject.RestoreLockTTL = time.Second * time.Duration(arguments.RestoreLockTTL)
+	}
+	return downloadObject
+}
 
+// Uploads the path to file as a single file or as an archive of the directory if an archive format has been specified.
+// Without an archive format every file of a directory is uploaded individually under the key of the directory.
+// The file is uploaded in chunks if a chunk size has been specified. Returns the key and whether the path was uploaded,
+// which is false if the upload was skipped as the file matched the most recent backup. The key is empty if the upload
+// of a symlink was skipped as --followpathsymlink is disabled
+func uploadPath(svc *s3.S3, arguments args, uploadObject upload.UploadObject, prefix string) (string, bool, error) {
+	if uploadObject.PathToFile == upload.StdinPath && (arguments.Archive != "" || arguments.ChunkSize > 0 || arguments.SplitSize > 0) {
+		return "", false, errors.New("stdin cannot be uploaded as an archive, in chunks or in volumes")
+	}
+	if skip, err := upload.SkipSymlink(uploadObject); err != nil || skip {
+		return "", false, err
+	}
+
+	var key string
+	var err error
+	switch arguments.Archive {
+	case "":
+		if info, statErr := os.Stat(uploadObject.PathToFile); statErr == nil && info.IsDir() {
+			return uploadDir(svc, uploadObject, arguments.DryRun)
+		}
+		if arguments.SplitSize > 0 {
+			key, err = upload.UploadSplit(svc, uploadObject, prefix, arguments.DryRun)
+			break
+		}
+		if arguments.ChunkSize > 0 {
+			key, err = upload.UploadChunked(svc, uploadObject, prefix, arguments.DryRun)
+			break
+		}
+		result, err := upload.UploadFileWithResult(svc, uploadObject, prefix, arguments.DryRun)
+		if err != nil {
+			return "", false, err
+		}
+		return result.Key, result.Status != upload.ResultStatusSkipped, nil
+	case upload.ArchiveFormatZip:
+		key, err = upload.UploadZip(svc, uploadObject, prefix, arguments.DryRun)
+	default:
+		err = errors.New("unsupported archive format specified: " + arguments.Archive)
+	}
+	return key, err == nil, err
+}
+
+// Uploads every file of the directory and returns the key of the directory and whether any file was uploaded
+func uploadDir(svc *s3.S3, uploadObject upload.UploadObject, dryRun bool) (string, bool, error) {
+	results, err := upload.UploadDir(svc, uploadObject, dryRun)
+
+	uploaded, skipped := 0, 0
+	for _, result := range results {
+		if result.Status == upload.ResultStatusSkipped {
+			skipped++
+		} else {
+			uploaded++
+		}
+	}
+	log.Info.Printf("Uploaded %d files and skipped %d files of directory '%s'\n", uploaded, skipped, uploadObject.PathToFile)
+
+	return uploadObject.BucketDir + uploadObject.S3FileName + "/", uploaded > 0, err
 }
 
 func getUploadObject(arguments args, manipulate bool) upload.UploadObject {
-	return upload.UploadObject{
+	var maxFileBytes int64
+	if arguments.MaxFileSize != "" {
+		var err error
+		maxFileBytes, err = util.ParseByteSize(arguments.MaxFileSize)
+		if err != nil {
+			log.Error.Printf("Invalid max file size specified. Reason: %v\n", err)
+			exit(1)
+		}
+	}
+
+	var maxBytesPerSec int64
+	if arguments.MaxUploadRate != "" {
+		var err error
+		maxBytesPerSec, err = util.ParseByteSize(arguments.MaxUploadRate)
+		if err != nil {
+			log.Error.Printf("Invalid max upload rate specified. Reason: %v\n", err)
+			exit(1)
+		}
+	}
+
+	resumeStateFile := arguments.ResumeStateFile
+	if resumeStateFile == "" {
+		resumeStateFile = arguments.PathToFile + ".resume.json"
+	}
+
+	tags, err := util.ParseTags(arguments.Tags)
+	if err != nil {
+		log.Error.Printf("Invalid tags specified. Reason: %v\n", err)
+		exit(1)
+	}
+	tagFlags, err := util.ParseTagPairs(arguments.Tag)
+	if err != nil {
+		log.Error.Printf("Invalid tag specified. Reason: %v\n", err)
+		exit(1)
+	}
+	for tagKey, tagValue := range tagFlags {
+		tags[tagKey] = tagValue
+	}
+
+	uploadObject := upload.UploadObject{
 		PathToFile: arguments.PathToFile,
 		S3FileName: arguments.S3FileName,
 		BucketDir:  arguments.BucketDir,
 		Endpoint:   arguments.Endpoint,
 		Bucket:     arguments.Bucket,
 		Timeout:    time.Second * time.Duration(arguments.Timeout),
-		NumWorkers: arguments.ConcurrentWorkers,
+		MaxRetries: arguments.MaxRetries,
+		NumWorkers: getConcurrentWorkers(arguments),
 		PartSize:   arguments.PartSize,
+		BufferSize: arguments.BufferSize,
 		Manipulate: manipulate,
+		TimeSource: arguments.TimeSource,
+
+		KeyTimeLayout: arguments.KeyTimeLayout,
+		Location:      getLocation(arguments),
+		DateRounding:  arguments.DateRounding,
+
+		HashTimeout:   time.Second * time.Duration(arguments.HashTimeout),
+		VerifyTimeout: time.Second * time.Duration(arguments.VerifyTimeout),
+
+		UploadPartOrdered: arguments.UploadPartOrdered,
+		MaxBytesPerSec:    maxBytesPerSec,
+
+		OnTimeout:       arguments.OnTimeout,
+		ResumeStateFile: resumeStateFile,
+		Resume:          arguments.Resume,
+
+		IncludeDotfiles: arguments.IncludeDotfiles,
+		FollowSymlinks:  arguments.FollowSymlinks,
+		SkipSymlink:     !arguments.FollowPathSymlink,
+		Ledger:          arguments.Ledger,
+		DeadLetter:      arguments.DeadLetter,
+		RetryDeadLetter: arguments.RetryDeadLetter,
+		FileRetries:     arguments.FileRetries,
+		DirManifest:     arguments.DirManifest,
+
+		MaxDestinationConcurrency: arguments.DestinationConcurrency,
+
+		ResultsFile:       arguments.ResultsFile,
+		SkipIfUnchanged:   arguments.SkipIfUnchanged,
+		SkipIfExists:      arguments.SkipIfExists,
+		MaxFileBytes:      maxFileBytes,
+		Force:             arguments.Force,
+		StrongVerify:      arguments.StrongVerify,
+		VerifyChecksum:    arguments.VerifyChecksum,
+		QuickVerify:       arguments.QuickVerify,
+		ChecksumAlgorithm: arguments.ChecksumAlgorithm,
+		ChunkSize:         arguments.ChunkSize,
+		SplitSize:         arguments.SplitSize,
+
+		Compression:      arguments.Compression,
+		CompressionLevel: arguments.CompressionLevel,
+
+		ObjectLockLegalHoldStatus: arguments.LegalHold,
+		Tags:                      tags,
+		ACL:                       arguments.ACL,
+		StorageClass:              arguments.StorageClass,
+		StorageClassMismatch:      arguments.StorageClassMismatch,
+		FinalizeAttributes:        arguments.FinalizeAttributes,
+
+		ServerSideEncryption: arguments.SSE,
+		KMSKeyID:             arguments.KMSKeyID,
+		AllowSSEFallback:     arguments.AllowSSEFallback,
+
+		WebsiteRedirectLocation: arguments.WebsiteRedirect,
+		ContentType:             arguments.ContentType,
+	}
+
+	if arguments.Encrypt {
+		uploadObject.EncryptionKey = getEncryptionKey(arguments)
+	}
+
+	progressFns := []func(int64, int64){}
+	if runStatus != nil {
+		progressFns = append(progressFns, runStatus.SetProgress)
+	}
+	if !arguments.Quiet {
+		progressFns = append(progressFns, upload.NewProgressLogger(progressLogInterval))
 	}
+	if len(progressFns) > 0 {
+		uploadObject.ProgressFn = func(bytesTransferred int64, totalBytes int64) {
+			for _, progressFn := range progressFns {
+				progressFn(bytesTransferred, totalBytes)
+			}
+		}
+	}
+
+	return uploadObject
+}
+
+// Returns the key material of the key file or of the passphrase environment variable. Exits if neither can be loaded
+func getEncryptionKey(arguments args) []byte {
+	material, err := encrypt.LoadPassphrase(arguments.KeyFile)
+	if err == nil {
+		err = encrypt.ValidateMaterial(material)
+	}
+	if err != nil {
+		log.Error.Printf("Failed to load the encryption key. Reason: %v\n", err)
+		exit(1)
+	}
+	return material
+}
+
+func getConcurrentWorkers(arguments args) int {
+	workers, err := util.ResolveConcurrency(arguments.ConcurrentWorkers)
+	if err != nil {
+		log.Error.Printf("Invalid concurrent workers specified. Reason: %v\n", err)
+		exit(1)
+	}
+
+	log.Info.Printf("Using %d concurrent workers (--concurrentworkers=%s)\n", workers, arguments.ConcurrentWorkers)
+	return workers
+}
+
+// Returns the time zone of --timezone, or nil to use the local time zone if none has been specified
+func getLocation(arguments args) *time.Location {
+	if arguments.Timezone == "" {
+		return nil
+	}
+
+	location, err := time.LoadLocation(arguments.Timezone)
+	if err != nil {
+		log.Error.Printf("Invalid time zone specified. Expected an IANA time zone e.g. Australia/Sydney. Reason: %v\n", err)
+		exit(1)
+	}
+	return location
 }
 
 func getRotationPolicy(arguments args) rpolicy.RotationPolicy {
@@ -174,8 +1690,80 @@ func getRotationPolicy(arguments args) rpolicy.RotationPolicy {
 			"This may result in objects being deleted that which have not exceeded the retention period")
 	}
 
+	if arguments.DailyRetentionCount < 0 || arguments.WeeklyRetentionCount < 0 || arguments.MonthlyRetentionCount < 0 || arguments.YearlyRetentionCount < 0 {
+		log.Error.Printf("Invalid retention count specified. Retention counts must not be negative: daily %d weekly %d monthly %d yearly %d\n",
+			arguments.DailyRetentionCount, arguments.WeeklyRetentionCount, arguments.MonthlyRetentionCount, arguments.YearlyRetentionCount)
+		exit(1)
+	}
+
+	if arguments.MinExpectedObjects < 0 {
+		log.Error.Printf("Invalid min expected objects specified. It must not be negative: %d\n", arguments.MinExpectedObjects)
+		exit(1)
+	}
+
+	if arguments.MinKeep < 0 {
+		log.Error.Printf("Invalid min keep specified. It must not be negative: %d\n", arguments.MinKeep)
+		exit(1)
+	}
+
+	if arguments.RotateTimeout < 0 {
+		log.Error.Printf("Invalid rotate timeout specified. It must not be negative: %d\n", arguments.RotateTimeout)
+		exit(1)
+	}
+
+	if arguments.DeleteConfirmAttempts < 0 || arguments.DeleteConfirmInterval < 0 {
+		log.Error.Printf("Invalid delete confirmation specified. The attempts and interval must not be negative: attempts %d interval %d\n",
+			arguments.DeleteConfirmAttempts, arguments.DeleteConfirmInterval)
+		exit(1)
+	}
+
+	if arguments.TagConcurrency < 0 {
+		log.Error.Printf("Invalid tag concurrency specified. It must not be negative: %d\n", arguments.TagConcurrency)
+		exit(1)
+	}
+
+	if arguments.RestoreLockWait < 0 {
+		log.Error.Printf("Invalid restore lock wait specified. It must not be negative: %d\n", arguments.RestoreLockWait)
+		exit(1)
+	}
+
+	tagFilter, err := util.ParseTags(arguments.TagFilter)
+	if err != nil {
+		log.Error.Printf("Invalid tag filter specified. Reason: %v\n", err)
+		exit(1)
+	}
+
+	switch strings.ToLower(arguments.UnparseableKeys) {
+	case "", rotate.UnparseableKeysIgnore, rotate.UnparseableKeysLastModified:
+	default:
+		log.Error.Printf("Invalid unparseable keys specified. Expected either '%s' or '%s' but got: '%s'\n",
+			rotate.UnparseableKeysIgnore, rotate.UnparseableKeysLastModified, arguments.UnparseableKeys)
+		exit(1)
+	}
+
+	if _, err := util.NewKeyTimeParser(arguments.KeyTimeLayout, nil); err != nil {
+		log.Error.Printf("Invalid key time layout specified. Reason: %v\n", err)
+		exit(1)
+	}
+
+	auditKey := arguments.RotationAuditKey
+	if auditKey == "" && arguments.CompressRotationAudit {
+		auditKey = arguments.BucketDir + "rotation_audit.ndjson.gz"
+	} else if auditKey == "" {
+		auditKey = arguments.BucketDir + "rotation_audit.json"
+	}
+
+	var auditMaxBytes int64
+	if arguments.RotationAuditMaxSize != "" {
+		auditMaxBytes, err = util.ParseByteSize(arguments.RotationAuditMaxSize)
+		if err != nil {
+			log.Error.Printf("Invalid rotation audit max size specified. Reason: %v\n", err)
+			exit(1)
+		}
+	}
+
 	//  Standard GFS rotation policy
-	return rpolicy.RotationPolicy{
+	policy := rpolicy.RotationPolicy{
 		DailyRetentionPeriod: time.Hour * time.Duration(arguments.DailyRetentionPeriod),
 		DailyRetentionCount:  arguments.DailyRetentionCount,
 		DailyPrefix:          "daily_",
@@ -184,32 +1772,210 @@ func getRotationPolicy(arguments args) rpolicy.RotationPolicy {
 		WeeklyRetentionCount:  arguments.WeeklyRetentionCount,
 		WeeklyPrefix:          "weekly_",
 
+		MonthlyRetentionPeriod: time.Hour * time.Duration(arguments.MonthlyRetentionPeriod),
+		MonthlyRetentionCount:  arguments.MonthlyRetentionCount,
 		MonthlyPrefix:          "monthly_",
+
+		YearlyRetentionPeriod: time.Hour * time.Duration(arguments.YearlyRetentionPeriod),
+		YearlyRetentionCount:  arguments.YearlyRetentionCount,
+		YearlyPrefix:          "yearly_",
+
 		EnforceRetentionPeriod: arguments.EnforceRetentionPeriod,
+
+		TagFilter:       tagFilter,
+		UnparseableKeys: arguments.UnparseableKeys,
+		KeyTimeLayout:   arguments.KeyTimeLayout,
+
+		Location: getLocation(arguments),
+
+		TagFetchConcurrency: arguments.TagConcurrency,
+		TagCacheFile:        arguments.TagCacheFile,
+		TagCacheTTL:         time.Second * time.Duration(arguments.TagCacheTTL),
+
+		MinExpectedObjects: arguments.MinExpectedObjects,
+		MinKeep:            arguments.MinKeep,
+
+		DeleteConfirmAttempts: arguments.DeleteConfirmAttempts,
+		DeleteConfirmInterval: time.Second * time.Duration(arguments.DeleteConfirmInterval),
+
+		RestoreLockWait:         time.Second * time.Duration(arguments.RestoreLockWait),
+		RestoreLockPollInterval: time.Second * 5,
+
+		Timeout: time.Second * time.Duration(arguments.RotateTimeout),
+
+		WriteRotationAudit: arguments.WriteRotationAudit,
+		AuditKey:           auditKey,
+		CompressAudit:      arguments.CompressRotationAudit,
+		AuditMaxBytes:      auditMaxBytes,
+
+		IndexKey:       getBackupIndexKey(arguments),
+		LastSuccessKey: getLastSuccessKey(arguments),
+	}
+
+	// The marker would be rotated or counted as a backup if it were listed with the keys of a tier
+	for _, prefix := range policy.Prefixes() {
+		if policy.LastSuccessKey != "" && strings.HasPrefix(policy.LastSuccessKey, arguments.BucketDir+prefix) {
+			log.Error.Printf("Invalid last success key specified. It must not be in the '%s' rotation tier: '%s'\n", prefix, policy.LastSuccessKey)
+			exit(1)
+		}
+	}
+
+	if arguments.ForceTier != "" {
+		policy.ForceTier, err = util.ResolveTier(policy, arguments.ForceTier)
+		if err != nil {
+			log.Error.Printf("Invalid tier specified. Reason: %v\n", err)
+			exit(1)
+		}
+		log.Warn.Printf("Forcing the rotation tier of the backup to '%s' regardless of its date\n", policy.ForceTier)
 	}
 
+	return policy
 }
 
 func logArgs(arguments args) {
 	log.Info.Println("Loaded s3backup with arguments: ")
 
 	log.Info.Println("--credfile=" + arguments.CredFile)
+	log.Info.Println("--useinstancerole=" + strconv.FormatBool(arguments.UseInstanceRole))
+	log.Info.Println("--assumerolearn=" + arguments.AssumeRoleARN)
+	log.Info.Println("--rolesessionname=" + arguments.RoleSessionName)
+	log.Info.Println("--credentialexpiry=" + arguments.CredentialExpiry)
 	log.Info.Println("--region=" + arguments.Region)
 	log.Info.Println("--bucket=" + arguments.Bucket)
 	log.Info.Println("--bucketdir=" + arguments.BucketDir)
 	log.Info.Println("--endpoint=" + arguments.Endpoint)
+	log.Info.Println("--partition=" + arguments.Partition)
+	log.Info.Println("--serviceendpoints=" + arguments.ServiceEndpoints)
+	log.Info.Println("--accelerate=" + strconv.FormatBool(arguments.Accelerate))
+	log.Info.Println("--destinations=" + arguments.Destinations)
+	log.Info.Println("--destinationretries=" + strconv.Itoa(arguments.DestinationRetries))
+	log.Info.Println("--destinationconcurrency=" + strconv.Itoa(arguments.DestinationConcurrency))
 	log.Info.Println("--profile=" + arguments.Profile)
 	log.Info.Println("--action=" + arguments.Action)
 	log.Info.Println("--pathtofile=" + arguments.PathToFile)
+	log.Info.Println("--archive=" + arguments.Archive)
+	log.Info.Println("--includedotfiles=" + strconv.FormatBool(arguments.IncludeDotfiles))
+	log.Info.Println("--followsymlinks=" + strconv.FormatBool(arguments.FollowSymlinks))
+	log.Info.Println("--followpathsymlink=" + strconv.FormatBool(arguments.FollowPathSymlink))
+	log.Info.Println("--ledger=" + arguments.Ledger)
+	log.Info.Println("--deadletter=" + arguments.DeadLetter)
+	log.Info.Println("--retrydeadletter=" + strconv.FormatBool(arguments.RetryDeadLetter))
+	log.Info.Println("--dirmanifest=" + strconv.FormatBool(arguments.DirManifest))
+	log.Info.Println("--expectedroot=" + arguments.ExpectedRoot)
 	log.Info.Println("--s3filename=" + arguments.S3FileName)
 	log.Info.Println("--dryrun=" + strconv.FormatBool(arguments.DryRun))
+	log.Info.Println("--interactive=" + strconv.FormatBool(arguments.Interactive))
+	log.Info.Println("--yes=" + strconv.FormatBool(arguments.Yes))
+	log.Info.Println("--timesource=" + arguments.TimeSource)
+	log.Info.Println("--keytimelayout=" + arguments.KeyTimeLayout)
+	log.Info.Println("--timezone=" + arguments.Timezone)
+	log.Info.Println("--daterounding=" + arguments.DateRounding)
 	log.Info.Println("--timeout=" + strconv.Itoa(arguments.Timeout))
+	log.Info.Println("--hashtimeout=" + strconv.Itoa(arguments.HashTimeout))
+	log.Info.Println("--verifytimeout=" + strconv.Itoa(arguments.VerifyTimeout))
+	log.Info.Println("--rotatetimeout=" + strconv.Itoa(arguments.RotateTimeout))
+	log.Info.Println("--maxretries=" + strconv.Itoa(arguments.MaxRetries))
+	log.Info.Println("--fileretries=" + strconv.Itoa(arguments.FileRetries))
+	log.Info.Println("--ontimeout=" + arguments.OnTimeout)
+	log.Info.Println("--resumestatefile=" + arguments.ResumeStateFile)
+	log.Info.Println("--resume=" + strconv.FormatBool(arguments.Resume))
 	log.Info.Println("--enforceretentionperiod=" + strconv.FormatBool(arguments.EnforceRetentionPeriod))
-	log.Info.Println("--concurrentworkers=" + strconv.Itoa(arguments.ConcurrentWorkers))
+	log.Info.Println("--concurrentworkers=" + arguments.ConcurrentWorkers)
 	log.Info.Println("--partsize=" + strconv.Itoa(arguments.PartSize))
+	log.Info.Println("--buffersize=" + strconv.Itoa(arguments.BufferSize))
+	log.Info.Println("--uploadpartordered=" + strconv.FormatBool(arguments.UploadPartOrdered))
+	log.Info.Println("--profiletransfer=" + arguments.ProfileTransfer)
+	log.Info.Println("--maxuploadrate=" + arguments.MaxUploadRate)
+	log.Info.Println("--checkperms=" + strconv.FormatBool(arguments.CheckPerms))
+	log.Info.Println("--validate=" + strconv.FormatBool(arguments.Validate))
+	log.Info.Println("--resultsfile=" + arguments.ResultsFile)
+	log.Info.Println("--statusfile=" + arguments.StatusFile)
+	log.Info.Println("--pidfile=" + arguments.PidFile)
+	log.Info.Println("--quiet=" + strconv.FormatBool(arguments.Quiet))
+	log.Info.Println("--otlpendpoint=" + arguments.OtlpEndpoint)
+	log.Info.Println("--maxfilesize=" + arguments.MaxFileSize)
+	log.Info.Println("--force=" + strconv.FormatBool(arguments.Force))
+	log.Info.Println("--strongverify=" + strconv.FormatBool(arguments.StrongVerify))
+	log.Info.Println("--verifychecksum=" + strconv.FormatBool(arguments.VerifyChecksum))
+	log.Info.Println("--quickverify=" + strconv.FormatBool(arguments.QuickVerify))
+	log.Info.Println("--checksumalgorithm=" + arguments.ChecksumAlgorithm)
+	log.Info.Println("--noopexitcode=" + strconv.Itoa(arguments.NoopExitCode))
+	log.Info.Println("--partialexitcode=" + strconv.Itoa(arguments.PartialExitCode))
+	log.Info.Println("--delimiter=" + arguments.Delimiter)
+	log.Info.Println("--bytier=" + strconv.FormatBool(arguments.ByTier))
+	log.Info.Println("--json=" + strconv.FormatBool(arguments.JSON))
+	log.Info.Println("--cleanstrays=" + strconv.FormatBool(arguments.CleanStrays))
+	log.Info.Println("--compacttier=" + arguments.CompactTier)
+	log.Info.Println("--compactolderthan=" + strconv.Itoa(arguments.CompactOlderThan))
+	log.Info.Println("--chunksize=" + strconv.Itoa(arguments.ChunkSize))
+	log.Info.Println("--splitsize=" + strconv.Itoa(arguments.SplitSize))
+	log.Info.Println("--legalhold=" + arguments.LegalHold)
+	log.Info.Println("--tags=" + arguments.Tags)
+	log.Info.Println("--tag=" + strings.Join(arguments.Tag, " --tag="))
+	log.Info.Println("--acl=" + arguments.ACL)
+	log.Info.Println("--storageclass=" + arguments.StorageClass)
+	log.Info.Println("--dailystorageclass=" + arguments.DailyStorageClass)
+	log.Info.Println("--weeklystorageclass=" + arguments.WeeklyStorageClass)
+	log.Info.Println("--monthlystorageclass=" + arguments.MonthlyStorageClass)
+	log.Info.Println("--yearlystorageclass=" + arguments.YearlyStorageClass)
+	log.Info.Println("--storageclassmismatch=" + arguments.StorageClassMismatch)
+	log.Info.Println("--sse=" + arguments.SSE)
+	log.Info.Println("--kmskeyid=" + arguments.KMSKeyID)
+	log.Info.Println("--allowssefallback=" + strconv.FormatBool(arguments.AllowSSEFallback))
+	log.Info.Println("--finalizeattributes=" + strconv.FormatBool(arguments.FinalizeAttributes))
+	log.Info.Println("--websiteredirect=" + arguments.WebsiteRedirect)
+	log.Info.Println("--contenttype=" + arguments.ContentType)
+	log.Info.Println("--compression=" + arguments.Compression)
+	log.Info.Println("--compressionlevel=" + strconv.Itoa(arguments.CompressionLevel))
+	log.Info.Println("--encrypt=" + strconv.FormatBool(arguments.Encrypt))
+	log.Info.Println("--keyfile=" + arguments.KeyFile)
+	log.Info.Println("--skipifunchanged=" + strconv.FormatBool(arguments.SkipIfUnchanged))
+	log.Info.Println("--skipifexists=" + strconv.FormatBool(arguments.SkipIfExists))
+	log.Info.Println("--postuploaddelay=" + strconv.Itoa(arguments.PostUploadDelay))
+	log.Info.Println("--durabilitytimeout=" + strconv.Itoa(arguments.DurabilityTimeout))
+	log.Info.Println("--requirereplication=" + strconv.FormatBool(arguments.RequireReplication))
 	log.Info.Println("--dailyretentioncount=" + strconv.Itoa(arguments.DailyRetentionCount))
 	log.Info.Println("--dailyretentionperiod=" + strconv.Itoa(arguments.DailyRetentionPeriod))
 	log.Info.Println("--weeklyretentioncount=" + strconv.Itoa(arguments.WeeklyRetentionCount))
 	log.Info.Println("--weeklyretentionperiod=" + strconv.Itoa(arguments.WeeklyRetentionPeriod))
+	log.Info.Println("--monthlyretentioncount=" + strconv.Itoa(arguments.MonthlyRetentionCount))
+	log.Info.Println("--monthlyretentionperiod=" + strconv.Itoa(arguments.MonthlyRetentionPeriod))
+	log.Info.Println("--yearlyretentioncount=" + strconv.Itoa(arguments.YearlyRetentionCount))
+	log.Info.Println("--yearlyretentionperiod=" + strconv.Itoa(arguments.YearlyRetentionPeriod))
+	log.Info.Println("--minexpectedobjects=" + strconv.Itoa(arguments.MinExpectedObjects))
+	log.Info.Println("--minkeep=" + strconv.Itoa(arguments.MinKeep))
+	log.Info.Println("--deleteconfirmattempts=" + strconv.Itoa(arguments.DeleteConfirmAttempts))
+	log.Info.Println("--deleteconfirminterval=" + strconv.Itoa(arguments.DeleteConfirmInterval))
+	log.Info.Println("--restorelockwait=" + strconv.Itoa(arguments.RestoreLockWait))
+	log.Info.Println("--forcetier=" + arguments.ForceTier)
+	log.Info.Println("--tagfilter=" + arguments.TagFilter)
+	log.Info.Println("--tagconcurrency=" + strconv.Itoa(arguments.TagConcurrency))
+	log.Info.Println("--tagcachefile=" + arguments.TagCacheFile)
+	log.Info.Println("--tagcachettl=" + strconv.Itoa(arguments.TagCacheTTL))
+	log.Info.Println("--unparseablekeys=" + arguments.UnparseableKeys)
+	log.Info.Println("--writerotationaudit=" + strconv.FormatBool(arguments.WriteRotationAudit))
+	log.Info.Println("--rotationauditkey=" + arguments.RotationAuditKey)
+	log.Info.Println("--compressrotationaudit=" + strconv.FormatBool(arguments.CompressRotationAudit))
+	log.Info.Println("--rotationauditmaxsize=" + arguments.RotationAuditMaxSize)
+	log.Info.Println("--backupindex=" + strconv.FormatBool(arguments.BackupIndex))
+	log.Info.Println("--backupindexkey=" + arguments.BackupIndexKey)
+	log.Info.Println("--writelastsuccess=" + strconv.FormatBool(arguments.WriteLastSuccess))
+	log.Info.Println("--lastsuccesskey=" + arguments.LastSuccessKey)
+	log.Info.Println("--simulateruns=" + strconv.Itoa(arguments.SimulateRuns))
+	log.Info.Println("--simulatecadence=" + strconv.Itoa(arguments.SimulateCadence))
+	log.Info.Println("--migratesourcedir=" + arguments.MigrateSourceDir)
+	log.Info.Println("--migratesourcename=" + arguments.MigrateSourceName)
+	log.Info.Println("--downloadremoteonly=" + strconv.FormatBool(arguments.DownloadRemoteOnly))
+	log.Info.Println("--latest=" + strconv.FormatBool(arguments.Latest))
+	log.Info.Println("--minage=" + strconv.Itoa(arguments.MinAge))
+	log.Info.Println("--downloadprefix=" + strconv.FormatBool(arguments.DownloadPrefix))
+	log.Info.Println("--preservemetadata=" + strconv.FormatBool(arguments.PreserveMetadata))
+	log.Info.Println("--verifyonly=" + strconv.FormatBool(arguments.VerifyOnly))
+	log.Info.Println("--checkinodes=" + strconv.FormatBool(arguments.CheckInodes))
+	log.Info.Println("--restorelock=" + strconv.FormatBool(arguments.RestoreLock))
+	log.Info.Println("--restorelockttl=" + strconv.Itoa(arguments.RestoreLockTTL))
+	log.Info.Println("--restoretier=" + arguments.RestoreTier)
+	log.Info.Println("--restoredays=" + strconv.Itoa(arguments.RestoreDays))
+	log.Info.Println("--restorewait=" + strconv.Itoa(arguments.RestoreWait))
 
 }
@@ -0,0 +1,1070 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"s3backup/compact"
+	"s3backup/rotate"
+	"s3backup/s3client"
+	"s3backup/s3mock"
+	"s3backup/tracing"
+	"s3backup/util"
+	"s3backup/version"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//----------------------------------------------
+// Version Testing
+//	1: --version prints the injected build information and exits zero
+//
+//----------------------------------------------
+
+// Test 1 - Version Testing
+//	--version prints the injected build information and exits zero
+func TestVersionFlag(t *testing.T) {
+	if os.Getenv("S3BACKUP_TEST_VERSION") == "1" {
+		// Emulates the values injected with ldflags
+		version.Version = "1.2.3"
+		version.Commit = "abc1234"
+		version.BuildDate = "2019-03-01T10:30:00Z"
+		os.Args = []string{"s3backup", "--version"}
+		main()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestVersionFlag$")
+	cmd.Env = append(os.Environ(), "S3BACKUP_TEST_VERSION=1")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected --version to exit zero: %v\n%s", err, output))
+	}
+
+	for _, expected := range []string{"1.2.3", "abc1234", "2019-03-01T10:30:00Z"} {
+		if !strings.Contains(string(output), expected) {
+			t.Error(fmt.Sprintf("expected --version output to contain '%s' but got: %s", expected, output))
+		}
+	}
+
+	if strings.Contains(string(output), "s3backup started") {
+		t.Error("expected s3backup to exit without running an action")
+	}
+}
+
+//----------------------------------------------
+// ETag Testing
+//	1: --action=etag logs the multipart ETag S3 assigns to a fixed input with the part size
+//
+//----------------------------------------------
+
+// Test 1 - ETag Testing
+//	--action=etag logs the multipart ETag S3 assigns to a fixed input with the part size
+func TestETagAction(t *testing.T) {
+	if pathToFile := os.Getenv("S3BACKUP_TEST_ETAG"); pathToFile != "" {
+		os.Args = []string{"s3backup", "--action=etag", "--region=us-east-1", "--bucket=mockbucket", "--pathtofile=" + pathToFile, "--partsize=5"}
+		main()
+		return
+	}
+
+	// 12MiB is 3 parts with a part size of 5MiB
+	pathToFile := filepath.Join(t.TempDir(), "etagTestFile")
+	if err := ioutil.WriteFile(pathToFile, []byte(strings.Repeat("0123456789abcdef", 12*1024*1024/16)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestETagAction$")
+	cmd.Env = append(os.Environ(), "S3BACKUP_TEST_ETAG="+pathToFile)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected --action=etag to exit zero: %v\n%s", err, output))
+	}
+
+	// The ETag S3 reports for the input uploaded in 5MiB parts
+	expected := fmt.Sprintf("ETag of '%s' with a part size of 5242880 bytes: 9a2ef9cb8ad224f4af0a22d36f6044d1-3", pathToFile)
+	if !strings.Contains(string(output), expected) {
+		t.Error(fmt.Sprintf("expected the output to contain '%s' but got: %s", expected, output))
+	}
+	if !strings.Contains(string(output), "Part 3: 3d1fac9a0e90cdd71f19e717128c8bc6") {
+		t.Error(fmt.Sprintf("expected the md5sum of each part to be logged: %s", output))
+	}
+}
+
+//----------------------------------------------
+// Verify Testing (mock S3)
+//	1: --action=verify reports a match and exits non-zero when the local file no longer matches the object
+//	2: --action=verify compares the file with the object under --bucketdir rather than a stray object at the root
+//
+//----------------------------------------------
+
+// Test 1 - Verify Testing
+//	Verify a file against an object with the same contents and against an object with different contents
+func TestVerifyAction(t *testing.T) {
+	if pathToFile := os.Getenv("S3BACKUP_TEST_VERIFY"); pathToFile != "" {
+		mockS3 := s3mock.New("mockbucket")
+		defer mockS3.Close()
+		mockS3.PutObject("mockbucket", "verifyTestFile", []byte("this is not the local file"), time.Now())
+
+		runVerifyAction(mockS3.Client(), args{Bucket: "mockbucket", S3FileName: "verifyTestFile", PathToFile: pathToFile})
+		return
+	}
+
+	pathToFile := filepath.Join(t.TempDir(), "verifyTestFile")
+	if err := ioutil.WriteFile(pathToFile, []byte("this is just a little test file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+	mockS3.PutObject("mockbucket", "verifyTestFile", []byte("this is just a little test file"), time.Now())
+
+	if !runVerifyAction(mockS3.Client(), args{Bucket: "mockbucket", S3FileName: "verifyTestFile", PathToFile: pathToFile}) {
+		t.Error("expected the file to match the object")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestVerifyAction$")
+	cmd.Env = append(os.Environ(), "S3BACKUP_TEST_VERIFY="+pathToFile)
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Fatal(fmt.Sprintf("expected --action=verify to exit 1 on a mismatch: %v\n%s", err, output))
+	}
+	if !strings.Contains(string(output), "MISMATCH") {
+		t.Error(fmt.Sprintf("expected the mismatch to be logged: %s", output))
+	}
+}
+
+// Test 2 - Verify Testing
+//	Verify a file against the object under the bucket dir while a stray object with the same name at the root differs
+func TestVerifyActionBucketDir(t *testing.T) {
+	if pathToFile := os.Getenv("S3BACKUP_TEST_VERIFY_BUCKETDIR"); pathToFile != "" {
+		mockS3 := s3mock.New("mockbucket")
+		defer mockS3.Close()
+		mockS3.PutObject("mockbucket", "verifyTestFile", []byte("this is just a little test file"), time.Now())
+		mockS3.PutObject("mockbucket", "backups/verifyTestFile", []byte("this is not the local file"), time.Now())
+
+		runVerifyAction(mockS3.Client(), args{Bucket: "mockbucket", BucketDir: "backups/", S3FileName: "verifyTestFile", PathToFile: pathToFile})
+		return
+	}
+
+	pathToFile := filepath.Join(t.TempDir(), "verifyTestFile")
+	if err := ioutil.WriteFile(pathToFile, []byte("this is just a little test file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+	mockS3.PutObject("mockbucket", "verifyTestFile", []byte("this is not the local file"), time.Now())
+	mockS3.PutObject("mockbucket", "backups/verifyTestFile", []byte("this is just a little test file"), time.Now())
+
+	if !runVerifyAction(mockS3.Client(), args{Bucket: "mockbucket", BucketDir: "backups/", S3FileName: "verifyTestFile", PathToFile: pathToFile}) {
+		t.Error("expected the file to match the object under the bucket dir")
+	}
+
+	// The object at the root matches the file but the object under the bucket dir does not
+	cmd := exec.Command(os.Args[0], "-test.run=^TestVerifyActionBucketDir$")
+	cmd.Env = append(os.Environ(), "S3BACKUP_TEST_VERIFY_BUCKETDIR="+pathToFile)
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Fatal(fmt.Sprintf("expected --action=verify to exit 1 on a mismatch under the bucket dir: %v\n%s", err, output))
+	}
+	if !strings.Contains(string(output), "key: 'backups/verifyTestFile'") {
+		t.Error(fmt.Sprintf("expected the key under the bucket dir to be logged: %s", output))
+	}
+}
+
+//----------------------------------------------
+// List Testing (mock S3)
+//	1: --action=list with --bytier and --json writes the backups of each tier under the bucket dir as JSON
+//
+//----------------------------------------------
+
+// Test 1 - List Testing
+//	List a daily, a monthly and a yearly backup under the bucket dir and a backup outside it by tier as JSON
+func TestListActionByTierJSON(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	now := time.Now()
+	mockS3.PutObject("mockbucket", "backups/daily_album_20240102T020000", []byte("daily"), now)
+	mockS3.PutObject("mockbucket", "backups/monthly_album_20240101T020000", []byte("monthly"), now)
+	mockS3.PutObject("mockbucket", "backups/yearly_album_20230101T020000", []byte("yearly"), now)
+	mockS3.PutObject("mockbucket", "other/daily_album_20240102T020000", []byte("other"), now)
+
+	var output bytes.Buffer
+	listOutput = &output
+	defer func() { listOutput = os.Stdout }()
+
+	runListAction(mockS3.Client(), args{Bucket: "mockbucket", BucketDir: "backups/", ByTier: true, JSON: true})
+
+	var listing struct {
+		BucketDir string
+		Tiers     []rotate.TierListing
+	}
+	if err := json.Unmarshal(output.Bytes(), &listing); err != nil {
+		t.Fatal(fmt.Sprintf("expected the listing to be a single JSON document: %v\n%s", err, output.String()))
+	}
+
+	listed := []string{}
+	for _, tier := range listing.Tiers {
+		for _, backup := range tier.Backups {
+			listed = append(listed, fmt.Sprintf("%s:%s:%d", tier.Tier, backup.Key, backup.Size))
+		}
+	}
+	expected := "[daily:backups/daily_album_20240102T020000:5 monthly:backups/monthly_album_20240101T020000:7 yearly:backups/yearly_album_20230101T020000:6]"
+	if listing.BucketDir != "backups/" || len(listing.Tiers) != 4 || fmt.Sprint(listed) != expected {
+		t.Error(fmt.Sprintf("expected the backups %s of 4 tiers under backups/ but got: %s", expected, output.String()))
+	}
+}
+
+//----------------------------------------------
+// Rotation Report Testing (mock S3)
+//	1: --action=rotate with --dryrun and --json writes the rotation report as JSON and deletes nothing
+//
+//----------------------------------------------
+
+// Test 1 - Rotation Report Testing
+//	Report the dry run rotation of 10 expired daily backups as JSON
+func TestRotationReportJSON(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+	putExpiredDailyBackups(mockS3, 10)
+
+	var output bytes.Buffer
+	listOutput = &output
+	defer func() { listOutput = os.Stdout }()
+
+	arguments := noopTestArgs(t)
+	arguments.Action = "rotate"
+	arguments.DryRun = true
+	arguments.JSON = true
+	runRotateAction(mockS3.Client(), arguments)
+
+	var report rotate.RotationReport
+	if err := json.Unmarshal(output.Bytes(), &report); err != nil {
+		t.Fatal(fmt.Sprintf("expected the rotation report to be a single JSON document: %v\n%s", err, output.String()))
+	}
+	if !report.DryRun || report.Deleted != 4 || report.Kept != 6 || len(report.Tiers) == 0 || report.Tiers[0].Deleted != 4 {
+		t.Error(fmt.Sprintf("expected the report to delete 4 daily keys and keep 6 but got: %s", output.String()))
+	}
+	if len(mockS3.Keys("mockbucket")) != 10 || len(mockS3.Requests("DeleteObject")) != 0 {
+		t.Error(fmt.Sprintf("expected no key to be deleted by the dry run but found: %v", mockS3.Keys("mockbucket")))
+	}
+}
+
+//----------------------------------------------
+// Compact Testing (mock S3)
+//	1: --action=compact bundles the old backups of --compacttier under the bucket dir and leaves the other tiers
+//
+//----------------------------------------------
+
+// Test 1 - Compact Testing
+//	Compact two weekly backups older than --compactolderthan alongside an old daily backup
+func TestCompactAction(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	old := time.Now().Add(-48 * time.Hour)
+	mockS3.PutObject("mockbucket", "backups/weekly_album_20240101T020000", []byte("first weekly"), old)
+	mockS3.PutObject("mockbucket", "backups/weekly_album_20240108T020000", []byte("second weekly"), old.Add(time.Hour))
+	mockS3.PutObject("mockbucket", "backups/daily_album_20240102T020000", []byte("daily"), old)
+
+	arguments := args{Bucket: "mockbucket", BucketDir: "backups/", CompactTier: "weekly", CompactOlderThan: 24, ConcurrentWorkers: "5"}
+	if !runCompactAction(mockS3.Client(), arguments) {
+		t.Fatal("expected the old weekly backups to be compacted")
+	}
+
+	keys := mockS3.Keys("mockbucket")
+	if len(keys) != 2 || !strings.HasPrefix(keys[0], "backups/"+compact.CompactDir+"weekly_") || keys[1] != "backups/daily_album_20240102T020000" {
+		t.Error(fmt.Sprintf("expected the weekly backups to be replaced by a single archive and the daily backup to be kept but got %v", keys))
+	}
+}
+
+//----------------------------------------------
+// Noop Testing (mock S3)
+//	1: A backup which skips the upload and rotates nothing reports that no work was performed
+//	2: A rotation which deletes nothing reports that no work was performed
+//	3: A backup of a symlink which is not followed uploads and rotates nothing
+//
+//----------------------------------------------
+
+// Test 1 - Noop Testing
+//	A backup which skips the upload and rotates nothing reports that no work was performed
+func TestBackupNoop(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	arguments := noopTestArgs(t)
+
+	if !runBackupAction(mockS3.Client(), arguments) {
+		t.Error("expected the first backup to report that work was performed")
+	}
+
+	if runBackupAction(mockS3.Client(), arguments) {
+		t.Error("expected a backup of an unchanged file with nothing to rotate to report noop")
+	}
+
+	if len(mockS3.Keys("mockbucket")) != 1 {
+		t.Error(fmt.Sprintf("expected the unchanged file not to be uploaded again: %v", mockS3.Keys("mockbucket")))
+	}
+
+	ioutil.WriteFile(arguments.PathToFile, []byte("this file has changed"), 0644)
+	if !runBackupAction(mockS3.Client(), arguments) {
+		t.Error("expected a backup of a changed file to report that work was performed")
+	}
+}
+
+// Test 2 - Noop Testing
+//	A rotation which deletes nothing reports that no work was performed
+func TestRotateNoop(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	if runRotateAction(mockS3.Client(), noopTestArgs(t)) {
+		t.Error("expected a rotation which deletes nothing to report noop")
+	}
+}
+
+// Test 3 - Noop Testing
+//	Back up a symlink to the test file with --followpathsymlink disabled alongside 10 expired daily backups
+func TestBackupSymlinkNotFollowed(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+	putExpiredDailyBackups(mockS3, 10)
+
+	arguments := noopTestArgs(t)
+	link := arguments.PathToFile + ".link"
+	if err := os.Symlink(arguments.PathToFile, link); err != nil {
+		t.Fatal(err)
+	}
+	arguments.PathToFile = link
+	arguments.FollowPathSymlink = false
+
+	if runBackupAction(mockS3.Client(), arguments) {
+		t.Error("expected the backup of a symlink which is not followed to report noop")
+	}
+	if len(mockS3.Keys("mockbucket")) != 10 || len(mockS3.Requests("DeleteObject")) != 0 {
+		t.Error(fmt.Sprintf("expected nothing to be uploaded or rotated but found: %v", mockS3.Keys("mockbucket")))
+	}
+}
+
+//----------------------------------------------
+// Partial Success Testing (mock S3)
+//	1: A backup which fails on one of several destinations rotates the others and exits with the partial exit code
+//	2: A run without a partial success exits with 0 or the noop exit code
+//
+//----------------------------------------------
+
+// Test 1 - Partial Success Testing
+//	Back up to the bucket and a destination which does not exist with a partial exit code of 5
+func TestBackupPartialSuccess(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+	defer func() { partialSuccess = false }()
+
+	arguments := noopTestArgs(t)
+	arguments.Destinations = "missingbucket"
+	arguments.PartialExitCode = 5
+
+	if !runBackupAction(mockS3.Client(), arguments) {
+		t.Error("expected the backup to report that work was performed")
+	}
+	if !partialSuccess {
+		t.Error("expected the backup to report a partial success")
+	}
+	if code := runExitCode(arguments, true); code != 5 {
+		t.Error(fmt.Sprintf("expected the partial exit code 5 but got %d", code))
+	}
+	if keys := mockS3.Keys("mockbucket"); len(keys) != 1 || !strings.HasPrefix(keys[0], "daily_noopTestFile") {
+		t.Error(fmt.Sprintf("expected the bucket to receive the backup but got %v", keys))
+	}
+}
+
+// Test 2 - Partial Success Testing
+//	The exit code of a run which performed work and of one which did not without a partial success
+func TestRunExitCode(t *testing.T) {
+	arguments := args{NoopExitCode: 3, PartialExitCode: 5}
+
+	if code := runExitCode(arguments, true); code != 0 {
+		t.Error(fmt.Sprintf("expected exit code 0 for a run which performed work but got %d", code))
+	}
+	if code := runExitCode(arguments, false); code != 3 {
+		t.Error(fmt.Sprintf("expected the noop exit code 3 but got %d", code))
+	}
+}
+
+// Returns the arguments of a backup of a small test file with the default retention policy
+func noopTestArgs(t *testing.T) args {
+	pathToFile := filepath.Join(t.TempDir(), "noopTestFile")
+	if err := ioutil.WriteFile(pathToFile, []byte("this is just a little test file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return args{
+		Action:                 "backup",
+		Bucket:                 "mockbucket",
+		S3FileName:             "noopTestFile",
+		PathToFile:             pathToFile,
+		SkipIfUnchanged:        true,
+		TimeSource:             "now",
+		Timeout:                60,
+		EnforceRetentionPeriod: true,
+		ConcurrentWorkers:      "1",
+		PartSize:               50,
+		DailyRetentionCount:    6,
+		DailyRetentionPeriod:   168,
+		WeeklyRetentionCount:   4,
+		WeeklyRetentionPeriod:  672,
+		DurabilityTimeout:      5,
+	}
+}
+
+//----------------------------------------------
+// Tracing Testing (mock S3)
+//	1: A backup produces a span for the run with a child span for its upload, verify and rotate phases
+//	2: No spans are produced when no exporter is configured
+//
+//----------------------------------------------
+
+// Test 1 - Tracing Testing
+//	A backup produces a span for the run with a child span for its upload, verify and rotate phases
+func TestTracingSpanTree(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	exporter := &tracing.InMemoryExporter{}
+	runTracer = tracing.NewTracer(exporter)
+	runSpan = runTracer.Start("run", nil)
+	defer func() { runTracer, runSpan = nil, nil }()
+
+	runBackupAction(mockS3.Client(), noopTestArgs(t))
+	stopTracing()
+
+	spans := map[string]tracing.SpanData{}
+	for _, span := range exporter.Spans() {
+		spans[span.Name] = span
+	}
+
+	root, ok := spans["run"]
+	if !ok || root.ParentSpanID != "" || len(spans) != 4 {
+		t.Fatal(fmt.Sprintf("expected a root span for the run and a span for each phase: %v", exporter.Spans()))
+	}
+	for _, name := range []string{"upload", "verify", "rotate"} {
+		span, ok := spans[name]
+		if !ok || span.ParentSpanID != root.SpanID || span.TraceID != root.TraceID {
+			t.Error(fmt.Sprintf("expected a '%s' span which is a child of the run span: %v", name, exporter.Spans()))
+		}
+		if span.Attributes["bucket"] != "mockbucket" || span.Error != "" {
+			t.Error(fmt.Sprintf("expected the '%s' span to succeed for the bucket: %v", name, span))
+		}
+	}
+
+	upload := spans["upload"]
+	if upload.Attributes["bytes"] != int64(len("this is just a little test file")) ||
+		upload.Attributes["key"] != mockS3.Keys("mockbucket")[0] || spans["verify"].Attributes["key"] != upload.Attributes["key"] {
+		t.Error(fmt.Sprintf("expected the key and size of the upload to be recorded: %v", upload.Attributes))
+	}
+}
+
+// Test 2 - Tracing Testing
+//	No spans are produced when no exporter is configured
+func TestTracingNoop(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	startTracing(noopTestArgs(t))
+	if runTracer != nil || runSpan != nil {
+		t.Fatal("expected no tracer without an OTLP endpoint")
+	}
+
+	if !runBackupAction(mockS3.Client(), noopTestArgs(t)) {
+		t.Error("expected the backup to succeed without tracing")
+	}
+	stopTracing()
+}
+
+//----------------------------------------------
+// Force Tier Testing (mock S3)
+//	1: A backup with a forced tier is uploaded with the prefix of the tier regardless of its date
+//
+//----------------------------------------------
+
+// Test 1 - Force Tier Testing
+//	A backup with a forced tier is uploaded with the prefix of the tier regardless of its date
+func TestForceTier(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	for _, tier := range []string{"monthly", "weekly", "daily"} {
+		arguments := noopTestArgs(t)
+		arguments.SkipIfUnchanged = false
+		arguments.ForceTier = tier
+		runBackupAction(mockS3.Client(), arguments)
+	}
+
+	keys := mockS3.Keys("mockbucket")
+	for _, prefix := range []string{"monthly_", "weekly_", "daily_"} {
+		found := 0
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix+"noopTestFile_") {
+				found++
+			}
+		}
+		if found != 1 {
+			t.Error(fmt.Sprintf("expected a single backup with the forced tier prefix '%s': %v", prefix, keys))
+		}
+	}
+}
+
+//----------------------------------------------
+// Storage Class Testing (mock S3)
+//	1: A backup is uploaded in the storage class of its tier or otherwise in the storage class of every upload
+//
+//----------------------------------------------
+
+// Test 1 - Storage Class Testing
+//	Force a backup of each tier with only the monthly tier mapped to its own storage class
+func TestStorageClassPerTier(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	for _, tier := range []string{"monthly", "weekly", "daily"} {
+		arguments := noopTestArgs(t)
+		arguments.SkipIfUnchanged = false
+		arguments.ForceTier = tier
+		arguments.StorageClass = "STANDARD_IA"
+		arguments.MonthlyStorageClass = "GLACIER"
+		runBackupAction(mockS3.Client(), arguments)
+	}
+
+	expected := map[string]string{"monthly_": "GLACIER", "weekly_": "STANDARD_IA", "daily_": "STANDARD_IA"}
+	for _, key := range mockS3.Keys("mockbucket") {
+		prefix := key[:strings.Index(key, "_")+1]
+		if storageClass := mockS3.Object("mockbucket", key).Header.Get("X-Amz-Storage-Class"); storageClass != expected[prefix] {
+			t.Error(fmt.Sprintf("expected key '%s' to be stored in '%s' but got: '%s'", key, expected[prefix], storageClass))
+		}
+		delete(expected, prefix)
+	}
+	if len(expected) != 0 {
+		t.Error(fmt.Sprintf("expected a backup of every tier but found: %v", mockS3.Keys("mockbucket")))
+	}
+}
+
+//----------------------------------------------
+// Validation Testing (mock S3)
+//	1: A valid configuration passes without running the action
+//	2: A bucket in another region fails the validation
+//
+//----------------------------------------------
+
+// Test 1 - Validation Testing
+//	Validate the configuration of a backup against a bucket in the expected region
+func TestValidationPassed(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	arguments := noopTestArgs(t)
+	arguments.Region = "us-east-1"
+	arguments.Validate = true
+
+	if !runValidation(mockS3.Client(), arguments) {
+		t.Error("expected the validation to pass")
+	}
+	if len(mockS3.Keys("mockbucket")) != 0 {
+		t.Error(fmt.Sprintf("expected the backup not to be uploaded but found: %v", mockS3.Keys("mockbucket")))
+	}
+}
+
+// Test 2 - Validation Testing
+//	Validate the configuration of a backup against a bucket in another region
+func TestValidationRegionMismatch(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	mockS3.SetBucketRegion("mockbucket", "ap-southeast-2")
+
+	arguments := noopTestArgs(t)
+	arguments.Region = "us-east-1"
+	arguments.Validate = true
+
+	if runValidation(mockS3.Client(), arguments) {
+		t.Error("expected the validation to fail as the bucket is in another region")
+	}
+}
+
+//----------------------------------------------
+// Backup Index Testing (mock S3)
+//	1: Each backup is added to the backup index with its tier and size
+//	2: A dry run does not write the backup index
+//
+//----------------------------------------------
+
+// Test 1 - Backup Index Testing
+//	Force a monthly and then a daily backup with the backup index enabled
+func TestBackupIndexUpdated(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	for _, tier := range []string{"monthly", "daily"} {
+		arguments := noopTestArgs(t)
+		arguments.SkipIfUnchanged = false
+		arguments.BucketDir = "backups/"
+		arguments.ForceTier = tier
+		arguments.BackupIndex = true
+		runBackupAction(mockS3.Client(), arguments)
+	}
+
+	index, found, err := s3client.GetBackupIndex(mockS3.Client(), "mockbucket", "backups/index.json")
+	if err != nil || !found {
+		t.Fatal(fmt.Sprintf("expected the backup index to be written to 'backups/index.json' but got: found %t %v", found, err))
+	}
+	if len(index.Backups) != 2 {
+		t.Fatal(fmt.Sprintf("expected 2 backups in the index but got: %v", index.Backups))
+	}
+
+	for i, tier := range []string{"daily", "monthly"} { // The most recent backup is listed first
+		backup := index.Backups[i]
+		if backup.Tier != tier || !strings.HasPrefix(backup.Key, "backups/"+tier+"_noopTestFile") {
+			t.Error(fmt.Sprintf("expected backup %d of the index to be the %s backup but got: %+v", i, tier, backup))
+		}
+		if backup.Size != int64(len("this is just a little test file")) || backup.Timestamp.IsZero() {
+			t.Error(fmt.Sprintf("expected the size and timestamp of key '%s' to be recorded but got: %+v", backup.Key, backup))
+		}
+		if mockS3.Object("mockbucket", backup.Key) == nil {
+			t.Error(fmt.Sprintf("expected key '%s' of the index to be stored", backup.Key))
+		}
+	}
+}
+
+// Test 2 - Backup Index Testing
+//	Run a backup with the backup index and dry run enabled
+func TestBackupIndexDryRun(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	arguments := noopTestArgs(t)
+	arguments.BackupIndex = true
+	arguments.BackupIndexKey = "catalog/backups.json"
+	arguments.DryRun = true
+	runBackupAction(mockS3.Client(), arguments)
+
+	if mockS3.Object("mockbucket", "catalog/backups.json") != nil {
+		t.Error("expected the backup index not to be written during a dry run")
+	}
+}
+
+//----------------------------------------------
+// Last Success Testing (mock S3)
+//	1: Each successful backup writes the current time to the last success marker
+//	2: The marker is preserved by rotation and is not a stray
+//	3: A dry run does not write the marker
+//
+//----------------------------------------------
+
+// Test 1 - Last Success Testing
+//	Run two backups with the last success marker enabled
+func TestLastSuccessWritten(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	arguments := noopTestArgs(t)
+	arguments.BucketDir = "backups/"
+	arguments.WriteLastSuccess = true
+
+	var previous time.Time
+	for run := 1; run <= 2; run++ {
+		before := time.Now().Add(-time.Second)
+		runBackupAction(mockS3.Client(), arguments)
+
+		successTime, found, err := s3client.GetLastSuccess(mockS3.Client(), "mockbucket", "backups/.last-success")
+		if err != nil || !found {
+			t.Fatal(fmt.Sprintf("expected the last success marker to be written to 'backups/.last-success' but got: found %t %v", found, err))
+		}
+		if successTime.Before(before) || successTime.After(time.Now()) || successTime.Location() != time.UTC {
+			t.Error(fmt.Sprintf("expected run %d to write the current time in UTC to the marker but got: %s", run, successTime))
+		}
+		if run == 2 && !successTime.After(previous) {
+			t.Error("expected the second backup to update the marker although its upload was skipped as unchanged")
+		}
+		previous = successTime
+
+		// The timestamp of the marker has a resolution of a second
+		time.Sleep(time.Second)
+	}
+}
+
+// Test 2 - Last Success Testing
+//	Rotate 10 daily backups past their retention with a marker stored beside them and clean the strays
+func TestLastSuccessPreserved(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	oldTime := time.Now().AddDate(0, 0, -30)
+	for i := 0; i < 10; i++ {
+		keyTime := oldTime.AddDate(0, 0, i)
+		mockS3.PutObject("mockbucket", "backups/daily_noopTestFile_"+keyTime.Format("20060102T150405"), []byte("old"), keyTime)
+	}
+
+	arguments := noopTestArgs(t)
+	arguments.BucketDir = "backups/"
+	arguments.ForceTier = "daily"
+	arguments.WriteLastSuccess = true
+	arguments.LastSuccessKey = "backups/monitor/last-success.txt"
+	mockS3.PutObject("mockbucket", arguments.LastSuccessKey, []byte("2024-01-31T02:00:05Z\n"), oldTime)
+	runBackupAction(mockS3.Client(), arguments)
+
+	if len(mockS3.Keys("mockbucket")) != 1+6 {
+		t.Fatal(fmt.Sprintf("expected rotation to keep the marker and 6 daily backups but found: %v", mockS3.Keys("mockbucket")))
+	}
+
+	arguments.Action = "strays"
+	arguments.CleanStrays = true
+	arguments.WriteLastSuccess = false // The marker is never a stray even if it is not enabled
+	runStraysAction(mockS3.Client(), arguments)
+
+	successTime, found, err := s3client.GetLastSuccess(mockS3.Client(), "mockbucket", arguments.LastSuccessKey)
+	if err != nil || !found || time.Since(successTime) > time.Minute {
+		t.Error(fmt.Sprintf("expected the marker to be updated by the backup and preserved but got: %s found %t %v", successTime, found, err))
+	}
+}
+
+// Test 3 - Last Success Testing
+//	Run a backup with the last success marker and dry run enabled
+func TestLastSuccessDryRun(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	arguments := noopTestArgs(t)
+	arguments.WriteLastSuccess = true
+	arguments.DryRun = true
+	runBackupAction(mockS3.Client(), arguments)
+
+	if mockS3.Object("mockbucket", ".last-success") != nil {
+		t.Error("expected the last success marker not to be written during a dry run")
+	}
+}
+
+//----------------------------------------------
+// Interactive Rotation Testing (mock S3)
+//	1: The planned keys are printed and deleted once the deletion is confirmed with y
+//	2: Anything other than y declines the deletion and nothing is deleted
+//	3: A run without a terminal confirms the deletion with --yes without prompting
+//	4: A run without a terminal and without --yes is refused
+//
+//----------------------------------------------
+
+// Test 1 - Interactive Rotation Testing
+//	Answer y to the prompt of an interactive rotation of 10 expired daily backups
+func TestInteractiveRotationConfirmed(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+	putExpiredDailyBackups(mockS3, 10)
+
+	output := simulatePrompt(t, true, "y\n")
+	arguments := interactiveRotateArgs(t)
+	if !runRotateAction(mockS3.Client(), arguments) {
+		t.Error("expected the confirmed rotation to delete keys")
+	}
+
+	if len(mockS3.Keys("mockbucket")) != 6 {
+		t.Error(fmt.Sprintf("expected the 4 planned keys to be deleted but found: %v", mockS3.Keys("mockbucket")))
+	}
+	if !strings.Contains(output.String(), "will delete 4 key(s)") || !strings.Contains(output.String(), "[y/N]") {
+		t.Error(fmt.Sprintf("expected the plan and the prompt to be printed but got: %s", output.String()))
+	}
+}
+
+// Test 2 - Interactive Rotation Testing
+//	Answer n, yes and nothing to the prompt of an interactive rotation of 10 expired daily backups
+func TestInteractiveRotationDeclined(t *testing.T) {
+	for _, answer := range []string{"n\n", "yes\n", "\n", ""} {
+		mockS3 := s3mock.New("mockbucket")
+		putExpiredDailyBackups(mockS3, 10)
+
+		simulatePrompt(t, true, answer)
+		if runRotateAction(mockS3.Client(), interactiveRotateArgs(t)) {
+			t.Error(fmt.Sprintf("expected the rotation declined with %q to delete nothing", answer))
+		}
+		if len(mockS3.Keys("mockbucket")) != 10 || len(mockS3.Requests("DeleteObject")) != 0 {
+			t.Error(fmt.Sprintf("expected no key to be deleted when answering %q but found: %v", answer, mockS3.Keys("mockbucket")))
+		}
+		mockS3.Close()
+	}
+}
+
+// Test 3 - Interactive Rotation Testing
+//	Run an interactive rotation with --yes without a terminal
+func TestInteractiveRotationYes(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+	putExpiredDailyBackups(mockS3, 10)
+
+	output := simulatePrompt(t, false, "")
+	arguments := interactiveRotateArgs(t)
+	arguments.Yes = true
+	if !runRotateAction(mockS3.Client(), arguments) || len(mockS3.Keys("mockbucket")) != 6 {
+		t.Error(fmt.Sprintf("expected --yes to confirm the deletion of the 4 planned keys but found: %v", mockS3.Keys("mockbucket")))
+	}
+	if strings.Contains(output.String(), "[y/N]") {
+		t.Error("expected no prompt to be shown with --yes")
+	}
+}
+
+// Test 4 - Interactive Rotation Testing
+//	Confirm the planned keys without a terminal and without --yes
+func TestInteractiveRotationNoTerminal(t *testing.T) {
+	expectedErrString := "specify --yes to confirm it without one"
+
+	simulatePrompt(t, false, "y\n")
+	confirmed, err := confirmRotation(interactiveRotateArgs(t), []string{"daily_noopTestFile_20240101T020000"})
+	if !confirmed && err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+// Strays Testing (mock S3)
+//	1: Only the stray objects under the bucket dir are deleted with clean strays
+//
+//----------------------------------------------
+
+// Test 1 - Strays Testing
+//	Clean the strays beside a backup and a backup index written by an earlier run
+func TestStraysCleaned(t *testing.T) {
+	mockS3 := s3mock.New("mockbucket")
+	defer mockS3.Close()
+
+	arguments := noopTestArgs(t)
+	arguments.BucketDir = "backups/"
+	runBackupAction(mockS3.Client(), arguments)
+
+	mockS3.PutObject("mockbucket", "backups/index.json", []byte("{}"), time.Now())
+	mockS3.PutObject("mockbucket", "backups/manual.tar", []byte("stray"), time.Now())
+	mockS3.PutObject("mockbucket", "manual.tar", []byte("outside"), time.Now())
+	keysBefore := len(mockS3.Keys("mockbucket"))
+
+	arguments.Action = "strays"
+	arguments.CleanStrays = true
+	if !runStraysAction(mockS3.Client(), arguments) {
+		t.Fatal("expected the stray object to be deleted")
+	}
+
+	if mockS3.Object("mockbucket", "backups/manual.tar") != nil || len(mockS3.Keys("mockbucket")) != keysBefore-1 {
+		t.Error(fmt.Sprintf("expected only the stray object to be deleted but found: %v", mockS3.Keys("mockbucket")))
+	}
+}
+
+//----------------------------------------------
+// Environment Testing
+//	1: Setting the env-var of every flag configures the run identically to the equivalent flags
+//	2: Flags take precedence over the environment and required flags are satisfied by their env-var
+//	3: An env-var with an invalid value is rejected
+//
+//----------------------------------------------
+
+// Test 1 - Environment Testing
+//	Setting the env-var of every flag configures the run identically to the equivalent flags
+func TestEnvMatchesFlags(t *testing.T) {
+	env, flags := envAndFlagsOfEveryField()
+
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	envArgs, err := defaultArgs()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the environment to be parsed without any error: %v", err))
+	}
+
+	for key := range env {
+		os.Unsetenv(key)
+	}
+	flagArgs := parseFlags(t, flags)
+
+	if !reflect.DeepEqual(envArgs, flagArgs) {
+		t.Error(fmt.Sprintf("expected the environment to configure the run identically to the flags\nenv:   %+v\nflags: %+v", envArgs, flagArgs))
+	}
+}
+
+// Test 2 - Environment Testing
+//	Flags take precedence over the environment and required flags are satisfied by their env-var
+func TestFlagsOverrideEnv(t *testing.T) {
+	t.Setenv("S3BACKUP_REGION", "us-east-1")
+	t.Setenv("S3BACKUP_BUCKET", "mybucket")
+	t.Setenv("S3BACKUP_ACTION", "rotate")
+	t.Setenv("S3BACKUP_DAILYRETENTIONCOUNT", "10")
+	t.Setenv("AWS_PROFILE", "awsprofile")
+	t.Setenv("S3BACKUP_PROFILE", "s3backupprofile")
+
+	parsedArgs := parseFlags(t, []string{"--action=upload"})
+
+	if parsedArgs.Action != "upload" || parsedArgs.DailyRetentionCount != 10 {
+		t.Error(fmt.Sprintf("expected the flag to override the environment: %+v", parsedArgs))
+	}
+
+	if parsedArgs.Region != "us-east-1" || parsedArgs.Bucket != "mybucket" {
+		t.Error(fmt.Sprintf("expected the required flags to be set from the environment: %+v", parsedArgs))
+	}
+
+	if parsedArgs.Profile != "s3backupprofile" {
+		t.Error("expected S3BACKUP_PROFILE to take precedence over AWS_PROFILE: " + parsedArgs.Profile)
+	}
+}
+
+// Test 3 - Environment Testing
+//	An env-var with an invalid value is rejected
+func TestEnvInvalidValue(t *testing.T) {
+	expectedErrString := "invalid value for S3BACKUP_DRYRUN"
+
+	t.Setenv("S3BACKUP_DRYRUN", "sometimes")
+
+	_, err := defaultArgs()
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+// Transfer Profile Testing
+//	1: A transfer profile replaces the default part size, concurrent workers and buffer size
+//	2: Transfer settings specified with their flag or env-var take precedence over the profile
+//	3: An unknown transfer profile is rejected
+//
+//----------------------------------------------
+
+// Test 1 - Transfer Profile Testing
+//	A transfer profile replaces the default part size, concurrent workers and buffer size
+func TestTransferProfile(t *testing.T) {
+	for name, profile := range util.TransferProfiles {
+		flags := []string{"--region=us-east-1", "--bucket=mybucket", "--profiletransfer=" + name}
+		parsedArgs := parseFlags(t, flags)
+
+		if err := applyTransferProfile(&parsedArgs, flags); err != nil {
+			t.Fatal(fmt.Sprintf("expected transfer profile '%s' to be applied without any error: %v", name, err))
+		}
+		if parsedArgs.PartSize != profile.PartSize || parsedArgs.ConcurrentWorkers != profile.ConcurrentWorkers || parsedArgs.BufferSize != profile.BufferSize {
+			t.Error(fmt.Sprintf("expected the settings of transfer profile '%s' %+v but got --partsize=%d --concurrentworkers=%s --buffersize=%d",
+				name, profile, parsedArgs.PartSize, parsedArgs.ConcurrentWorkers, parsedArgs.BufferSize))
+		}
+	}
+
+	withoutProfile := parseFlags(t, []string{"--region=us-east-1", "--bucket=mybucket"})
+	if err := applyTransferProfile(&withoutProfile, nil); err != nil || withoutProfile.PartSize != 50 || withoutProfile.ConcurrentWorkers != "5" || withoutProfile.BufferSize != 0 {
+		t.Error(fmt.Sprintf("expected the default transfer settings without a profile: %v %+v", err, withoutProfile))
+	}
+}
+
+// Test 2 - Transfer Profile Testing
+//	Transfer settings specified with their flag or env-var take precedence over the profile
+func TestTransferProfileOverrides(t *testing.T) {
+	t.Setenv("S3BACKUP_BUFFERSIZE", "64")
+
+	// A flag matching the default still takes precedence over the profile
+	flags := []string{"--region=us-east-1", "--bucket=mybucket", "--profiletransfer=lan", "--partsize=50", "--concurrentworkers", "3"}
+	parsedArgs := parseFlags(t, flags)
+
+	if err := applyTransferProfile(&parsedArgs, flags); err != nil {
+		t.Fatal(err)
+	}
+	if parsedArgs.PartSize != 50 || parsedArgs.ConcurrentWorkers != "3" || parsedArgs.BufferSize != 64 {
+		t.Error(fmt.Sprintf("expected the explicit settings to take precedence over the profile but got --partsize=%d --concurrentworkers=%s --buffersize=%d",
+			parsedArgs.PartSize, parsedArgs.ConcurrentWorkers, parsedArgs.BufferSize))
+	}
+}
+
+// Test 3 - Transfer Profile Testing
+//	Apply an unknown transfer profile
+func TestTransferProfileInvalid(t *testing.T) {
+	expectedErrString := "invalid transfer profile 'satellite', expected one of: highlatency, lan, lowmem, wan"
+
+	flags := []string{"--region=us-east-1", "--bucket=mybucket", "--profiletransfer=satellite"}
+	parsedArgs := parseFlags(t, flags)
+
+	err := applyTransferProfile(&parsedArgs, flags)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns an env-var and the equivalent flag for every field of the args with a value differing from its default
+func envAndFlagsOfEveryField() (map[string]string, []string) {
+	env := map[string]string{}
+	flags := []string{}
+
+	argsType := reflect.TypeOf(args{})
+	for i := 0; i < argsType.NumField(); i++ {
+		name := argsType.Field(i).Name
+
+		var value string
+		switch argsType.Field(i).Type.Kind() {
+		case reflect.Bool:
+			value = strconv.FormatBool(name != "EnforceRetentionPeriod") // Enabled by default
+		case reflect.Int:
+			value = strconv.Itoa(i + 1000)
+		default:
+			value = "value-of-" + strings.ToLower(name)
+		}
+
+		env[EnvPrefix+strings.ToUpper(name)] = value
+		flags = append(flags, "--"+strings.ToLower(name)+"="+value)
+	}
+	return env, flags
+}
+
+func parseFlags(t *testing.T, flags []string) args {
+	parsedArgs, err := defaultArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parser, err := arg.NewParser(arg.Config{}, &parsedArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = parser.Parse(flags); err != nil {
+		t.Fatal(fmt.Sprintf("expected the flags to be parsed without any error: %v", err))
+	}
+	return parsedArgs
+}
+
+// Stores daily backups which are older than the daily retention period
+func putExpiredDailyBackups(mockS3 *s3mock.Server, count int) {
+	oldTime := time.Now().AddDate(0, 0, -30)
+	for i := 0; i < count; i++ {
+		keyTime := oldTime.AddDate(0, 0, i)
+		mockS3.PutObject("mockbucket", "daily_noopTestFile_"+keyTime.Format("20060102T150405"), []byte("old"), keyTime)
+	}
+}
+
+// Returns the arguments of an interactive rotation with the default retention policy
+func interactiveRotateArgs(t *testing.T) args {
+	arguments := noopTestArgs(t)
+	arguments.Action = "rotate"
+	arguments.Interactive = true
+	return arguments
+}
+
+// Answers the prompt of --interactive with the input as if it were typed at a terminal if terminal is true. Returns the
+// output the plan and prompt are written to. The prompt is restored once the test has finished
+func simulatePrompt(t *testing.T, terminal bool, input string) *bytes.Buffer {
+	input0, output0, isTerminal0 := promptInput, promptOutput, promptIsTerminal
+	t.Cleanup(func() { promptInput, promptOutput, promptIsTerminal = input0, output0, isTerminal0 })
+
+	output := &bytes.Buffer{}
+	promptInput = strings.NewReader(input)
+	promptOutput = output
+	promptIsTerminal = func() bool { return terminal }
+	return output
+}
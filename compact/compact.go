@@ -0,0 +1,490 @@
+package compact
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/log"
+	"s3backup/s3client"
+	"s3backup/upload"
+	"s3backup/version"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CompactDir is the directory under the bucket dir that compacted archives are stored in. It begins with '.' like the
+// directories of chunks and volumes so that the archives are never rotated or reported as strays
+const CompactDir = ".compacted/"
+
+// IndexName is the name of the entry of a compacted archive which indexes the backups bundled into it
+const IndexName = "index.json"
+
+// IndexVersion is the version of the index format written by CompactBackups
+const IndexVersion = 1
+
+// The time layout of the oldest and newest last modified times in the key of a compacted archive
+const archiveTimeLayout = "20060102T150405"
+
+// ArchiveIndex lists the backups bundled into a compacted archive in the order they were bundled, oldest first
+type ArchiveIndex struct {
+	Version int               `json:"version"`
+	Prefix  string            `json:"prefix"`
+	Backups []CompactedBackup `json:"backups"`
+}
+
+// CompactedBackup is a single backup bundled into a compacted archive
+type CompactedBackup struct {
+	Key          string    `json:"key"`  // The key of the backup before it was compacted
+	Name         string    `json:"name"` // The name of the entry of the backup in the archive, i.e. its key relative to the bucket dir
+	Size         int64     `json:"size"`
+	MD5          string    `json:"md5"` // Hex encoded md5sum of the object
+	LastModified time.Time `json:"lastModified"`
+}
+
+// CompactedArchive records the archive the backups were bundled into and the original keys which were deleted
+type CompactedArchive struct {
+	Key         string
+	Size        int64
+	Backups     []CompactedBackup
+	DeletedKeys []string
+}
+
+// CompactBackups bundles every backup of the prefix under the bucket dir which was last modified longer ago than the
+// older than duration of the compact object into a single zip archive stored under <bucketdir>.compacted/. The archive
+// contains each backup as it is stored, named by its key relative to the bucket dir, along with an index of the
+// backups and their md5sums. Once uploaded the archive is downloaded again and every backup is verified against the
+// index before the original keys are deleted, so the originals are only deleted once the archive is known to restore
+// them. Backups under a legal hold, split and chunked uploads are never compacted, and nothing is compacted while a
+// restore holds a lock under the bucket dir. Returns a nil archive if there was nothing to compact
+func CompactBackups(svc *s3.S3, compactObject CompactObject, dryRun bool) (*CompactedArchive, error) {
+	if svc == nil {
+		return nil, errors.New("svc must not be nil")
+	}
+
+	if err := validationCheck(compactObject); err != nil {
+		return nil, err
+	}
+	if compactObject.MinBackups == 0 {
+		compactObject.MinBackups = 2
+	}
+
+	log.Info.Println(`
+	######################################
+	#      Backup Compaction Started     #
+	######################################
+	`)
+
+	locks, err := s3client.GetRestoreLocks(svc, compactObject.Bucket, compactObject.BucketDir, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for restore locks: %v", err)
+	}
+	if len(locks) > 0 {
+		log.Warn.Printf("Skipping compaction as %d restore(s) hold a lock under '%s': %s\n", len(locks), compactObject.BucketDir, strings.Join(locks, ", "))
+		return nil, nil
+	}
+
+	candidates, err := findCandidates(svc, compactObject)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) < compactObject.MinBackups {
+		log.Info.Printf("Skipping compaction as only %d backup(s) under '%s' are older than %s, at least %d are required\n",
+			len(candidates), compactObject.BucketDir+compactObject.Prefix, compactObject.OlderThan, compactObject.MinBackups)
+		return nil, nil
+	}
+
+	if dryRun {
+		archive := &CompactedArchive{Key: archiveKey(compactObject, candidates[0].ModifiedTime, candidates[len(candidates)-1].ModifiedTime)}
+		for _, obj := range candidates {
+			log.Info.Printf("Skipping compaction of key: '%s' into '%s' as dry run has been enabled\n", obj.Key, archive.Key)
+			archive.Backups = append(archive.Backups, CompactedBackup{Key: obj.Key, Name: strings.TrimPrefix(obj.Key, compactObject.BucketDir),
+				Size: obj.Size, LastModified: obj.ModifiedTime})
+			archive.DeletedKeys = append(archive.DeletedKeys, obj.Key)
+		}
+		return archive, nil
+	}
+
+	tmpDir, err := ioutil.TempDir("", "s3backup-compact-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathToArchive := filepath.Join(tmpDir, "archive.zip")
+	index, err := writeArchive(svc, compactObject, candidates, pathToArchive)
+	if err != nil {
+		return nil, err
+	}
+	if len(index.Backups) < compactObject.MinBackups {
+		log.Info.Printf("Skipping compaction as only %d backup(s) could be bundled, at least %d are required\n", len(index.Backups), compactObject.MinBackups)
+		return nil, nil
+	}
+
+	oldest, newest := index.Backups[0].LastModified, index.Backups[len(index.Backups)-1].LastModified
+	archive := &CompactedArchive{Key: archiveKey(compactObject, oldest, newest), Backups: index.Backups}
+
+	archive.Size, err = uploadArchive(svc, compactObject, archive.Key, pathToArchive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload compacted archive '%s': %v", archive.Key, err)
+	}
+	log.Info.Printf("Uploaded %d backups compacted into key: '%s' (%d bytes)\n", len(archive.Backups), archive.Key, archive.Size)
+
+	// The originals are only deleted once the archive which was stored restores every one of them
+	if err = verifyUploadedArchive(svc, compactObject, archive.Key, index, tmpDir); err != nil {
+		if _, deleteErr := s3client.DeleteKey(svc, compactObject.Bucket, archive.Key); deleteErr != nil {
+			log.Error.Printf("Failed to remove compacted archive: '%s' which failed verification, it must be removed manually. Reason: %v\n", archive.Key, deleteErr)
+		}
+		return nil, fmt.Errorf("compacted archive '%s' failed verification and the backups were not deleted: %v", archive.Key, err)
+	}
+	log.Info.Printf("Verified every backup of compacted archive: '%s'\n", archive.Key)
+
+	failed := 0
+	archive.DeletedKeys = []string{}
+	for _, backup := range archive.Backups {
+		if _, err := s3client.DeleteKey(svc, compactObject.Bucket, backup.Key); err != nil {
+			log.Error.Printf("Failed to delete compacted key: '%s'. Reason: %v\n", backup.Key, err)
+			failed++
+			continue
+		}
+		log.Info.Printf("Deleted compacted key: '%s'\n", backup.Key)
+		archive.DeletedKeys = append(archive.DeletedKeys, backup.Key)
+	}
+
+	if compactObject.IndexKey != "" && len(archive.DeletedKeys) > 0 {
+		if removed, err := s3client.RemoveFromBackupIndex(svc, compactObject.Bucket, compactObject.IndexKey, archive.DeletedKeys); err != nil {
+			log.Error.Printf("Failed to remove the compacted keys from backup index: '%s': %v\n", compactObject.IndexKey, err)
+		} else {
+			log.Info.Printf("Removed %d compacted keys from backup index: '%s'\n", removed, compactObject.IndexKey)
+		}
+	}
+
+	if failed > 0 {
+		return archive, fmt.Errorf("failed to delete %d of %d keys compacted into '%s'", failed, len(archive.Backups), archive.Key)
+	}
+	return archive, nil
+}
+
+// VerifyArchive downloads the compacted archive under the key and returns its index once every backup listed in the
+// index has been read from the archive and matches the size and md5sum recorded for it
+func VerifyArchive(svc *s3.S3, bucket string, key string) (ArchiveIndex, error) {
+	tmpDir, err := ioutil.TempDir("", "s3backup-compact-")
+	if err != nil {
+		return ArchiveIndex{}, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathToArchive := filepath.Join(tmpDir, "archive.zip")
+	if err = downloadArchive(svc, bucket, key, pathToArchive, 0, 0); err != nil {
+		return ArchiveIndex{}, err
+	}
+	return verifyArchiveFile(pathToArchive)
+}
+
+// Returns the backups of the prefix which are old enough to compact, oldest first. Folder placeholders, backups under
+// a legal hold and the indexes of split and chunked uploads, whose volumes and chunks are stored separately, are skipped
+func findCandidates(svc *s3.S3, compactObject CompactObject) ([]s3client.ListedObject, error) {
+	prefix := compactObject.BucketDir + compactObject.Prefix
+	listing, err := s3client.ListByDelimiter(svc, compactObject.Bucket, prefix, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys under '%s': %v", prefix, err)
+	}
+
+	cutoff := time.Now().Add(-compactObject.OlderThan)
+	candidates := []s3client.ListedObject{}
+	for _, obj := range listing.Objects {
+		if strings.HasSuffix(obj.Key, "/") || !obj.ModifiedTime.Before(cutoff) {
+			continue
+		}
+
+		held, err := s3client.GetLegalHold(svc, compactObject.Bucket, obj.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the legal hold of key '%s': %v", obj.Key, err)
+		}
+		if held {
+			log.Info.Printf("Skipping key: '%s' as it is under a legal hold\n", obj.Key)
+			continue
+		}
+		candidates = append(candidates, obj)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].ModifiedTime.Equal(candidates[j].ModifiedTime) {
+			return candidates[i].Key < candidates[j].Key
+		}
+		return candidates[i].ModifiedTime.Before(candidates[j].ModifiedTime)
+	})
+	return candidates, nil
+}
+
+// Returns the key of the archive of the prefix spanning the oldest and newest last modified times of its backups,
+// i.e. <bucketdir>.compacted/<tier>_<oldest>_<newest>.zip
+func archiveKey(compactObject CompactObject, oldest time.Time, newest time.Time) string {
+	return compactObject.BucketDir + CompactDir + strings.TrimSuffix(compactObject.Prefix, "_") + "_" +
+		oldest.UTC().Format(archiveTimeLayout) + "_" + newest.UTC().Format(archiveTimeLayout) + ".zip"
+}
+
+// Streams every candidate into a zip archive at the path, hashing each object as it is written, followed by the index
+// of the backups which were bundled. An object whose ETag is an md5sum is checked against it so that an object which
+// was corrupted in transit is never bundled
+func writeArchive(svc *s3.S3, compactObject CompactObject, candidates []s3client.ListedObject, pathToArchive string) (ArchiveIndex, error) {
+	index := ArchiveIndex{Version: IndexVersion, Prefix: compactObject.Prefix, Backups: []CompactedBackup{}}
+
+	file, err := os.Create(pathToArchive)
+	if err != nil {
+		return index, err
+	}
+	defer file.Close()
+
+	zipWriter := zip.NewWriter(file)
+	for _, obj := range candidates {
+		backup, err := writeEntry(svc, compactObject, obj, zipWriter)
+		if err != nil {
+			return index, fmt.Errorf("failed to bundle key '%s': %v", obj.Key, err)
+		}
+		if backup != nil {
+			index.Backups = append(index.Backups, *backup)
+		}
+	}
+
+	body, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return index, err
+	}
+	entry, err := zipWriter.CreateHeader(&zip.FileHeader{Name: IndexName, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return index, err
+	}
+	if _, err = entry.Write(body); err != nil {
+		return index, err
+	}
+
+	if err = zipWriter.Close(); err != nil {
+		return index, err
+	}
+	return index, file.Close()
+}
+
+// Writes a single object to the archive. Returns nil if the object is the index of a split or chunked upload
+func writeEntry(svc *s3.S3, compactObject CompactObject, obj s3client.ListedObject, zipWriter *zip.Writer) (*CompactedBackup, error) {
+	resp, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(compactObject.Bucket),
+		Key:    aws.String(obj.Key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	for metadataKey, value := range resp.Metadata {
+		archive := aws.StringValue(value)
+		if http.CanonicalHeaderKey(metadataKey) == upload.ArchiveMetadataKey && (archive == upload.ArchiveFormatVolumes || archive == upload.ArchiveFormatChunks) {
+			log.Info.Printf("Skipping key: '%s' as it is a %s upload whose contents are stored separately\n", obj.Key, archive)
+			return nil, nil
+		}
+	}
+
+	backup := &CompactedBackup{Key: obj.Key, Name: strings.TrimPrefix(obj.Key, compactObject.BucketDir), LastModified: obj.ModifiedTime}
+	log.Info.Printf("Bundling key: '%s' (%d bytes) as '%s'\n", obj.Key, obj.Size, backup.Name)
+
+	entry, err := zipWriter.CreateHeader(&zip.FileHeader{Name: backup.Name, Method: zip.Deflate, Modified: obj.ModifiedTime})
+	if err != nil {
+		return nil, err
+	}
+
+	hash := md5.New()
+	backup.Size, err = io.Copy(io.MultiWriter(entry, hash), resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	backup.MD5 = hex.EncodeToString(hash.Sum(nil))
+
+	if backup.Size != obj.Size {
+		return nil, fmt.Errorf("read %d bytes but the object was listed with %d bytes", backup.Size, obj.Size)
+	}
+	etag := strings.Trim(aws.StringValue(resp.ETag), "\"")
+	encrypted := aws.StringValue(resp.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms || resp.SSECustomerAlgorithm != nil
+	if !encrypted && !strings.Contains(etag, "-") && etag != backup.MD5 {
+		return nil, fmt.Errorf("md5sum '%s' of the object read does not match its ETag '%s'", backup.MD5, etag)
+	}
+	return backup, nil
+}
+
+// Uploads the archive at the path to the key and returns its size
+func uploadArchive(svc *s3.S3, compactObject CompactObject, key string, pathToArchive string) (int64, error) {
+	file, err := os.Open(pathToArchive)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	uploader := s3manager.NewUploaderWithClient(svc, func(u *s3manager.Uploader) {
+		if compactObject.PartSize > 0 {
+			u.PartSize = int64(compactObject.PartSize * 1024 * 1024)
+		}
+		if compactObject.NumWorkers > 0 {
+			u.Concurrency = compactObject.NumWorkers
+		}
+		u.LeavePartsOnError = false
+	})
+
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(compactObject.Bucket),
+		Key:         aws.String(key),
+		Body:        file,
+		ContentType: aws.String("application/zip"),
+		Metadata:    map[string]*string{upload.ArchiveMetadataKey: aws.String(upload.ArchiveFormatZip), upload.VersionMetadataKey: aws.String(version.Version)},
+	})
+	return info.Size(), err
+}
+
+// Downloads the uploaded archive and verifies it restores every backup of the index it was written with
+func verifyUploadedArchive(svc *s3.S3, compactObject CompactObject, key string, index ArchiveIndex, tmpDir string) error {
+	pathToArchive := filepath.Join(tmpDir, "verify.zip")
+	if err := downloadArchive(svc, compactObject.Bucket, key, pathToArchive, compactObject.PartSize, compactObject.NumWorkers); err != nil {
+		return err
+	}
+
+	stored, err := verifyArchiveFile(pathToArchive)
+	if err != nil {
+		return err
+	}
+	if len(stored.Backups) != len(index.Backups) {
+		return fmt.Errorf("the index of the archive lists %d backups rather than the %d which were bundled", len(stored.Backups), len(index.Backups))
+	}
+	for i, backup := range index.Backups {
+		if stored.Backups[i].Key != backup.Key || stored.Backups[i].MD5 != backup.MD5 {
+			return fmt.Errorf("the index of the archive does not match the backup '%s' which was bundled", backup.Key)
+		}
+	}
+	return nil
+}
+
+func downloadArchive(svc *s3.S3, bucket string, key string, pathToArchive string, partSize int, numWorkers int) error {
+	file, err := os.Create(pathToArchive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	downloader := s3manager.NewDownloaderWithClient(svc, func(d *s3manager.Downloader) {
+		if partSize > 0 {
+			d.PartSize = int64(partSize * 1024 * 1024)
+		}
+		if numWorkers > 0 {
+			d.Concurrency = numWorkers
+		}
+	})
+
+	_, err = downloader.Download(file, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download compacted archive '%s': %v", key, err)
+	}
+	return file.Close()
+}
+
+// Reads the index of the archive at the path and checks every backup it lists against the entry of the archive
+func verifyArchiveFile(pathToArchive string) (ArchiveIndex, error) {
+	reader, err := zip.OpenReader(pathToArchive)
+	if err != nil {
+		return ArchiveIndex{}, err
+	}
+	defer reader.Close()
+
+	entries := make(map[string]*zip.File)
+	for _, entry := range reader.File {
+		entries[entry.Name] = entry
+	}
+
+	indexEntry, ok := entries[IndexName]
+	if !ok {
+		return ArchiveIndex{}, fmt.Errorf("archive has no %s", IndexName)
+	}
+	var index ArchiveIndex
+	if err = readJSONEntry(indexEntry, &index); err != nil {
+		return ArchiveIndex{}, fmt.Errorf("failed to read the index of the archive: %v", err)
+	}
+	if index.Version > IndexVersion {
+		return ArchiveIndex{}, fmt.Errorf("archive index version %d is newer than the supported version %d", index.Version, IndexVersion)
+	}
+
+	for _, backup := range index.Backups {
+		entry, ok := entries[backup.Name]
+		if !ok {
+			return ArchiveIndex{}, fmt.Errorf("backup '%s' listed in the index is missing from the archive", backup.Key)
+		}
+		size, md5sum, err := hashEntry(entry)
+		if err != nil {
+			return ArchiveIndex{}, fmt.Errorf("failed to read backup '%s' from the archive: %v", backup.Key, err)
+		}
+		if size != backup.Size || md5sum != backup.MD5 {
+			return ArchiveIndex{}, fmt.Errorf("backup '%s' in the archive is %d bytes with md5sum '%s' rather than %d bytes with md5sum '%s'",
+				backup.Key, size, md5sum, backup.Size, backup.MD5)
+		}
+	}
+	return index, nil
+}
+
+func readJSONEntry(entry *zip.File, v interface{}) error {
+	rc, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v)
+}
+
+// Returns the size and hex encoded md5sum of the entry. Reading the entry to the end also checks its CRC-32
+func hashEntry(entry *zip.File) (int64, string, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return 0, "", err
+	}
+	defer rc.Close()
+
+	hash := md5.New()
+	size, err := io.Copy(hash, rc)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func validationCheck(compactObject CompactObject) error {
+	if compactObject.Bucket == "" {
+		return errors.New("bucket must be specified to compact backups")
+	}
+
+	if compactObject.Prefix == "" {
+		return errors.New("the rotation prefix of the backups to compact must be specified")
+	}
+
+	if compactObject.OlderThan <= 0 {
+		return errors.New("backups must be older than a duration greater than 0 to be compacted")
+	}
+
+	if compactObject.MinBackups < 0 {
+		return errors.New("min backups must not be less than 0")
+	}
+
+	return nil
+}
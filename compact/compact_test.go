@@ -0,0 +1,213 @@
+package compact
+
+import (
+	"fmt"
+	"s3backup/log"
+	"s3backup/s3mock"
+	"s3backup/upload"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mockBucket = "mockbucket"
+
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+}
+
+//----------------------------------------------
+// Compaction Testing (mock S3)
+//	1: Daily backups older than the threshold are bundled into a verifiable archive and the originals are removed
+//	2: A dry run reports the compaction without modifying the bucket
+//	3: Backups under a legal hold and split uploads are never compacted
+//	4: Nothing is compacted when fewer than the minimum backups are old enough
+//	5: The originals are kept when the uploaded archive fails verification
+//
+//----------------------------------------------
+
+// Test 1 - Compaction Testing
+//	Daily backups older than 30 days are bundled into an archive and removed
+func TestCompactBundlesOldBackups(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	now := time.Now()
+	seedDailyBackups(mockS3, now)
+
+	archive, err := CompactBackups(mockS3.Client(), testCompactObject(), false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to compact backups without any error: %v", err))
+	}
+	if archive == nil {
+		t.Fatal("expected the old daily backups to be compacted")
+	}
+
+	expectedKey := "backups/.compacted/daily_" + now.AddDate(0, 0, -60).UTC().Format(archiveTimeLayout) + "_" +
+		now.AddDate(0, 0, -40).UTC().Format(archiveTimeLayout) + ".zip"
+	if archive.Key != expectedKey {
+		t.Error(fmt.Sprintf("expected the archive to be stored under '%s' but got '%s'", expectedKey, archive.Key))
+	}
+
+	expectedKeys := []string{
+		expectedKey,
+		"backups/daily_db_recent",
+		"backups/weekly_db_old",
+	}
+	if keys := mockS3.Keys(mockBucket); strings.Join(keys, ",") != strings.Join(expectedKeys, ",") {
+		t.Error(fmt.Sprintf("expected keys %v after compaction but got %v", expectedKeys, keys))
+	}
+	if len(archive.DeletedKeys) != 3 {
+		t.Error(fmt.Sprintf("expected the 3 compacted originals to be deleted but got %v", archive.DeletedKeys))
+	}
+
+	obj := mockS3.Object(mockBucket, expectedKey)
+	if obj.Header.Get("X-Amz-Meta-"+upload.ArchiveMetadataKey) != upload.ArchiveFormatZip {
+		t.Error("expected the archive to be recorded as a zip archive so that it is extracted on download")
+	}
+
+	index, err := VerifyArchive(mockS3.Client(), mockBucket, expectedKey)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the archive to verify without any error: %v", err))
+	}
+	expectedOrder := []string{"backups/daily_db_a", "backups/daily_db_b", "backups/daily_db_c"}
+	if len(index.Backups) != len(expectedOrder) {
+		t.Fatal(fmt.Sprintf("expected the index to list %d backups but got %d", len(expectedOrder), len(index.Backups)))
+	}
+	for i, backup := range index.Backups {
+		if backup.Key != expectedOrder[i] || backup.Name != strings.TrimPrefix(expectedOrder[i], "backups/") {
+			t.Error(fmt.Sprintf("expected backup %d of the index to be '%s' but got '%s' named '%s'", i, expectedOrder[i], backup.Key, backup.Name))
+		}
+	}
+	if index.Backups[0].MD5 != "8a8b5130119939bd5985e84cd405d9e6" || index.Backups[0].Size != int64(len("oldest daily backup")) {
+		t.Error(fmt.Sprintf("expected the md5sum and size of the oldest backup to be indexed but got '%s' %d bytes", index.Backups[0].MD5, index.Backups[0].Size))
+	}
+}
+
+// Test 2 - Compaction Testing
+//	Compact the daily backups with dry run enabled
+func TestCompactDryRun(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	seedDailyBackups(mockS3, time.Now())
+	before := mockS3.Keys(mockBucket)
+
+	archive, err := CompactBackups(mockS3.Client(), testCompactObject(), true)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to compact backups without any error: %v", err))
+	}
+
+	if archive == nil || len(archive.Backups) != 3 {
+		t.Error(fmt.Sprintf("expected the 3 old daily backups to be reported but got %v", archive))
+	}
+	if keys := mockS3.Keys(mockBucket); strings.Join(keys, ",") != strings.Join(before, ",") {
+		t.Error(fmt.Sprintf("expected the bucket to be unchanged by a dry run but got %v", keys))
+	}
+	if len(mockS3.Requests("GetObject")) != 0 {
+		t.Error("expected no backup to be downloaded by a dry run")
+	}
+}
+
+// Test 3 - Compaction Testing
+//	Compact old daily backups of which one is under a legal hold and one is a split upload
+func TestCompactSkipsHeldAndSplitBackups(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	now := time.Now()
+	seedDailyBackups(mockS3, now)
+	mockS3.PutObject(mockBucket, "backups/daily_db_held", []byte("held daily backup"), now.AddDate(0, 0, -50)).LegalHold = "ON"
+	mockS3.PutObject(mockBucket, "backups/daily_db_split", []byte(`{"version":1}`), now.AddDate(0, 0, -45)).
+		Header.Set("X-Amz-Meta-"+upload.ArchiveMetadataKey, upload.ArchiveFormatVolumes)
+
+	archive, err := CompactBackups(mockS3.Client(), testCompactObject(), false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to compact backups without any error: %v", err))
+	}
+
+	if archive == nil || len(archive.Backups) != 3 {
+		t.Fatal(fmt.Sprintf("expected only the 3 old daily backups to be compacted but got %v", archive))
+	}
+	for _, key := range []string{"backups/daily_db_held", "backups/daily_db_split"} {
+		if mockS3.Object(mockBucket, key) == nil {
+			t.Error(fmt.Sprintf("expected '%s' to be kept", key))
+		}
+	}
+}
+
+// Test 4 - Compaction Testing
+//	Compact when only a single daily backup is old enough
+func TestCompactTooFewBackups(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	now := time.Now()
+	mockS3.PutObject(mockBucket, "backups/daily_db_a", []byte("oldest daily backup"), now.AddDate(0, 0, -60))
+	mockS3.PutObject(mockBucket, "backups/daily_db_recent", []byte("recent daily backup"), now.AddDate(0, 0, -1))
+
+	archive, err := CompactBackups(mockS3.Client(), testCompactObject(), false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to skip compaction without any error: %v", err))
+	}
+
+	if archive != nil {
+		t.Error(fmt.Sprintf("expected nothing to be compacted but got '%s'", archive.Key))
+	}
+	if len(mockS3.Keys(mockBucket)) != 2 {
+		t.Error(fmt.Sprintf("expected the bucket to be unchanged but got %v", mockS3.Keys(mockBucket)))
+	}
+}
+
+// Test 5 - Compaction Testing
+//	Compact when the archive read back from the bucket is truncated
+func TestCompactVerificationFailure(t *testing.T) {
+	expectedErrString := "failed verification and the backups were not deleted"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	now := time.Now()
+	seedDailyBackups(mockS3, now)
+	before := mockS3.Keys(mockBucket)
+
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "GetObject" && strings.HasPrefix(req.Key, "backups/"+CompactDir) {
+			if obj := mockS3.Object(mockBucket, req.Key); obj != nil && obj.Header.Get("X-Amz-Meta-Truncated") == "" {
+				truncated := mockS3.PutObject(mockBucket, req.Key, obj.Body[:len(obj.Body)/2], time.Now())
+				truncated.Header.Set("X-Amz-Meta-Truncated", "true")
+			}
+		}
+		return nil
+	})
+
+	_, err := CompactBackups(mockS3.Client(), testCompactObject(), false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if keys := mockS3.Keys(mockBucket); strings.Join(keys, ",") != strings.Join(before, ",") {
+		t.Error(fmt.Sprintf("expected the originals to be kept and the archive to be removed but got %v", keys))
+	}
+}
+
+// Seeds three daily backups older than 30 days, a recent daily backup and an old weekly backup
+func seedDailyBackups(mockS3 *s3mock.Server, now time.Time) {
+	mockS3.PutObject(mockBucket, "backups/daily_db_b", []byte("middle daily backup"), now.AddDate(0, 0, -50))
+	mockS3.PutObject(mockBucket, "backups/daily_db_a", []byte("oldest daily backup"), now.AddDate(0, 0, -60))
+	mockS3.PutObject(mockBucket, "backups/daily_db_c", []byte("newest old daily backup"), now.AddDate(0, 0, -40))
+	mockS3.PutObject(mockBucket, "backups/daily_db_recent", []byte("recent daily backup"), now.AddDate(0, 0, -1))
+	mockS3.PutObject(mockBucket, "backups/weekly_db_old", []byte("old weekly backup"), now.AddDate(0, 0, -60))
+}
+
+func testCompactObject() CompactObject {
+	return CompactObject{
+		Bucket:    mockBucket,
+		BucketDir: "backups/",
+		Prefix:    "daily_",
+		OlderThan: 30 * 24 * time.Hour,
+	}
+}
@@ -0,0 +1,15 @@
+package compact
+
+import "time"
+
+// CompactObject represents the backups of a rotation tier to bundle into a single archive once they are old enough
+type CompactObject struct {
+	Bucket     string
+	BucketDir  string
+	Prefix     string        // The rotation prefix of the backups to compact, e.g. daily_
+	OlderThan  time.Duration // Only backups last modified longer ago than this are compacted
+	MinBackups int           // Backups are only compacted once at least this many are old enough. Defaults to 2
+	IndexKey   string        // If set then every compacted key which was deleted is removed from the backup index stored under this key
+	NumWorkers int
+	PartSize   int
+}
@@ -0,0 +1,120 @@
+package compress
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// MetadataKey is the user metadata key that the compression algorithm of an uploaded object is recorded under
+const MetadataKey = "S3backup-Compression"
+
+// Compressor is a compression algorithm which files can be compressed with before upload
+// and decompressed with after download. New algorithms are made available with Register
+type Compressor interface {
+	Name() string      // The name used to select the algorithm, e.g. --compression=gzip
+	Extension() string // The extension appended to compressed keys including the leading '.'
+	ValidLevel(level int) bool
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error) // A level of 0 selects the default level of the algorithm
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressors = map[string]Compressor{}
+
+// Register makes the compressor available by name and extension
+func Register(compressor Compressor) {
+	compressors[compressor.Name()] = compressor
+}
+
+// Names returns the sorted names of every registered compressor
+func Names() []string {
+	names := []string{}
+	for name := range compressors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the compressor registered with the name
+func Get(name string) (Compressor, error) {
+	compressor, ok := compressors[strings.ToLower(name)]
+	if !ok {
+		return nil, errors.New("unsupported compression algorithm '" + name + "', expected one of: " + strings.Join(Names(), ", "))
+	}
+	return compressor, nil
+}
+
+// ForKey returns the compressor whose extension the key ends with
+func ForKey(key string) (Compressor, bool) {
+	for _, compressor := range compressors {
+		if strings.HasSuffix(key, compressor.Extension()) {
+			return compressor, true
+		}
+	}
+	return nil, false
+}
+
+// CompressFile writes the compressed contents of the source file to the destination file
+func CompressFile(compressor Compressor, level int, src string, dst string) error {
+	if !compressor.ValidLevel(level) {
+		return errors.New("invalid " + compressor.Name() + " compression level")
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	writer, err := compressor.NewWriter(out, level)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(writer, in); err != nil {
+		writer.Close()
+		return err
+	}
+
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+// DecompressFile writes the decompressed contents of the source file to the destination file
+func DecompressFile(compressor Compressor, src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reader, err := compressor.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, reader); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,111 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testData = []byte(strings.Repeat("this is just a little test file which should compress well\n", 2000))
+
+//----------------------------------------------
+//
+//             Round Trip Tests
+//
+//----------------------------------------------
+
+// Every valid level of every algorithm should decompress back to the original contents
+func TestRoundTripEveryAlgorithmAndLevel(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "source")
+	if err := ioutil.WriteFile(src, testData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	levels := map[string]int{"gzip": 9, "zstd": 22}
+
+	for _, name := range Names() {
+		compressor, err := Get(name)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to get compressor '%s': %v", name, err))
+		}
+
+		maxLevel, ok := levels[name]
+		if !ok {
+			t.Fatal("no levels defined for compressor: " + name)
+		}
+
+		for level := 0; level <= maxLevel; level++ {
+			compressed := filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, level, compressor.Extension()))
+			decompressed := filepath.Join(dir, fmt.Sprintf("%s-%d", name, level))
+
+			if err = CompressFile(compressor, level, src, compressed); err != nil {
+				t.Fatal(fmt.Sprintf("expected to compress with %s level %d: %v", name, level, err))
+			}
+
+			if err = DecompressFile(compressor, compressed, decompressed); err != nil {
+				t.Fatal(fmt.Sprintf("expected to decompress with %s level %d: %v", name, level, err))
+			}
+
+			result, _ := ioutil.ReadFile(decompressed)
+			if !bytes.Equal(result, testData) {
+				t.Error(fmt.Sprintf("expected %s level %d to round trip to the original contents", name, level))
+			}
+
+			compressedData, _ := ioutil.ReadFile(compressed)
+			if len(compressedData) >= len(testData) {
+				t.Error(fmt.Sprintf("expected %s level %d to reduce the size of the file", name, level))
+			}
+		}
+	}
+}
+
+func TestInvalidLevel(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "source")
+	ioutil.WriteFile(src, testData, 0644)
+
+	for name, level := range map[string]int{"gzip": 10, "zstd": 23} {
+		compressor, _ := Get(name)
+		err := CompressFile(compressor, level, src, filepath.Join(dir, name))
+
+		expectedErrString := "invalid " + name + " compression level"
+		if err != nil && strings.Contains(err.Error(), expectedErrString) {
+			// Pass
+		} else {
+			t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+		}
+	}
+}
+
+func TestUnknownAlgorithm(t *testing.T) {
+	_, err := Get("lz4")
+	expectedErrString := "unsupported compression algorithm 'lz4'"
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+func TestForKey(t *testing.T) {
+	for key, expected := range map[string]string{
+		"daily_backup_20200101T000000.gz":  "gzip",
+		"daily_backup_20200101T000000.zst": "zstd",
+		"daily_backup_20200101T000000":     "",
+	} {
+		compressor, ok := ForKey(key)
+		if expected == "" {
+			if ok {
+				t.Error("expected no compressor for key: " + key)
+			}
+			continue
+		}
+		if !ok || compressor.Name() != expected {
+			t.Error(fmt.Sprintf("expected compressor '%s' for key: %s", expected, key))
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+func init() {
+	Register(gzipCompressor{})
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Extension() string {
+	return ".gz"
+}
+
+func (gzipCompressor) ValidLevel(level int) bool {
+	return level >= 0 && level <= gzip.BestCompression
+}
+
+func (gzipCompressor) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
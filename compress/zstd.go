@@ -0,0 +1,41 @@
+package compress
+
+import (
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+func init() {
+	Register(zstdCompressor{})
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string {
+	return "zstd"
+}
+
+func (zstdCompressor) Extension() string {
+	return ".zst"
+}
+
+// Levels follow the zstd command line levels of 1 to 22 which are mapped onto the closest supported encoder level
+func (zstdCompressor) ValidLevel(level int) bool {
+	return level >= 0 && level <= 22
+}
+
+func (zstdCompressor) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	encoderLevel := zstd.SpeedDefault
+	if level > 0 {
+		encoderLevel = zstd.EncoderLevelFromZstd(level)
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel))
+}
+
+func (zstdCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
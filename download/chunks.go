@@ -0,0 +1,84 @@
+package download
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/s3client"
+	"s3backup/upload"
+	"io"
+	"os"
+)
+
+// Reassembles a file uploaded with UploadChunked into the download location from the chunks listed in its manifest.
+// Every chunk is verified against its hash as it is written, and the Merkle root and md5sum of the manifest are
+// verified once the file has been reassembled
+func downloadChunked(svc *s3.S3, downloadObject DownloadObject) error {
+	body, err := s3client.GetObjectBody(svc, downloadObject.Bucket, downloadObject.S3FileKey)
+	if err != nil {
+		return err
+	}
+
+	var manifest upload.ChunkManifest
+	if err = json.Unmarshal(body, &manifest); err != nil {
+		return fmt.Errorf("failed to parse chunk manifest '%s': %v", downloadObject.S3FileKey, err)
+	}
+
+	if manifest.Version != upload.ChunkManifestVersion {
+		return fmt.Errorf("unsupported chunk manifest version: %d", manifest.Version)
+	}
+
+	hashes := make([]string, len(manifest.Chunks))
+	for i, chunk := range manifest.Chunks {
+		hashes[i] = chunk.Hash
+	}
+	root, err := upload.MerkleRoot(hashes)
+	if err != nil {
+		return err
+	}
+	if root != manifest.Root {
+		return fmt.Errorf("root of the chunk hashes '%s' does not match the Merkle root recorded in the manifest '%s'", root, manifest.Root)
+	}
+
+	file, err := os.Create(downloadObject.DownloadLocation)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	log.Info.Printf("Reassembling '%s' from %d chunks\n", downloadObject.S3FileKey, len(manifest.Chunks))
+
+	hash := md5.New()
+	w := io.MultiWriter(file, hash)
+
+	var size int64
+	for _, chunk := range manifest.Chunks {
+		contents, err := s3client.GetObjectBody(svc, downloadObject.Bucket, chunk.Key)
+		if err != nil {
+			return err
+		}
+		if contents == nil {
+			return fmt.Errorf("chunk '%s' referenced by the manifest does not exist", chunk.Key)
+		}
+
+		sum := sha256.Sum256(contents)
+		if hex.EncodeToString(sum[:]) != chunk.Hash || int64(len(contents)) != chunk.Size {
+			return fmt.Errorf("chunk '%s' does not match the hash recorded in the manifest", chunk.Key)
+		}
+
+		if _, err = w.Write(contents); err != nil {
+			return err
+		}
+		size += chunk.Size
+	}
+
+	if size != manifest.Size || hex.EncodeToString(hash.Sum(nil)) != manifest.MD5 {
+		return fmt.Errorf("reassembled file does not match the size and md5sum recorded in the manifest")
+	}
+
+	return file.Close()
+}
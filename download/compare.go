@@ -0,0 +1,188 @@
+package download
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/upload"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// How a local file was compared with an object
+const (
+	CompareMethodETag     = "etag"     // The ETag computed from the local file was compared with the ETag of the object
+	CompareMethodChecksum = "checksum" // The md5sum of the local file was compared with the md5sum recorded on upload
+	CompareMethodStream   = "stream"   // The object was downloaded and its md5sum compared with the md5sum of the local file
+)
+
+// Comparison is the result of comparing a local file with an object
+type Comparison struct {
+	Method string
+	Local  string // The ETag, md5sum or size of the local file
+	Remote string // The ETag, md5sum or size of the object
+	Match  bool
+}
+
+// CompareFile compares the local file with the object without downloading the object whenever possible. If the ETag
+// of the object is an md5sum, i.e. it is not encrypted with SSE-KMS or SSE-C, then the ETag of the local file is
+// computed in parts of the size of the first part of the object and compared with it. Otherwise, and for objects which
+// were compressed or encrypted on upload, the md5sum of the local file is compared with the md5sum recorded on upload.
+// The md5sum of a split upload is taken from its index. Only an object with neither is downloaded, streaming it through
+// a hasher without writing it to disk
+func CompareFile(svc *s3.S3, bucket string, key string, pathToFile string) (Comparison, error) {
+	info, err := os.Stat(pathToFile)
+	if err != nil {
+		return Comparison{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return Comparison{}, fmt.Errorf("'%s' is not a regular file", pathToFile)
+	}
+
+	compressor, archive, encrypted, err := getObjectFormat(svc, DownloadObject{Bucket: bucket, S3FileKey: key})
+	if err != nil {
+		return Comparison{}, err
+	}
+	if archive == upload.ArchiveFormatVolumes {
+		index, err := getVolumeIndex(svc, DownloadObject{Bucket: bucket, S3FileKey: key})
+		if err != nil {
+			return Comparison{}, err
+		}
+		return compareChecksum(pathToFile, index.MD5)
+	}
+	if archive != "" {
+		return Comparison{}, fmt.Errorf("'%s' is a %s archive which cannot be compared with a single local file", key, archive)
+	}
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return Comparison{}, err
+	}
+
+	recordedMD5 := ""
+	for metadataKey, value := range head.Metadata {
+		if http.CanonicalHeaderKey(metadataKey) == upload.ChecksumMetadataKey {
+			recordedMD5 = aws.StringValue(value)
+		}
+	}
+
+	// The ETag of a compressed or encrypted object is of the content which was uploaded rather than of the local file
+	if compressor != nil || encrypted {
+		if recordedMD5 == "" {
+			return Comparison{}, fmt.Errorf("'%s' was compressed or encrypted on upload and no checksum was recorded to compare the local file with, "+
+				"download it with --verifyonly instead", key)
+		}
+		return compareChecksum(pathToFile, recordedMD5)
+	}
+
+	etag := strings.Trim(aws.StringValue(head.ETag), "\"")
+	if aws.StringValue(head.ServerSideEncryption) != s3.ServerSideEncryptionAwsKms && head.SSECustomerAlgorithm == nil {
+		return compareETag(svc, bucket, key, pathToFile, info.Size(), aws.Int64Value(head.ContentLength), etag)
+	}
+
+	if recordedMD5 != "" {
+		return compareChecksum(pathToFile, recordedMD5)
+	}
+
+	log.Warn.Printf("The ETag of '%s' is not an md5sum and no checksum was recorded on upload. Streaming the whole object through a hasher\n", key)
+	return compareStream(svc, bucket, key, pathToFile)
+}
+
+// Compares the ETag of the local file with the ETag of the object. A multipart object is compared in parts of the size
+// of its first part, which is the part size it was uploaded with
+func compareETag(svc *s3.S3, bucket string, key string, pathToFile string, localSize int64, remoteSize int64, etag string) (Comparison, error) {
+	if localSize != remoteSize {
+		return Comparison{Method: CompareMethodETag, Local: fmt.Sprintf("%d bytes", localSize), Remote: fmt.Sprintf("%d bytes", remoteSize)}, nil
+	}
+
+	multipart := strings.Contains(etag, "-")
+	partSize := remoteSize
+	if multipart {
+		part, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket:     aws.String(bucket),
+			Key:        aws.String(key),
+			PartNumber: aws.Int64(1),
+		})
+		if err != nil {
+			return Comparison{}, err
+		}
+		partSize = aws.Int64Value(part.ContentLength)
+		log.Info.Printf("'%s' is a multipart object with a part size of %d bytes\n", key, partSize)
+	}
+	if partSize < 1 {
+		partSize = 1 // An empty object has a single empty part
+	}
+
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return Comparison{}, err
+	}
+	defer file.Close()
+
+	local, err := upload.StreamETag(file, partSize, multipart)
+	if err != nil {
+		return Comparison{}, err
+	}
+	return Comparison{Method: CompareMethodETag, Local: local.ETag, Remote: etag, Match: local.ETag == etag}, nil
+}
+
+// Compares the md5sum of the local file with the md5sum recorded on upload
+func compareChecksum(pathToFile string, recordedMD5 string) (Comparison, error) {
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return Comparison{}, err
+	}
+	defer file.Close()
+
+	localMD5, err := hashMD5(file)
+	if err != nil {
+		return Comparison{}, err
+	}
+	return Comparison{Method: CompareMethodChecksum, Local: localMD5, Remote: recordedMD5, Match: localMD5 == recordedMD5}, nil
+}
+
+// Compares the md5sum of the local file with the md5sum of the object streamed through a hasher
+func compareStream(svc *s3.S3, bucket string, key string, pathToFile string) (Comparison, error) {
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return Comparison{}, err
+	}
+	defer file.Close()
+
+	localMD5, err := hashMD5(file)
+	if err != nil {
+		return Comparison{}, err
+	}
+
+	resp, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return Comparison{}, err
+	}
+	defer resp.Body.Close()
+
+	remoteMD5, err := hashMD5(resp.Body)
+	if err != nil {
+		return Comparison{}, fmt.Errorf("failed to stream '%s': %v", key, err)
+	}
+	return Comparison{Method: CompareMethodStream, Local: localMD5, Remote: remoteMD5, Match: localMD5 == remoteMD5}, nil
+}
+
+// Returns the hex encoded md5sum of everything read from the reader
+func hashMD5(r io.Reader) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,86 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/s3client"
+	"s3backup/upload"
+	"io"
+	"strings"
+)
+
+// VerifyDirManifest verifies every file of a directory uploaded with a manifest by UploadDir. Each file listed in the
+// manifest is streamed through a hasher without being written to disk and the Merkle root is recomputed from the
+// stored files, which must match the root recorded in the manifest and the expected root if one is specified.
+// Returns the verified root
+func VerifyDirManifest(svc *s3.S3, bucket string, manifestKey string, expectedRoot string) (string, error) {
+	body, err := s3client.GetObjectBody(svc, bucket, manifestKey)
+	if err != nil {
+		return "", err
+	}
+
+	var manifest upload.DirManifest
+	if err = json.Unmarshal(body, &manifest); err != nil {
+		return "", fmt.Errorf("failed to parse manifest '%s': %v", manifestKey, err)
+	}
+
+	if manifest.Version != upload.DirManifestVersion {
+		return "", fmt.Errorf("unsupported manifest version: %d", manifest.Version)
+	}
+
+	if expectedRoot != "" && !strings.EqualFold(expectedRoot, manifest.Root) {
+		return "", fmt.Errorf("the Merkle root recorded in the manifest '%s' does not match the expected root '%s'", manifest.Root, expectedRoot)
+	}
+
+	log.Info.Printf("Verifying %d files against the manifest '%s'\n", len(manifest.Files), manifestKey)
+
+	stored := make([]upload.ManifestFile, len(manifest.Files))
+	differing := []string{}
+	for i, file := range manifest.Files {
+		if stored[i], err = hashStoredFile(svc, bucket, file.Key); err != nil {
+			return "", fmt.Errorf("failed to verify key '%s': %v", file.Key, err)
+		}
+		if stored[i] != file {
+			log.Error.Printf("Key: '%s' has sha256 %s (%d bytes) but the manifest records %s (%d bytes)\n",
+				file.Key, stored[i].Hash, stored[i].Size, file.Hash, file.Size)
+			differing = append(differing, file.Key)
+		}
+	}
+
+	root, err := upload.DirManifestRoot(stored)
+	if err != nil {
+		return "", err
+	}
+	if root != manifest.Root {
+		return "", fmt.Errorf("the Merkle root of the stored files '%s' does not match the root recorded in the manifest '%s', %d files differ: %s",
+			root, manifest.Root, len(differing), strings.Join(differing, ", "))
+	}
+
+	log.Info.Printf("Verified %d files with Merkle root: %s\n", len(stored), root)
+
+	return root, nil
+}
+
+// Returns the manifest entry of the object stored under the key by streaming it through a hasher
+func hashStoredFile(svc *s3.S3, bucket string, key string) (upload.ManifestFile, error) {
+	output, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return upload.ManifestFile{}, err
+	}
+	defer output.Body.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, output.Body)
+	if err != nil {
+		return upload.ManifestFile{}, err
+	}
+	return upload.ManifestFile{Key: key, Hash: hex.EncodeToString(hash.Sum(nil)), Size: size}, nil
+}
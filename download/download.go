@@ -4,13 +4,54 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"errors"
+	"fmt"
+	"s3backup/compress"
+	"s3backup/encrypt"
 	"s3backup/log"
+	"s3backup/s3client"
+	"s3backup/upload"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
 // DownloadFile downloads a file from s3 given a bucket and key
+// If the object was encrypted on upload then it is decrypted with the encryption key of the download object.
+// If the object was compressed on upload then it is decompressed into the download location.
+// If the object is a zip archive then it is extracted into the download location which is created as a directory.
+// Otherwise if the download location is an existing directory then the file is written into it named after the key.
+// If the object is a chunk manifest then the file is reassembled from its chunks into the download location.
+// If the object is a volume index then the file is reassembled from its volumes into the download location.
+// Any parent directories of the download location which do not exist are created.
+// If verify only is enabled then the object is verified with VerifyObject and nothing is written.
+// If the object is archived, e.g. in GLACIER, then its restore is requested and waited for with EnsureRestored.
+// If resume is enabled then a partial download of the same object is resumed rather than downloaded from the start.
+// If a restore lock ttl is specified then a restore lock is held under the bucket dir until the download has finished
 func DownloadFile(svc *s3.S3, downloadObject DownloadObject) error {
+	if downloadObject.RestoreLockTTL > 0 {
+		lock, err := s3client.AcquireRestoreLock(svc, downloadObject.Bucket, downloadObject.BucketDir, downloadObject.S3FileKey, downloadObject.RestoreLockTTL)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := s3client.ReleaseRestoreLock(svc, downloadObject.Bucket, lock); err != nil {
+				log.Warn.Println(err)
+			}
+		}()
+	}
+
+	if err := EnsureRestored(svc, downloadObject.Bucket, downloadObject.S3FileKey, downloadObject.Restore); err != nil {
+		return err
+	}
+
+	if downloadObject.VerifyOnly {
+		return VerifyObject(svc, downloadObject)
+	}
 
 	log.Info.Println(`
 	######################################
@@ -18,28 +59,103 @@ func DownloadFile(svc *s3.S3, downloadObject DownloadObject) error {
 	######################################
 	`)
 
+	compressor, archive, encrypted, err := getObjectFormat(svc, downloadObject)
+	if err != nil {
+		return err
+	}
+	if err = checkEncryptionKey(downloadObject, encrypted); err != nil {
+		return err
+	}
+
+	if archive != upload.ArchiveFormatZip {
+		downloadObject.DownloadLocation = resolveDownloadLocation(downloadObject, compressor, encrypted)
+	}
+
+	if err := createParentDirs(downloadObject.DownloadLocation); err != nil {
+		return err
+	}
+
 	partSize := int64(downloadObject.PartSize * 1024 * 1024)
 
 	downloader := s3manager.NewDownloaderWithClient(svc, func(d *s3manager.Downloader) {
 		d.PartSize = partSize
 		d.Concurrency = downloadObject.NumWorkers
+		if downloadObject.BufferSize > 0 {
+			d.BufferProvider = s3manager.NewPooledBufferedWriterReadFromProvider(downloadObject.BufferSize * 1024)
+		}
 	})
 
-	file, err := os.Create(downloadObject.DownloadLocation)
-	if err != nil {
-		return err
+	if downloadObject.Resume && (archive == upload.ArchiveFormatChunks || archive == upload.ArchiveFormatVolumes) {
+		log.Warn.Printf("'%s' is a %s upload which cannot be resumed, it is reassembled from the start\n", downloadObject.S3FileKey, archive)
 	}
 
-	log.Info.Println("Attempting to download file from S3: " + downloadObject.S3FileKey)
+	if archive == upload.ArchiveFormatChunks {
+		startTime := time.Now()
+		err = downloadChunked(svc, downloadObject)
+		log.Info.Printf("Total time spent processing download: %0.2f seconds\n", time.Since(startTime).Seconds())
+		if err != nil {
+			log.Error.Printf("Failed to reassemble '%s' from its chunks: %v\n", downloadObject.S3FileKey, err)
+			return err
+		}
+
+		log.Info.Printf("Downloading complete. '%s' has been written to '%s'", downloadObject.S3FileKey, downloadObject.DownloadLocation)
+		return nil
+	}
+
+	if archive == upload.ArchiveFormatVolumes {
+		startTime := time.Now()
+		err = downloadVolumes(svc, downloadObject)
+		log.Info.Printf("Total time spent processing download: %0.2f seconds\n", time.Since(startTime).Seconds())
+		if err != nil {
+			log.Error.Printf("Failed to reassemble '%s' from its volumes: %v\n", downloadObject.S3FileKey, err)
+			return err
+		}
 
-	log.Info.Printf("Downloading is about to begin with a maximum of %d workers\n", downloadObject.NumWorkers)
+		log.Info.Printf("Downloading complete. '%s' has been written to '%s'", downloadObject.S3FileKey, downloadObject.DownloadLocation)
+		return nil
+	}
+
+	// Encrypted and compressed objects and archives are downloaded next to the download location and then decrypted,
+	// decompressed or extracted into it. The partial download of a resumable download is kept if the download fails
+	keepPartial := downloadObject.Resume
+	removeTemp := func(pathToTemp string) {
+		if !keepPartial {
+			os.Remove(pathToTemp)
+		}
+	}
+	pathToDownload := downloadObject.DownloadLocation
+	extension := ""
+	if compressor != nil || archive != "" {
+		extension = "." + archive
+		if compressor != nil {
+			extension = compressor.Extension()
+		}
+		pathToDownload, err = createTempDownload(downloadObject, extension)
+		if err != nil {
+			return err
+		}
+		defer removeTemp(pathToDownload)
+	}
+	pathToDecrypt := pathToDownload
+	if encrypted {
+		pathToDownload, err = createTempDownload(downloadObject, extension+encrypt.Extension)
+		if err != nil {
+			return err
+		}
+		defer removeTemp(pathToDownload)
+	}
+
+	log.Info.Println("Attempting to download file from S3: " + downloadObject.S3FileKey)
 
 	startTime := time.Now()
 
-	_, err = downloader.Download(file, &s3.GetObjectInput{
-		Bucket: aws.String(downloadObject.Bucket),
-		Key:    aws.String(downloadObject.S3FileKey),
-	})
+	if downloadObject.Resume {
+		log.Info.Println("Downloading is about to begin in order of the bytes of the object so that it can be resumed")
+		err = downloadResumable(svc, downloadObject, pathToDownload, partSize)
+	} else {
+		log.Info.Printf("Downloading is about to begin with a maximum of %d workers\n", downloadObject.NumWorkers)
+		err = downloadToFile(downloader, downloadObject, pathToDownload)
+	}
 
 	elapsedTime := time.Since(startTime).Seconds()
 
@@ -49,9 +165,163 @@ func DownloadFile(svc *s3.S3, downloadObject DownloadObject) error {
 		log.Error.Printf("Failed to download '%s' from S3: %v\n", downloadObject.S3FileKey, err)
 		return err
 	}
+	keepPartial = false
+
+	if encrypted {
+		log.Info.Printf("Decrypting '%s' with %s\n", downloadObject.S3FileKey, encrypt.Algorithm)
+		err = encrypt.DecryptFile(downloadObject.EncryptionKey, pathToDownload, pathToDecrypt)
+		if err != nil {
+			os.Remove(pathToDecrypt) // Nothing decrypted from an object which fails to decrypt can be trusted
+			log.Error.Printf("Failed to decrypt '%s': %v\n", downloadObject.S3FileKey, err)
+			return err
+		}
+		pathToDownload = pathToDecrypt
+	}
+
+	if compressor != nil {
+		log.Info.Printf("Decompressing '%s' with %s\n", downloadObject.S3FileKey, compressor.Name())
+		err = compress.DecompressFile(compressor, pathToDownload, downloadObject.DownloadLocation)
+		if err != nil {
+			log.Error.Printf("Failed to decompress '%s': %v\n", downloadObject.S3FileKey, err)
+			return err
+		}
+	}
+
+	if archive == upload.ArchiveFormatZip {
+		log.Info.Printf("Extracting zip archive '%s' into '%s'\n", downloadObject.S3FileKey, downloadObject.DownloadLocation)
+		err = extractZip(pathToDownload, downloadObject.DownloadLocation, downloadObject.PreserveMetadata, downloadObject.CheckInodes)
+		if err != nil {
+			log.Error.Printf("Failed to extract '%s': %v\n", downloadObject.S3FileKey, err)
+			return err
+		}
+	}
 
 	log.Info.Printf("Downloading complete. '%s' has been written to '%s'", downloadObject.S3FileKey, downloadObject.DownloadLocation)
 
 	return nil
 
 }
+
+// Downloads the object into the file at the path with the parts of the downloader downloaded at once
+func downloadToFile(downloader *s3manager.Downloader, downloadObject DownloadObject, pathToDownload string) error {
+	file, err := os.Create(pathToDownload)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = downloader.Download(file, &s3.GetObjectInput{
+		Bucket: aws.String(downloadObject.Bucket),
+		Key:    aws.String(downloadObject.S3FileKey),
+	})
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Creates an empty temporary file with the extension next to the download location and returns its path. The partial
+// download of a resumable download is instead named after the download location so that the next download finds it
+func createTempDownload(downloadObject DownloadObject, extension string) (string, error) {
+	if downloadObject.Resume {
+		return downloadObject.DownloadLocation + ".partial" + extension, nil
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(downloadObject.DownloadLocation), filepath.Base(downloadObject.DownloadLocation)+".*"+extension)
+	if err != nil {
+		return "", err
+	}
+	tmpFile.Close()
+	return tmpFile.Name(), nil
+}
+
+// Returns the download location, or if it is an existing directory the path of a file in it named after the base name
+// of the key. The extensions of the compression and encryption of the object are removed from the name as the file is
+// written decompressed and decrypted
+func resolveDownloadLocation(downloadObject DownloadObject, compressor compress.Compressor, encrypted bool) string {
+	info, err := os.Stat(downloadObject.DownloadLocation)
+	if err != nil || !info.IsDir() {
+		return downloadObject.DownloadLocation
+	}
+
+	name := path.Base(downloadObject.S3FileKey)
+	if encrypted {
+		name = strings.TrimSuffix(name, encrypt.Extension)
+	}
+	if compressor != nil {
+		name = strings.TrimSuffix(name, compressor.Extension())
+	}
+
+	downloadLocation := filepath.Join(downloadObject.DownloadLocation, name)
+	log.Info.Printf("Download location '%s' is a directory, writing '%s' to '%s'\n", downloadObject.DownloadLocation, downloadObject.S3FileKey, downloadLocation)
+	return downloadLocation
+}
+
+// Creates the parent directories of the download location if they do not exist
+func createParentDirs(downloadLocation string) error {
+	parent := filepath.Dir(downloadLocation)
+	if _, err := os.Stat(parent); !os.IsNotExist(err) {
+		return err
+	}
+
+	log.Info.Printf("Creating the parent directories of the download location: '%s'\n", parent)
+	return os.MkdirAll(parent, 0755)
+}
+
+// Returns the compressor the object was compressed with on upload or nil if it is not compressed, the archive format
+// if the object is an archive of a directory, and whether the object was encrypted on upload. The metadata of the
+// object takes precedence over the extension of the key
+func getObjectFormat(svc *s3.S3, downloadObject DownloadObject) (compress.Compressor, string, bool, error) {
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(downloadObject.Bucket),
+		Key:    aws.String(downloadObject.S3FileKey),
+	})
+	if err != nil {
+		log.Error.Printf("Failed to retrieve metadata of '%s' from S3: %v\n", downloadObject.S3FileKey, err)
+		return nil, "", false, err
+	}
+
+	key := downloadObject.S3FileKey
+	encrypted := strings.HasSuffix(key, encrypt.Extension)
+	for metadataKey, value := range head.Metadata {
+		if http.CanonicalHeaderKey(metadataKey) == encrypt.MetadataKey {
+			if aws.StringValue(value) != encrypt.Algorithm {
+				return nil, "", false, errors.New("unsupported encryption algorithm: " + aws.StringValue(value))
+			}
+			encrypted = true
+		}
+	}
+	key = strings.TrimSuffix(key, encrypt.Extension)
+
+	for metadataKey, value := range head.Metadata {
+		switch http.CanonicalHeaderKey(metadataKey) {
+		case compress.MetadataKey:
+			compressor, err := compress.Get(aws.StringValue(value))
+			return compressor, "", encrypted, err
+		case upload.ArchiveMetadataKey:
+			switch aws.StringValue(value) {
+			case upload.ArchiveFormatZip, upload.ArchiveFormatChunks, upload.ArchiveFormatVolumes:
+				return nil, aws.StringValue(value), encrypted, nil
+			}
+			return nil, "", false, errors.New("unsupported archive format: " + aws.StringValue(value))
+		}
+	}
+
+	if compressor, ok := compress.ForKey(key); ok {
+		return compressor, "", encrypted, nil
+	}
+
+	if strings.HasSuffix(key, ".zip") {
+		return nil, upload.ArchiveFormatZip, encrypted, nil
+	}
+
+	return nil, "", encrypted, nil
+}
+
+// Returns an error if the object is encrypted and no key material has been provided to decrypt it with
+func checkEncryptionKey(downloadObject DownloadObject, encrypted bool) error {
+	if encrypted && len(downloadObject.EncryptionKey) == 0 {
+		return fmt.Errorf("'%s' is encrypted, a key file or the %s environment variable must be specified to decrypt it",
+			downloadObject.S3FileKey, encrypt.PassphraseEnv)
+	}
+	return nil
+}
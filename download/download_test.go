@@ -1,14 +1,25 @@
 package download
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"s3backup/log"
 	"s3backup/s3client"
+	"s3backup/s3mock"
 	"s3backup/upload"
 	"s3backup/util"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -31,8 +42,10 @@ func init() {
 	awsCredentials := os.Getenv("AWS_CRED_FILE")
 	awsProfile := os.Getenv("AWS_PROFILE")
 	awsRegion := os.Getenv("AWS_REGION")
+	awsEndpoint := os.Getenv("AWS_ENDPOINT")
+	awsPartition := os.Getenv("AWS_PARTITION")
 	awsBucket := os.Getenv("AWS_BUCKET_DOWNLOAD")
-	s3svc, err := s3client.CreateS3Client(awsCredentials, awsProfile, awsRegion)
+	s3svc, err := s3client.CreateS3Client(awsCredentials, awsProfile, false, "", "", awsRegion, awsEndpoint, awsPartition, nil, false)
 
 	if err != nil {
 		log.Error.Println(err)
@@ -171,3 +184,1817 @@ func TestDownloadFileInDir(t *testing.T) {
 		t.Error("expected md5s to match")
 	}
 }
+
+//----------------------------------------------
+// Compression Testing (mock S3)
+//	1: A compressed upload is decompressed on download for each algorithm and level
+//	2: The decompressor is picked from the extension if the object has no compression metadata
+//----------------------------------------------
+
+// Test 1 - Compression Testing
+//	Upload the file compressed with each algorithm and download it to the original contents
+func TestDownloadCompressedFile(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+	mockSvc := server.Client()
+
+	createdMD5, err := util.ComputeMD5Sum(fullPathToTestFile)
+	if err != nil {
+		t.Fatal("expected to be able to generated md5sum on existing file")
+	}
+
+	for _, compression := range []struct {
+		name      string
+		level     int
+		extension string
+	}{{"gzip", 0, ".gz"}, {"gzip", 9, ".gz"}, {"zstd", 0, ".zst"}, {"zstd", 19, ".zst"}} {
+		uploadObject := upload.UploadObject{
+			PathToFile:       fullPathToTestFile,
+			S3FileName:       testFileName + "-" + compression.name + strconv.Itoa(compression.level),
+			Bucket:           "mockbucket",
+			Timeout:          timeout,
+			NumWorkers:       5,
+			PartSize:         50,
+			Compression:      compression.name,
+			CompressionLevel: compression.level,
+		}
+
+		s3FileName, err := upload.UploadFile(mockSvc, uploadObject, "", false)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to upload compressed file without any error: %v", err))
+		}
+
+		if !strings.HasSuffix(s3FileName, compression.extension) {
+			t.Error(fmt.Sprintf("expected key '%s' to have the extension '%s'", s3FileName, compression.extension))
+		}
+
+		if string(server.Object("mockbucket", s3FileName).Body) == "this is just a little test file" {
+			t.Error("expected the uploaded object to be compressed")
+		}
+
+		downloadLocation := "../myCompressedTestDownload"
+
+		err = DownloadFile(mockSvc, DownloadObject{
+			DownloadLocation: downloadLocation,
+			S3FileKey:        s3FileName,
+			Bucket:           "mockbucket",
+			NumWorkers:       5,
+			PartSize:         50,
+		})
+		if err != nil {
+			t.Fatal("failed to download s3 file: " + err.Error())
+		}
+
+		downloadedMD5, err := util.ComputeMD5Sum(downloadLocation)
+		if err != nil {
+			t.Error("expected to be able to generated md5sum on downloaded file")
+		}
+
+		if string(createdMD5) != string(downloadedMD5) {
+			t.Error(fmt.Sprintf("expected md5s to match for %s level %d", compression.name, compression.level))
+		}
+	}
+}
+
+// Test 2 - Compression Testing
+//	Seed a gzip object without any metadata and download it
+func TestDownloadCompressedFileByExtension(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte("this is just a little test file"))
+	writer.Close()
+
+	server.PutObject("mockbucket", "seeded.gz", compressed.Bytes(), time.Now())
+
+	downloadLocation := "../mySeededTestDownload"
+
+	err := DownloadFile(server.Client(), DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        "seeded.gz",
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         50,
+	})
+	if err != nil {
+		t.Fatal("failed to download s3 file: " + err.Error())
+	}
+
+	downloaded, _ := ioutil.ReadFile(downloadLocation)
+	if string(downloaded) != "this is just a little test file" {
+		t.Error("expected the downloaded file to be decompressed: " + string(downloaded))
+	}
+}
+
+//----------------------------------------------
+// Zip Archive Testing (mock S3)
+//	1: A nested directory uploaded as a zip archive is extracted to the same tree
+//	2: Zip entries which would be extracted outside of the download location are rejected
+//----------------------------------------------
+
+// Test 1 - Zip Archive Testing
+//	The archive is larger than the part size so that it is streamed with a multipart upload
+func TestDownloadZipArchive(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+	mockSvc := server.Client()
+
+	srcDir := filepath.Join(t.TempDir(), "tree")
+	expectedFiles := map[string][]byte{
+		"top.txt":             []byte("this is just a little test file"),
+		"a/middle.txt":        []byte("a file in a directory"),
+		"a/b/c/deep.txt":      []byte("a file in a nested directory"),
+		"a/b/random.bin":      make([]byte, 12*1024*1024),
+		"with space/file.txt": []byte("a file in a directory with a space"),
+	}
+	rand.New(rand.NewSource(1)).Read(expectedFiles["a/b/random.bin"]) // Incompressible so the archive spans multiple parts
+
+	for path, contents := range expectedFiles {
+		fullPath := filepath.Join(srcDir, filepath.FromSlash(path))
+		os.MkdirAll(filepath.Dir(fullPath), 0755)
+		if err := ioutil.WriteFile(fullPath, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.MkdirAll(filepath.Join(srcDir, "empty"), 0755)
+
+	uploadObject := upload.UploadObject{
+		PathToFile: srcDir,
+		S3FileName: "tree",
+		Bucket:     "mockbucket",
+		Timeout:    timeout,
+		NumWorkers: 5,
+		PartSize:   5,
+	}
+
+	s3FileName, err := upload.UploadZip(mockSvc, uploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload zip archive without any error: %v", err))
+	}
+
+	if s3FileName != "tree.zip" {
+		t.Error("expected the key to have the '.zip' extension: " + s3FileName)
+	}
+
+	if len(server.Requests("UploadPart")) < 2 {
+		t.Error("expected the archive to be streamed with a multipart upload")
+	}
+
+	downloadLocation := filepath.Join(t.TempDir(), "restored")
+
+	err = DownloadFile(mockSvc, DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        s3FileName,
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+	})
+	if err != nil {
+		t.Fatal("failed to download s3 file: " + err.Error())
+	}
+
+	for path, expected := range expectedFiles {
+		contents, err := ioutil.ReadFile(filepath.Join(downloadLocation, filepath.FromSlash(path)))
+		if err != nil {
+			t.Error(fmt.Sprintf("expected '%s' to be extracted: %v", path, err))
+			continue
+		}
+		if !bytes.Equal(contents, expected) {
+			t.Error(fmt.Sprintf("expected '%s' to match the original file", path))
+		}
+	}
+
+	if info, err := os.Stat(filepath.Join(downloadLocation, "empty")); err != nil || !info.IsDir() {
+		t.Error("expected the empty directory to be extracted")
+	}
+
+	leftovers, _ := filepath.Glob(downloadLocation + ".*")
+	if len(leftovers) != 0 {
+		t.Error(fmt.Sprintf("expected the downloaded archive to be removed after extraction: %v", leftovers))
+	}
+}
+
+// Test 2 - Zip Archive Testing
+//	Seed an archive with an entry containing '..'
+func TestDownloadZipArchiveTraversal(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	entry, _ := zipWriter.Create("../escaped.txt")
+	entry.Write([]byte("this should not be extracted"))
+	zipWriter.Close()
+
+	server.PutObject("mockbucket", "malicious.zip", archive.Bytes(), time.Now())
+
+	dir := t.TempDir()
+
+	err := DownloadFile(server.Client(), DownloadObject{
+		DownloadLocation: filepath.Join(dir, "restored"),
+		S3FileKey:        "malicious.zip",
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+	})
+
+	expectedErrString := "would be extracted outside of"
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "escaped.txt")); err == nil {
+		t.Error("expected the entry not to be extracted outside of the download location")
+	}
+}
+
+//----------------------------------------------
+// Chunked Upload Testing (mock S3)
+//	1: A file uploaded in chunks is reassembled into the download location
+//	2: Download fails when a chunk does not match the hash recorded in the manifest
+//----------------------------------------------
+
+// Test 1 - Chunked Upload Testing
+//	A file uploaded in chunks is reassembled into the download location
+func TestDownloadChunkedFile(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	expected, s3FileName := uploadChunkedTestFile(t, server)
+
+	downloadLocation := filepath.Join(t.TempDir(), "restored")
+	err := DownloadFile(server.Client(), DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        s3FileName,
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+	})
+	if err != nil {
+		t.Fatal("failed to download s3 file: " + err.Error())
+	}
+
+	contents, err := ioutil.ReadFile(downloadLocation)
+	if err != nil || !bytes.Equal(contents, expected) {
+		t.Error("expected the reassembled file to match the original file")
+	}
+}
+
+// Test 2 - Chunked Upload Testing
+//	Corrupt the contents of a stored chunk
+func TestDownloadChunkedFileCorruptChunk(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	_, s3FileName := uploadChunkedTestFile(t, server)
+
+	for _, key := range server.Keys("mockbucket") {
+		if strings.HasPrefix(key, upload.ChunkDir) {
+			server.Object("mockbucket", key).Body[0] ^= 0xff
+			break
+		}
+	}
+
+	err := DownloadFile(server.Client(), DownloadObject{
+		DownloadLocation: filepath.Join(t.TempDir(), "restored"),
+		S3FileKey:        s3FileName,
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+	})
+
+	expectedErrString := "does not match the hash recorded in the manifest"
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+// Split Upload Testing (mock S3)
+//	1: A file split into volumes is reassembled exactly into the download location
+//	2: Download fails when a volume does not match the md5sum recorded in the index
+//	3: Verify only streams every volume without writing to the download location
+//----------------------------------------------
+
+// Test 1 - Split Upload Testing
+//	An 11MiB file split into volumes of 5MiB is reassembled from its 3 volumes
+func TestDownloadSplitFile(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	expected, s3FileName := uploadSplitTestFile(t, server)
+
+	for i, size := range []int{5 * 1024 * 1024, 5 * 1024 * 1024, 1024 * 1024} {
+		volume := server.Object("mockbucket", s3client.VolumeKey("", s3FileName, i+1))
+		if volume == nil || len(volume.Body) != size {
+			t.Fatal(fmt.Sprintf("expected volume %d to be stored as a separate object of %d bytes", i+1, size))
+		}
+	}
+
+	downloadLocation := filepath.Join(t.TempDir(), "restored")
+	err := DownloadFile(server.Client(), DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        s3FileName,
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+	})
+	if err != nil {
+		t.Fatal("failed to download s3 file: " + err.Error())
+	}
+
+	contents, err := ioutil.ReadFile(downloadLocation)
+	if err != nil || !bytes.Equal(contents, expected) {
+		t.Error("expected the reassembled file to match the original file")
+	}
+}
+
+// Test 2 - Split Upload Testing
+//	Corrupt the contents of the second volume
+func TestDownloadSplitFileCorruptVolume(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	_, s3FileName := uploadSplitTestFile(t, server)
+	server.Object("mockbucket", s3client.VolumeKey("", s3FileName, 2)).Body[0] ^= 0xff
+
+	err := DownloadFile(server.Client(), DownloadObject{
+		DownloadLocation: filepath.Join(t.TempDir(), "restored"),
+		S3FileKey:        s3FileName,
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+	})
+
+	expectedErrString := "does not match the size and md5sum recorded in the index"
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 3 - Split Upload Testing
+//	Verify a file split into volumes without downloading it
+func TestVerifySplitFile(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	_, s3FileName := uploadSplitTestFile(t, server)
+
+	downloadLocation := filepath.Join(t.TempDir(), "restored")
+	err := DownloadFile(server.Client(), DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        s3FileName,
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+		VerifyOnly:       true,
+	})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the volumes to be verified without any error: %v", err))
+	}
+	if _, err = os.Stat(downloadLocation); !os.IsNotExist(err) {
+		t.Error("expected nothing to be written to the download location")
+	}
+}
+
+// Uploads a file of 11MiB of random contents split into volumes of 5MiB and returns its contents and the key of its index
+func uploadSplitTestFile(t *testing.T, server *s3mock.Server) ([]byte, string) {
+	contents := make([]byte, 11*1024*1024)
+	rand.New(rand.NewSource(1)).Read(contents)
+
+	pathToFile := filepath.Join(t.TempDir(), "split")
+	if err := ioutil.WriteFile(pathToFile, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s3FileName, err := upload.UploadSplit(server.Client(), upload.UploadObject{
+		PathToFile: pathToFile,
+		S3FileName: "split",
+		Bucket:     "mockbucket",
+		Timeout:    timeout,
+		NumWorkers: 5,
+		PartSize:   5,
+		SplitSize:  5,
+	}, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file split into volumes without any error: %v", err))
+	}
+
+	return contents, s3FileName
+}
+
+//----------------------------------------------
+// Encryption Testing (mock S3)
+//	1: An encrypted object and an encrypted compressed object are decrypted into the download location
+//	2: Download fails with the wrong key without leaving the download location behind
+//	3: Download fails for an encrypted object without a key
+//	4: Verify only decrypts an encrypted object and verifies it against its recorded checksum
+//----------------------------------------------
+
+// Test 1 - Encryption Testing
+//	Download a multipart encrypted object and an object compressed with gzip and then encrypted
+func TestDownloadEncrypted(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	pathToFile := createVerifyTestFile(t)
+	expected, _ := ioutil.ReadFile(pathToFile)
+
+	for _, compression := range []string{"", "gzip"} {
+		key := uploadEncryptedTestFile(t, server, pathToFile, compression)
+
+		downloadLocation := filepath.Join(t.TempDir(), "backup")
+		downloadObject := encryptedDownloadObject(key, downloadLocation)
+		if err := DownloadFile(server.Client(), downloadObject); err != nil {
+			t.Fatal(fmt.Sprintf("expected to download '%s' without any error: %v", key, err))
+		}
+
+		contents, err := ioutil.ReadFile(downloadLocation)
+		if err != nil || !bytes.Equal(contents, expected) {
+			t.Error(fmt.Sprintf("expected '%s' to be decrypted into the download location", key))
+		}
+		if matches, _ := filepath.Glob(downloadLocation + ".*"); len(matches) != 0 {
+			t.Error(fmt.Sprintf("expected the temporary files to be removed but got: %v", matches))
+		}
+	}
+}
+
+// Test 2 - Encryption Testing
+//	Download an encrypted object with different key material
+func TestDownloadEncryptedWrongKey(t *testing.T) {
+	expectedErrString := "the key is wrong"
+
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	key := uploadEncryptedTestFile(t, server, createVerifyTestFile(t), "")
+
+	downloadLocation := filepath.Join(t.TempDir(), "backup")
+	downloadObject := encryptedDownloadObject(key, downloadLocation)
+	downloadObject.EncryptionKey = []byte("the wrong key material")
+
+	err := DownloadFile(server.Client(), downloadObject)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if _, err = os.Stat(downloadLocation); !os.IsNotExist(err) {
+		t.Error("expected nothing decrypted with the wrong key to be left in the download location")
+	}
+}
+
+// Test 3 - Encryption Testing
+//	Download an encrypted object without key material
+func TestDownloadEncryptedNoKey(t *testing.T) {
+	expectedErrString := "is encrypted, a key file or the S3BACKUP_PASSPHRASE environment variable must be specified"
+
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	key := uploadEncryptedTestFile(t, server, createVerifyTestFile(t), "")
+
+	downloadObject := encryptedDownloadObject(key, filepath.Join(t.TempDir(), "backup"))
+	downloadObject.EncryptionKey = nil
+
+	err := DownloadFile(server.Client(), downloadObject)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if len(server.Requests("GetObject")) != 0 {
+		t.Error("expected the object not to be downloaded")
+	}
+}
+
+// Test 4 - Encryption Testing
+//	Verify an encrypted object and the same object corrupted after upload
+func TestVerifyOnlyEncrypted(t *testing.T) {
+	expectedErrString := "failed to decrypt"
+
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	key := uploadEncryptedTestFile(t, server, createVerifyTestFile(t), "gzip")
+
+	downloadLocation := filepath.Join(t.TempDir(), "backup")
+	downloadObject := encryptedDownloadObject(key, downloadLocation)
+	downloadObject.VerifyOnly = true
+	if err := DownloadFile(server.Client(), downloadObject); err != nil {
+		t.Fatal(fmt.Sprintf("expected the encrypted object to be verified without any error: %v", err))
+	}
+
+	obj := server.Object("mockbucket", key)
+	obj.Body[len(obj.Body)/2] ^= 0xff
+
+	err := DownloadFile(server.Client(), downloadObject)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if _, err = os.Stat(downloadLocation); !os.IsNotExist(err) {
+		t.Error("expected nothing to be written with verify only")
+	}
+}
+
+// The key material objects are encrypted with during testing
+var testEncryptionKey = []byte("correct horse battery staple")
+
+// Uploads the file encrypted with the test key and its checksum recorded, compressed with the algorithm if one is
+// specified, and returns its key
+func uploadEncryptedTestFile(t *testing.T, server *s3mock.Server, pathToFile string, compression string) string {
+	key, err := upload.UploadFile(server.Client(), upload.UploadObject{
+		PathToFile:      pathToFile,
+		S3FileName:      "encrypted" + compression,
+		Bucket:          "mockbucket",
+		Timeout:         timeout,
+		NumWorkers:      5,
+		PartSize:        5,
+		SkipIfUnchanged: true, // Records the checksum of the file in the metadata
+		Compression:     compression,
+		EncryptionKey:   testEncryptionKey,
+	}, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload encrypted file without any error: %v", err))
+	}
+	return key
+}
+
+func encryptedDownloadObject(key string, downloadLocation string) DownloadObject {
+	return DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        key,
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+		EncryptionKey:    testEncryptionKey,
+	}
+}
+
+//----------------------------------------------
+// Export Testing (mock S3)
+//	1: Every object under the prefix is written to the tar with its relative name and contents
+//	2: Export fails when an object is shorter than it was listed
+//----------------------------------------------
+
+// Test 1 - Export Testing
+//	Every object under the prefix is written to the tar with its relative name and contents
+func TestExportTar(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	expected := map[string][]byte{
+		"daily_portfolio_20240101T000000": []byte("the first backup"),
+		"weekly_portfolio_20231231T000000": []byte("a weekly backup which spans several ranges"),
+		"nested/monthly_portfolio":         []byte("x"),
+		"empty":                            {},
+	}
+	for name, contents := range expected {
+		server.PutObject("mockbucket", "backups/"+name, contents, time.Now())
+	}
+	server.PutObject("mockbucket", "backups/folder/", []byte{}, time.Now())
+	server.PutObject("mockbucket", "other/daily_portfolio_20240101T000000", []byte("not under the prefix"), time.Now())
+
+	var tarball bytes.Buffer
+	exportedKeys, err := ExportTar(server.Client(), "mockbucket", "backups/", 8, &tarball)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to export without any error: %v", err))
+	}
+	if len(exportedKeys) != len(expected) {
+		t.Error(fmt.Sprintf("expected %d keys to be exported but got %v", len(expected), exportedKeys))
+	}
+
+	tr := tar.NewReader(&tarball)
+	found := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected a valid tar: %v", err))
+		}
+
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, ok := expected[header.Name]; !ok || !bytes.Equal(contents, want) {
+			t.Error(fmt.Sprintf("unexpected entry '%s' in the tar with contents '%s'", header.Name, contents))
+		}
+		found++
+	}
+
+	if found != len(expected) {
+		t.Error(fmt.Sprintf("expected %d entries in the tar but found %d", len(expected), found))
+	}
+
+	// The weekly backup spans 6 ranges of 8 bytes and the empty object is never requested
+	if len(server.Requests("GetObject")) != 9 {
+		t.Error(fmt.Sprintf("expected 9 ranged requests but %d were made", len(server.Requests("GetObject"))))
+	}
+}
+
+// Test 2 - Export Testing
+//	Truncate an object between listing and download
+func TestExportTarObjectChanged(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	server.PutObject("mockbucket", "backups/daily_portfolio", []byte("the contents of a backup"), time.Now())
+
+	// Truncate the object once it has been listed
+	server.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "GetObject" {
+			server.Object("mockbucket", "backups/daily_portfolio").Body = []byte("short")
+		}
+		return nil
+	})
+
+	_, err := ExportTar(server.Client(), "mockbucket", "backups/", 1024, ioutil.Discard)
+
+	expectedErrString := "may have changed during the export"
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Uploads a file of random contents in chunks and returns its contents and the key of its manifest
+func uploadChunkedTestFile(t *testing.T, server *s3mock.Server) ([]byte, string) {
+	contents := make([]byte, 6*1024*1024)
+	rand.New(rand.NewSource(1)).Read(contents)
+
+	pathToFile := filepath.Join(t.TempDir(), "chunked")
+	if err := ioutil.WriteFile(pathToFile, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s3FileName, err := upload.UploadChunked(server.Client(), upload.UploadObject{
+		PathToFile: pathToFile,
+		S3FileName: "chunked",
+		Bucket:     "mockbucket",
+		Timeout:    timeout,
+		NumWorkers: 5,
+		PartSize:   5,
+		ChunkSize:  1,
+	}, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file in chunks without any error: %v", err))
+	}
+
+	return contents, s3FileName
+}
+
+//----------------------------------------------
+// Latest Testing (mock S3)
+//	1: The newest backup older than the min age is selected over a backup modified within the min age
+//	2: The newest backup in any rotation tier is selected without a min age
+//	3: Selection fails when every backup was modified within the min age
+//----------------------------------------------
+
+// Test 1 - Latest Testing
+//	The newest backup older than the min age is selected over a backup modified within the min age
+func TestFindLatestMinAge(t *testing.T) {
+	server := latestTestServer()
+	defer server.Close()
+
+	key, err := FindLatest(server.Client(), "mockbucket", "backups/", "portfolio", time.Minute*10)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to find the latest backup without any error: %v", err))
+	}
+	if key != "backups/weekly_portfolio_20240107T000000.zst" {
+		t.Error(fmt.Sprintf("expected the newest backup older than the min age to be selected but got: '%s'", key))
+	}
+}
+
+// Test 2 - Latest Testing
+//	The newest backup in any rotation tier is selected without a min age
+func TestFindLatestWithoutMinAge(t *testing.T) {
+	server := latestTestServer()
+	defer server.Close()
+
+	key, err := FindLatest(server.Client(), "mockbucket", "backups/", "portfolio", 0)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to find the latest backup without any error: %v", err))
+	}
+	if key != "backups/daily_portfolio_20240108T000000.zst" {
+		t.Error(fmt.Sprintf("expected the newest backup to be selected but got: '%s'", key))
+	}
+}
+
+// Test 3 - Latest Testing
+//	Every backup was modified within the min age
+func TestFindLatestNoneOldEnough(t *testing.T) {
+	expectedErrString := "no backup of 'portfolio' under 'backups/' older than the min age of 24h0m0s was found"
+
+	server := latestTestServer()
+	defer server.Close()
+
+	_, err := FindLatest(server.Client(), "mockbucket", "backups/", "portfolio", time.Hour*24)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns a server with a backup which has only just been written, older backups of the file in other tiers and
+// newer objects which are not backups of the file
+func latestTestServer() *s3mock.Server {
+	server := s3mock.New("mockbucket")
+	now := time.Now()
+
+	server.PutObject("mockbucket", "backups/daily_portfolio_20240108T000000.zst", []byte("in flight"), now.Add(-time.Minute))
+	server.PutObject("mockbucket", "backups/weekly_portfolio_20240107T000000.zst", []byte("durable"), now.Add(-time.Hour))
+	server.PutObject("mockbucket", "backups/daily_portfolio_20240106T000000.zst", []byte("older"), now.Add(-time.Hour*2))
+	server.PutObject("mockbucket", "backups/daily_portfolioAlbum_20240107T120000", []byte("another file"), now.Add(-time.Minute*30))
+	server.PutObject("mockbucket", "backups/nested/daily_portfolio_20240107T120000", []byte("another directory"), now.Add(-time.Minute*30))
+	server.PutObject("mockbucket", "backups/portfolio.lock", []byte("not a backup"), now.Add(-time.Minute*30))
+	return server
+}
+
+//----------------------------------------------
+// Directory Creation Testing (mock S3)
+//	1: A download to a deep path which does not exist creates the directories and writes the file
+//	2: A compressed download to a deep path which does not exist is decompressed into it
+//	3: The permissions and modification times of a zip archive are restored when metadata is preserved
+//----------------------------------------------
+
+// Test 1 - Directory Creation Testing
+//	Download to a path whose parent directories do not exist
+func TestDownloadCreatesDirectories(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+	server.PutObject("mockbucket", "backup", []byte("this is just a little test file"), time.Now())
+
+	downloadLocation := filepath.Join(t.TempDir(), "restore", "2024", "01", "backup")
+
+	err := DownloadFile(server.Client(), DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        "backup",
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+	})
+	if err != nil {
+		t.Fatal("failed to download s3 file: " + err.Error())
+	}
+
+	contents, err := ioutil.ReadFile(downloadLocation)
+	if err != nil || string(contents) != "this is just a little test file" {
+		t.Error(fmt.Sprintf("expected the file to be written to '%s': %v", downloadLocation, err))
+	}
+}
+
+// Test 2 - Directory Creation Testing
+//	Download a compressed object to a path whose parent directories do not exist
+func TestDownloadCompressedCreatesDirectories(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	gzipWriter.Write([]byte("this is just a little test file"))
+	gzipWriter.Close()
+	server.PutObject("mockbucket", "backup.gz", compressed.Bytes(), time.Now())
+
+	downloadLocation := filepath.Join(t.TempDir(), "restore", "nested", "backup")
+
+	err := DownloadFile(server.Client(), DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        "backup.gz",
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+	})
+	if err != nil {
+		t.Fatal("failed to download s3 file: " + err.Error())
+	}
+
+	contents, err := ioutil.ReadFile(downloadLocation)
+	if err != nil || string(contents) != "this is just a little test file" {
+		t.Error(fmt.Sprintf("expected the file to be decompressed into '%s': %v", downloadLocation, err))
+	}
+}
+
+// Test 3 - Directory Creation Testing
+//	Download a zip archive with restricted directories to a path whose parent directories do not exist
+func TestDownloadZipPreserveMetadata(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	modified := time.Date(2020, time.March, 1, 10, 30, 0, 0, time.UTC)
+
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	for _, entry := range []struct {
+		name string
+		mode os.FileMode
+	}{{"private/", os.ModeDir | 0700}, {"private/secret.txt", 0600}, {"public/", os.ModeDir | 0755}, {"public/readme.txt", 0644}} {
+		header := &zip.FileHeader{Name: entry.name, Modified: modified}
+		header.SetMode(entry.mode)
+		w, _ := zipWriter.CreateHeader(header)
+		if !entry.mode.IsDir() {
+			w.Write([]byte("the contents of " + entry.name))
+		}
+	}
+	zipWriter.Close()
+	server.PutObject("mockbucket", "tree.zip", archive.Bytes(), time.Now())
+
+	for _, preserveMetadata := range []bool{false, true} {
+		downloadLocation := filepath.Join(t.TempDir(), "restore", "nested", "tree")
+
+		err := DownloadFile(server.Client(), DownloadObject{
+			DownloadLocation: downloadLocation,
+			S3FileKey:        "tree.zip",
+			Bucket:           "mockbucket",
+			NumWorkers:       5,
+			PartSize:         5,
+			PreserveMetadata: preserveMetadata,
+		})
+		if err != nil {
+			t.Fatal("failed to download s3 file: " + err.Error())
+		}
+
+		contents, err := ioutil.ReadFile(filepath.Join(downloadLocation, "private", "secret.txt"))
+		if err != nil || string(contents) != "the contents of private/secret.txt" {
+			t.Error(fmt.Sprintf("expected the archive to be extracted into '%s': %v", downloadLocation, err))
+		}
+
+		info, err := os.Stat(filepath.Join(downloadLocation, "private"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if preserveMetadata && (info.Mode().Perm() != 0700 || !info.ModTime().Equal(modified)) {
+			t.Error(fmt.Sprintf("expected the permissions and modification time of the directory to be restored: %s %s", info.Mode(), info.ModTime()))
+		}
+		if !preserveMetadata && info.Mode().Perm() == 0700 {
+			t.Error("expected the permissions of the directory not to be restored unless metadata is preserved")
+		}
+
+		if info, err = os.Stat(filepath.Join(downloadLocation, "public", "readme.txt")); err != nil || (preserveMetadata && !info.ModTime().Equal(modified)) {
+			t.Error(fmt.Sprintf("expected the modification time of the file to be restored: %v", err))
+		}
+	}
+}
+
+//----------------------------------------------
+// Download To Directory Testing (mock S3)
+//	1: A download to an existing directory writes a file named after the base name of the key
+//	2: The extensions of an object compressed and encrypted on upload are removed from the name of the file
+//----------------------------------------------
+
+// Test 1 - Download To Directory Testing
+//	Download a key under a bucket dir to an existing directory
+func TestDownloadToDirectory(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+	server.PutObject("mockbucket", "backups/daily_album_20240101T020000", []byte("this is just a little test file"), time.Now())
+
+	downloadDir := t.TempDir()
+
+	err := DownloadFile(server.Client(), DownloadObject{
+		DownloadLocation: downloadDir,
+		S3FileKey:        "backups/daily_album_20240101T020000",
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+	})
+	if err != nil {
+		t.Fatal("failed to download s3 file: " + err.Error())
+	}
+
+	downloadLocation := filepath.Join(downloadDir, "daily_album_20240101T020000")
+	contents, err := ioutil.ReadFile(downloadLocation)
+	if err != nil || string(contents) != "this is just a little test file" {
+		t.Error(fmt.Sprintf("expected the file to be written to '%s': %v", downloadLocation, err))
+	}
+	if entries, _ := ioutil.ReadDir(downloadDir); len(entries) != 1 {
+		t.Error(fmt.Sprintf("expected only the downloaded file in the directory but got %d entries", len(entries)))
+	}
+}
+
+// Test 2 - Download To Directory Testing
+//	Download an object compressed with gzip and then encrypted to an existing directory
+func TestDownloadToDirectoryCompressedEncrypted(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	pathToFile := createVerifyTestFile(t)
+	expected, _ := ioutil.ReadFile(pathToFile)
+	key := uploadEncryptedTestFile(t, server, pathToFile, "gzip")
+
+	downloadDir := t.TempDir()
+	if err := DownloadFile(server.Client(), encryptedDownloadObject(key, downloadDir)); err != nil {
+		t.Fatal(fmt.Sprintf("expected to download '%s' without any error: %v", key, err))
+	}
+
+	downloadLocation := filepath.Join(downloadDir, "encryptedgzip")
+	contents, err := ioutil.ReadFile(downloadLocation)
+	if err != nil || !bytes.Equal(contents, expected) {
+		t.Error(fmt.Sprintf("expected '%s' to be decrypted and decompressed into '%s': %v", key, downloadLocation, err))
+	}
+	if entries, _ := ioutil.ReadDir(downloadDir); len(entries) != 1 {
+		t.Error(fmt.Sprintf("expected the temporary files to be removed but got %d entries", len(entries)))
+	}
+}
+
+//----------------------------------------------
+// Verify Only Testing (mock S3)
+//	1: A multipart object and a compressed object are verified against their recorded checksum and ETag without writing a file
+//	2: Verification fails for an object corrupted after upload without writing a file
+//	3: An SSE-KMS object is verified against its recorded checksum alone
+//	4: Verification fails for an SSE-KMS object without a recorded checksum
+//----------------------------------------------
+
+// Test 1 - Verify Only Testing
+//	Verify a multipart object and objects compressed with each algorithm
+func TestVerifyOnly(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	pathToFile := createVerifyTestFile(t)
+	for _, compression := range []string{"", "gzip", "zstd"} {
+		key := uploadVerifyTestFile(t, server, pathToFile, compression)
+		if compression == "" && len(server.Object("mockbucket", key).Parts) != 3 {
+			t.Fatal("expected the file to be uploaded in 3 parts")
+		}
+
+		downloadLocation := filepath.Join(t.TempDir(), "restore", "backup")
+		err := DownloadFile(server.Client(), verifyDownloadObject(key, downloadLocation))
+		if err != nil {
+			t.Error(fmt.Sprintf("expected '%s' to be verified without any error: %v", key, err))
+		}
+
+		if _, err = os.Stat(filepath.Dir(downloadLocation)); !os.IsNotExist(err) {
+			t.Error("expected nothing to be written with verify only")
+		}
+	}
+
+	if len(server.Requests("GetObject")) != 3 {
+		t.Error(fmt.Sprintf("expected each object to be streamed with a single GetObject, got %d", len(server.Requests("GetObject"))))
+	}
+}
+
+// Test 2 - Verify Only Testing
+//	Corrupt a byte of an object with and without a recorded checksum
+func TestVerifyOnlyCorrupted(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	pathToFile := createVerifyTestFile(t)
+	key := uploadVerifyTestFile(t, server, pathToFile, "")
+
+	// An object put without s3backup has no recorded checksum so only its ETag is verified
+	contents, _ := ioutil.ReadFile(pathToFile)
+	server.PutObject("mockbucket", "unrecorded", contents[:1024], time.Now())
+
+	for expectedErrString, corruptedKey := range map[string]string{
+		"does not match the checksum": key,
+		"does not match the ETag":     "unrecorded",
+	} {
+		obj := server.Object("mockbucket", corruptedKey)
+		obj.Body[len(obj.Body)/2] ^= 0xff
+
+		downloadLocation := filepath.Join(t.TempDir(), "backup")
+		err := DownloadFile(server.Client(), verifyDownloadObject(corruptedKey, downloadLocation))
+		if err != nil && strings.Contains(err.Error(), expectedErrString) {
+			// Pass
+		} else {
+			t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+		}
+
+		if _, err = os.Stat(downloadLocation); !os.IsNotExist(err) {
+			t.Error("expected nothing to be written with verify only")
+		}
+	}
+}
+
+// Test 3 - Verify Only Testing
+//	Verify an object encrypted with SSE-KMS whose ETag is not an md5sum
+func TestVerifyOnlyKMS(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+	server.SetBucketEncryption("mockbucket", s3.ServerSideEncryptionAwsKms)
+
+	key := uploadVerifyTestFile(t, server, createVerifyTestFile(t), "")
+
+	err := DownloadFile(server.Client(), verifyDownloadObject(key, filepath.Join(t.TempDir(), "backup")))
+	if err != nil {
+		t.Error(fmt.Sprintf("expected the SSE-KMS object to be verified against its recorded checksum: %v", err))
+	}
+}
+
+// Test 4 - Verify Only Testing
+//	Verify an object encrypted with SSE-KMS without a recorded checksum
+func TestVerifyOnlyKMSUnrecorded(t *testing.T) {
+	expectedErrString := "cannot be verified as its ETag is not an md5sum"
+
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+	server.PutObject("mockbucket", "unrecorded", []byte("this is just a little test file"), time.Now())
+	server.SetObjectHeader("mockbucket", "unrecorded", "X-Amz-Server-Side-Encryption", s3.ServerSideEncryptionAwsKms)
+
+	err := DownloadFile(server.Client(), verifyDownloadObject("unrecorded", filepath.Join(t.TempDir(), "backup")))
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Creates a 12MiB file of random contents which is uploaded in 3 parts of 5MiB
+func createVerifyTestFile(t *testing.T) string {
+	contents := make([]byte, 12*1024*1024)
+	rand.Read(contents)
+
+	pathToFile := filepath.Join(t.TempDir(), "verify")
+	if err := util.CreateFile(pathToFile, contents); err != nil {
+		t.Fatal(err)
+	}
+	return pathToFile
+}
+
+// Uploads the file with its checksum recorded, compressed with the algorithm if one is specified, and returns its key
+func uploadVerifyTestFile(t *testing.T, server *s3mock.Server, pathToFile string, compression string) string {
+	key, err := upload.UploadFile(server.Client(), upload.UploadObject{
+		PathToFile:      pathToFile,
+		S3FileName:      "verify" + compression,
+		Bucket:          "mockbucket",
+		Timeout:         timeout,
+		NumWorkers:      5,
+		PartSize:        5,
+		SkipIfUnchanged: true, // Records the checksum of the file in the metadata
+		Compression:     compression,
+	}, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+	return key
+}
+
+func verifyDownloadObject(key string, downloadLocation string) DownloadObject {
+	return DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        key,
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+		VerifyOnly:       true,
+	}
+}
+
+//----------------------------------------------
+// Compare Testing (mock S3)
+//	1: A multipart object is compared by the ETag of the local file without downloading it
+//	2: A local file which differs from the object does not match
+//	3: A compressed object and a split upload are compared by the checksum recorded on upload
+//	4: An SSE-KMS object without a recorded checksum is streamed through a hasher
+//----------------------------------------------
+
+// Test 1 - Compare Testing
+//	Compare a file uploaded in 3 parts of 5MiB
+func TestCompareFileETag(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	pathToFile := createVerifyTestFile(t)
+	key := uploadVerifyTestFile(t, server, pathToFile, "")
+
+	comparison, err := CompareFile(server.Client(), "mockbucket", key, pathToFile)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to compare the file without any error: %v", err))
+	}
+	if !comparison.Match || comparison.Method != CompareMethodETag || !strings.HasSuffix(comparison.Remote, "-3") {
+		t.Error(fmt.Sprintf("expected the file to match the multipart ETag of the object but got: %+v", comparison))
+	}
+	if len(server.Requests("GetObject")) != 0 {
+		t.Error("expected the object not to be downloaded")
+	}
+}
+
+// Test 2 - Compare Testing
+//	Compare a file with a byte changed and with a byte appended
+func TestCompareFileMismatch(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	pathToFile := createVerifyTestFile(t)
+	key := uploadVerifyTestFile(t, server, pathToFile, "")
+	contents, _ := ioutil.ReadFile(pathToFile)
+
+	changed := append([]byte{}, contents...)
+	changed[len(changed)/2] ^= 0xff
+	for _, modified := range [][]byte{changed, append(contents, 0)} {
+		if err := ioutil.WriteFile(pathToFile, modified, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		comparison, err := CompareFile(server.Client(), "mockbucket", key, pathToFile)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to compare the file without any error: %v", err))
+		}
+		if comparison.Match {
+			t.Error(fmt.Sprintf("expected the modified file not to match the object: %+v", comparison))
+		}
+	}
+}
+
+// Test 3 - Compare Testing
+//	Compare a file with the object it was compressed to with gzip and with the index of a split upload
+func TestCompareFileCompressed(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	pathToFile := createVerifyTestFile(t)
+	key := uploadVerifyTestFile(t, server, pathToFile, "gzip")
+
+	comparison, err := CompareFile(server.Client(), "mockbucket", key, pathToFile)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to compare the file without any error: %v", err))
+	}
+	if !comparison.Match || comparison.Method != CompareMethodChecksum {
+		t.Error(fmt.Sprintf("expected the file to match the checksum recorded on upload but got: %+v", comparison))
+	}
+
+	contents, splitKey := uploadSplitTestFile(t, server)
+	splitFile := filepath.Join(t.TempDir(), "split")
+	if err = ioutil.WriteFile(splitFile, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	comparison, err = CompareFile(server.Client(), "mockbucket", splitKey, splitFile)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to compare the file without any error: %v", err))
+	}
+	if !comparison.Match || comparison.Method != CompareMethodChecksum {
+		t.Error(fmt.Sprintf("expected the file to match the checksum in the index of the split upload but got: %+v", comparison))
+	}
+}
+
+// Test 4 - Compare Testing
+//	Compare a file with an SSE-KMS object put without s3backup
+func TestCompareFileStream(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	pathToFile := createVerifyTestFile(t)
+	contents, _ := ioutil.ReadFile(pathToFile)
+	server.PutObject("mockbucket", "unrecorded", contents, time.Now())
+	server.SetObjectHeader("mockbucket", "unrecorded", "X-Amz-Server-Side-Encryption", s3.ServerSideEncryptionAwsKms)
+
+	comparison, err := CompareFile(server.Client(), "mockbucket", "unrecorded", pathToFile)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to compare the file without any error: %v", err))
+	}
+	if !comparison.Match || comparison.Method != CompareMethodStream {
+		t.Error(fmt.Sprintf("expected the file to match the streamed object but got: %+v", comparison))
+	}
+}
+
+//----------------------------------------------
+// Inode Check Testing (mock S3)
+//	1: A zip archive is not extracted when the filesystem has too few free inodes for its entries
+//	2: A zip archive is extracted when the filesystem of the nearest existing parent has enough free inodes
+//	3: Filesystems which do not report a fixed number of inodes are not checked
+//----------------------------------------------
+
+// Test 1 - Inode Check Testing
+//	Mock a filesystem with fewer free inodes than the entries plus the margin
+func TestCheckInodesInsufficient(t *testing.T) {
+	expectedErrString := "not enough free inodes to extract 3 entries"
+
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+	putInodeTestArchive(t, server)
+	mockInodes(t, 3+minInodeMargin-1, 1000)
+
+	downloadLocation := filepath.Join(t.TempDir(), "restored")
+	err := DownloadFile(server.Client(), inodeDownloadObject(downloadLocation))
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if _, err = os.Stat(downloadLocation); !os.IsNotExist(err) {
+		t.Error("expected nothing to be extracted when there are not enough free inodes")
+	}
+}
+
+// Test 2 - Inode Check Testing
+//	Extract into a directory whose parents do not exist yet
+func TestCheckInodesSufficient(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+	putInodeTestArchive(t, server)
+	statted := mockInodes(t, 3+minInodeMargin, 1000)
+
+	dir := t.TempDir()
+	downloadLocation := filepath.Join(dir, "2024", "restored")
+	err := DownloadFile(server.Client(), inodeDownloadObject(downloadLocation))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the archive to be extracted without any error: %v", err))
+	}
+
+	if _, err = os.Stat(filepath.Join(downloadLocation, "a", "b.txt")); err != nil {
+		t.Error(fmt.Sprintf("expected the archive to be extracted: %v", err))
+	}
+
+	if len(*statted) != 1 || (*statted)[0] != filepath.Join(dir, "2024") {
+		t.Error(fmt.Sprintf("expected the filesystem of the nearest existing parent to be checked: %v", *statted))
+	}
+}
+
+// Test 3 - Inode Check Testing
+//	Mock a filesystem which reports no inodes at all
+func TestCheckInodesDynamic(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+	putInodeTestArchive(t, server)
+	mockInodes(t, 0, 0)
+
+	err := DownloadFile(server.Client(), inodeDownloadObject(filepath.Join(t.TempDir(), "restored")))
+	if err != nil {
+		t.Error(fmt.Sprintf("expected a filesystem without a fixed number of inodes not to be checked: %v", err))
+	}
+}
+
+// Puts an archive of 3 entries, a directory and 2 files
+func putInodeTestArchive(t *testing.T, server *s3mock.Server) {
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	zipWriter.Create("a/")
+	for _, name := range []string{"a/b.txt", "c.txt"} {
+		entry, _ := zipWriter.Create(name)
+		entry.Write([]byte("this is just a little test file"))
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	server.PutObject("mockbucket", "small.zip", archive.Bytes(), time.Now())
+}
+
+// Mocks the free and total inodes of every filesystem until the test ends. Returns the paths which are checked
+func mockInodes(t *testing.T, free uint64, total uint64) *[]string {
+	statted := &[]string{}
+	original := statInodes
+	statInodes = func(path string) (uint64, uint64, error) {
+		*statted = append(*statted, path)
+		return free, total, nil
+	}
+	t.Cleanup(func() { statInodes = original })
+	return statted
+}
+
+func inodeDownloadObject(downloadLocation string) DownloadObject {
+	return DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        "small.zip",
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+		CheckInodes:      true,
+	}
+}
+
+//----------------------------------------------
+// Glacier Restore Testing (mock S3)
+//	1: The restore of an archived object is requested with the tier and days and the download fails as in progress
+//	2: The restore of an object whose restore is already in progress is not requested again
+//	3: The download waits for the restore to complete and then downloads the restored copy
+//	4: Download fails with an invalid restore tier
+//----------------------------------------------
+
+// Test 1 - Glacier Restore Testing
+//	Download an object in DEEP_ARCHIVE without waiting for its restore
+func TestDownloadRestoreRequested(t *testing.T) {
+	server := archivedTestServer(s3.StorageClassDeepArchive)
+	defer server.Close()
+
+	downloadLocation := filepath.Join(t.TempDir(), "backup")
+	downloadObject := archivedDownloadObject(downloadLocation)
+	downloadObject.Restore = RestoreObject{Tier: "bulk", Days: 3}
+
+	err := DownloadFile(server.Client(), downloadObject)
+	if _, ok := err.(*RestoreInProgressError); !ok || !strings.Contains(err.Error(), "restore of 'monthly_backup' from DEEP_ARCHIVE is in progress") {
+		t.Fatal(fmt.Sprintf("expected the download to fail as the restore is in progress but got: %v", err))
+	}
+
+	requests := server.Requests("RestoreObject")
+	if len(requests) != 1 || !strings.Contains(string(requests[0].Body), "<Days>3</Days>") || !strings.Contains(string(requests[0].Body), "<Tier>Bulk</Tier>") {
+		t.Error(fmt.Sprintf("expected a single Bulk restore kept for 3 days to be requested but got %d requests", len(requests)))
+	}
+	if len(server.Requests("GetObject")) != 0 {
+		t.Error("expected the archived object not to be downloaded")
+	}
+	if _, err := os.Stat(downloadLocation); !os.IsNotExist(err) {
+		t.Error("expected nothing to be written to the download location")
+	}
+}
+
+// Test 2 - Glacier Restore Testing
+//	Download an object in GLACIER whose restore was requested by a previous download
+func TestDownloadRestoreAlreadyInProgress(t *testing.T) {
+	server := archivedTestServer(s3.StorageClassGlacier)
+	defer server.Close()
+	server.SetObjectHeader("mockbucket", "monthly_backup", "X-Amz-Restore", `ongoing-request="true"`)
+
+	err := DownloadFile(server.Client(), archivedDownloadObject(filepath.Join(t.TempDir(), "backup")))
+	if _, ok := err.(*RestoreInProgressError); !ok {
+		t.Fatal(fmt.Sprintf("expected the download to fail as the restore is in progress but got: %v", err))
+	}
+	if len(server.Requests("RestoreObject")) != 0 {
+		t.Error("expected the restore not to be requested again")
+	}
+}
+
+// Test 3 - Glacier Restore Testing
+//	Download an object in GLACIER whose restore completes after it has been checked twice
+func TestDownloadRestoreWait(t *testing.T) {
+	server := archivedTestServer(s3.StorageClassGlacier)
+	defer server.Close()
+	restorePollInterval = time.Millisecond * 10
+
+	polls := 0
+	server.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "HeadObject" && len(server.Requests("RestoreObject")) > 0 {
+			if polls++; polls == 2 {
+				server.SetObjectHeader("mockbucket", "monthly_backup", "X-Amz-Restore", `ongoing-request="false", expiry-date="Fri, 23 Dec 2044 00:00:00 GMT"`)
+			}
+		}
+		return nil
+	})
+
+	downloadLocation := filepath.Join(t.TempDir(), "backup")
+	downloadObject := archivedDownloadObject(downloadLocation)
+	downloadObject.Restore.Wait = time.Minute
+
+	if err := DownloadFile(server.Client(), downloadObject); err != nil {
+		t.Fatal(fmt.Sprintf("expected to download the restored object without any error: %v", err))
+	}
+	contents, err := ioutil.ReadFile(downloadLocation)
+	if err != nil || string(contents) != "this is just a little test file" {
+		t.Error(fmt.Sprintf("expected the restored object to be written to '%s': %v", downloadLocation, err))
+	}
+
+	// The restored copy is downloaded again without another restore
+	if err := DownloadFile(server.Client(), archivedDownloadObject(downloadLocation)); err != nil || len(server.Requests("RestoreObject")) != 1 {
+		t.Error(fmt.Sprintf("expected the restored copy to be downloaded without another restore: %v", err))
+	}
+}
+
+// Test 4 - Glacier Restore Testing
+//	Download an object with a restore tier with a typo
+func TestDownloadRestoreInvalidTier(t *testing.T) {
+	expectedErrString := "restore tier must be one of Expedited, Standard or Bulk: 'Standrad'"
+
+	server := archivedTestServer(s3.StorageClassGlacier)
+	defer server.Close()
+
+	downloadObject := archivedDownloadObject(filepath.Join(t.TempDir(), "backup"))
+	downloadObject.Restore.Tier = "Standrad"
+
+	err := DownloadFile(server.Client(), downloadObject)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error: '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns a mock S3 server with an object archived in the storage class
+func archivedTestServer(storageClass string) *s3mock.Server {
+	server := s3mock.New("mockbucket")
+	server.PutObject("mockbucket", "monthly_backup", []byte("this is just a little test file"), time.Now())
+	server.SetObjectHeader("mockbucket", "monthly_backup", "X-Amz-Storage-Class", storageClass)
+	return server
+}
+
+func archivedDownloadObject(downloadLocation string) DownloadObject {
+	return DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        "monthly_backup",
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+	}
+}
+
+//----------------------------------------------
+// Restore Lock Testing (mock S3)
+//	1: A restore lock is held under the bucket dir while the backup is downloaded and released afterwards
+//	2: The restore lock is released when the download fails
+//----------------------------------------------
+
+// Test 1 - Restore Lock Testing
+//	Record the order of the requests to check the lock is held throughout the download
+func TestDownloadRestoreLock(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+	server.PutObject("mockbucket", "backups/small.txt", []byte("this is just a little test file"), time.Now())
+	requests := recordRestoreLockRequests(server)
+
+	err := DownloadFile(server.Client(), restoreLockDownloadObject(filepath.Join(t.TempDir(), "small.txt"), "backups/small.txt"))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the backup to be downloaded without any error: %v", err))
+	}
+
+	if len(*requests) != 3 || (*requests)[0] != "PutObject" || (*requests)[1] != "GetObject" || (*requests)[2] != "DeleteObject" {
+		t.Error(fmt.Sprintf("expected the restore lock to be acquired before and released after the download but got: %v", *requests))
+	}
+	for _, key := range server.Keys("mockbucket") {
+		if strings.HasPrefix(key, "backups/"+s3client.RestoreLockDir) {
+			t.Error(fmt.Sprintf("expected the restore lock to be released but found: %s", key))
+		}
+	}
+}
+
+// Test 2 - Restore Lock Testing
+//	Download a key which does not exist
+func TestDownloadRestoreLockReleasedOnFailure(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+	requests := recordRestoreLockRequests(server)
+
+	err := DownloadFile(server.Client(), restoreLockDownloadObject(filepath.Join(t.TempDir(), "missing.txt"), "backups/missing.txt"))
+	if err == nil {
+		t.Fatal("expected the download of a missing key to fail")
+	}
+
+	if len(*requests) != 2 || (*requests)[0] != "PutObject" || (*requests)[1] != "DeleteObject" {
+		t.Error(fmt.Sprintf("expected the restore lock to be released after the failed download but got: %v", *requests))
+	}
+	if keys := server.Keys("mockbucket"); len(keys) != 0 {
+		t.Error(fmt.Sprintf("expected the restore lock to be released but found: %v", keys))
+	}
+}
+
+// Records the operation of every request for a restore lock or a backup, ignoring the requests to head the backup
+func recordRestoreLockRequests(server *s3mock.Server) *[]string {
+	requests := &[]string{}
+	server.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation != "HeadObject" {
+			*requests = append(*requests, req.Operation)
+		}
+		return nil
+	})
+	return requests
+}
+
+func restoreLockDownloadObject(downloadLocation string, key string) DownloadObject {
+	return DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        key,
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         5,
+		RestoreLockTTL:   time.Hour,
+	}
+}
+
+//----------------------------------------------
+// Dir Manifest Testing (mock S3)
+//	1: A directory is verified against its manifest and the expected Merkle root
+//	2: Verification fails and names the file when a single stored file has changed
+//	3: Verification fails when the manifest does not record the expected Merkle root
+//----------------------------------------------
+
+// Test 1 - Dir Manifest Testing
+//	A directory is verified against its manifest and the expected Merkle root
+func TestVerifyDirManifest(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	expectedRoot := putDirManifestTestFiles(t, server)
+
+	root, err := VerifyDirManifest(server.Client(), "mockbucket", "photos"+upload.DirManifestSuffix, expectedRoot)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to verify the directory without any error: %v", err))
+	}
+	if root != expectedRoot {
+		t.Error(fmt.Sprintf("expected the verified root to be '%s' but got '%s'", expectedRoot, root))
+	}
+}
+
+// Test 2 - Dir Manifest Testing
+//	Verification fails and names the file when a single stored file has changed
+func TestVerifyDirManifestChangedFile(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	putDirManifestTestFiles(t, server)
+	server.PutObject("mockbucket", "photos/2019/b.jpg", []byte("the contents of B"), time.Now())
+
+	expectedErrString := "1 files differ: photos/2019/b.jpg"
+	_, err := VerifyDirManifest(server.Client(), "mockbucket", "photos"+upload.DirManifestSuffix, "")
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 3 - Dir Manifest Testing
+//	Verification fails when the manifest does not record the expected Merkle root
+func TestVerifyDirManifestUnexpectedRoot(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	putDirManifestTestFiles(t, server)
+
+	expectedErrString := "does not match the expected root"
+	_, err := VerifyDirManifest(server.Client(), "mockbucket", "photos"+upload.DirManifestSuffix, strings.Repeat("0", 64))
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if len(server.Requests("GetObject")) != 1 {
+		t.Error("expected only the manifest to be downloaded when its root is not the expected root")
+	}
+}
+
+// Uploads the files of a directory with a manifest to the key photos. Returns the Merkle root of the manifest
+func putDirManifestTestFiles(t *testing.T, server *s3mock.Server) string {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "2019"), 0755)
+	for _, name := range []string{"a.jpg", filepath.Join("2019", "b.jpg"), filepath.Join("2019", "c.jpg")} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("the contents of "+filepath.Base(name)), 0644)
+	}
+
+	uploadObject := upload.UploadObject{
+		PathToFile:  dir,
+		S3FileName:  "photos",
+		Bucket:      "mockbucket",
+		Timeout:     time.Hour,
+		NumWorkers:  5,
+		PartSize:    5,
+		DirManifest: true,
+	}
+	if _, err := upload.UploadDir(server.Client(), uploadObject, false); err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the directory without any error: %v", err))
+	}
+
+	obj := server.Object("mockbucket", "photos"+upload.DirManifestSuffix)
+	if obj == nil {
+		t.Fatal("expected the manifest to be uploaded")
+	}
+	var manifest upload.DirManifest
+	if err := json.Unmarshal(obj.Body, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	return manifest.Root
+}
+
+//----------------------------------------------
+// Download Resume Testing (mock S3)
+//	1: An interrupted download is kept and resumed by requesting only its remaining bytes
+//	2: A partial download of a key which changed since is downloaded again from the start
+//	3: A key which changes during the download fails the download rather than being spliced together
+//----------------------------------------------
+
+// Test 1 - Download Resume Testing
+//	Download an object in 1MB parts which fails after its first part and is then resumed
+func TestDownloadResume(t *testing.T) {
+	server, body := resumeTestServer()
+	defer server.Close()
+
+	interrupted := true
+	server.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if interrupted && req.Operation == "GetObject" && req.Header.Get("Range") != "bytes=0-1048575" {
+			return &s3mock.Error{StatusCode: 403, Code: "AccessDenied", Message: "Access Denied"}
+		}
+		return nil
+	})
+
+	downloadLocation := filepath.Join(t.TempDir(), "backup")
+	if err := DownloadFile(server.Client(), resumeDownloadObject(downloadLocation)); err == nil {
+		t.Fatal("expected the interrupted download to fail")
+	}
+	if info, err := os.Stat(downloadLocation); err != nil || info.Size() != 1048576 {
+		t.Fatal(fmt.Sprintf("expected the first part of the interrupted download to be kept: %v", err))
+	}
+	if _, err := os.Stat(downloadLocation + ResumeMarkerExtension); err != nil {
+		t.Fatal(fmt.Sprintf("expected the resume marker of the interrupted download to be kept: %v", err))
+	}
+
+	interrupted = false
+	requestsBefore := len(server.Requests("GetObject"))
+	if err := DownloadFile(server.Client(), resumeDownloadObject(downloadLocation)); err != nil {
+		t.Fatal(fmt.Sprintf("expected to resume the download without any error: %v", err))
+	}
+
+	var ranges []string
+	for _, req := range server.Requests("GetObject")[requestsBefore:] {
+		ranges = append(ranges, req.Header.Get("Range"))
+	}
+	expectedRanges := []string{"bytes=1048576-2097151", "bytes=2097152-2621439"}
+	if strings.Join(ranges, ",") != strings.Join(expectedRanges, ",") {
+		t.Error(fmt.Sprintf("expected only the remaining ranges %v to be requested but got %v", expectedRanges, ranges))
+	}
+	if contents, err := ioutil.ReadFile(downloadLocation); err != nil || !bytes.Equal(contents, body) {
+		t.Error(fmt.Sprintf("expected the resumed download to match the object: %v", err))
+	}
+	if _, err := os.Stat(downloadLocation + ResumeMarkerExtension); !os.IsNotExist(err) {
+		t.Error("expected the resume marker to be removed once the download completed")
+	}
+}
+
+// Test 2 - Download Resume Testing
+//	Resume a partial download whose marker records an earlier version of the object
+func TestDownloadResumeChangedObject(t *testing.T) {
+	server, body := resumeTestServer()
+	defer server.Close()
+
+	downloadLocation := filepath.Join(t.TempDir(), "backup")
+	marker, _ := json.Marshal(resumeMarker{Key: "daily_backup", ETag: `"0123456789abcdef0123456789abcdef"`, Size: int64(len(body))})
+	if err := ioutil.WriteFile(downloadLocation+ResumeMarkerExtension, marker, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(downloadLocation, bytes.Repeat([]byte("x"), 1048576), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DownloadFile(server.Client(), resumeDownloadObject(downloadLocation)); err != nil {
+		t.Fatal(fmt.Sprintf("expected to download the changed object without any error: %v", err))
+	}
+
+	requests := server.Requests("GetObject")
+	if len(requests) != 3 || requests[0].Header.Get("Range") != "bytes=0-1048575" {
+		t.Error(fmt.Sprintf("expected the changed object to be downloaded from the start in 3 parts but got %d requests", len(requests)))
+	}
+	if contents, err := ioutil.ReadFile(downloadLocation); err != nil || !bytes.Equal(contents, body) {
+		t.Error(fmt.Sprintf("expected the partial download to be replaced by the object: %v", err))
+	}
+}
+
+// Test 3 - Download Resume Testing
+//	Download an object which is overwritten after its first part is downloaded
+func TestDownloadResumeObjectChangedDuringDownload(t *testing.T) {
+	expectedErrString := "PreconditionFailed"
+
+	server, _ := resumeTestServer()
+	defer server.Close()
+
+	server.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "GetObject" && req.Header.Get("Range") == "bytes=1048576-2097151" {
+			server.PutObject("mockbucket", "daily_backup", bytes.Repeat([]byte("b"), 2621440), time.Now())
+		}
+		return nil
+	})
+
+	downloadLocation := filepath.Join(t.TempDir(), "backup")
+	err := DownloadFile(server.Client(), resumeDownloadObject(downloadLocation))
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns a mock S3 server with a 2.5MB object and its body
+func resumeTestServer() (*s3mock.Server, []byte) {
+	body := make([]byte, 2621440)
+	rand.New(rand.NewSource(1)).Read(body)
+	server := s3mock.New("mockbucket")
+	server.PutObject("mockbucket", "daily_backup", body, time.Now())
+	return server, body
+}
+
+func resumeDownloadObject(downloadLocation string) DownloadObject {
+	return DownloadObject{
+		DownloadLocation: downloadLocation,
+		S3FileKey:        "daily_backup",
+		Bucket:           "mockbucket",
+		NumWorkers:       5,
+		PartSize:         1,
+		Resume:           true,
+	}
+}
+
+//----------------------------------------------
+// Prefix Download Testing (mock S3)
+//	1: Every key under the prefix is downloaded into the directory under its path relative to the bucket dir
+//	2: A key which fails to download does not stop the remaining keys from being downloaded
+//	3: A download location which is a file is rejected
+//----------------------------------------------
+
+// Test 1 - Prefix Download Testing
+//	Download the daily backups under a bucket dir alongside a weekly backup and hidden chunks and restore locks
+func TestDownloadPrefix(t *testing.T) {
+	server := prefixTestServer()
+	defer server.Close()
+
+	dir := filepath.Join(t.TempDir(), "restore")
+	downloaded, err := DownloadPrefix(server.Client(), prefixDownloadObject(dir), "daily_")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to download the prefix without any error: %v", err))
+	}
+
+	expected := map[string]string{
+		"backups/daily_db_20240130T020000":   "first daily backup",
+		"backups/daily_db_20240131T020000":   "second daily backup",
+		"backups/daily_site/index.html":      "<html></html>",
+		"backups/daily_site/images/logo.png": "logo",
+	}
+	if len(downloaded) != len(expected) {
+		t.Error(fmt.Sprintf("expected %d keys to be downloaded but got %v", len(expected), downloaded))
+	}
+	for _, key := range downloaded {
+		contents, err := ioutil.ReadFile(key.Path)
+		if err != nil || string(contents) != expected[key.Key] {
+			t.Error(fmt.Sprintf("expected key '%s' to be downloaded to '%s': %v", key.Key, key.Path, err))
+		}
+		if key.Path != filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(key.Key, "backups/"))) {
+			t.Error(fmt.Sprintf("expected key '%s' to be downloaded under its path relative to the bucket dir but got '%s'", key.Key, key.Path))
+		}
+	}
+	for _, name := range []string{"weekly_db_20240128T020000", ".chunks", ".restorelocks"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Error(fmt.Sprintf("expected '%s' not to be downloaded", name))
+		}
+	}
+}
+
+// Test 2 - Prefix Download Testing
+//	Download the daily backups when one of them is denied
+func TestDownloadPrefixContinuesOnFailure(t *testing.T) {
+	server := prefixTestServer()
+	defer server.Close()
+	server.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Key == "backups/daily_db_20240130T020000" {
+			return &s3mock.Error{StatusCode: 403, Code: "AccessDenied", Message: "Access Denied"}
+		}
+		return nil
+	})
+
+	dir := filepath.Join(t.TempDir(), "restore")
+	downloaded, err := DownloadPrefix(server.Client(), prefixDownloadObject(dir), "daily_")
+
+	prefixErr, ok := err.(*PrefixDownloadError)
+	if !ok || len(prefixErr.Failed) != 1 || prefixErr.Failed["backups/daily_db_20240130T020000"] == nil {
+		t.Fatal(fmt.Sprintf("expected only the denied key to fail but got: %v", err))
+	}
+	if len(downloaded) != 3 {
+		t.Error(fmt.Sprintf("expected the remaining 3 keys to be downloaded but got %v", downloaded))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "daily_db_20240131T020000")); err != nil {
+		t.Error(fmt.Sprintf("expected the key after the denied key to be downloaded: %v", err))
+	}
+}
+
+// Test 3 - Prefix Download Testing
+//	Download a prefix to a download location which is an existing file
+func TestDownloadPrefixToFile(t *testing.T) {
+	expectedErrString := "must be a directory to download the keys under 'backups/daily_'"
+
+	server := prefixTestServer()
+	defer server.Close()
+
+	downloadLocation := filepath.Join(t.TempDir(), "restore")
+	ioutil.WriteFile(downloadLocation, []byte("an existing file"), 0644)
+
+	_, err := DownloadPrefix(server.Client(), prefixDownloadObject(downloadLocation), "daily_")
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error: '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns a mock S3 server with daily and weekly backups and the hidden objects stored alongside them
+func prefixTestServer() *s3mock.Server {
+	server := s3mock.New("mockbucket")
+	server.PutObject("mockbucket", "backups/daily_db_20240130T020000", []byte("first daily backup"), time.Now())
+	server.PutObject("mockbucket", "backups/daily_db_20240131T020000", []byte("second daily backup"), time.Now())
+	server.PutObject("mockbucket", "backups/daily_site/", []byte{}, time.Now())
+	server.PutObject("mockbucket", "backups/daily_site/index.html", []byte("<html></html>"), time.Now())
+	server.PutObject("mockbucket", "backups/daily_site/images/logo.png", []byte("logo"), time.Now())
+	server.PutObject("mockbucket", "backups/daily_site/.cache/entry", []byte("hidden"), time.Now())
+	server.PutObject("mockbucket", "backups/weekly_db_20240128T020000", []byte("weekly backup"), time.Now())
+	server.PutObject("mockbucket", "backups/.chunks/daily_db/0123", []byte("chunk"), time.Now())
+	server.PutObject("mockbucket", "backups/.restorelocks/daily_db", []byte("lock"), time.Now())
+	return server
+}
+
+func prefixDownloadObject(downloadLocation string) DownloadObject {
+	return DownloadObject{
+		DownloadLocation: downloadLocation,
+		Bucket:           "mockbucket",
+		BucketDir:        "backups/",
+		NumWorkers:       2,
+		PartSize:         5,
+	}
+}
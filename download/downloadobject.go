@@ -1,5 +1,7 @@
 package download
 
+import "time"
+
 // DownloadObject represents an object to download from S3
 type DownloadObject struct {
 	DownloadLocation string
@@ -9,4 +11,16 @@ type DownloadObject struct {
 	Endpoint         string
 	NumWorkers       int
 	PartSize         int
+	BufferSize       int // Size (KB) of the buffer each part is written to the download location through. 0 writes the parts unbuffered
+
+	PreserveMetadata bool // Restore the permissions and modification times of the directories and files of a zip archive
+	VerifyOnly       bool // Verify the object by streaming it through a hasher without writing it to the download location
+	CheckInodes      bool // Only extract a zip archive if the filesystem of the download location has enough free inodes for its entries
+	Resume           bool // Resume a partial download of the same object by requesting only its remaining bytes. The partial download is kept if the download fails
+
+	EncryptionKey []byte // Key material the object is decrypted with if it was encrypted on upload
+
+	Restore RestoreObject // Restore of the object if it is archived, e.g. in GLACIER, and must be restored before it is downloaded
+
+	RestoreLockTTL time.Duration // Hold a restore lock under the bucket dir which expires after this long while the object is read so that rotation does not delete backups. 0 holds no lock
 }
@@ -0,0 +1,88 @@
+package download
+
+import (
+	"archive/tar"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/s3client"
+	"io"
+	"strings"
+)
+
+// ExportTar streams every object under the prefix into a single tar written to w without writing anything to disk.
+// Each object is downloaded sequentially with ranged requests of the part size (bytes) and is named in the tar by its
+// key relative to the prefix. Keys ending in '/' are folder placeholders and are skipped. Returns the exported keys
+func ExportTar(svc *s3.S3, bucket string, prefix string, partSize int64, w io.Writer) ([]string, error) {
+	if partSize <= 0 {
+		return nil, fmt.Errorf("part size must be greater than 0: %d", partSize)
+	}
+
+	listing, err := s3client.ListByDelimiter(svc, bucket, prefix, "")
+	if err != nil {
+		return nil, err
+	}
+
+	tw := tar.NewWriter(w)
+	exportedKeys := []string{}
+
+	for _, obj := range listing.Objects {
+		name := strings.TrimPrefix(obj.Key, prefix)
+		if name == "" || strings.HasSuffix(name, "/") {
+			log.Info.Printf("Skipping folder placeholder '%s'\n", obj.Key)
+			continue
+		}
+
+		log.Info.Printf("Exporting '%s' (%d bytes)\n", obj.Key, obj.Size)
+
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     obj.Size,
+			Mode:     0644,
+			ModTime:  obj.ModifiedTime,
+		})
+		if err != nil {
+			return exportedKeys, err
+		}
+
+		if err = exportObject(svc, bucket, obj, partSize, tw); err != nil {
+			return exportedKeys, fmt.Errorf("failed to export '%s': %v", obj.Key, err)
+		}
+		exportedKeys = append(exportedKeys, obj.Key)
+	}
+
+	return exportedKeys, tw.Close()
+}
+
+// Copies the object into the writer one ranged request at a time. The object must still be the size it was listed
+// with, otherwise the tar entry would be truncated or overrun
+func exportObject(svc *s3.S3, bucket string, obj s3client.ListedObject, partSize int64, w io.Writer) error {
+	for start := int64(0); start < obj.Size; start += partSize {
+		end := start + partSize - 1
+		if end >= obj.Size {
+			end = obj.Size - 1
+		}
+
+		resp, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(obj.Key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			return err
+		}
+
+		n, err := io.Copy(w, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if n != end-start+1 {
+			return fmt.Errorf("expected %d bytes from range %d-%d but received %d. The object may have changed during the export", end-start+1, start, end, n)
+		}
+	}
+
+	return nil
+}
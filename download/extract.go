@@ -0,0 +1,109 @@
+package download
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Extracts the zip archive into the directory, creating it if it does not exist.
+// Entries which would be extracted outside of the directory are rejected. If metadata is preserved then the
+// permissions and modification times recorded in the archive are restored once every entry has been extracted.
+// If inodes are checked then nothing is extracted unless the filesystem has enough free inodes for every entry
+func extractZip(pathToArchive string, dir string, preserveMetadata bool, inodes bool) error {
+	reader, err := zip.OpenReader(pathToArchive) // Requires random access as the central directory is at the end of the archive
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if inodes {
+		if err = checkInodes(dir, len(reader.File)); err != nil {
+			return err
+		}
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	extracted := map[string]*zip.File{}
+	for _, entry := range reader.File {
+		path := filepath.Join(root, filepath.FromSlash(entry.Name))
+		if path != root && !strings.HasPrefix(path, root+string(os.PathSeparator)) {
+			return fmt.Errorf("zip entry '%s' would be extracted outside of '%s'", entry.Name, dir)
+		}
+		extracted[path] = entry
+
+		if entry.FileInfo().IsDir() {
+			if err = os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err = extractZipEntry(entry, path); err != nil {
+			return err
+		}
+	}
+
+	if preserveMetadata {
+		return restoreZipMetadata(extracted)
+	}
+	return nil
+}
+
+// Restores the permissions and modification time of every extracted entry. Directories are restored after the
+// files and directories within them, deepest first, so that a directory which is not writable is only restricted once
+// nothing else is written to it and its modification time is not updated by the entries restored within it
+func restoreZipMetadata(extracted map[string]*zip.File) error {
+	paths := []string{}
+	for path := range extracted {
+		paths = append(paths, path)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+
+	for _, path := range paths {
+		entry := extracted[path]
+		if err := os.Chmod(path, entry.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(path, entry.Modified, entry.Modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipEntry(entry *zip.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	in, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, entry.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
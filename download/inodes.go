@@ -0,0 +1,64 @@
+package download
+
+import (
+	"fmt"
+	"s3backup/log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// The inodes required beyond the entries of an archive, as a fraction of the entries and at least the minimum. Covers
+// the parent directories of the entries which are not themselves entries and anything else written to the filesystem
+// while the archive is extracted
+const (
+	inodeMarginFraction = 0.1
+	minInodeMargin      = 64
+)
+
+// Returns the free and total inodes of the filesystem of the path. Replaced by tests to mock the filesystem
+var statInodes = func(path string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return uint64(stat.Ffree), uint64(stat.Files), nil
+}
+
+// Checks that the filesystem of the directory has enough free inodes to extract the entries of an archive into it, so
+// that a restore of many small files fails before anything is extracted rather than partway through. The directory
+// need not exist, the filesystem of its nearest existing parent is checked. Filesystems which allocate inodes
+// dynamically report no inodes at all and are not checked
+func checkInodes(dir string, entries int) error {
+	path, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err = os.Stat(path); err == nil || filepath.Dir(path) == path {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+
+	free, total, err := statInodes(path)
+	if err != nil {
+		return fmt.Errorf("failed to check the free inodes of '%s': %v", path, err)
+	}
+	if total == 0 {
+		log.Info.Printf("Not checking the free inodes of '%s' as its filesystem does not report a fixed number of inodes\n", path)
+		return nil
+	}
+
+	margin := int(float64(entries) * inodeMarginFraction)
+	if margin < minInodeMargin {
+		margin = minInodeMargin
+	}
+	required := uint64(entries + margin)
+
+	if free < required {
+		return fmt.Errorf("not enough free inodes to extract %d entries into '%s', %d are required but only %d are free", entries, dir, required, free)
+	}
+	log.Info.Printf("%d inodes are free to extract %d entries into '%s'\n", free, entries, dir)
+	return nil
+}
@@ -0,0 +1,48 @@
+package download
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/s3client"
+	"regexp"
+	"time"
+)
+
+// FindLatest returns the key of the most recently modified backup of the file under the bucket dir, i.e. a key of the
+// form <bucketdir>[<prefix>]<s3filename>_<timestamp>[<extension>] in any rotation tier. Backups modified less than the
+// min age ago are not eligible, so that a backup which has only just been written, and may not yet be durable, is not
+// selected before an older backup which is
+func FindLatest(svc *s3.S3, bucket string, bucketDir string, s3FileName string, minAge time.Duration) (string, error) {
+	if minAge < 0 {
+		return "", fmt.Errorf("min age must not be negative: %s", minAge)
+	}
+
+	listing, err := s3client.ListByDelimiter(svc, bucket, bucketDir, "/")
+	if err != nil {
+		return "", err
+	}
+
+	re := regexp.MustCompile("^" + regexp.QuoteMeta(bucketDir) + "([^/]*_)?" + regexp.QuoteMeta(s3FileName) + `_\d{8}T\d{6}(\.[^/]+)?$`)
+	cutoff := time.Now().Add(-minAge)
+
+	keys := map[string]time.Time{}
+	for _, obj := range listing.Objects {
+		if !re.MatchString(obj.Key) {
+			continue
+		}
+		if obj.ModifiedTime.After(cutoff) {
+			log.Info.Printf("Skipping '%s' as it was modified less than %s ago\n", obj.Key, minAge)
+			continue
+		}
+		keys[obj.Key] = obj.ModifiedTime
+	}
+
+	if len(keys) == 0 {
+		return "", fmt.Errorf("no backup of '%s' under '%s' older than the min age of %s was found", s3FileName, bucketDir, minAge)
+	}
+
+	latest := s3client.SortKeysByTime(keys)[0]
+	log.Info.Printf("Latest backup of '%s' is '%s' modified at %s\n", s3FileName, latest.Key, latest.ModifiedTime.Format(time.RFC3339))
+	return latest.Key, nil
+}
@@ -0,0 +1,153 @@
+package download
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/s3client"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PrefixDownloadError is returned by DownloadPrefix when any key under the prefix failed to download. The remaining
+// keys are still downloaded, so the prefix can be downloaded again to retry the keys which failed
+type PrefixDownloadError struct {
+	Failed map[string]error // The error of each key which failed to download by its key
+}
+
+func (e *PrefixDownloadError) Error() string {
+	keys := make([]string, 0, len(e.Failed))
+	for key := range e.Failed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	failures := make([]string, 0, len(keys))
+	for _, key := range keys {
+		failures = append(failures, fmt.Sprintf("'%s': %v", key, e.Failed[key]))
+	}
+	return fmt.Sprintf("failed to download %d keys under the prefix: %s", len(keys), strings.Join(failures, "; "))
+}
+
+// DownloadedKey represents a key downloaded by DownloadPrefix and the path it was downloaded to
+type DownloadedKey struct {
+	Key  string
+	Path string
+}
+
+// DownloadPrefix downloads every key under the bucket dir beginning with the prefix into the download location, which
+// is created as a directory if it does not exist. Each key is downloaded with DownloadFile to its key relative to the
+// bucket dir, e.g. backups/website/index.html to <downloadlocation>/website/index.html for the bucket dir backups/, so
+// compressed and encrypted keys are decompressed and decrypted and zip archives are extracted into a directory named
+// after the key. An empty prefix downloads every key under the bucket dir. Folder placeholders and the hidden objects
+// s3backup stores alongside the backups, such as chunks, volumes and restore locks, are skipped. Up to the number of
+// workers keys are downloaded at once, each with its own workers for its parts. A key which fails does not stop the
+// remaining keys from being downloaded and every key which failed is named by the returned PrefixDownloadError.
+// Returns the keys which were downloaded in the order they were listed
+func DownloadPrefix(svc *s3.S3, downloadObject DownloadObject, prefix string) ([]DownloadedKey, error) {
+	if downloadObject.DownloadLocation == "" {
+		return nil, fmt.Errorf("a download location must be specified to download the keys under '%s'", downloadObject.BucketDir+prefix)
+	}
+	if info, err := os.Stat(downloadObject.DownloadLocation); err == nil && !info.IsDir() {
+		return nil, fmt.Errorf("download location '%s' must be a directory to download the keys under '%s'", downloadObject.DownloadLocation, downloadObject.BucketDir+prefix)
+	}
+
+	listing, err := s3client.ListByDelimiter(svc, downloadObject.Bucket, downloadObject.BucketDir+prefix, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys under '%s': %v", downloadObject.BucketDir+prefix, err)
+	}
+
+	workers := downloadObject.NumWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	slots := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	results := make([]*DownloadedKey, len(listing.Objects)) // A slot for each key in the order it was listed, nil if the key was skipped or failed
+	failed := make(map[string]error)
+
+	for i, obj := range listing.Objects {
+		relKey := strings.TrimPrefix(obj.Key, downloadObject.BucketDir)
+		if relKey == "" || strings.HasSuffix(relKey, "/") {
+			log.Info.Printf("Skipping folder placeholder '%s'\n", obj.Key)
+			continue
+		}
+		if hiddenKey(relKey) {
+			log.Info.Printf("Skipping hidden key: '%s'\n", obj.Key)
+			continue
+		}
+
+		pathToFile, err := prefixDownloadPath(downloadObject.DownloadLocation, relKey)
+		if err != nil {
+			log.Error.Printf("Failed to download key: '%s': %v\n", obj.Key, err)
+			failed[obj.Key] = err
+			continue
+		}
+
+		keyObject := downloadObject
+		keyObject.S3FileKey = obj.Key
+		keyObject.DownloadLocation = pathToFile
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(slot int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			err := DownloadFile(svc, keyObject)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Error.Printf("Failed to download key: '%s': %v\n", keyObject.S3FileKey, err)
+				failed[keyObject.S3FileKey] = err
+				return
+			}
+			log.Info.Printf("Key downloaded: '%s' -> '%s'\n", keyObject.S3FileKey, keyObject.DownloadLocation)
+			results[slot] = &DownloadedKey{Key: keyObject.S3FileKey, Path: keyObject.DownloadLocation}
+		}(i)
+	}
+	wg.Wait()
+
+	downloaded := []DownloadedKey{}
+	for _, result := range results {
+		if result != nil {
+			downloaded = append(downloaded, *result)
+		}
+	}
+
+	if len(failed) > 0 {
+		return downloaded, &PrefixDownloadError{Failed: failed}
+	}
+	return downloaded, nil
+}
+
+// Returns true if any element of the key relative to the bucket dir begins with '.', e.g. .chunks/ and .volumes/
+func hiddenKey(relKey string) bool {
+	for _, name := range strings.Split(relKey, "/") {
+		if strings.HasPrefix(name, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the path in the directory of the key relative to the bucket dir. Keys which would be downloaded outside of
+// the directory are rejected
+func prefixDownloadPath(dir string, relKey string) (string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	pathToFile := filepath.Join(root, filepath.FromSlash(relKey))
+	if !strings.HasPrefix(pathToFile, root+string(os.PathSeparator)) {
+		return "", fmt.Errorf("key would be downloaded outside of '%s'", dir)
+	}
+	return pathToFile, nil
+}
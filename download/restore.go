@@ -0,0 +1,145 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"strings"
+	"time"
+)
+
+// How often the object is checked while waiting for its restore to complete
+var restorePollInterval = time.Minute
+
+// RestoreObject configures the restore of an object archived in the GLACIER or DEEP_ARCHIVE storage class, or in an
+// archive access tier of INTELLIGENT_TIERING, which must be restored before it can be downloaded
+type RestoreObject struct {
+	Tier string        // Retrieval tier of the restore [Expedited|Standard|Bulk]. Defaults to Standard
+	Days int           // Days the restored copy is kept before it expires. Defaults to 1
+	Wait time.Duration // Maximum time to wait for the restore to complete. 0 returns a RestoreInProgressError once the restore is requested
+}
+
+// RestoreInProgressError is returned when an archived object cannot be downloaded until its restore has completed
+type RestoreInProgressError struct {
+	Key          string
+	StorageClass string
+}
+
+func (e *RestoreInProgressError) Error() string {
+	return fmt.Sprintf("restore of '%s' from %s is in progress, download it again once the restore has completed", e.Key, e.StorageClass)
+}
+
+// EnsureRestored requests the restore of the object if it is archived and has not been restored, and waits up to the
+// wait of the restore object for the restore to complete. An object which is not archived, or whose restored copy
+// has not expired, can be downloaded straight away. Returns a RestoreInProgressError if the restore has not completed
+func EnsureRestored(svc *s3.S3, bucket string, key string, restoreObject RestoreObject) error {
+	tier, err := restoreTier(restoreObject.Tier)
+	if err != nil {
+		return err
+	}
+	days := restoreObject.Days
+	if days == 0 {
+		days = 1
+	}
+	if days < 0 {
+		return fmt.Errorf("restore days must not be less than 0: %d", days)
+	}
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+
+	storageClass, archived := archiveStorageClass(head)
+	if !archived || restoreCompleted(head) {
+		return nil
+	}
+
+	if aws.StringValue(head.Restore) == "" {
+		input := &s3.RestoreObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			RestoreRequest: &s3.RestoreRequest{
+				GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(tier)},
+			},
+		}
+		// The restored copy of an archive access tier of INTELLIGENT_TIERING does not expire, so no days are specified
+		if head.ArchiveStatus == nil {
+			input.RestoreRequest.Days = aws.Int64(int64(days))
+		}
+
+		log.Info.Printf("Key: '%s' is archived in %s, requesting a %s restore kept for %d days\n", key, storageClass, tier, days)
+		if _, err = svc.RestoreObject(input); err != nil {
+			if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "RestoreAlreadyInProgress" {
+				return fmt.Errorf("failed to request the restore of '%s': %v", key, err)
+			}
+		}
+	}
+
+	if restoreObject.Wait <= 0 {
+		return &RestoreInProgressError{Key: key, StorageClass: storageClass}
+	}
+
+	log.Info.Printf("Waiting up to %s for the restore of '%s' to complete\n", restoreObject.Wait, key)
+	ctx, cancelFn := context.WithTimeout(context.Background(), restoreObject.Wait)
+	defer cancelFn()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return &RestoreInProgressError{Key: key, StorageClass: storageClass}
+		case <-time.After(restorePollInterval):
+		}
+
+		head, err = svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return &RestoreInProgressError{Key: key, StorageClass: storageClass}
+			}
+			return err
+		}
+		if restoreCompleted(head) {
+			log.Info.Printf("Restore of '%s' has completed\n", key)
+			return nil
+		}
+	}
+}
+
+// Returns the retrieval tier in the case S3 expects. An empty tier is Standard
+func restoreTier(tier string) (string, error) {
+	if tier == "" {
+		return s3.TierStandard, nil
+	}
+	for _, valid := range []string{s3.TierStandard, s3.TierBulk, s3.TierExpedited} {
+		if strings.EqualFold(tier, valid) {
+			return valid, nil
+		}
+	}
+	return "", fmt.Errorf("restore tier must be one of %s, %s or %s: '%s'", s3.TierExpedited, s3.TierStandard, s3.TierBulk, tier)
+}
+
+// Returns the storage class of the object and whether it is archived and must be restored before it can be read
+func archiveStorageClass(head *s3.HeadObjectOutput) (string, bool) {
+	storageClass := aws.StringValue(head.StorageClass)
+	switch {
+	case storageClass == s3.StorageClassGlacier, storageClass == s3.StorageClassDeepArchive:
+		return storageClass, true
+	case head.ArchiveStatus != nil:
+		return storageClass + " " + aws.StringValue(head.ArchiveStatus), true
+	}
+	return storageClass, false
+}
+
+// Returns true if the restore of the object has completed and its restored copy can be read
+func restoreCompleted(head *s3.HeadObjectOutput) bool {
+	return strings.HasPrefix(aws.StringValue(head.Restore), `ongoing-request="false"`)
+}
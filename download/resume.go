@@ -0,0 +1,133 @@
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ResumeMarkerExtension is appended to the path of a resumable download to name the marker of the object it is
+// downloaded from. The marker is written before the first byte is downloaded and is removed once the download completes
+const ResumeMarkerExtension = ".resume"
+
+// Records the object a partial download was downloaded from so that it is only resumed if the object is unchanged
+type resumeMarker struct {
+	Key  string `json:"key"`
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+// Downloads the object to the path in order of its bytes with ranged requests of the part size, so that the file at
+// the path only ever holds a contiguous start of the object. If the file already holds the start of the same object,
+// i.e. the marker of the partial download records the ETag and size of the object, then only the remaining bytes are
+// requested and appended. Otherwise the object is downloaded again from the start. Every range is requested with the
+// ETag of the object so that an object which changes during the download fails it rather than being spliced together
+func downloadResumable(svc *s3.S3, downloadObject DownloadObject, pathToDownload string, partSize int64) error {
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(downloadObject.Bucket),
+		Key:    aws.String(downloadObject.S3FileKey),
+	})
+	if err != nil {
+		return err
+	}
+	marker := resumeMarker{Key: downloadObject.S3FileKey, ETag: aws.StringValue(head.ETag), Size: aws.Int64Value(head.ContentLength)}
+	markerPath := pathToDownload + ResumeMarkerExtension
+
+	offset := resumeOffset(pathToDownload, markerPath, marker)
+	if offset == 0 {
+		body, err := json.Marshal(marker)
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(markerPath, body, 0644); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(pathToDownload, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// A part which was only partly written is kept as the bytes of the file are always in order
+	if err = file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if partSize <= 0 {
+		partSize = marker.Size
+	}
+
+	for start := offset; start < marker.Size; start += partSize {
+		end := start + partSize - 1
+		if end >= marker.Size {
+			end = marker.Size - 1
+		}
+
+		resp, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket:  aws.String(downloadObject.Bucket),
+			Key:     aws.String(downloadObject.S3FileKey),
+			Range:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+			IfMatch: aws.String(marker.ETag),
+		})
+		if err != nil {
+			return err
+		}
+
+		n, err := io.Copy(file, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if n != end-start+1 {
+			return fmt.Errorf("expected %d bytes from range %d-%d but received %d", end-start+1, start, end, n)
+		}
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+	log.Info.Printf("Downloaded '%s' (%d bytes) to '%s'\n", downloadObject.S3FileKey, marker.Size, pathToDownload)
+	return os.Remove(markerPath)
+}
+
+// Returns the number of bytes of the object already held by the partial download at the path, or 0 if there is no
+// partial download of the object, it has no marker or the object has changed since it was downloaded
+func resumeOffset(pathToDownload string, markerPath string, marker resumeMarker) int64 {
+	info, err := os.Stat(pathToDownload)
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		return 0
+	}
+
+	body, err := ioutil.ReadFile(markerPath)
+	if err != nil {
+		log.Info.Printf("'%s' has no resume marker, downloading '%s' from the start\n", pathToDownload, marker.Key)
+		return 0
+	}
+	var recorded resumeMarker
+	if err = json.Unmarshal(body, &recorded); err != nil {
+		log.Warn.Printf("Resume marker '%s' is unreadable, downloading '%s' from the start: %v\n", markerPath, marker.Key, err)
+		return 0
+	}
+
+	if recorded != marker {
+		log.Info.Printf("Key: '%s' has changed since '%s' was partly downloaded (ETag %s, %d bytes rather than ETag %s, %d bytes), downloading it from the start\n",
+			marker.Key, pathToDownload, recorded.ETag, recorded.Size, marker.ETag, marker.Size)
+		return 0
+	}
+	if info.Size() > marker.Size {
+		log.Info.Printf("'%s' is larger than key: '%s', downloading it from the start\n", pathToDownload, marker.Key)
+		return 0
+	}
+
+	log.Info.Printf("Resuming the download of '%s' at byte %d of %d\n", marker.Key, info.Size(), marker.Size)
+	return info.Size()
+}
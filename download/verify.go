@@ -0,0 +1,172 @@
+package download
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/compress"
+	"s3backup/encrypt"
+	"s3backup/log"
+	"s3backup/upload"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VerifyObject streams the object through a hasher without writing it to disk and verifies it against the md5sum of
+// the source recorded on upload, decrypting and decompressing the object if it was encrypted or compressed, and against its ETag. The ETag is only
+// verified if S3 derives it from the md5sum of the object, i.e. the object is not encrypted with SSE-KMS or SSE-C.
+// Returns an error if the object does not match or if neither can be verified
+func VerifyObject(svc *s3.S3, downloadObject DownloadObject) error {
+
+	log.Info.Println(`
+	######################################
+	#    Download Verification Started   #
+	######################################
+	`)
+
+	compressor, archive, encrypted, err := getObjectFormat(svc, downloadObject)
+	if err != nil {
+		return err
+	}
+	if err = checkEncryptionKey(downloadObject, encrypted); err != nil {
+		return err
+	}
+	if archive == upload.ArchiveFormatChunks {
+		return fmt.Errorf("'%s' is a chunk manifest, verify only is not supported for chunked uploads", downloadObject.S3FileKey)
+	}
+	if archive == upload.ArchiveFormatVolumes {
+		return verifyVolumes(svc, downloadObject)
+	}
+
+	// The size of the first part of a multipart object is the part size its ETag was computed with
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket:     aws.String(downloadObject.Bucket),
+		Key:        aws.String(downloadObject.S3FileKey),
+		PartNumber: aws.Int64(1),
+	})
+	if err != nil {
+		return err
+	}
+
+	expectedMD5 := ""
+	for metadataKey, value := range head.Metadata {
+		if http.CanonicalHeaderKey(metadataKey) == upload.ChecksumMetadataKey {
+			expectedMD5 = aws.StringValue(value)
+		}
+	}
+
+	etag := strings.Trim(aws.StringValue(head.ETag), "\"")
+	verifyETag := aws.StringValue(head.ServerSideEncryption) != s3.ServerSideEncryptionAwsKms && head.SSECustomerAlgorithm == nil
+	if !verifyETag && expectedMD5 == "" {
+		return fmt.Errorf("'%s' cannot be verified as its ETag is not an md5sum and no checksum was recorded on upload", downloadObject.S3FileKey)
+	}
+
+	partSize := aws.Int64Value(head.ContentLength)
+	if partSize < 1 {
+		partSize = 1 // An empty object has a single empty part
+	}
+
+	resp, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(downloadObject.Bucket),
+		Key:    aws.String(downloadObject.S3FileKey),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	log.Info.Printf("Streaming '%s' through the hasher without writing it to disk\n", downloadObject.S3FileKey)
+	startTime := time.Now()
+
+	// The recorded md5sum is of the source before it was compressed and encrypted, so a compressed object is also
+	// decompressed as it is read. An encrypted object is always decrypted as it is read, which also authenticates it
+	var body io.Reader = resp.Body
+	var pw *io.PipeWriter
+	var decoded chan error
+	source := md5.New()
+	if (expectedMD5 != "" && compressor != nil) || encrypted {
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		body = io.TeeReader(resp.Body, pw)
+		decoded = make(chan error, 1)
+		go func() {
+			decoded <- hashDecoded(compressor, downloadObject.EncryptionKey, encrypted, pr, source)
+		}()
+	}
+
+	local, err := upload.StreamETag(body, partSize, strings.Contains(etag, "-"))
+	if pw != nil {
+		pw.CloseWithError(err)
+		if decodeErr := <-decoded; err == nil && decodeErr != nil {
+			err = decodeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stream '%s': %v", downloadObject.S3FileKey, err)
+	}
+
+	log.Info.Printf("Total time spent verifying download: %0.2f seconds\n", time.Since(startTime).Seconds())
+
+	if expectedMD5 != "" {
+		sourceMD5 := local.MD5
+		if compressor != nil || encrypted {
+			sourceMD5 = hex.EncodeToString(source.Sum(nil))
+		}
+		if sourceMD5 != expectedMD5 {
+			return fmt.Errorf("verification of '%s' failed: md5sum '%s' does not match the checksum '%s' recorded on upload", downloadObject.S3FileKey, sourceMD5, expectedMD5)
+		}
+		log.Info.Printf("md5sum of '%s' matches the checksum recorded on upload: %s\n", downloadObject.S3FileKey, sourceMD5)
+	}
+
+	if verifyETag {
+		if local.ETag != etag {
+			return fmt.Errorf("verification of '%s' failed: computed ETag '%s' does not match the ETag '%s' of the object", downloadObject.S3FileKey, local.ETag, etag)
+		}
+		log.Info.Printf("ETag of '%s' matches the ETag of the object: %s\n", downloadObject.S3FileKey, etag)
+	}
+
+	log.Info.Printf("Verification complete. '%s' is intact and nothing has been written to disk\n", downloadObject.S3FileKey)
+	return nil
+}
+
+// Decrypts the object read from the pipe if it is encrypted and decompresses it if it is compressed into the hash. The
+// rest of the pipe is drained once the object has been decoded, or closed with the error if it cannot be, so that the
+// object is never blocked from being read
+func hashDecoded(compressor compress.Compressor, material []byte, encrypted bool, pr *io.PipeReader, h hash.Hash) error {
+	var source io.Reader = pr
+	var err error
+	if encrypted {
+		source, err = encrypt.NewReader(pr, material)
+		if err != nil {
+			err = fmt.Errorf("failed to decrypt with %s: %v", encrypt.Algorithm, err)
+		}
+	}
+	if err == nil && compressor == nil {
+		_, err = io.Copy(h, source)
+		if err != nil {
+			err = fmt.Errorf("failed to decrypt with %s: %v", encrypt.Algorithm, err)
+		}
+	} else if err == nil {
+		var reader io.ReadCloser
+		reader, err = compressor.NewReader(source)
+		if err == nil {
+			_, err = io.Copy(h, reader)
+			reader.Close()
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to decompress with %s: %v", compressor.Name(), err)
+		}
+	}
+	if err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, pr)
+	return err
+}
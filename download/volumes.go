@@ -0,0 +1,106 @@
+package download
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/s3client"
+	"s3backup/upload"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Reassembles a file uploaded with UploadSplit into the download location from the volumes listed in its index
+func downloadVolumes(svc *s3.S3, downloadObject DownloadObject) error {
+	index, err := getVolumeIndex(svc, downloadObject)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(downloadObject.DownloadLocation)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	log.Info.Printf("Reassembling '%s' from %d volumes\n", downloadObject.S3FileKey, len(index.Volumes))
+	if err = readVolumes(svc, downloadObject.Bucket, index, file); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Streams every volume listed in the index through a hasher without writing them to disk
+func verifyVolumes(svc *s3.S3, downloadObject DownloadObject) error {
+	index, err := getVolumeIndex(svc, downloadObject)
+	if err != nil {
+		return err
+	}
+
+	log.Info.Printf("Streaming the %d volumes of '%s' through the hasher without writing them to disk\n", len(index.Volumes), downloadObject.S3FileKey)
+	if err = readVolumes(svc, downloadObject.Bucket, index, ioutil.Discard); err != nil {
+		return fmt.Errorf("verification of '%s' failed: %v", downloadObject.S3FileKey, err)
+	}
+
+	log.Info.Printf("Verification complete. '%s' is intact and nothing has been written to disk\n", downloadObject.S3FileKey)
+	return nil
+}
+
+// Returns the index of volumes stored under the key of the download object
+func getVolumeIndex(svc *s3.S3, downloadObject DownloadObject) (upload.VolumeIndex, error) {
+	var index upload.VolumeIndex
+
+	body, err := s3client.GetObjectBody(svc, downloadObject.Bucket, downloadObject.S3FileKey)
+	if err != nil {
+		return index, err
+	}
+
+	if err = json.Unmarshal(body, &index); err != nil {
+		return index, fmt.Errorf("failed to parse volume index '%s': %v", downloadObject.S3FileKey, err)
+	}
+
+	if index.Version != upload.VolumeIndexVersion {
+		return index, fmt.Errorf("unsupported volume index version: %d", index.Version)
+	}
+	return index, nil
+}
+
+// Writes the volumes of the index to the writer in order. Every volume is verified against its size and md5sum as it
+// is written, and the size and md5sum of the index are verified once every volume has been written
+func readVolumes(svc *s3.S3, bucket string, index upload.VolumeIndex, w io.Writer) error {
+	hash := md5.New()
+	w = io.MultiWriter(w, hash)
+
+	var size int64
+	for _, volume := range index.Volumes {
+		resp, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(volume.Key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to download volume '%s': %v", volume.Key, err)
+		}
+
+		volumeHash := md5.New()
+		n, err := io.Copy(io.MultiWriter(w, volumeHash), resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to download volume '%s': %v", volume.Key, err)
+		}
+
+		if n != volume.Size || hex.EncodeToString(volumeHash.Sum(nil)) != volume.MD5 {
+			return fmt.Errorf("volume '%s' does not match the size and md5sum recorded in the index", volume.Key)
+		}
+		size += n
+	}
+
+	if size != index.Size || hex.EncodeToString(hash.Sum(nil)) != index.MD5 {
+		return fmt.Errorf("reassembled file does not match the size and md5sum recorded in the index")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"s3backup/log"
+	"io/ioutil"
+	"os"
+)
+
+// PassphraseEnv is the environment variable the passphrase is read from when no key file is specified
+const PassphraseEnv = "S3BACKUP_PASSPHRASE"
+
+// KeySize is the size (bytes) of a derived key, the size of an AES-256 key
+const KeySize = 32
+
+// SaltSize is the size (bytes) of the salt a key is derived with
+const SaltSize = 16
+
+// The number of PBKDF2 iterations when deriving a key from the passphrase
+var keyIterations = 600000
+
+// LoadPassphrase returns the key material of the key file, or of the passphrase environment variable if no key file is
+// specified. Passing the passphrase as an argument would expose it to other users through the process list, so it can
+// only be provided by either of these. The key file takes precedence over the environment variable
+func LoadPassphrase(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		if os.Getenv(PassphraseEnv) != "" {
+			log.Warn.Printf("Both a key file and %s are set. Using the key file '%s'\n", PassphraseEnv, keyFile)
+		}
+		return ReadKeyFile(keyFile)
+	}
+
+	passphrase := os.Getenv(PassphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("a key file or the %s environment variable must be specified", PassphraseEnv)
+	}
+	return []byte(passphrase), nil
+}
+
+// ReadKeyFile returns the contents of the key file without a trailing newline. A key file which can be read by any user
+// is still read but a warning is logged, as the key should only be readable by the user running the backup
+func ReadKeyFile(keyFile string) ([]byte, error) {
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("key file '%s' is not a regular file", keyFile)
+	}
+
+	if info.Mode().Perm()&0004 != 0 {
+		log.Warn.Printf("Key file '%s' is readable by every user (%s). Restrict it with chmod 600\n", keyFile, info.Mode().Perm())
+	}
+
+	contents, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	contents = bytes.TrimRight(contents, "\r\n")
+	if len(contents) == 0 {
+		return nil, fmt.Errorf("key file '%s' is empty", keyFile)
+	}
+	return contents, nil
+}
+
+// DeriveKey derives a key of the key size from the key material with PBKDF2-SHA256. A new random salt should be used
+// for every run so that each encrypted backup has its own key even though the key material stays the same
+func DeriveKey(material []byte, salt []byte) ([]byte, error) {
+	if len(material) == 0 {
+		return nil, errors.New("key material must not be empty")
+	}
+	if len(salt) != SaltSize {
+		return nil, fmt.Errorf("salt must be %d bytes: %d", SaltSize, len(salt))
+	}
+	return pbkdf2SHA256(material, salt, keyIterations), nil
+}
+
+// PBKDF2 (RFC 8018) with HMAC-SHA256. The key size is the size of a SHA256 digest so only a single block is derived
+func pbkdf2SHA256(password []byte, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1}) // Index of the block
+	u := prf.Sum(nil)
+
+	key := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
@@ -0,0 +1,157 @@
+package encrypt
+
+import (
+	"bytes"
+	"fmt"
+	"s3backup/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testSalt = []byte("0123456789abcdef")
+
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	keyIterations = 1000 // Keep key derivation quick during tests
+}
+
+//----------------------------------------------
+//
+//             Key Loading Tests
+//
+//----------------------------------------------
+
+// The contents of the key file are used even if the passphrase environment variable is set
+func TestKeyFileUsed(t *testing.T) {
+	keyFile := writeKeyFile(t, "key file passphrase\n", 0600)
+	os.Setenv(PassphraseEnv, "environment passphrase")
+	defer os.Unsetenv(PassphraseEnv)
+
+	material, err := LoadPassphrase(keyFile)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to load the key file: %v", err))
+	}
+	if string(material) != "key file passphrase" {
+		t.Error(fmt.Sprintf("expected the contents of the key file without the trailing newline but got: '%s'", material))
+	}
+
+	fileKey, _ := DeriveKey(material, testSalt)
+	expectedKey, _ := DeriveKey([]byte("key file passphrase"), testSalt)
+	if !bytes.Equal(fileKey, expectedKey) || len(fileKey) != KeySize {
+		t.Error("expected the key to be derived from the contents of the key file")
+	}
+}
+
+// The passphrase environment variable is used when no key file is specified
+func TestPassphraseEnv(t *testing.T) {
+	os.Setenv(PassphraseEnv, "environment passphrase")
+	defer os.Unsetenv(PassphraseEnv)
+
+	material, err := LoadPassphrase("")
+	if err != nil || string(material) != "environment passphrase" {
+		t.Error(fmt.Sprintf("expected the passphrase of the environment variable but got: '%s' %v", material, err))
+	}
+}
+
+// Loading fails when neither a key file nor the environment variable is specified
+func TestNoPassphrase(t *testing.T) {
+	expectedErrString := "a key file or the " + PassphraseEnv + " environment variable must be specified"
+
+	os.Unsetenv(PassphraseEnv)
+	_, err := LoadPassphrase("")
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// A key file which every user can read is loaded with a warning
+func TestWorldReadableKeyFileWarning(t *testing.T) {
+	var warnings bytes.Buffer
+	log.Init(ioutil.Discard, &warnings, ioutil.Discard)
+	defer log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	if _, err := ReadKeyFile(writeKeyFile(t, "passphrase", 0600)); err != nil {
+		t.Fatal(fmt.Sprintf("expected to read the key file: %v", err))
+	}
+	if warnings.Len() != 0 {
+		t.Error(fmt.Sprintf("expected no warning for a key file only the owner can read but got: %s", warnings.String()))
+	}
+
+	if _, err := ReadKeyFile(writeKeyFile(t, "passphrase", 0644)); err != nil {
+		t.Fatal(fmt.Sprintf("expected to read the world readable key file: %v", err))
+	}
+	if !strings.Contains(warnings.String(), "is readable by every user") {
+		t.Error(fmt.Sprintf("expected a warning for a world readable key file but got: '%s'", warnings.String()))
+	}
+}
+
+// An empty key file is rejected
+func TestEmptyKeyFile(t *testing.T) {
+	expectedErrString := "is empty"
+
+	_, err := ReadKeyFile(writeKeyFile(t, "\n", 0600))
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+//
+//             Key Derivation Tests
+//
+//----------------------------------------------
+
+// Each salt derives a different key from the same key material
+func TestDeriveKeyPerSalt(t *testing.T) {
+	first, err := DeriveKey([]byte("passphrase"), testSalt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := DeriveKey([]byte("passphrase"), []byte("fedcba9876543210"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("expected a different key to be derived with a different salt")
+	}
+}
+
+// A salt of the wrong size is rejected
+func TestDeriveKeyInvalidSalt(t *testing.T) {
+	expectedErrString := fmt.Sprintf("salt must be %d bytes", SaltSize)
+
+	_, err := DeriveKey([]byte("passphrase"), []byte("short"))
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// The derived key matches the PBKDF2-HMAC-SHA256 test vector of RFC 7914
+func TestDeriveKeyTestVector(t *testing.T) {
+	key := pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1)
+	if fmt.Sprintf("%x", key) != "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" {
+		t.Error(fmt.Sprintf("expected the key of the test vector but got: %x", key))
+	}
+}
+
+// Writes the contents to a key file with the permissions in a temporary directory
+func writeKeyFile(t *testing.T, contents string, perm os.FileMode) string {
+	keyFile := filepath.Join(t.TempDir(), "backup.key")
+	if err := ioutil.WriteFile(keyFile, []byte(contents), perm); err != nil {
+		t.Fatal(err)
+	}
+	// The umask may have removed permissions from the file
+	if err := os.Chmod(keyFile, perm); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
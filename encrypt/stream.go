@@ -0,0 +1,305 @@
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// MetadataKey is the user metadata key that the encryption algorithm of an uploaded object is recorded under
+const MetadataKey = "S3backup-Encryption"
+
+// Algorithm is the name of the encryption recorded under the metadata key of encrypted objects
+const Algorithm = "aes-256-gcm"
+
+// Extension is appended to the key of an encrypted object after the extension of its compression algorithm
+const Extension = ".enc"
+
+// MinMaterialSize is the minimum size (bytes) of the key material an object can be encrypted or decrypted with
+const MinMaterialSize = 16
+
+// The magic the header of an encrypted object begins with. The final byte is the version of the format
+var magic = []byte("S3BKENC\x01")
+
+// The size of the prefix of the nonce of every segment, the rest of the nonce is the segment counter and final flag
+const noncePrefixSize = 7
+
+// The size of the header: the magic, the segment size, the salt of the key and the prefix of the nonce
+const headerSize = 8 + 4 + SaltSize + noncePrefixSize
+
+// The largest segment size accepted in the header of an encrypted object, which bounds the memory used to decrypt it
+const maxSegmentSize = 16 * 1024 * 1024
+
+// The size (bytes) of the plaintext of every segment but the last
+var segmentSize = 64 * 1024
+
+// ErrNotEncrypted is returned when the content does not begin with the header of an encrypted object
+var ErrNotEncrypted = errors.New("content is not encrypted by s3backup")
+
+// ValidateMaterial returns an error if the key material is too short to encrypt with
+func ValidateMaterial(material []byte) error {
+	if len(material) < MinMaterialSize {
+		return fmt.Errorf("key material must be at least %d bytes but got %d bytes", MinMaterialSize, len(material))
+	}
+	return nil
+}
+
+// NewWriter returns a writer which encrypts everything written to it with AES-256-GCM and writes it to w. A new key is
+// derived from the key material with DeriveKey and a random salt, which is written to w in the header of the content
+// along with the random prefix of the nonces. The content is sealed in segments so that it can be decrypted as it is
+// streamed, with the index of every segment and whether it is the final segment authenticated by its nonce so that
+// segments cannot be reordered, removed or appended. Close must be called to write the final segment
+func NewWriter(w io.Writer, material []byte) (io.WriteCloser, error) {
+	if err := ValidateMaterial(material); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[len(magic):], uint32(segmentSize))
+	salt := header[len(magic)+4 : len(magic)+4+SaltSize]
+	if _, err := rand.Read(header[len(magic)+4:]); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(material, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &writer{
+		w:       w,
+		segment: segment{aead: aead, header: header, size: segmentSize},
+		buf:     make([]byte, 0, segmentSize),
+	}, nil
+}
+
+// NewReader returns a reader which decrypts the content of r encrypted by NewWriter. Returns ErrNotEncrypted if the
+// content does not begin with the header of an encrypted object. Reading fails if the key is wrong or the content has
+// been modified or truncated, in which case nothing which was read should be trusted
+func NewReader(r io.Reader, material []byte) (io.Reader, error) {
+	if err := ValidateMaterial(material); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrNotEncrypted
+	} else if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(magic)-1], magic[:len(magic)-1]) {
+		return nil, ErrNotEncrypted
+	}
+	if header[len(magic)-1] != magic[len(magic)-1] {
+		return nil, fmt.Errorf("unsupported encryption format version: %d", header[len(magic)-1])
+	}
+
+	size := binary.BigEndian.Uint32(header[len(magic):])
+	if size == 0 || size > maxSegmentSize {
+		return nil, fmt.Errorf("invalid encryption segment size: %d", size)
+	}
+
+	aead, err := newAEAD(material, header[len(magic)+4:len(magic)+4+SaltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{
+		r:       bufio.NewReader(r),
+		segment: segment{aead: aead, header: header, size: int(size)},
+		sealed:  make([]byte, int(size)+aead.Overhead()),
+	}, nil
+}
+
+// EncryptFile writes the encrypted contents of the source file to the destination file
+func EncryptFile(material []byte, src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	writer, err := NewWriter(out, material)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, in); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// DecryptFile writes the decrypted contents of the source file to the destination file
+func DecryptFile(material []byte, src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reader, err := NewReader(in, material)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, reader); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// Returns AES-256-GCM with the key derived from the key material and salt
+func newAEAD(material []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := DeriveKey(material, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// The segments of encrypted content. Every segment is authenticated along with the header
+type segment struct {
+	aead    cipher.AEAD
+	header  []byte
+	size    int
+	counter uint32
+}
+
+// Returns the nonce of the next segment: the prefix of the header followed by the counter and the final flag
+func (s *segment) nonce(final bool) []byte {
+	nonce := make([]byte, s.aead.NonceSize())
+	copy(nonce, s.header[headerSize-noncePrefixSize:])
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], s.counter)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+type writer struct {
+	w io.Writer
+	segment
+	buf    []byte
+	closed bool
+}
+
+// A full segment is only sealed once more is written, as the final segment must be sealed as final when it is closed
+func (e *writer) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encryption writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == e.size {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):e.size], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Seals the final segment, which is only empty if the content is empty
+func (e *writer) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+func (e *writer) seal(final bool) error {
+	if e.counter == math.MaxUint32 {
+		return errors.New("content is too large to encrypt")
+	}
+	_, err := e.w.Write(e.aead.Seal(nil, e.nonce(final), e.buf, e.header))
+	e.buf = e.buf[:0]
+	e.counter++
+	return err
+}
+
+type reader struct {
+	r *bufio.Reader
+	segment
+	sealed []byte
+	plain  []byte
+	done   bool
+}
+
+func (d *reader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// Opens the next segment. A segment shorter than a full segment, or followed by the end of the content, is the final
+// segment and fails to open unless it was sealed as final, so content truncated on a segment boundary is detected
+func (d *reader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	final := err == io.ErrUnexpectedEOF || err == io.EOF
+	if err == nil {
+		if _, err = d.r.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	} else if !final {
+		return err
+	}
+
+	if d.counter == math.MaxUint32 {
+		return errors.New("content is too large to decrypt")
+	}
+	d.plain, err = d.aead.Open(d.sealed[:0], d.nonce(final), d.sealed[:n], d.header) // Decrypted in place
+	if err != nil {
+		return errors.New("failed to decrypt, the key is wrong or the content has been modified or truncated")
+	}
+	d.counter++
+	d.done = final
+	return nil
+}
@@ -0,0 +1,162 @@
+package encrypt
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testMaterial = []byte("correct horse battery staple")
+
+//----------------------------------------------
+//
+//             Stream Encryption Tests
+//
+//----------------------------------------------
+
+// Content of every size around the segment boundaries is decrypted to the content it was encrypted from
+func TestEncryptRoundTrip(t *testing.T) {
+	defer withSegmentSize(16)()
+
+	for _, size := range []int{0, 1, 15, 16, 17, 48, 100} {
+		contents := make([]byte, size)
+		rand.Read(contents)
+
+		encrypted := encryptBytes(t, contents, testMaterial)
+		if size > 0 && bytes.Contains(encrypted, contents) {
+			t.Error(fmt.Sprintf("expected the %d bytes of content not to be stored in plaintext", size))
+		}
+
+		decrypted, err := decryptBytes(encrypted, testMaterial)
+		if err != nil || !bytes.Equal(decrypted, contents) {
+			t.Error(fmt.Sprintf("expected %d bytes of content to be decrypted but got %d bytes: %v", size, len(decrypted), err))
+		}
+	}
+}
+
+// Every encryption of the same content derives a new key from a new salt
+func TestEncryptUniquePerRun(t *testing.T) {
+	contents := []byte("the same backup")
+	first := encryptBytes(t, contents, testMaterial)
+	second := encryptBytes(t, contents, testMaterial)
+
+	if bytes.Equal(first[:headerSize], second[:headerSize]) || bytes.Equal(first[headerSize:], second[headerSize:]) {
+		t.Error("expected each encryption to have its own salt and nonce prefix")
+	}
+}
+
+// Decryption fails with the wrong key material
+func TestDecryptWrongKey(t *testing.T) {
+	expectedErrString := "the key is wrong"
+
+	encrypted := encryptBytes(t, []byte("backup"), testMaterial)
+	_, err := decryptBytes(encrypted, []byte("the wrong key material"))
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Decryption fails when the content is modified or truncated on a segment boundary
+func TestDecryptTamperedContent(t *testing.T) {
+	defer withSegmentSize(16)()
+	expectedErrString := "has been modified or truncated"
+
+	contents := make([]byte, 40)
+	rand.Read(contents)
+	encrypted := encryptBytes(t, contents, testMaterial)
+
+	modified := append([]byte{}, encrypted...)
+	modified[len(modified)-1] ^= 0xff
+	truncated := encrypted[:headerSize+2*(16+16)] // The first two segments and their tags
+
+	for _, tampered := range [][]byte{modified, truncated} {
+		_, err := decryptBytes(tampered, testMaterial)
+		if err != nil && strings.Contains(err.Error(), expectedErrString) {
+			// Pass
+		} else {
+			t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+		}
+	}
+}
+
+// Content which was not encrypted by s3backup is rejected
+func TestDecryptNotEncrypted(t *testing.T) {
+	_, err := decryptBytes([]byte("an unencrypted backup which is long enough for a header"), testMaterial)
+	if err != ErrNotEncrypted {
+		t.Error(fmt.Sprintf("expected error '%v' but got: %v", ErrNotEncrypted, err))
+	}
+}
+
+// Key material shorter than the minimum size is rejected
+func TestEncryptShortKey(t *testing.T) {
+	expectedErrString := fmt.Sprintf("key material must be at least %d bytes", MinMaterialSize)
+
+	_, err := NewWriter(&bytes.Buffer{}, []byte("short"))
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// A file is decrypted to the file it was encrypted from
+func TestEncryptFile(t *testing.T) {
+	dir := t.TempDir()
+	src, encrypted, decrypted := filepath.Join(dir, "src"), filepath.Join(dir, "src.enc"), filepath.Join(dir, "decrypted")
+
+	contents := make([]byte, 3*segmentSize+1)
+	rand.Read(contents)
+	if err := ioutil.WriteFile(src, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EncryptFile(testMaterial, src, encrypted); err != nil {
+		t.Fatal(fmt.Sprintf("expected to encrypt the file: %v", err))
+	}
+	if err := DecryptFile(testMaterial, encrypted, decrypted); err != nil {
+		t.Fatal(fmt.Sprintf("expected to decrypt the file: %v", err))
+	}
+
+	restored, err := ioutil.ReadFile(decrypted)
+	if err != nil || !bytes.Equal(restored, contents) {
+		t.Error("expected the decrypted file to match the source file")
+	}
+}
+
+// Sets the segment size until the returned function is called
+func withSegmentSize(size int) func() {
+	previous := segmentSize
+	segmentSize = size
+	return func() { segmentSize = previous }
+}
+
+// Returns the contents encrypted with the key material
+func encryptBytes(t *testing.T, contents []byte, material []byte) []byte {
+	var encrypted bytes.Buffer
+	writer, err := NewWriter(&encrypted, material)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.Write(contents); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return encrypted.Bytes()
+}
+
+// Returns the contents decrypted with the key material
+func decryptBytes(encrypted []byte, material []byte) ([]byte, error) {
+	reader, err := NewReader(bytes.NewReader(encrypted), material)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,320 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/compress"
+	"s3backup/log"
+	"s3backup/s3client"
+	"s3backup/upload"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MigratedKey records an existing backup and the key it was migrated to
+type MigratedKey struct {
+	OldKey       string
+	NewKey       string
+	Recompressed bool // True if the object was downloaded and uploaded again as its compression changed
+}
+
+// MigrateBackups re-keys every backup of the source file name under the source dir to the bucket dir and S3 file name
+// of the migrate object, keeping the rotation prefix and timestamp of each key. Backups whose compression differs from
+// the migrate object are downloaded, recompressed and uploaded again, otherwise they are copied within the bucket.
+// The old key is only deleted once the new key has been written. Backups are migrated oldest first so that the order
+// of their last modified times, which rotation relies on, is preserved
+func MigrateBackups(svc *s3.S3, migrateObject MigrateObject, dryRun bool) ([]MigratedKey, error) {
+	migrated := []MigratedKey{}
+
+	if svc == nil {
+		return migrated, errors.New("svc must not be nil")
+	}
+
+	err := validationCheck(migrateObject)
+	if err != nil {
+		return migrated, err
+	}
+
+	log.Info.Println(`
+	######################################
+	#       Backup Migration Started     #
+	######################################
+	`)
+
+	keys, err := s3client.GetKeysByPrefix(svc, migrateObject.Bucket, migrateObject.SourceDir)
+	if err != nil {
+		return migrated, err
+	}
+
+	re := keyPattern(migrateObject)
+	sortedKeys := s3client.SortKeysByTime(keys)
+
+	for i := len(sortedKeys) - 1; i >= 0; i-- {
+		key := sortedKeys[i].Key
+		match := re.FindStringSubmatch(key)
+		if match == nil {
+			log.Info.Printf("Skipping key: '%s' as it is not a backup of '%s'\n", key, migrateObject.SourceFileName)
+			continue
+		}
+
+		migratedKey, err := migrateKey(svc, migrateObject, key, match[1], match[2], dryRun)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to migrate key '%s': %v", key, err)
+		}
+		if migratedKey != nil {
+			migrated = append(migrated, *migratedKey)
+		}
+	}
+
+	log.Info.Printf("The total number of keys migrated was: %d\n", len(migrated))
+
+	return migrated, nil
+}
+
+// Migrates a single key given the rotation prefix and timestamp parsed from it.
+// Returns nil if the key already matches the migrate object
+func migrateKey(svc *s3.S3, migrateObject MigrateObject, key string, prefix string, timestamp string, dryRun bool) (*MigratedKey, error) {
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(migrateObject.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	current, archive, err := getObjectFormat(key, head)
+	if err != nil {
+		return nil, err
+	}
+
+	var target compress.Compressor
+	extension := ""
+	if archive != "" {
+		// Chunk manifests reference their chunks by key so they are copied as they are and keep referencing the same chunks
+		if archive == upload.ArchiveFormatZip {
+			extension = ".zip"
+		}
+		if migrateObject.Compression != "" {
+			log.Warn.Printf("Key: '%s' is a %s archive and is migrated without compression\n", key, archive)
+		}
+	} else if migrateObject.Compression != "" {
+		target, _ = compress.Get(migrateObject.Compression) // Validated by validationCheck
+		extension = target.Extension()
+	}
+
+	newKey := migrateObject.BucketDir + prefix + migrateObject.S3FileName + timestamp + extension
+	recompress := archive == "" && compressorName(current) != compressorName(target)
+
+	if newKey == key && !recompress {
+		log.Info.Printf("Skipping key: '%s' as it has already been migrated\n", key)
+		return nil, nil
+	}
+
+	migratedKey := &MigratedKey{OldKey: key, NewKey: newKey, Recompressed: recompress}
+
+	if dryRun {
+		log.Info.Printf("Skipping migration of key: '%s' to '%s' as dry run has been enabled\n", key, newKey)
+		return migratedKey, nil
+	}
+
+	// Objects which are too large to copy are downloaded and uploaded again
+	if recompress || aws.Int64Value(head.ContentLength) > s3client.MaxCopyObjectSize {
+		log.Info.Printf("Migrating key: '%s' to '%s' by downloading and uploading it again\n", key, newKey)
+		err = reuploadObject(svc, migrateObject, key, newKey, head, current, target)
+	} else {
+		log.Info.Printf("Migrating key: '%s' to '%s' by copying it\n", key, newKey)
+		_, err = svc.CopyObject(&s3.CopyObjectInput{
+			Bucket:     aws.String(migrateObject.Bucket),
+			Key:        aws.String(newKey),
+			CopySource: aws.String((&url.URL{Path: migrateObject.Bucket + "/" + key}).EscapedPath()),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if newKey != key {
+		if _, err = s3client.DeleteKey(svc, migrateObject.Bucket, key); err != nil {
+			return nil, fmt.Errorf("migrated to '%s' but failed to delete the old key: %v", newKey, err)
+		}
+	}
+
+	log.Info.Printf("Migrated key: '%s' to '%s'\n", key, newKey)
+
+	return migratedKey, nil
+}
+
+// Downloads the object, decompresses it with the current compressor and compresses it with the target compressor
+// before uploading it to the new key with the metadata and tags of the object
+func reuploadObject(svc *s3.S3, migrateObject MigrateObject, key string, newKey string, head *s3.HeadObjectOutput, current compress.Compressor, target compress.Compressor) error {
+	tmpDir, err := ioutil.TempDir("", "s3backup-migrate-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The defaults of the downloader and uploader are used if the part size and number of workers are not specified
+	partSize := int64(migrateObject.PartSize * 1024 * 1024)
+	if partSize <= 0 {
+		partSize = s3manager.DefaultUploadPartSize
+	}
+	numWorkers := migrateObject.NumWorkers
+	if numWorkers <= 0 {
+		numWorkers = s3manager.DefaultUploadConcurrency
+	}
+
+	pathToFile := filepath.Join(tmpDir, "downloaded")
+	file, err := os.Create(pathToFile)
+	if err != nil {
+		return err
+	}
+
+	downloader := s3manager.NewDownloaderWithClient(svc, func(d *s3manager.Downloader) {
+		d.PartSize = partSize
+		d.Concurrency = numWorkers
+	})
+
+	_, err = downloader.Download(file, &s3.GetObjectInput{
+		Bucket: aws.String(migrateObject.Bucket),
+		Key:    aws.String(key),
+	})
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	if current != nil {
+		log.Info.Printf("Decompressing key: '%s' with %s\n", key, current.Name())
+		decompressed := filepath.Join(tmpDir, "decompressed")
+		if err = compress.DecompressFile(current, pathToFile, decompressed); err != nil {
+			return err
+		}
+		pathToFile = decompressed
+	}
+
+	metadata := map[string]*string{}
+	for metadataKey, value := range head.Metadata {
+		if http.CanonicalHeaderKey(metadataKey) != compress.MetadataKey {
+			metadata[metadataKey] = value
+		}
+	}
+
+	if target != nil {
+		log.Info.Printf("Compressing key: '%s' with %s\n", newKey, target.Name())
+		compressed := filepath.Join(tmpDir, "compressed")
+		if err = compress.CompressFile(target, migrateObject.CompressionLevel, pathToFile, compressed); err != nil {
+			return err
+		}
+		pathToFile = compressed
+		metadata[compress.MetadataKey] = aws.String(target.Name())
+	}
+
+	tags, err := s3client.GetObjectTags(svc, migrateObject.Bucket, key)
+	if err != nil {
+		return err
+	}
+
+	file, err = os.Open(pathToFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	uploader := s3manager.NewUploaderWithClient(svc, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = numWorkers
+		u.LeavePartsOnError = false
+	})
+
+	uploadParams := &s3manager.UploadInput{
+		Bucket:      aws.String(migrateObject.Bucket),
+		Key:         aws.String(newKey),
+		Body:        file,
+		Metadata:    metadata,
+		ContentType: head.ContentType,
+	}
+	if len(tags) > 0 {
+		values := url.Values{}
+		for tagKey, tagValue := range tags {
+			values.Set(tagKey, tagValue)
+		}
+		uploadParams.Tagging = aws.String(values.Encode())
+	}
+
+	_, err = uploader.Upload(uploadParams)
+	return err
+}
+
+// Returns the compressor the object was compressed with or nil if it is not compressed, and the archive format if the
+// object is an archive. The metadata of the object takes precedence over the extension of the key
+func getObjectFormat(key string, head *s3.HeadObjectOutput) (compress.Compressor, string, error) {
+	for metadataKey, value := range head.Metadata {
+		switch http.CanonicalHeaderKey(metadataKey) {
+		case compress.MetadataKey:
+			compressor, err := compress.Get(aws.StringValue(value))
+			return compressor, "", err
+		case upload.ArchiveMetadataKey:
+			return nil, aws.StringValue(value), nil
+		}
+	}
+
+	if compressor, ok := compress.ForKey(key); ok {
+		return compressor, "", nil
+	}
+
+	if strings.HasSuffix(key, ".zip") {
+		return nil, upload.ArchiveFormatZip, nil
+	}
+
+	return nil, "", nil
+}
+
+// Matches keys created by UploadFile and UploadZip for the source file, i.e. <sourcedir><prefix><sourcefilename>[_<timestamp>][<extension>]
+// The prefix and timestamp are captured so that they can be kept in the migrated key
+func keyPattern(migrateObject MigrateObject) *regexp.Regexp {
+	extensions := []string{regexp.QuoteMeta(".zip")}
+	for _, name := range compress.Names() {
+		compressor, _ := compress.Get(name)
+		extensions = append(extensions, regexp.QuoteMeta(compressor.Extension()))
+	}
+
+	return regexp.MustCompile("^" + regexp.QuoteMeta(migrateObject.SourceDir) + "([^/]*?)" +
+		regexp.QuoteMeta(migrateObject.SourceFileName) + `(_\d{8}T\d{6})?(?:` + strings.Join(extensions, "|") + ")?$")
+}
+
+func compressorName(compressor compress.Compressor) string {
+	if compressor == nil {
+		return ""
+	}
+	return compressor.Name()
+}
+
+func validationCheck(migrateObject MigrateObject) error {
+	if migrateObject.Bucket == "" {
+		return errors.New("bucket must be specified to migrate backups")
+	}
+
+	if migrateObject.SourceFileName == "" || migrateObject.S3FileName == "" {
+		return errors.New("source file name and S3 file name must be specified to migrate backups")
+	}
+
+	if migrateObject.Compression != "" {
+		compressor, err := compress.Get(migrateObject.Compression)
+		if err != nil {
+			return err
+		}
+		if !compressor.ValidLevel(migrateObject.CompressionLevel) {
+			return fmt.Errorf("invalid %s compression level: %d", compressor.Name(), migrateObject.CompressionLevel)
+		}
+	}
+
+	return nil
+}
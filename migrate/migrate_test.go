@@ -0,0 +1,205 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"s3backup/compress"
+	"s3backup/log"
+	"s3backup/s3mock"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mockBucket = "mockbucket"
+
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+}
+
+//----------------------------------------------
+// Migration Testing (mock S3)
+//	1: Backups are re-keyed to the new bucket dir and file name and the old keys are removed
+//	2: Backups are recompressed when the compression changes
+//	3: A dry run reports the migration without modifying the bucket
+//	4: Backups which already match are not migrated again
+//
+//----------------------------------------------
+
+// Test 1 - Migration Testing
+//	Backups are re-keyed to the new bucket dir and file name and the old keys are removed
+func TestMigrateRekeysBackups(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	seedBackups(mockS3)
+
+	migrated, err := MigrateBackups(mockS3.Client(), testMigrateObject(""), false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to migrate backups without any error: %v", err))
+	}
+
+	if len(migrated) != 3 {
+		t.Error(fmt.Sprintf("expected 3 keys to be migrated, got %d", len(migrated)))
+	}
+
+	expectedKeys := []string{
+		"new/daily_database_20190304T010000",
+		"new/monthly_database_20190301T010000",
+		"new/weekly_database_20190302T010000",
+		"old/notes.txt",
+	}
+	keys := mockS3.Keys(mockBucket)
+	if strings.Join(keys, ",") != strings.Join(expectedKeys, ",") {
+		t.Error(fmt.Sprintf("expected keys %v after migration but got %v", expectedKeys, keys))
+	}
+
+	if string(mockS3.Object(mockBucket, "new/daily_database_20190304T010000").Body) != "daily backup" {
+		t.Error("expected the contents of the backup to be preserved")
+	}
+
+	// Migrated oldest first so that the last modified order is preserved for rotation
+	if migrated[0].OldKey != "old/monthly_db_20190301T010000" || migrated[2].OldKey != "old/daily_db_20190304T010000" {
+		t.Error(fmt.Sprintf("expected backups to be migrated oldest first: %v", migrated))
+	}
+}
+
+// Test 2 - Migration Testing
+//	Backups are recompressed when the compression changes
+func TestMigrateRecompressesBackups(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	gzipCompressor, _ := compress.Get("gzip")
+	obj := mockS3.PutObject(mockBucket, "old/daily_db_20190304T010000.gz", compressBody(t, gzipCompressor, "daily backup"), time.Now())
+	obj.Header.Set("X-Amz-Meta-"+compress.MetadataKey, "gzip")
+	obj.Header.Set("X-Amz-Meta-S3backup-Md5", "abc123")
+	obj.Tags["app"] = "database"
+
+	migrated, err := MigrateBackups(mockS3.Client(), testMigrateObject("zstd"), false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to migrate backups without any error: %v", err))
+	}
+
+	newKey := "new/daily_database_20190304T010000.zst"
+	if len(migrated) != 1 || migrated[0].NewKey != newKey || !migrated[0].Recompressed {
+		t.Fatal(fmt.Sprintf("expected the backup to be recompressed to '%s': %v", newKey, migrated))
+	}
+
+	if mockS3.Object(mockBucket, "old/daily_db_20190304T010000.gz") != nil {
+		t.Error("expected the old key to be removed")
+	}
+
+	newObj := mockS3.Object(mockBucket, newKey)
+	if newObj == nil {
+		t.Fatal(fmt.Sprintf("expected key '%s' to exist", newKey))
+	}
+
+	zstdCompressor, _ := compress.Get("zstd")
+	if decompressBody(t, zstdCompressor, newObj.Body) != "daily backup" {
+		t.Error("expected the migrated backup to decompress to the original contents with zstd")
+	}
+
+	if newObj.Header.Get("X-Amz-Meta-"+compress.MetadataKey) != "zstd" || newObj.Header.Get("X-Amz-Meta-S3backup-Md5") != "abc123" {
+		t.Error(fmt.Sprintf("expected the compression metadata to be updated and other metadata kept: %v", newObj.Header))
+	}
+
+	if newObj.Tags["app"] != "database" {
+		t.Error(fmt.Sprintf("expected the tags to be kept: %v", newObj.Tags))
+	}
+}
+
+// Test 3 - Migration Testing
+//	A dry run reports the migration without modifying the bucket
+func TestMigrateDryRun(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	seedBackups(mockS3)
+	keysBefore := mockS3.Keys(mockBucket)
+
+	migrated, err := MigrateBackups(mockS3.Client(), testMigrateObject(""), true)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected dry run migration without any error: %v", err))
+	}
+
+	if len(migrated) != 3 {
+		t.Error(fmt.Sprintf("expected 3 keys to be reported, got %d", len(migrated)))
+	}
+
+	if strings.Join(mockS3.Keys(mockBucket), ",") != strings.Join(keysBefore, ",") {
+		t.Error("expected the bucket to be unchanged by a dry run")
+	}
+
+	if len(mockS3.Requests("CopyObject")) != 0 || len(mockS3.Requests("DeleteObject")) != 0 {
+		t.Error("expected no copy or delete requests in a dry run")
+	}
+}
+
+// Test 4 - Migration Testing
+//	Backups which already match are not migrated again
+func TestMigrateAlreadyMigrated(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	mockS3.PutObject(mockBucket, "new/daily_database_20190304T010000", []byte("daily backup"), time.Now())
+
+	migrateObject := testMigrateObject("")
+	migrateObject.SourceDir = "new/"
+	migrateObject.SourceFileName = "database"
+
+	migrated, err := MigrateBackups(mockS3.Client(), migrateObject, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected migration without any error: %v", err))
+	}
+
+	if len(migrated) != 0 || len(mockS3.Requests("CopyObject")) != 0 {
+		t.Error(fmt.Sprintf("expected no keys to be migrated: %v", migrated))
+	}
+}
+
+// Seeds a backup in each rotation tier under the old bucket dir along with an unrelated object
+func seedBackups(mockS3 *s3mock.Server) {
+	mockS3.PutObject(mockBucket, "old/monthly_db_20190301T010000", []byte("monthly backup"), time.Date(2019, time.March, 1, 1, 0, 0, 0, time.UTC))
+	mockS3.PutObject(mockBucket, "old/weekly_db_20190302T010000", []byte("weekly backup"), time.Date(2019, time.March, 2, 1, 0, 0, 0, time.UTC))
+	mockS3.PutObject(mockBucket, "old/daily_db_20190304T010000", []byte("daily backup"), time.Date(2019, time.March, 4, 1, 0, 0, 0, time.UTC))
+	mockS3.PutObject(mockBucket, "old/notes.txt", []byte("not a backup"), time.Date(2019, time.March, 4, 1, 0, 0, 0, time.UTC))
+}
+
+func testMigrateObject(compression string) MigrateObject {
+	return MigrateObject{
+		Bucket:         mockBucket,
+		SourceDir:      "old/",
+		SourceFileName: "db",
+		BucketDir:      "new/",
+		S3FileName:     "database",
+		Compression:    compression,
+		NumWorkers:     5,
+		PartSize:       50,
+	}
+}
+
+func compressBody(t *testing.T, compressor compress.Compressor, body string) []byte {
+	var buf bytes.Buffer
+	w, err := compressor.NewWriter(&buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(body))
+	w.Close()
+	return buf.Bytes()
+}
+
+func decompressBody(t *testing.T, compressor compress.Compressor, body []byte) string {
+	r, err := compressor.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	decompressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decompressed)
+}
@@ -0,0 +1,14 @@
+package migrate
+
+// MigrateObject represents the existing backups to migrate and the naming and compression they are migrated to
+type MigrateObject struct {
+	Bucket           string
+	SourceDir        string // The bucket dir the existing backups were uploaded to
+	SourceFileName   string // The S3 file name the existing backups were uploaded with
+	BucketDir        string // The bucket dir the backups are migrated to
+	S3FileName       string // The S3 file name the backups are migrated to
+	Compression      string // The algorithm the backups are compressed with once migrated. Empty if they are not compressed
+	CompressionLevel int
+	NumWorkers       int
+	PartSize         int
+}
@@ -0,0 +1,286 @@
+package reconcile
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/download"
+	"s3backup/log"
+	"s3backup/s3client"
+	"s3backup/upload"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// How a file was reconciled
+const (
+	ActionUpload   = "upload"   // The local file was uploaded as it was missing or differed remotely
+	ActionDownload = "download" // The remote file was downloaded as it was missing locally
+)
+
+// ReconciledFile records a file which was missing or differed and how it was reconciled
+type ReconciledFile struct {
+	Path   string // The path of the file in the local directory
+	Key    string
+	Action string // How the file was reconciled [upload|download]
+	Reason string // Why the file was reconciled, e.g. missing remotely
+}
+
+// Reconcile compares every regular file under the local directory against the files uploaded from it with UploadDir,
+// i.e. the keys under <bucketdir><s3filename>/, and uploads every file which is missing remotely or whose size or
+// checksum differs. Files are compared against the md5sum recorded on upload or, if none was recorded, against the
+// ETag of the object computed locally with its part size. A file which cannot be compared, such as an object
+// encrypted with SSE-KMS without a recorded md5sum, is uploaded again. If download remote only is enabled then the
+// files which are only stored remotely are downloaded into the local directory. Reconciliation stops at the first
+// file which fails. Returns every file which was reconciled
+func Reconcile(svc *s3.S3, reconcileObject ReconcileObject, dryRun bool) ([]ReconciledFile, error) {
+	reconciled := []ReconciledFile{}
+
+	if svc == nil {
+		return reconciled, errors.New("svc must not be nil")
+	}
+
+	uploadObject := reconcileObject.Upload
+	if err := validationCheck(uploadObject); err != nil {
+		return reconciled, err
+	}
+
+	log.Info.Println(`
+	######################################
+	#    Backup Reconciliation Started   #
+	######################################
+	`)
+
+	dir := uploadObject.PathToFile
+	dirKey := uploadObject.BucketDir + uploadObject.S3FileName + "/"
+
+	listing, err := s3client.ListByDelimiter(svc, uploadObject.Bucket, dirKey, "")
+	if err != nil {
+		return reconciled, err
+	}
+
+	remote := map[string]s3client.ListedObject{}
+	for _, obj := range listing.Objects {
+		if !strings.HasSuffix(obj.Key, "/") { // Folders created in the console are empty objects ending with '/'
+			remote[obj.Key] = obj
+		}
+	}
+	log.Info.Printf("Found %d remote files under '%s'\n", len(remote), dirKey)
+
+	local := map[string]bool{}
+	err = filepath.Walk(dir, func(pathToFile string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if hidden(info.Name(), uploadObject.IncludeDotfiles) && pathToFile != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			log.Warn.Printf("Skipping '%s' as it is not a regular file\n", pathToFile)
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, pathToFile)
+		if err != nil {
+			return err
+		}
+		key := dirKey + filepath.ToSlash(relPath)
+		local[key] = true
+
+		reason := "missing remotely"
+		if obj, ok := remote[key]; ok {
+			if reason, err = compareFile(svc, uploadObject.Bucket, pathToFile, info, obj); err != nil {
+				return fmt.Errorf("failed to compare '%s' with key '%s': %v", pathToFile, key, err)
+			}
+			if reason == "" {
+				return nil
+			}
+		}
+
+		log.Info.Printf("Uploading '%s' to key: '%s' as it is %s\n", pathToFile, key, reason)
+		if err = uploadFile(svc, uploadObject, pathToFile, key, dryRun); err != nil {
+			return fmt.Errorf("failed to upload '%s': %v", pathToFile, err)
+		}
+		reconciled = append(reconciled, ReconciledFile{Path: pathToFile, Key: key, Action: ActionUpload, Reason: reason})
+		return nil
+	})
+	if err != nil {
+		return reconciled, err
+	}
+
+	remoteOnly := []string{}
+	for key := range remote {
+		if !local[key] && !hiddenKey(strings.TrimPrefix(key, dirKey), uploadObject.IncludeDotfiles) {
+			remoteOnly = append(remoteOnly, key)
+		}
+	}
+	sort.Strings(remoteOnly)
+
+	for _, key := range remoteOnly {
+		pathToFile, err := localPath(dir, strings.TrimPrefix(key, dirKey))
+		if err != nil {
+			return reconciled, err
+		}
+
+		if !reconcileObject.DownloadRemoteOnly {
+			log.Info.Printf("Key: '%s' is missing locally. Enable download remote only to download it\n", key)
+			continue
+		}
+
+		log.Info.Printf("Downloading key: '%s' to '%s' as it is missing locally\n", key, pathToFile)
+		if dryRun {
+			log.Info.Printf("Skipping download of key: '%s' as dry run has been enabled\n", key)
+		} else {
+			err = download.DownloadFile(svc, download.DownloadObject{
+				DownloadLocation: pathToFile,
+				S3FileKey:        key,
+				Bucket:           uploadObject.Bucket,
+				NumWorkers:       uploadObject.NumWorkers,
+				PartSize:         uploadObject.PartSize,
+				BufferSize:       uploadObject.BufferSize,
+			})
+			if err != nil {
+				return reconciled, fmt.Errorf("failed to download key '%s': %v", key, err)
+			}
+		}
+		reconciled = append(reconciled, ReconciledFile{Path: pathToFile, Key: key, Action: ActionDownload, Reason: "missing locally"})
+	}
+
+	log.Info.Printf("The total number of files reconciled was: %d\n", len(reconciled))
+
+	return reconciled, nil
+}
+
+// Compares the local file with the remote object. Returns why the file differs or empty if it matches
+func compareFile(svc *s3.S3, bucket string, pathToFile string, info os.FileInfo, obj s3client.ListedObject) (string, error) {
+	if info.Size() != obj.Size {
+		return "a different size remotely", nil
+	}
+
+	// The size of the first part of a multipart object is the part size its ETag was computed with
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(obj.Key),
+		PartNumber: aws.Int64(1),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	recordedMD5 := ""
+	for metadataKey, value := range head.Metadata {
+		if http.CanonicalHeaderKey(metadataKey) == upload.ChecksumMetadataKey {
+			recordedMD5 = aws.StringValue(value)
+		}
+	}
+
+	etag := strings.Trim(aws.StringValue(head.ETag), "\"")
+	compareETag := aws.StringValue(head.ServerSideEncryption) != s3.ServerSideEncryptionAwsKms && head.SSECustomerAlgorithm == nil
+	if recordedMD5 == "" && !compareETag {
+		return "not comparable as its ETag is not an md5sum and no checksum was recorded on upload", nil
+	}
+
+	partSize := aws.Int64Value(head.ContentLength)
+	if partSize < 1 {
+		partSize = 1 // An empty object has a single empty part
+	}
+
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	local, err := upload.StreamETag(file, partSize, strings.Contains(etag, "-"))
+	if err != nil {
+		return "", err
+	}
+
+	if recordedMD5 != "" && recordedMD5 != local.MD5 {
+		return "a different checksum remotely", nil
+	}
+	if recordedMD5 == "" && etag != local.ETag {
+		return "a different ETag remotely", nil
+	}
+	return "", nil
+}
+
+// Uploads the file to the key with the settings of the upload object. The checksum of the file is recorded so that
+// it can be compared without computing its ETag the next time the directory is reconciled
+func uploadFile(svc *s3.S3, uploadObject upload.UploadObject, pathToFile string, key string, dryRun bool) error {
+	fileObject := uploadObject
+	fileObject.PathToFile = pathToFile
+	fileObject.BucketDir = path.Dir(key) + "/"
+	fileObject.S3FileName = path.Base(key)
+	fileObject.SkipIfUnchanged = true
+	fileObject.Ledger = ""
+	fileObject.DirManifest = false
+
+	_, err := upload.UploadFileWithResult(svc, fileObject, "", dryRun)
+	return err
+}
+
+// Returns the path in the directory of the file relative to the remote dir. Files which would be written outside of
+// the directory are rejected
+func localPath(dir string, relPath string) (string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	pathToFile := filepath.Join(root, filepath.FromSlash(relPath))
+	if !strings.HasPrefix(pathToFile, root+string(os.PathSeparator)) {
+		return "", fmt.Errorf("remote file '%s' would be downloaded outside of '%s'", relPath, dir)
+	}
+	return pathToFile, nil
+}
+
+// Returns true if the name begins with '.' and hidden files and directories are skipped, as with UploadDir
+func hidden(name string, includeDotfiles bool) bool {
+	return !includeDotfiles && strings.HasPrefix(name, ".")
+}
+
+// Returns true if any element of the relative path of the key is hidden
+func hiddenKey(relPath string, includeDotfiles bool) bool {
+	for _, name := range strings.Split(relPath, "/") {
+		if hidden(name, includeDotfiles) {
+			return true
+		}
+	}
+	return false
+}
+
+func validationCheck(uploadObject upload.UploadObject) error {
+	if uploadObject.Bucket == "" {
+		return errors.New("bucket must be specified to reconcile backups")
+	}
+
+	if uploadObject.S3FileName == "" || strings.Contains(uploadObject.S3FileName, "/") {
+		return errors.New("s3FileName must be specified without any '/' to reconcile backups")
+	}
+
+	fileInfo, err := os.Stat(uploadObject.PathToFile)
+	if err != nil {
+		return err
+	}
+
+	if !fileInfo.IsDir() {
+		return errors.New("path to file must be a directory to reconcile the files under it")
+	}
+
+	if uploadObject.Manipulate || uploadObject.Compression != "" || uploadObject.ChunkSize > 0 {
+		return errors.New("only directories uploaded without manipulation, compression or chunking can be reconciled")
+	}
+
+	return nil
+}
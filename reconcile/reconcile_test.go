@@ -0,0 +1,237 @@
+package reconcile
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"s3backup/log"
+	"s3backup/s3mock"
+	"s3backup/upload"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mockBucket = "mockbucket"
+
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+}
+
+//----------------------------------------------
+// Reconcile Testing (mock S3)
+//	1: Files missing remotely or differing by size checksum or ETag are uploaded and unchanged files are not
+//	2: Remote only files are downloaded only when download remote only is enabled
+//	3: A file encrypted with SSE-KMS without a recorded checksum is uploaded again
+//	4: A dry run reports the files to reconcile without uploading or downloading them
+//	5: Reconcile fails for a directory uploaded with compression
+//
+//----------------------------------------------
+
+// Test 1 - Reconcile Testing
+//	Files missing remotely or differing by size checksum or ETag are uploaded and unchanged files are not
+func TestReconcileUploadsMissingAndDiffering(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	reconcileObject := reconcileTestObject(t, map[string]string{
+		"unchanged.txt":        "unchanged contents",
+		"recorded.txt":         "recorded contents",
+		"etag.txt":             "local etag contents",
+		"checksum.txt":         "local checksum contents",
+		"size.txt":             "local contents of a different size",
+		"nested/missing.txt":   "missing contents",
+		".hidden/skipped.txt":  "skipped contents",
+	})
+	putRemote(mockS3, "reconcileTestDir/unchanged.txt", "unchanged contents", false)
+	putRemote(mockS3, "reconcileTestDir/recorded.txt", "recorded contents", true)
+	putRemote(mockS3, "reconcileTestDir/etag.txt", "other etag contents", false)
+	putRemote(mockS3, "reconcileTestDir/checksum.txt", "other checksum contents", true)
+	putRemote(mockS3, "reconcileTestDir/size.txt", "other contents", false)
+
+	reconciled, err := Reconcile(mockS3.Client(), reconcileObject, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to reconcile the directory without any error: %v", err))
+	}
+
+	expectedKeys := []string{
+		"reconcileTestDir/checksum.txt",
+		"reconcileTestDir/etag.txt",
+		"reconcileTestDir/nested/missing.txt",
+		"reconcileTestDir/size.txt",
+	}
+	if keys := uploadedKeys(mockS3); fmt.Sprint(keys) != fmt.Sprint(expectedKeys) {
+		t.Error(fmt.Sprintf("expected %v to be uploaded but got: %v", expectedKeys, keys))
+	}
+	if len(reconciled) != len(expectedKeys) {
+		t.Error(fmt.Sprintf("expected %d files to be reconciled but got: %d", len(expectedKeys), len(reconciled)))
+	}
+	for _, file := range reconciled {
+		if file.Action != ActionUpload {
+			t.Error(fmt.Sprintf("expected '%s' to be reconciled by upload but got: %s", file.Key, file.Action))
+		}
+	}
+
+	if string(mockS3.Object(mockBucket, "reconcileTestDir/etag.txt").Body) != "local etag contents" {
+		t.Error("expected the differing remote file to be replaced by the local file")
+	}
+	if mockS3.Object(mockBucket, "reconcileTestDir/nested/missing.txt").Header.Get("X-Amz-Meta-"+upload.ChecksumMetadataKey) != md5Hex("missing contents") {
+		t.Error("expected the checksum of the uploaded file to be recorded")
+	}
+}
+
+// Test 2 - Reconcile Testing
+//	Remote only files are downloaded only when download remote only is enabled
+func TestReconcileDownloadsRemoteOnly(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	reconcileObject := reconcileTestObject(t, map[string]string{"local.txt": "local contents"})
+	putRemote(mockS3, "reconcileTestDir/local.txt", "local contents", true)
+	putRemote(mockS3, "reconcileTestDir/nested/remote.txt", "remote contents", false)
+	putRemote(mockS3, "reconcileTestDir/nested/", "", false)
+
+	reconciled, err := Reconcile(mockS3.Client(), reconcileObject, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to reconcile the directory without any error: %v", err))
+	}
+	downloadedFile := filepath.Join(reconcileObject.Upload.PathToFile, "nested", "remote.txt")
+	if len(reconciled) != 0 {
+		t.Error(fmt.Sprintf("expected no files to be reconciled without download remote only but got: %v", reconciled))
+	}
+	if _, err := os.Stat(downloadedFile); !os.IsNotExist(err) {
+		t.Error("expected the remote only file not to be downloaded without download remote only")
+	}
+
+	reconcileObject.DownloadRemoteOnly = true
+	reconciled, err = Reconcile(mockS3.Client(), reconcileObject, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to reconcile the directory without any error: %v", err))
+	}
+	if len(reconciled) != 1 || reconciled[0].Action != ActionDownload || reconciled[0].Key != "reconcileTestDir/nested/remote.txt" {
+		t.Error(fmt.Sprintf("expected only the remote only file to be downloaded but got: %v", reconciled))
+	}
+	if contents, err := ioutil.ReadFile(downloadedFile); err != nil || string(contents) != "remote contents" {
+		t.Error(fmt.Sprintf("expected the remote only file to be downloaded into the directory but got: %s %v", contents, err))
+	}
+	if len(uploadedKeys(mockS3)) != 0 {
+		t.Error(fmt.Sprintf("expected no files to be uploaded but got: %v", uploadedKeys(mockS3)))
+	}
+}
+
+// Test 3 - Reconcile Testing
+//	A file encrypted with SSE-KMS without a recorded checksum is uploaded again
+func TestReconcileKMSWithoutChecksum(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	reconcileObject := reconcileTestObject(t, map[string]string{"kms.txt": "kms contents", "recorded.txt": "recorded contents"})
+	putRemote(mockS3, "reconcileTestDir/kms.txt", "kms contents", false)
+	mockS3.SetObjectHeader(mockBucket, "reconcileTestDir/kms.txt", "X-Amz-Server-Side-Encryption", "aws:kms")
+	putRemote(mockS3, "reconcileTestDir/recorded.txt", "recorded contents", true)
+	mockS3.SetObjectHeader(mockBucket, "reconcileTestDir/recorded.txt", "X-Amz-Server-Side-Encryption", "aws:kms")
+
+	reconciled, err := Reconcile(mockS3.Client(), reconcileObject, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to reconcile the directory without any error: %v", err))
+	}
+	if keys := uploadedKeys(mockS3); fmt.Sprint(keys) != "[reconcileTestDir/kms.txt]" {
+		t.Error(fmt.Sprintf("expected only the file without a recorded checksum to be uploaded but got: %v", keys))
+	}
+	if len(reconciled) != 1 || !strings.Contains(reconciled[0].Reason, "no checksum was recorded") {
+		t.Error(fmt.Sprintf("expected the file to be reconciled as it could not be compared but got: %v", reconciled))
+	}
+}
+
+// Test 4 - Reconcile Testing
+//	A dry run reports the files to reconcile without uploading or downloading them
+func TestReconcileDryRun(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	reconcileObject := reconcileTestObject(t, map[string]string{"missing.txt": "missing contents"})
+	reconcileObject.DownloadRemoteOnly = true
+	putRemote(mockS3, "reconcileTestDir/remote.txt", "remote contents", false)
+
+	reconciled, err := Reconcile(mockS3.Client(), reconcileObject, true)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to reconcile the directory without any error: %v", err))
+	}
+	if len(reconciled) != 2 {
+		t.Error(fmt.Sprintf("expected the missing and remote only files to be reported but got: %v", reconciled))
+	}
+	if len(mockS3.Requests("PutObject")) != 0 || len(mockS3.Requests("CreateMultipartUpload")) != 0 || len(mockS3.Requests("GetObject")) != 0 {
+		t.Error("expected no files to be uploaded or downloaded during a dry run")
+	}
+	if _, err := os.Stat(filepath.Join(reconcileObject.Upload.PathToFile, "remote.txt")); !os.IsNotExist(err) {
+		t.Error("expected the remote only file not to be downloaded during a dry run")
+	}
+}
+
+// Test 5 - Reconcile Testing
+//	Reconcile fails for a directory uploaded with compression
+func TestReconcileCompressionRejected(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	reconcileObject := reconcileTestObject(t, map[string]string{"a.txt": "contents"})
+	reconcileObject.Upload.Compression = "gzip"
+
+	expectedErrString := "only directories uploaded without manipulation, compression or chunking can be reconciled"
+	_, err := Reconcile(mockS3.Client(), reconcileObject, false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+// Helper functions for testing below
+//----------------------------------------------
+
+func reconcileTestObject(t *testing.T, files map[string]string) ReconcileObject {
+	dir := t.TempDir()
+	for name, contents := range files {
+		pathToFile := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(pathToFile), 0755)
+		ioutil.WriteFile(pathToFile, []byte(contents), 0644)
+	}
+
+	return ReconcileObject{
+		Upload: upload.UploadObject{
+			PathToFile: dir,
+			S3FileName: "reconcileTestDir",
+			Bucket:     mockBucket,
+			Timeout:    time.Hour,
+			NumWorkers: 3,
+			PartSize:   5,
+		},
+	}
+}
+
+// Stores the remote file, recording the checksum of its contents as it would have been on upload if recorded is true
+func putRemote(mockS3 *s3mock.Server, key string, contents string, recorded bool) {
+	mockS3.PutObject(mockBucket, key, []byte(contents), time.Now())
+	if recorded {
+		mockS3.SetObjectHeader(mockBucket, key, "X-Amz-Meta-"+upload.ChecksumMetadataKey, md5Hex(contents))
+	}
+}
+
+func uploadedKeys(mockS3 *s3mock.Server) []string {
+	keys := []string{}
+	for _, req := range mockS3.Requests("PutObject") {
+		keys = append(keys, req.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func md5Hex(contents string) string {
+	md5sum := md5.Sum([]byte(contents))
+	return hex.EncodeToString(md5sum[:])
+}
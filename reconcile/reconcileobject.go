@@ -0,0 +1,9 @@
+package reconcile
+
+import "s3backup/upload"
+
+// ReconcileObject represents a local backup directory to reconcile against the files uploaded from it
+type ReconcileObject struct {
+	Upload             upload.UploadObject // The directory at the path to file, the remote dir <bucketdir><s3filename>/ and the settings missing files are uploaded with
+	DownloadRemoteOnly bool                // Download files which are only stored remotely into the local directory
+}
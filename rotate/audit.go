@@ -0,0 +1,232 @@
+package rotate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/s3client"
+		"path"
+	"strings"
+	"time"
+)
+
+// RotationAudit is the history of every rotation recorded in the audit object.
+// Each run is chained to the previous run by its hash so that modifications to the history can be detected
+type RotationAudit struct {
+	Runs []AuditRun `json:"runs"`
+}
+
+// AuditOptions configures how the audit object is stored
+type AuditOptions struct {
+	Compress bool  // Store the history as gzip compressed newline delimited JSON with a run on each line
+	MaxBytes int64 // Roll the history over to a new object once the audit object would exceed this size. 0 disables rollover
+}
+
+// AuditRun records the keys deleted by a single rotation
+type AuditRun struct {
+	Time         time.Time         `json:"time"`
+	Bucket       string            `json:"bucket"`
+	BucketDir    string            `json:"bucketDir"`
+	DeletedKeys  []AuditDeletedKey `json:"deletedKeys"`
+	PreviousHash string            `json:"previousHash"`          // Hash of the previous run, empty for the first run
+	PreviousKey  string            `json:"previousKey,omitempty"` // Key the history was rolled over to, set on the first run after a rollover
+	Hash         string            `json:"hash"`                  // sha256 of the run with an empty hash
+}
+
+// AuditDeletedKey records a key deleted by rotation and why it was deleted
+type AuditDeletedKey struct {
+	Key          string    `json:"key"`
+	LastModified time.Time `json:"lastModified"`
+	Reason       string    `json:"reason"`
+}
+
+// WriteRotationAudit appends a run recording the deleted keys to the audit object stored under the audit key. The
+// audit object is read, the run appended and the object written again as S3 cannot append to an object. If the audit
+// object would exceed the max size of the options then the history is rolled over: the current object is copied to a
+// key with the time of its last run and the audit object is replaced by a history starting with the new run
+func WriteRotationAudit(svc *s3.S3, bucket string, auditKey string, bucketDir string, deletedKeys []AuditDeletedKey, runTime time.Time, options AuditOptions) error {
+	if auditKey == "" {
+		return fmt.Errorf("audit key must be specified to write the rotation audit")
+	}
+
+	audit, existingBody, err := getRotationAudit(svc, bucket, auditKey)
+	if err != nil {
+		return err
+	}
+
+	if err = audit.Verify(); err != nil {
+		log.Warn.Printf("Rotation audit object: '%s' may have been tampered with: %v\n", auditKey, err)
+	}
+
+	run := AuditRun{
+		Time:        runTime.UTC(),
+		Bucket:      bucket,
+		BucketDir:   bucketDir,
+		DeletedKeys: append([]AuditDeletedKey{}, deletedKeys...),
+	}
+	if len(audit.Runs) > 0 {
+		run.PreviousHash = audit.Runs[len(audit.Runs)-1].Hash
+	}
+	if run.Hash, err = run.computeHash(); err != nil {
+		return err
+	}
+
+	body, err := encodeRotationAudit(RotationAudit{Runs: append(audit.Runs, run)}, options.Compress)
+	if err != nil {
+		return err
+	}
+
+	if options.MaxBytes > 0 && int64(len(body)) > options.MaxBytes && len(audit.Runs) > 0 {
+		rolledKey := rolledAuditKey(auditKey, audit.Runs[len(audit.Runs)-1].Time)
+		if err = s3client.PutObjectBody(svc, bucket, rolledKey, existingBody, auditContentType(existingBody)); err != nil {
+			return err
+		}
+		log.Info.Printf("Rotation audit object: '%s' exceeds %d bytes and has been rolled over to: '%s'\n", auditKey, options.MaxBytes, rolledKey)
+
+		// The new history is chained to the history which was rolled over
+		run.PreviousKey = rolledKey
+		if run.Hash, err = run.computeHash(); err != nil {
+			return err
+		}
+		if body, err = encodeRotationAudit(RotationAudit{Runs: []AuditRun{run}}, options.Compress); err != nil {
+			return err
+		}
+	}
+
+	return s3client.PutObjectBody(svc, bucket, auditKey, body, auditContentType(body))
+}
+
+// GetRotationAudit returns the rotation history stored in the audit object. An empty history is returned if it does not exist.
+// Both a JSON audit object and a gzip compressed newline delimited JSON history are read
+func GetRotationAudit(svc *s3.S3, bucket string, auditKey string) (RotationAudit, error) {
+	audit, _, err := getRotationAudit(svc, bucket, auditKey)
+	return audit, err
+}
+
+// Returns the rotation history and the body of the audit object it was read from
+func getRotationAudit(svc *s3.S3, bucket string, auditKey string) (RotationAudit, []byte, error) {
+	audit := RotationAudit{Runs: []AuditRun{}}
+
+	body, err := s3client.GetObjectBody(svc, bucket, auditKey)
+	if err != nil || body == nil {
+		return audit, body, err
+	}
+
+	if !isGzip(body) {
+		if err = json.Unmarshal(body, &audit); err != nil {
+			return audit, body, fmt.Errorf("failed to parse rotation audit object '%s': %v", auditKey, err)
+		}
+		return audit, body, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return audit, body, fmt.Errorf("failed to decompress rotation audit object '%s': %v", auditKey, err)
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024) // A run which deleted many keys is a long line
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var run AuditRun
+		if err = json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			return audit, body, fmt.Errorf("failed to parse run %d of rotation audit object '%s': %v", len(audit.Runs)+1, auditKey, err)
+		}
+		audit.Runs = append(audit.Runs, run)
+	}
+	if err = scanner.Err(); err != nil {
+		return audit, body, fmt.Errorf("failed to decompress rotation audit object '%s': %v", auditKey, err)
+	}
+
+	return audit, body, nil
+}
+
+// Encodes the history as a JSON document, or as gzip compressed newline delimited JSON if it is compressed
+func encodeRotationAudit(audit RotationAudit, compress bool) ([]byte, error) {
+	if !compress {
+		return json.MarshalIndent(audit, "", "  ")
+	}
+
+	var body bytes.Buffer
+	writer := gzip.NewWriter(&body)
+	for _, run := range audit.Runs {
+		line, err := json.Marshal(run)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = writer.Write(append(line, '\n')); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// Returns the key a history is rolled over to, the audit key with the time of its last run inserted before the
+// extension e.g. audit/rotation_audit_20240101T020000.ndjson.gz
+func rolledAuditKey(auditKey string, lastRunTime time.Time) string {
+	dir, name := path.Split(auditKey)
+	timestamp := "_" + lastRunTime.UTC().Format("20060102T150405")
+	if i := strings.Index(name, "."); i > 0 {
+		return dir + name[:i] + timestamp + name[i:]
+	}
+	return dir + name + timestamp
+}
+
+func auditContentType(body []byte) string {
+	if isGzip(body) {
+		return "application/gzip"
+	}
+	return "application/json"
+}
+
+// Returns true if the body starts with the gzip magic number
+func isGzip(body []byte) bool {
+	return len(body) > 1 && body[0] == 0x1f && body[1] == 0x8b
+}
+
+// Verify checks that the hash of every run is valid and chained to the previous run. The first run of a history which
+// was rolled over is chained to the last run of the history recorded under its previous key
+func (audit RotationAudit) Verify() error {
+	previousHash := ""
+	if len(audit.Runs) > 0 && audit.Runs[0].PreviousKey != "" {
+		previousHash = audit.Runs[0].PreviousHash
+	}
+	for i, run := range audit.Runs {
+		if run.PreviousHash != previousHash {
+			return fmt.Errorf("run %d is not chained to the previous run", i+1)
+		}
+
+		hash, err := run.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != run.Hash {
+			return fmt.Errorf("hash of run %d does not match its contents", i+1)
+		}
+
+		previousHash = run.Hash
+	}
+	return nil
+}
+
+func (run AuditRun) computeHash() (string, error) {
+	run.Hash = ""
+	body, err := json.Marshal(run)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,61 @@
+package rotate
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/s3client"
+	"time"
+)
+
+// Tracks the keys deleted by a rotation. Some providers briefly continue to list a deleted key, so every deleted key
+// is confirmed to be gone with HeadObject and is excluded from any later listing of the same rotation. This prevents
+// a key which is still listed from being deleted again or counted as a retained key
+type deletionTracker struct {
+	attempts int           // Times a deleted key is checked until it is no longer found. 0 disables the check
+	interval time.Duration // Time between each check of a deleted key
+	deleted  map[string]bool
+	allowed  map[string]bool // If set then only these keys are deleted
+}
+
+func newDeletionTracker(attempts int, interval time.Duration, allowed map[string]bool) *deletionTracker {
+	return &deletionTracker{attempts: attempts, interval: interval, deleted: map[string]bool{}, allowed: allowed}
+}
+
+// Deletes the key unless it has already been deleted by this rotation and confirms that it is no longer found. A key
+// which is still found once every check has been made is logged but is still treated as deleted, as S3 accepted the
+// delete. Returns true if the key was deleted
+func (t *deletionTracker) delete(svc *s3.S3, bucket string, key string) (bool, error) {
+	if t.deleted[key] {
+		log.Warn.Printf("Skipping deletion of key: '%s' as it has already been deleted by this rotation\n", key)
+		return false, nil
+	}
+	if t.allowed != nil && !t.allowed[key] {
+		log.Warn.Printf("Skipping deletion of key: '%s' as it is not one of the keys confirmed for deletion\n", key)
+		return false, nil
+	}
+
+	if _, err := s3client.DeleteKey(svc, bucket, key); err != nil {
+		return false, err
+	}
+	t.deleted[key] = true
+
+	if t.attempts > 0 {
+		if err := s3client.WaitForObjectDeleted(svc, bucket, key, t.attempts, t.interval); err != nil {
+			log.Warn.Printf("Failed to confirm the deletion of key: '%s'. It is excluded from the rest of this rotation: %v\n", key, err)
+		}
+	}
+	return true, nil
+}
+
+// Returns the keys which have not been deleted by this rotation. The order of the keys is preserved
+func (t *deletionTracker) exclude(keys []s3client.BucketEntry) []s3client.BucketEntry {
+	remaining := []s3client.BucketEntry{}
+	for _, kv := range keys {
+		if t.deleted[kv.Key] {
+			log.Info.Printf("Ignoring key: '%s' as it is still listed after it was deleted\n", kv.Key)
+			continue
+		}
+		remaining = append(remaining, kv)
+	}
+	return remaining
+}
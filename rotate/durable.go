@@ -0,0 +1,50 @@
+package rotate
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/rpolicy"
+	"s3backup/s3client"
+	"time"
+)
+
+// DurabilityPolicy controls how long rotation is delayed after an upload and how the uploaded object is confirmed
+type DurabilityPolicy struct {
+	PostUploadDelay    time.Duration // Time to wait after the upload before polling for the object
+	PollInterval       time.Duration // Time between each HeadObject request
+	Timeout            time.Duration // Maximum time to poll for the object before giving up
+	RequireReplication bool          // If enabled then the object must also report a replication status of COMPLETED
+}
+
+// StartRotationWhenDurable waits until the uploaded key is confirmed to be present in the bucket and then starts the
+// GFS rotation. If the key cannot be confirmed then no rotation is performed and an error is returned
+func StartRotationWhenDurable(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, bucketDir string, uploadedKey string, durability DurabilityPolicy, dryRun bool) ([]string, error) {
+	if err := ConfirmDurable(svc, bucket, uploadedKey, durability, dryRun); err != nil {
+		return nil, err
+	}
+
+	return StartRotation(svc, bucket, policy, bucketDir, dryRun), nil
+}
+
+// ConfirmDurable waits for the post upload delay and then until the uploaded key is confirmed to be present in the
+// bucket, and replicated if required by the durability policy. The check is skipped if dry run is enabled
+func ConfirmDurable(svc *s3.S3, bucket string, uploadedKey string, durability DurabilityPolicy, dryRun bool) error {
+	if dryRun {
+		log.Info.Println("Dry run enabled, skipping durability check of key: " + uploadedKey)
+		return nil
+	}
+
+	if durability.PostUploadDelay > 0 {
+		log.Info.Printf("Delaying rotation for %v after upload\n", durability.PostUploadDelay)
+		time.Sleep(durability.PostUploadDelay)
+	}
+
+	log.Info.Printf("Confirming key: '%s' is present before rotation\n", uploadedKey)
+	err := s3client.WaitForObject(svc, bucket, uploadedKey, durability.RequireReplication, durability.PollInterval, durability.Timeout)
+	if err != nil {
+		return err
+	}
+	log.Info.Printf("Confirmed key: '%s' is present, starting rotation\n", uploadedKey)
+
+	return nil
+}
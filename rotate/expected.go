@@ -0,0 +1,40 @@
+package rotate
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/rpolicy"
+)
+
+// CheckMinExpectedObjects returns an error if fewer backups than the min expected objects of the policy are stored
+// under the bucket dir across every rotation tier. A bucket which is unexpectedly empty, e.g. because the backups were
+// written to the wrong bucket dir or an upload silently wrote nothing, is a sign that rotation would delete the only
+// good backups. Keys which are ignored by rotation as they do not end with a key timestamp are not counted. The check
+// is skipped if the policy does not expect any objects
+func CheckMinExpectedObjects(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, bucketDir string) error {
+	if policy.MinExpectedObjects <= 0 {
+		return nil
+	}
+
+	order, err := newKeyOrder(policy)
+	if err != nil {
+		return err
+	}
+
+	found := 0
+	for _, prefix := range policy.Prefixes() {
+		keys, err := order.sortedKeys(svc, bucket, prefix, bucketDir)
+		if err != nil {
+			return err
+		}
+		found += len(order.filter(keys, prefix))
+	}
+
+	if found < policy.MinExpectedObjects {
+		return fmt.Errorf("found %d backups under '%s' in bucket '%s' but at least %d are expected", found, bucketDir, bucket, policy.MinExpectedObjects)
+	}
+
+	log.Info.Printf("Found %d backups under '%s' which meets the minimum of %d expected\n", found, bucketDir, policy.MinExpectedObjects)
+	return nil
+}
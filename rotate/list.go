@@ -0,0 +1,55 @@
+package rotate
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/rpolicy"
+	"s3backup/s3client"
+	"strings"
+	"time"
+)
+
+// TierListing is the backups of a single rotation tier
+type TierListing struct {
+	Tier    string         `json:"tier"`    // The rotation tier without the trailing underscore, e.g. daily
+	Prefix  string         `json:"prefix"`  // The prefix of the keys of the tier under the bucket dir, e.g. daily_
+	Backups []ListedBackup `json:"backups"` // Ordered with the most recent backup first
+}
+
+// ListedBackup is a single backup of a rotation tier
+type ListedBackup struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// ListTiers lists the backups of the daily, weekly, monthly and yearly tiers of the policy under the bucket dir. The backups of
+// each tier are ordered with the most recent first by the timestamp in the key time layout of the policy, in the same
+// order rotation keeps them, and by their last modified time if the key does not end with a timestamp
+func ListTiers(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, bucketDir string) ([]TierListing, error) {
+	order, err := newKeyOrder(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	tiers := []TierListing{}
+	for _, prefix := range policy.Prefixes() {
+		listing, err := s3client.ListByDelimiter(svc, bucket, bucketDir+prefix, "")
+		if err != nil {
+			return nil, err
+		}
+
+		keys := make(map[string]time.Time)
+		sizes := make(map[string]int64)
+		for _, obj := range listing.Objects {
+			keys[obj.Key] = obj.ModifiedTime
+			sizes[obj.Key] = obj.Size
+		}
+
+		tier := TierListing{Tier: strings.TrimSuffix(prefix, "_"), Prefix: prefix, Backups: []ListedBackup{}}
+		for _, kv := range s3client.SortKeysByKeyTime(keys, order.parse) {
+			tier.Backups = append(tier.Backups, ListedBackup{Key: kv.Key, Size: sizes[kv.Key], LastModified: kv.ModifiedTime})
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
@@ -0,0 +1,112 @@
+package rotate
+
+import (
+	"fmt"
+	"s3backup/log"
+	"s3backup/s3client"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// What rotation did with each key of a report
+const (
+	ReportActionDelete = "delete" // The key was deleted, or would be deleted by a dry run
+	ReportActionKeep   = "keep"   // The key was kept
+)
+
+// RotationReport records every key each tier of a rotation considered, whether it was deleted or kept and the
+// retention rule which decided it, so that the decisions of a dry run can be reviewed before keys are deleted
+type RotationReport struct {
+	Bucket    string       `json:"bucket"`
+	BucketDir string       `json:"bucketDir"`
+	RunTime   time.Time    `json:"runTime"`
+	DryRun    bool         `json:"dryRun"`
+	Deleted   int          `json:"deleted"`
+	Kept      int          `json:"kept"`
+	Tiers     []TierReport `json:"tiers"`
+}
+
+// TierReport records the keys of a rotation tier in order from the newest key to the oldest
+type TierReport struct {
+	Prefix               string        `json:"prefix"`
+	RetentionCount       int           `json:"retentionCount"`
+	RetentionPeriodHours float64       `json:"retentionPeriodHours"`
+	Deleted              int           `json:"deleted"`
+	Kept                 int           `json:"kept"`
+	Keys                 []ReportedKey `json:"keys"`
+
+	runTime time.Time // The run time of the rotation which the age of every key is measured from
+}
+
+// ReportedKey is a key considered by rotation with its age at the run time of the rotation
+type ReportedKey struct {
+	Key          string    `json:"key"`
+	LastModified time.Time `json:"lastModified"`
+	AgeHours     float64   `json:"ageHours"`
+	Action       string    `json:"action"`
+	Reason       string    `json:"reason"`
+}
+
+func newTierReport(prefix string, retentionCount int, retentionPeriod time.Duration, runTime time.Time) *TierReport {
+	return &TierReport{Prefix: prefix, RetentionCount: retentionCount, RetentionPeriodHours: retentionPeriod.Hours(), Keys: []ReportedKey{}, runTime: runTime}
+}
+
+// Records that the key was deleted, or would be deleted by a dry run, for the reason
+func (r *TierReport) delete(kv s3client.BucketEntry, reason string) {
+	r.record(kv, ReportActionDelete, reason)
+	r.Deleted++
+}
+
+// Records that the key was kept for the reason
+func (r *TierReport) keep(kv s3client.BucketEntry, reason string) {
+	r.record(kv, ReportActionKeep, reason)
+	r.Kept++
+}
+
+func (r *TierReport) record(kv s3client.BucketEntry, action string, reason string) {
+	r.Keys = append(r.Keys, ReportedKey{
+		Key:          kv.Key,
+		LastModified: kv.ModifiedTime.UTC(),
+		AgeHours:     r.runTime.Sub(kv.ModifiedTime).Hours(),
+		Action:       action,
+		Reason:       reason,
+	})
+}
+
+// Adds the report of a tier to the rotation report and its counts to the totals
+func (report *RotationReport) add(tier *TierReport) {
+	report.Tiers = append(report.Tiers, *tier)
+	report.Deleted += tier.Deleted
+	report.Kept += tier.Kept
+}
+
+// Table returns the report as a table with a row for each key and a summary of the keys deleted and kept by each tier
+func (report RotationReport) Table() string {
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "TIER\tKEY\tAGE (HOURS)\tACTION\tREASON")
+	for _, tier := range report.Tiers {
+		for _, key := range tier.Keys {
+			fmt.Fprintf(w, "%s\t%s\t%0.1f\t%s\t%s\n", tier.Prefix, key.Key, key.AgeHours, key.Action, key.Reason)
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "TIER\tRETENTION COUNT\tRETENTION PERIOD (HOURS)\tDELETE\tKEEP")
+	for _, tier := range report.Tiers {
+		fmt.Fprintf(w, "%s\t%d\t%0.1f\t%d\t%d\n", tier.Prefix, tier.RetentionCount, tier.RetentionPeriodHours, tier.Deleted, tier.Kept)
+	}
+	fmt.Fprintf(w, "total\t\t\t%d\t%d\n", report.Deleted, report.Kept)
+	w.Flush()
+
+	return table.String()
+}
+
+// Logs the report of a dry run as a table, a line at a time so that each row is prefixed like any other log
+func logDryRunReport(report RotationReport) {
+	log.Info.Printf("Dry run rotation of bucket: '%s' would delete %d key(s) and keep %d key(s)\n", report.Bucket, report.Deleted, report.Kept)
+	for _, line := range strings.Split(strings.TrimRight(report.Table(), "\n"), "\n") {
+		log.Info.Println(line)
+	}
+}
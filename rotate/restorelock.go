@@ -0,0 +1,40 @@
+package rotate
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/rpolicy"
+	"s3backup/s3client"
+	"strings"
+	"time"
+)
+
+// WaitForRestores waits for every restore holding a lock under the bucket dir to release it, so that rotation does not
+// delete a backup while it is being restored. The locks are polled until the restore lock wait of the policy elapses.
+// Returns an error if a restore still holds a lock once the wait has elapsed, in which case rotation should be skipped
+func WaitForRestores(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, bucketDir string) error {
+	deadline := time.Now().Add(policy.RestoreLockWait)
+
+	for {
+		locks, err := s3client.GetRestoreLocks(svc, bucket, bucketDir, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to check for restores in progress: %v", err)
+		}
+
+		if len(locks) == 0 {
+			return nil
+		}
+
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return fmt.Errorf("%d restores hold a lock under '%s' in bucket '%s': %s", len(locks), bucketDir, bucket, strings.Join(locks, ", "))
+		}
+
+		log.Info.Printf("Waiting for %d restores holding a lock under '%s' to finish before rotating\n", len(locks), bucketDir)
+		if remaining > policy.RestoreLockPollInterval {
+			remaining = policy.RestoreLockPollInterval
+		}
+		time.Sleep(remaining)
+	}
+}
@@ -1,16 +1,25 @@
 package rotate
 
 import (
+	"context"
+	"fmt"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"s3backup/log"
 	"s3backup/rpolicy"
 	"s3backup/s3client"
-	"s3backup/util"
 	"time"
 )
 
 // StartRotation initiates the GFS rotation with the provided policy
 func StartRotation(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, bucketDir string, dryRun bool) []string {
+	deletedKeys, _ := StartRotationWithReport(svc, bucket, policy, bucketDir, dryRun)
+	return deletedKeys
+}
+
+// StartRotationWithReport initiates the GFS rotation with the provided policy like StartRotation and also returns a
+// report of every key each tier considered, whether it was deleted or kept and why. The report of a dry run records
+// the keys which would be deleted and is logged as a table
+func StartRotationWithReport(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, bucketDir string, dryRun bool) ([]string, RotationReport) {
 	log.Info.Println(`
 	######################################
 	#  s3backup Rotation Started!   #
@@ -19,8 +28,30 @@ func StartRotation(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, buc
 
 	log.Info.Println("Starting GFS rotation")
 
-	// Keys to be returned at end of both daily and weekly rotation
+	// Keys to be returned at end of the daily, weekly, monthly and yearly rotation
 	deletedKeys := []string{}
+	report := RotationReport{Bucket: bucket, BucketDir: bucketDir, RunTime: time.Now().UTC(), DryRun: dryRun, Tiers: []TierReport{}}
+	tracker := newDeletionTracker(policy.DeleteConfirmAttempts, policy.DeleteConfirmInterval, policy.ConfirmedKeys)
+
+	// Rotation stops deleting keys once its timeout has elapsed, the remaining keys are deleted by the next rotation
+	ctx := context.Background()
+	if policy.Timeout > 0 {
+		var cancelFn context.CancelFunc
+		ctx, cancelFn = context.WithTimeout(ctx, policy.Timeout)
+		defer cancelFn()
+	}
+
+	tags, err := newTagFilter(svc, bucket, policy)
+	if err != nil {
+		log.Error.Printf("Skipping rotation as the tag filter cannot be applied: %v\n", err)
+		return deletedKeys, report
+	}
+
+	order, err := newKeyOrder(policy)
+	if err != nil {
+		log.Error.Printf("Skipping rotation as the keys cannot be ordered: %v\n", err)
+		return deletedKeys, report
+	}
 
 	log.Info.Println(`
 	######################################
@@ -29,9 +60,7 @@ func StartRotation(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, buc
 	`)
 
 	// Daily rotation
-	for _, key := range keyRotation(svc, bucket, policy.DailyRetentionPeriod, policy.DailyRetentionCount, policy.DailyPrefix, bucketDir, policy.EnforceRetentionPeriod, dryRun) {
-		deletedKeys = append(deletedKeys, key)
-	}
+	auditedKeys := keyRotation(ctx, svc, bucket, policy.DailyRetentionPeriod, policy.DailyRetentionCount, policy.MinKeep, policy.DailyPrefix, bucketDir, policy.EnforceRetentionPeriod, tags, order, tracker, &report, dryRun)
 
 	log.Info.Println(`
 	######################################
@@ -40,8 +69,36 @@ func StartRotation(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, buc
 	`)
 
 	// Weekly rotation
-	for _, key := range keyRotation(svc, bucket, policy.WeeklyRetentionPeriod, policy.WeeklyRetentionCount, policy.WeeklyPrefix, bucketDir, policy.EnforceRetentionPeriod, dryRun) {
-		deletedKeys = append(deletedKeys, key)
+	auditedKeys = append(auditedKeys, keyRotation(ctx, svc, bucket, policy.WeeklyRetentionPeriod, policy.WeeklyRetentionCount, policy.MinKeep, policy.WeeklyPrefix, bucketDir, policy.EnforceRetentionPeriod, tags, order, tracker, &report, dryRun)...)
+
+	// Monthly rotation, if the policy has a monthly retention count
+	if policy.MonthlyRetentionCount > 0 {
+		log.Info.Println(`
+	######################################
+	#   Starting Monthly Key Rotation!   #
+	######################################
+	`)
+
+		auditedKeys = append(auditedKeys, keyRotation(ctx, svc, bucket, policy.MonthlyRetentionPeriod, policy.MonthlyRetentionCount, policy.MinKeep, policy.MonthlyPrefix, bucketDir, policy.EnforceRetentionPeriod, tags, order, tracker, &report, dryRun)...)
+	}
+
+	// Yearly rotation, if the policy has a yearly tier and a yearly retention count
+	if policy.YearlyPrefix != "" && policy.YearlyRetentionCount > 0 {
+		log.Info.Println(`
+	######################################
+	#   Starting Yearly Key Rotation!    #
+	######################################
+	`)
+
+		auditedKeys = append(auditedKeys, keyRotation(ctx, svc, bucket, policy.YearlyRetentionPeriod, policy.YearlyRetentionCount, policy.MinKeep, policy.YearlyPrefix, bucketDir, policy.EnforceRetentionPeriod, tags, order, tracker, &report, dryRun)...)
+	}
+
+	for _, auditedKey := range auditedKeys {
+		deletedKeys = append(deletedKeys, auditedKey.Key)
+	}
+
+	if err = tags.save(); err != nil {
+		log.Error.Printf("Failed to cache the tags fetched by rotation: %v\n", err)
 	}
 
 	log.Info.Println(`
@@ -55,15 +112,47 @@ func StartRotation(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, buc
 		log.Info.Printf("Key deleted in rotation: '%s'\n", key)
 	}
 
+	if policy.WriteRotationAudit {
+		if dryRun {
+			log.Info.Printf("Skipping write of rotation audit object: '%s' as dry run has been enabled\n", policy.AuditKey)
+		} else if err := WriteRotationAudit(svc, bucket, policy.AuditKey, bucketDir, auditedKeys, time.Now(),
+			AuditOptions{Compress: policy.CompressAudit, MaxBytes: policy.AuditMaxBytes}); err != nil {
+			log.Error.Printf("Failed to write rotation audit object: '%s': %v\n", policy.AuditKey, err)
+		} else {
+			log.Info.Printf("Rotation audit written to key: '%s'\n", policy.AuditKey)
+		}
+	}
+
+	if policy.IndexKey != "" && len(deletedKeys) > 0 {
+		if dryRun {
+			log.Info.Printf("Skipping removal of %d keys from backup index: '%s' as dry run has been enabled\n", len(deletedKeys), policy.IndexKey)
+		} else if removed, err := s3client.RemoveFromBackupIndex(svc, bucket, policy.IndexKey, deletedKeys); err != nil {
+			log.Error.Printf("Failed to remove the deleted keys from backup index: '%s': %v\n", policy.IndexKey, err)
+		} else {
+			log.Info.Printf("Removed %d deleted keys from backup index: '%s'\n", removed, policy.IndexKey)
+		}
+	}
+
+	if dryRun {
+		logDryRunReport(report)
+	}
+
 	log.Info.Println("Finished GFS rotation")
 
-	return deletedKeys
+	return deletedKeys, report
 }
 
 // Any keys with prefix _monthly should have a life cycle policy to move into glacier after 30 days
 // If enforceRetentionPeriod is set to true then no keys that are
-func keyRotation(svc *s3.S3, bucket string, retentionPeriod time.Duration, retentionCount int, prefix string, bucketDir string, enforceRetentionPeriod bool, dryRun bool) []string {
-	sortedKeys, err := sortKeysAndLogInfo(svc, bucket, prefix, bucketDir) // Requirement that the keys are sorted before rotating
+// Keys already deleted by the tracker are excluded from the keys to rotate.
+// No further keys are deleted once the context has expired, e.g. the timeout of the rotation has elapsed.
+// Every key of the prefix is added to the report with whether it was deleted or kept and why.
+// Returns the deleted keys along with the reason each key was deleted
+func keyRotation(ctx context.Context, svc *s3.S3, bucket string, retentionPeriod time.Duration, retentionCount int, minKeep int, prefix string, bucketDir string, enforceRetentionPeriod bool, tags *tagFilter, order *keyOrder, tracker *deletionTracker, report *RotationReport, dryRun bool) []AuditDeletedKey {
+	tierReport := newTierReport(prefix, retentionCount, retentionPeriod, report.RunTime)
+	defer report.add(tierReport)
+
+	sortedKeys, err := sortKeysAndLogInfo(svc, bucket, prefix, bucketDir, tags, order) // Requirement that the keys are sorted before rotating
 
 	log.Info.Println(`
 	######################################
@@ -76,27 +165,73 @@ func keyRotation(svc *s3.S3, bucket string, retentionPeriod time.Duration, reten
 		return nil
 	}
 
-	if sortedKeys == nil {
+	sortedKeys = tracker.exclude(sortedKeys)
+
+	if len(sortedKeys) == 0 {
 		log.Info.Printf("No '%s' key(s) found for rotation\n", prefix)
 		return nil
 	}
 
-	deletedKeys := []string{}
+	if retentionCount < 0 {
+		log.Error.Printf("Skipping rotation for '%s' keys as the retention count of %d is negative\n", prefix, retentionCount)
+		for _, kv := range sortedKeys {
+			tierReport.keep(kv, fmt.Sprintf("the '%s' retention count of %d is negative", prefix, retentionCount))
+		}
+		return nil
+	}
+
+	deletedKeys := []AuditDeletedKey{}
 
 	numKeys := len(sortedKeys)
 	if numKeys > retentionCount {
-		log.Info.Printf("Total number of '%s' keys (%d) exceeds retention policy of %d, purging old keys\n",
-			prefix, numKeys, retentionCount)
+		for _, kv := range sortedKeys[:retentionCount] {
+			tierReport.keep(kv, fmt.Sprintf("within the newest %d '%s' keys of the retention count", retentionCount, prefix))
+		}
+
+		// The minimum keep is a safety floor against a retention count which would delete too many or all of the backups
+		keepCount := retainedCount(retentionCount, minKeep)
+		if keepCount > numKeys {
+			keepCount = numKeys
+		}
+		if keepCount > retentionCount {
+			log.Warn.Printf("Rotating '%s' keys with the retention count of %d would keep fewer than the minimum of %d keys. "+
+				"Keeping the newest %d '%s' key(s)\n", prefix, retentionCount, minKeep, keepCount, prefix)
+			for _, kv := range sortedKeys[retentionCount:keepCount] {
+				tierReport.keep(kv, fmt.Sprintf("within the newest %d '%s' keys of the minimum keep", minKeep, prefix))
+			}
+		}
+		if keepCount == numKeys {
+			log.Info.Printf("Skipping rotation for '%s' keys as the minimum keep of %d keeps all %d key(s) "+
+				"which exceed the retention policy of %d\n", prefix, minKeep, numKeys, retentionCount)
+			return nil
+		}
 
-		for _, kv := range sortedKeys[retentionCount:] {
+		log.Info.Printf("Total number of '%s' keys (%d) exceeds retention policy of %d, purging up to %d old key(s)\n",
+			prefix, numKeys, retentionCount, numKeys-keepCount)
+
+		timedOut := false
+		for _, kv := range sortedKeys[keepCount:] {
 			key := kv.Key
 
-			keyAge := time.Since(kv.ModifiedTime)
+			if ctx.Err() != nil {
+				if !timedOut {
+					log.Error.Printf("Rotation exceeded its timeout, skipping deletion of the remaining '%s' keys from key: '%s'. "+
+						"They will be deleted by the next rotation\n", prefix, key)
+					timedOut = true
+				}
+				tierReport.keep(kv, "rotation exceeded its timeout before the key was deleted")
+				continue
+			}
+
+			keyAge := report.RunTime.Sub(kv.ModifiedTime) // Measured from the run time so that it matches the age in the report
 			keyAgeHours := keyAge.Hours()
 			keyAgeMinutes := keyAge.Minutes()
 
 			log.Info.Printf("Candidate key for deletion: '%s' is %0.1f hours / %0.1f minutes old\n", key, keyAgeHours, keyAgeMinutes)
 
+			reason := fmt.Sprintf("exceeds the '%s' retention count of %d and is older than the retention period of %0.1f hours",
+				prefix, retentionCount, retentionPeriod.Hours())
+
 			// Safety check to ensure that candidate keys for deletion are not within the retentionPeriod
 			// This will prevent any key from being deleted if the retention period is enforced
 			// If the retention period is not enforced then the key will be removed and a warning logged
@@ -107,6 +242,8 @@ func keyRotation(svc *s3.S3, bucket string, retentionPeriod time.Duration, reten
 						"%0.1f hours / %0.1f minutes. This is less than the retention period of %0.1f hours / %0.1f minutes. "+
 						"This key is not eligible for deletion until the retention period has elapsed\n", key, keyAgeHours,
 						keyAgeMinutes, retentionPeriod.Hours(), retentionPeriod.Minutes())
+					tierReport.keep(kv, fmt.Sprintf("exceeds the '%s' retention count of %d but is within the enforced retention period of %0.1f hours",
+						prefix, retentionCount, retentionPeriod.Hours()))
 					continue // Skip to next candidate key for deletion
 				}
 
@@ -115,34 +252,80 @@ func keyRotation(svc *s3.S3, bucket string, retentionPeriod time.Duration, reten
 					"%0.1f hours / %0.1f minutes. This is less than the retention period of %0.1f hours / %0.1f minutes. "+
 					"This key WILL be deleted since enforce retention period is NOT enabled\n", key, keyAgeHours,
 					keyAgeMinutes, retentionPeriod.Hours(), retentionPeriod.Minutes())
+
+				reason = fmt.Sprintf("exceeds the '%s' retention count of %d. The retention period of %0.1f hours is not enforced",
+					prefix, retentionCount, retentionPeriod.Hours())
+			}
+			// Objects under a legal hold must be retained regardless of the rotation policy
+			held, err := s3client.GetLegalHold(svc, bucket, key)
+			if err != nil {
+				log.Error.Printf("Failed to retrieve legal hold status of key: '%s'. Skipping deletion: %v\n", key, err)
+				tierReport.keep(kv, fmt.Sprintf("failed to retrieve the legal hold status of the key: %v", err))
+				continue
+			}
+			if held {
+				log.Warn.Printf("Key: '%s' is in violation of retention policy count. However, the key has an active "+
+					"legal hold and is not eligible for deletion until the legal hold has been removed\n", key)
+				tierReport.keep(kv, "under a legal hold")
+				continue
 			}
+
 			if dryRun { // Do not delete any keys if dry run has been specified
 				log.Info.Printf("Skipping deletion of key: '%s' as dry run has been enabled\n", key)
-				deletedKeys = append(deletedKeys, key)
+				deletedKeys = append(deletedKeys, AuditDeletedKey{Key: key, LastModified: kv.ModifiedTime.UTC(), Reason: reason})
+				tierReport.delete(kv, reason)
 			} else {
-				deletedKey, err := s3client.DeleteKey(svc, bucket, key)
+				deleted, err := tracker.delete(svc, bucket, key)
 				if err != nil {
 					log.Error.Printf("Failed to delete key from bucket: '%s': %v\n", key, err)
-				} else {
+					tierReport.keep(kv, fmt.Sprintf("failed to delete the key: %v", err))
+				} else if deleted {
 					log.Info.Printf("Successfully deleted key from bucket: '%s'\n", key)
-					deletedKeys = append(deletedKeys, deletedKey)
+					deleteVolumes(svc, bucket, bucketDir, key)
+					deletedKeys = append(deletedKeys, AuditDeletedKey{Key: key, LastModified: kv.ModifiedTime.UTC(), Reason: reason})
+					tierReport.delete(kv, reason)
+				} else {
+					tierReport.keep(kv, "already deleted by this rotation or not one of the keys confirmed for deletion")
 				}
 			}
 
 		}
 
+		if !dryRun && len(deletedKeys) > 0 {
+			retained, err := countRetainedKeys(svc, bucket, prefix, bucketDir, tags, order, tracker)
+			if err != nil {
+				log.Error.Printf("Failed to recount '%s' keys after rotation: %v\n", prefix, err)
+			} else {
+				log.Info.Printf("Retained %d '%s' key(s) after rotation\n", retained, prefix)
+			}
+		}
+
 		return deletedKeys
 	}
 
 	log.Info.Printf("Skipping rotation for '%s' keys due to insufficient number of keys. "+
 		"Minimum of %d keys required for rotation. Found %d key(s)\n", prefix, retentionCount+1, numKeys)
+	for _, kv := range sortedKeys {
+		tierReport.keep(kv, fmt.Sprintf("within the newest %d '%s' keys of the retention count", retentionCount, prefix))
+	}
 	return nil
 
 }
 
-// Returns an array of sorted keys by LastModified date.
-// The first value in the array is the most recently modified key
-func sortKeysAndLogInfo(svc *s3.S3, bucket string, prefix string, bucketDir string) ([]s3client.BucketEntry, error) {
+// Returns the number of the newest keys of a tier which are kept, which is the retention count raised to the minimum
+// keep if the retention count is lower
+func retainedCount(retentionCount int, minKeep int) int {
+	if minKeep > retentionCount {
+		return minKeep
+	}
+	return retentionCount
+}
+
+// Returns an array of keys sorted by the timestamp at the end of each key, or by LastModified date for keys without one.
+// The first value in the array is the most recent key
+// If a tag filter is specified then only keys with every tag in the filter are returned.
+// Keys which do not end with a key timestamp are only returned if unparseable keys fall back to their last modified time
+func sortKeysAndLogInfo(svc *s3.S3, bucket string, prefix string, bucketDir string, tags *tagFilter, order *keyOrder) ([]s3client.BucketEntry, error) {
 	log.Info.Println(`
 	######################################
 	#        Retrieving Key Info!        #
@@ -150,12 +333,22 @@ func sortKeysAndLogInfo(svc *s3.S3, bucket string, prefix string, bucketDir stri
 	`)
 
 	log.Info.Printf("Attempting to retrieve list of keys with prefix: '%s'\n", prefix)
-	sortedKeys, err := util.RetrieveSortedKeysByTime(svc, bucket, prefix, bucketDir)
+	sortedKeys, err := order.sortedKeys(svc, bucket, prefix, bucketDir)
 	if err != nil {
 		log.Error.Printf("Failed to retrieve keys with prefix: '%s' from bucket: %s\n", prefix, bucket)
 		return nil, err
 	}
 
+	if tags != nil {
+		sortedKeys, err = tags.filter(sortedKeys)
+		if err != nil {
+			log.Error.Printf("Failed to filter keys with prefix: '%s' by tags: %v\n", prefix, err)
+			return nil, err
+		}
+	}
+
+	sortedKeys = order.filter(sortedKeys, prefix)
+
 	for _, kv := range sortedKeys {
 		log.Info.Printf("Found key: '%s'\n", kv.Key)
 	}
@@ -167,3 +360,33 @@ func sortKeysAndLogInfo(svc *s3.S3, bucket string, prefix string, bucketDir stri
 	}
 	return sortedKeys, nil
 }
+
+// Lists the keys of the prefix again once rotation has deleted keys and returns the number of keys retained. Keys
+// deleted by the tracker which are still listed are not counted
+func countRetainedKeys(svc *s3.S3, bucket string, prefix string, bucketDir string, tags *tagFilter, order *keyOrder, tracker *deletionTracker) (int, error) {
+	sortedKeys, err := sortKeysAndLogInfo(svc, bucket, prefix, bucketDir, tags, order)
+	if err != nil {
+		return 0, err
+	}
+	return len(tracker.exclude(sortedKeys)), nil
+}
+
+// Deletes the volumes of a deleted key which is the index of a split upload. The key has already been deleted, so a
+// failure is logged and the volumes are left to be removed manually
+func deleteVolumes(svc *s3.S3, bucket string, bucketDir string, key string) {
+	removed, err := s3client.DeleteVolumes(svc, bucket, bucketDir, key)
+	if err != nil {
+		log.Error.Printf("Failed to delete the volumes of key: '%s', they must be removed manually: %v\n", key, err)
+	} else if removed > 0 {
+		log.Info.Printf("Successfully deleted %d volumes of key: '%s'\n", removed, key)
+	}
+}
+
+func matchesTags(tags map[string]string, tagFilter map[string]string) bool {
+	for key, value := range tagFilter {
+		if tagValue, ok := tags[key]; !ok || tagValue != value {
+			return false
+		}
+	}
+	return true
+}
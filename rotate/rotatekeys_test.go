@@ -1,16 +1,22 @@
 package rotate
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"s3backup/log"
 	"s3backup/rpolicy"
 	"s3backup/s3client"
+	"s3backup/s3mock"
 	"s3backup/upload"
 	"s3backup/util"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -31,6 +37,9 @@ var weeklyRetentionPeriod time.Duration
 var testFileName string
 var pathToTestFile string
 
+var objectLockBucket string
+var mockBucket string
+
 // Setup testing
 func init() {
 	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
@@ -38,8 +47,10 @@ func init() {
 	awsCredentials := os.Getenv("AWS_CRED_FILE")
 	awsProfile := os.Getenv("AWS_PROFILE")
 	awsRegion := os.Getenv("AWS_REGION")
+	awsEndpoint := os.Getenv("AWS_ENDPOINT")
+	awsPartition := os.Getenv("AWS_PARTITION")
 	awsBucket := os.Getenv("AWS_BUCKET_ROTATION")
-	s3svc, err := s3client.CreateS3Client(awsCredentials, awsProfile, awsRegion)
+	s3svc, err := s3client.CreateS3Client(awsCredentials, awsProfile, false, "", "", awsRegion, awsEndpoint, awsPartition, nil, false)
 
 	if err != nil {
 		log.Error.Println(err)
@@ -48,6 +59,8 @@ func init() {
 	svc = s3svc
 
 	bucket = awsBucket
+	objectLockBucket = os.Getenv("AWS_BUCKET_OBJECT_LOCK")
+	mockBucket = "mockbucket"
 
 	dailyRetentionCount = 6
 	dailyRetentionPeriod = 140
@@ -69,6 +82,7 @@ func init() {
 		WeeklyPrefix:           "weekly_",
 		MonthlyPrefix:          "monthly_",
 		EnforceRetentionPeriod: false,
+		UnparseableKeys:        UnparseableKeysLastModified, // Most mock keys are not named with a key timestamp
 	}
 
 	err = util.CreateFile(pathToTestFile, []byte("this is just a little test file"))
@@ -261,7 +275,7 @@ func TestFullWeekUpload(t *testing.T) {
 	log.Info.Println(len(bucketContents.Contents))
 
 	if !util.CheckBucketSize(bucketContents, 7) {
-		t.Error("expected bucket contents to be 7 but got: " + string(len(bucketContents.Contents)))
+		t.Error("expected bucket contents to be 7 but got: " + strconv.Itoa(len(bucketContents.Contents)))
 	}
 
 	for _, dailyKey := range dailyKeys {
@@ -1126,59 +1140,1800 @@ func TestRotationWithOtherObjects(t *testing.T) {
 }
 
 //----------------------------------------------
+// Positive Testing
+//		Simulation Testing
+//			Simulate next rotation
 //
-//      Helper functions for testing below
+// An initial set of daily objects is created which exceeds the retention count.
+// The first simulated run should delete exactly the keys that a dry run rotation would delete
+//----------------------------------------------
+
+func TestSimulateMatchesDryRunRotation(t *testing.T) {
+	err := util.EmptyBucket(svc, bucket)
+	if err != nil {
+		t.Error("failed to empty bucket")
+	}
+
+	for i := 0; i < dailyRetentionCount+3; i++ {
+		_, err := justUploadIt(policy.DailyPrefix+testFileName+strconv.Itoa(i), "")
+		if err != nil {
+			t.Error("failed to upload daily key")
+		}
+		time.Sleep(time.Second) // Ensure keys have distinct modified times
+	}
+
+	simulatedRuns, err := SimulateRotation(svc, bucket, policy, "", testFileName, 3, time.Hour*24)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to simulate rotation without any error: %v", err))
+	}
+
+	if len(simulatedRuns) != 3 {
+		t.Fatal(fmt.Sprintf("expected 3 simulated runs, got %d", len(simulatedRuns)))
+	}
+
+	deletedKeys := StartRotation(svc, bucket, policy, "", true)
+
+	if len(simulatedRuns[0].DeletedKeys) != len(deletedKeys) {
+		t.Error(fmt.Sprintf("expected simulation to delete %d keys, got %d", len(deletedKeys), len(simulatedRuns[0].DeletedKeys)))
+	}
+
+	for _, deletedKey := range deletedKeys {
+		found := false
+		for _, simulatedKey := range simulatedRuns[0].DeletedKeys {
+			if simulatedKey == deletedKey {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("expected simulation to delete key: " + deletedKey)
+		}
+	}
+
+	if len(simulatedRuns[0].RemainingKeys[policy.DailyPrefix]) != dailyRetentionCount {
+		t.Error(fmt.Sprintf("expected %d daily keys to remain after simulated run", dailyRetentionCount))
+	}
+
+	// Simulation must be read-only
+	bucketContents, err := s3client.GetBucketContents(svc, bucket)
+	if err != nil {
+		t.Error("failed to retrieve bucket contents")
+	}
+
+	if !util.CheckBucketSize(bucketContents, dailyRetentionCount+3) {
+		t.Error(fmt.Sprintf("expected bucket size to be %d", dailyRetentionCount+3))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Legal Hold Testing
+//			Held keys survive rotation
 //
+// Requires a bucket with object lock enabled which is specified with AWS_BUCKET_OBJECT_LOCK.
+// The oldest key is uploaded with a legal hold and should not be deleted until the hold has been cleared
 //----------------------------------------------
 
-// Uploads the 'test_backup_file' file in the repository and initiates a GFS rotation
-// If delay > 0 then the thread will sleep for the delay specified (seconds) before rotating the files
-func runMockBackup(t *testing.T, uploadDate time.Time, delay int, providedPolicy rpolicy.RotationPolicy, dryRun bool) (string, []string) {
+func TestLegalHeldKeysSurviveRotation(t *testing.T) {
+	if objectLockBucket == "" {
+		t.Skip("AWS_BUCKET_OBJECT_LOCK not specified")
+	}
 
-	testUploadObject := upload.UploadObject{
-		PathToFile: pathToTestFile,
-		S3FileName: testFileName + "_" + uploadDate.Format("02-Jan-06"),
-		BucketDir:  "",
-		Bucket:     bucket,
-		Timeout:    timeout,
-		NumWorkers: 5,
-		PartSize:   50,
-		Manipulate: true,
+	err := util.EmptyBucket(svc, objectLockBucket)
+	if err != nil {
+		t.Error("failed to empty bucket")
 	}
 
-	prefix := util.GetKeyType(providedPolicy, uploadDate)
-	s3FileName, err := upload.UploadFile(svc, testUploadObject, prefix, dryRun)
+	heldKey := ""
+	for i := 0; i <= dailyRetentionCount; i++ {
+		testUploadObject := upload.UploadObject{
+			PathToFile: pathToTestFile,
+			S3FileName: policy.DailyPrefix + testFileName + strconv.Itoa(i),
+			Bucket:     objectLockBucket,
+			Timeout:    timeout,
+			NumWorkers: 5,
+			PartSize:   50,
+		}
+		if i == 0 {
+			testUploadObject.ObjectLockLegalHoldStatus = s3.ObjectLockLegalHoldStatusOn
+		}
+
+		key, err := upload.UploadFile(svc, testUploadObject, "", false)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to upload file: %v", err))
+		}
+		if i == 0 {
+			heldKey = key
+		}
+		time.Sleep(time.Second) // Ensure keys have distinct modified times
+	}
+
+	held, err := s3client.GetLegalHold(svc, objectLockBucket, heldKey)
+	if err != nil || !held {
+		t.Error(fmt.Sprintf("expected key '%s' to have an active legal hold: %v", heldKey, err))
+	}
+
+	deletedKeys := StartRotation(svc, objectLockBucket, policy, "", false)
+	if len(deletedKeys) != 0 {
+		t.Error(fmt.Sprintf("expected no keys to be deleted while held, got %d", len(deletedKeys)))
+	}
+
+	bucketContents, err := s3client.GetBucketContents(svc, objectLockBucket)
 	if err != nil {
-		t.Fatal(fmt.Sprintf("failed to upload file: %v", err))
+		t.Error("failed to retrieve bucket contents")
 	}
 
-	// If no minimum delay is specified then it's more likely a file will be uploaded
-	// out of order and break the test
-	if delay == 0 {
-		delay = 1
+	if !util.FindKeyInBucket(heldKey, bucketContents) {
+		t.Error("expected held key to survive rotation: " + heldKey)
 	}
 
-	time.Sleep(time.Second * time.Duration(delay))
+	err = s3client.SetLegalHold(svc, objectLockBucket, heldKey, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to clear legal hold: %v", err))
+	}
 
-	return s3FileName, StartRotation(svc, bucket, providedPolicy, "", dryRun)
+	held, err = s3client.GetLegalHold(svc, objectLockBucket, heldKey)
+	if err != nil || held {
+		t.Error(fmt.Sprintf("expected key '%s' to have no legal hold: %v", heldKey, err))
+	}
+
+	deletedKeys = StartRotation(svc, objectLockBucket, policy, "", false)
+	if len(deletedKeys) != 1 || deletedKeys[0] != heldKey {
+		t.Error(fmt.Sprintf("expected previously held key '%s' to be deleted, got: %v", heldKey, deletedKeys))
+	}
 }
 
-func justUploadIt(s3FileName string, s3BucketDir string) (string, error) {
-	testUploadObject := upload.UploadObject{
-		PathToFile: pathToTestFile,
-		S3FileName: s3FileName,
-		BucketDir:  s3BucketDir,
-		Bucket:     bucket,
-		Timeout:    timeout,
-		NumWorkers: 5,
-		PartSize:   50,
-		Manipulate: false,
+//----------------------------------------------
+// Positive Testing
+//		Durability Testing (mock S3)
+//			Rotation waits for the uploaded key to be confirmed present
+//
+// HeadObject reports the key as missing for the first few polls. Rotation must not list the bucket until
+// the key has been confirmed
+//----------------------------------------------
+
+func TestRotationWaitsForUploadedKey(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+
+	uploadedKey := "daily_" + testFileName + "_" + time.Now().Format("20060102T150405")
+	server.PutObject(mockBucket, uploadedKey, []byte("backup"), time.Now())
+
+	headCount := 0
+	server.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "HeadObject" {
+			headCount++
+			if headCount <= 3 {
+				return &s3mock.Error{StatusCode: 404, Code: "NotFound", Message: "mock not yet visible"}
+			}
+		}
+		return nil
+	})
+
+	durability := DurabilityPolicy{PollInterval: time.Millisecond * 10, Timeout: time.Second * 5}
+	_, err := StartRotationWhenDurable(server.Client(), mockBucket, policy, "", uploadedKey, durability, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected rotation to start once the key was confirmed: %v", err))
 	}
 
-	prefix := util.GetKeyType(policy, time.Now())
-	backupKey, err := upload.UploadFile(svc, testUploadObject, prefix, false)
+	if headCount != 4 {
+		t.Error(fmt.Sprintf("expected the key to be polled 4 times but was polled %d times", headCount))
+	}
+
+	lastHead, firstList := -1, -1
+	for i, req := range server.Requests("") {
+		if req.Operation == "HeadObject" {
+			lastHead = i
+		}
+		if (req.Operation == "ListObjects" || req.Operation == "ListObjectsV2") && firstList == -1 {
+			firstList = i
+		}
+	}
+
+	if firstList == -1 || firstList < lastHead {
+		t.Error(fmt.Sprintf("expected rotation to list the bucket after the key was confirmed. Last HeadObject: %d, First list: %d", lastHead, firstList))
+	}
+}
+
+//----------------------------------------------
+// Negative Testing
+//		Durability Testing (mock S3)
+//			Rotation is aborted if the uploaded key is never confirmed
+//----------------------------------------------
+
+func TestRotationAbortedWhenKeyNotConfirmed(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+
+	uploadedKey := "daily_" + testFileName + "_" + time.Now().Format("20060102T150405")
+	server.PutObject(mockBucket, "daily_"+testFileName+"_20000101T000000", []byte("old backup"), time.Now().AddDate(-1, 0, 0))
+
+	durability := DurabilityPolicy{PollInterval: time.Millisecond * 10, Timeout: time.Millisecond * 100}
+	deletedKeys, err := StartRotationWhenDurable(server.Client(), mockBucket, policy, "", uploadedKey, durability, false)
+
+	expectedErrString := "timed out waiting for key"
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if len(deletedKeys) != 0 || len(server.Requests("ListObjects"))+len(server.Requests("ListObjectsV2")) != 0 {
+		t.Error("expected no rotation to be performed when the key was not confirmed")
+	}
+
+	if len(server.Keys(mockBucket)) != 1 {
+		t.Error("expected the existing key to survive when the uploaded key was not confirmed")
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Durability Testing (mock S3)
+//			Rotation waits for the uploaded key to be replicated
+//----------------------------------------------
+
+func TestRotationWaitsForReplication(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+
+	uploadedKey := "daily_" + testFileName + "_" + time.Now().Format("20060102T150405")
+	server.PutObject(mockBucket, uploadedKey, []byte("backup"), time.Now())
+	server.SetObjectHeader(mockBucket, uploadedKey, "X-Amz-Replication-Status", s3.ReplicationStatusPending)
+
+	headCount := 0
+	server.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "HeadObject" {
+			headCount++
+			if headCount == 3 {
+				server.SetObjectHeader(mockBucket, uploadedKey, "X-Amz-Replication-Status", s3.ReplicationStatusCompleted)
+			}
+		}
+		return nil
+	})
+
+	durability := DurabilityPolicy{PollInterval: time.Millisecond * 10, Timeout: time.Second * 5, RequireReplication: true}
+	_, err := StartRotationWhenDurable(server.Client(), mockBucket, policy, "", uploadedKey, durability, false)
 	if err != nil {
-		return "", err
+		t.Fatal(fmt.Sprintf("expected rotation to start once the key was replicated: %v", err))
+	}
+
+	if headCount != 3 {
+		t.Error(fmt.Sprintf("expected the key to be polled 3 times but was polled %d times", headCount))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Rotation Audit Testing (mock S3)
+//			The audit object records the deleted keys and reasons after each rotation
+//
+// Eight daily keys are seeded with a retention count of six. The two oldest keys should be recorded in the audit
+// object and a second rotation should append a run which is chained to the first
+//----------------------------------------------
+
+func TestRotationAuditWritten(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("daily_%s_%d", testFileName, i)
+		server.PutObject(mockBucket, key, []byte("backup"), now.Add(-time.Hour*time.Duration(24*i)))
+	}
+
+	auditPolicy := policy
+	auditPolicy.WriteRotationAudit = true
+	auditPolicy.AuditKey = "audit/rotation_audit.json"
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, auditPolicy, "", false)
+	if len(deletedKeys) != 2 {
+		t.Fatal(fmt.Sprintf("expected 2 keys to be deleted but %d were deleted", len(deletedKeys)))
+	}
+
+	audit, err := GetRotationAudit(mockSvc, mockBucket, auditPolicy.AuditKey)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to retrieve the rotation audit without any error: %v", err))
+	}
+
+	if len(audit.Runs) != 1 {
+		t.Fatal(fmt.Sprintf("expected the audit to contain 1 run but found %d", len(audit.Runs)))
+	}
+
+	auditedKeys := audit.Runs[0].DeletedKeys
+	if len(auditedKeys) != 2 || auditedKeys[0].Key != "daily_"+testFileName+"_6" || auditedKeys[1].Key != "daily_"+testFileName+"_7" {
+		t.Error(fmt.Sprintf("expected the audit to record the two oldest keys: %v", auditedKeys))
+	}
+
+	for _, auditedKey := range auditedKeys {
+		if !strings.Contains(auditedKey.Reason, "exceeds the 'daily_' retention count of 6") {
+			t.Error("expected the audit to record why the key was deleted: " + auditedKey.Reason)
+		}
+		if auditedKey.LastModified.IsZero() {
+			t.Error("expected the audit to record the last modified time of key: " + auditedKey.Key)
+		}
+	}
+
+	StartRotation(mockSvc, mockBucket, auditPolicy, "", false)
+
+	audit, err = GetRotationAudit(mockSvc, mockBucket, auditPolicy.AuditKey)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to retrieve the rotation audit without any error: %v", err))
+	}
+
+	if len(audit.Runs) != 2 || len(audit.Runs[1].DeletedKeys) != 0 || audit.Runs[1].PreviousHash != audit.Runs[0].Hash {
+		t.Error("expected the second rotation to append a run chained to the first")
+	}
+
+	if err = audit.Verify(); err != nil {
+		t.Error(fmt.Sprintf("expected the rotation audit to verify without any error: %v", err))
+	}
+}
+
+//----------------------------------------------
+// Negative Testing
+//		Rotation Audit Testing (mock S3)
+//			Modifying the audit history is detected and dry runs do not write the audit
+//----------------------------------------------
+
+func TestRotationAuditTampered(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	for i := 0; i < 7; i++ {
+		server.PutObject(mockBucket, fmt.Sprintf("daily_%s_%d", testFileName, i), []byte("backup"), now.Add(-time.Hour*time.Duration(24*i)))
+	}
+
+	auditPolicy := policy
+	auditPolicy.WriteRotationAudit = true
+	auditPolicy.AuditKey = "rotation_audit.json"
+
+	StartRotation(mockSvc, mockBucket, auditPolicy, "", true)
+	if server.Object(mockBucket, auditPolicy.AuditKey) != nil {
+		t.Fatal("expected no rotation audit to be written for a dry run")
+	}
+
+	StartRotation(mockSvc, mockBucket, auditPolicy, "", false)
+
+	audit := server.Object(mockBucket, auditPolicy.AuditKey)
+	if audit == nil {
+		t.Fatal("expected the rotation audit to be written")
+	}
+
+	tampered := strings.Replace(string(audit.Body), "daily_"+testFileName+"_6", "daily_"+testFileName+"_5", 1)
+	server.PutObject(mockBucket, auditPolicy.AuditKey, []byte(tampered), now)
+
+	tamperedAudit, err := GetRotationAudit(mockSvc, mockBucket, auditPolicy.AuditKey)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to retrieve the rotation audit without any error: %v", err))
+	}
+
+	expectedErrString := "hash of run 1 does not match its contents"
+	err = tamperedAudit.Verify()
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Rotation Audit Testing (mock S3)
+//			A compressed audit is appended to and rolled over once it exceeds the max size
+//
+// Rotations are recorded in a gzip compressed history. Each rotation should append a line to the history until it
+// would exceed the max size, at which point the history is moved to a key with the time of its last run and a new
+// history is started which is chained to it
+//----------------------------------------------
+
+func TestRotationAuditCompressed(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	for i := 0; i < 8; i++ {
+		server.PutObject(mockBucket, fmt.Sprintf("daily_%s_%d", testFileName, i), []byte("backup"), now.Add(-time.Hour*time.Duration(24*i)))
+	}
+
+	auditPolicy := policy
+	auditPolicy.WriteRotationAudit = true
+	auditPolicy.AuditKey = "audit/rotation_audit.ndjson.gz"
+	auditPolicy.CompressAudit = true
+
+	StartRotation(mockSvc, mockBucket, auditPolicy, "", false)
+	StartRotation(mockSvc, mockBucket, auditPolicy, "", false)
+
+	obj := server.Object(mockBucket, auditPolicy.AuditKey)
+	if obj == nil || len(obj.Body) < 2 || obj.Body[0] != 0x1f || obj.Body[1] != 0x8b {
+		t.Fatal("expected the rotation audit to be written gzip compressed")
+	}
+	if obj.Header.Get("Content-Type") != "application/gzip" {
+		t.Error("expected the compressed rotation audit to have the gzip content type: " + obj.Header.Get("Content-Type"))
+	}
+
+	audit, err := GetRotationAudit(mockSvc, mockBucket, auditPolicy.AuditKey)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to retrieve the compressed rotation audit without any error: %v", err))
+	}
+	if len(audit.Runs) != 2 || len(audit.Runs[0].DeletedKeys) != 2 || audit.Runs[1].PreviousHash != audit.Runs[0].Hash {
+		t.Error(fmt.Sprintf("expected the second rotation to be appended to the compressed history: %+v", audit.Runs))
+	}
+	if err = audit.Verify(); err != nil {
+		t.Error(fmt.Sprintf("expected the compressed rotation audit to verify without any error: %v", err))
+	}
+}
+
+func TestRotationAuditRollover(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	auditKey := "audit/rotation_audit.ndjson.gz"
+	options := AuditOptions{Compress: true, MaxBytes: 600}
+	runTime := time.Date(2024, time.January, 1, 2, 0, 0, 0, time.UTC)
+
+	// Each run records a deleted key so that the history grows by roughly the same size with every run
+	for i := 0; i < 12; i++ {
+		deletedKeys := []AuditDeletedKey{{Key: fmt.Sprintf("daily_%s_%d", testFileName, i), LastModified: runTime, Reason: "exceeds the retention count"}}
+		if err := WriteRotationAudit(mockSvc, mockBucket, auditKey, "", deletedKeys, runTime.Add(time.Hour*time.Duration(24*i)), options); err != nil {
+			t.Fatal(fmt.Sprintf("expected to write the rotation audit without any error: %v", err))
+		}
+		if size := len(server.Object(mockBucket, auditKey).Body); int64(size) > options.MaxBytes {
+			t.Fatal(fmt.Sprintf("expected the rotation audit to be rolled over before it exceeds %d bytes but it is %d bytes", options.MaxBytes, size))
+		}
+	}
+
+	rolledKeys := []string{}
+	for _, key := range server.Keys(mockBucket) {
+		if key != auditKey {
+			rolledKeys = append(rolledKeys, key)
+		}
+	}
+	if len(rolledKeys) == 0 {
+		t.Fatal("expected the rotation audit to be rolled over")
+	}
+
+	// Every run is recorded once across the histories, which are chained from the current history back to the first
+	runs := 0
+	key := auditKey
+	for key != "" {
+		audit, err := GetRotationAudit(mockSvc, mockBucket, key)
+		if err != nil || len(audit.Runs) == 0 {
+			t.Fatal(fmt.Sprintf("expected the history '%s' to contain the rotations: %v", key, err))
+		}
+		if err = audit.Verify(); err != nil {
+			t.Error(fmt.Sprintf("expected the history '%s' to verify without any error: %v", key, err))
+		}
+
+		previousKey := audit.Runs[0].PreviousKey
+		if previousKey != "" {
+			firstRun := audit.Runs[0]
+			previous, _ := GetRotationAudit(mockSvc, mockBucket, previousKey)
+			if len(previous.Runs) == 0 || previous.Runs[len(previous.Runs)-1].Hash != firstRun.PreviousHash {
+				t.Error(fmt.Sprintf("expected the history '%s' to be chained to the last run of '%s'", key, previousKey))
+			}
+			if !strings.HasPrefix(previousKey, "audit/rotation_audit_") || !strings.HasSuffix(previousKey, ".ndjson.gz") {
+				t.Error("expected the rolled over history to be keyed by the time of its last run: " + previousKey)
+			}
+		}
+
+		runs += len(audit.Runs)
+		key = previousKey
+	}
+
+	if runs != 12 {
+		t.Error(fmt.Sprintf("expected the 12 rotations to be recorded across the histories but found %d", runs))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Tag Filter Testing (mock S3)
+//			Only objects with the matching tag are rotated
+//
+// Eight daily keys tagged app=myservice are interleaved with eight older daily keys which are untagged or belong to
+// another application. Only the two oldest tagged keys should be deleted, the other keys should not be touched and
+// should not count towards the retention count
+//----------------------------------------------
+
+func TestRotationTagFilter(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	for i := 0; i < 8; i++ {
+		tagged := server.PutObject(mockBucket, fmt.Sprintf("daily_mine_%d", i), []byte("backup"), now.Add(-time.Hour*time.Duration(24*i)))
+		tagged.Tags["app"] = "myservice"
+
+		other := server.PutObject(mockBucket, fmt.Sprintf("daily_other_%d", i), []byte("backup"), now.Add(-time.Hour*time.Duration(24*i+240)))
+		if i%2 == 0 {
+			other.Tags["app"] = "otherservice"
+		}
+	}
+
+	filterPolicy := policy
+	filterPolicy.TagFilter = map[string]string{"app": "myservice"}
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, filterPolicy, "", false)
+
+	expected := "[daily_mine_6 daily_mine_7]"
+	if fmt.Sprint(deletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected only the oldest tagged keys %s to be deleted but got %v", expected, deletedKeys))
+	}
+
+	for i := 0; i < 8; i++ {
+		if server.Object(mockBucket, fmt.Sprintf("daily_other_%d", i)) == nil {
+			t.Error(fmt.Sprintf("expected key 'daily_other_%d' without the tag to be ignored", i))
+		}
+	}
+
+	if len(server.Requests("DeleteObject")) != 2 {
+		t.Error(fmt.Sprintf("expected 2 keys to be deleted but %d delete requests were made", len(server.Requests("DeleteObject"))))
+	}
+
+	simulatedRuns, err := SimulateRotation(mockSvc, mockBucket, filterPolicy, "", testFileName, 1, 0)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to simulate rotation without any error: %v", err))
+	}
+
+	if len(simulatedRuns[0].RemainingKeys[filterPolicy.DailyPrefix]) != 6 {
+		t.Error("expected the simulated rotation to only consider the tagged keys")
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Retention Count Boundary Testing (mock S3)
+//			1: Nothing is deleted when the retention count exceeds the number of keys
+//			2: Nothing is deleted when the retention count equals the number of keys
+//			3: Only the keys beyond the retention count are deleted when it is less than the number of keys
+//			4: Only the keys beyond the retention count which are older than the enforced retention period are deleted
+//			5: Nothing is deleted when the retention count is negative
+//
+// Five daily keys are uploaded one day apart. Simulated rotation must agree with every result
+//----------------------------------------------
+
+// Test 1 - Retention Count Boundary Testing
+//	Nothing is deleted when the retention count exceeds the number of keys
+func TestRetentionCountExceedsKeys(t *testing.T) {
+	boundaryPolicy := policy
+	boundaryPolicy.DailyRetentionCount = 10
+
+	assertBoundaryRotation(t, boundaryPolicy, "[]")
+}
+
+// Test 2 - Retention Count Boundary Testing
+//	Nothing is deleted when the retention count equals the number of keys
+func TestRetentionCountEqualsKeys(t *testing.T) {
+	boundaryPolicy := policy
+	boundaryPolicy.DailyRetentionCount = 5
+
+	assertBoundaryRotation(t, boundaryPolicy, "[]")
+}
+
+// Test 3 - Retention Count Boundary Testing
+//	Only the keys beyond the retention count are deleted when it is less than the number of keys
+func TestRetentionCountLessThanKeys(t *testing.T) {
+	boundaryPolicy := policy
+	boundaryPolicy.DailyRetentionCount = 3
+
+	assertBoundaryRotation(t, boundaryPolicy, "[daily_boundary_3 daily_boundary_4]")
+}
+
+// Test 4 - Retention Count Boundary Testing
+//	Only the keys beyond the retention count which are older than the enforced retention period are deleted
+func TestRetentionCountEnforcedRetentionPeriod(t *testing.T) {
+	boundaryPolicy := policy
+	boundaryPolicy.DailyRetentionCount = 1
+	boundaryPolicy.DailyRetentionPeriod = time.Hour * 60
+	boundaryPolicy.EnforceRetentionPeriod = true
+
+	assertBoundaryRotation(t, boundaryPolicy, "[daily_boundary_3 daily_boundary_4]")
+}
+
+// Test 5 - Retention Count Boundary Testing
+//	Nothing is deleted when the retention count is negative
+func TestRetentionCountNegative(t *testing.T) {
+	boundaryPolicy := policy
+	boundaryPolicy.DailyRetentionCount = -1
+
+	assertBoundaryRotation(t, boundaryPolicy, "[]")
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Min Expected Objects Testing (mock S3)
+//			Rotation proceeds when the bucket holds at least the expected number of backups
+//
+// Two daily keys, a weekly key and a key outside of the rotation tiers are stored. The three backups in the tiers
+// meet a minimum of three, and the check is skipped when no objects are expected
+//----------------------------------------------
+
+func TestMinExpectedObjectsMet(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	server.PutObject(mockBucket, "daily_expected_0", []byte("backup"), now)
+	server.PutObject(mockBucket, "daily_expected_1", []byte("backup"), now.Add(-time.Hour*24))
+	server.PutObject(mockBucket, "weekly_expected_0", []byte("backup"), now.Add(-time.Hour*24*7))
+	server.PutObject(mockBucket, "unrelated_object", []byte("backup"), now)
+
+	for _, minExpected := range []int{0, 3} {
+		expectedPolicy := policy
+		expectedPolicy.MinExpectedObjects = minExpected
+
+		if err := CheckMinExpectedObjects(mockSvc, mockBucket, expectedPolicy, ""); err != nil {
+			t.Error(fmt.Sprintf("expected a minimum of %d backups to be met: %v", minExpected, err))
+		}
+	}
+}
+
+//----------------------------------------------
+// Negative Testing
+//		Min Expected Objects Testing (mock S3)
+//			Rotation is refused when the bucket holds fewer than the expected number of backups
+//
+// Only objects outside of the bucket dir or the rotation tiers are stored alongside two backups, so a minimum of
+// three must trip the guard
+//----------------------------------------------
+
+func TestMinExpectedObjectsNotMet(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	server.PutObject(mockBucket, "backups/daily_expected_0", []byte("backup"), now)
+	server.PutObject(mockBucket, "backups/monthly_expected_0", []byte("backup"), now.Add(-time.Hour*24*30))
+	server.PutObject(mockBucket, "backups/unrelated_object", []byte("backup"), now)
+	server.PutObject(mockBucket, "daily_elsewhere_0", []byte("backup"), now)
+
+	expectedPolicy := policy
+	expectedPolicy.MinExpectedObjects = 3
+
+	expectedErrString := "found 2 backups under 'backups/'"
+	err := CheckMinExpectedObjects(mockSvc, mockBucket, expectedPolicy, "backups/")
+	if err == nil || !strings.Contains(err.Error(), expectedErrString) {
+		t.Error(fmt.Sprintf("expected the guard to trip with '%s' but got: %v", expectedErrString, err))
+	}
+
+	if len(server.Requests("DeleteObject")) != 0 {
+		t.Error("expected no key to be deleted by the check")
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Unparseable Keys Testing (mock S3)
+//			Keys without a key timestamp are ignored by default and never deleted
+//
+// Four daily backups named with a key timestamp are interleaved with four older malformed keys, e.g. an object
+// uploaded manually or a timestamp with an invalid month. Only the malformed keys would be deleted if they were
+// rotated, so the ignored keys must all survive and only the oldest backup beyond the retention count is deleted
+//----------------------------------------------
+
+func TestRotationIgnoresUnparseableKeys(t *testing.T) {
+	server, mockSvc := unparseableKeysTestServer()
+	defer server.Close()
+
+	ignorePolicy := policy
+	ignorePolicy.DailyRetentionCount = 3
+	ignorePolicy.UnparseableKeys = ""
+
+	simulatedRuns, err := SimulateRotation(mockSvc, mockBucket, ignorePolicy, "", testFileName, 1, 0)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to simulate rotation without any error: %v", err))
+	}
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, ignorePolicy, "", false)
+
+	expected := "[daily_portfolio_20240128T020000]"
+	if fmt.Sprint(deletedKeys) != expected || fmt.Sprint(simulatedRuns[0].DeletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected only %s to be deleted but got %v and simulated %v", expected, deletedKeys, simulatedRuns[0].DeletedKeys))
+	}
+
+	for _, key := range unparseableKeys {
+		if server.Object(mockBucket, key) == nil {
+			t.Error(fmt.Sprintf("expected malformed key '%s' not to be deleted", key))
+		}
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Unparseable Keys Testing (mock S3)
+//			Keys without a key timestamp are rotated by their last modified time
+//
+// The same keys as above are rotated with the malformed keys falling back to their last modified time. As they are
+// the oldest keys every malformed key is deleted along with the oldest backup
+//----------------------------------------------
+
+func TestRotationUnparseableKeysLastModified(t *testing.T) {
+	server, mockSvc := unparseableKeysTestServer()
+	defer server.Close()
+
+	lastModifiedPolicy := policy
+	lastModifiedPolicy.DailyRetentionCount = 3
+	lastModifiedPolicy.UnparseableKeys = UnparseableKeysLastModified
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, lastModifiedPolicy, "", false)
+
+	expected := fmt.Sprint(append([]string{"daily_portfolio_20240128T020000"}, unparseableKeys...))
+	if fmt.Sprint(deletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected %s to be deleted but got %v", expected, deletedKeys))
+	}
+}
+
+// Malformed keys of the daily tier, ordered newest first
+var unparseableKeys = []string{
+	"daily_portfolio_manual",
+	"daily_portfolio_20241399T020000",
+	"daily_portfolio_20240101T0200",
+	"daily_portfolio_20240101T020000/nested",
+}
+
+// Returns a server with four daily backups a day apart followed by the older malformed keys
+func unparseableKeysTestServer() (*s3mock.Server, *s3.S3) {
+	server := s3mock.New(mockBucket)
+
+	now := time.Now()
+	for i, key := range []string{"daily_portfolio_20240131T020000", "daily_portfolio_20240130T020000.zst",
+		"daily_portfolio_20240129T020000", "daily_portfolio_20240128T020000"} {
+		server.PutObject(mockBucket, key, []byte("backup"), now.Add(-time.Hour*time.Duration(24*i+1)))
+	}
+	for i, key := range unparseableKeys {
+		server.PutObject(mockBucket, key, []byte("backup"), now.Add(-time.Hour*time.Duration(24*(i+4)+1)))
+	}
+	return server, server.Client()
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Key Time Testing (mock S3)
+//			Keys are rotated in the order of the timestamp in each key
+//
+// Four daily backups named with a custom key time layout were copied into the bucket in the reverse of the order they
+// were made, so the oldest backup was last modified most recently. Rotation must delete the backups made first
+// rather than the backups modified first
+//----------------------------------------------
+
+func TestRotationOrdersKeysByKeyTime(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	for i, key := range []string{"daily_portfolio_2024-01-28_0200", "daily_portfolio_2024-01-29_0200.zst",
+		"daily_portfolio_2024-01-30_0200", "daily_portfolio_2024-01-31_0200"} {
+		server.PutObject(mockBucket, key, []byte("backup"), now.Add(-time.Hour*time.Duration(i+1)))
+	}
+
+	keyTimePolicy := policy
+	keyTimePolicy.DailyRetentionCount = 2
+	keyTimePolicy.UnparseableKeys = ""
+	keyTimePolicy.KeyTimeLayout = "2006-01-02_1504"
+
+	simulatedRuns, err := SimulateRotation(mockSvc, mockBucket, keyTimePolicy, "", testFileName, 1, 0)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to simulate rotation without any error: %v", err))
+	}
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, keyTimePolicy, "", false)
+
+	expected := "[daily_portfolio_2024-01-29_0200.zst daily_portfolio_2024-01-28_0200]"
+	if fmt.Sprint(deletedKeys) != expected || fmt.Sprint(simulatedRuns[0].DeletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected the oldest backups by key time %s to be deleted but got %v and simulated %v", expected, deletedKeys, simulatedRuns[0].DeletedKeys))
+	}
+
+	for _, key := range []string{"daily_portfolio_2024-01-30_0200", "daily_portfolio_2024-01-31_0200"} {
+		if server.Object(mockBucket, key) == nil {
+			t.Error(fmt.Sprintf("expected the newest backup by key time '%s' not to be deleted", key))
+		}
+	}
+}
+
+//----------------------------------------------
+// Negative Testing
+//		Key Time Testing (mock S3)
+//			Rotation is skipped if the key time layout is invalid
+//----------------------------------------------
+
+func TestRotationInvalidKeyTimeLayout(t *testing.T) {
+	server, mockSvc := unparseableKeysTestServer()
+	defer server.Close()
+
+	invalidPolicy := policy
+	invalidPolicy.DailyRetentionCount = 0
+	invalidPolicy.KeyTimeLayout = "Jan 2 2006"
+
+	if deletedKeys := StartRotation(mockSvc, mockBucket, invalidPolicy, "", false); len(deletedKeys) != 0 {
+		t.Error(fmt.Sprintf("expected rotation to be skipped for an invalid key time layout but deleted %v", deletedKeys))
+	}
+
+	if _, err := SimulateRotation(mockSvc, mockBucket, invalidPolicy, "", testFileName, 1, 0); err == nil {
+		t.Error("expected an error when simulating rotation with an invalid key time layout")
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Delete Consistency Testing (mock S3)
+//			A deleted key which is still listed is not deleted again or counted as retained
+//
+// Eight daily keys are rotated with a retention count of six on a provider which lists each deleted key once more.
+// The deletion is not confirmed, so the recount after the deletions still lists both deleted keys and must exclude them
+//----------------------------------------------
+
+func TestRotationDeletedKeyListedAgain(t *testing.T) {
+	server, mockSvc := deleteConsistencyTestServer()
+	defer server.Close()
+	server.SetDeleteLag(1)
+
+	info := captureInfoLog(t)
+
+	consistencyPolicy := policy
+	consistencyPolicy.DeleteConfirmAttempts = 0
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, consistencyPolicy, "", false)
+
+	expected := "[daily_consistency_6 daily_consistency_7]"
+	if fmt.Sprint(deletedKeys) != expected || len(server.Requests("DeleteObject")) != 2 {
+		t.Error(fmt.Sprintf("expected %s to be deleted once each but got %v with %d delete requests", expected, deletedKeys, len(server.Requests("DeleteObject"))))
+	}
+	if len(server.Requests("HeadObject")) != 0 {
+		t.Error("expected the deletions not to be confirmed when the check is disabled")
+	}
+	if !strings.Contains(info.String(), "Retained 6 'daily_' key(s) after rotation") {
+		t.Error("expected the keys which are still listed not to be counted as retained")
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Delete Consistency Testing (mock S3)
+//			Each deleted key is checked with HeadObject until it is no longer found
+//
+// Each deleted key is still found by the first two checks, so it is confirmed by the third and is no longer listed
+// by the recount. A key which is never confirmed is only checked up to the number of attempts and is excluded from
+// the recount
+//----------------------------------------------
+
+func TestRotationDeleteConfirmed(t *testing.T) {
+	for _, lag := range []int{2, 10} {
+		server, mockSvc := deleteConsistencyTestServer()
+		server.SetDeleteLag(lag)
+
+		info := captureInfoLog(t)
+
+		consistencyPolicy := policy
+		consistencyPolicy.DeleteConfirmAttempts = 3
+		consistencyPolicy.DeleteConfirmInterval = time.Millisecond * 10
+
+		deletedKeys := StartRotation(mockSvc, mockBucket, consistencyPolicy, "", false)
+		if len(deletedKeys) != 2 || len(server.Requests("DeleteObject")) != 2 {
+			t.Error(fmt.Sprintf("expected 2 keys to be deleted once each but got %v", deletedKeys))
+		}
+
+		if heads := len(server.Requests("HeadObject")); heads != 6 {
+			t.Error(fmt.Sprintf("expected each deleted key to be checked 3 times with a lag of %d but %d checks were made", lag, heads))
+		}
+		if !strings.Contains(info.String(), "Retained 6 'daily_' key(s) after rotation") {
+			t.Error(fmt.Sprintf("expected 6 keys to be counted as retained with a lag of %d", lag))
+		}
+		server.Close()
+	}
+}
+
+// Returns a server with eight daily keys a day apart
+func deleteConsistencyTestServer() (*s3mock.Server, *s3.S3) {
+	server := s3mock.New(mockBucket)
+
+	now := time.Now()
+	for i := 0; i < 8; i++ {
+		server.PutObject(mockBucket, fmt.Sprintf("daily_consistency_%d", i), []byte("backup"), now.Add(-time.Hour*time.Duration(24*i+1)))
+	}
+	return server, server.Client()
+}
+
+// Captures the info log until the test ends
+func captureInfoLog(t *testing.T) *bytes.Buffer {
+	var info bytes.Buffer
+	log.Init(&info, ioutil.Discard, ioutil.Discard)
+	t.Cleanup(func() { log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard) })
+	return &info
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Restore Lock Testing (mock S3)
+//			Rotation defers while a restore holds a lock and proceeds once it is released
+//
+// Without a wait rotation is skipped as soon as a lock is found. With a wait the locks are polled and rotation
+// proceeds once the restore releases its lock. A lock which has expired, e.g. as the restore was killed, is ignored
+//----------------------------------------------
+
+func TestRotationDeferredByRestoreLock(t *testing.T) {
+	server, mockSvc := deleteConsistencyTestServer()
+	defer server.Close()
+
+	lock, err := s3client.AcquireRestoreLock(mockSvc, mockBucket, "", "daily_consistency_0", time.Hour)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to acquire the restore lock without any error: %v", err))
+	}
+
+	lockPolicy := policy
+	lockPolicy.RestoreLockPollInterval = time.Millisecond * 10
+
+	err = WaitForRestores(mockSvc, mockBucket, lockPolicy, "")
+	if err == nil || !strings.Contains(err.Error(), lock.Key) {
+		t.Fatal(fmt.Sprintf("expected rotation to be deferred while the restore holds '%s' but got: %v", lock.Key, err))
+	}
+
+	released := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		s3client.ReleaseRestoreLock(mockSvc, mockBucket, lock)
+		released <- time.Now()
+	}()
+
+	lockPolicy.RestoreLockWait = time.Second * 10
+	if err = WaitForRestores(mockSvc, mockBucket, lockPolicy, ""); err != nil {
+		t.Fatal(fmt.Sprintf("expected rotation to proceed once the restore lock was released but got: %v", err))
+	}
+	select {
+	case <-released:
+	default:
+		t.Error("expected rotation to wait until the restore lock was released")
+	}
+
+	if deletedKeys := StartRotation(mockSvc, mockBucket, lockPolicy, "", false); len(deletedKeys) != 2 {
+		t.Error(fmt.Sprintf("expected rotation to delete 2 keys once the restore lock was released but got %v", deletedKeys))
+	}
+}
+
+func TestRotationExpiredRestoreLockIgnored(t *testing.T) {
+	server, mockSvc := deleteConsistencyTestServer()
+	defer server.Close()
+
+	server.PutObject(mockBucket, "backups/"+s3client.RestoreLockDir+"20190304T010000Z_restorehost_1234_1.json", []byte("{}"), time.Now())
+
+	if err := WaitForRestores(mockSvc, mockBucket, policy, "backups/"); err != nil {
+		t.Error(fmt.Sprintf("expected an expired restore lock to be ignored but got: %v", err))
+	}
+
+	server.PutObject(mockBucket, "backups/"+s3client.RestoreLockDir+"unexpiring.json", []byte("{}"), time.Now())
+
+	if err := WaitForRestores(mockSvc, mockBucket, policy, "backups/"); err == nil {
+		t.Error("expected a restore lock without an expiry to be held")
+	}
+	if err := WaitForRestores(mockSvc, mockBucket, policy, "other/"); err != nil {
+		t.Error(fmt.Sprintf("expected the restore locks of another bucket dir to be ignored but got: %v", err))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Backup Index Testing (mock S3)
+//			Rotation removes the keys it deletes from the backup index
+//
+// The index is left as it is by a dry run and when rotation deletes nothing
+//----------------------------------------------
+
+func TestRotationPrunesBackupIndex(t *testing.T) {
+	server, mockSvc := deleteConsistencyTestServer()
+	defer server.Close()
+
+	for _, key := range server.Keys(mockBucket) {
+		if _, err := s3client.AddToBackupIndex(mockSvc, mockBucket, "index.json", key, "daily"); err != nil {
+			t.Fatal(fmt.Sprintf("expected to add key '%s' to the backup index without any error: %v", key, err))
+		}
+	}
+
+	indexPolicy := policy
+	indexPolicy.IndexKey = "index.json"
+
+	if deletedKeys := StartRotation(mockSvc, mockBucket, indexPolicy, "", true); len(deletedKeys) != 2 {
+		t.Fatal(fmt.Sprintf("expected the dry run to delete 2 keys but got %v", deletedKeys))
+	}
+	if index, _, _ := s3client.GetBackupIndex(mockSvc, mockBucket, "index.json"); len(index.Backups) != 8 {
+		t.Error(fmt.Sprintf("expected the dry run to leave 8 backups in the index but got %d", len(index.Backups)))
+	}
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, indexPolicy, "", false)
+	if len(deletedKeys) != 2 {
+		t.Fatal(fmt.Sprintf("expected rotation to delete 2 keys but got %v", deletedKeys))
+	}
+
+	index, found, err := s3client.GetBackupIndex(mockSvc, mockBucket, "index.json")
+	if err != nil || !found {
+		t.Fatal(fmt.Sprintf("expected the backup index to be found but got: found %t %v", found, err))
+	}
+	if len(index.Backups) != 6 {
+		t.Error(fmt.Sprintf("expected 6 backups to remain in the index but got %d", len(index.Backups)))
+	}
+	for _, backup := range index.Backups {
+		for _, deletedKey := range deletedKeys {
+			if backup.Key == deletedKey {
+				t.Error(fmt.Sprintf("expected deleted key '%s' to be removed from the backup index", deletedKey))
+			}
+		}
+	}
+
+	puts := len(server.Requests("PutObject"))
+	if deletedKeys = StartRotation(mockSvc, mockBucket, indexPolicy, "", false); len(deletedKeys) != 0 {
+		t.Fatal(fmt.Sprintf("expected the second rotation to delete nothing but got %v", deletedKeys))
+	}
+	if len(server.Requests("PutObject")) != puts {
+		t.Error("expected the backup index not to be written when rotation deletes nothing")
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Stray Objects Testing (mock S3)
+//			Only the objects under the bucket dir which are not in a rotation tier are reported and deleted
+//
+// The rotation audit, its rolled over histories, the backup index and hidden objects are never strays and objects
+// outside the bucket dir are never listed. A dry run reports the strays without deleting them
+//----------------------------------------------
+
+func TestFindStrays(t *testing.T) {
+	server, mockSvc := straysTestServer()
+	defer server.Close()
+
+	strays, err := FindStrays(mockSvc, mockBucket, straysPolicy(), "backups/")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to find strays without any error: %v", err))
+	}
+
+	keys := []string{}
+	for _, stray := range strays {
+		keys = append(keys, stray.Key)
+	}
+	sort.Strings(keys)
+
+	expected := "[backups/daily-manual.tar backups/manual.tar backups/photos/a.jpg]"
+	if fmt.Sprint(keys) != expected {
+		t.Error(fmt.Sprintf("expected strays %s but got %v", expected, keys))
+	}
+}
+
+func TestDeleteStrays(t *testing.T) {
+	server, mockSvc := straysTestServer()
+	defer server.Close()
+
+	strays, err := FindStrays(mockSvc, mockBucket, straysPolicy(), "backups/")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to find strays without any error: %v", err))
+	}
+
+	keysBefore := len(server.Keys(mockBucket))
+	if deletedKeys, err := DeleteStrays(mockSvc, mockBucket, "backups/", strays, true); err != nil || len(deletedKeys) != 3 {
+		t.Fatal(fmt.Sprintf("expected the dry run to report 3 strays but got %v %v", deletedKeys, err))
+	}
+	if len(server.Requests("DeleteObject")) != 0 || len(server.Keys(mockBucket)) != keysBefore {
+		t.Error("expected the dry run not to delete any key")
+	}
+
+	deletedKeys, err := DeleteStrays(mockSvc, mockBucket, "backups/", strays, false)
+	if err != nil || len(deletedKeys) != 3 {
+		t.Fatal(fmt.Sprintf("expected 3 strays to be deleted but got %v %v", deletedKeys, err))
+	}
+
+	remaining := server.Keys(mockBucket)
+	sort.Strings(remaining)
+	expected := "[backups/.chunks/0a1b backups/.s3backup-restores/lock.json backups/daily_album_20240102T020000 " +
+		"backups/index.json backups/monthly_album_20240101T020000 backups/rotation_audit.json " +
+		"backups/rotation_audit_20240101T020000.json backups/weekly_album_20240108T020000 other/manual.tar]"
+	if fmt.Sprint(remaining) != expected {
+		t.Error(fmt.Sprintf("expected keys %s to remain but got %v", expected, remaining))
+	}
+}
+
+func TestDeleteStraysOutsideBucketDir(t *testing.T) {
+	server, mockSvc := straysTestServer()
+	defer server.Close()
+
+	outside := []s3client.ListedObject{{Key: "other/manual.tar"}}
+	if deletedKeys, err := DeleteStrays(mockSvc, mockBucket, "backups/", outside, false); err != nil || len(deletedKeys) != 0 {
+		t.Error(fmt.Sprintf("expected no key outside the bucket dir to be deleted but got %v %v", deletedKeys, err))
+	}
+	if server.Object(mockBucket, "other/manual.tar") == nil {
+		t.Error("expected the key outside the bucket dir to remain")
+	}
+}
+
+// Returns a server with backups in every tier, objects s3backup stores alongside them, strays and an object outside the
+// bucket dir
+func straysTestServer() (*s3mock.Server, *s3.S3) {
+	server := s3mock.New(mockBucket)
+
+	now := time.Now()
+	for _, key := range []string{
+		"backups/daily_album_20240102T020000",
+		"backups/weekly_album_20240108T020000",
+		"backups/monthly_album_20240101T020000",
+		"backups/rotation_audit.json",
+		"backups/rotation_audit_20240101T020000.json",
+		"backups/index.json",
+		"backups/.chunks/0a1b",
+		"backups/.s3backup-restores/lock.json",
+		"backups/manual.tar",
+		"backups/daily-manual.tar",
+		"backups/photos/a.jpg",
+		"other/manual.tar",
+	} {
+		server.PutObject(mockBucket, key, []byte("object"), now)
+	}
+	return server, server.Client()
+}
+
+// Returns the rotation policy with the audit and backup index keys under the bucket dir of the strays test server
+func straysPolicy() rpolicy.RotationPolicy {
+	straysPolicy := policy
+	straysPolicy.AuditKey = "backups/rotation_audit.json"
+	straysPolicy.IndexKey = "backups/index.json"
+	return straysPolicy
+}
+
+// Returns a mock S3 server with twelve daily keys one day apart tagged app=myservice
+func tagCacheTestServer() *s3mock.Server {
+	server := s3mock.New(mockBucket)
+
+	now := time.Now()
+	for i := 0; i < 12; i++ {
+		obj := server.PutObject(mockBucket, fmt.Sprintf("daily_mine_%d", i), []byte("backup"), now.Add(-time.Hour*time.Duration(24*i)))
+		obj.Tags["app"] = "myservice"
+	}
+	return server
+}
+
+// Returns the rotation policy filtering by the app=myservice tag with a tag cache file in a temporary directory
+func tagCachePolicy(t *testing.T) rpolicy.RotationPolicy {
+	cachePolicy := policy
+	cachePolicy.TagFilter = map[string]string{"app": "myservice"}
+	cachePolicy.TagFetchConcurrency = 4
+	cachePolicy.TagCacheFile = filepath.Join(t.TempDir(), "tags.json")
+	cachePolicy.TagCacheTTL = time.Hour
+	return cachePolicy
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Tag Cache Testing (mock S3)
+//			1: Tags are fetched concurrently up to the tag fetch concurrency and only once per rotation
+//			2: A later rotation reuses the cached tags within the TTL and only fetches the tags of changed objects
+//			3: The cached tags are fetched again once the TTL has elapsed
+//
+// Twelve daily keys are tagged app=myservice. The tags of every key are fetched by a single rotation even though each
+// tier is listed again once keys have been deleted
+//----------------------------------------------
+
+// Test 1 - Tag Cache Testing
+//	Tags are fetched concurrently up to the tag fetch concurrency and only once per rotation
+func TestTagFetchConcurrency(t *testing.T) {
+	server := tagCacheTestServer()
+	defer server.Close()
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation != "GetObjectTagging" {
+			return nil
+		}
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return nil
+	})
+
+	cachePolicy := tagCachePolicy(t)
+	cachePolicy.TagCacheFile = ""
+
+	deletedKeys := StartRotation(server.Client(), mockBucket, cachePolicy, "", false)
+	if len(deletedKeys) != 12-cachePolicy.DailyRetentionCount {
+		t.Fatal(fmt.Sprintf("expected the tagged keys beyond the retention count to be deleted but got %v", deletedKeys))
+	}
+
+	if maxInFlight != 4 {
+		t.Error(fmt.Sprintf("expected the tags of 4 objects to be fetched at once but got: %d", maxInFlight))
+	}
+	if fetched := len(server.Requests("GetObjectTagging")); fetched != 12 {
+		t.Error(fmt.Sprintf("expected the tags of each of the 12 keys to be fetched once but %d requests were made", fetched))
+	}
+}
+
+// Test 2 - Tag Cache Testing
+//	A later rotation reuses the cached tags within the TTL and only fetches the tags of changed objects
+func TestTagCacheReused(t *testing.T) {
+	server := tagCacheTestServer()
+	defer server.Close()
+
+	cachePolicy := tagCachePolicy(t)
+
+	StartRotation(server.Client(), mockBucket, cachePolicy, "", true)
+	if fetched := len(server.Requests("GetObjectTagging")); fetched != 12 {
+		t.Fatal(fmt.Sprintf("expected the first rotation to fetch the tags of the 12 keys but %d requests were made", fetched))
+	}
+
+	// Replacing the object changes its ETag
+	changed := server.PutObject(mockBucket, "daily_mine_3", []byte("changed backup"), time.Now().Add(-time.Hour*72))
+	changed.Tags["app"] = "otherservice"
+
+	deletedKeys := StartRotation(server.Client(), mockBucket, cachePolicy, "", true)
+
+	requests := server.Requests("GetObjectTagging")
+	if len(requests) != 13 || requests[12].Key != "daily_mine_3" {
+		t.Error(fmt.Sprintf("expected only the tags of the changed key to be fetched again but %d requests were made", len(requests)-12))
+	}
+	for _, key := range deletedKeys {
+		if key == "daily_mine_3" {
+			t.Error("expected the changed key which no longer matches the tag filter to be ignored")
+		}
+	}
+}
+
+// Test 3 - Tag Cache Testing
+//	The cached tags are fetched again once the TTL has elapsed
+func TestTagCacheExpired(t *testing.T) {
+	server := tagCacheTestServer()
+	defer server.Close()
+
+	cachePolicy := tagCachePolicy(t)
+	cachePolicy.TagCacheTTL = time.Nanosecond
+
+	StartRotation(server.Client(), mockBucket, cachePolicy, "", true)
+	StartRotation(server.Client(), mockBucket, cachePolicy, "", true)
+
+	if fetched := len(server.Requests("GetObjectTagging")); fetched != 24 {
+		t.Error(fmt.Sprintf("expected the expired tags of the 12 keys to be fetched again but %d requests were made", fetched))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Confirmed Keys Testing (mock S3)
+//			Only the keys confirmed for deletion are deleted
+//
+// The plan of a rotation of eight daily keys deletes the two oldest keys. Two older keys are stored after the plan was
+// confirmed, so rotation would delete them as well, but only the confirmed keys are deleted
+//----------------------------------------------
+
+func TestRotationOnlyConfirmedKeysDeleted(t *testing.T) {
+	server, mockSvc := deleteConsistencyTestServer()
+	defer server.Close()
+
+	plannedKeys := StartRotation(mockSvc, mockBucket, policy, "", true)
+	if fmt.Sprint(plannedKeys) != "[daily_consistency_6 daily_consistency_7]" {
+		t.Fatal(fmt.Sprintf("expected the 2 oldest keys to be planned for deletion but got %v", plannedKeys))
+	}
+
+	now := time.Now()
+	for i := 8; i < 10; i++ {
+		server.PutObject(mockBucket, fmt.Sprintf("daily_consistency_%d", i), []byte("backup"), now.Add(-time.Hour*time.Duration(24*i+1)))
+	}
+
+	confirmedPolicy := policy
+	confirmedPolicy.ConfirmedKeys = map[string]bool{}
+	for _, key := range plannedKeys {
+		confirmedPolicy.ConfirmedKeys[key] = true
+	}
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, confirmedPolicy, "", false)
+	if fmt.Sprint(deletedKeys) != fmt.Sprint(plannedKeys) || len(server.Requests("DeleteObject")) != 2 {
+		t.Error(fmt.Sprintf("expected only the confirmed keys %v to be deleted but got %v", plannedKeys, deletedKeys))
+	}
+	if server.Object(mockBucket, "daily_consistency_8") == nil || server.Object(mockBucket, "daily_consistency_9") == nil {
+		t.Error("expected the keys which were not confirmed to be retained")
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Split Volumes Testing (mock S3)
+//			The volumes of a split upload are deleted along with its index
+//
+// The two oldest of eight daily keys are deleted by rotation. The oldest key and a retained key are the indexes of split
+// uploads whose volumes are stored under the volume dir, only the volumes of the deleted index are deleted
+//----------------------------------------------
+
+func TestRotationDeletesSplitVolumes(t *testing.T) {
+	server, mockSvc := deleteConsistencyTestServer()
+	defer server.Close()
+
+	now := time.Now()
+	for _, key := range []string{"daily_consistency_7", "daily_consistency_0"} {
+		for volume := 1; volume <= 2; volume++ {
+			server.PutObject(mockBucket, s3client.VolumeKey("", key, volume), []byte("volume"), now)
+		}
+	}
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, policy, "", false)
+	if fmt.Sprint(deletedKeys) != "[daily_consistency_6 daily_consistency_7]" {
+		t.Fatal(fmt.Sprintf("expected the volumes not to be rotated as backups but got %v", deletedKeys))
+	}
+
+	for volume := 1; volume <= 2; volume++ {
+		if server.Object(mockBucket, s3client.VolumeKey("", "daily_consistency_7", volume)) != nil {
+			t.Error(fmt.Sprintf("expected volume %d of the deleted index to be deleted", volume))
+		}
+		if server.Object(mockBucket, s3client.VolumeKey("", "daily_consistency_0", volume)) == nil {
+			t.Error(fmt.Sprintf("expected volume %d of the retained index to be retained", volume))
+		}
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Tier Listing Testing (mock S3)
+//			The backups of each tier under the bucket dir are listed newest first by the time in each key
+//
+// The daily key whose last modified time is the most recent was copied after the other daily keys were uploaded, so
+// it is listed last by the time in its key. Objects outside the bucket dir are never listed
+//----------------------------------------------
+
+func TestListTiers(t *testing.T) {
+	server, mockSvc := straysTestServer()
+	defer server.Close()
+
+	now := time.Now()
+	server.PutObject(mockBucket, "backups/daily_album_20240101T020000", []byte("copied backup"), now.Add(time.Hour))
+	server.PutObject(mockBucket, "backups/daily_album_20240103T020000", []byte("backup"), now.Add(-time.Hour))
+	server.PutObject(mockBucket, "other/daily_album_20240104T020000", []byte("backup"), now)
+
+	tiers, err := ListTiers(mockSvc, mockBucket, straysPolicy(), "backups/")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to list the tiers without any error: %v", err))
+	}
+
+	listed := []string{}
+	for _, tier := range tiers {
+		keys := []string{}
+		for _, backup := range tier.Backups {
+			keys = append(keys, fmt.Sprintf("%s:%d", backup.Key, backup.Size))
+		}
+		listed = append(listed, fmt.Sprintf("%s=%v", tier.Tier, keys))
+	}
+
+	expected := "[daily=[backups/daily_album_20240103T020000:6 backups/daily_album_20240102T020000:6 backups/daily_album_20240101T020000:13] " +
+		"weekly=[backups/weekly_album_20240108T020000:6] monthly=[backups/monthly_album_20240101T020000:6]]"
+	if fmt.Sprint(listed) != expected {
+		t.Error(fmt.Sprintf("expected tiers %s but got %v", expected, listed))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Yearly Rotation Testing (mock S3)
+//			The yearly tier is rotated by its retention count while monthly backups are never rotated
+//
+// Four yearly keys and two monthly keys are stored. With a yearly retention count of 2 the two oldest yearly keys are
+// deleted by both the rotation and the simulated rotation
+//----------------------------------------------
+
+func TestRotationYearlyTier(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	for i, year := range []int{2024, 2023, 2022, 2021} {
+		server.PutObject(mockBucket, fmt.Sprintf("yearly_album_%d0101T020000", year), []byte("backup"), now.AddDate(-i, 0, 0))
+	}
+	server.PutObject(mockBucket, "monthly_album_20230201T020000", []byte("backup"), now.AddDate(-1, 0, 0))
+	server.PutObject(mockBucket, "monthly_album_20210201T020000", []byte("backup"), now.AddDate(-3, 0, 0))
+
+	yearlyPolicy := policy
+	yearlyPolicy.YearlyPrefix = "yearly_"
+	yearlyPolicy.YearlyRetentionCount = 2
+	yearlyPolicy.YearlyRetentionPeriod = time.Hour * 24 * 365
+
+	expected := "[yearly_album_20220101T020000 yearly_album_20210101T020000]"
+
+	simulatedRuns, err := SimulateRotation(mockSvc, mockBucket, yearlyPolicy, "", testFileName, 1, 0)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to simulate rotation without any error: %v", err))
+	}
+	if fmt.Sprint(simulatedRuns[0].DeletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected the simulated rotation to delete %s but got %v", expected, simulatedRuns[0].DeletedKeys))
+	}
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, yearlyPolicy, "", false)
+	if fmt.Sprint(deletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected the rotation to delete %s but got %v", expected, deletedKeys))
+	}
+	if len(server.Keys(mockBucket)) != 4 {
+		t.Error(fmt.Sprintf("expected the 2 newest yearly keys and both monthly keys to be kept but got %v", server.Keys(mockBucket)))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Yearly Rotation Testing (mock S3)
+//			The yearly tier is never rotated with a yearly retention count of 0 like the monthly tier
+//
+// Four yearly keys older than the yearly retention period are stored. With a yearly retention count of 0 neither the
+// rotation nor the simulated rotation deletes any of them
+//----------------------------------------------
+
+func TestRotationYearlyNotRotated(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	for i, year := range []int{2024, 2023, 2022, 2021} {
+		server.PutObject(mockBucket, fmt.Sprintf("yearly_album_%d0101T020000", year), []byte("backup"), now.AddDate(-i-1, 0, 0))
+	}
+
+	yearlyPolicy := policy
+	yearlyPolicy.YearlyPrefix = "yearly_"
+	yearlyPolicy.YearlyRetentionCount = 0
+	yearlyPolicy.YearlyRetentionPeriod = time.Hour * 24
+
+	simulatedRuns, err := SimulateRotation(mockSvc, mockBucket, yearlyPolicy, "", testFileName, 1, 0)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to simulate rotation without any error: %v", err))
+	}
+	if len(simulatedRuns[0].DeletedKeys) != 0 {
+		t.Error(fmt.Sprintf("expected the simulated rotation not to delete any yearly keys but got %v", simulatedRuns[0].DeletedKeys))
+	}
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, yearlyPolicy, "", false)
+	if len(deletedKeys) != 0 || len(server.Keys(mockBucket)) != 4 {
+		t.Error(fmt.Sprintf("expected every yearly key to be kept but got deleted keys %v", deletedKeys))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Monthly Rotation Testing (mock S3)
+//			The monthly tier is rotated by its retention count and period once a retention count is specified
+//
+// Four monthly keys are stored. With a monthly retention count of 2 and a retention period of 60 days the oldest
+// monthly key is deleted by both the rotation and the simulated rotation while the third, which is still within the
+// retention period, is kept. Without a monthly retention count no monthly key is deleted
+//----------------------------------------------
+
+func TestRotationMonthlyTier(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	server.PutObject(mockBucket, "monthly_album_20240401T020000", []byte("backup"), now.AddDate(0, 0, -1))
+	server.PutObject(mockBucket, "monthly_album_20240301T020000", []byte("backup"), now.AddDate(0, 0, -31))
+	server.PutObject(mockBucket, "monthly_album_20240201T020000", []byte("backup"), now.AddDate(0, 0, -50))
+	server.PutObject(mockBucket, "monthly_album_20240101T020000", []byte("backup"), now.AddDate(0, 0, -90))
+
+	if deletedKeys := StartRotation(mockSvc, mockBucket, policy, "", false); len(deletedKeys) != 0 {
+		t.Error(fmt.Sprintf("expected no monthly key to be deleted without a monthly retention count but got %v", deletedKeys))
+	}
+
+	monthlyPolicy := policy
+	monthlyPolicy.MonthlyRetentionCount = 2
+	monthlyPolicy.MonthlyRetentionPeriod = time.Hour * 24 * 60
+	monthlyPolicy.EnforceRetentionPeriod = true
+
+	expected := "[monthly_album_20240101T020000]"
+
+	simulatedRuns, err := SimulateRotation(mockSvc, mockBucket, monthlyPolicy, "", testFileName, 1, 0)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to simulate rotation without any error: %v", err))
+	}
+	if fmt.Sprint(simulatedRuns[0].DeletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected the simulated rotation to delete %s but got %v", expected, simulatedRuns[0].DeletedKeys))
+	}
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, monthlyPolicy, "", false)
+	if fmt.Sprint(deletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected the rotation to delete %s but got %v", expected, deletedKeys))
+	}
+	if len(server.Keys(mockBucket)) != 3 {
+		t.Error(fmt.Sprintf("expected the 3 monthly keys within the retention count or period to be kept but got %v", server.Keys(mockBucket)))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Rotation Timeout Testing (mock S3)
+//			Rotation stops deleting keys once its timeout has elapsed
+//
+// Eight daily keys are stored. A rotation whose timeout has already elapsed deletes none of the two keys beyond the
+// daily retention count, which are deleted by the next rotation without a timeout
+//----------------------------------------------
+
+func TestRotationTimeout(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	for i := 0; i < 8; i++ {
+		server.PutObject(mockBucket, fmt.Sprintf("daily_album_202401%02dT020000", 10-i), []byte("backup"), now.AddDate(0, 0, -i-8))
+	}
+
+	timeoutPolicy := policy
+	timeoutPolicy.Timeout = time.Nanosecond
+
+	if deletedKeys := StartRotation(mockSvc, mockBucket, timeoutPolicy, "", false); len(deletedKeys) != 0 {
+		t.Error(fmt.Sprintf("expected no key to be deleted once the rotation timed out but got %v", deletedKeys))
+	}
+	if len(server.Requests("DeleteObject")) != 0 {
+		t.Error("expected no delete to be requested once the rotation timed out")
+	}
+
+	expected := "[daily_album_20240104T020000 daily_album_20240103T020000]"
+	if deletedKeys := StartRotation(mockSvc, mockBucket, policy, "", false); fmt.Sprint(deletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected the next rotation to delete %s but got %v", expected, deletedKeys))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Rotation Report Testing (mock S3)
+//			A dry run reports every key each tier would delete or keep with its age and the rule which decided it
+//
+// Nine daily keys and two weekly keys are stored. The daily retention period of 7 days is enforced, so of the three
+// daily keys beyond the retention count the one only 6 days old is kept and the two older keys would be deleted. Both
+// weekly keys are kept as there are fewer than the weekly retention count. Nothing is deleted by the dry run
+//----------------------------------------------
+
+func TestRotationReport(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	for i := 0; i < 9; i++ {
+		server.PutObject(mockBucket, fmt.Sprintf("daily_album_202401%02dT020000", 10-i), []byte("backup"), now.Add(-time.Duration(24*i+1)*time.Hour))
+	}
+	server.PutObject(mockBucket, "weekly_album_20240107T020000", []byte("backup"), now.AddDate(0, 0, -3))
+	server.PutObject(mockBucket, "weekly_album_20231231T020000", []byte("backup"), now.AddDate(0, 0, -10))
+
+	reportPolicy := policy
+	reportPolicy.DailyRetentionPeriod = 7 * 24 * time.Hour
+	reportPolicy.EnforceRetentionPeriod = true
+
+	deletedKeys, report := StartRotationWithReport(mockSvc, mockBucket, reportPolicy, "", true)
+
+	expected := "[daily_album_20240103T020000 daily_album_20240102T020000]"
+	if fmt.Sprint(deletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected the dry run to report %s as deleted but got %v", expected, deletedKeys))
+	}
+	if len(server.Requests("DeleteObject")) != 0 {
+		t.Error("expected no delete to be requested by the dry run")
+	}
+	if !report.DryRun || report.Bucket != mockBucket || report.Deleted != 2 || report.Kept != 9 {
+		t.Error(fmt.Sprintf("expected a dry run report of bucket '%s' deleting 2 keys and keeping 9 but got %+v", mockBucket, report))
+	}
+	if len(report.Tiers) != 2 {
+		t.Fatalf("expected a report of the daily and weekly tiers but got %d tiers", len(report.Tiers))
+	}
+
+	daily, weekly := report.Tiers[0], report.Tiers[1]
+	if daily.Prefix != "daily_" || daily.RetentionCount != 6 || daily.RetentionPeriodHours != 168 || daily.Deleted != 2 || daily.Kept != 7 || len(daily.Keys) != 9 {
+		t.Error(fmt.Sprintf("expected the daily tier to delete 2 keys and keep 7 but got %+v", daily))
+	}
+	if weekly.Prefix != "weekly_" || weekly.Deleted != 0 || weekly.Kept != 2 {
+		t.Error(fmt.Sprintf("expected the weekly tier to keep both keys but got %+v", weekly))
+	}
+
+	for _, tier := range report.Tiers {
+		for _, key := range tier.Keys {
+			if age := report.RunTime.Sub(key.LastModified).Hours(); key.AgeHours != age {
+				t.Error(fmt.Sprintf("expected the age of key '%s' to be measured from the run time as %f hours but got %f hours", key.Key, age, key.AgeHours))
+			}
+		}
+	}
+
+	for _, key := range daily.Keys {
+		switch key.Key {
+		case "daily_album_20240110T020000":
+			if key.Action != ReportActionKeep || !strings.Contains(key.Reason, "within the newest 6 'daily_' keys") {
+				t.Error(fmt.Sprintf("expected the newest daily key to be kept by the retention count but got %+v", key))
+			}
+		case "daily_album_20240104T020000":
+			if key.Action != ReportActionKeep || !strings.Contains(key.Reason, "within the enforced retention period of 168.0 hours") {
+				t.Error(fmt.Sprintf("expected the daily key 6 days old to be kept by the retention period but got %+v", key))
+			}
+		case "daily_album_20240102T020000":
+			if key.Action != ReportActionDelete || !strings.Contains(key.Reason, "exceeds the 'daily_' retention count of 6") {
+				t.Error(fmt.Sprintf("expected the oldest daily key to be deleted by the retention count but got %+v", key))
+			}
+			if key.AgeHours < 193 || key.AgeHours > 194 {
+				t.Error(fmt.Sprintf("expected the oldest daily key to be 193 hours old but got %0.1f hours", key.AgeHours))
+			}
+		}
+	}
+
+	table := report.Table()
+	for _, expected := range []string{"AGE (HOURS)", "daily_album_20240102T020000", ReportActionDelete, "weekly_album_20231231T020000", "total"} {
+		if !strings.Contains(table, expected) {
+			t.Error(fmt.Sprintf("expected the report table to contain '%s' but got:\n%s", expected, table))
+		}
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Min Keep Testing (mock S3)
+//			The newest keys of each tier are kept by the minimum keep even if the retention count would delete them
+//
+// Five daily, weekly and yearly keys are stored and rotated with a retention period of 0 and a retention count of 0 for
+// the daily and weekly tiers, which would delete every key, and of 1 for the yearly tier, as a yearly retention count of
+// 0 never rotates the tier. With a minimum keep of 2 the newest 2 keys of each tier are kept
+//----------------------------------------------
+
+func TestRotationMinKeep(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		server.PutObject(mockBucket, fmt.Sprintf("daily_album_202401%02dT020000", 10-i), []byte("backup"), now.AddDate(0, 0, -i-1))
+		server.PutObject(mockBucket, fmt.Sprintf("weekly_album_2023%02d01T020000", 12-i), []byte("backup"), now.AddDate(0, 0, -7*i-14))
+		server.PutObject(mockBucket, fmt.Sprintf("yearly_album_%d0101T020000", 2022-i), []byte("backup"), now.AddDate(-i-1, 0, 0))
+	}
+
+	emptyPolicy := policy
+	emptyPolicy.DailyRetentionCount, emptyPolicy.DailyRetentionPeriod = 0, 0
+	emptyPolicy.WeeklyRetentionCount, emptyPolicy.WeeklyRetentionPeriod = 0, 0
+	emptyPolicy.YearlyRetentionCount, emptyPolicy.YearlyRetentionPeriod = 1, 0
+	emptyPolicy.YearlyPrefix = "yearly_"
+	emptyPolicy.MinKeep = 2
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, emptyPolicy, "", false)
+	if len(deletedKeys) != 9 {
+		t.Error(fmt.Sprintf("expected the 3 oldest keys of each tier to be deleted but got %v", deletedKeys))
+	}
+
+	keys := server.Keys(mockBucket)
+	sort.Strings(keys)
+	expected := "[daily_album_20240109T020000 daily_album_20240110T020000 weekly_album_20231101T020000 weekly_album_20231201T020000 " +
+		"yearly_album_20210101T020000 yearly_album_20220101T020000]"
+	if fmt.Sprint(keys) != expected {
+		t.Error(fmt.Sprintf("expected the newest 2 keys of each tier %s to be kept but got %v", expected, keys))
+	}
+}
+
+//----------------------------------------------
+// Positive Testing
+//		Min Keep Testing (mock S3)
+//			A tier which the minimum keep keeps entirely is skipped without logging that its keys are purged
+//
+// Two daily keys are stored and rotated with a retention count of 0 and a minimum keep of 3, which keeps both keys
+//----------------------------------------------
+
+func TestRotationMinKeepKeepsTier(t *testing.T) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	server.PutObject(mockBucket, "daily_album_20240110T020000", []byte("backup"), now.AddDate(0, 0, -1))
+	server.PutObject(mockBucket, "daily_album_20240109T020000", []byte("backup"), now.AddDate(0, 0, -2))
+
+	floorPolicy := policy
+	floorPolicy.DailyRetentionCount, floorPolicy.DailyRetentionPeriod = 0, 0
+	floorPolicy.MinKeep = 3
+
+	info := captureInfoLog(t)
+	deletedKeys := StartRotation(mockSvc, mockBucket, floorPolicy, "", false)
+	if len(deletedKeys) != 0 || len(server.Keys(mockBucket)) != 2 {
+		t.Error(fmt.Sprintf("expected both daily keys to be kept by the minimum keep but got deleted keys %v", deletedKeys))
+	}
+
+	if logged := info.String(); strings.Contains(logged, "purging") {
+		t.Error(fmt.Sprintf("expected no keys to be logged as purged but got:\n%s", logged))
+	} else if !strings.Contains(logged, "as the minimum keep of 3 keeps all 2 key(s)") {
+		t.Error(fmt.Sprintf("expected the minimum keep to be logged as keeping the tier but got:\n%s", logged))
+	}
+}
+
+//----------------------------------------------
+//
+//      Helper functions for testing below
+//
+//----------------------------------------------
+
+// Uploads the 'test_backup_file' file in the repository and initiates a GFS rotation
+// If delay > 0 then the thread will sleep for the delay specified (seconds) before rotating the files
+func runMockBackup(t *testing.T, uploadDate time.Time, delay int, providedPolicy rpolicy.RotationPolicy, dryRun bool) (string, []string) {
+
+	testUploadObject := upload.UploadObject{
+		PathToFile: pathToTestFile,
+		S3FileName: testFileName + "_" + uploadDate.Format("02-Jan-06"),
+		BucketDir:  "",
+		Bucket:     bucket,
+		Timeout:    timeout,
+		NumWorkers: 5,
+		PartSize:   50,
+		Manipulate: true,
+	}
+
+	prefix := util.GetKeyType(providedPolicy, uploadDate)
+	s3FileName, err := upload.UploadFile(svc, testUploadObject, prefix, dryRun)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to upload file: %v", err))
+	}
+
+	// If no minimum delay is specified then it's more likely a file will be uploaded
+	// out of order and break the test
+	if delay == 0 {
+		delay = 1
+	}
+
+	time.Sleep(time.Second * time.Duration(delay))
+
+	return s3FileName, StartRotation(svc, bucket, providedPolicy, "", dryRun)
+}
+
+func justUploadIt(s3FileName string, s3BucketDir string) (string, error) {
+	testUploadObject := upload.UploadObject{
+		PathToFile: pathToTestFile,
+		S3FileName: s3FileName,
+		BucketDir:  s3BucketDir,
+		Bucket:     bucket,
+		Timeout:    timeout,
+		NumWorkers: 5,
+		PartSize:   50,
+		Manipulate: false,
+	}
+
+	prefix := util.GetKeyType(policy, time.Now())
+	backupKey, err := upload.UploadFile(svc, testUploadObject, prefix, false)
+	if err != nil {
+		return "", err
+	}
+	return backupKey, nil
+}
+
+// Rotates five daily keys uploaded a day apart with the policy and checks that exactly the expected keys are deleted
+// by both the rotation and the simulated rotation
+func assertBoundaryRotation(t *testing.T, boundaryPolicy rpolicy.RotationPolicy, expected string) {
+	server := s3mock.New(mockBucket)
+	defer server.Close()
+	mockSvc := server.Client()
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		server.PutObject(mockBucket, fmt.Sprintf("daily_boundary_%d", i), []byte("backup"), now.Add(-time.Hour*time.Duration(24*i+1)))
+	}
+
+	simulatedRuns, err := SimulateRotation(mockSvc, mockBucket, boundaryPolicy, "", testFileName, 1, 0)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to simulate rotation without any error: %v", err))
+	}
+	if fmt.Sprint(simulatedRuns[0].DeletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected the simulated rotation to delete %s but got %v", expected, simulatedRuns[0].DeletedKeys))
+	}
+
+	deletedKeys := StartRotation(mockSvc, mockBucket, boundaryPolicy, "", false)
+	if fmt.Sprint(deletedKeys) != expected {
+		t.Error(fmt.Sprintf("expected %s to be deleted but got %v", expected, deletedKeys))
+	}
+
+	remaining := 5 - len(deletedKeys)
+	if len(server.Requests("DeleteObject")) != len(deletedKeys) || len(server.Keys(mockBucket)) != remaining {
+		t.Error(fmt.Sprintf("expected %d delete requests leaving %d keys but %d delete requests were made",
+			len(deletedKeys), remaining, len(server.Requests("DeleteObject"))))
 	}
-	return backupKey, nil
 }
@@ -0,0 +1,120 @@
+package rotate
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/rpolicy"
+	"s3backup/s3client"
+	"s3backup/util"
+	"time"
+)
+
+// SimulatedRun represents the projected outcome of a single backup and rotation run
+type SimulatedRun struct {
+	RunTime       time.Time
+	UploadedKey   string // Empty for the first run as it rotates the current bucket contents only
+	DeletedKeys   []string
+	RemainingKeys map[string][]s3client.BucketEntry // Map[Prefix] -> keys sorted newest first
+}
+
+// SimulateRotation projects what rotation would do over the next number of runs given the current bucket contents.
+// The first run rotates the bucket as it is now which matches a dry run rotation. Every subsequent run assumes a
+// backup of s3FileName is uploaded once per cadence before rotating. Nothing in the bucket is modified
+func SimulateRotation(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, bucketDir string, s3FileName string, runs int, cadence time.Duration) ([]SimulatedRun, error) {
+	if runs < 1 {
+		return nil, fmt.Errorf("number of simulated runs must be greater than 0: %d", runs)
+	}
+
+	if runs > 1 && cadence <= 0 {
+		return nil, fmt.Errorf("upload cadence must be greater than 0 when simulating more than one run: %v", cadence)
+	}
+
+	tags, err := newTagFilter(svc, bucket, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := newKeyOrder(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string][]s3client.BucketEntry)
+	for _, prefix := range policy.Prefixes() {
+		sortedKeys, err := order.sortedKeys(svc, bucket, prefix, bucketDir)
+		if err != nil {
+			return nil, err
+		}
+		if tags != nil {
+			sortedKeys, err = tags.filter(sortedKeys)
+			if err != nil {
+				return nil, err
+			}
+		}
+		keys[prefix] = order.filter(sortedKeys, prefix)
+	}
+
+	simulatedRuns := []SimulatedRun{}
+	startTime := time.Now()
+
+	for i := 0; i < runs; i++ {
+		run := SimulatedRun{RunTime: startTime.Add(cadence * time.Duration(i)), DeletedKeys: []string{}}
+
+		if i > 0 {
+			prefix := util.GetKeyType(policy, run.RunTime)
+			run.UploadedKey = fmt.Sprintf("%s%s%s_%s", bucketDir, prefix, s3FileName, run.RunTime.Format(order.layout))
+			keys[prefix] = append([]s3client.BucketEntry{{Key: run.UploadedKey, ModifiedTime: run.RunTime}}, keys[prefix]...)
+		}
+
+		var deleted []string
+		deleted, keys[policy.DailyPrefix] = simulateKeyRotation(keys[policy.DailyPrefix], policy.DailyRetentionPeriod, policy.DailyRetentionCount, policy.MinKeep, policy.EnforceRetentionPeriod, run.RunTime)
+		run.DeletedKeys = append(run.DeletedKeys, deleted...)
+
+		deleted, keys[policy.WeeklyPrefix] = simulateKeyRotation(keys[policy.WeeklyPrefix], policy.WeeklyRetentionPeriod, policy.WeeklyRetentionCount, policy.MinKeep, policy.EnforceRetentionPeriod, run.RunTime)
+		run.DeletedKeys = append(run.DeletedKeys, deleted...)
+
+		if policy.MonthlyRetentionCount > 0 {
+			deleted, keys[policy.MonthlyPrefix] = simulateKeyRotation(keys[policy.MonthlyPrefix], policy.MonthlyRetentionPeriod, policy.MonthlyRetentionCount, policy.MinKeep, policy.EnforceRetentionPeriod, run.RunTime)
+			run.DeletedKeys = append(run.DeletedKeys, deleted...)
+		}
+
+		if policy.YearlyPrefix != "" && policy.YearlyRetentionCount > 0 {
+			deleted, keys[policy.YearlyPrefix] = simulateKeyRotation(keys[policy.YearlyPrefix], policy.YearlyRetentionPeriod, policy.YearlyRetentionCount, policy.MinKeep, policy.EnforceRetentionPeriod, run.RunTime)
+			run.DeletedKeys = append(run.DeletedKeys, deleted...)
+		}
+
+		run.RemainingKeys = make(map[string][]s3client.BucketEntry)
+		for prefix, entries := range keys {
+			run.RemainingKeys[prefix] = append([]s3client.BucketEntry{}, entries...)
+		}
+
+		simulatedRuns = append(simulatedRuns, run)
+	}
+
+	return simulatedRuns, nil
+}
+
+// Applies the same rules as keyRotation to the sorted keys (newest first) as if the rotation ran at the specified time.
+// Returns the keys that would be deleted and the keys that would remain
+func simulateKeyRotation(sortedKeys []s3client.BucketEntry, retentionPeriod time.Duration, retentionCount int, minKeep int, enforceRetentionPeriod bool, runTime time.Time) ([]string, []s3client.BucketEntry) {
+	if retentionCount < 0 {
+		return nil, sortedKeys
+	}
+	retentionCount = retainedCount(retentionCount, minKeep)
+	if len(sortedKeys) <= retentionCount {
+		return nil, sortedKeys
+	}
+
+	deletedKeys := []string{}
+	remainingKeys := append([]s3client.BucketEntry{}, sortedKeys[:retentionCount]...)
+
+	for _, kv := range sortedKeys[retentionCount:] {
+		if enforceRetentionPeriod && runTime.Sub(kv.ModifiedTime) <= retentionPeriod {
+			remainingKeys = append(remainingKeys, kv)
+			continue
+		}
+		deletedKeys = append(deletedKeys, kv.Key)
+	}
+
+	return deletedKeys, remainingKeys
+}
@@ -0,0 +1,101 @@
+package rotate
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/rpolicy"
+	"s3backup/s3client"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// FindStrays returns every object under the bucket dir which is not in a rotation tier, i.e. whose key relative to the
+// bucket dir does not begin with the daily, weekly or monthly prefix of the policy, e.g. objects uploaded manually or
+// by another tool. The objects s3backup stores under the bucket dir alongside the backups are never strays: the
+// rotation audit and its rolled over histories, the backup index and hidden objects such as chunks, restore locks and
+// permission probes. Objects outside the bucket dir are never listed
+func FindStrays(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, bucketDir string) ([]s3client.ListedObject, error) {
+	listing, err := s3client.ListByDelimiter(svc, bucket, bucketDir, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys under '%s': %v", bucketDir, err)
+	}
+
+	strays := []s3client.ListedObject{}
+	for _, obj := range listing.Objects {
+		if !strings.HasPrefix(obj.Key, bucketDir) || !isStray(obj.Key[len(bucketDir):], obj.Key, policy) {
+			continue
+		}
+		strays = append(strays, obj)
+	}
+
+	log.Info.Printf("Found %d stray object(s) out of %d under '%s'\n", len(strays), len(listing.Objects), bucketDir)
+	return strays, nil
+}
+
+// DeleteStrays deletes the strays found by FindStrays and returns the keys which were deleted. Every key is checked to
+// be under the bucket dir again before it is deleted. A key which fails to be deleted does not stop the remaining
+// strays from being deleted
+func DeleteStrays(svc *s3.S3, bucket string, bucketDir string, strays []s3client.ListedObject, dryRun bool) ([]string, error) {
+	deletedKeys := []string{}
+	failed := 0
+	for _, stray := range strays {
+		if !strings.HasPrefix(stray.Key, bucketDir) {
+			log.Warn.Printf("Refusing to delete key: '%s' as it is not under '%s'\n", stray.Key, bucketDir)
+			continue
+		}
+
+		if dryRun {
+			log.Info.Printf("Skipping deletion of stray key: '%s' as dry run has been enabled\n", stray.Key)
+			deletedKeys = append(deletedKeys, stray.Key)
+			continue
+		}
+
+		if _, err := s3client.DeleteKey(svc, bucket, stray.Key); err != nil {
+			log.Error.Printf("Failed to delete stray key: '%s'. Reason: %v\n", stray.Key, err)
+			failed++
+			continue
+		}
+		log.Info.Printf("Deleted stray key: '%s'\n", stray.Key)
+		deletedKeys = append(deletedKeys, stray.Key)
+	}
+
+	if failed > 0 {
+		return deletedKeys, fmt.Errorf("failed to delete %d of %d stray keys", failed, len(strays))
+	}
+	return deletedKeys, nil
+}
+
+// Returns true if the key, relative to the bucket dir, is neither the key of a backup in a rotation tier nor an
+// object s3backup stores alongside the backups
+func isStray(relativeKey string, key string, policy rpolicy.RotationPolicy) bool {
+	for _, prefix := range policy.Prefixes() {
+		if prefix != "" && strings.HasPrefix(relativeKey, prefix) {
+			return false
+		}
+	}
+
+	if strings.HasPrefix(relativeKey, ".") || key == policy.IndexKey || key == policy.LastSuccessKey || isAuditKey(key, policy.AuditKey) {
+		return false
+	}
+	return true
+}
+
+// Returns true if the key is the audit key or a history the audit was rolled over to
+func isAuditKey(key string, auditKey string) bool {
+	if auditKey == "" {
+		return false
+	}
+	if key == auditKey {
+		return true
+	}
+
+	dir, name := path.Split(auditKey)
+	stem, extension := name, ""
+	if i := strings.Index(name, "."); i > 0 {
+		stem, extension = name[:i], name[i:]
+	}
+	rolled := regexp.QuoteMeta(dir+stem) + `_\d{8}T\d{6}` + regexp.QuoteMeta(extension)
+	return regexp.MustCompile("^" + rolled + "$").MatchString(key)
+}
@@ -0,0 +1,221 @@
+package rotate
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/rpolicy"
+	"s3backup/s3client"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// TagCache is the local file the tags fetched by a tag filtered rotation are cached in. The tags of an object are
+// reused while its ETag is unchanged and they were fetched within the TTL of the policy. Tags changed without
+// changing the object, e.g. with PutObjectTagging, are only seen once the TTL has elapsed
+type TagCache struct {
+	Bucket  string                   `json:"bucket"`
+	Entries map[string]TagCacheEntry `json:"entries"` // The cached tags of each object by key
+}
+
+// TagCacheEntry is the tags of an object as they were when the object had the ETag
+type TagCacheEntry struct {
+	ETag      string            `json:"etag"`
+	Tags      map[string]string `json:"tags"`
+	FetchedAt time.Time         `json:"fetchedAt"`
+}
+
+// Filters keys by their tags. The tags of up to the tag fetch concurrency of the policy are fetched at once, and the
+// tags of an object are only fetched once during a rotation as rotation lists each prefix again after deleting keys
+type tagFilter struct {
+	svc         *s3.S3
+	bucket      string
+	tags        map[string]string
+	concurrency int
+	cachePath   string
+	ttl         time.Duration
+	started     time.Time // Tags fetched since the filter was created are reused regardless of the TTL
+
+	mu    sync.Mutex
+	cache TagCache
+}
+
+// Returns the tag filter of the policy with the tags cached in its tag cache file, or nil if the policy does not filter
+// by tags. A cache file which does not exist or records another bucket starts an empty cache
+func newTagFilter(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy) (*tagFilter, error) {
+	if len(policy.TagFilter) == 0 {
+		return nil, nil
+	}
+
+	f := &tagFilter{
+		svc:         svc,
+		bucket:      bucket,
+		tags:        policy.TagFilter,
+		concurrency: policy.TagFetchConcurrency,
+		cachePath:   policy.TagCacheFile,
+		ttl:         policy.TagCacheTTL,
+		started:     time.Now(),
+		cache:       TagCache{Bucket: bucket, Entries: make(map[string]TagCacheEntry)},
+	}
+	if f.concurrency < 1 {
+		f.concurrency = 1
+	}
+
+	if f.cachePath == "" {
+		return f, nil
+	}
+	contents, err := ioutil.ReadFile(f.cachePath)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read tag cache '%s': %v", f.cachePath, err)
+	}
+
+	var cache TagCache
+	if err = json.Unmarshal(contents, &cache); err != nil {
+		return nil, fmt.Errorf("failed to parse tag cache '%s': %v", f.cachePath, err)
+	}
+	if cache.Bucket != bucket {
+		log.Warn.Printf("Ignoring tag cache '%s' as it records the tags of bucket '%s'\n", f.cachePath, cache.Bucket)
+		return f, nil
+	}
+	if cache.Entries != nil {
+		f.cache.Entries = cache.Entries
+	}
+	log.Info.Printf("Loaded the tags of %d objects from tag cache '%s'\n", len(f.cache.Entries), f.cachePath)
+	return f, nil
+}
+
+// Returns the keys which have every tag in the tag filter. The order of the keys is preserved
+func (f *tagFilter) filter(keys []s3client.BucketEntry) ([]s3client.BucketEntry, error) {
+	etags, err := f.listETags(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]bool, len(keys))
+	slots := make(chan struct{}, f.concurrency)
+	var wg sync.WaitGroup
+	var fetchErr error
+
+	for i, kv := range keys {
+		if tags, ok := f.cached(kv.Key, etags[kv.Key]); ok {
+			matched[i] = matchesTags(tags, f.tags)
+			continue
+		}
+
+		f.mu.Lock()
+		failed := fetchErr != nil
+		f.mu.Unlock()
+		if failed {
+			break
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			tags, err := s3client.GetObjectTags(f.svc, f.bucket, key)
+
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if err != nil {
+				if fetchErr == nil {
+					fetchErr = fmt.Errorf("failed to get the tags of key '%s': %v", key, err)
+				}
+				return
+			}
+			matched[i] = matchesTags(tags, f.tags)
+			f.cache.Entries[key] = TagCacheEntry{ETag: etags[key], Tags: tags, FetchedAt: time.Now().UTC()}
+		}(i, kv.Key)
+	}
+	wg.Wait()
+
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	filteredKeys := []s3client.BucketEntry{}
+	for i, kv := range keys {
+		if matched[i] {
+			filteredKeys = append(filteredKeys, kv)
+		} else {
+			log.Info.Printf("Ignoring key: '%s' as it does not match the tag filter\n", kv.Key)
+		}
+	}
+	return filteredKeys, nil
+}
+
+// Returns the cached tags of the key if they were cached with the ETag and are still within the TTL
+func (f *tagFilter) cached(key string, etag string) (map[string]string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entry, ok := f.cache.Entries[key]
+	if !ok || etag == "" || entry.ETag != etag {
+		return nil, false
+	}
+	if entry.FetchedAt.Before(f.started) && time.Since(entry.FetchedAt) > f.ttl {
+		return nil, false
+	}
+	return entry.Tags, true
+}
+
+// Returns the ETag of each key by listing the longest prefix every key shares, so the ETags of the keys of a tier are
+// listed by a single paginated listing rather than a request for each key
+func (f *tagFilter) listETags(keys []s3client.BucketEntry) (map[string]string, error) {
+	etags := make(map[string]string)
+	if len(keys) == 0 {
+		return etags, nil
+	}
+
+	prefix := keys[0].Key
+	for _, kv := range keys[1:] {
+		i := 0
+		for i < len(prefix) && i < len(kv.Key) && prefix[i] == kv.Key[i] {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+
+	listing, err := s3client.ListByDelimiter(f.svc, f.bucket, prefix, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the ETags of the keys under '%s': %v", prefix, err)
+	}
+	for _, obj := range listing.Objects {
+		etags[obj.Key] = obj.ETag
+	}
+	return etags, nil
+}
+
+// Writes the tags cached by the filter to its tag cache file without the tags which have expired. Nothing is written if
+// the filter has no tag cache file
+func (f *tagFilter) save() error {
+	if f == nil || f.cachePath == "" {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, entry := range f.cache.Entries {
+		if entry.FetchedAt.Before(f.started) && time.Since(entry.FetchedAt) > f.ttl {
+			delete(f.cache.Entries, key)
+		}
+	}
+
+	contents, err := json.MarshalIndent(f.cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(f.cachePath, contents, 0644); err != nil {
+		return fmt.Errorf("failed to write tag cache '%s': %v", f.cachePath, err)
+	}
+	log.Info.Printf("Cached the tags of %d objects in tag cache '%s'\n", len(f.cache.Entries), f.cachePath)
+	return nil
+}
@@ -0,0 +1,83 @@
+package rotate
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"s3backup/rpolicy"
+	"s3backup/s3client"
+	"s3backup/util"
+	"strings"
+	"time"
+)
+
+// What happens to keys in a rotation tier which do not end with the timestamp s3backup appends to the key of a backup,
+// e.g. objects uploaded manually or by another tool
+const (
+	UnparseableKeysIgnore       = "ignore"       // The keys are never rotated and do not count towards the retention count
+	UnparseableKeysLastModified = "lastmodified" // The keys are rotated along with the backups by their last modified time
+)
+
+// The parser of the timestamp appended to the key of a backup with the default key time layout
+var defaultKeyTimeParser, _ = util.NewKeyTimeParser(util.DefaultKeyTimeLayout, nil)
+
+// ParseKeyTime returns the timestamp appended to the key of a backup by s3backup with the default key time layout,
+// optionally followed by the extension of a compressed object or archive, e.g. daily_portfolioAlbum_20240131T020000.zst.
+// Returns false if the key does not end with a valid timestamp
+func ParseKeyTime(key string) (time.Time, bool) {
+	return defaultKeyTimeParser(key)
+}
+
+// Orders the keys of a rotation tier by the timestamp at the end of each key in the key time layout and the location of
+// the policy, so that a backup whose last modified time changed when it was copied is still rotated in the order it was made, and
+// decides what happens to the keys which do not end with a timestamp
+type keyOrder struct {
+	layout          string
+	parse           s3client.KeyTimeParser
+	unparseableKeys string
+}
+
+// Returns the key order of the policy. Returns an error if its key time layout is invalid
+func newKeyOrder(policy rpolicy.RotationPolicy) (*keyOrder, error) {
+	layout := policy.KeyTimeLayout
+	if layout == "" {
+		layout = util.DefaultKeyTimeLayout
+	}
+	parse, err := util.NewKeyTimeParser(layout, policy.Location)
+	if err != nil {
+		return nil, err
+	}
+	return &keyOrder{layout: layout, parse: parse, unparseableKeys: policy.UnparseableKeys}, nil
+}
+
+// Returns every key of the prefix ordered by key time with the most recent key first. Keys which do not end with a
+// timestamp are ordered by their last modified time
+func (o *keyOrder) sortedKeys(svc *s3.S3, bucket string, prefix string, bucketDir string) ([]s3client.BucketEntry, error) {
+	return util.RetrieveSortedKeysByKeyTime(svc, bucket, prefix, bucketDir, o.parse)
+}
+
+// Returns the sorted keys without the keys which cannot be parsed unless they fall back to their last modified time.
+// Any value other than lastmodified ignores the keys so that an unexpected value can never cause a key to be deleted
+func (o *keyOrder) filter(sortedKeys []s3client.BucketEntry, prefix string) []s3client.BucketEntry {
+	lastModified := strings.ToLower(o.unparseableKeys) == UnparseableKeysLastModified
+
+	filtered := []s3client.BucketEntry{}
+	skipped := 0
+	for _, kv := range sortedKeys {
+		if _, ok := o.parse(kv.Key); ok {
+			filtered = append(filtered, kv)
+			continue
+		}
+		if lastModified {
+			log.Info.Printf("Key: '%s' does not end with a key timestamp, rotating it by its last modified time\n", kv.Key)
+			filtered = append(filtered, kv)
+			continue
+		}
+		log.Warn.Printf("Key: '%s' does not end with a key timestamp and is ignored by rotation\n", kv.Key)
+		skipped++
+	}
+
+	if skipped > 0 {
+		log.Warn.Printf("Skipped %d '%s' key(s) which do not end with a key timestamp. These keys are never deleted\n", skipped, prefix)
+	}
+	return filtered
+}
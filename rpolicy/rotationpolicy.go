@@ -10,6 +10,55 @@ type RotationPolicy struct {
 	WeeklyRetentionPeriod  time.Duration
 	WeeklyRetentionCount   int
 	WeeklyPrefix           string
+	MonthlyRetentionPeriod time.Duration
+	MonthlyRetentionCount  int // If 0 then monthly backups are never rotated and are kept until they are removed manually
 	MonthlyPrefix          string
+	YearlyRetentionPeriod  time.Duration
+	YearlyRetentionCount   int
+	YearlyPrefix           string // If empty then there is no yearly tier and a backup on January 1st is monthly
 	EnforceRetentionPeriod bool
+
+	TagFilter       map[string]string // If set then only objects with every tag are rotated, all other objects are ignored
+	UnparseableKeys string            // What happens to keys which do not end with a key timestamp [ignore|lastmodified]. Defaults to ignore
+	KeyTimeLayout   string            // The Go time layout of the timestamp at the end of each key which keys are ordered by. Defaults to 20060102T150405
+
+	TagFetchConcurrency int           // Number of objects whose tags are fetched at once when filtering by tags. 0 fetches them one at a time
+	TagCacheFile        string        // Local file the tags of objects are cached in by key and ETag so that later rotations reuse them
+	TagCacheTTL         time.Duration // Time the cached tags of an object are reused before they are fetched again
+
+	ForceTier string         // If set then every backup is classified into this tier prefix regardless of its date
+	Location  *time.Location // The time zone the date of a backup is classified and the timestamp of its key is parsed in. Nil uses the local time zone
+
+	MinExpectedObjects int // Rotation fails if fewer backups than this are stored under the bucket dir. 0 disables the check
+	MinKeep            int // The newest backups of each tier which are always kept even if the retention count is lower. 0 disables the floor
+
+	DeleteConfirmAttempts int           // Times a deleted key is checked with HeadObject until it is no longer found. 0 disables the check
+	DeleteConfirmInterval time.Duration // Time between each check of a deleted key
+
+	RestoreLockWait         time.Duration // Time rotation waits for restores holding a lock under the bucket dir to finish before it is skipped
+	RestoreLockPollInterval time.Duration // Time between each check of the restore locks while waiting
+
+	Timeout time.Duration // Rotation stops deleting keys once this has elapsed, the remaining keys are deleted by the next rotation. 0 disables the timeout
+
+	WriteRotationAudit bool   // Write an audit object recording every deleted key after each rotation
+	AuditKey           string // The key of the audit object
+	CompressAudit      bool   // Store the audit as a gzip compressed newline delimited JSON history
+	AuditMaxBytes      int64  // Roll the audit over to a new object once it would exceed this many bytes. 0 disables rollover
+
+	IndexKey       string // If set then every key deleted by rotation is removed from the backup index stored under this key
+	LastSuccessKey string // The key of the marker each successful backup writes its time to. It is never rotated or a stray
+
+	ConfirmedKeys map[string]bool // If set then rotation only deletes these keys, e.g. the keys of a plan confirmed by the operator
+}
+
+// Prefixes returns the prefix of every tier of the policy in the order daily, weekly, monthly and yearly. A tier
+// without a prefix, e.g. the yearly tier of a policy without one, is omitted
+func (policy RotationPolicy) Prefixes() []string {
+	prefixes := []string{}
+	for _, prefix := range []string{policy.DailyPrefix, policy.WeeklyPrefix, policy.MonthlyPrefix, policy.YearlyPrefix} {
+		if prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
 }
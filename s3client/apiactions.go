@@ -1,13 +1,20 @@
 package s3client
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
 	"sort"
 	"time"
 )
 
+// MaxCopyObjectSize is the largest object which can be copied with a single CopyObject request
+const MaxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
 // BucketEntry represents an object which exists in S3
 type BucketEntry struct {
 	Key          string
@@ -17,16 +24,54 @@ type BucketEntry struct {
 // SortKeysByTime sorts the bucket keys by the last modified time
 // and Returns a bucket entry array with the newest values first
 func SortKeysByTime(keys map[string]time.Time) []BucketEntry {
-	var sortedBucketEntry []BucketEntry
+	return SortKeysByKeyTime(keys, nil)
+}
+
+// KeyTimeParser returns the time embedded in a key. Returns false if the key does not embed a time
+type KeyTimeParser func(key string) (time.Time, bool)
+
+// SortKeysByKeyTime sorts the bucket keys which embed a time by the time the parser finds in each key and returns a
+// bucket entry array with the newest values first. The time in a key does not change when the object is copied, e.g.
+// by a migration or a provider replicating it. Keys which do not embed a time are sorted by their last modified time
+// and placed before the first key which embeds a time that was last modified before them. The modified time of each
+// entry is still its last modified time. Keys are sorted by their last modified time if the parser is nil
+func SortKeysByKeyTime(keys map[string]time.Time, parse KeyTimeParser) []BucketEntry {
+	var timedEntries, untimedEntries []BucketEntry
+	keyTimes := make(map[string]time.Time)
 	for k, v := range keys {
-		sortedBucketEntry = append(sortedBucketEntry, BucketEntry{k, v})
+		if parse != nil {
+			if keyTime, ok := parse(k); ok {
+				keyTimes[k] = keyTime
+				timedEntries = append(timedEntries, BucketEntry{k, v})
+				continue
+			}
+		}
+		untimedEntries = append(untimedEntries, BucketEntry{k, v})
 	}
 
-	sort.Slice(sortedBucketEntry, func(i, j int) bool {
-		return sortedBucketEntry[i].ModifiedTime.After(sortedBucketEntry[j].ModifiedTime)
+	sort.Slice(timedEntries, func(i, j int) bool {
+		ti, tj := keyTimes[timedEntries[i].Key], keyTimes[timedEntries[j].Key]
+		if ti.Equal(tj) {
+			return timedEntries[i].ModifiedTime.After(timedEntries[j].ModifiedTime)
+		}
+		return ti.After(tj)
+	})
+	sort.Slice(untimedEntries, func(i, j int) bool {
+		return untimedEntries[i].ModifiedTime.After(untimedEntries[j].ModifiedTime)
 	})
 
-	return sortedBucketEntry
+	var sortedBucketEntry []BucketEntry
+	for len(timedEntries) > 0 && len(untimedEntries) > 0 {
+		if untimedEntries[0].ModifiedTime.After(timedEntries[0].ModifiedTime) {
+			sortedBucketEntry = append(sortedBucketEntry, untimedEntries[0])
+			untimedEntries = untimedEntries[1:]
+		} else {
+			sortedBucketEntry = append(sortedBucketEntry, timedEntries[0])
+			timedEntries = timedEntries[1:]
+		}
+	}
+	sortedBucketEntry = append(sortedBucketEntry, timedEntries...)
+	return append(sortedBucketEntry, untimedEntries...)
 }
 
 // GetKeysByPrefix returns a map of keys in the bucket along with the LastModified attribute
@@ -143,3 +188,194 @@ func GetBucketContents(svc *s3.S3, bucket string) (*s3.ListObjectsOutput, error)
 	}
 	return result, nil
 }
+
+// Listing is the result of listing a prefix grouped by a delimiter
+type Listing struct {
+	CommonPrefixes []string       `json:"commonPrefixes"` // Prefixes of keys which contain the delimiter after the listed prefix, i.e. folders
+	Objects        []ListedObject `json:"objects"`        // Objects directly under the listed prefix
+}
+
+// ListedObject represents an object returned in a listing
+type ListedObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ModifiedTime time.Time `json:"lastModified"`
+	ETag         string    `json:"etag"`
+}
+
+// ListByDelimiter lists the prefix with ListObjectsV2, paginating through every page. Keys which contain the delimiter
+// after the prefix are rolled up into a common prefix in the same way the S3 console displays folders.
+// Every key under the prefix is listed as an object if the delimiter is empty
+func ListByDelimiter(svc *s3.S3, bucket string, prefix string, delimiter string) (Listing, error) {
+	listing := Listing{CommonPrefixes: []string{}, Objects: []ListedObject{}}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
+
+	err := svc.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, commonPrefix := range page.CommonPrefixes {
+			listing.CommonPrefixes = append(listing.CommonPrefixes, aws.StringValue(commonPrefix.Prefix))
+		}
+		for _, obj := range page.Contents {
+			listing.Objects = append(listing.Objects, ListedObject{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				ModifiedTime: aws.TimeValue(obj.LastModified),
+				ETag:         aws.StringValue(obj.ETag),
+			})
+		}
+		return true
+	})
+
+	return listing, err
+}
+
+// GetLegalHold returns true if the object has an active legal hold.
+// Objects in buckets without an object lock configuration are never held
+func GetLegalHold(svc *s3.S3, bucket string, key string) (bool, error) {
+	resp, err := svc.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case "NoSuchObjectLockConfiguration", "InvalidRequest", "NotImplemented":
+				return false, nil
+			}
+		}
+		return false, err
+	}
+
+	if resp.LegalHold == nil {
+		return false, nil
+	}
+
+	return aws.StringValue(resp.LegalHold.Status) == s3.ObjectLockLegalHoldStatusOn, nil
+}
+
+// SetLegalHold places or removes the legal hold on an object
+func SetLegalHold(svc *s3.S3, bucket string, key string, hold bool) error {
+	status := s3.ObjectLockLegalHoldStatusOff
+	if hold {
+		status = s3.ObjectLockLegalHoldStatusOn
+	}
+
+	_, err := svc.PutObjectLegalHold(&s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		LegalHold: &s3.ObjectLockLegalHold{Status: aws.String(status)},
+	})
+
+	return err
+}
+
+// GetObjectTags returns the tags of the object as a map of tag key to value
+func GetObjectTags(svc *s3.S3, bucket string, key string) (map[string]string, error) {
+	resp, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range resp.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags, nil
+}
+
+// GetObjectBody returns the contents of the object. If the object does not exist then nil is returned without an error
+func GetObjectBody(svc *s3.S3, bucket string, key string) ([]byte, error) {
+	resp, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// PutObjectBody writes the contents to the object with the specified content type
+func PutObjectBody(svc *s3.S3, bucket string, key string, body []byte, contentType string) error {
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+// WaitForObject polls the object with HeadObject until it is present in the bucket and, if replication is required,
+// until its replication status is COMPLETED. Returns an error if the object is not confirmed before the timeout elapses
+func WaitForObject(svc *s3.S3, bucket string, key string, requireReplication bool, pollInterval time.Duration, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		resp, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+
+		if err == nil && !requireReplication {
+			return nil
+		}
+
+		if err == nil {
+			replicationStatus := aws.StringValue(resp.ReplicationStatus)
+			if replicationStatus == s3.ReplicationStatusCompleted {
+				return nil
+			}
+			if replicationStatus == s3.ReplicationStatusFailed {
+				return errors.New("replication of key '" + key + "' failed")
+			}
+			err = errors.New("replication status of key '" + key + "' is '" + replicationStatus + "'")
+		} else if aerr, ok := err.(awserr.Error); !ok || (aerr.Code() != "NotFound" && aerr.Code() != s3.ErrCodeNoSuchKey) {
+			return err // Only keep polling while the object has not yet appeared
+		}
+
+		if time.Now().Add(pollInterval).After(deadline) {
+			return errors.New("timed out waiting for key '" + key + "' to be confirmed: " + err.Error())
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+// WaitForObjectDeleted polls the deleted object with HeadObject until it is no longer found. Some providers continue
+// to return a deleted object briefly. Returns an error if the object is still found after the number of attempts
+func WaitForObjectDeleted(svc *s3.S3, bucket string, key string, attempts int, pollInterval time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		_, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+				return nil
+			}
+			return err
+		}
+
+		if attempt >= attempts {
+			return fmt.Errorf("key '%s' was still found by %d checks after it was deleted", key, attempts)
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
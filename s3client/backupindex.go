@@ -0,0 +1,131 @@
+package s3client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sort"
+	"time"
+)
+
+// BackupIndexVersion is the version of the index format written by AddToBackupIndex
+const BackupIndexVersion = 1
+
+// BackupIndex lists every backup under a bucket dir so that the backups can be found by reading the index alone rather
+// than listing the bucket. The index is read, updated and written again by each backup and rotation, so runs which
+// update the same index must not overlap
+type BackupIndex struct {
+	Version   int          `json:"version"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+	Backups   []IndexEntry `json:"backups"` // Ordered with the most recent backup first
+}
+
+// IndexEntry is a single backup listed in the index
+type IndexEntry struct {
+	Key       string    `json:"key"`
+	Tier      string    `json:"tier"` // The rotation tier of the key without the trailing underscore, e.g. daily
+	Size      int64     `json:"size"`
+	Timestamp time.Time `json:"timestamp"` // The last modified time of the backup
+}
+
+// GetBackupIndex returns the index stored under the key. Returns false if there is no index
+func GetBackupIndex(svc *s3.S3, bucket string, indexKey string) (BackupIndex, bool, error) {
+	index := BackupIndex{Version: BackupIndexVersion, Backups: []IndexEntry{}}
+
+	body, err := GetObjectBody(svc, bucket, indexKey)
+	if err != nil {
+		return index, false, fmt.Errorf("failed to read backup index '%s': %v", indexKey, err)
+	}
+	if body == nil {
+		return index, false, nil
+	}
+
+	if err = json.Unmarshal(body, &index); err != nil {
+		return index, false, fmt.Errorf("failed to parse backup index '%s': %v", indexKey, err)
+	}
+	if index.Version != BackupIndexVersion {
+		return index, false, fmt.Errorf("unsupported backup index version: %d", index.Version)
+	}
+	return index, true, nil
+}
+
+// AddToBackupIndex adds the uploaded backup to the index with the size and last modified time it is stored with. An
+// entry already listed for the key is replaced. The index is created if there is none
+func AddToBackupIndex(svc *s3.S3, bucket string, indexKey string, key string, tier string) (IndexEntry, error) {
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return IndexEntry{}, fmt.Errorf("failed to retrieve the size of key '%s': %v", key, err)
+	}
+
+	entry := IndexEntry{
+		Key:       key,
+		Tier:      tier,
+		Size:      aws.Int64Value(head.ContentLength),
+		Timestamp: aws.TimeValue(head.LastModified).UTC(),
+	}
+
+	index, _, err := GetBackupIndex(svc, bucket, indexKey)
+	if err != nil {
+		return entry, err
+	}
+
+	backups := []IndexEntry{entry}
+	for _, backup := range index.Backups {
+		if backup.Key != key {
+			backups = append(backups, backup)
+		}
+	}
+	index.Backups = backups
+
+	return entry, putBackupIndex(svc, bucket, indexKey, index)
+}
+
+// RemoveFromBackupIndex removes the deleted keys from the index. Returns the number of entries removed, the index is
+// left unchanged if none of the keys are listed in it
+func RemoveFromBackupIndex(svc *s3.S3, bucket string, indexKey string, deletedKeys []string) (int, error) {
+	index, found, err := GetBackupIndex(svc, bucket, indexKey)
+	if err != nil || !found {
+		return 0, err
+	}
+
+	deleted := make(map[string]bool)
+	for _, key := range deletedKeys {
+		deleted[key] = true
+	}
+
+	backups := []IndexEntry{}
+	for _, backup := range index.Backups {
+		if !deleted[backup.Key] {
+			backups = append(backups, backup)
+		}
+	}
+
+	removed := len(index.Backups) - len(backups)
+	if removed == 0 {
+		return 0, nil
+	}
+	index.Backups = backups
+
+	return removed, putBackupIndex(svc, bucket, indexKey, index)
+}
+
+// Writes the index to the key with the most recent backup first
+func putBackupIndex(svc *s3.S3, bucket string, indexKey string, index BackupIndex) error {
+	sort.SliceStable(index.Backups, func(i, j int) bool { return index.Backups[i].Timestamp.After(index.Backups[j].Timestamp) })
+	index.Version = BackupIndexVersion
+	index.UpdatedAt = time.Now().UTC()
+
+	body, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = PutObjectBody(svc, bucket, indexKey, body, "application/json"); err != nil {
+		return fmt.Errorf("failed to write backup index '%s': %v", indexKey, err)
+	}
+	return nil
+}
@@ -2,17 +2,34 @@ package s3client
 
 import (
 	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"s3backup/log"
+	"s3backup/version"
 	"os"
+	"time"
 )
 
+// The time before assumed role credentials expire in which they are refreshed
+const assumeRoleExpiryWindow = time.Minute * 5
+
 // CreateS3Client creates an S3 client using environment variables if present; else AWS creds file
 // 2. Use the specified credential file
-func CreateS3Client(credFile string, profile string, region string, endpoint string) (*s3.S3, error) {
+// 3. Use the EC2 instance profile or ECS task role if the credential file cannot provide credentials
+// If use instance role is enabled then the credential file is not used and the role must provide credentials
+// If a role ARN is specified then the role is assumed with these credentials, e.g. to back up into a bucket of another account
+// If the endpoint is an AWS endpoint then it is resolved from the partition which is derived from the region unless specified
+// Service endpoints route individual AWS services, e.g. s3, sts, kms, to their own endpoint and take precedence over the endpoint
+// If accelerate is enabled then S3 requests are sent to the S3 Transfer Acceleration endpoint, which is only available on AWS
+func CreateS3Client(credFile string, profile string, useInstanceRole bool, roleARN string, roleSessionName string, region string, endpoint string, partition string, serviceEndpoints map[string]string, accelerate bool) (*s3.S3, error) {
 	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
 	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
 
@@ -33,14 +50,239 @@ func CreateS3Client(credFile string, profile string, region string, endpoint str
 		creds = credentials.NewEnvCredentials()
 	}
 
+	if creds == nil && useInstanceRole {
+		log.Info.Println("Attempting to create S3 client with the instance role credentials")
+		creds = credentials.NewCredentials(defaults.RemoteCredProvider(*session.Config, session.Handlers))
+		if _, err := creds.Get(); err != nil {
+			return nil, fmt.Errorf("failed to retrieve credentials from the instance role: %v", err)
+		}
+	}
+
 	if creds == nil {
 		log.Info.Printf("Attempting to create S3 client with specified credential file and profile: [%s | %s]\n", credFile, profile)
 		creds = credentials.NewSharedCredentials(credFile, profile)
+		if _, err := creds.Get(); err != nil {
+			// Retrieved on the first request so that a role which cannot provide credentials fails that request
+			log.Info.Printf("Falling back to the instance role credentials as the credential file cannot be used: %v\n", err)
+			creds = credentials.NewCredentials(defaults.RemoteCredProvider(*session.Config, session.Handlers))
+		}
 	}
 
 	if creds == nil {
 		return nil, errors.New("failed to retrieve S3 client access key id and access key secret")
 	}
 
-	return s3.New(session, &aws.Config{Region: aws.String(region), Credentials: creds, Endpoint: aws.String(endpoint)}), nil
+	config, err := newConfig(region, endpoint, partition, serviceEndpoints)
+	if err != nil {
+		return nil, err
+	}
+	config.Credentials = creds
+
+	if roleARN != "" {
+		log.Info.Printf("Assuming role: '%s'\n", roleARN)
+		config.Credentials = assumeRole(session, config, creds, roleARN, roleSessionName)
+		if _, err = config.Credentials.Get(); err != nil {
+			return nil, fmt.Errorf("failed to assume role '%s': %v", roleARN, err)
+		}
+	}
+
+	if accelerate {
+		if err = accelerateValidationCheck(region, endpoint, partition, serviceEndpoints); err != nil {
+			return nil, err
+		}
+		log.Info.Println("Sending S3 requests to the S3 Transfer Acceleration endpoint")
+		config.S3UseAccelerate = aws.Bool(true)
+	}
+
+	svc := s3.New(session, config)
+	svc.Handlers.Build.PushBack(request.MakeAddToUserAgentHandler(version.Name, version.Version))
+
+	return svc, nil
+}
+
+// Returns the credentials of the role assumed with the base credentials. STS is called with the endpoints of the
+// configuration. The assumed credentials expire after the default duration of STS and are refreshed by assuming the
+// role again within the expiry window before they expire, so that a long running upload signs every request with
+// credentials which are still valid
+func assumeRole(sess *session.Session, config *aws.Config, base *credentials.Credentials, roleARN string, roleSessionName string) *credentials.Credentials {
+	stsConfig := config.Copy()
+	stsConfig.Credentials = base
+
+	if roleSessionName == "" {
+		roleSessionName = version.Name
+	}
+
+	return stscreds.NewCredentialsWithClient(sts.New(sess, stsConfig), roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = roleSessionName
+		p.ExpiryWindow = assumeRoleExpiryWindow
+	})
+}
+
+// ResolvePartition returns the AWS partition (aws, aws-cn, aws-us-gov, etc.) for the region.
+// If a partition is specified then the region must belong to it
+func ResolvePartition(partition string, region string) (endpoints.Partition, error) {
+	regionPartition, found := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
+
+	if partition == "" {
+		if !found {
+			return endpoints.Partition{}, fmt.Errorf("unable to derive AWS partition from region '%s', the partition must be specified", region)
+		}
+		return regionPartition, nil
+	}
+
+	for _, p := range endpoints.DefaultPartitions() {
+		if p.ID() != partition {
+			continue
+		}
+		if found && regionPartition.ID() != partition {
+			return endpoints.Partition{}, fmt.Errorf("region '%s' belongs to partition '%s' not '%s'", region, regionPartition.ID(), partition)
+		}
+		return p, nil
+	}
+
+	return endpoints.Partition{}, fmt.Errorf("unknown AWS partition specified: '%s'", partition)
+}
+
+// ResolveEndpoint returns the endpoint URL of the AWS service for the region within the partition
+func ResolveEndpoint(service string, region string, partition string) (string, error) {
+	p, err := ResolvePartition(partition, region)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := p.EndpointFor(service, region)
+	if err != nil {
+		return "", err
+	}
+
+	return resolved.URL, nil
+}
+
+// Builds the client configuration for the region. Unless an explicit endpoint has been specified, AWS endpoints are
+// resolved by the partition so that every AWS service client created from this configuration is routed to the same partition.
+// Any service endpoints are resolved first by wrapping the resolver of the configuration
+func newConfig(region string, endpoint string, partition string, serviceEndpoints map[string]string) (*aws.Config, error) {
+	config, err := newPartitionConfig(region, endpoint, partition)
+	if err != nil || len(serviceEndpoints) == 0 {
+		return config, err
+	}
+
+	for service, serviceEndpoint := range serviceEndpoints {
+		if !isKnownService(service) {
+			return nil, fmt.Errorf("unknown AWS service specified for endpoint '%s': '%s'", serviceEndpoint, service)
+		}
+		log.Info.Printf("Routing AWS service '%s' to endpoint: '%s'\n", service, serviceEndpoint)
+	}
+
+	var fallback endpoints.Resolver = endpoints.DefaultResolver()
+	if config.EndpointResolver != nil {
+		fallback = config.EndpointResolver
+	}
+
+	// An explicit endpoint would override the resolver for every service so it is only used for S3 unless it is routed elsewhere
+	if config.Endpoint != nil {
+		overrides := map[string]string{s3.EndpointsID: aws.StringValue(config.Endpoint)}
+		for service, serviceEndpoint := range serviceEndpoints {
+			overrides[service] = serviceEndpoint
+		}
+		serviceEndpoints = overrides
+		config.Endpoint = nil
+	}
+
+	config.EndpointResolver = ServiceEndpointResolver(serviceEndpoints, fallback)
+	return config, nil
+}
+
+// ServiceEndpointResolver returns a resolver which routes each AWS service to its endpoint, keyed by the endpoints ID of
+// the service e.g. s3, sts, kms. Services without an endpoint are resolved by the fallback resolver
+func ServiceEndpointResolver(serviceEndpoints map[string]string, fallback endpoints.Resolver) endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service string, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		serviceEndpoint, ok := serviceEndpoints[service]
+		if !ok {
+			return fallback.EndpointFor(service, region, opts...)
+		}
+		return endpoints.ResolvedEndpoint{
+			URL:           endpoints.AddScheme(serviceEndpoint, false),
+			SigningRegion: region,
+			SigningMethod: "v4",
+		}, nil
+	})
+}
+
+// Builds the client configuration for the region with the endpoint or the resolver of the partition
+func newPartitionConfig(region string, endpoint string, partition string) (*aws.Config, error) {
+	config := &aws.Config{Region: aws.String(region)}
+
+	if region == "" && partition == "" {
+		// Without a region the partition cannot be derived so leave endpoint resolution to the SDK
+		if endpoint != "" && !isPartitionDNSSuffix(endpoint) {
+			config.Endpoint = aws.String(endpoint)
+		}
+		return config, nil
+	}
+
+	if !isPartitionDNSSuffix(endpoint) {
+		if partition != "" {
+			log.Warn.Printf("Ignoring partition '%s' as an explicit endpoint has been specified: '%s'\n", partition, endpoint)
+		}
+		config.Endpoint = aws.String(endpoint)
+		return config, nil
+	}
+
+	p, err := ResolvePartition(partition, region)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info.Printf("Resolving AWS endpoints for region '%s' in partition '%s'\n", region, p.ID())
+	config.EndpointResolver = p
+
+	return config, nil
+}
+
+// Transfer acceleration is a feature of the AWS commercial partition so it cannot be used with other S3 compatible providers
+func accelerateValidationCheck(region string, endpoint string, partition string, serviceEndpoints map[string]string) error {
+	if !isPartitionDNSSuffix(endpoint) {
+		return fmt.Errorf("transfer acceleration is only supported with AWS endpoints, not '%s'", endpoint)
+	}
+
+	if _, ok := serviceEndpoints[s3.EndpointsID]; ok {
+		return errors.New("transfer acceleration is only supported with AWS endpoints, not a service endpoint for s3")
+	}
+
+	if region != "" || partition != "" {
+		p, err := ResolvePartition(partition, region)
+		if err != nil {
+			return err
+		}
+		if p.ID() != endpoints.AwsPartitionID {
+			return fmt.Errorf("transfer acceleration is not available in partition '%s'", p.ID())
+		}
+	}
+
+	return nil
+}
+
+// Returns true if the service is an AWS service in any partition
+func isKnownService(service string) bool {
+	for _, p := range endpoints.DefaultPartitions() {
+		if _, ok := p.Services()[service]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the endpoint is empty or is only the DNS suffix of an AWS partition (amazonaws.com, amazonaws.com.cn, etc.)
+func isPartitionDNSSuffix(endpoint string) bool {
+	if endpoint == "" {
+		return true
+	}
+
+	for _, p := range endpoints.DefaultPartitions() {
+		if endpoint == p.DNSSuffix() {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,790 @@
+package s3client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"s3backup/log"
+	"s3backup/s3mock"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Setup testing
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+}
+
+//----------------------------------------------
+//
+//             Client Creation Tests
+//
+//----------------------------------------------
+
+// The partition should be derived from a China region and resolve to the amazonaws.com.cn DNS suffix
+func TestResolveEndpointChinaRegion(t *testing.T) {
+	endpoint, err := ResolveEndpoint(s3.EndpointsID, "cn-north-1", "")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to resolve endpoint without any error: %v", err))
+	}
+
+	if !strings.HasSuffix(endpoint, ".amazonaws.com.cn") {
+		t.Error("expected endpoint to be in the amazonaws.com.cn partition: " + endpoint)
+	}
+}
+
+func TestResolvePartitionGovCloud(t *testing.T) {
+	p, err := ResolvePartition("aws-us-gov", "us-gov-west-1")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to resolve partition without any error: %v", err))
+	}
+
+	if p.ID() != "aws-us-gov" {
+		t.Error("expected partition to be aws-us-gov: " + p.ID())
+	}
+}
+
+func TestResolvePartitionRegionMismatch(t *testing.T) {
+	_, err := ResolvePartition("aws-cn", "us-east-1")
+	if err == nil {
+		t.Error("expected an error when the region does not belong to the specified partition")
+	}
+}
+
+func TestResolvePartitionUnknown(t *testing.T) {
+	_, err := ResolvePartition("aws-moon", "us-east-1")
+	if err == nil {
+		t.Error("expected an error when an unknown partition is specified")
+	}
+}
+
+func TestCreateS3ClientChinaRegion(t *testing.T) {
+	svc, err := CreateS3Client("", "default", false, "", "", "cn-north-1", "amazonaws.com", "", nil, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create client without any error: %v", err))
+	}
+
+	if !strings.HasSuffix(svc.Endpoint, ".amazonaws.com.cn") {
+		t.Error("expected client endpoint to be in the amazonaws.com.cn partition: " + svc.Endpoint)
+	}
+}
+
+func TestCreateS3ClientCustomEndpoint(t *testing.T) {
+	svc, err := CreateS3Client("", "default", false, "", "", "ru-central1", "https://storage.yandexcloud.net", "", nil, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create client without any error: %v", err))
+	}
+
+	if svc.Endpoint != "https://storage.yandexcloud.net" {
+		t.Error("expected client to use the specified endpoint: " + svc.Endpoint)
+	}
+}
+
+// Transfer acceleration should be enabled on the client and S3 requests sent to the accelerate endpoint
+func TestCreateS3ClientAccelerate(t *testing.T) {
+	svc, err := CreateS3Client("", "default", false, "", "", "us-east-1", "amazonaws.com", "", nil, true)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create client without any error: %v", err))
+	}
+
+	if !aws.BoolValue(svc.Config.S3UseAccelerate) {
+		t.Error("expected transfer acceleration to be enabled on the client config")
+	}
+
+	req, _ := svc.HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String("mybucket")})
+	if err = req.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if req.HTTPRequest.URL.Host != "mybucket.s3-accelerate.amazonaws.com" {
+		t.Error("expected the request to be sent to the accelerate endpoint: " + req.HTTPRequest.URL.Host)
+	}
+}
+
+// Transfer acceleration should be rejected for S3 compatible providers and partitions other than aws
+func TestCreateS3ClientAccelerateRejected(t *testing.T) {
+	rejected := map[string]func() error{
+		"transfer acceleration is only supported with AWS endpoints": func() error {
+			_, err := CreateS3Client("", "default", false, "", "", "ru-central1", "https://storage.yandexcloud.net", "", nil, true)
+			return err
+		},
+		"not a service endpoint for s3": func() error {
+			_, err := CreateS3Client("", "default", false, "", "", "us-east-1", "amazonaws.com", "", map[string]string{"s3": "https://gateway:9000"}, true)
+			return err
+		},
+		"not available in partition 'aws-cn'": func() error {
+			_, err := CreateS3Client("", "default", false, "", "", "cn-north-1", "amazonaws.com", "", nil, true)
+			return err
+		},
+	}
+
+	for expectedErrString, create := range rejected {
+		err := create()
+		if err != nil && strings.Contains(err.Error(), expectedErrString) {
+			// Pass
+		} else {
+			t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+		}
+	}
+}
+
+// Service endpoints should take precedence over the endpoint for the services they are specified for
+func TestCreateS3ClientServiceEndpoints(t *testing.T) {
+	svc, err := CreateS3Client("", "default", false, "", "", "us-east-1", "https://storage.yandexcloud.net", "",
+		map[string]string{"s3": "https://s3.gateway.internal:9000"}, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create client without any error: %v", err))
+	}
+
+	if svc.Endpoint != "https://s3.gateway.internal:9000" {
+		t.Error("expected client to use the S3 service endpoint: " + svc.Endpoint)
+	}
+}
+
+// S3 and STS requests should be routed to their own endpoints and every other service resolved by the partition
+func TestServiceEndpointsRouteRequests(t *testing.T) {
+	s3Gateway, s3Requests := newRecordingServer()
+	defer s3Gateway.Close()
+	stsGateway, stsRequests := newRecordingServer()
+	defer stsGateway.Close()
+
+	config, err := newConfig("us-east-1", "amazonaws.com", "", map[string]string{"s3": s3Gateway.URL, "sts": stsGateway.URL})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create config without any error: %v", err))
+	}
+	config.Credentials = credentials.NewStaticCredentials("AKIDMOCK", "SECRETMOCK", "")
+	config.MaxRetries = aws.Int(0)
+	config.S3ForcePathStyle = aws.Bool(true)
+
+	sess := session.Must(session.NewSession())
+	s3.New(sess, config).ListBuckets(&s3.ListBucketsInput{})
+	sts.New(sess, config).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+
+	if *s3Requests != 1 || *stsRequests != 1 {
+		t.Error(fmt.Sprintf("expected one request to each gateway but got %d S3 and %d STS requests", *s3Requests, *stsRequests))
+	}
+
+	resolved, err := config.EndpointResolver.EndpointFor("kms", "us-east-1")
+	if err != nil || resolved.URL != "https://kms.us-east-1.amazonaws.com" {
+		t.Error(fmt.Sprintf("expected KMS to be resolved by the partition but got '%s': %v", resolved.URL, err))
+	}
+}
+
+func TestServiceEndpointsUnknownService(t *testing.T) {
+	expectedErrString := "unknown AWS service"
+
+	_, err := newConfig("us-east-1", "amazonaws.com", "", map[string]string{"s4": "https://gateway:9000"})
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+func TestCreateS3ClientInstanceRole(t *testing.T) {
+	defer mockInstanceRole(t, http.StatusOK)()
+
+	svc, err := CreateS3Client("", "default", true, "", "", "us-east-1", "amazonaws.com", "", nil, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create client without any error: %v", err))
+	}
+
+	creds, err := svc.Config.Credentials.Get()
+	if err != nil || creds.AccessKeyID != "AKIDROLE" || creds.SessionToken != "TOKENROLE" {
+		t.Error(fmt.Sprintf("expected the credentials of the instance role but got '%s': %v", creds.AccessKeyID, err))
+	}
+}
+
+func TestCreateS3ClientInstanceRoleUnavailable(t *testing.T) {
+	defer mockInstanceRole(t, http.StatusNotFound)()
+	expectedErrString := "failed to retrieve credentials from the instance role"
+
+	_, err := CreateS3Client("", "default", true, "", "", "us-east-1", "amazonaws.com", "", nil, false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// The instance role should be used when the credential file cannot provide credentials
+func TestCreateS3ClientFallbackToInstanceRole(t *testing.T) {
+	defer mockInstanceRole(t, http.StatusOK)()
+
+	svc, err := CreateS3Client(filepath.Join(t.TempDir(), "missing_creds"), "default", false, "", "", "us-east-1", "amazonaws.com", "", nil, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create client without any error: %v", err))
+	}
+
+	creds, err := svc.Config.Credentials.Get()
+	if err != nil || creds.AccessKeyID != "AKIDROLE" {
+		t.Error(fmt.Sprintf("expected the credentials of the instance role but got '%s': %v", creds.AccessKeyID, err))
+	}
+}
+
+// Serves the credentials of a task role from the ECS credential endpoint and points the SDK at it. The status of an
+// unavailable role is returned without credentials. Returns a function which restores the environment
+func mockInstanceRole(t *testing.T, status int) func() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			fmt.Fprint(w, `{"code":"NotFound","message":"no role is attached"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"AccessKeyId":"AKIDROLE","SecretAccessKey":"SECRETROLE","Token":"TOKENROLE","Expiration":"%s"}`,
+			time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		if os.Getenv(name) != "" {
+			server.Close()
+			t.Skip("environment credentials take precedence over the instance role: " + name)
+		}
+	}
+	os.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL)
+
+	return func() {
+		os.Unsetenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+		server.Close()
+	}
+}
+
+// The role should be assumed with the base credentials and every request signed with the assumed credentials
+func TestCreateS3ClientAssumeRole(t *testing.T) {
+	stsServer, assumeRequests := newAssumeRoleServer(time.Hour, http.StatusOK)
+	defer stsServer.Close()
+
+	svc, err := CreateS3Client(baseCredFile(t), "default", false, "arn:aws:iam::111122223333:role/BackupWriter", "",
+		"us-east-1", "amazonaws.com", "", map[string]string{"sts": stsServer.URL}, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create client without any error: %v", err))
+	}
+
+	creds, err := svc.Config.Credentials.Get()
+	if err != nil || creds.AccessKeyID != "AKIDASSUMED" || creds.SessionToken != "TOKENASSUMED" {
+		t.Error(fmt.Sprintf("expected the credentials of the assumed role but got '%s': %v", creds.AccessKeyID, err))
+	}
+
+	if len(*assumeRequests) != 1 {
+		t.Fatal(fmt.Sprintf("expected the role to be assumed once but got %d requests", len(*assumeRequests)))
+	}
+	req := (*assumeRequests)[0]
+	if req.Form.Get("RoleArn") != "arn:aws:iam::111122223333:role/BackupWriter" || req.Form.Get("RoleSessionName") != "s3backup" {
+		t.Error(fmt.Sprintf("expected the role to be assumed with the default session name but got: %v", req.Form))
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "AKIDBASE") {
+		t.Error("expected the role to be assumed with the base credentials: " + req.Header.Get("Authorization"))
+	}
+}
+
+// Assumed credentials should be refreshed once they are within the expiry window so long running uploads do not fail
+func TestAssumeRoleRefreshedBeforeExpiry(t *testing.T) {
+	stsServer, assumeRequests := newAssumeRoleServer(assumeRoleExpiryWindow-time.Minute, http.StatusOK)
+	defer stsServer.Close()
+
+	svc, err := CreateS3Client(baseCredFile(t), "default", false, "arn:aws:iam::111122223333:role/BackupWriter", "portfolio",
+		"us-east-1", "amazonaws.com", "", map[string]string{"sts": stsServer.URL}, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create client without any error: %v", err))
+	}
+
+	if _, err = svc.Config.Credentials.Get(); err != nil {
+		t.Fatal(fmt.Sprintf("expected to refresh the assumed credentials without any error: %v", err))
+	}
+	if len(*assumeRequests) != 2 {
+		t.Error(fmt.Sprintf("expected the role to be assumed again before the credentials expire but got %d requests", len(*assumeRequests)))
+	}
+	if (*assumeRequests)[0].Form.Get("RoleSessionName") != "portfolio" {
+		t.Error("expected the role to be assumed with the specified session name")
+	}
+}
+
+func TestCreateS3ClientAssumeRoleDenied(t *testing.T) {
+	stsServer, _ := newAssumeRoleServer(time.Hour, http.StatusForbidden)
+	defer stsServer.Close()
+	expectedErrString := "failed to assume role 'arn:aws:iam::111122223333:role/BackupWriter'"
+
+	_, err := CreateS3Client(baseCredFile(t), "default", false, "arn:aws:iam::111122223333:role/BackupWriter", "",
+		"us-east-1", "amazonaws.com", "", map[string]string{"sts": stsServer.URL}, false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns a credential file with the base credentials the role is assumed with
+func baseCredFile(t *testing.T) string {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		if os.Getenv(name) != "" {
+			t.Skip("environment credentials take precedence over the credential file: " + name)
+		}
+	}
+
+	credFile := filepath.Join(t.TempDir(), "creds")
+	ioutil.WriteFile(credFile, []byte("[default]\naws_access_key_id = AKIDBASE\naws_secret_access_key = SECRETBASE\n"), 0600)
+	return credFile
+}
+
+// Returns an STS server which records every AssumeRole request. Credentials which expire after the duration are
+// returned with a status of OK, otherwise the request is denied
+func newAssumeRoleServer(expiresIn time.Duration, status int) (*httptest.Server, *[]*http.Request) {
+	requests := &[]*http.Request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*requests = append(*requests, r)
+
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>not authorized to assume the role</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials><AccessKeyId>AKIDASSUMED</AccessKeyId>`+
+			`<SecretAccessKey>SECRETASSUMED</SecretAccessKey><SessionToken>TOKENASSUMED</SessionToken>`+
+			`<Expiration>%s</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`,
+			time.Now().Add(expiresIn).UTC().Format(time.RFC3339))
+	}))
+	return server, requests
+}
+
+// Returns a server which counts the requests it receives
+func newRecordingServer() (*httptest.Server, *int) {
+	requests := new(int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	return server, requests
+}
+
+//----------------------------------------------
+//
+//             Permission Check Tests
+//
+//----------------------------------------------
+
+var allPermissions = []string{
+	PermissionListBucket,
+	PermissionPutObject,
+	PermissionGetObject,
+	PermissionDeleteObject,
+	PermissionListBucketMultipartUploads,
+	PermissionListMultipartUploadParts,
+	PermissionAbortMultipartUpload,
+	PermissionGetObjectLegalHold,
+	PermissionPutObjectLegalHold,
+	PermissionGetObjectTagging,
+}
+
+// Every permission should be allowed and the probe object removed when nothing is denied
+func TestCheckPermissionsAllAllowed(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	report, err := CheckPermissions(server.Client(), "mockbucket", "backups/", allPermissions)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to check permissions without any error: %v", err))
+	}
+
+	if len(report.Missing()) != 0 {
+		t.Error(fmt.Sprintf("expected no missing permissions: %v", report.Missing()))
+	}
+
+	if len(report.Checks) != len(allPermissions) {
+		t.Error(fmt.Sprintf("expected %d permissions to be checked but %d were checked", len(allPermissions), len(report.Checks)))
+	}
+
+	if len(server.Keys("mockbucket")) != 0 || server.MultipartUploads() != 0 {
+		t.Error("expected the probe object and multipart upload to be removed")
+	}
+}
+
+// Denied operations should be reported with their IAM action names
+func TestCheckPermissionsDenied(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	server.FailOperation("DeleteObject", 403, "AccessDenied")
+	server.FailOperation("ListMultipartUploads", 403, "AccessDenied")
+	server.FailOperation("GetObjectLegalHold", 403, "AccessDenied")
+
+	report, err := CheckPermissions(server.Client(), "mockbucket", "", allPermissions)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to check permissions without any error: %v", err))
+	}
+
+	expected := []string{PermissionDeleteObject, PermissionGetObjectLegalHold, PermissionListBucketMultipartUploads}
+	if fmt.Sprint(report.Missing()) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected missing permissions %v but got %v", expected, report.Missing()))
+	}
+
+	if report.LeftoverKey == "" || server.Object("mockbucket", report.LeftoverKey) == nil {
+		t.Error("expected the probe object which could not be deleted to be reported")
+	}
+}
+
+// Multipart permissions are still checked with an upload id which does not exist when s3:PutObject is denied
+func TestCheckPermissionsPutObjectDenied(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	server.FailOperation("PutObject", 403, "AccessDenied")
+	server.FailOperation("ListParts", 403, "AccessDenied")
+	server.FailOperation("GetObject", 403, "AccessDenied")
+
+	report, err := CheckPermissions(server.Client(), "mockbucket", "", allPermissions)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to check permissions without any error: %v", err))
+	}
+
+	expected := []string{PermissionGetObject, PermissionListMultipartUploadParts, PermissionPutObject}
+	if fmt.Sprint(report.Missing()) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected missing permissions %v but got %v", expected, report.Missing()))
+	}
+
+	if len(server.Requests("CreateMultipartUpload")) != 0 {
+		t.Error("expected no multipart upload to be created when s3:PutObject is denied")
+	}
+}
+
+// Only the requested permissions should be reported
+func TestCheckPermissionsSubset(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	server.FailOperation("DeleteObject", 403, "AccessDenied")
+
+	report, err := CheckPermissions(server.Client(), "mockbucket", "", []string{PermissionGetObject})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to check permissions without any error: %v", err))
+	}
+
+	if len(report.Checks) != 1 || len(report.Missing()) != 0 {
+		t.Error(fmt.Sprintf("expected only s3:GetObject to be checked and allowed: %v", report.Checks))
+	}
+}
+
+// Errors other than access denied should fail the check
+func TestCheckPermissionsNoSuchBucket(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	_, err := CheckPermissions(server.Client(), "missingbucket", "", allPermissions)
+
+	expectedErrString := "NoSuchBucket"
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+//
+//             Listing Tests
+//
+//----------------------------------------------
+
+// Keys in nested prefixes should be rolled up into common prefixes distinct from the objects directly under the prefix
+func TestListByDelimiter(t *testing.T) {
+	server := seedNestedKeys()
+	defer server.Close()
+
+	listing, err := ListByDelimiter(server.Client(), "mockbucket", "backups/", "/")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to list without any error: %v", err))
+	}
+
+	expectedPrefixes := "backups/db/,backups/photos/"
+	if strings.Join(listing.CommonPrefixes, ",") != expectedPrefixes {
+		t.Error(fmt.Sprintf("expected common prefixes '%s' but got %v", expectedPrefixes, listing.CommonPrefixes))
+	}
+
+	if len(listing.Objects) != 1 || listing.Objects[0].Key != "backups/notes.txt" || listing.Objects[0].Size != int64(len("notes")) {
+		t.Error(fmt.Sprintf("expected only the leaf object 'backups/notes.txt' to be listed as an object: %v", listing.Objects))
+	}
+}
+
+// A nested prefix should list its own folders and objects
+func TestListByDelimiterNestedPrefix(t *testing.T) {
+	server := seedNestedKeys()
+	defer server.Close()
+
+	listing, err := ListByDelimiter(server.Client(), "mockbucket", "backups/db/", "/")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to list without any error: %v", err))
+	}
+
+	if strings.Join(listing.CommonPrefixes, ",") != "backups/db/archive/" || len(listing.Objects) != 2 {
+		t.Error(fmt.Sprintf("expected one common prefix and two objects but got %v and %v", listing.CommonPrefixes, listing.Objects))
+	}
+}
+
+// Every key under the prefix should be listed as an object across pages when no delimiter is specified
+func TestListByDelimiterFlat(t *testing.T) {
+	server := seedNestedKeys()
+	defer server.Close()
+
+	for i := 0; i < 1200; i++ {
+		server.PutObject("mockbucket", fmt.Sprintf("backups/photos/%04d.jpg", i), []byte("photo"), time.Now())
+	}
+
+	listing, err := ListByDelimiter(server.Client(), "mockbucket", "backups/", "")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to list without any error: %v", err))
+	}
+
+	if len(listing.CommonPrefixes) != 0 || len(listing.Objects) != 1205 {
+		t.Error(fmt.Sprintf("expected every key to be listed as an object, got %d objects and %d common prefixes",
+			len(listing.Objects), len(listing.CommonPrefixes)))
+	}
+}
+
+func seedNestedKeys() *s3mock.Server {
+	server := s3mock.New("mockbucket")
+	for _, key := range []string{
+		"backups/notes.txt",
+		"backups/db/daily_db_20190304T010000",
+		"backups/db/weekly_db_20190302T010000",
+		"backups/db/archive/monthly_db_20190101T010000",
+		"backups/photos/album.zip",
+		"other/unrelated.txt",
+	} {
+		body := []byte("backup")
+		if key == "backups/notes.txt" {
+			body = []byte("notes")
+		}
+		server.PutObject("mockbucket", key, body, time.Now())
+	}
+	return server
+}
+
+//----------------------------------------------
+//
+//             Validation Tests
+//
+//----------------------------------------------
+
+// Every check should pass against a bucket in the expected region which allows every permission
+func TestValidateConfigPassed(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	report := ValidateConfig(server.Client(), "mockbucket", "us-east-1", "backups/", allPermissions)
+	if !report.Passed() {
+		t.Fatal(fmt.Sprintf("expected every check to pass but got: %+v", report.Checks))
+	}
+
+	expected := []string{ValidationCredentials, ValidationEndpoint, ValidationBucket, ValidationRegion, ValidationPermissions}
+	if fmt.Sprint(validationCheckNames(report)) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected the checks %v but got %v", expected, validationCheckNames(report)))
+	}
+
+	if len(server.Keys("mockbucket")) != 0 {
+		t.Error("expected the probe object of the permission check to be removed")
+	}
+}
+
+// A bucket in another region should fail the region check and skip the permission check
+func TestValidateConfigRegionMismatch(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	server.SetBucketRegion("mockbucket", "eu-west-1")
+
+	report := ValidateConfig(server.Client(), "mockbucket", "us-east-1", "", allPermissions)
+	assertValidationFailed(t, report, ValidationRegion, "is in region 'eu-west-1' but the expected region is 'us-east-1'")
+
+	if len(server.Requests("PutObject")) != 0 {
+		t.Error("expected the permissions not to be checked once the region check failed")
+	}
+}
+
+// A bucket which does not exist should fail the bucket check
+func TestValidateConfigBucketMissing(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	report := ValidateConfig(server.Client(), "otherbucket", "us-east-1", "", allPermissions)
+	assertValidationFailed(t, report, ValidationBucket, "bucket 'otherbucket' does not exist")
+}
+
+// An endpoint which is not listening should fail the endpoint check
+func TestValidateConfigEndpointUnreachable(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	mockSvc := server.Client()
+	server.Close()
+
+	report := ValidateConfig(mockSvc, "mockbucket", "us-east-1", "", allPermissions)
+	assertValidationFailed(t, report, ValidationEndpoint, "is unreachable")
+}
+
+// Credentials which cannot be resolved should fail before any request is made
+func TestValidateConfigCredentialsUnresolved(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	mockSvc := server.Client()
+	mockSvc.Config.Credentials = credentials.NewCredentials(&credentials.ErrorProvider{
+		Err:          fmt.Errorf("the credential file is empty"),
+		ProviderName: "ErrorProvider",
+	})
+
+	report := ValidateConfig(mockSvc, "mockbucket", "us-east-1", "", allPermissions)
+	assertValidationFailed(t, report, ValidationCredentials, "the credential file is empty")
+
+	if len(server.Requests("HeadBucket")) != 0 {
+		t.Error("expected no request to be made once the credentials failed to resolve")
+	}
+}
+
+// A missing permission should fail the permission check with the IAM action which is missing
+func TestValidateConfigPermissionMissing(t *testing.T) {
+	server := s3mock.New("mockbucket")
+	defer server.Close()
+
+	server.FailOperation("GetObjectTagging", 403, "AccessDenied")
+
+	report := ValidateConfig(server.Client(), "mockbucket", "us-east-1", "", allPermissions)
+	assertValidationFailed(t, report, ValidationPermissions, "missing 1 of 10 required permissions: "+PermissionGetObjectTagging)
+}
+
+// Fails the test unless only the named check failed with the detail and every check after it was skipped
+func assertValidationFailed(t *testing.T, report ValidationReport, name string, detail string) {
+	t.Helper()
+
+	if report.Passed() || fmt.Sprint(report.Failed()) != fmt.Sprint([]string{name}) {
+		t.Fatal(fmt.Sprintf("expected only the %s check to fail but got: %+v", name, report.Checks))
+	}
+	if len(report.Checks) != 5 {
+		t.Fatal(fmt.Sprintf("expected a result for each of the 5 checks but got: %+v", report.Checks))
+	}
+
+	failed := false
+	for _, check := range report.Checks {
+		switch {
+		case check.Name == name:
+			failed = true
+			if !strings.Contains(check.Detail, detail) {
+				t.Error(fmt.Sprintf("expected the %s check to fail with '%s' but got: %s", name, detail, check.Detail))
+			}
+		case failed && !check.Skipped:
+			t.Error(fmt.Sprintf("expected the %s check after the failed %s check to be skipped", check.Name, name))
+		case !failed && !check.Passed:
+			t.Error(fmt.Sprintf("expected the %s check before the failed %s check to pass: %s", check.Name, name, check.Detail))
+		}
+	}
+}
+
+func validationCheckNames(report ValidationReport) []string {
+	names := []string{}
+	for _, check := range report.Checks {
+		names = append(names, check.Name)
+	}
+	return names
+}
+
+//----------------------------------------------
+//
+//             Credential Expiry Tests
+//
+//----------------------------------------------
+
+// Credentials which do not expire, e.g. access keys, should always pass the check
+func TestCheckCredentialExpiryStatic(t *testing.T) {
+	creds := credentials.NewStaticCredentials("AKIDSTATIC", "secret", "")
+
+	if err := CheckCredentialExpiry(creds, time.Hour); err != nil {
+		t.Error(fmt.Sprintf("expected credentials which do not expire to pass the check: %v", err))
+	}
+}
+
+// Credentials which expire after the run is estimated to finish should pass without being refreshed
+func TestCheckCredentialExpiryValid(t *testing.T) {
+	provider := &expiringProvider{validFor: []time.Duration{2 * time.Hour}}
+
+	if err := CheckCredentialExpiry(credentials.NewCredentials(provider), time.Hour); err != nil {
+		t.Error(fmt.Sprintf("expected credentials valid for the whole run to pass the check: %v", err))
+	}
+	if provider.retrieved != 1 {
+		t.Error(fmt.Sprintf("expected the credentials not to be refreshed but they were retrieved %d times", provider.retrieved))
+	}
+}
+
+// Credentials about to expire which are refreshed with a later expiry should pass as they are refreshed again
+func TestCheckCredentialExpiryRefreshed(t *testing.T) {
+	provider := &expiringProvider{validFor: []time.Duration{5 * time.Minute, 15 * time.Minute}}
+
+	if err := CheckCredentialExpiry(credentials.NewCredentials(provider), time.Hour); err != nil {
+		t.Error(fmt.Sprintf("expected credentials which are refreshed before they expire to pass the check: %v", err))
+	}
+	if provider.retrieved != 2 {
+		t.Error(fmt.Sprintf("expected the credentials to be refreshed once but they were retrieved %d times", provider.retrieved))
+	}
+}
+
+// Credentials about to expire which the provider issues again with the same expiry should fail the check early
+func TestCheckCredentialExpiryImminent(t *testing.T) {
+	expiresAt := time.Now().Add(5 * time.Minute)
+	provider := &expiringProvider{expiresAt: expiresAt}
+	expectedErrString := "before the run is estimated to finish"
+
+	err := CheckCredentialExpiry(credentials.NewCredentials(provider), time.Hour)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) && strings.Contains(err.Error(), "cannot be refreshed") &&
+		strings.Contains(err.Error(), expiresAt.UTC().Format(time.RFC3339)) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if provider.retrieved != 2 {
+		t.Error(fmt.Sprintf("expected the credentials to be refreshed before failing but they were retrieved %d times", provider.retrieved))
+	}
+}
+
+// Credentials which cannot be retrieved should fail the check
+func TestCheckCredentialExpiryUnavailable(t *testing.T) {
+	provider := &expiringProvider{err: errors.New("mock provider unavailable")}
+	expectedErrString := "failed to retrieve the credentials to check their expiry"
+
+	err := CheckCredentialExpiry(credentials.NewCredentials(provider), time.Hour)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Mock credential provider whose credentials expire once they have been valid for the next duration, or at the fixed
+// expiry if one is set, each time they are retrieved
+type expiringProvider struct {
+	credentials.Expiry
+	validFor  []time.Duration
+	expiresAt time.Time
+	err       error
+	retrieved int
+}
+
+func (p *expiringProvider) Retrieve() (credentials.Value, error) {
+	if p.err != nil {
+		return credentials.Value{}, p.err
+	}
+
+	expiresAt := p.expiresAt
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(p.validFor[p.retrieved])
+	}
+	p.retrieved++
+	p.SetExpiration(expiresAt, 0)
+
+	return credentials.Value{AccessKeyID: "AKIDTEMP", SecretAccessKey: "secret", SessionToken: "token", ProviderName: "expiringProvider"}, nil
+}
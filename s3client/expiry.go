@@ -0,0 +1,58 @@
+package s3client
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"s3backup/log"
+	"time"
+)
+
+// What happens when the credentials expire before the run is estimated to finish and cannot be refreshed
+const (
+	CredentialExpiryWarn = "warn" // Log a warning and run the action regardless
+	CredentialExpiryFail = "fail" // Fail before the action runs
+)
+
+// CheckCredentialExpiry returns an error if the credentials expire before the run is estimated to finish and cannot be
+// refreshed, e.g. as the provider issues the same session again, so that a long upload fails before it starts rather
+// than with access denied once the credentials have expired. Credentials which expire before the run finishes are
+// refreshed, and credentials which were refreshed with a later expiry, e.g. of an assumed role or the instance role,
+// are refreshed again as they expire. Credentials which do not expire, e.g. access keys, are always valid
+func CheckCredentialExpiry(creds *credentials.Credentials, runDuration time.Duration) error {
+	if _, err := creds.Get(); err != nil {
+		return fmt.Errorf("failed to retrieve the credentials to check their expiry: %v", err)
+	}
+
+	expiresAt, err := creds.ExpiresAt()
+	if err != nil {
+		log.Info.Println("The credentials do not expire")
+		return nil
+	}
+
+	finishAt := time.Now().Add(runDuration)
+	if !expiresAt.Before(finishAt) {
+		log.Info.Printf("The credentials expire at %s after the run is estimated to finish\n", expiresAt.UTC().Format(time.RFC3339))
+		return nil
+	}
+
+	log.Info.Printf("The credentials expire at %s before the run is estimated to finish at %s, refreshing them\n",
+		expiresAt.UTC().Format(time.RFC3339), finishAt.UTC().Format(time.RFC3339))
+	creds.Expire()
+	if _, err = creds.Get(); err != nil {
+		return fmt.Errorf("failed to refresh the credentials which expire at %s: %v", expiresAt.UTC().Format(time.RFC3339), err)
+	}
+
+	refreshedAt, err := creds.ExpiresAt()
+	if err != nil || !refreshedAt.Before(finishAt) {
+		log.Info.Println("The refreshed credentials expire after the run is estimated to finish")
+		return nil
+	}
+	if refreshedAt.After(expiresAt) {
+		log.Info.Printf("The refreshed credentials expire at %s and are refreshed again before they expire\n", refreshedAt.UTC().Format(time.RFC3339))
+		return nil
+	}
+
+	return fmt.Errorf("the credentials expire at %s in %s which is before the run is estimated to finish at %s, and they cannot be refreshed. "+
+		"Any request made once they have expired fails with access denied", refreshedAt.UTC().Format(time.RFC3339),
+		time.Until(refreshedAt).Round(time.Second), finishAt.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,37 @@
+package s3client
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"strings"
+	"time"
+)
+
+// WriteLastSuccess writes the time of the last successful backup to the marker key as an RFC 3339 timestamp in UTC,
+// replacing the time of the previous backup. External monitors alert when the timestamp or the last modified time of
+// the marker is older than the backup schedule allows
+func WriteLastSuccess(svc *s3.S3, bucket string, markerKey string, successTime time.Time) error {
+	body := []byte(successTime.UTC().Format(time.RFC3339) + "\n")
+	if err := PutObjectBody(svc, bucket, markerKey, body, "text/plain"); err != nil {
+		return fmt.Errorf("failed to write last success marker '%s': %v", markerKey, err)
+	}
+	return nil
+}
+
+// GetLastSuccess returns the time of the last successful backup written to the marker key. Returns false if there is
+// no marker
+func GetLastSuccess(svc *s3.S3, bucket string, markerKey string) (time.Time, bool, error) {
+	body, err := GetObjectBody(svc, bucket, markerKey)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to read last success marker '%s': %v", markerKey, err)
+	}
+	if body == nil {
+		return time.Time{}, false, nil
+	}
+
+	successTime, err := time.Parse(time.RFC3339, strings.TrimSpace(string(body)))
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to parse last success marker '%s': %v", markerKey, err)
+	}
+	return successTime, true, nil
+}
@@ -0,0 +1,204 @@
+package s3client
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IAM actions required by s3backup
+const (
+	PermissionListBucket                 = "s3:ListBucket"
+	PermissionPutObject                  = "s3:PutObject"
+	PermissionGetObject                  = "s3:GetObject"
+	PermissionDeleteObject               = "s3:DeleteObject"
+	PermissionListBucketMultipartUploads = "s3:ListBucketMultipartUploads"
+	PermissionListMultipartUploadParts   = "s3:ListMultipartUploadParts"
+	PermissionAbortMultipartUpload       = "s3:AbortMultipartUpload"
+	PermissionGetObjectLegalHold         = "s3:GetObjectLegalHold"
+	PermissionPutObjectLegalHold         = "s3:PutObjectLegalHold"
+	PermissionGetObjectTagging           = "s3:GetObjectTagging"
+)
+
+// PermissionCheck is the result of checking a single IAM action
+type PermissionCheck struct {
+	Action    string // The IAM action, e.g. s3:PutObject
+	Operation string // The S3 API operation used to check the action
+	Allowed   bool
+	Error     error // The error returned if the action is not allowed
+}
+
+// PermissionReport is the result of checking every requested IAM action
+type PermissionReport struct {
+	Checks      []PermissionCheck
+	LeftoverKey string // The probe object if it could not be removed because s3:DeleteObject is missing
+}
+
+// Missing returns the sorted IAM actions which are not allowed
+func (report PermissionReport) Missing() []string {
+	missing := []string{}
+	for _, check := range report.Checks {
+		if !check.Allowed {
+			missing = append(missing, check.Action)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// CheckPermissions attempts each of the IAM actions against the bucket with a temporary probe object under the
+// bucket dir and reports which actions are not allowed. Only access denied errors are reported as missing
+// permissions, any other failure such as the bucket not existing is returned as an error
+func CheckPermissions(svc *s3.S3, bucket string, bucketDir string, actions []string) (PermissionReport, error) {
+	report := PermissionReport{Checks: []PermissionCheck{}}
+	probeKey := fmt.Sprintf("%s.s3backup-permcheck-%d", bucketDir, time.Now().UnixNano())
+
+	required := make(map[string]bool)
+	for _, action := range actions {
+		required[action] = true
+	}
+
+	check := func(action string, operation string, err error, allowedCodes ...string) error {
+		if !required[action] {
+			return nil
+		}
+		result := PermissionCheck{Action: action, Operation: operation, Allowed: true}
+		if err != nil {
+			aerr, ok := err.(awserr.Error)
+			switch {
+			case ok && isAccessDenied(err):
+				result.Allowed = false
+				result.Error = err
+			case ok && containsString(allowedCodes, aerr.Code()):
+				// The request was authorised but failed for another expected reason, e.g. the key does not exist
+			default:
+				return fmt.Errorf("failed to check %s with %s: %v", action, operation, err)
+			}
+		}
+		report.Checks = append(report.Checks, result)
+		return nil
+	}
+
+	_, err := svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String(bucket), Prefix: aws.String(bucketDir), MaxKeys: aws.Int64(1)})
+	if err = check(PermissionListBucket, "ListObjects", err); err != nil {
+		return report, err
+	}
+
+	// Checked before the probe object is created so that a legal hold can never be placed on it
+	_, err = svc.PutObjectLegalHold(&s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(probeKey),
+		LegalHold: &s3.ObjectLockLegalHold{Status: aws.String(s3.ObjectLockLegalHoldStatusOff)},
+	})
+	if err = check(PermissionPutObjectLegalHold, "PutObjectLegalHold", err, s3.ErrCodeNoSuchKey,
+		"NoSuchObjectLockConfiguration", "InvalidRequest", "NotImplemented"); err != nil {
+		return report, err
+	}
+
+	putAllowed := false
+	if required[PermissionPutObject] {
+		_, err = svc.PutObject(&s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(probeKey), Body: strings.NewReader("")})
+		putAllowed = err == nil
+		if err = check(PermissionPutObject, "PutObject", err); err != nil {
+			return report, err
+		}
+	}
+
+	// The probe object may not exist if s3:PutObject is missing in which case a missing key is expected
+	_, err = svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(probeKey)})
+	if err = check(PermissionGetObject, "GetObject", err, s3.ErrCodeNoSuchKey); err != nil {
+		return report, err
+	}
+
+	_, err = svc.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{Bucket: aws.String(bucket), Key: aws.String(probeKey)})
+	if err = check(PermissionGetObjectLegalHold, "GetObjectLegalHold", err, s3.ErrCodeNoSuchKey,
+		"NoSuchObjectLockConfiguration", "InvalidRequest", "NotImplemented"); err != nil {
+		return report, err
+	}
+
+	_, err = svc.GetObjectTagging(&s3.GetObjectTaggingInput{Bucket: aws.String(bucket), Key: aws.String(probeKey)})
+	if err = check(PermissionGetObjectTagging, "GetObjectTagging", err, s3.ErrCodeNoSuchKey); err != nil {
+		return report, err
+	}
+
+	_, err = svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{Bucket: aws.String(bucket), Prefix: aws.String(probeKey)})
+	if err = check(PermissionListBucketMultipartUploads, "ListMultipartUploads", err); err != nil {
+		return report, err
+	}
+
+	// Multipart uploads are authorised with s3:PutObject. If it is missing then an upload id which does not exist is
+	// used to check the remaining multipart actions
+	uploadID := "s3backup-permcheck"
+	if putAllowed {
+		created, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String(bucket), Key: aws.String(probeKey)})
+		if err == nil {
+			uploadID = aws.StringValue(created.UploadId)
+		}
+		if err = check(PermissionPutObject, "CreateMultipartUpload", err); err != nil {
+			return report, err
+		}
+	}
+
+	_, err = svc.ListParts(&s3.ListPartsInput{Bucket: aws.String(bucket), Key: aws.String(probeKey), UploadId: aws.String(uploadID)})
+	if err = check(PermissionListMultipartUploadParts, "ListParts", err, s3.ErrCodeNoSuchUpload); err != nil {
+		return report, err
+	}
+
+	_, err = svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{Bucket: aws.String(bucket), Key: aws.String(probeKey), UploadId: aws.String(uploadID)})
+	if err = check(PermissionAbortMultipartUpload, "AbortMultipartUpload", err, s3.ErrCodeNoSuchUpload); err != nil {
+		return report, err
+	}
+
+	// Deleting a key which does not exist succeeds if s3:DeleteObject is allowed so this also removes the probe object
+	_, deleteErr := svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(probeKey)})
+	if putAllowed && deleteErr != nil {
+		report.LeftoverKey = probeKey
+	}
+	if err = check(PermissionDeleteObject, "DeleteObject", deleteErr); err != nil {
+		return report, err
+	}
+
+	report.Checks = mergeChecks(report.Checks)
+	return report, nil
+}
+
+// Returns true if the error is an access denied error. HEAD requests and some S3 compatible providers only return the status code
+func isAccessDenied(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusForbidden {
+		return true
+	}
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == "AccessDenied" || aerr.Code() == "Forbidden")
+}
+
+// An action may be checked by more than one operation. It is only allowed if every operation is allowed
+func mergeChecks(checks []PermissionCheck) []PermissionCheck {
+	merged := []PermissionCheck{}
+	index := make(map[string]int)
+	for _, check := range checks {
+		i, ok := index[check.Action]
+		if !ok {
+			index[check.Action] = len(merged)
+			merged = append(merged, check)
+			continue
+		}
+		if merged[i].Allowed && !check.Allowed {
+			merged[i] = check
+		}
+	}
+	return merged
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
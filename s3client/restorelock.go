@@ -0,0 +1,96 @@
+package s3client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"os"
+	"strings"
+	"time"
+)
+
+// RestoreLockDir is the directory under the bucket dir that restores hold their locks in while they read backups.
+// Rotation does not delete any backups under the bucket dir while an unexpired lock is held
+const RestoreLockDir = ".s3backup-restores/"
+
+// The format of the expiry which begins the name of each lock, so that locks can be checked by listing them alone
+const restoreLockTimeFormat = "20060102T150405Z"
+
+// RestoreLock is held by a restore while it reads a backup from under the bucket dir. Each restore holds its own lock
+// so that concurrent restores do not release each other's locks
+type RestoreLock struct {
+	Key        string    `json:"-"`
+	Object     string    `json:"object"` // The key of the backup being restored
+	Host       string    `json:"host"`
+	PID        int       `json:"pid"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"` // The time the lock is treated as released if the restore has not released it, e.g. because it was killed
+}
+
+// AcquireRestoreLock writes a lock under the bucket dir which is held while the object is restored and expires after the ttl
+func AcquireRestoreLock(svc *s3.S3, bucket string, bucketDir string, object string, ttl time.Duration) (RestoreLock, error) {
+	host, _ := os.Hostname()
+	now := time.Now().UTC()
+
+	lock := RestoreLock{
+		Object:     object,
+		Host:       host,
+		PID:        os.Getpid(),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	lock.Key = fmt.Sprintf("%s%s%s_%s_%d_%d.json", bucketDir, RestoreLockDir, lock.ExpiresAt.Format(restoreLockTimeFormat),
+		strings.Replace(host, "_", "-", -1), lock.PID, now.UnixNano())
+
+	body, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return lock, err
+	}
+
+	if err = PutObjectBody(svc, bucket, lock.Key, body, "application/json"); err != nil {
+		return lock, fmt.Errorf("failed to acquire restore lock '%s': %v", lock.Key, err)
+	}
+
+	log.Info.Printf("Acquired restore lock: '%s' until %s\n", lock.Key, lock.ExpiresAt.Format(time.RFC3339))
+	return lock, nil
+}
+
+// ReleaseRestoreLock deletes the lock so that rotation may delete backups again
+func ReleaseRestoreLock(svc *s3.S3, bucket string, lock RestoreLock) error {
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(lock.Key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release restore lock '%s', it is held until %s: %v", lock.Key, lock.ExpiresAt.Format(time.RFC3339), err)
+	}
+
+	log.Info.Printf("Released restore lock: '%s'\n", lock.Key)
+	return nil
+}
+
+// GetRestoreLocks returns the keys of every restore lock under the bucket dir which has not expired by now. The expiry
+// of each lock is read from its key, so that the locks can be checked without reading them. Locks whose key does not
+// begin with an expiry are held until they are removed
+func GetRestoreLocks(svc *s3.S3, bucket string, bucketDir string, now time.Time) ([]string, error) {
+	listing, err := ListByDelimiter(svc, bucket, bucketDir+RestoreLockDir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	held := []string{}
+	for _, obj := range listing.Objects {
+		name := strings.TrimPrefix(obj.Key, bucketDir+RestoreLockDir)
+		expiresAt, err := time.Parse(restoreLockTimeFormat, strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			log.Warn.Printf("Restore lock: '%s' does not begin with an expiry, it is held until it is removed\n", obj.Key)
+		} else if !now.Before(expiresAt) {
+			log.Info.Printf("Ignoring restore lock: '%s' as it expired at %s\n", obj.Key, expiresAt.Format(time.RFC3339))
+			continue
+		}
+		held = append(held, obj.Key)
+	}
+	return held, nil
+}
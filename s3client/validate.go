@@ -0,0 +1,157 @@
+package s3client
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+	"strings"
+)
+
+// Names of the checks made by ValidateConfig in the order they are made
+const (
+	ValidationCredentials = "credentials"
+	ValidationEndpoint    = "endpoint"
+	ValidationBucket      = "bucket"
+	ValidationRegion      = "region"
+	ValidationPermissions = "permissions"
+)
+
+// ValidationCheck is the result of a single check of the configuration
+type ValidationCheck struct {
+	Name    string
+	Passed  bool
+	Skipped bool   // True if the check was not made as a check it depends on failed. A skipped check has not passed
+	Detail  string // Why the check passed or failed
+}
+
+// ValidationReport is the result of every check of the configuration
+type ValidationReport struct {
+	Checks []ValidationCheck
+}
+
+// Passed returns true if every check passed
+func (report ValidationReport) Passed() bool {
+	for _, check := range report.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed returns the names of the checks which failed, excluding those which were skipped
+func (report ValidationReport) Failed() []string {
+	failed := []string{}
+	for _, check := range report.Checks {
+		if !check.Passed && !check.Skipped {
+			failed = append(failed, check.Name)
+		}
+	}
+	return failed
+}
+
+// ValidateConfig checks that the credentials of the client resolve, that its endpoint is reachable, that the bucket
+// exists in the expected region and that every IAM action is allowed. Each check depends on the checks before it, so
+// the remaining checks are skipped once one fails. The permissions are checked with CheckPermissions, which writes and
+// removes a probe object under the bucket dir if s3:PutObject is one of the actions
+func ValidateConfig(svc *s3.S3, bucket string, region string, bucketDir string, actions []string) ValidationReport {
+	report := ValidationReport{Checks: []ValidationCheck{}}
+	names := []string{ValidationCredentials, ValidationEndpoint, ValidationBucket, ValidationRegion, ValidationPermissions}
+
+	// Records the result of the next check. Returns false if it failed, in which case every remaining check is skipped
+	record := func(passed bool, format string, a ...interface{}) bool {
+		name := names[len(report.Checks)]
+		report.Checks = append(report.Checks, ValidationCheck{Name: name, Passed: passed, Detail: fmt.Sprintf(format, a...)})
+		if !passed {
+			for _, remaining := range names[len(report.Checks):] {
+				report.Checks = append(report.Checks, ValidationCheck{Name: remaining, Skipped: true,
+					Detail: fmt.Sprintf("skipped as the %s check failed", name)})
+			}
+		}
+		return passed
+	}
+
+	credentials, err := svc.Config.Credentials.Get()
+	if err != nil {
+		record(false, "failed to resolve credentials: %v", err)
+		return report
+	}
+	record(true, "resolved by %s", credentials.ProviderName)
+
+	// HeadBucket reports whether the bucket exists and, on AWS, its region even if the request was sent to another region
+	req, _ := svc.HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	err = req.Send()
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == request.ErrCodeRequestError {
+		record(false, "endpoint '%s' is unreachable: %v", svc.Endpoint, err)
+		return report
+	}
+	record(true, "endpoint '%s' is reachable", svc.Endpoint)
+
+	bucketRegion := ""
+	if req.HTTPResponse != nil {
+		bucketRegion = req.HTTPResponse.Header.Get("X-Amz-Bucket-Region")
+	}
+
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		switch reqErr.StatusCode() {
+		case http.StatusNotFound:
+			record(false, "bucket '%s' does not exist", bucket)
+			return report
+		case http.StatusForbidden:
+			record(false, "access to bucket '%s' was denied", bucket)
+			return report
+		case http.StatusMovedPermanently, http.StatusBadRequest:
+			// The bucket exists in another region, which is reported by the region check
+			if bucketRegion == "" {
+				record(false, "bucket '%s' could not be found in region '%s': %v", bucket, region, err)
+				return report
+			}
+		default:
+			record(false, "failed to check bucket '%s': %v", bucket, err)
+			return report
+		}
+	} else if err != nil {
+		record(false, "failed to check bucket '%s': %v", bucket, err)
+		return report
+	}
+	record(true, "bucket '%s' exists", bucket)
+
+	// Providers which do not report the region with HeadBucket may still report it with GetBucketLocation
+	if bucketRegion == "" {
+		location, err := svc.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+		if err == nil {
+			bucketRegion = s3.NormalizeBucketLocation(aws.StringValue(location.LocationConstraint))
+		}
+	}
+	switch {
+	case bucketRegion == "":
+		record(true, "the region of bucket '%s' is not reported by the endpoint", bucket)
+	case !strings.EqualFold(bucketRegion, region):
+		record(false, "bucket '%s' is in region '%s' but the expected region is '%s'", bucket, bucketRegion, region)
+		return report
+	default:
+		record(true, "bucket '%s' is in region '%s'", bucket, bucketRegion)
+	}
+
+	if len(actions) == 0 {
+		record(true, "no permissions are required")
+		return report
+	}
+
+	permissions, err := CheckPermissions(svc, bucket, bucketDir, actions)
+	leftover := ""
+	if permissions.LeftoverKey != "" {
+		leftover = fmt.Sprintf(". The probe object '%s' could not be removed and must be removed manually", permissions.LeftoverKey)
+	}
+	if err != nil {
+		record(false, "failed to check permissions: %v%s", err, leftover)
+	} else if missing := permissions.Missing(); len(missing) > 0 {
+		record(false, "missing %d of %d required permissions: %s%s", len(missing), len(permissions.Checks), strings.Join(missing, ", "), leftover)
+	} else {
+		record(true, "all %d required permissions are allowed", len(permissions.Checks))
+	}
+	return report
+}
@@ -0,0 +1,46 @@
+package s3client
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"strings"
+)
+
+// VolumeDir is the directory under the bucket dir that the volumes of split uploads are stored in. The volumes of a
+// backup are stored under the key of its index relative to the bucket dir, so they are never listed by rotation or
+// reported as strays, and are deleted along with the index when rotation deletes the backup
+const VolumeDir = ".volumes/"
+
+// VolumeKey returns the key of the volume numbered from 1 of the index key, e.g.
+// backups/.volumes/daily_database_20240131T020000.part001 for backups/daily_database_20240131T020000
+func VolumeKey(bucketDir string, indexKey string, volume int) string {
+	return fmt.Sprintf("%s%s%s.part%03d", bucketDir, VolumeDir, strings.TrimPrefix(indexKey, bucketDir), volume)
+}
+
+// DeleteVolumes deletes every volume of the index key and returns the number of volumes deleted. A key which is not the
+// index of a split upload has no volumes
+func DeleteVolumes(svc *s3.S3, bucket string, bucketDir string, indexKey string) (int, error) {
+	prefix := strings.TrimSuffix(VolumeKey(bucketDir, indexKey, 0), "000")
+
+	volumes := []string{}
+	err := svc.ListObjectsPages(&s3.ListObjectsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			volumes = append(volumes, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the volumes of '%s': %v", indexKey, err)
+	}
+
+	for i, volume := range volumes {
+		if _, err = DeleteKey(svc, bucket, volume); err != nil {
+			return i, fmt.Errorf("failed to delete volume '%s': %v", volume, err)
+		}
+	}
+	return len(volumes), nil
+}
@@ -0,0 +1,122 @@
+package s3mock
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"hash"
+	"hash/crc32"
+	"net/http"
+	"strings"
+)
+
+// Headers of the additional checksums supported by the server and the hash of each
+var checksumHeaders = map[string]func() hash.Hash{
+	"X-Amz-Checksum-Crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"X-Amz-Checksum-Sha256": sha256.New,
+}
+
+// The checksum header of each checksum algorithm
+var checksumAlgorithmHeaders = map[string]string{
+	s3.ChecksumAlgorithmCrc32c: "X-Amz-Checksum-Crc32c",
+	s3.ChecksumAlgorithmSha256: "X-Amz-Checksum-Sha256",
+}
+
+// SetBucketEncryption sets the default server side encryption of the bucket, e.g. aws:kms. Objects created without
+// server side encryption are encrypted with it and, as with S3, the ETag of an object encrypted with SSE-KMS is not
+// the md5sum of its contents
+func (s *Server) SetBucketEncryption(bucket string, algorithm string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encryption[bucket] = algorithm
+}
+
+// Returns the base64 checksum of the body for the checksum header
+func checksum(header string, body []byte) string {
+	h := checksumHeaders[header]()
+	h.Write(body)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Verifies every checksum header of the request against the body
+func verifyChecksums(req *Request, body []byte) *Error {
+	for header := range checksumHeaders {
+		if value := req.Header.Get(header); value != "" && value != checksum(header, body) {
+			return &Error{http.StatusBadRequest, "BadDigest", "The " + header + " you specified did not match the calculated checksum"}
+		}
+	}
+	return nil
+}
+
+// Returns the checksum header of the algorithm the multipart upload was created with, or empty if it has none
+func uploadChecksumHeader(upload *multipartUpload) string {
+	return checksumAlgorithmHeaders[strings.ToUpper(upload.header.Get("X-Amz-Checksum-Algorithm"))]
+}
+
+// Returns the ETag of the body encrypted with the server side encryption. SSE-KMS ETags are opaque
+func encryptedETag(algorithm string, body []byte) string {
+	if algorithm != s3.ServerSideEncryptionAwsKms {
+		return md5ETag(body)
+	}
+	sum := md5.Sum(append([]byte("aws:kms"), body...))
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+// Returns the checksum of the checksums of the parts in the same format S3 reports the checksum of a multipart object
+func compositeChecksum(header string, parts []Part) string {
+	h := checksumHeaders[header]()
+	for _, part := range parts {
+		sum, _ := base64.StdEncoding.DecodeString(part.Checksum)
+		h.Write(sum)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(parts))
+}
+
+func (s *Server) getBucketEncryption(w http.ResponseWriter, req *Request) {
+	algorithm, ok := s.encryption[req.Bucket]
+	if !ok {
+		writeError(w, &Error{http.StatusNotFound, "ServerSideEncryptionConfigurationNotFoundError", "The server side encryption configuration was not found"})
+		return
+	}
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName      xml.Name `xml:"ServerSideEncryptionConfiguration"`
+		SSEAlgorithm string   `xml:"Rule>ApplyServerSideEncryptionByDefault>SSEAlgorithm"`
+	}{SSEAlgorithm: algorithm})
+}
+
+func (s *Server) getObjectAttributes(w http.ResponseWriter, req *Request, objects map[string]*Object) {
+	obj, ok := objects[req.Key]
+	if !ok {
+		writeError(w, &Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."})
+		return
+	}
+
+	type checksums struct {
+		ChecksumCRC32C string `xml:",omitempty"`
+		ChecksumSHA256 string `xml:",omitempty"`
+	}
+	type objectParts struct {
+		TotalPartsCount int `xml:"PartsCount"`
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"GetObjectAttributesResponse"`
+		ETag        string
+		Checksum    *checksums   `xml:",omitempty"`
+		ObjectParts *objectParts `xml:",omitempty"`
+		ObjectSize  int
+	}{ETag: strings.Trim(obj.ETag, "\""), ObjectSize: len(obj.Body)}
+
+	if crc, sha := obj.Header.Get("X-Amz-Checksum-Crc32c"), obj.Header.Get("X-Amz-Checksum-Sha256"); crc != "" || sha != "" {
+		result.Checksum = &checksums{ChecksumCRC32C: crc, ChecksumSHA256: sha}
+	}
+	if len(obj.Parts) > 0 {
+		result.ObjectParts = &objectParts{TotalPartsCount: len(obj.Parts)}
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
@@ -0,0 +1,1034 @@
+package s3mock
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an in-memory S3 compatible server used for testing.
+// Only the subset of the S3 REST API used by s3backup is implemented and buckets must be created up front
+type Server struct {
+	mu       sync.Mutex
+	server   *httptest.Server
+	buckets  map[string]map[string]*Object
+	uploads  map[string]*multipartUpload
+	uploadID int
+	hooks    []Hook
+	requests []*Request
+	clock    func() time.Time
+
+	encryption map[string]string // Default server side encryption of each bucket
+	regions    map[string]string // Region of each bucket reported by HeadBucket and GetBucketLocation. Defaults to us-east-1
+
+	deleteLag int                                  // Requests which still return an object after it has been deleted
+	deleted   map[string]map[string]*deletedObject // Deleted objects of each bucket which are still returned
+}
+
+// An object which has been deleted but is still returned by listings and HeadObject
+type deletedObject struct {
+	obj       *Object
+	remaining int // Requests which still return the object
+}
+
+// Object represents an object stored in the server
+type Object struct {
+	Key          string
+	Body         []byte
+	ETag         string // Quoted as returned by S3
+	LastModified time.Time
+	Header       http.Header // Headers the object was created with, e.g. Content-Type, x-amz-meta-*
+	Tags         map[string]string
+	LegalHold    string
+	Parts        []Part // Parts the object was assembled from if it was uploaded with a multipart upload
+}
+
+// Part represents a single part of a multipart upload
+type Part struct {
+	PartNumber int64
+	Body       []byte
+	ETag       string
+	Checksum   string // The additional checksum sent with the part in the algorithm of the upload, if any
+}
+
+// Request represents a request received by the server. Hooks may modify the body before it is processed
+type Request struct {
+	Operation string // The name of the S3 API operation, e.g. PutObject, UploadPart
+	Bucket    string
+	Key       string
+	Query     url.Values
+	Header    http.Header
+	Body      []byte
+	Time      time.Time
+}
+
+// Error is an S3 error response
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+// Hook is invoked for every request before it is processed. Returning an error fails the request with that error
+type Hook func(req *Request) *Error
+
+type multipartUpload struct {
+	bucket string
+	key    string
+	header http.Header
+	parts  map[int64]Part
+	time   time.Time
+}
+
+// New starts a server with the specified buckets
+func New(buckets ...string) *Server {
+	s := &Server{
+		buckets: make(map[string]map[string]*Object),
+		uploads: make(map[string]*multipartUpload),
+		clock:   time.Now,
+
+		encryption: make(map[string]string),
+		regions:    make(map[string]string),
+		deleted:    make(map[string]map[string]*deletedObject),
+	}
+	for _, bucket := range buckets {
+		s.buckets[bucket] = make(map[string]*Object)
+		s.deleted[bucket] = make(map[string]*deletedObject)
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// URL returns the endpoint of the server
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Client returns an S3 client configured to use the server
+func (s *Server) Client() *s3.S3 {
+	return s3.New(session.Must(session.NewSession()), &aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(s.server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("AKIDMOCK", "SECRETMOCK", ""),
+		MaxRetries:       aws.Int(0),
+	})
+}
+
+// SetBucketRegion sets the region the bucket is reported to be in
+func (s *Server) SetBucketRegion(bucket string, region string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.regions[bucket] = region
+}
+
+// Returns the region of the bucket
+func (s *Server) bucketRegion(bucket string) string {
+	if region := s.regions[bucket]; region != "" {
+		return region
+	}
+	return "us-east-1"
+}
+
+// AddHook registers a hook which is invoked for every subsequent request
+func (s *Server) AddHook(hook Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// FailOperation fails every request for the operation with the error until the server is closed
+func (s *Server) FailOperation(operation string, statusCode int, code string) {
+	s.AddHook(func(req *Request) *Error {
+		if req.Operation == operation {
+			return &Error{StatusCode: statusCode, Code: code, Message: "mock failure"}
+		}
+		return nil
+	})
+}
+
+// SetClock overrides the time used for the last modified time of new objects
+func (s *Server) SetClock(clock func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// PutObject stores an object directly with the specified last modified time
+func (s *Server) PutObject(bucket string, key string, body []byte, lastModified time.Time) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj := &Object{Key: key, Body: body, ETag: md5ETag(body), LastModified: lastModified, Header: http.Header{}, Tags: map[string]string{}}
+	delete(s.deleted[bucket], key)
+	s.buckets[bucket][key] = obj
+	return obj
+}
+
+// Object returns the object with the key or nil if it does not exist
+func (s *Server) Object(bucket string, key string) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buckets[bucket][key]
+}
+
+// SetDeleteLag emulates a provider which briefly continues to return a deleted object. A deleted object is still
+// returned by the next number of listings and HeadObject requests which would have returned it
+func (s *Server) SetDeleteLag(requests int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLag = requests
+}
+
+// SetObjectHeader sets a header which is returned with the object, e.g. X-Amz-Replication-Status
+func (s *Server) SetObjectHeader(bucket string, key string, name string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if obj, ok := s.buckets[bucket][key]; ok {
+		obj.Header.Set(name, value)
+	}
+}
+
+// Keys returns the sorted keys of every object in the bucket
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Requests returns every request received for the operation, or all requests if the operation is empty
+func (s *Server) Requests(operation string) []*Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := []*Request{}
+	for _, req := range s.requests {
+		if operation == "" || req.Operation == operation {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+// MultipartUploads returns the number of multipart uploads which have not been completed or aborted
+func (s *Server) MultipartUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, &Error{http.StatusBadRequest, "IncompleteBody", err.Error()})
+		return
+	}
+
+	path := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	req := &Request{Bucket: path[0], Query: r.URL.Query(), Header: r.Header, Body: body}
+	if len(path) > 1 {
+		req.Key = path[1]
+	}
+	req.Operation = operation(r.Method, req)
+
+	s.mu.Lock()
+	req.Time = s.clock()
+	s.requests = append(s.requests, req)
+	hooks := append([]Hook{}, s.hooks...)
+	s.mu.Unlock()
+
+	for _, hook := range hooks {
+		if hookErr := hook(req); hookErr != nil {
+			writeError(w, hookErr)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects, ok := s.buckets[req.Bucket]
+	if !ok {
+		writeError(w, &Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"})
+		return
+	}
+
+	switch req.Operation {
+	case "HeadBucket":
+		w.Header().Set("X-Amz-Bucket-Region", s.bucketRegion(req.Bucket))
+		w.WriteHeader(http.StatusOK)
+	case "GetBucketLocation":
+		location := s.regions[req.Bucket] // As with S3 buckets in us-east-1 have an empty location constraint
+		writeXML(w, http.StatusOK, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Value   string   `xml:",chardata"`
+		}{Value: location})
+	case "ListObjects", "ListObjectsV2":
+		s.listObjects(w, req, objects)
+	case "ListMultipartUploads":
+		s.listMultipartUploads(w, req)
+	case "GetBucketEncryption":
+		s.getBucketEncryption(w, req)
+	case "PutObject":
+		if checksumErr := verifyChecksums(req, req.Body); checksumErr != nil {
+			writeError(w, checksumErr)
+			return
+		}
+		obj := s.newObject(req, req.Body)
+		objects[req.Key] = obj
+		w.Header().Set("ETag", obj.ETag)
+		w.WriteHeader(http.StatusOK)
+	case "CopyObject":
+		s.copyObject(w, req, objects)
+	case "GetObject", "HeadObject":
+		obj, ok := objects[req.Key]
+		if !ok && req.Operation == "HeadObject" {
+			obj, ok = s.deletedObject(req.Bucket, req.Key)
+		}
+		if !ok {
+			writeError(w, &Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."})
+			return
+		}
+		if req.Operation == "GetObject" && archived(obj) && !restored(obj) {
+			writeError(w, &Error{http.StatusForbidden, "InvalidObjectState", "The operation is not valid for the object's storage class"})
+			return
+		}
+		writeObject(w, r, obj)
+	case "RestoreObject":
+		s.restoreObject(w, req, objects)
+	case "DeleteObject":
+		if obj, ok := objects[req.Key]; ok && obj.LegalHold == s3.ObjectLockLegalHoldStatusOn {
+			writeError(w, &Error{http.StatusForbidden, "AccessDenied", "Object is under a legal hold"})
+			return
+		}
+		if obj, ok := objects[req.Key]; ok && s.deleteLag > 0 {
+			s.deleted[req.Bucket][req.Key] = &deletedObject{obj: obj, remaining: s.deleteLag}
+		}
+		delete(objects, req.Key)
+		w.WriteHeader(http.StatusNoContent)
+	case "GetObjectTagging", "PutObjectTagging", "DeleteObjectTagging":
+		s.objectTagging(w, req, objects)
+	case "GetObjectLegalHold", "PutObjectLegalHold":
+		s.objectLegalHold(w, req, objects)
+	case "PutObjectAcl":
+		obj, ok := objects[req.Key]
+		if !ok {
+			writeError(w, &Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."})
+			return
+		}
+		obj.Header.Set("X-Amz-Acl", req.Header.Get("X-Amz-Acl"))
+		w.WriteHeader(http.StatusOK)
+	case "CreateMultipartUpload":
+		s.uploadID++
+		uploadID := strconv.Itoa(s.uploadID)
+		s.uploads[uploadID] = &multipartUpload{bucket: req.Bucket, key: req.Key, header: req.Header, parts: map[int64]Part{}, time: req.Time}
+		writeXML(w, http.StatusOK, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: req.Bucket, Key: req.Key, UploadId: uploadID})
+	case "UploadPart":
+		upload, ok := s.uploads[req.Query.Get("uploadId")]
+		if !ok {
+			writeError(w, &Error{http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist"})
+			return
+		}
+		if checksumErr := verifyChecksums(req, req.Body); checksumErr != nil {
+			writeError(w, checksumErr)
+			return
+		}
+		partNumber, _ := strconv.ParseInt(req.Query.Get("partNumber"), 10, 64)
+		part := Part{PartNumber: partNumber, Body: req.Body, ETag: encryptedETag(s.objectEncryption(upload.bucket, upload.header), req.Body)}
+		if header := uploadChecksumHeader(upload); header != "" {
+			part.Checksum = req.Header.Get(header)
+		}
+		upload.parts[partNumber] = part
+		w.Header().Set("ETag", part.ETag)
+		w.WriteHeader(http.StatusOK)
+	case "CompleteMultipartUpload":
+		s.completeMultipartUpload(w, req, objects)
+	case "AbortMultipartUpload":
+		delete(s.uploads, req.Query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case "ListParts":
+		s.listParts(w, req)
+	case "GetObjectAttributes":
+		s.getObjectAttributes(w, req, objects)
+	default:
+		writeError(w, &Error{http.StatusNotImplemented, "NotImplemented", req.Operation + " is not implemented"})
+	}
+}
+
+// Returns the name of the S3 API operation for the request
+func operation(method string, req *Request) string {
+	q := req.Query
+	has := func(param string) bool { _, ok := q[param]; return ok }
+
+	if req.Key == "" {
+		switch {
+		case method == http.MethodHead:
+			return "HeadBucket"
+		case method == http.MethodGet && has("location"):
+			return "GetBucketLocation"
+		case method == http.MethodGet && has("uploads"):
+			return "ListMultipartUploads"
+		case method == http.MethodGet && has("encryption"):
+			return "GetBucketEncryption"
+		case method == http.MethodGet && q.Get("list-type") == "2":
+			return "ListObjectsV2"
+		case method == http.MethodGet:
+			return "ListObjects"
+		}
+		return method + "Bucket"
+	}
+
+	switch method {
+	case http.MethodPut:
+		switch {
+		case has("partNumber"):
+			return "UploadPart"
+		case has("tagging"):
+			return "PutObjectTagging"
+		case has("legal-hold"):
+			return "PutObjectLegalHold"
+		case has("acl"):
+			return "PutObjectAcl"
+		case req.Header.Get("X-Amz-Copy-Source") != "":
+			return "CopyObject"
+		}
+		return "PutObject"
+	case http.MethodPost:
+		switch {
+		case has("uploads"):
+			return "CreateMultipartUpload"
+		case has("uploadId"):
+			return "CompleteMultipartUpload"
+		case has("restore"):
+			return "RestoreObject"
+		}
+	case http.MethodDelete:
+		switch {
+		case has("uploadId"):
+			return "AbortMultipartUpload"
+		case has("tagging"):
+			return "DeleteObjectTagging"
+		}
+		return "DeleteObject"
+	case http.MethodGet:
+		switch {
+		case has("uploadId"):
+			return "ListParts"
+		case has("tagging"):
+			return "GetObjectTagging"
+		case has("legal-hold"):
+			return "GetObjectLegalHold"
+		case has("attributes"):
+			return "GetObjectAttributes"
+		}
+		return "GetObject"
+	case http.MethodHead:
+		return "HeadObject"
+	}
+	return method + "Object"
+}
+
+// Starts the restore of an archived object. The restore stays in progress until the X-Amz-Restore header of the object
+// is set to ongoing-request="false" with SetObjectHeader. As with S3 a restore which is already in progress conflicts
+// and the restore of an object which has been restored is accepted without starting another
+func (s *Server) restoreObject(w http.ResponseWriter, req *Request, objects map[string]*Object) {
+	obj, ok := objects[req.Key]
+	if !ok {
+		writeError(w, &Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."})
+		return
+	}
+	if !archived(obj) {
+		writeError(w, &Error{http.StatusForbidden, "InvalidObjectState", "Restore is not allowed for the object's current storage class"})
+		return
+	}
+	if restored(obj) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if obj.Header.Get("X-Amz-Restore") != "" {
+		writeError(w, &Error{http.StatusConflict, "RestoreAlreadyInProgress", "Object restore is already in progress"})
+		return
+	}
+	obj.Header.Set("X-Amz-Restore", `ongoing-request="true"`)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Returns true if the object is stored in an archive storage class and must be restored before it can be read
+func archived(obj *Object) bool {
+	storageClass := obj.Header.Get("X-Amz-Storage-Class")
+	return storageClass == s3.StorageClassGlacier || storageClass == s3.StorageClassDeepArchive
+}
+
+// Returns true if a restored copy of the archived object can be read
+func restored(obj *Object) bool {
+	return strings.HasPrefix(obj.Header.Get("X-Amz-Restore"), `ongoing-request="false"`)
+}
+
+// Creates an object from the request headers which are stored with the object
+func (s *Server) newObject(req *Request, body []byte) *Object {
+	obj := &Object{Key: req.Key, Body: body, LastModified: req.Time, Header: objectHeader(req.Header), Tags: map[string]string{}}
+	delete(s.deleted[req.Bucket], req.Key)
+	if encryption := s.objectEncryption(req.Bucket, req.Header); encryption != "" {
+		obj.Header.Set("X-Amz-Server-Side-Encryption", encryption)
+	}
+	obj.ETag = encryptedETag(obj.Header.Get("X-Amz-Server-Side-Encryption"), body)
+	if tagging := req.Header.Get("X-Amz-Tagging"); tagging != "" {
+		values, _ := url.ParseQuery(tagging)
+		for k := range values {
+			obj.Tags[k] = values.Get(k)
+		}
+	}
+	obj.LegalHold = req.Header.Get("X-Amz-Object-Lock-Legal-Hold")
+	return obj
+}
+
+// Returns the server side encryption requested by the headers or the default encryption of the bucket
+func (s *Server) objectEncryption(bucket string, header http.Header) string {
+	if encryption := header.Get("X-Amz-Server-Side-Encryption"); encryption != "" {
+		return encryption
+	}
+	return s.encryption[bucket]
+}
+
+// Returns a deleted object which is still returned by the request, counting the request against the delete lag
+func (s *Server) deletedObject(bucket string, key string) (*Object, bool) {
+	deleted, ok := s.deleted[bucket][key]
+	if !ok {
+		return nil, false
+	}
+	deleted.remaining--
+	if deleted.remaining <= 0 {
+		delete(s.deleted[bucket], key)
+	}
+	return deleted.obj, true
+}
+
+// Returns the headers of the request which are stored with an object
+func objectHeader(header http.Header) http.Header {
+	stored := http.Header{}
+	for k, v := range header {
+		canonical := http.CanonicalHeaderKey(k)
+		switch {
+		case strings.HasPrefix(canonical, "X-Amz-Meta-"),
+			canonical == "Content-Type", canonical == "Content-Encoding", canonical == "Content-Disposition",
+			canonical == "Cache-Control", canonical == "X-Amz-Storage-Class", canonical == "X-Amz-Server-Side-Encryption",
+			canonical == "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", canonical == "X-Amz-Website-Redirect-Location",
+			canonical == "X-Amz-Acl", canonical == "X-Amz-Checksum-Algorithm", canonical == "X-Amz-Sdk-Checksum-Algorithm",
+			canonical == "X-Amz-Checksum-Crc32c", canonical == "X-Amz-Checksum-Sha256":
+			stored[canonical] = v
+		}
+	}
+	return stored
+}
+
+func writeObject(w http.ResponseWriter, r *http.Request, obj *Object) {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != obj.ETag {
+		writeError(w, &Error{http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold"})
+		return
+	}
+	for k, v := range obj.Header {
+		w.Header()[k] = v
+	}
+	if len(obj.Tags) > 0 {
+		w.Header().Set("X-Amz-Tagging-Count", strconv.Itoa(len(obj.Tags)))
+	}
+	w.Header().Set("ETag", obj.ETag)
+	w.Header().Set("Last-Modified", obj.LastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	body := obj.Body
+	status := http.StatusOK
+	size := int64(len(obj.Body))
+	if partNumber, err := strconv.ParseInt(r.URL.Query().Get("partNumber"), 10, 64); err == nil && len(obj.Parts) > 0 {
+		// A part of an object uploaded with a multipart upload, at the offset of the parts before it
+		var start int64
+		var part *Part
+		for i := range obj.Parts {
+			if obj.Parts[i].PartNumber == partNumber {
+				part = &obj.Parts[i]
+				break
+			}
+			start += int64(len(obj.Parts[i].Body))
+		}
+		if part == nil {
+			writeError(w, &Error{http.StatusRequestedRangeNotSatisfiable, "InvalidPartNumber", "The requested partnumber is not satisfiable"})
+			return
+		}
+		body = part.Body
+		status = http.StatusPartialContent
+		w.Header().Set("X-Amz-Mp-Parts-Count", strconv.Itoa(len(obj.Parts)))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+int64(len(body))-1, size))
+	} else if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		start, end, ok := parseRange(rangeHeader, size)
+		if !ok {
+			writeError(w, &Error{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable"})
+			return
+		}
+		body = obj.Body[start : end+1]
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+// Parses a single byte range, e.g. bytes=0-99, bytes=100- or bytes=-100, returning the inclusive start and end
+func parseRange(rangeHeader string, size int64) (int64, int64, bool) {
+	spec := strings.TrimPrefix(rangeHeader, "bytes=")
+	bounds := strings.SplitN(spec, "-", 2)
+	if len(bounds) != 2 || size == 0 {
+		return 0, 0, false
+	}
+
+	var start, end int64
+	var err error
+	switch {
+	case bounds[0] == "":
+		suffix, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		start, end = size-suffix, size-1
+	case bounds[1] == "":
+		start, err = strconv.ParseInt(bounds[0], 10, 64)
+		end = size - 1
+	default:
+		start, err = strconv.ParseInt(bounds[0], 10, 64)
+		if err == nil {
+			end, err = strconv.ParseInt(bounds[1], 10, 64)
+		}
+	}
+
+	if err != nil || start >= size || start > end {
+		return 0, 0, false
+	}
+	if end >= size {
+		end = size - 1
+	}
+	return start, end, true
+}
+
+type listEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, req *Request, objects map[string]*Object) {
+	prefix := req.Query.Get("prefix")
+	delimiter := req.Query.Get("delimiter")
+	marker := req.Query.Get("marker")
+	if req.Operation == "ListObjectsV2" {
+		marker = req.Query.Get("continuation-token")
+		if marker == "" {
+			marker = req.Query.Get("start-after")
+		}
+	}
+	maxKeys := 1000
+	if value, err := strconv.Atoi(req.Query.Get("max-keys")); err == nil {
+		maxKeys = value
+	}
+
+	keys := []string{}
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range s.deleted[req.Bucket] {
+		if _, ok := objects[key]; !ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	contents := []listEntry{}
+	prefixes := []commonPrefix{}
+	seenPrefixes := make(map[string]bool)
+	truncated := false
+	lastKey := ""
+
+	for _, key := range keys {
+		if key <= marker {
+			continue
+		}
+
+		entry := key
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry = key[:len(prefix)+i+len(delimiter)]
+				if seenPrefixes[entry] {
+					continue
+				}
+			}
+		}
+
+		if len(contents)+len(prefixes) >= maxKeys {
+			truncated = true
+			break
+		}
+
+		if entry != key {
+			seenPrefixes[entry] = true
+			prefixes = append(prefixes, commonPrefix{entry})
+			lastKey = key
+			continue
+		}
+
+		obj, ok := objects[key]
+		if !ok {
+			obj, _ = s.deletedObject(req.Bucket, key)
+		}
+		storageClass := obj.Header.Get("X-Amz-Storage-Class")
+		if storageClass == "" {
+			storageClass = s3.StorageClassStandard
+		}
+		contents = append(contents, listEntry{
+			Key:          key,
+			LastModified: obj.LastModified.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         obj.ETag,
+			Size:         len(obj.Body),
+			StorageClass: storageClass,
+		})
+		lastKey = key
+	}
+
+	if req.Operation == "ListObjectsV2" {
+		result := struct {
+			XMLName               xml.Name `xml:"ListBucketResult"`
+			Name                  string
+			Prefix                string
+			Delimiter             string `xml:",omitempty"`
+			KeyCount              int
+			MaxKeys               int
+			IsTruncated           bool
+			ContinuationToken     string `xml:",omitempty"`
+			NextContinuationToken string `xml:",omitempty"`
+			Contents              []listEntry
+			CommonPrefixes        []commonPrefix
+		}{Name: req.Bucket, Prefix: prefix, Delimiter: delimiter, KeyCount: len(contents) + len(prefixes), MaxKeys: maxKeys,
+			IsTruncated: truncated, ContinuationToken: req.Query.Get("continuation-token"), Contents: contents, CommonPrefixes: prefixes}
+		if truncated {
+			result.NextContinuationToken = lastKey
+		}
+		writeXML(w, http.StatusOK, result)
+		return
+	}
+
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Name           string
+		Prefix         string
+		Marker         string
+		Delimiter      string `xml:",omitempty"`
+		MaxKeys        int
+		IsTruncated    bool
+		NextMarker     string `xml:",omitempty"`
+		Contents       []listEntry
+		CommonPrefixes []commonPrefix
+	}{Name: req.Bucket, Prefix: prefix, Marker: marker, Delimiter: delimiter, MaxKeys: maxKeys, IsTruncated: truncated,
+		Contents: contents, CommonPrefixes: prefixes}
+	if truncated {
+		result.NextMarker = lastKey
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, req *Request, objects map[string]*Object) {
+	source, _ := url.PathUnescape(req.Header.Get("X-Amz-Copy-Source"))
+	sourcePath := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)
+	if len(sourcePath) != 2 {
+		writeError(w, &Error{http.StatusBadRequest, "InvalidArgument", "Invalid copy source"})
+		return
+	}
+
+	sourceObj, ok := s.buckets[sourcePath[0]][sourcePath[1]]
+	if !ok {
+		writeError(w, &Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."})
+		return
+	}
+
+	obj := s.newObject(req, append([]byte{}, sourceObj.Body...))
+	if req.Header.Get("X-Amz-Metadata-Directive") != s3.MetadataDirectiveReplace {
+		for k, v := range sourceObj.Header {
+			if _, set := obj.Header[k]; !set || strings.HasPrefix(k, "X-Amz-Meta-") || k == "Content-Type" {
+				obj.Header[k] = v
+			}
+		}
+	}
+	if req.Header.Get("X-Amz-Tagging-Directive") != s3.TaggingDirectiveReplace {
+		for k, v := range sourceObj.Tags {
+			obj.Tags[k] = v
+		}
+	}
+	obj.ETag = sourceObj.ETag
+	objects[req.Key] = obj
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag: obj.ETag, LastModified: obj.LastModified.UTC().Format("2006-01-02T15:04:05.000Z")})
+}
+
+type tag struct {
+	Key   string
+	Value string
+}
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  []tag    `xml:"TagSet>Tag"`
+}
+
+func (s *Server) objectTagging(w http.ResponseWriter, req *Request, objects map[string]*Object) {
+	obj, ok := objects[req.Key]
+	if !ok {
+		writeError(w, &Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."})
+		return
+	}
+
+	switch req.Operation {
+	case "PutObjectTagging":
+		var body tagging
+		if err := xml.Unmarshal(req.Body, &body); err != nil {
+			writeError(w, &Error{http.StatusBadRequest, "MalformedXML", err.Error()})
+			return
+		}
+		obj.Tags = map[string]string{}
+		for _, t := range body.TagSet {
+			obj.Tags[t.Key] = t.Value
+		}
+		w.WriteHeader(http.StatusOK)
+	case "DeleteObjectTagging":
+		obj.Tags = map[string]string{}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		body := tagging{TagSet: []tag{}}
+		for k, v := range obj.Tags {
+			body.TagSet = append(body.TagSet, tag{k, v})
+		}
+		sort.Slice(body.TagSet, func(i, j int) bool { return body.TagSet[i].Key < body.TagSet[j].Key })
+		writeXML(w, http.StatusOK, body)
+	}
+}
+
+type legalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Status  string
+}
+
+func (s *Server) objectLegalHold(w http.ResponseWriter, req *Request, objects map[string]*Object) {
+	obj, ok := objects[req.Key]
+	if !ok {
+		writeError(w, &Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."})
+		return
+	}
+
+	if req.Operation == "PutObjectLegalHold" {
+		var body legalHold
+		if err := xml.Unmarshal(req.Body, &body); err != nil {
+			writeError(w, &Error{http.StatusBadRequest, "MalformedXML", err.Error()})
+			return
+		}
+		obj.LegalHold = body.Status
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	status := obj.LegalHold
+	if status == "" {
+		status = s3.ObjectLockLegalHoldStatusOff
+	}
+	writeXML(w, http.StatusOK, legalHold{Status: status})
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, req *Request, objects map[string]*Object) {
+	uploadID := req.Query.Get("uploadId")
+	upload, ok := s.uploads[uploadID]
+	if !ok {
+		writeError(w, &Error{http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist"})
+		return
+	}
+
+	var body struct {
+		Parts []struct {
+			PartNumber     int64
+			ETag           string
+			ChecksumCRC32C string
+			ChecksumSHA256 string
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(req.Body, &body); err != nil || len(body.Parts) == 0 {
+		writeError(w, &Error{http.StatusBadRequest, "MalformedXML", "The XML provided was not well-formed"})
+		return
+	}
+
+	data := []byte{}
+	digests := []byte{}
+	parts := []Part{}
+	for i, completed := range body.Parts {
+		if i > 0 && completed.PartNumber <= body.Parts[i-1].PartNumber {
+			writeError(w, &Error{http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order"})
+			return
+		}
+		part, ok := upload.parts[completed.PartNumber]
+		if !ok || part.ETag != completed.ETag {
+			writeError(w, &Error{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found"})
+			return
+		}
+		// Every part of an upload created with a checksum algorithm must be completed with its checksum
+		if header := uploadChecksumHeader(upload); header != "" {
+			completedChecksum := map[string]string{"X-Amz-Checksum-Crc32c": completed.ChecksumCRC32C, "X-Amz-Checksum-Sha256": completed.ChecksumSHA256}[header]
+			if part.Checksum == "" || part.Checksum != completedChecksum {
+				writeError(w, &Error{http.StatusBadRequest, "InvalidPart", "The checksum of one or more of the specified parts does not match"})
+				return
+			}
+		}
+		sum := md5.Sum(part.Body)
+		digests = append(digests, sum[:]...)
+		data = append(data, part.Body...)
+		parts = append(parts, part)
+	}
+
+	obj := s.newObject(&Request{Bucket: upload.bucket, Key: upload.key, Header: upload.header, Time: req.Time}, data)
+	composite := md5.Sum(digests)
+	obj.ETag = fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(composite[:]), len(parts))
+	if obj.Header.Get("X-Amz-Server-Side-Encryption") == s3.ServerSideEncryptionAwsKms {
+		composite = md5.Sum(append([]byte("aws:kms"), digests...))
+		obj.ETag = fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(composite[:]), len(parts))
+	}
+	if header := uploadChecksumHeader(upload); header != "" {
+		obj.Header.Set(header, compositeChecksum(header, parts))
+	}
+	obj.Parts = parts
+	objects[upload.key] = obj
+	delete(s.uploads, uploadID)
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{Location: s.server.URL + "/" + upload.bucket + "/" + upload.key, Bucket: upload.bucket, Key: upload.key, ETag: obj.ETag})
+}
+
+func (s *Server) listParts(w http.ResponseWriter, req *Request) {
+	upload, ok := s.uploads[req.Query.Get("uploadId")]
+	if !ok {
+		writeError(w, &Error{http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist"})
+		return
+	}
+
+	type listPart struct {
+		PartNumber   int64
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	parts := []listPart{}
+	for _, part := range upload.parts {
+		parts = append(parts, listPart{part.PartNumber, upload.time.UTC().Format("2006-01-02T15:04:05.000Z"), part.ETag, len(part.Body)})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName     xml.Name `xml:"ListPartsResult"`
+		Bucket      string
+		Key         string
+		UploadId    string
+		IsTruncated bool
+		Parts       []listPart `xml:"Part"`
+	}{Bucket: upload.bucket, Key: upload.key, UploadId: req.Query.Get("uploadId"), Parts: parts})
+}
+
+func (s *Server) listMultipartUploads(w http.ResponseWriter, req *Request) {
+	type listUpload struct {
+		Key       string
+		UploadId  string
+		Initiated string
+	}
+	uploads := []listUpload{}
+	for uploadID, upload := range s.uploads {
+		if upload.bucket == req.Bucket && strings.HasPrefix(upload.key, req.Query.Get("prefix")) {
+			uploads = append(uploads, listUpload{upload.key, uploadID, upload.time.UTC().Format("2006-01-02T15:04:05.000Z")})
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].UploadId < uploads[j].UploadId })
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName     xml.Name `xml:"ListMultipartUploadsResult"`
+		Bucket      string
+		IsTruncated bool
+		Uploads     []listUpload `xml:"Upload"`
+	}{Bucket: req.Bucket, Uploads: uploads})
+}
+
+func writeXML(w http.ResponseWriter, statusCode int, v interface{}) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		writeError(w, &Error{http.StatusInternalServerError, "InternalError", err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	w.Write(append([]byte(xml.Header), body...))
+}
+
+func writeError(w http.ResponseWriter, e *Error) {
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: e.Code, Message: e.Message})
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.StatusCode)
+	w.Write(body)
+}
+
+// Returns the quoted md5 ETag of the body as returned by S3 for a single part upload
+func md5ETag(body []byte) string {
+	sum := md5.Sum(body)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
@@ -0,0 +1,168 @@
+package status
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Phases of a run recorded in the status file
+const (
+	PhaseStarting    = "starting"
+	PhaseUploading   = "uploading"
+	PhaseRotating    = "rotating"
+	PhaseDownloading = "downloading"
+	PhaseRunning     = "running" // Any other action
+)
+
+// Status is the progress of a run written to the status file as JSON
+type Status struct {
+	PID        int       `json:"pid"`
+	Action     string    `json:"action"`
+	Phase      string    `json:"phase"`
+	BytesDone  int64     `json:"bytesDone"`
+	TotalBytes int64     `json:"totalBytes"`
+	StartedAt  time.Time `json:"startedAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// Reporter writes the PID file and periodically rewrites the status file until it is stopped.
+// Every method is safe to call on a nil reporter so callers don't need to check whether reporting is enabled
+type Reporter struct {
+	statusFile string
+	pidFile    string
+	mu         sync.Mutex
+	status     Status
+	stopCh     chan bool
+	doneCh     chan bool
+	stopOnce   sync.Once
+}
+
+// Start writes the PID file and the status file, and rewrites the status file at the interval so that monitoring can
+// read the progress of a long run. Either file may be empty to disable it
+func Start(statusFile string, pidFile string, action string, interval time.Duration) (*Reporter, error) {
+	r := &Reporter{
+		statusFile: statusFile,
+		pidFile:    pidFile,
+		status: Status{
+			PID:       os.Getpid(),
+			Action:    action,
+			Phase:     PhaseStarting,
+			StartedAt: time.Now().UTC(),
+		},
+		stopCh: make(chan bool),
+		doneCh: make(chan bool),
+	}
+
+	if pidFile != "" {
+		if err := writeFile(pidFile, []byte(strconv.Itoa(r.status.PID)+"\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.write(); err != nil {
+		os.Remove(pidFile)
+		return nil, err
+	}
+
+	go func() {
+		defer close(r.doneCh)
+		for {
+			select {
+			case <-r.stopCh:
+				return
+			case <-time.After(interval):
+				r.write()
+			}
+		}
+	}()
+
+	return r, nil
+}
+
+// SetPhase records the phase of the run and rewrites the status file immediately. The progress is reset
+func (r *Reporter) SetPhase(phase string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.status.Phase = phase
+	r.status.BytesDone = 0
+	r.status.TotalBytes = 0
+	r.mu.Unlock()
+
+	r.write()
+}
+
+// SetProgress records the progress of the current phase, which is written to the status file at the next interval.
+// It matches the ProgressFn of an upload object
+func (r *Reporter) SetProgress(bytesDone int64, totalBytes int64) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.status.BytesDone = bytesDone
+	r.status.TotalBytes = totalBytes
+	r.mu.Unlock()
+}
+
+// Stop stops rewriting the status file and removes the status file and PID file. Only the first call has any effect
+func (r *Reporter) Stop() {
+	if r == nil {
+		return
+	}
+
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+		<-r.doneCh
+
+		if r.statusFile != "" {
+			os.Remove(r.statusFile)
+		}
+		if r.pidFile != "" {
+			os.Remove(r.pidFile)
+		}
+	})
+}
+
+func (r *Reporter) write() error {
+	if r.statusFile == "" {
+		return nil
+	}
+
+	r.mu.Lock()
+	r.status.UpdatedAt = time.Now().UTC()
+	body, err := json.Marshal(r.status)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return writeFile(r.statusFile, body)
+}
+
+// Writes the file by renaming a temporary file over it so that readers never see a partially written file
+func writeFile(path string, body []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(body)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+	}
+	return err
+}
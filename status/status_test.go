@@ -0,0 +1,159 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"s3backup/log"
+	"s3backup/s3mock"
+	"s3backup/upload"
+	"s3backup/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test variables
+var mockBucket string
+var testFileSize int64
+
+// Setup testing
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	mockBucket = "mock-status-bucket"
+	testFileSize = int64(12 * 1024 * 1024) // 12MiB is 3 parts with a part size of 5MiB
+}
+
+//----------------------------------------------
+// Status File Testing (mock S3)
+//	1: The status file is updated with the progress of a transfer and removed when stopped
+//	2: The PID file records the process id and is removed when stopped
+//	3: A nil reporter does nothing
+//
+//----------------------------------------------
+
+// Test 1 - Status File Testing
+//	The status file is updated with the progress of a transfer and removed when stopped
+func TestStatusFileUpdatedDuringTransfer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pathToFile := filepath.Join(dir, "statusTestFile")
+	err = util.CreateFile(pathToFile, []byte(strings.Repeat("0123456789abcdef", int(testFileSize/16))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statusFile := filepath.Join(dir, "status.json")
+
+	reporter, err := Start(statusFile, "", "upload", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the reporter to start: %v", err))
+	}
+	reporter.SetPhase(PhaseUploading)
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	// Read the status file while the last part is in flight, after the reporter has had time to rewrite it
+	var mu sync.Mutex
+	var duringTransfer Status
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "UploadPart" && req.Query.Get("partNumber") == "3" {
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			readStatusFile(t, statusFile, &duringTransfer)
+		}
+		return nil
+	})
+
+	uploadObject := upload.UploadObject{
+		PathToFile: pathToFile,
+		S3FileName: "statusTestFile",
+		Bucket:     mockBucket,
+		Timeout:    time.Minute,
+		NumWorkers: 1,
+		PartSize:   5,
+		ProgressFn: reporter.SetProgress,
+	}
+	_, err = upload.UploadFile(mockS3.Client(), uploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the upload to pass: %v", err))
+	}
+
+	mu.Lock()
+	if duringTransfer.Phase != PhaseUploading || duringTransfer.Action != "upload" {
+		t.Error(fmt.Sprintf("expected the status file to record the uploading phase of the upload action, got %+v", duringTransfer))
+	}
+	if duringTransfer.BytesDone <= 0 || duringTransfer.BytesDone > testFileSize || duringTransfer.TotalBytes != testFileSize {
+		t.Error(fmt.Sprintf("expected the status file to record the progress of the transfer, got %d of %d bytes", duringTransfer.BytesDone, duringTransfer.TotalBytes))
+	}
+	if duringTransfer.StartedAt.IsZero() || duringTransfer.UpdatedAt.Before(duringTransfer.StartedAt) {
+		t.Error(fmt.Sprintf("expected the status file to record when the run started and was updated, got %+v", duringTransfer))
+	}
+	mu.Unlock()
+
+	reporter.Stop()
+
+	if _, err := os.Stat(statusFile); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("expected the status file to be removed when stopped: %v", err))
+	}
+}
+
+// Test 2 - Status File Testing
+//	The PID file records the process id and is removed when stopped
+func TestPidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pidFile := filepath.Join(dir, "s3backup.pid")
+
+	reporter, err := Start("", pidFile, "rotate", time.Hour)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the reporter to start: %v", err))
+	}
+
+	contents, err := ioutil.ReadFile(pidFile)
+	if err != nil || strings.TrimSpace(string(contents)) != strconv.Itoa(os.Getpid()) {
+		t.Error(fmt.Sprintf("expected the PID file to contain %d, got '%s': %v", os.Getpid(), contents, err))
+	}
+
+	reporter.Stop()
+	reporter.Stop()
+
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("expected the PID file to be removed when stopped: %v", err))
+	}
+}
+
+// Test 3 - Status File Testing
+//	A nil reporter does nothing
+func TestNilReporter(t *testing.T) {
+	var reporter *Reporter
+
+	reporter.SetPhase(PhaseRotating)
+	reporter.SetProgress(1, 2)
+	reporter.Stop()
+}
+
+func readStatusFile(t *testing.T, statusFile string, status *Status) {
+	body, err := ioutil.ReadFile(statusFile)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected the status file to exist during the run: %v", err))
+		return
+	}
+	if err = json.Unmarshal(body, status); err != nil {
+		t.Error(fmt.Sprintf("expected the status file to be valid JSON: %v", err))
+	}
+}
@@ -0,0 +1,174 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The path spans are posted to on an OTLP/HTTP endpoint
+const otlpTracesPath = "/v1/traces"
+
+// OTLP span kind and status codes
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusCodeOk     = 1
+	otlpStatusCodeError  = 2
+)
+
+// OTLPExporter exports spans to an OpenTelemetry collector with OTLP/HTTP using the JSON encoding
+type OTLPExporter struct {
+	url            string
+	serviceName    string
+	serviceVersion string
+	client         *http.Client
+}
+
+// NewOTLPExporter returns an exporter which posts spans to the endpoint, e.g. http://collector:4318. The traces path is
+// appended unless the endpoint already ends with it. The spans are reported as the service with the version
+func NewOTLPExporter(endpoint string, serviceName string, serviceVersion string) *OTLPExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, otlpTracesPath) {
+		url += otlpTracesPath
+	}
+	return &OTLPExporter{url: url, serviceName: serviceName, serviceVersion: serviceVersion, client: &http.Client{Timeout: time.Second * 10}}
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// Exactly one field is set. 64 bit integers are encoded as strings in OTLP JSON
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// ExportSpans posts the spans to the collector in a single request
+func (e *OTLPExporter) ExportSpans(spans []SpanData) error {
+	scopeSpans := otlpScopeSpans{Scope: otlpScope{Name: e.serviceName, Version: e.serviceVersion}}
+	for _, span := range spans {
+		scopeSpans.Spans = append(scopeSpans.Spans, toOTLPSpan(span))
+	}
+
+	request := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{toOTLPAttribute("service.name", e.serviceName)}},
+		ScopeSpans: []otlpScopeSpans{scopeSpans},
+	}}}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to export %d spans to '%s': %s", len(spans), e.url, resp.Status)
+	}
+	return nil
+}
+
+func toOTLPSpan(span SpanData) otlpSpan {
+	converted := otlpSpan{
+		TraceID:           span.TraceID,
+		SpanID:            span.SpanID,
+		ParentSpanID:      span.ParentSpanID,
+		Name:              span.Name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusCodeOk},
+	}
+	if span.Error != "" {
+		converted.Status = otlpStatus{Code: otlpStatusCodeError, Message: span.Error}
+	}
+
+	// Sorted so that the same span is always encoded identically
+	keys := []string{}
+	for key := range span.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		converted.Attributes = append(converted.Attributes, toOTLPAttribute(key, span.Attributes[key]))
+	}
+	return converted
+}
+
+func toOTLPAttribute(key string, value interface{}) otlpAttribute {
+	attribute := otlpAttribute{Key: key}
+	switch v := value.(type) {
+	case string:
+		attribute.Value.StringValue = &v
+	case int:
+		i := strconv.Itoa(v)
+		attribute.Value.IntValue = &i
+	case int64:
+		i := strconv.FormatInt(v, 10)
+		attribute.Value.IntValue = &i
+	case float64:
+		attribute.Value.DoubleValue = &v
+	case bool:
+		attribute.Value.BoolValue = &v
+	default:
+		s := fmt.Sprint(v)
+		attribute.Value.StringValue = &s
+	}
+	return attribute
+}
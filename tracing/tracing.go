@@ -0,0 +1,178 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// SpanData is a span which has ended, as passed to an exporter
+type SpanData struct {
+	Name         string
+	TraceID      string // Hex encoded 16 byte ID shared by every span of the tracer
+	SpanID       string // Hex encoded 8 byte ID
+	ParentSpanID string // Empty for the root span
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]interface{} // Values are strings, ints, int64s, float64s or bools
+	Error        string                 // Set if the operation of the span failed
+}
+
+// Exporter sends ended spans to a tracing backend
+type Exporter interface {
+	ExportSpans(spans []SpanData) error
+}
+
+// Tracer records spans for a single run and exports them when it is flushed. Every method of the tracer and of its
+// spans is safe to call on nil, so tracing is a no-op unless a tracer has been created with an exporter
+type Tracer struct {
+	exporter Exporter
+	traceID  string
+	mu       sync.Mutex
+	open     map[*Span]bool
+	ended    []SpanData
+}
+
+// Span is a timed operation of the run, e.g. the upload. A span is only exported once it has ended
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// NewTracer returns a tracer which exports its spans with the exporter. Returns nil, a no-op tracer, if the exporter is nil
+func NewTracer(exporter Exporter) *Tracer {
+	if exporter == nil {
+		return nil
+	}
+	return &Tracer{exporter: exporter, traceID: newID(16), open: make(map[*Span]bool)}
+}
+
+// Start starts a span which is a child of the parent, or the root span of the trace if the parent is nil
+func (t *Tracer) Start(name string, parent *Span) *Span {
+	if t == nil {
+		return nil
+	}
+
+	span := &Span{tracer: t, data: SpanData{
+		Name:       name,
+		TraceID:    t.traceID,
+		SpanID:     newID(8),
+		StartTime:  time.Now(),
+		Attributes: map[string]interface{}{},
+	}}
+	if parent != nil {
+		span.data.ParentSpanID = parent.data.SpanID
+	}
+
+	t.mu.Lock()
+	t.open[span] = true
+	t.mu.Unlock()
+	return span
+}
+
+// Flush ends every span which is still open, such as those of a run which is exiting early, and exports every ended
+// span which has not already been exported
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	open := []*Span{}
+	for span := range t.open {
+		open = append(open, span)
+	}
+	t.mu.Unlock()
+
+	for _, span := range open {
+		span.End()
+	}
+
+	t.mu.Lock()
+	spans := t.ended
+	t.ended = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.exporter.ExportSpans(spans)
+}
+
+// SetAttribute records the attribute on the span, e.g. the bucket or the number of bytes uploaded. Attributes set
+// after the span has ended are ignored
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+// RecordError marks the operation of the span as failed. A nil error is ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Error = err.Error()
+	}
+}
+
+// End ends the span so that it is exported by the next flush. Ending a span again has no effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	delete(s.tracer.open, s)
+	s.tracer.ended = append(s.tracer.ended, data)
+}
+
+// InMemoryExporter keeps every exported span in memory, e.g. to assert which spans a run produces
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// ExportSpans appends the spans to the exported spans
+func (e *InMemoryExporter) ExportSpans(spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// Spans returns every span exported so far in the order the spans ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData{}, e.spans...)
+}
+
+// Returns a random hex encoded ID of the size (bytes)
+func newID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
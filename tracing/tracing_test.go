@@ -0,0 +1,160 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//----------------------------------------------
+//
+//             Span Tests
+//
+//----------------------------------------------
+
+// Every method is a no-op without an exporter
+func TestNilTracer(t *testing.T) {
+	tracer := NewTracer(nil)
+	if tracer != nil {
+		t.Fatal("expected a nil tracer without an exporter")
+	}
+
+	span := tracer.Start("run", nil)
+	span.SetAttribute("bucket", "mybucket")
+	span.RecordError(errors.New("failed"))
+	span.End()
+
+	if err := tracer.Flush(); err != nil {
+		t.Error(fmt.Sprintf("expected flushing a nil tracer to do nothing: %v", err))
+	}
+}
+
+// Child spans share the trace of the root span and reference it as their parent
+func TestSpanTree(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	root := tracer.Start("run", nil)
+	upload := tracer.Start("upload", root)
+	upload.SetAttribute("bytes", int64(42))
+	upload.RecordError(errors.New("upload failed"))
+	upload.End()
+	root.End()
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 2 || spans[0].Name != "upload" || spans[1].Name != "run" {
+		t.Fatal(fmt.Sprintf("expected the upload and run spans to be exported in the order they ended: %v", spans))
+	}
+	if spans[0].TraceID != spans[1].TraceID || len(spans[0].TraceID) != 32 {
+		t.Error("expected both spans to share a 16 byte trace ID")
+	}
+	if spans[0].ParentSpanID != spans[1].SpanID || spans[1].ParentSpanID != "" {
+		t.Error("expected the upload span to be a child of the root span")
+	}
+	if spans[0].Attributes["bytes"] != int64(42) || spans[0].Error != "upload failed" {
+		t.Error(fmt.Sprintf("expected the attributes and error of the upload span: %v %s", spans[0].Attributes, spans[0].Error))
+	}
+	if spans[0].EndTime.Before(spans[0].StartTime) {
+		t.Error("expected the span to end after it started")
+	}
+}
+
+// Flushing ends the spans which are still open and spans are only exported once
+func TestFlushEndsOpenSpans(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	root := tracer.Start("run", nil)
+	tracer.Start("rotate", root)
+
+	tracer.Flush()
+	root.End()
+	root.SetAttribute("ignored", true)
+	tracer.Flush()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatal(fmt.Sprintf("expected both open spans to be exported once: %v", spans))
+	}
+	for _, span := range spans {
+		if span.EndTime.IsZero() || span.Attributes["ignored"] != nil {
+			t.Error(fmt.Sprintf("expected span '%s' to be ended by the flush", span.Name))
+		}
+	}
+}
+
+//----------------------------------------------
+//
+//             OTLP Exporter Tests
+//
+//----------------------------------------------
+
+// Spans are posted to the traces path of the endpoint with the OTLP JSON encoding
+func TestOTLPExporter(t *testing.T) {
+	var path string
+	var request map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &request)
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(NewOTLPExporter(collector.URL, "s3backup", "1.2.3"))
+	root := tracer.Start("run", nil)
+	upload := tracer.Start("upload", root)
+	upload.SetAttribute("bucket", "mybucket")
+	upload.SetAttribute("bytes", int64(1024))
+	upload.RecordError(errors.New("upload failed"))
+	upload.End()
+	root.End()
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatal(fmt.Sprintf("expected to export the spans without any error: %v", err))
+	}
+	if path != "/v1/traces" {
+		t.Error(fmt.Sprintf("expected the spans to be posted to /v1/traces but got: %s", path))
+	}
+
+	var decoded otlpRequest
+	encoded, _ := json.Marshal(request)
+	json.Unmarshal(encoded, &decoded)
+
+	spans := decoded.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Name != "upload" || spans[0].ParentSpanID != spans[1].SpanID {
+		t.Fatal(fmt.Sprintf("expected the upload span to be exported as a child of the run span: %s", encoded))
+	}
+	if spans[0].Status.Code != otlpStatusCodeError || spans[0].Status.Message != "upload failed" || spans[1].Status.Code != otlpStatusCodeOk {
+		t.Error(fmt.Sprintf("expected only the upload span to have an error status: %s", encoded))
+	}
+	if len(spans[0].Attributes) != 2 || spans[0].Attributes[0].Key != "bucket" || *spans[0].Attributes[0].Value.StringValue != "mybucket" ||
+		*spans[0].Attributes[1].Value.IntValue != "1024" {
+		t.Error(fmt.Sprintf("expected the attributes of the upload span: %s", encoded))
+	}
+	if resource := decoded.ResourceSpans[0].Resource.Attributes; len(resource) != 1 || *resource[0].Value.StringValue != "s3backup" {
+		t.Error(fmt.Sprintf("expected the service name to be exported as a resource attribute: %s", encoded))
+	}
+}
+
+// Export fails when the collector rejects the spans
+func TestOTLPExporterRejected(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(NewOTLPExporter(collector.URL+"/v1/traces", "s3backup", ""))
+	tracer.Start("run", nil).End()
+
+	if err := tracer.Flush(); err == nil {
+		t.Error("expected the export to fail when the collector rejects the spans")
+	}
+}
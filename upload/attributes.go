@@ -0,0 +1,193 @@
+package upload
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/log"
+	"s3backup/s3client"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Sets the attributes of the upload object on the upload input. The uploader sends the same attributes with both
+// PutObject and CreateMultipartUpload so that single part and multipart uploads create objects with the same attributes
+func applyObjectAttributes(uploadParams *s3manager.UploadInput, uploadObject UploadObject) {
+	if len(uploadObject.Tags) > 0 {
+		uploadParams.Tagging = aws.String(encodeTags(uploadObject.Tags))
+	}
+
+	if uploadObject.ACL != "" {
+		uploadParams.ACL = aws.String(uploadObject.ACL)
+	}
+
+	if uploadObject.StorageClass != "" {
+		uploadParams.StorageClass = aws.String(uploadObject.StorageClass)
+	}
+
+	if uploadObject.WebsiteRedirectLocation != "" {
+		uploadParams.WebsiteRedirectLocation = aws.String(uploadObject.WebsiteRedirectLocation)
+	}
+
+	if uploadObject.ServerSideEncryption != "" {
+		uploadParams.ServerSideEncryption = aws.String(uploadObject.ServerSideEncryption)
+	}
+
+	if uploadObject.KMSKeyID != "" {
+		uploadParams.SSEKMSKeyId = aws.String(uploadObject.KMSKeyID)
+	}
+
+	if uploadObject.ObjectLockLegalHoldStatus != "" {
+		log.Info.Printf("Setting legal hold status '%s' on key: '%s'\n", uploadObject.ObjectLockLegalHoldStatus, aws.StringValue(uploadParams.Key))
+		uploadParams.ObjectLockLegalHoldStatus = aws.String(uploadObject.ObjectLockLegalHoldStatus)
+	}
+}
+
+// Some S3 compatible providers ignore the attributes sent with CreateMultipartUpload and only apply them to single part
+// uploads. Checks the attributes of the completed object against the upload input and applies any that are missing.
+// Metadata can only be replaced by copying the object onto itself, which also replaces every other attribute at once.
+// Otherwise missing tags are applied with PutObjectTagging, and the ACL is always applied as it cannot be compared
+func finalizeObjectAttributes(svc *s3.S3, uploadParams *s3manager.UploadInput) error {
+	key := aws.StringValue(uploadParams.Key)
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: uploadParams.Bucket,
+		Key:    uploadParams.Key,
+	})
+	if err != nil {
+		return err
+	}
+
+	contentTypeMatches := uploadParams.ContentType == nil || aws.StringValue(head.ContentType) == aws.StringValue(uploadParams.ContentType)
+	if !contentTypeMatches || !metadataMatches(uploadParams.Metadata, head.Metadata) {
+		if aws.Int64Value(head.ContentLength) > s3client.MaxCopyObjectSize {
+			return fmt.Errorf("metadata of key '%s' was not applied by the multipart upload and the object is too large to copy onto itself", key)
+		}
+		if storageClass := aws.StringValue(head.StorageClass); storageClass == s3.StorageClassGlacier || storageClass == s3.StorageClassDeepArchive {
+			return fmt.Errorf("metadata of key '%s' was not applied by the multipart upload and an object in storage class %s cannot be copied onto itself", key, storageClass)
+		}
+
+		log.Warn.Printf("Metadata of key: '%s' was not applied by the multipart upload, copying the object onto itself to apply every attribute\n", key)
+		copyParams := &s3.CopyObjectInput{
+			Bucket:                    uploadParams.Bucket,
+			Key:                       uploadParams.Key,
+			CopySource:                aws.String((&url.URL{Path: aws.StringValue(uploadParams.Bucket) + "/" + key}).EscapedPath()),
+			MetadataDirective:         aws.String(s3.MetadataDirectiveReplace),
+			Metadata:                  uploadParams.Metadata,
+			ContentType:               uploadParams.ContentType,
+			ACL:                       uploadParams.ACL,
+			WebsiteRedirectLocation:   uploadParams.WebsiteRedirectLocation,
+			StorageClass:              uploadParams.StorageClass, // Otherwise the copy is stored in the STANDARD storage class
+			ObjectLockLegalHoldStatus: uploadParams.ObjectLockLegalHoldStatus,
+			ServerSideEncryption:      uploadParams.ServerSideEncryption, // Otherwise the copy is encrypted with the default encryption of the bucket
+			SSEKMSKeyId:               uploadParams.SSEKMSKeyId,
+		}
+		if uploadParams.Tagging != nil {
+			copyParams.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+			copyParams.Tagging = uploadParams.Tagging
+		}
+		_, err = svc.CopyObject(copyParams)
+		return err
+	}
+
+	if uploadParams.Tagging != nil {
+		tags, err := s3client.GetObjectTags(svc, aws.StringValue(uploadParams.Bucket), key)
+		if err != nil {
+			return err
+		}
+
+		if encodeTags(tags) != aws.StringValue(uploadParams.Tagging) {
+			log.Warn.Printf("Tags of key: '%s' were not applied by the multipart upload, applying them with PutObjectTagging\n", key)
+			if err = putObjectTagging(svc, uploadParams); err != nil {
+				return err
+			}
+		}
+	}
+
+	if uploadParams.ACL != nil {
+		log.Info.Printf("Applying ACL '%s' to key: '%s'\n", aws.StringValue(uploadParams.ACL), key)
+		_, err = svc.PutObjectAcl(&s3.PutObjectAclInput{
+			Bucket: uploadParams.Bucket,
+			Key:    uploadParams.Key,
+			ACL:    uploadParams.ACL,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func putObjectTagging(svc *s3.S3, uploadParams *s3manager.UploadInput) error {
+	values, err := url.ParseQuery(aws.StringValue(uploadParams.Tagging))
+	if err != nil {
+		return err
+	}
+
+	tagSet := []*s3.Tag{}
+	for tagKey := range values {
+		tagSet = append(tagSet, &s3.Tag{Key: aws.String(tagKey), Value: aws.String(values.Get(tagKey))})
+	}
+
+	_, err = svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  uploadParams.Bucket,
+		Key:     uploadParams.Key,
+		Tagging: &s3.Tagging{TagSet: tagSet},
+	})
+	return err
+}
+
+// Returns true if every metadata value which was uploaded is present on the object
+func metadataMatches(uploaded map[string]*string, object map[string]*string) bool {
+	objectMetadata := make(map[string]string)
+	for metadataKey, value := range object {
+		objectMetadata[http.CanonicalHeaderKey(metadataKey)] = aws.StringValue(value)
+	}
+
+	for metadataKey, value := range uploaded {
+		if objectValue, ok := objectMetadata[http.CanonicalHeaderKey(metadataKey)]; !ok || objectValue != aws.StringValue(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func validACL(acl string) bool {
+	for _, cannedACL := range s3.ObjectCannedACL_Values() {
+		if acl == cannedACL {
+			return true
+		}
+	}
+	return false
+}
+
+// S3 only accepts a redirect to a path in the same bucket or to an absolute URL
+func validWebsiteRedirectLocation(location string) bool {
+	return strings.HasPrefix(location, "/") || strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// Encodes the tags as URL query parameters sorted by key as expected by the Tagging field of an upload
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for tagKey, tagValue := range tags {
+		values.Set(tagKey, tagValue)
+	}
+	return values.Encode()
+}
+
+// ValidateStorageClass returns an error if the storage class is not one of the storage classes of S3. An empty storage
+// class is valid and leaves the object to the STANDARD storage class
+func ValidateStorageClass(storageClass string) error {
+	if storageClass == "" {
+		return nil
+	}
+	for _, valid := range s3.StorageClass_Values() {
+		if storageClass == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid storage class '%s', expected one of: %s", storageClass, strings.Join(s3.StorageClass_Values(), ", "))
+}
@@ -0,0 +1,211 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/log"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Checksum algorithms which an upload can be verified with instead of the ETag
+const (
+	ChecksumAlgorithmCRC32C = s3.ChecksumAlgorithmCrc32c
+	ChecksumAlgorithmSHA256 = s3.ChecksumAlgorithmSha256
+)
+
+// The SDK sends the checksum of a request but does not calculate it, and the uploader does not send the checksum of
+// each part. Calculates the checksum of the body of every PutObject and UploadPart request made by the uploader and
+// completes the multipart upload with the checksum of each part, recording the checksums so that the completed object
+// can be verified. The body of each request can seek as the uploader buffers every part it sends
+type checksumRecorder struct {
+	algorithm string
+	mu        sync.Mutex
+	whole     string           // Checksum of a single part upload
+	parts     map[int64]string // Checksum of each part of a multipart upload
+}
+
+func newChecksumRecorder(algorithm string) *checksumRecorder {
+	return &checksumRecorder{algorithm: algorithm, parts: make(map[int64]string)}
+}
+
+// Returns the hash of the checksum algorithm
+func newChecksumHash(algorithm string) hash.Hash {
+	if algorithm == ChecksumAlgorithmCRC32C {
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	}
+	return sha256.New()
+}
+
+// Request option which is applied to every request made by the uploader. The checksums are set before the request is
+// built so that they are sent as headers of the request
+func (r *checksumRecorder) requestOption(req *request.Request) {
+	req.Handlers.Build.PushFront(func(req *request.Request) {
+		switch input := req.Params.(type) {
+		case *s3.PutObjectInput:
+			sum, err := r.checksum(input.Body)
+			if err != nil {
+				req.Error = err
+				return
+			}
+			r.setChecksum(&input.ChecksumCRC32C, &input.ChecksumSHA256, sum)
+
+			r.mu.Lock()
+			r.whole = sum
+			r.mu.Unlock()
+		case *s3.UploadPartInput:
+			sum, err := r.checksum(input.Body)
+			if err != nil {
+				req.Error = err
+				return
+			}
+			input.ChecksumAlgorithm = aws.String(r.algorithm)
+			r.setChecksum(&input.ChecksumCRC32C, &input.ChecksumSHA256, sum)
+
+			r.mu.Lock()
+			r.parts[aws.Int64Value(input.PartNumber)] = sum
+			r.mu.Unlock()
+		case *s3.CompleteMultipartUploadInput:
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			for _, part := range input.MultipartUpload.Parts {
+				r.setChecksum(&part.ChecksumCRC32C, &part.ChecksumSHA256, r.parts[aws.Int64Value(part.PartNumber)])
+			}
+		}
+	})
+}
+
+// Returns the base64 checksum of the body and seeks back to the start of the body
+func (r *checksumRecorder) checksum(body io.ReadSeeker) (string, error) {
+	h := newChecksumHash(r.algorithm)
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+func (r *checksumRecorder) setChecksum(crc32c **string, sha **string, sum string) {
+	if r.algorithm == ChecksumAlgorithmCRC32C {
+		*crc32c = aws.String(sum)
+	} else {
+		*sha = aws.String(sum)
+	}
+}
+
+// Returns the checksum the completed object should have. The checksum of a multipart object is the checksum of the
+// checksums of its parts
+func (r *checksumRecorder) expected() (string, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.parts) == 0 {
+		if r.whole == "" {
+			return "", 0, fmt.Errorf("no %s checksum was recorded for the upload", r.algorithm)
+		}
+		return r.whole, 0, nil
+	}
+
+	h := newChecksumHash(r.algorithm)
+	for partNumber := int64(1); partNumber <= int64(len(r.parts)); partNumber++ {
+		sum, ok := r.parts[partNumber]
+		if !ok {
+			return "", 0, fmt.Errorf("no %s checksum was recorded for part %d", r.algorithm, partNumber)
+		}
+		raw, _ := base64.StdEncoding.DecodeString(sum)
+		h.Write(raw)
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), len(r.parts), nil
+}
+
+// Verifies the checksum S3 reports for the uploaded object with GetObjectAttributes against the checksums calculated
+// from the file as it was uploaded. Unlike the ETag the checksum does not depend on how the object is encrypted
+func verifyChecksum(ctx context.Context, svc *s3.S3, uploadParams *s3manager.UploadInput, recorder *checksumRecorder) error {
+	key := aws.StringValue(uploadParams.Key)
+
+	expected, parts, err := recorder.expected()
+	if err != nil {
+		return err
+	}
+
+	attributes, err := svc.GetObjectAttributesWithContext(ctx, &s3.GetObjectAttributesInput{
+		Bucket:           uploadParams.Bucket,
+		Key:              uploadParams.Key,
+		ObjectAttributes: aws.StringSlice([]string{s3.ObjectAttributesChecksum, s3.ObjectAttributesObjectParts}),
+	})
+	if err != nil {
+		return err
+	}
+
+	var actual string
+	if attributes.Checksum != nil {
+		if recorder.algorithm == ChecksumAlgorithmCRC32C {
+			actual = aws.StringValue(attributes.Checksum.ChecksumCRC32C)
+		} else {
+			actual = aws.StringValue(attributes.Checksum.ChecksumSHA256)
+		}
+	}
+	if actual == "" {
+		return fmt.Errorf("no %s checksum was reported for key '%s'", recorder.algorithm, key)
+	}
+
+	// The checksum of a multipart object may be reported with the number of parts as a suffix
+	actual = strings.SplitN(actual, "-", 2)[0]
+	if actual != expected {
+		return fmt.Errorf("%s checksum of key '%s' (%s) does not match the checksum of the file (%s)", recorder.algorithm, key, actual, expected)
+	}
+	if parts > 0 && attributes.ObjectParts != nil && aws.Int64Value(attributes.ObjectParts.TotalPartsCount) != int64(parts) {
+		return fmt.Errorf("key '%s' has %d parts but %d parts were uploaded", key, aws.Int64Value(attributes.ObjectParts.TotalPartsCount), parts)
+	}
+
+	return nil
+}
+
+// Returns the checksum algorithm to verify the upload with. The ETag of an object encrypted with SSE-KMS is not its
+// md5sum, so strong verification and checksum verification use a SHA256 checksum instead when the object is uploaded
+// with SSE-KMS or the bucket encrypts objects with SSE-KMS by default
+func selectChecksumAlgorithm(svc *s3.S3, uploadObject UploadObject) string {
+	if uploadObject.ChecksumAlgorithm != "" || (!uploadObject.StrongVerify && !uploadObject.VerifyChecksum) {
+		return uploadObject.ChecksumAlgorithm
+	}
+
+	switch uploadObject.ServerSideEncryption {
+	case s3.ServerSideEncryptionAwsKms:
+		log.Info.Printf("Uploading with SSE-KMS. Verifying with a %s checksum instead of the ETag\n", ChecksumAlgorithmSHA256)
+		return ChecksumAlgorithmSHA256
+	case s3.ServerSideEncryptionAes256:
+		return "" // The ETag of an object encrypted with SSE-S3 is its md5sum whatever the default encryption of the bucket
+	}
+
+	output, err := svc.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: aws.String(uploadObject.Bucket)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "ServerSideEncryptionConfigurationNotFoundError" {
+			log.Warn.Printf("Failed to retrieve the default encryption of bucket: '%s'. Verifying with the ETag: %v\n", uploadObject.Bucket, err)
+		}
+		return ""
+	}
+
+	if output.ServerSideEncryptionConfiguration != nil {
+		for _, rule := range output.ServerSideEncryptionConfiguration.Rules {
+			if rule.ApplyServerSideEncryptionByDefault != nil &&
+				aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm) == s3.ServerSideEncryptionAwsKms {
+				log.Info.Printf("Bucket: '%s' encrypts objects with SSE-KMS by default. Verifying with a %s checksum instead of the ETag\n",
+					uploadObject.Bucket, ChecksumAlgorithmSHA256)
+				return ChecksumAlgorithmSHA256
+			}
+		}
+	}
+	return ""
+}
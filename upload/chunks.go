@@ -0,0 +1,435 @@
+package upload
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/log"
+	"s3backup/version"
+	"io"
+	"math/bits"
+	"os"
+	"sync"
+	"time"
+)
+
+// ArchiveFormatChunks is the archive format of files uploaded with UploadChunked. The object stored under the key of
+// the backup is a ChunkManifest listing the content defined chunks of the file rather than the contents of the file
+const ArchiveFormatChunks = "chunks"
+
+// ChunkDir is the directory under the bucket dir that chunks are stored in. Chunks are keyed by their sha256 so a chunk
+// shared by several backups is only stored once. Chunks are not removed when rotation deletes the manifests referencing them
+const ChunkDir = ".chunks/"
+
+// ChunkManifestVersion is the version of the manifest format written by UploadChunked
+const ChunkManifestVersion = 1
+
+// ChunkManifest lists the chunks a file was split into in the order they are reassembled
+type ChunkManifest struct {
+	Version int             `json:"version"`
+	Size    int64           `json:"size"`
+	MD5     string          `json:"md5"`  // Hex encoded md5sum of the file
+	Root    string          `json:"root"` // Merkle root of the chunk hashes
+	Chunks  []ManifestChunk `json:"chunks"`
+}
+
+// ManifestChunk is a single chunk of a file uploaded with UploadChunked
+type ManifestChunk struct {
+	Key  string `json:"key"`
+	Hash string `json:"hash"` // Hex encoded sha256 of the chunk
+	Size int64  `json:"size"`
+}
+
+// UploadChunked splits the file at the path to file into content defined chunks and uploads only the chunks which are
+// not already stored under the chunk dir, followed by a manifest of the chunks which is stored under the key of the
+// backup. Chunk boundaries are chosen by a rolling hash of the contents, so a change to a small region of a large file
+// only changes the chunks around it and the remaining chunks are shared with the previous backup.
+// Returns the name of the key that the manifest was uploaded to
+func UploadChunked(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun bool) (string, error) {
+
+	if svc == nil {
+		return "", errors.New("svc must not be nil")
+	}
+
+	err := validationCheck(uploadObject)
+	if err != nil {
+		return "", err
+	}
+
+	err = chunkValidationCheck(uploadObject)
+	if err != nil {
+		return "", err
+	}
+
+	uploadObject = withRateLimiter(uploadObject)
+
+	log.Info.Println(`
+	######################################
+	#       Chunked Upload Started       #
+	######################################
+	`)
+
+	// Context provides a timeout with AWS SDK calls 'WithContext'
+	ctx := context.Background()
+	if uploadObject.Timeout > 0 {
+		var cancelFn func()
+		ctx, cancelFn = context.WithTimeout(ctx, uploadObject.Timeout)
+		defer cancelFn()
+	}
+
+	file, err := os.Open(uploadObject.PathToFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	fileInfo, _ := file.Stat()
+	fileSize := fileInfo.Size()
+
+	if uploadObject.MaxFileBytes > 0 && fileSize > uploadObject.MaxFileBytes {
+		if !uploadObject.Force {
+			return "", fmt.Errorf("file '%s' is %d bytes which exceeds the maximum file size of %d bytes, "+
+				"use --force to upload it anyway", uploadObject.PathToFile, fileSize, uploadObject.MaxFileBytes)
+		}
+		log.Warn.Printf("File '%s' is %d bytes which exceeds the maximum file size of %d bytes. "+
+			"Uploading anyway as force has been enabled\n", uploadObject.PathToFile, fileSize, uploadObject.MaxFileBytes)
+	}
+
+	s3FileName, err := getS3FileName(uploadObject, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	uploadObject, err = resolveStorageClassMismatch(svc, uploadObject, s3FileName)
+	if err != nil {
+		return "", err
+	}
+
+	chunkDir := uploadObject.BucketDir + ChunkDir
+	storedChunks, err := getStoredChunks(ctx, svc, uploadObject.Bucket, chunkDir)
+	if err != nil {
+		return "", err
+	}
+
+	log.Info.Printf("Uploading '%s' (%d bytes) in chunks averaging %d bytes to s3 bucket '%s'. %d chunks are already stored\n",
+		uploadObject.PathToFile, fileSize, int64(uploadObject.ChunkSize)*1024*1024, uploadObject.Bucket, len(storedChunks))
+
+	startTime := time.Now()
+
+	hash := md5.New()
+	chunker := newChunker(withProgress(io.TeeReader(file, hash), uploadObject.ProgressFn, fileSize), int64(uploadObject.ChunkSize)*1024*1024)
+	manifest := ChunkManifest{Version: ChunkManifestVersion, Size: fileSize}
+	uploader := newChunkUploader(ctx, svc, uploadObject.Bucket, uploadObject.NumWorkers, rateLimitOptions(uploadObject))
+
+	var uploadedChunks, uploadedBytes int64
+	for {
+		chunk, err := chunker.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			uploader.wait()
+			return "", err
+		}
+
+		sum := sha256.Sum256(chunk)
+		chunkHash := hex.EncodeToString(sum[:])
+		chunkKey := chunkDir + chunkHash
+		manifest.Chunks = append(manifest.Chunks, ManifestChunk{Key: chunkKey, Hash: chunkHash, Size: int64(len(chunk))})
+
+		if storedChunks[chunkKey] {
+			continue
+		}
+		storedChunks[chunkKey] = true // Chunks repeated within the file are only uploaded once
+		uploadedChunks++
+		uploadedBytes += int64(len(chunk))
+
+		if dryRun {
+			log.Info.Printf("Skipping upload of chunk: '%s' (%d bytes) as dry run has been enabled\n", chunkKey, len(chunk))
+			continue
+		}
+		uploader.upload(chunkKey, chunk)
+	}
+
+	err = uploader.wait()
+
+	manifest.MD5 = hex.EncodeToString(hash.Sum(nil))
+	if err == nil {
+		manifest.Root, err = MerkleRoot(chunkHashes(manifest))
+	}
+
+	if err == nil {
+		log.Info.Printf("Uploaded %d of %d chunks (%d bytes), the remaining chunks are shared with existing backups\n",
+			uploadedChunks, len(manifest.Chunks), uploadedBytes)
+
+		if dryRun {
+			log.Info.Printf("Skipping upload of manifest: '%s' as dry run has been enabled\n", s3FileName)
+		} else {
+			err = putChunkManifest(ctx, svc, uploadObject, s3FileName, manifest)
+		}
+	}
+
+	elapsedTime := time.Since(startTime).Seconds()
+
+	log.Info.Printf("Total time spent processing upload: %0.2f seconds\n", elapsedTime)
+
+	result := UploadResult{Key: s3FileName, Bytes: fileSize, Duration: elapsedTime, Checksum: manifest.MD5, Status: ResultStatusSuccess}
+	if err != nil {
+		result.Status = ResultStatusFailed
+		result.Error = err.Error()
+	} else if dryRun {
+		result.Status = ResultStatusDryRun
+	}
+	recordResult(uploadObject, result)
+
+	if err != nil {
+		return "", err
+	}
+
+	return s3FileName, nil
+}
+
+// MerkleRoot returns the hex encoded root of the binary Merkle tree of the hex encoded sha256 hashes. Each level of the
+// tree hashes adjacent pairs of the level below, an odd node at the end of a level is carried up unchanged
+func MerkleRoot(hashes []string) (string, error) {
+	if len(hashes) == 0 {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	level := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		node, err := hex.DecodeString(h)
+		if err != nil {
+			return "", fmt.Errorf("invalid chunk hash '%s': %v", h, err)
+		}
+		level[i] = node
+	}
+
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			sum := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+			next = append(next, sum[:])
+		}
+		level = next
+	}
+
+	return hex.EncodeToString(level[0]), nil
+}
+
+func chunkHashes(manifest ChunkManifest) []string {
+	hashes := make([]string, len(manifest.Chunks))
+	for i, chunk := range manifest.Chunks {
+		hashes[i] = chunk.Hash
+	}
+	return hashes
+}
+
+// Returns every chunk key stored under the chunk dir
+func getStoredChunks(ctx context.Context, svc *s3.S3, bucket string, chunkDir string) (map[string]bool, error) {
+	chunks := make(map[string]bool)
+	err := svc.ListObjectsPagesWithContext(ctx, &s3.ListObjectsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(chunkDir),
+	}, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			chunks[aws.StringValue(obj.Key)] = true
+		}
+		return true
+	})
+	return chunks, err
+}
+
+// Uploads the manifest to the key of the backup along with the attributes of the upload object
+func putChunkManifest(ctx context.Context, svc *s3.S3, uploadObject UploadObject, s3FileName string, manifest ChunkManifest) error {
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	uploadParams := &s3manager.UploadInput{
+		Bucket:      aws.String(uploadObject.Bucket),
+		Key:         aws.String(s3FileName),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+		Metadata:    map[string]*string{ArchiveMetadataKey: aws.String(ArchiveFormatChunks), VersionMetadataKey: aws.String(version.Version)},
+	}
+
+	applyObjectAttributes(uploadParams, uploadObject)
+
+	log.Info.Printf("Uploading manifest of %d chunks to key: '%s'\n", len(manifest.Chunks), s3FileName)
+	_, err = s3manager.NewUploaderWithClient(svc).UploadWithContext(ctx, uploadParams)
+	return err
+}
+
+// Uploads chunks with a fixed number of workers. The first error stops any further chunks from being uploaded
+type chunkUploader struct {
+	ctx    context.Context
+	svc    *s3.S3
+	bucket string
+	opts   []request.Option // Applied to the request of every chunk, e.g. to limit their rate
+	jobs   chan chunkJob
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	err    error
+}
+
+type chunkJob struct {
+	key  string
+	body []byte
+}
+
+func newChunkUploader(ctx context.Context, svc *s3.S3, bucket string, numWorkers int, opts []request.Option) *chunkUploader {
+	u := &chunkUploader{ctx: ctx, svc: svc, bucket: bucket, opts: opts, jobs: make(chan chunkJob)}
+	for i := 0; i < numWorkers; i++ {
+		u.wg.Add(1)
+		go u.work()
+	}
+	return u
+}
+
+func (u *chunkUploader) upload(key string, body []byte) {
+	u.jobs <- chunkJob{key: key, body: body}
+}
+
+// Waits for every chunk to be uploaded and returns the first error encountered
+func (u *chunkUploader) wait() error {
+	close(u.jobs)
+	u.wg.Wait()
+	return u.err
+}
+
+func (u *chunkUploader) work() {
+	defer u.wg.Done()
+	for job := range u.jobs {
+		u.mu.Lock()
+		failed := u.err != nil
+		u.mu.Unlock()
+		if failed {
+			continue
+		}
+
+		// Content-MD5 has the provider verify each chunk as it is received
+		sum := md5.Sum(job.body)
+		log.Info.Printf("Uploading chunk: '%s' (%d bytes)\n", job.key, len(job.body))
+		_, err := u.svc.PutObjectWithContext(u.ctx, &s3.PutObjectInput{
+			Bucket:     aws.String(u.bucket),
+			Key:        aws.String(job.key),
+			Body:       bytes.NewReader(job.body),
+			ContentMD5: aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+		}, u.opts...)
+		if err != nil {
+			u.mu.Lock()
+			if u.err == nil {
+				u.err = fmt.Errorf("failed to upload chunk '%s': %v", job.key, err)
+			}
+			u.mu.Unlock()
+		}
+	}
+}
+
+// Splits a stream into content defined chunks with a gear rolling hash. A boundary is placed after a byte once the
+// chunk has reached the minimum size and the top bits of the hash are zero. The hash only depends on the last 64 bytes
+// read, so boundaries realign shortly after a modified region and the chunks after it are unchanged
+type chunker struct {
+	r       *bufio.Reader
+	minSize int64
+	maxSize int64
+	mask    uint64
+}
+
+// Chunks average roughly the average size, with a minimum of a quarter and a maximum of four times the average size
+func newChunker(r io.Reader, avgSize int64) *chunker {
+	maskBits := uint(bits.Len64(uint64(avgSize)) - 1)
+	return &chunker{
+		r:       bufio.NewReaderSize(r, 1024*1024),
+		minSize: avgSize / 4,
+		maxSize: avgSize * 4,
+		mask:    ((uint64(1) << maskBits) - 1) << (64 - maskBits),
+	}
+}
+
+// Returns the next chunk of the stream or io.EOF once the stream has been fully read
+func (c *chunker) next() ([]byte, error) {
+	chunk := make([]byte, 0, c.minSize)
+	var hash uint64
+	for int64(len(chunk)) < c.maxSize {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			if len(chunk) == 0 {
+				return nil, io.EOF
+			}
+			return chunk, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		chunk = append(chunk, b)
+		hash = (hash << 1) + gearTable[b]
+		if int64(len(chunk)) >= c.minSize && hash&c.mask == 0 {
+			return chunk, nil
+		}
+	}
+	return chunk, nil
+}
+
+// The gear table maps each byte to a pseudo random value. It is generated from a fixed seed as the boundaries of
+// existing chunks depend on it
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	seed := uint64(0x5362636b75704344) // splitmix64
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+func chunkValidationCheck(uploadObject UploadObject) error {
+	if uploadObject.ChunkSize < 1 || uploadObject.ChunkSize > 1024 {
+		return errors.New("chunk size must be between 1MiB and 1024MiB")
+	}
+
+	if uploadObject.Compression != "" {
+		return errors.New("compression is not supported with chunked uploads as chunks are deduplicated by their contents")
+	}
+
+	if len(uploadObject.EncryptionKey) > 0 {
+		return errors.New("encryption is not supported with chunked uploads as chunks are deduplicated by their contents")
+	}
+
+	if uploadObject.StrongVerify || uploadObject.VerifyChecksum || uploadObject.SkipIfUnchanged || uploadObject.ChecksumAlgorithm != "" {
+		return errors.New("strong verify, verify checksum, checksum algorithm and skip if unchanged are not supported with chunked uploads as every chunk is " +
+			"verified on upload and unchanged chunks are never uploaded again")
+	}
+
+	if uploadObject.Resume {
+		return errors.New("resume is not supported with chunked uploads as the chunks which are already stored are never uploaded again")
+	}
+
+	if uploadObject.SkipIfExists {
+		return errors.New("skip if exists is not supported with chunked uploads as the chunks which are already stored are never uploaded again")
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// The number of bytes http.DetectContentType considers when sniffing the content type of a file
+const sniffLength = 512
+
+// Returns the content type of the uploaded object. The content type of the upload object wins over detection,
+// otherwise the type is detected from the extension of the file and then by sniffing the start of the file if the
+// extension is unknown. The file whose contents are uploaded is detected, so a compressed file is detected by the
+// extension of its compressor. An empty content type is returned for an empty file of an unknown extension so that
+// the provider applies its default
+func detectContentType(uploadObject UploadObject, pathToUpload string) (string, error) {
+	if uploadObject.ContentType != "" {
+		return uploadObject.ContentType, nil
+	}
+
+	if contentType := mime.TypeByExtension(filepath.Ext(pathToUpload)); contentType != "" {
+		return contentType, nil
+	}
+
+	file, err := os.Open(pathToUpload)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	buf := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("failed to read '%s' to detect its content type: %v", pathToUpload, err)
+	}
+	if n == 0 {
+		return "", nil
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// Returns true if the content type is a valid media type, e.g. text/csv or text/html; charset=utf-8
+func validContentType(contentType string) bool {
+	_, _, err := mime.ParseMediaType(contentType)
+	return err == nil
+}
@@ -0,0 +1,95 @@
+package upload
+
+import (
+	"bufio"
+	"encoding/json"
+	"s3backup/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DeadLetterEntry records a file of a directory upload which still failed once it had been retried. It is written to
+// the dead letter as a single line of newline delimited JSON
+type DeadLetterEntry struct {
+	Path  string `json:"path"` // Path of the file relative to the directory, separated by '/'
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// Loads the relative paths of the files recorded in the dead letter at the path. A missing dead letter records no files
+func loadDeadLetter(path string) (map[string]bool, error) {
+	paths := make(map[string]bool)
+
+	fd, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return paths, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry DeadLetterEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Warn.Printf("Skipping unreadable entry of dead letter '%s': %v\n", path, err)
+			continue
+		}
+		paths[entry.Path] = true
+	}
+
+	return paths, scanner.Err()
+}
+
+// Replaces the dead letter at the path with the entries ordered by path. The entries are written to a temporary file
+// which is renamed over the dead letter so that the dead letter of a previous run is never left truncated
+func writeDeadLetter(path string, entries []DeadLetterEntry) error {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	fd, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	if err = fd.Chmod(0644); err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(fd)
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err = writer.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if err = fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
+
+// Removes the dead letter at the path once every file it recorded has been uploaded
+func removeDeadLetter(path string) error {
+	err := os.Remove(path)
+	if err == nil {
+		log.Info.Printf("Removed dead letter '%s' as no file failed to upload\n", path)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
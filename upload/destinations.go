@@ -0,0 +1,175 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FailoverMarker is the prefix of a failover destination in the destinations passed to ParseDestinations
+const FailoverMarker = "failover:"
+
+// Time waited before each retry of a destination, multiplied by the number of the attempt
+var destinationRetryDelay = time.Second * 5
+
+// Destination is a bucket which an upload is sent to. Every primary destination receives the upload. A failover
+// destination belongs to the primary destination before it and only receives the upload if the primary destination
+// and every failover destination before it failed
+type Destination struct {
+	Bucket   string
+	Region   string
+	Failover bool
+	Retries  int    // The number of times a failed upload is retried before failing over to the next destination
+	Svc      *s3.S3 // The client of the region of the destination
+}
+
+// DestinationResult is the outcome of uploading to a primary destination and its failover destinations
+type DestinationResult struct {
+	Primary     Destination
+	Destination Destination // The destination which received the upload. Unset if every destination failed
+	Key         string
+	Uploaded    bool  // False if the upload was skipped or every destination failed
+	FailedOver  bool  // True if a failover destination received the upload instead of the primary destination
+	Err         error // The error of the last destination tried if every destination failed
+}
+
+// UploadFn uploads the upload object to its bucket with the client. Returns the key and whether the file was uploaded
+type UploadFn func(svc *s3.S3, uploadObject UploadObject) (string, bool, error)
+
+// ParseDestinations parses destinations separated by a comma in the format bucket[@region]. A destination prefixed
+// with the failover marker is a failover destination of the primary destination before it. The region defaults to
+// the specified region
+func ParseDestinations(destinations string, region string) ([]Destination, error) {
+	parsed := []Destination{}
+	if strings.TrimSpace(destinations) == "" {
+		return parsed, nil
+	}
+
+	for _, entry := range strings.Split(destinations, ",") {
+		entry = strings.TrimSpace(entry)
+		destination := Destination{Region: region}
+
+		if strings.HasPrefix(entry, FailoverMarker) {
+			destination.Failover = true
+			entry = strings.TrimPrefix(entry, FailoverMarker)
+		}
+
+		destination.Bucket = entry
+		if i := strings.Index(entry, "@"); i >= 0 {
+			destination.Bucket, destination.Region = entry[:i], entry[i+1:]
+			if destination.Region == "" {
+				return nil, fmt.Errorf("invalid destination '%s', the region must not be empty", entry)
+			}
+		}
+
+		if destination.Bucket == "" {
+			return nil, fmt.Errorf("invalid destination '%s', the bucket must not be empty", entry)
+		}
+		parsed = append(parsed, destination)
+	}
+
+	return parsed, nil
+}
+
+// UploadToDestinations uploads the upload object to every primary destination concurrently with the upload function.
+// A destination which fails is retried before failing over to the next failover destination of the primary. The
+// bucket of the upload object is replaced with the bucket of each destination. No more than MaxDestinationConcurrency
+// primaries and their failover destinations are uploaded to at once. Returns a result for every primary
+func UploadToDestinations(destinations []Destination, uploadObject UploadObject, uploadFn UploadFn) ([]DestinationResult, error) {
+	if uploadObject.MaxDestinationConcurrency < 0 {
+		return nil, fmt.Errorf("the max destination concurrency must not be negative: %d", uploadObject.MaxDestinationConcurrency)
+	}
+
+	groups, err := groupDestinations(destinations)
+	if err != nil {
+		return nil, err
+	}
+	if isStdin(uploadObject) && (len(destinations) > 1 || destinations[0].Retries > 0) {
+		return nil, errors.New("stdin can only be uploaded to a single destination without retries as it cannot be read again")
+	}
+	uploadObject = withRateLimiter(uploadObject) // Every destination uploaded to at once shares the rate
+
+	limit := uploadObject.MaxDestinationConcurrency
+	if limit == 0 || limit > len(groups) {
+		limit = len(groups)
+	}
+	slots := make(chan struct{}, limit)
+
+	results := make([]DestinationResult, len(groups))
+
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		wg.Add(1)
+		go func(i int, group []Destination) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = uploadToGroup(group, uploadObject, uploadFn)
+		}(i, group)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// Splits the destinations into groups each of a primary destination followed by its failover destinations
+func groupDestinations(destinations []Destination) ([][]Destination, error) {
+	if len(destinations) == 0 {
+		return nil, errors.New("at least one destination must be specified")
+	}
+
+	groups := [][]Destination{}
+	for _, destination := range destinations {
+		if destination.Svc == nil {
+			return nil, fmt.Errorf("destination '%s' has no client", destination.Bucket)
+		}
+		if destination.Retries < 0 {
+			return nil, fmt.Errorf("the retries of destination '%s' must not be negative: %d", destination.Bucket, destination.Retries)
+		}
+
+		if !destination.Failover {
+			groups = append(groups, []Destination{destination})
+			continue
+		}
+		if len(groups) == 0 {
+			return nil, fmt.Errorf("failover destination '%s' must follow a primary destination", destination.Bucket)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], destination)
+	}
+
+	return groups, nil
+}
+
+// Tries each destination of the group in order until one succeeds
+func uploadToGroup(group []Destination, uploadObject UploadObject, uploadFn UploadFn) DestinationResult {
+	result := DestinationResult{Primary: group[0]}
+
+	for i, destination := range group {
+		if i > 0 {
+			log.Warn.Printf("Failing over from destination '%s' to '%s' in region '%s'\n", group[i-1].Bucket, destination.Bucket, destination.Region)
+		}
+
+		uploadObject.Bucket = destination.Bucket
+		for attempt := 0; attempt <= destination.Retries; attempt++ {
+			if attempt > 0 {
+				log.Warn.Printf("Retrying upload to destination '%s' (attempt %d of %d)\n", destination.Bucket, attempt+1, destination.Retries+1)
+				time.Sleep(destinationRetryDelay * time.Duration(attempt))
+			}
+
+			key, uploaded, err := uploadFn(destination.Svc, uploadObject)
+			if err == nil {
+				return DestinationResult{Primary: group[0], Destination: destination, Key: key, Uploaded: uploaded, FailedOver: i > 0}
+			}
+
+			log.Error.Printf("Failed to upload to destination '%s': %v\n", destination.Bucket, err)
+			result.Err = err
+		}
+	}
+
+	result.Err = fmt.Errorf("every destination of primary '%s' failed, last error: %v", group[0].Bucket, result.Err)
+	return result
+}
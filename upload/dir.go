@@ -0,0 +1,335 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirUploadError is returned by UploadDir when any file of the directory failed to upload. The remaining files are
+// still uploaded, so the directory can be uploaded again to retry only the files which failed if a ledger is specified
+type DirUploadError struct {
+	Failed     map[string]error // The error of each file which failed to upload by its path
+	DeadLetter string           // Path of the dead letter the failed files were written to. Empty if they were not written
+}
+
+func (e *DirUploadError) Error() string {
+	paths := make([]string, 0, len(e.Failed))
+	for pathToFile := range e.Failed {
+		paths = append(paths, pathToFile)
+	}
+	sort.Strings(paths)
+
+	failures := make([]string, 0, len(paths))
+	for _, pathToFile := range paths {
+		failures = append(failures, fmt.Sprintf("'%s': %v", pathToFile, e.Failed[pathToFile]))
+	}
+	msg := fmt.Sprintf("failed to upload %d files of the directory: %s", len(paths), strings.Join(failures, "; "))
+	if e.DeadLetter != "" {
+		msg += fmt.Sprintf(". The failed files were written to dead letter '%s'", e.DeadLetter)
+	}
+	return msg
+}
+
+// UploadDir uploads every regular file under the directory at the path to file as its own object, keyed by its path
+// relative to the directory under <bucketdir><s3filename>/. Up to the number of workers files are uploaded at once,
+// each with its own workers for its parts. A file which fails does not stop the remaining files from being uploaded
+// and every file which failed is named by the returned DirUploadError. Symlinks are skipped unless follow symlinks is
+// enabled, in which case a linked file is uploaded under the path of the link and a linked directory is walked as if it
+// were under the directory, except for a link to a directory which contains it. If a ledger is specified then each
+// file is recorded in it once uploaded and a file the ledger records as uploaded to the bucket with the same size and
+// md5sum is skipped without any request to S3, so an interrupted upload can be run again to upload only the remaining
+// files. If dir manifest is enabled then once every file has been uploaded a DirManifest of the files is uploaded beside
+// the directory. A file which fails is uploaded again up to file retries times, and if a dead letter is specified then
+// every file which still failed is written to it once the remaining files have been uploaded. The dead letter is
+// removed once no file fails, and if retry dead letter is enabled then only the files it records are uploaded. Returns
+// the result of every file which was walked and did not fail, in the order they were walked
+func UploadDir(svc *s3.S3, uploadObject UploadObject, dryRun bool) ([]UploadResult, error) {
+	if err := dirValidationCheck(uploadObject); err != nil {
+		return nil, err
+	}
+	uploadObject = withRateLimiter(uploadObject) // Every file uploaded at once shares the rate
+
+	var completed *ledger
+	if uploadObject.Ledger != "" {
+		var err error
+		completed, err = loadLedger(uploadObject.Ledger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ledger '%s': %v", uploadObject.Ledger, err)
+		}
+		log.Info.Printf("Loaded %d completed files from ledger '%s'\n", len(completed.entries), uploadObject.Ledger)
+	}
+
+	var retrying map[string]bool
+	if uploadObject.RetryDeadLetter {
+		var err error
+		retrying, err = loadDeadLetter(uploadObject.DeadLetter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load dead letter '%s': %v", uploadObject.DeadLetter, err)
+		}
+		if len(retrying) == 0 {
+			log.Info.Printf("Dead letter '%s' records no failed files, there is nothing to retry\n", uploadObject.DeadLetter)
+			return []UploadResult{}, nil
+		}
+		log.Info.Printf("Retrying the %d failed files recorded in dead letter '%s'\n", len(retrying), uploadObject.DeadLetter)
+	}
+
+	dir := uploadObject.PathToFile
+	dirKey := uploadObject.BucketDir + uploadObject.S3FileName + "/"
+	manifestFiles := []ManifestFile{}
+
+	workers := uploadObject.NumWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	slots := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	results := []*UploadResult{} // A slot for each file in the order it was walked, nil if the file failed
+	failed := make(map[string]error)
+	deadLetters := []DeadLetterEntry{}
+
+	uploadDirFile := func(pathToFile string, relPath string, info os.FileInfo) error {
+		if retrying != nil {
+			if !retrying[relPath] {
+				return nil
+			}
+			delete(retrying, relPath)
+		}
+
+		fileObject := uploadObject
+		fileObject.PathToFile = pathToFile
+		fileObject.Ledger = ""
+		fileObject.DirManifest = false
+		fileObject.DeadLetter = ""
+		fileObject.RetryDeadLetter = false
+		fileObject.BucketDir = dirKey
+		if relDir := path.Dir(relPath); relDir != "." {
+			fileObject.BucketDir += relDir + "/"
+		}
+		fileObject.S3FileName = path.Base(relPath)
+		key := fileObject.BucketDir + fileObject.S3FileName
+
+		if uploadObject.DirManifest {
+			manifestFile, err := newManifestFile(pathToFile, key)
+			if err != nil {
+				return err
+			}
+			manifestFiles = append(manifestFiles, manifestFile)
+		}
+
+		var md5sum string
+		if completed != nil {
+			var err error
+			md5sum, err = computeHexMD5Sum(pathToFile)
+			if err != nil {
+				return err
+			}
+			if completed.completed(uploadObject.Bucket, key, info.Size(), md5sum) {
+				log.Info.Printf("Skipping upload of '%s' as the ledger records it as uploaded to key: '%s'\n", pathToFile, key)
+				mu.Lock()
+				results = append(results, &UploadResult{Key: key, Bytes: info.Size(), Checksum: md5sum, Status: ResultStatusSkipped})
+				mu.Unlock()
+				return nil
+			}
+		}
+
+		mu.Lock()
+		slot := len(results)
+		results = append(results, nil)
+		mu.Unlock()
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result, err := UploadFileWithResult(svc, fileObject, "", dryRun)
+			for attempt := 1; attempt <= uploadObject.FileRetries && err != nil; attempt++ {
+				delay := retryDelay(attempt)
+				log.Warn.Printf("Upload of '%s' failed, uploading it again in %s (attempt %d of %d): %v\n",
+					pathToFile, delay, attempt+1, uploadObject.FileRetries+1, err)
+				time.Sleep(delay)
+
+				result, err = UploadFileWithResult(svc, fileObject, "", dryRun)
+			}
+			if err == nil && completed != nil && !dryRun {
+				if err = completed.record(LedgerEntry{Bucket: uploadObject.Bucket, Key: key, Bytes: info.Size(), Checksum: md5sum}); err != nil {
+					err = fmt.Errorf("failed to record '%s' in ledger '%s': %v", key, uploadObject.Ledger, err)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Error.Printf("Failed to upload '%s': %v\n", pathToFile, err)
+				failed[pathToFile] = err
+				deadLetters = append(deadLetters, DeadLetterEntry{Path: relPath, Key: key, Error: err.Error()})
+				return
+			}
+			results[slot] = &result
+		}()
+		return nil
+	}
+
+	err := walkDirFiles(dir, "", uploadObject.IncludeDotfiles, uploadObject.FollowSymlinks, uploadDirFile)
+	wg.Wait()
+
+	for relPath := range retrying {
+		log.Warn.Printf("Dropping '%s' from dead letter '%s' as it is no longer in the directory\n", relPath, uploadObject.DeadLetter)
+	}
+
+	uploaded := []UploadResult{}
+	for _, result := range results {
+		if result != nil {
+			uploaded = append(uploaded, *result)
+		}
+	}
+
+	deadLetter := ""
+	if uploadObject.DeadLetter != "" && !dryRun {
+		var deadLetterErr error
+		if len(deadLetters) > 0 {
+			if deadLetterErr = writeDeadLetter(uploadObject.DeadLetter, deadLetters); deadLetterErr == nil {
+				deadLetter = uploadObject.DeadLetter
+				log.Info.Printf("Wrote the %d files which failed to upload to dead letter '%s'\n", len(deadLetters), deadLetter)
+			}
+		} else if err == nil {
+			deadLetterErr = removeDeadLetter(uploadObject.DeadLetter)
+		}
+		if deadLetterErr != nil {
+			log.Error.Printf("Failed to update dead letter '%s': %v\n", uploadObject.DeadLetter, deadLetterErr)
+		}
+	}
+
+	if err == nil && len(failed) > 0 {
+		err = &DirUploadError{Failed: failed, DeadLetter: deadLetter}
+	}
+
+	if err == nil && uploadObject.DirManifest {
+		if _, err = putDirManifest(svc, uploadObject, manifestFiles, dryRun); err != nil {
+			err = fmt.Errorf("failed to upload the manifest of '%s': %v", dir, err)
+		}
+	}
+
+	return uploaded, err
+}
+
+// Walks the directory and calls the function with each regular file, its path relative to the directory prefixed by
+// the relative path of the directory, and its info. Symlinks are skipped unless they are followed, in which case the
+// info is of the file linked to and a linked directory is walked under the relative path of the link. A link to a
+// directory which contains the link is skipped as following it would walk the directory forever
+func walkDirFiles(dir string, relDir string, includeDotfiles bool, followSymlinks bool, fileFn func(string, string, os.FileInfo) error) error {
+	return walkDir(dir, includeDotfiles, func(pathToFile string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, pathToFile)
+		if err != nil {
+			return err
+		}
+		relPath = path.Join(relDir, filepath.ToSlash(relPath))
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !followSymlinks {
+				log.Warn.Printf("Skipping '%s' as it is a symlink and follow symlinks is not enabled\n", pathToFile)
+				return nil
+			}
+
+			target, err := os.Stat(pathToFile)
+			if err != nil {
+				log.Warn.Printf("Skipping symlink '%s' as its target cannot be read: %v\n", pathToFile, err)
+				return nil
+			}
+			if target.IsDir() {
+				if cycle, err := linksToParent(pathToFile); err != nil || cycle {
+					log.Warn.Printf("Skipping symlink '%s' as it links to a directory which contains it\n", pathToFile)
+					return err
+				}
+				return walkDirFiles(pathToFile+string(filepath.Separator), relPath, includeDotfiles, followSymlinks, fileFn)
+			}
+			info = target
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			log.Warn.Printf("Skipping '%s' as it is not a regular file\n", pathToFile)
+			return nil
+		}
+		return fileFn(pathToFile, relPath, info)
+	})
+}
+
+// Returns true if the symlink links to a directory which contains the symlink
+func linksToParent(link string) (bool, error) {
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return false, err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(link))
+	if err != nil {
+		return false, err
+	}
+	return parent == target || strings.HasPrefix(parent, target+string(filepath.Separator)), nil
+}
+
+func dirValidationCheck(uploadObject UploadObject) error {
+	// The ledger, manifest and dead letter belong to the directory rather than to each file
+	dirManifest := uploadObject.DirManifest
+	uploadObject.Ledger = ""
+	uploadObject.DirManifest = false
+	deadLetter, retryDeadLetter := uploadObject.DeadLetter, uploadObject.RetryDeadLetter
+	uploadObject.DeadLetter = ""
+	uploadObject.RetryDeadLetter = false
+	if err := validationCheck(uploadObject); err != nil {
+		return err
+	}
+
+	if uploadObject.FileRetries < 0 {
+		return errors.New("file retries must not be less than 0")
+	}
+
+	if retryDeadLetter && deadLetter == "" {
+		return errors.New("a dead letter must be specified to retry the files recorded in it")
+	}
+
+	if retryDeadLetter && dirManifest {
+		return errors.New("a manifest cannot be uploaded when retrying a dead letter as it would only list the files which are retried")
+	}
+
+	fileInfo, err := os.Stat(uploadObject.PathToFile)
+	if err != nil {
+		return err
+	}
+
+	if !fileInfo.IsDir() {
+		return errors.New("path to file must be a directory to upload every file under it")
+	}
+
+	if uploadObject.Manipulate {
+		return errors.New("a directory can only be uploaded without manipulation, use an archive to back up a directory as a single object")
+	}
+
+	if uploadObject.ChunkSize > 0 {
+		return errors.New("chunked uploads are not supported with directories")
+	}
+
+	if strings.ToLower(uploadObject.OnTimeout) == OnTimeoutPreserve {
+		return errors.New("preserving the parts of an upload which times out is not supported with directories")
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/log"
+	"s3backup/version"
+	"io"
+	"os"
+	"sort"
+)
+
+// DirManifestSuffix is appended to the key of a directory, i.e. <bucketdir><s3filename>, to give the key of the manifest
+// uploaded by UploadDir. The manifest is stored beside the directory so that it is not one of its files
+const DirManifestSuffix = ".manifest.json"
+
+// DirManifestVersion is the version of the manifest format written by UploadDir
+const DirManifestVersion = 1
+
+// DirManifest lists every file of a directory upload with its checksum and the Merkle root over them, so that the
+// whole backup can be verified against the single root
+type DirManifest struct {
+	Version int            `json:"version"`
+	Root    string         `json:"root"` // Merkle root of the files, see DirManifestRoot
+	Files   []ManifestFile `json:"files"`
+}
+
+// ManifestFile is a single file of a directory uploaded with UploadDir
+type ManifestFile struct {
+	Key  string `json:"key"`
+	Hash string `json:"hash"` // Hex encoded sha256 of the file
+	Size int64  `json:"size"`
+}
+
+// DirManifestRoot returns the Merkle root of the files ordered by key. Each leaf is the sha256 of the key and the hash
+// of the file, so the root changes if any file is changed, added, removed or renamed
+func DirManifestRoot(files []ManifestFile) (string, error) {
+	sorted := append([]ManifestFile{}, files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	leaves := make([]string, len(sorted))
+	for i, file := range sorted {
+		fileHash, err := hex.DecodeString(file.Hash)
+		if err != nil {
+			return "", err
+		}
+		leaf := sha256.Sum256(append(append([]byte(file.Key), 0), fileHash...))
+		leaves[i] = hex.EncodeToString(leaf[:])
+	}
+	return MerkleRoot(leaves)
+}
+
+// Returns the manifest entry of the file at the path stored under the key
+func newManifestFile(pathToFile string, key string) (ManifestFile, error) {
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	return ManifestFile{Key: key, Hash: hex.EncodeToString(hash.Sum(nil)), Size: size}, nil
+}
+
+// Computes the root of the files and uploads the manifest beside the directory along with the attributes of the
+// upload object. Returns the manifest, which is only logged during a dry run
+func putDirManifest(svc *s3.S3, uploadObject UploadObject, files []ManifestFile, dryRun bool) (DirManifest, error) {
+	manifest := DirManifest{Version: DirManifestVersion, Files: files}
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Key < manifest.Files[j].Key })
+
+	var err error
+	if manifest.Root, err = DirManifestRoot(manifest.Files); err != nil {
+		return manifest, err
+	}
+
+	key := uploadObject.BucketDir + uploadObject.S3FileName + DirManifestSuffix
+	if dryRun {
+		log.Info.Printf("Skipping upload of manifest: '%s' with Merkle root: %s as dry run has been enabled\n", key, manifest.Root)
+		return manifest, nil
+	}
+
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+
+	uploadParams := &s3manager.UploadInput{
+		Bucket:      aws.String(uploadObject.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+		Metadata:    map[string]*string{VersionMetadataKey: aws.String(version.Version)},
+	}
+
+	applyObjectAttributes(uploadParams, uploadObject)
+
+	log.Info.Printf("Uploading manifest of %d files to key: '%s' with Merkle root: %s\n", len(manifest.Files), key, manifest.Root)
+	_, err = s3manager.NewUploaderWithClient(svc).Upload(uploadParams)
+	return manifest, err
+}
@@ -0,0 +1,72 @@
+package upload
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Returns whether an object identical to the file already exists under the key, i.e. it has the size of the file to
+// upload and a checksum which matches it. The md5sum of the source recorded on upload is compared if the object has
+// one, otherwise its ETag is compared with the md5sum of the file to upload or, if it was uploaded in parts, the
+// composite ETag of the file in parts of the part size. The file to upload differs from the source if it is compressed
+func checkObjectExists(ctx context.Context, svc *s3.S3, bucket string, key string, pathToFile string, pathToUpload string, fileSize int64, partSize int64) (bool, error) {
+	head, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if size := aws.Int64Value(head.ContentLength); size != fileSize {
+		log.Info.Printf("Key: '%s' already exists but is %d bytes rather than the %d bytes of '%s'\n", key, size, fileSize, pathToUpload)
+		return false, nil
+	}
+
+	for metadataKey, value := range head.Metadata {
+		if http.CanonicalHeaderKey(metadataKey) == ChecksumMetadataKey {
+			md5sum, err := computeHexMD5SumWithContext(ctx, pathToFile)
+			if err != nil {
+				return false, err
+			}
+			return aws.StringValue(value) == md5sum, nil
+		}
+	}
+
+	file, err := os.Open(pathToUpload)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	// The md5sum and the composite ETag are computed with a single read of the file
+	localETag, err := StreamETag(&contextReader{ctx: ctx, reader: file}, partSize, true)
+	if err != nil {
+		return false, err
+	}
+
+	etag := strings.Trim(aws.StringValue(head.ETag), "\"")
+	switch {
+	case aws.StringValue(head.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms:
+		log.Info.Printf("Key: '%s' already exists but is encrypted with SSE-KMS so its ETag cannot be compared with '%s'\n", key, pathToUpload)
+		return false, nil
+	case strings.Contains(etag, "-"):
+		if etag != localETag.ETag {
+			log.Info.Printf("Key: '%s' already exists but its ETag '%s' does not match the composite ETag '%s' of '%s' in parts of %d bytes\n",
+				key, etag, localETag.ETag, pathToUpload, partSize)
+			return false, nil
+		}
+		return true, nil
+	default:
+		return etag == localETag.MD5, nil
+	}
+}
@@ -0,0 +1,92 @@
+package upload
+
+import (
+	"bufio"
+	"encoding/json"
+	"s3backup/log"
+	"os"
+	"sync"
+)
+
+// LedgerEntry records a file of a directory upload which completed. It is written to the ledger as a single line of
+// newline delimited JSON as soon as the upload of the file completes
+type LedgerEntry struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	Bytes    int64  `json:"bytes"`
+	Checksum string `json:"checksum"` // Hex encoded md5sum of the local file
+}
+
+// The files of directory uploads which completed, keyed by bucket and key. Entries are appended to the file so that
+// the progress of an upload which is interrupted is kept
+type ledger struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]LedgerEntry
+}
+
+// Loads the ledger at the path. A missing ledger is empty. A line which cannot be parsed, such as the last line of a
+// ledger whose run was killed while writing it, is skipped so the file it records is uploaded again
+func loadLedger(path string) (*ledger, error) {
+	l := &ledger{path: path, entries: make(map[string]LedgerEntry)}
+
+	fd, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Warn.Printf("Skipping unreadable entry of ledger '%s': %v\n", path, err)
+			continue
+		}
+		l.entries[ledgerID(entry.Bucket, entry.Key)] = entry
+	}
+
+	return l, scanner.Err()
+}
+
+// Returns true if the ledger records the key as uploaded to the bucket with the size and checksum
+func (l *ledger) completed(bucket string, key string, bytes int64, checksum string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[ledgerID(bucket, key)]
+	return ok && entry.Bytes == bytes && entry.Checksum == checksum
+}
+
+// Appends the entry to the ledger
+func (l *ledger) record(entry LedgerEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fd, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if _, err = fd.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.entries[ledgerID(entry.Bucket, entry.Key)] = entry
+	return nil
+}
+
+func ledgerID(bucket string, key string) string {
+	return bucket + "/" + key
+}
@@ -0,0 +1,32 @@
+package upload
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sort"
+)
+
+// Request option which is applied to every request made by the uploader. Workers finish their parts in any order, so
+// the parts are sorted by part number before CompleteMultipartUpload is built as S3 rejects parts out of order
+func sortCompletedParts(req *request.Request) {
+	req.Handlers.Build.PushFront(func(req *request.Request) {
+		input, ok := req.Params.(*s3.CompleteMultipartUploadInput)
+		if !ok || input.MultipartUpload == nil {
+			return
+		}
+		parts := input.MultipartUpload.Parts
+		sort.SliceStable(parts, func(i, j int) bool {
+			return aws.Int64Value(parts[i].PartNumber) < aws.Int64Value(parts[j].PartNumber)
+		})
+	})
+}
+
+// Returns the number of workers to upload the parts with. Parts are only uploaded strictly in order of their part
+// number by a single worker, for providers which require parts in order or limit concurrent UploadPart requests
+func partWorkers(uploadObject UploadObject) int {
+	if uploadObject.UploadPartOrdered {
+		return 1
+	}
+	return uploadObject.NumWorkers
+}
@@ -0,0 +1,70 @@
+package upload
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"io"
+	"os"
+	"time"
+)
+
+// The phases of an upload which each have their own timeout
+const (
+	PhaseHash   = "hash"   // The source is read to compare its md5sum with SkipIfUnchanged and SkipIfExists
+	PhaseUpload = "upload" // The source is uploaded
+	PhaseVerify = "verify" // The uploaded object is verified against the source once the upload has completed
+)
+
+// Returns a context of the phase which expires once the timeout has elapsed, or which never expires if the timeout
+// is 0. Each phase derives its own context from the parent so that a slow phase does not consume the timeout of the next
+func phaseContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// Returns an error naming the phase and its timeout if the error was caused by the context of the phase expiring,
+// otherwise the error is returned unchanged
+func phaseError(ctx context.Context, phase string, timeout time.Duration, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() != request.CanceledErrorCode {
+		return err
+	}
+	return fmt.Errorf("%s phase exceeded its timeout of %v: %v", phase, timeout, err)
+}
+
+// Reader which fails with the error of its context once the context has expired, so that reading a file to hash it
+// stops once the timeout of the phase has elapsed
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// Returns the hex encoded md5sum of the file. Reading the file stops once the context has expired
+func computeHexMD5SumWithContext(ctx context.Context, pathToFile string) (string, error) {
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err = io.Copy(hash, &contextReader{ctx: ctx, reader: file}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,175 @@
+package upload
+
+import (
+	"s3backup/log"
+	"io"
+	"sync"
+	"time"
+)
+
+// Reports the total number of bytes read from the reader to the progress function after every read
+type progressReader struct {
+	r           io.Reader
+	fn          func(bytesTransferred int64, totalBytes int64)
+	total       int64
+	transferred int64
+}
+
+// Wraps the reader so that the progress function is called as it is read. The reader is returned unchanged if there is
+// no progress function. A file which the uploader can read each part of at its offset is wrapped so that it can still
+// seek and is read in the same way, otherwise the wrapped reader can no longer seek so the uploader buffers each part
+// in memory
+func withProgress(r io.Reader, fn func(int64, int64), total int64) io.Reader {
+	if fn == nil {
+		return r
+	}
+	if file, ok := r.(readerAtSeeker); ok {
+		return &progressReaderAt{file: file, fn: fn, total: total}
+	}
+	return &progressReader{r: r, fn: fn, total: total}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.transferred += int64(n)
+		p.fn(p.transferred, p.total)
+	}
+	return n, err
+}
+
+// Matches the bodies the uploader reads each part of with ReadAt rather than buffering them
+type readerAtSeeker interface {
+	io.ReaderAt
+	io.ReadSeeker
+}
+
+// Reports the number of bytes read from the file to the progress function as the workers of the uploader read their
+// parts at once. Each part is read more than once, e.g. to sign the request and again to send it or when the request
+// is retried, so only the first read of each byte is counted
+type progressReaderAt struct {
+	file  readerAtSeeker
+	fn    func(bytesTransferred int64, totalBytes int64)
+	total int64
+
+	mu          sync.Mutex
+	offset      int64      // Offset of the next Read
+	read        [][2]int64 // Sorted and merged ranges of the file which have been read
+	transferred int64
+}
+
+func (p *progressReaderAt) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	offset := p.offset
+	p.mu.Unlock()
+
+	n, err := p.file.Read(b)
+
+	p.mu.Lock()
+	p.offset = offset + int64(n)
+	p.mu.Unlock()
+
+	p.record(offset, n)
+	return n, err
+}
+
+func (p *progressReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := p.file.ReadAt(b, off)
+	p.record(off, n)
+	return n, err
+}
+
+func (p *progressReaderAt) Seek(offset int64, whence int) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pos, err := p.file.Seek(offset, whence)
+	if err == nil {
+		p.offset = pos
+	}
+	return pos, err
+}
+
+// Adds the range read to the ranges already read and reports the progress if any of it had not been read before
+func (p *progressReaderAt) record(off int64, n int) {
+	if n <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous := p.transferred
+	start, end := off, off+int64(n)
+	ranges := make([][2]int64, 0, len(p.read)+1)
+	for _, r := range p.read {
+		if r[1] < start || r[0] > end {
+			ranges = append(ranges, r)
+			continue
+		}
+		// The range overlaps or adjoins the range read, so they are merged and its bytes are not counted twice
+		p.transferred -= r[1] - r[0]
+		if r[0] < start {
+			start = r[0]
+		}
+		if r[1] > end {
+			end = r[1]
+		}
+	}
+	p.transferred += end - start
+
+	i := 0
+	for i < len(ranges) && ranges[i][0] < start {
+		i++
+	}
+	ranges = append(ranges, [2]int64{})
+	copy(ranges[i+1:], ranges[i:])
+	ranges[i] = [2]int64{start, end}
+	p.read = ranges
+
+	if p.transferred > previous {
+		p.fn(p.transferred, p.total)
+	}
+}
+
+// NewProgressLogger returns a progress function which logs the percentage of the total read by the uploader and the
+// throughput at most once per interval, and once the total has been read. The function may be shared by the uploads of
+// each file of a directory, a new upload begins whenever the total changes or fewer bytes have been transferred. A
+// negative total is unknown, e.g. an upload from stdin, and only the bytes transferred are logged
+func NewProgressLogger(interval time.Duration) func(bytesTransferred int64, totalBytes int64) {
+	var mu sync.Mutex
+	var started, logged time.Time
+	var lastTransferred, lastTotal int64
+	finished := false
+
+	return func(bytesTransferred int64, totalBytes int64) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now := time.Now()
+		if started.IsZero() || totalBytes != lastTotal || bytesTransferred < lastTransferred {
+			started, logged, finished = now, now, false
+		}
+		lastTransferred, lastTotal = bytesTransferred, totalBytes
+
+		done := totalBytes >= 0 && bytesTransferred >= totalBytes
+		if finished || (!done && now.Sub(logged) < interval) {
+			return
+		}
+		logged, finished = now, done
+
+		percent := 100.0
+		if totalBytes > 0 {
+			percent = float64(bytesTransferred) / float64(totalBytes) * 100
+		}
+		throughput := 0.0
+		if elapsed := now.Sub(started).Seconds(); elapsed > 0 {
+			throughput = float64(bytesTransferred) / elapsed / (1024 * 1024)
+		}
+		if totalBytes < 0 {
+			log.Info.Printf("Upload progress: %d bytes at %0.2f MiB/s\n", bytesTransferred, throughput)
+			return
+		}
+		log.Info.Printf("Upload progress: %0.1f%% (%d/%d bytes) at %0.2f MiB/s\n", percent, bytesTransferred, totalBytes, throughput)
+	}
+}
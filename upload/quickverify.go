@@ -0,0 +1,95 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Number of bytes at the start and at the end of the uploaded object compared with the source by QuickVerify
+const quickVerifyBytes = 4096
+
+// Compares the first and last bytes of the uploaded object, fetched with a ranged GET each, with the same bytes of the
+// file it was uploaded from and checks that the object is the size of the file. This is far cheaper than reading the
+// whole file again but only catches gross corruption such as a truncated object or the wrong file being uploaded
+func quickVerify(ctx context.Context, svc *s3.S3, bucket string, key string, pathToUpload string) error {
+	file, err := os.Open(pathToUpload)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil // An empty object has no bytes to compare and its size is checked by the upload
+	}
+
+	head := size
+	if head > quickVerifyBytes {
+		head = quickVerifyBytes
+	}
+	if err = compareRange(ctx, svc, bucket, key, file, size, 0, head-1); err != nil {
+		return err
+	}
+
+	// The last bytes do not overlap the first bytes of a file shorter than both ranges
+	tail := size - quickVerifyBytes
+	if tail < head {
+		tail = head
+	}
+	if tail < size {
+		return compareRange(ctx, svc, bucket, key, file, size, tail, size-1)
+	}
+	return nil
+}
+
+// Fetches the inclusive range of the object and compares it with the same range of the file
+func compareRange(ctx context.Context, svc *s3.S3, bucket string, key string, file *os.File, size int64, start int64, end int64) error {
+	resp, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+	})
+	if err != nil {
+		return fmt.Errorf("quick verification failed to get bytes %d-%d of key: '%s' which should be %d bytes: %v", start, end, key, size, err)
+	}
+	defer resp.Body.Close()
+
+	if objectSize, ok := contentRangeSize(aws.StringValue(resp.ContentRange)); ok && objectSize != size {
+		return fmt.Errorf("quick verification failed as key: '%s' is %d bytes but '%s' is %d bytes", key, objectSize, file.Name(), size)
+	}
+
+	uploaded, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	expected := make([]byte, end-start+1)
+	if _, err = file.ReadAt(expected, start); err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Equal(uploaded, expected) {
+		return fmt.Errorf("quick verification failed as bytes %d-%d of key: '%s' do not match '%s'", start, end, key, file.Name())
+	}
+	return nil
+}
+
+// Returns the total size of the object from the Content-Range of a ranged GET, e.g. 1024 from bytes 0-99/1024
+func contentRangeSize(contentRange string) (int64, bool) {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	return size, err == nil
+}
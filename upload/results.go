@@ -0,0 +1,50 @@
+package upload
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Result statuses written to the results file
+const (
+	ResultStatusSuccess = "success"
+	ResultStatusFailed  = "failed"
+	ResultStatusDryRun  = "dryrun"
+	ResultStatusSkipped = "skipped"
+)
+
+// UploadResult represents the outcome of uploading a single file.
+// It is written to the results file as a single line of newline delimited JSON
+type UploadResult struct {
+	Key      string  `json:"key"`
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"duration"` // Seconds
+	Checksum string  `json:"checksum"` // Hex encoded md5sum of the local file
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Appends the upload result for the file to the results file as a single NDJSON line
+func writeResult(resultsFile string, pathToFile string, result UploadResult) error {
+	if result.Checksum == "" && pathToFile != StdinPath { // The content of stdin is only hashed if it was uploaded
+		md5sum, err := computeHexMD5Sum(pathToFile)
+		if err != nil {
+			return err
+		}
+		result.Checksum = md5sum
+	}
+
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.OpenFile(resultsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	_, err = fd.Write(append(line, '\n'))
+	return err
+}
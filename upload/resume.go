@@ -0,0 +1,339 @@
+package upload
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/log"
+	"s3backup/s3client"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// An interrupted multipart upload of the file which is resumed by uploading only the parts S3 does not report as uploaded
+type resumableUpload struct {
+	Key      string
+	UploadID string
+	PartSize int64
+	Parts    map[int64]*s3.Part // The parts already uploaded by part number
+}
+
+// Failure of a resumed upload. It reports its upload ID in the same way as a failure of the uploader so that its parts
+// are handled by handleFailedUpload
+type resumeFailure struct {
+	err      awserr.Error
+	uploadID string
+}
+
+func (f resumeFailure) Error() string   { return f.err.Error() }
+func (f resumeFailure) Code() string    { return f.err.Code() }
+func (f resumeFailure) Message() string { return f.err.Message() }
+func (f resumeFailure) OrigErr() error  { return f.err.OrigErr() }
+func (f resumeFailure) UploadID() string {
+	return f.uploadID
+}
+
+// Returns the interrupted multipart upload of the file or nil if the file is uploaded from the start. The upload is
+// read from the resume state file if it records the file, otherwise it is found among the multipart uploads in
+// progress by its key. An upload of a file which has changed since it was interrupted is aborted so that the file is
+// uploaded again from the start
+func findResumableUpload(svc *s3.S3, uploadObject UploadObject, key string, fileInfo os.FileInfo, partSize int64) (*resumableUpload, error) {
+	resumable := &resumableUpload{Key: key, PartSize: partSize}
+
+	var state *ResumeState
+	if uploadObject.ResumeStateFile != "" {
+		loaded, err := LoadResumeState(uploadObject.ResumeStateFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil && loaded.Bucket == uploadObject.Bucket && loaded.PathToFile == uploadObject.PathToFile {
+			state = &loaded
+			resumable.Key, resumable.UploadID, resumable.PartSize = loaded.Key, loaded.UploadID, loaded.PartSize
+			log.Info.Printf("Found the interrupted upload of key: '%s' in resume state file '%s'\n", loaded.Key, uploadObject.ResumeStateFile)
+		}
+	}
+
+	if state == nil {
+		uploads, err := s3client.GetAllMultiPartUploads(svc, uploadObject.Bucket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the multipart uploads in progress: %v", err)
+		}
+		if resumable.UploadID = uploads[key]; resumable.UploadID == "" {
+			log.Info.Printf("No interrupted upload of key: '%s' was found to resume\n", key)
+			return nil, nil
+		}
+	}
+
+	var err error
+	resumable.Parts, err = listUploadedParts(svc, uploadObject.Bucket, resumable.Key, resumable.UploadID)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchUpload {
+		log.Warn.Printf("The interrupted upload of key: '%s' no longer exists, uploading the file from the start\n", resumable.Key)
+		clearResumeState(uploadObject, resumable.Key)
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list the uploaded parts of key '%s': %v", resumable.Key, err)
+	}
+
+	if state == nil && len(resumable.Parts) > 0 {
+		resumable.PartSize = 0 // Every part but the last is the size of the part size that the upload was started with
+		for _, part := range resumable.Parts {
+			if size := aws.Int64Value(part.Size); size > resumable.PartSize {
+				resumable.PartSize = size
+			}
+		}
+	}
+
+	if reason := resumable.changedReason(fileInfo, state); reason != "" {
+		log.Warn.Printf("Uploading '%s' from the start as %s since the upload of key: '%s' was interrupted\n", uploadObject.PathToFile, reason, resumable.Key)
+		_, err = svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(uploadObject.Bucket),
+			Key:      aws.String(resumable.Key),
+			UploadId: aws.String(resumable.UploadID),
+		})
+		if err != nil {
+			log.Error.Printf("Failed to abort the interrupted upload '%s' of key: '%s', its parts must be removed manually. Reason: %v\n", resumable.UploadID, resumable.Key, err)
+		}
+		clearResumeState(uploadObject, resumable.Key)
+		return nil, nil
+	}
+
+	if state != nil {
+		if err = resumable.verifyParts(uploadObject.PathToFile, *state); err != nil {
+			return nil, fmt.Errorf("failed to verify the uploaded parts of key '%s' against '%s': %v", resumable.Key, uploadObject.PathToFile, err)
+		}
+	}
+
+	log.Info.Printf("Resuming the interrupted upload of key: '%s' with %d of %d parts already uploaded\n",
+		resumable.Key, len(resumable.Parts), resumable.totalParts(fileInfo.Size()))
+	return resumable, nil
+}
+
+// Returns why the file can no longer be resumed, or an empty reason if the parts already uploaded still match it. The
+// size and modification time recorded in the resume state are compared when there is one. Otherwise the file must not
+// have been modified after any of its parts was uploaded
+func (r *resumableUpload) changedReason(fileInfo os.FileInfo, state *ResumeState) string {
+	fileSize := fileInfo.Size()
+
+	if state != nil && state.Bytes != fileSize {
+		return fmt.Sprintf("its size changed from %d to %d bytes", state.Bytes, fileSize)
+	}
+	// A file no larger than a single part is uploaded with a single PUT rather than completing the upload with an empty
+	// or single part
+	if fileSize <= r.PartSize {
+		return fmt.Sprintf("its size of %d bytes fits in a single part", fileSize)
+	}
+	if state != nil && !state.ModTime.IsZero() && !state.ModTime.Equal(fileInfo.ModTime()) {
+		return fmt.Sprintf("it was modified at %s", fileInfo.ModTime().UTC())
+	}
+
+	totalParts := r.totalParts(fileSize)
+	for partNumber, part := range r.Parts {
+		expected := r.PartSize
+		if partNumber == totalParts {
+			expected = fileSize - (totalParts-1)*r.PartSize
+		}
+		if partNumber > totalParts || aws.Int64Value(part.Size) != expected {
+			return fmt.Sprintf("its size of %d bytes no longer matches part %d of %d bytes", fileSize, partNumber, aws.Int64Value(part.Size))
+		}
+		if (state == nil || state.ModTime.IsZero()) && fileInfo.ModTime().After(aws.TimeValue(part.LastModified)) {
+			return fmt.Sprintf("it was modified at %s after part %d was uploaded", fileInfo.ModTime().UTC(), partNumber)
+		}
+	}
+	return ""
+}
+
+// Removes every uploaded part which no longer matches the part recorded in the resume state so that it is uploaded
+// again. A part matches if it was uploaded from the same offset with the same ETag and the bytes of the file at its
+// offset still have the recorded md5sum, so a file which changed without its size or modification time changing is
+// never completed from parts of its earlier contents. A resume state written without md5sums is not compared
+func (r *resumableUpload) verifyParts(pathToFile string, state ResumeState) error {
+	recorded := make(map[int64]ResumePart)
+	for _, part := range state.Parts {
+		if part.MD5 == "" {
+			return nil
+		}
+		recorded[part.PartNumber] = part
+	}
+
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for partNumber, part := range r.Parts {
+		offset := (partNumber - 1) * r.PartSize
+		size := aws.Int64Value(part.Size)
+
+		reason := ""
+		if record, ok := recorded[partNumber]; !ok {
+			reason = "it is not recorded in the resume state file"
+		} else if record.Offset != offset || record.Size != size || record.ETag != aws.StringValue(part.ETag) {
+			reason = "it no longer matches the part recorded in the resume state file"
+		} else {
+			md5sum, err := partMD5(file, offset, size)
+			if err != nil {
+				return err
+			}
+			if md5sum != record.MD5 {
+				reason = fmt.Sprintf("the md5sum of the file at its offset changed from %s to %s", record.MD5, md5sum)
+			}
+		}
+
+		if reason != "" {
+			log.Warn.Printf("Uploading part %d of key: '%s' again as %s\n", partNumber, r.Key, reason)
+			delete(r.Parts, partNumber)
+		}
+	}
+	return nil
+}
+
+// Returns the hex encoded md5sum of the size bytes of the file at the offset
+func partMD5(file io.ReaderAt, offset int64, size int64) (string, error) {
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(file, offset, size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Returns the number of parts of the file
+func (r *resumableUpload) totalParts(fileSize int64) int64 {
+	if fileSize == 0 {
+		return 1
+	}
+	return (fileSize + r.PartSize - 1) / r.PartSize
+}
+
+// Uploads the parts of the file missing from the interrupted upload with the workers of the upload object and
+// completes the upload with every part in order
+func resumeUpload(ctx aws.Context, svc *s3.S3, uploadObject UploadObject, uploadParams *s3manager.UploadInput, resumable *resumableUpload,
+	file *os.File, fileSize int64) (*s3manager.UploadOutput, error) {
+	totalParts := resumable.totalParts(fileSize)
+
+	var mu sync.Mutex
+	var uploadErr error
+	completed := []*s3.CompletedPart{}
+	transferred := int64(0)
+
+	missing := make(chan int64, totalParts)
+	for partNumber := int64(1); partNumber <= totalParts; partNumber++ {
+		if part, ok := resumable.Parts[partNumber]; ok {
+			completed = append(completed, &s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+			transferred += aws.Int64Value(part.Size)
+		} else {
+			missing <- partNumber
+		}
+	}
+	close(missing)
+
+	log.Info.Printf("Uploading the %d missing parts of key: '%s' (%d of %d bytes already uploaded)\n", len(missing), resumable.Key, transferred, fileSize)
+	if uploadObject.ProgressFn != nil {
+		uploadObject.ProgressFn(transferred, fileSize)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < partWorkers(uploadObject); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range missing {
+				mu.Lock()
+				failed := uploadErr != nil
+				mu.Unlock()
+				if failed {
+					return
+				}
+
+				offset := (partNumber - 1) * resumable.PartSize
+				size := resumable.PartSize
+				if offset+size > fileSize {
+					size = fileSize - offset
+				}
+
+				output, err := svc.UploadPartWithContext(ctx, &s3.UploadPartInput{
+					Bucket:     uploadParams.Bucket,
+					Key:        aws.String(resumable.Key),
+					UploadId:   aws.String(resumable.UploadID),
+					PartNumber: aws.Int64(partNumber),
+					Body:       io.NewSectionReader(file, offset, size),
+				}, rateLimitOptions(uploadObject)...)
+
+				mu.Lock()
+				if err != nil && uploadErr == nil {
+					uploadErr = err
+				} else if err == nil {
+					completed = append(completed, &s3.CompletedPart{ETag: output.ETag, PartNumber: aws.Int64(partNumber)})
+					transferred += size
+					if uploadObject.ProgressFn != nil {
+						uploadObject.ProgressFn(transferred, fileSize)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if uploadErr != nil {
+		return nil, resumeFailure{awserr.New("MultipartUpload", "resumed upload multipart failed", uploadErr), resumable.UploadID}
+	}
+
+	sort.Slice(completed, func(i, j int) bool {
+		return aws.Int64Value(completed[i].PartNumber) < aws.Int64Value(completed[j].PartNumber)
+	})
+
+	output, err := svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          uploadParams.Bucket,
+		Key:             aws.String(resumable.Key),
+		UploadId:        aws.String(resumable.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return nil, resumeFailure{awserr.New("MultipartUpload", "resumed upload multipart failed", err), resumable.UploadID}
+	}
+
+	log.Info.Printf("Resumed upload of key: '%s' completed with %d parts\n", resumable.Key, len(completed))
+	return &s3manager.UploadOutput{
+		Location:  aws.StringValue(output.Location),
+		VersionID: output.VersionId,
+		UploadID:  resumable.UploadID,
+		ETag:      output.ETag,
+	}, nil
+}
+
+// Returns every part of the multipart upload S3 reports as uploaded by part number
+func listUploadedParts(svc *s3.S3, bucket string, key string, uploadID string) (map[int64]*s3.Part, error) {
+	parts := make(map[int64]*s3.Part)
+	err := svc.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			parts[aws.Int64Value(part.PartNumber)] = part
+		}
+		return true
+	})
+	return parts, err
+}
+
+// Removes the resume state file if it records the upload of the key, as the upload has completed or can no longer be resumed
+func clearResumeState(uploadObject UploadObject, key string) {
+	if uploadObject.ResumeStateFile == "" {
+		return
+	}
+	state, err := LoadResumeState(uploadObject.ResumeStateFile)
+	if err != nil || state.Bucket != uploadObject.Bucket || state.Key != key {
+		return
+	}
+	if err = os.Remove(uploadObject.ResumeStateFile); err != nil {
+		log.Warn.Printf("Failed to remove resume state file '%s': %v\n", uploadObject.ResumeStateFile, err)
+	}
+}
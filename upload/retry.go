@@ -0,0 +1,95 @@
+package upload
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Delay before the first retry of an upload. The delay doubles for every further retry up to retryMaxDelay and a
+// random jitter of up to half the delay is taken off so that the runs of many hosts do not retry in step
+var retryBaseDelay = time.Second
+var retryMaxDelay = time.Second * 30
+
+// Error codes of transient failures which S3 and other providers report without a status code which is retried
+var retriableErrorCodes = map[string]bool{
+	"SlowDown":            true,
+	"Throttling":          true,
+	"ThrottlingException": true,
+	"RequestTimeout":      true,
+	"InternalError":       true,
+	"ServiceUnavailable":  true,
+}
+
+// Uploads the file retrying an upload which fails with a retriable error up to MaxRetries times with exponential
+// backoff. Every attempt uploads the file again with its own timeout to the key of the first attempt, and an
+// interrupted upload is resumed by the retry if the upload object resumes. An upload from stdin is never retried
+func uploadFileWithRetries(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun bool) (UploadResult, error) {
+	if isStdin(uploadObject) {
+		uploadObject.MaxRetries = 0 // The content read by the failed attempt cannot be read again
+	}
+
+	// The key and tags of every attempt use the same time, which is kept if it was fixed before the tier was classified
+	uploadObject, _, err := WithKeyTime(uploadObject)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	result, err := uploadFileWithResult(svc, uploadObject, prefix, dryRun)
+
+	for attempt := 1; attempt <= uploadObject.MaxRetries && err != nil && isRetriableError(err); attempt++ {
+		delay := retryDelay(attempt)
+		log.Warn.Printf("Upload of '%s' failed with a transient error, retrying in %s (attempt %d of %d): %v\n",
+			uploadObject.PathToFile, delay, attempt+1, uploadObject.MaxRetries+1, err)
+		time.Sleep(delay)
+
+		result, err = uploadFileWithResult(svc, uploadObject, prefix, dryRun)
+	}
+	return result, err
+}
+
+// Returns the delay before the retry of the attempt
+func retryDelay(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if shift := uint(attempt - 1); shift < 32 && retryBaseDelay<<shift < retryMaxDelay {
+		delay = retryBaseDelay << shift
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay - time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Returns true if the upload failed with a transient error which may succeed if the upload is retried, i.e. a 5xx or
+// 429 response, throttling or a request which failed to be sent such as a reset connection. Any other response such as
+// 400 or 403 is fatal, as is an upload which timed out or was cancelled. The errors of the parts of a multipart upload
+// are wrapped by the uploader
+func isRetriableError(err error) bool {
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return false
+	}
+
+	for err != nil {
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return false
+		}
+		if aerr.Code() == request.CanceledErrorCode {
+			return false
+		}
+		if failure, ok := err.(awserr.RequestFailure); ok && failure.StatusCode() != 0 {
+			return failure.StatusCode() >= http.StatusInternalServerError || failure.StatusCode() == http.StatusTooManyRequests ||
+				retriableErrorCodes[failure.Code()]
+		}
+		if retriableErrorCodes[aerr.Code()] || aerr.Code() == request.ErrCodeRequestError || aerr.Code() == request.ErrCodeResponseTimeout {
+			return true
+		}
+		err = aerr.OrigErr()
+	}
+	return false
+}
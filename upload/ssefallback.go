@@ -0,0 +1,23 @@
+package upload
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"strings"
+)
+
+// Returns true if the upload failed as S3 could not use the KMS key to encrypt the object, e.g. as the key is disabled,
+// pending deletion or KMS throttled the request. S3 reports these errors with the code of the KMS error prefixed with
+// 'KMS.'. The errors of the parts of a multipart upload are wrapped by the uploader
+func isKMSError(err error) bool {
+	for err != nil {
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return false
+		}
+		if strings.HasPrefix(aerr.Code(), "KMS.") {
+			return true
+		}
+		err = aerr.OrigErr()
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package upload
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/log"
+	"s3backup/version"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// StdinPath is the path to file which uploads the content read from stdin, e.g. a dump piped into s3backup
+const StdinPath = "-"
+
+// The reader the content of an upload from stdin is read from
+var stdin io.Reader = os.Stdin
+
+// Returns true if the upload object uploads the content read from stdin
+func isStdin(uploadObject UploadObject) bool {
+	return uploadObject.PathToFile == StdinPath
+}
+
+// Returns an error if the upload object reads from stdin with an option which requires a file that can be read again
+// or whose size is known before the upload starts
+func validateStdin(uploadObject UploadObject) error {
+	if uploadObject.S3FileName == "" {
+		return errors.New("s3FileName must be specified when uploading from stdin as there is no file name to derive the key from")
+	}
+
+	unsupported := []string{}
+	if strings.ToLower(uploadObject.TimeSource) == TimeSourceFileMtime {
+		unsupported = append(unsupported, "a time source of filemtime")
+	}
+	if uploadObject.Compression != "" {
+		unsupported = append(unsupported, "compression")
+	}
+	if len(uploadObject.EncryptionKey) > 0 {
+		unsupported = append(unsupported, "encryption")
+	}
+	if uploadObject.SkipIfUnchanged {
+		unsupported = append(unsupported, "skip if unchanged")
+	}
+	if uploadObject.SkipIfExists {
+		unsupported = append(unsupported, "skip if exists")
+	}
+	if uploadObject.Resume || strings.ToLower(uploadObject.OnTimeout) == OnTimeoutPreserve {
+		unsupported = append(unsupported, "resuming the upload")
+	}
+	if uploadObject.VerifyChecksum || uploadObject.StrongVerify {
+		unsupported = append(unsupported, "verifying the ETag, use a checksum algorithm instead")
+	}
+	if uploadObject.QuickVerify {
+		unsupported = append(unsupported, "quick verification")
+	}
+
+	if len(unsupported) > 0 {
+		return fmt.Errorf("uploading from stdin is not supported with: %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// Counts the bytes read from stdin and fails the upload once more than the maximum file size has been read
+type stdinReader struct {
+	r     io.Reader
+	read  int64
+	max   int64
+	force bool
+}
+
+func (s *stdinReader) Read(b []byte) (int, error) {
+	n, err := s.r.Read(b)
+	s.read += int64(n)
+	if s.max > 0 && !s.force && s.read > s.max {
+		return n, fmt.Errorf("stdin exceeds the maximum file size of %d bytes, use --force to upload it anyway", s.max)
+	}
+	return n, err
+}
+
+// Uploads the content read from stdin until it is closed. The size of the content is unknown and stdin cannot seek, so
+// the uploader buffers parts of the part size in memory and uploads the content in parts once it exceeds a single
+// part. The content is limited to the maximum number of parts of the part size. A failed upload is aborted and the
+// upload is never retried as the content cannot be read again
+func uploadStdin(ctx context.Context, svc *s3.S3, uploadObject UploadObject, prefix string, dryRun bool) (UploadResult, error) {
+	s3FileName, err := getS3FileName(uploadObject, prefix)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	if len(uploadObject.Tags) > 0 {
+		keyTime, err := GetKeyTime(uploadObject)
+		if err != nil {
+			return UploadResult{}, err
+		}
+		uploadObject.Tags, err = renderTags(uploadObject.Tags, keyTime, prefix)
+		if err != nil {
+			return UploadResult{}, err
+		}
+	}
+
+	partSize := int64(uploadObject.PartSize * 1024 * 1024)
+	log.Info.Printf("Uploading stdin to s3 bucket '%s' in parts of %d bytes, up to %d bytes can be uploaded\n",
+		uploadObject.Bucket, partSize, partSize*s3manager.MaxUploadParts)
+
+	uploadObject, err = resolveStorageClassMismatch(svc, uploadObject, s3FileName)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	if dryRun {
+		log.Info.Printf("Skipping upload of stdin to key: '%s' as dry run has been enabled\n", s3FileName)
+		return UploadResult{Key: s3FileName, Status: ResultStatusDryRun}, nil
+	}
+
+	reader := bufio.NewReaderSize(stdin, sniffLength)
+	source := &stdinReader{r: reader, max: uploadObject.MaxFileBytes, force: uploadObject.Force}
+	hasher := md5.New()
+
+	uploadParams := &s3manager.UploadInput{
+		Bucket:   aws.String(uploadObject.Bucket),
+		Key:      aws.String(s3FileName),
+		Body:     withProgress(io.TeeReader(source, hasher), uploadObject.ProgressFn, -1),
+		Metadata: map[string]*string{VersionMetadataKey: aws.String(version.Version)},
+	}
+
+	applyObjectAttributes(uploadParams, uploadObject)
+
+	// The content type is sniffed from the start of stdin without consuming it
+	contentType := uploadObject.ContentType
+	if contentType == "" {
+		if peeked, _ := reader.Peek(sniffLength); len(peeked) > 0 {
+			contentType = http.DetectContentType(peeked)
+		}
+	}
+	if contentType != "" {
+		log.Info.Printf("Uploading key: '%s' with content type '%s'\n", s3FileName, contentType)
+		uploadParams.ContentType = aws.String(contentType)
+	}
+
+	checksums := newChecksumRecorder(uploadObject.ChecksumAlgorithm)
+	if uploadObject.ChecksumAlgorithm != "" {
+		uploadParams.ChecksumAlgorithm = aws.String(uploadObject.ChecksumAlgorithm)
+	}
+
+	uploader := s3manager.NewUploaderWithClient(svc, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = partWorkers(uploadObject) // Each worker buffers a part in memory
+		u.LeavePartsOnError = true                // The parts of a failed upload are aborted by handleFailedUpload
+		u.RequestOptions = append(u.RequestOptions, sortCompletedParts)
+		if uploadObject.ChecksumAlgorithm != "" {
+			u.RequestOptions = append(u.RequestOptions, checksums.requestOption)
+		}
+		u.RequestOptions = append(u.RequestOptions, rateLimitOptions(uploadObject)...)
+	})
+
+	startTime := time.Now()
+	_, err = uploader.UploadWithContext(ctx, uploadParams)
+	if err != nil {
+		err = handleFailedUpload(svc, uploadObject, uploadParams, partSize, source.read, err, ctx.Err() == context.DeadlineExceeded)
+	} else if uploadObject.ChecksumAlgorithm != "" {
+		log.Info.Printf("Verifying the %s checksum of key: '%s'\n", uploadObject.ChecksumAlgorithm, s3FileName)
+		verifyCtx, cancelVerify := phaseContext(context.Background(), uploadObject.VerifyTimeout)
+		err = phaseError(verifyCtx, PhaseVerify, uploadObject.VerifyTimeout, verifyChecksum(verifyCtx, svc, uploadParams, checksums))
+		cancelVerify()
+		if err == nil {
+			log.Info.Printf("Checksum verification passed for key: '%s'\n", s3FileName)
+		}
+	}
+	elapsedTime := time.Since(startTime).Seconds()
+
+	log.Info.Printf("Uploaded %d bytes from stdin in %0.2f seconds\n", source.read, elapsedTime)
+
+	result := UploadResult{Key: s3FileName, Bytes: source.read, Duration: elapsedTime, Status: ResultStatusSuccess}
+	if err != nil {
+		result.Status = ResultStatusFailed
+		result.Error = err.Error()
+	} else {
+		result.Checksum = hex.EncodeToString(hasher.Sum(nil))
+	}
+	return result, err
+}
@@ -0,0 +1,83 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/log"
+	"strings"
+)
+
+// What happens when an object already exists under the final key in a storage class other than the storage class of the upload
+const (
+	StorageClassMismatchOverwrite = "overwrite" // The object is replaced by the upload in the storage class of the upload
+	StorageClassMismatchKeep      = "keep"      // The object is replaced by the upload in the storage class of the existing object
+	StorageClassMismatchFail      = "fail"      // The upload fails and the existing object is left as it is
+)
+
+// StorageClassMismatchError is returned when an object already exists under the final key in a storage class other
+// than the storage class of the upload with StorageClassMismatchFail
+type StorageClassMismatchError struct {
+	Key                  string
+	ExistingStorageClass string
+	StorageClass         string
+}
+
+func (e *StorageClassMismatchError) Error() string {
+	return fmt.Sprintf("key '%s' already exists in storage class %s rather than the storage class %s of the upload",
+		e.Key, e.ExistingStorageClass, e.StorageClass)
+}
+
+// Returns the upload object with the storage class the upload to the key is made in. Unless the upload overwrites
+// the storage class of an existing object, the storage class of the object under the key is compared with the storage
+// class of the upload. An object without a storage class, and an upload without one, are in the STANDARD storage class
+func resolveStorageClassMismatch(svc *s3.S3, uploadObject UploadObject, key string) (UploadObject, error) {
+	mode := strings.ToLower(uploadObject.StorageClassMismatch)
+	if mode == "" || mode == StorageClassMismatchOverwrite {
+		return uploadObject, nil
+	}
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(uploadObject.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+			return uploadObject, nil
+		}
+		return uploadObject, fmt.Errorf("failed to check the storage class of existing key '%s': %v", key, err)
+	}
+
+	existing := storageClassOrStandard(aws.StringValue(head.StorageClass))
+	storageClass := storageClassOrStandard(uploadObject.StorageClass)
+	if existing == storageClass {
+		return uploadObject, nil
+	}
+
+	if mode == StorageClassMismatchFail {
+		return uploadObject, &StorageClassMismatchError{Key: key, ExistingStorageClass: existing, StorageClass: storageClass}
+	}
+
+	log.Info.Printf("Key: '%s' already exists in storage class %s, uploading in %s rather than %s to keep it\n", key, existing, existing, storageClass)
+	uploadObject.StorageClass = existing
+	return uploadObject, nil
+}
+
+// Returns the storage class, or STANDARD if it is empty as S3 omits the storage class of STANDARD objects
+func storageClassOrStandard(storageClass string) string {
+	if storageClass == "" {
+		return s3.StorageClassStandard
+	}
+	return storageClass
+}
+
+func storageClassMismatchValidationCheck(uploadObject UploadObject) error {
+	switch strings.ToLower(uploadObject.StorageClassMismatch) {
+	case "", StorageClassMismatchOverwrite, StorageClassMismatchKeep, StorageClassMismatchFail:
+		return nil
+	}
+	return errors.New("storage class mismatch must be one of '" + StorageClassMismatchOverwrite + "', '" +
+		StorageClassMismatchKeep + "' or '" + StorageClassMismatchFail + "'")
+}
@@ -0,0 +1,36 @@
+package upload
+
+import (
+	"fmt"
+	"s3backup/log"
+	"os"
+)
+
+// SkipSymlink returns true if the path to file is a symlink and skip symlink is enabled, in which case nothing is
+// uploaded and a warning is logged. Otherwise the file or directory the symlink links to is uploaded under the key of
+// the path to file. Returns an error if the path to file is a symlink whose target does not exist, whether or not it
+// is followed, so that a broken symlink is not mistaken for a missing backup source or silently skipped
+func SkipSymlink(uploadObject UploadObject) (bool, error) {
+	if isStdin(uploadObject) {
+		return false, nil
+	}
+
+	info, err := os.Lstat(uploadObject.PathToFile)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return false, nil // A missing file fails when it is opened for the upload
+	}
+
+	target, err := os.Readlink(uploadObject.PathToFile)
+	if err != nil {
+		return false, err
+	}
+	if _, err = os.Stat(uploadObject.PathToFile); err != nil {
+		return false, fmt.Errorf("path to file '%s' is a broken symlink to '%s': %v", uploadObject.PathToFile, target, err)
+	}
+
+	if uploadObject.SkipSymlink {
+		log.Warn.Printf("Skipping upload of '%s' as it is a symlink to '%s' and symlinks are not followed\n", uploadObject.PathToFile, target)
+		return true, nil
+	}
+	return false, nil
+}
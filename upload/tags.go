@@ -0,0 +1,82 @@
+package upload
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Tokens which may be used in the tag values of an upload object. They are rendered at upload time
+const (
+	TagTokenDate = "{date}" // The date of the key time of the upload, e.g. 2024-01-31
+	TagTokenHost = "{host}" // The hostname of the machine running the upload
+	TagTokenTier = "{tier}" // The rotation tier of the key without the trailing underscore, e.g. daily. Empty if the key is not manipulated
+)
+
+// S3 tag constraints
+const (
+	maxTags           = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// S3 only accepts letters, numbers, spaces and the characters + - = . _ : / @ in tag keys and values
+var validTagRegex = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+var tagTokenRegex = regexp.MustCompile(`\{[^{}]*\}`)
+
+// Returns the tags of the upload object with the tokens of every value rendered for an upload with the key time and
+// rotation prefix. Every rendered tag is validated against the S3 tag constraints so that an invalid tag fails the
+// upload before any request is made
+func renderTags(tags map[string]string, keyTime time.Time, prefix string) (map[string]string, error) {
+	if len(tags) == 0 {
+		return tags, nil
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render the %s tag token: %v", TagTokenHost, err)
+	}
+
+	replacer := strings.NewReplacer(
+		TagTokenDate, keyTime.Format("2006-01-02"),
+		TagTokenHost, host,
+		TagTokenTier, strings.TrimSuffix(prefix, "_"),
+	)
+
+	rendered := make(map[string]string)
+	for tagKey, tagValue := range tags {
+		renderedValue := replacer.Replace(tagValue)
+		if token := tagTokenRegex.FindString(renderedValue); token != "" {
+			return nil, fmt.Errorf("unknown token '%s' in the value of tag '%s', expected one of: %s, %s, %s", token, tagKey, TagTokenDate, TagTokenHost, TagTokenTier)
+		}
+		if err = validateTag(tagKey, renderedValue); err != nil {
+			return nil, err
+		}
+		rendered[tagKey] = renderedValue
+	}
+	return rendered, nil
+}
+
+// Returns an error if the tag does not satisfy the S3 tag constraints
+func validateTag(tagKey string, tagValue string) error {
+	if tagKey == "" || utf8.RuneCountInString(tagKey) > maxTagKeyLength {
+		return fmt.Errorf("invalid tag key '%s', expected between 1 and %d characters", tagKey, maxTagKeyLength)
+	}
+	if strings.HasPrefix(strings.ToLower(tagKey), "aws:") {
+		return fmt.Errorf("invalid tag key '%s', the aws: prefix is reserved", tagKey)
+	}
+	if !validTagRegex.MatchString(tagKey) {
+		return fmt.Errorf("invalid tag key '%s', expected only letters, numbers, spaces and the characters + - = . _ : / @", tagKey)
+	}
+	if utf8.RuneCountInString(tagValue) > maxTagValueLength {
+		return fmt.Errorf("invalid value of tag '%s', expected at most %d characters but got %d", tagKey, maxTagValueLength, utf8.RuneCountInString(tagValue))
+	}
+	if !validTagRegex.MatchString(tagValue) {
+		return fmt.Errorf("invalid value '%s' of tag '%s', expected only letters, numbers, spaces and the characters + - = . _ : / @", tagValue, tagKey)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package upload
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Token bucket which limits the bytes sent by every request it is applied to combined. The bucket fills at the rate
+// and holds at most one second of it, and a read which takes more bytes than the bucket holds is delayed until they
+// have been added, so the requests of every worker share the rate rather than each being limited to it
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes added to the bucket every second
+	burst  float64 // Bytes the bucket holds when full, the most a single read may send
+	tokens float64 // Bytes in the bucket. Negative when reads are waiting for bytes to be added
+	last   time.Time
+}
+
+// Returns a limiter of the rate which starts empty so that no more than the rate is sent in the first second
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{rate: float64(bytesPerSec), burst: math.Max(1, float64(bytesPerSec)), last: time.Now()}
+}
+
+// Returns the upload object with the rate limiter which its uploads share, created from MaxBytesPerSec if the upload
+// object does not already have one. Uploads run at once, such as the files of a directory or the uploads to each
+// destination, share the limiter of the upload object they were copied from
+func withRateLimiter(uploadObject UploadObject) UploadObject {
+	if uploadObject.limiter == nil && uploadObject.MaxBytesPerSec > 0 {
+		uploadObject.limiter = newRateLimiter(uploadObject.MaxBytesPerSec)
+	}
+	return uploadObject
+}
+
+// Takes n bytes from the bucket, waiting until the bucket has been filled with them unless the context is done first
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Request option which is applied to every request of an upload. The body is limited as it is sent rather than as it
+// is read by the uploader, as the body of each request is also read to sign it and again whenever it is retried
+func (l *rateLimiter) requestOption(req *request.Request) {
+	req.Handlers.Send.PushFront(func(req *request.Request) {
+		if body := req.HTTPRequest.Body; body != nil && body != request.NoBody {
+			req.HTTPRequest.Body = &limitedBody{body: body, limiter: l, ctx: req.Context()}
+		}
+	})
+}
+
+// Returns the request options which limit the rate of the requests of the upload object, if it has a rate limiter
+func rateLimitOptions(uploadObject UploadObject) []request.Option {
+	if uploadObject.limiter == nil {
+		return nil
+	}
+	return []request.Option{uploadObject.limiter.requestOption}
+}
+
+// Body of a request which takes every byte read from the bucket of the limiter before it is sent
+type limitedBody struct {
+	body    io.ReadCloser
+	limiter *rateLimiter
+	ctx     context.Context
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if max := int(b.limiter.burst); len(p) > max {
+		p = p[:max]
+	}
+	n, err := b.body.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.wait(b.ctx, n); waitErr != nil {
+			return 0, waitErr
+		}
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
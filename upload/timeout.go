@@ -0,0 +1,160 @@
+package upload
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/log"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// What happens to the parts of a multipart upload which times out
+const (
+	OnTimeoutAbort    = "abort"    // The multipart upload is aborted and its parts are deleted
+	OnTimeoutPreserve = "preserve" // The parts are kept and the state needed to resume the upload is written to the resume state file
+)
+
+// ResumeState is the state of a multipart upload which timed out with OnTimeoutPreserve. It records every part S3
+// reports as uploaded so that a later run can resume the upload rather than uploading the file again
+type ResumeState struct {
+	Bucket     string       `json:"bucket"`
+	Key        string       `json:"key"`
+	UploadID   string       `json:"uploadId"`
+	PathToFile string       `json:"pathToFile"`
+	Bytes      int64        `json:"bytes"`
+	ModTime    time.Time    `json:"modTime"` // Modification time of the file, the upload is restarted if the file is modified before it is resumed
+	PartSize   int64        `json:"partSize"`
+	Parts      []ResumePart `json:"parts"`
+	TimedOutAt time.Time    `json:"timedOutAt"`
+}
+
+// ResumePart is a part of a multipart upload which S3 reports as uploaded
+type ResumePart struct {
+	PartNumber int64  `json:"partNumber"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size"`
+	Offset     int64  `json:"offset"` // Offset of the part in the file
+	MD5        string `json:"md5"`    // Hex encoded md5sum of the bytes of the file the part was uploaded from, compared with the file before the part is skipped on resume
+}
+
+// LoadResumeState reads the resume state file written when a multipart upload timed out
+func LoadResumeState(path string) (ResumeState, error) {
+	var state ResumeState
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	if err = json.Unmarshal(contents, &state); err != nil {
+		return state, fmt.Errorf("failed to parse resume state file '%s': %v", path, err)
+	}
+	return state, nil
+}
+
+// Handles the parts of a failed multipart upload. The parts of an upload which timed out are preserved if the upload
+// object preserves them and the parts of any failed upload are preserved if it is resumable, otherwise they are
+// aborted. The uploader cannot abort an upload once its context has expired, so the upload is aborted here without
+// the context. Returns the error of the upload
+func handleFailedUpload(svc *s3.S3, uploadObject UploadObject, uploadParams *s3manager.UploadInput, partSize int64,
+	fileSize int64, uploadErr error, timedOut bool) error {
+	failure, ok := uploadErr.(s3manager.MultiUploadFailure)
+	if !ok || failure.UploadID() == "" {
+		return uploadErr // Nothing was uploaded in parts
+	}
+
+	key := aws.StringValue(uploadParams.Key)
+
+	failed := "failed"
+	if timedOut {
+		failed = "timed out"
+	}
+
+	// A resumable upload without a resume state file is found again by its key
+	if uploadObject.Resume && uploadObject.ResumeStateFile == "" {
+		log.Warn.Printf("Upload of key: '%s' %s. Its parts have been kept and the upload can be resumed by running it again\n", key, failed)
+		return uploadErr
+	}
+
+	if uploadObject.Resume || (timedOut && strings.ToLower(uploadObject.OnTimeout) == OnTimeoutPreserve) {
+		if err := writeResumeState(svc, uploadObject, key, failure.UploadID(), partSize, fileSize); err != nil {
+			log.Error.Printf("Failed to write resume state file '%s', aborting the multipart upload of key: '%s'. Reason: %v\n", uploadObject.ResumeStateFile, key, err)
+		} else {
+			log.Warn.Printf("Upload of key: '%s' %s. Its parts have been kept and the upload can be resumed from '%s'\n", key, failed, uploadObject.ResumeStateFile)
+			return uploadErr
+		}
+	}
+
+	_, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   uploadParams.Bucket,
+		Key:      uploadParams.Key,
+		UploadId: aws.String(failure.UploadID()),
+	})
+	if err != nil {
+		log.Error.Printf("Failed to abort the multipart upload '%s' of key: '%s', its parts must be removed manually. Reason: %v\n", failure.UploadID(), key, err)
+	} else {
+		log.Info.Printf("Aborted the multipart upload of key: '%s'\n", key)
+	}
+	return uploadErr
+}
+
+// Writes the state of the multipart upload to the resume state file with every part S3 reports as uploaded and the
+// md5sum of the bytes of the file at its offset
+func writeResumeState(svc *s3.S3, uploadObject UploadObject, key string, uploadID string, partSize int64, fileSize int64) error {
+	file, err := os.Open(uploadObject.PathToFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	state := ResumeState{
+		Bucket:     uploadObject.Bucket,
+		Key:        key,
+		UploadID:   uploadID,
+		PathToFile: uploadObject.PathToFile,
+		Bytes:      fileSize,
+		ModTime:    fileInfo.ModTime(),
+		PartSize:   partSize,
+		Parts:      []ResumePart{},
+		TimedOutAt: time.Now().UTC(),
+	}
+
+	err = svc.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(uploadObject.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			state.Parts = append(state.Parts, ResumePart{
+				PartNumber: aws.Int64Value(part.PartNumber),
+				ETag:       aws.StringValue(part.ETag),
+				Size:       aws.Int64Value(part.Size),
+				Offset:     (aws.Int64Value(part.PartNumber) - 1) * partSize,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, part := range state.Parts {
+		if state.Parts[i].MD5, err = partMD5(file, part.Offset, part.Size); err != nil {
+			return err
+		}
+	}
+
+	contents, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(uploadObject.ResumeStateFile, contents, 0644)
+}
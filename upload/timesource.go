@@ -0,0 +1,76 @@
+package upload
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// Time sources used to classify the rotation tier of a backup and to build its timestamped key
+const (
+	TimeSourceNow       = "now"       // The time the backup runs
+	TimeSourceFileMtime = "filemtime" // The modification time of the file being backed up, e.g. for archival imports
+)
+
+// Windows the timestamp of a key is rounded down to so that every backup within the window shares a key
+const (
+	DateRoundingDay  = "day"  // Backups taken on the same day share a key
+	DateRoundingHour = "hour" // Backups taken within the same hour share a key
+)
+
+// Returns the current time of the time source now. Replaced by tests to run an upload at a fixed time
+var timeNow = time.Now
+
+// GetKeyTime returns the time of the upload object according to its time source in the location of the upload object,
+// or the time fixed by WithKeyTime. The same time must be used to classify the rotation tier and to build the key so
+// that they are consistent, so a backup should fix the time with WithKeyTime before it classifies the tier
+func GetKeyTime(uploadObject UploadObject) (time.Time, error) {
+	if !uploadObject.keyTime.IsZero() {
+		return uploadObject.keyTime, nil
+	}
+
+	var keyTime time.Time
+	switch strings.ToLower(uploadObject.TimeSource) {
+	case "", TimeSourceNow:
+		keyTime = timeNow()
+	case TimeSourceFileMtime:
+		fileInfo, err := os.Stat(uploadObject.PathToFile)
+		if err != nil {
+			return time.Time{}, err
+		}
+		keyTime = fileInfo.ModTime()
+	default:
+		return time.Time{}, errors.New("time source must be either '" + TimeSourceNow + "' or '" + TimeSourceFileMtime + "'")
+	}
+
+	if uploadObject.Location != nil {
+		keyTime = keyTime.In(uploadObject.Location)
+	}
+	return keyTime, nil
+}
+
+// WithKeyTime returns the upload object with the time of its key fixed to the time returned by GetKeyTime along with
+// that time. The tier classified from the time, the timestamp of the key, the date of its tags and every attempt of a
+// retried upload then all use the same time. A time already fixed is kept
+func WithKeyTime(uploadObject UploadObject) (UploadObject, time.Time, error) {
+	keyTime, err := GetKeyTime(uploadObject)
+	if err != nil {
+		return uploadObject, time.Time{}, err
+	}
+	uploadObject.keyTime = keyTime
+	return uploadObject, keyTime, nil
+}
+
+// Rounds the time down to the start of the day or hour of the date rounding in the location of the time, so that a
+// backup which runs again within the window is uploaded to the same key and overwrites the earlier backup. The time is
+// unchanged if there is no date rounding
+func roundKeyTime(keyTime time.Time, dateRounding string) time.Time {
+	switch strings.ToLower(dateRounding) {
+	case DateRoundingDay:
+		return time.Date(keyTime.Year(), keyTime.Month(), keyTime.Day(), 0, 0, 0, 0, keyTime.Location())
+	case DateRoundingHour:
+		return time.Date(keyTime.Year(), keyTime.Month(), keyTime.Day(), keyTime.Hour(), 0, 0, 0, keyTime.Location())
+	}
+	return keyTime
+}
@@ -0,0 +1,76 @@
+package upload
+
+import (
+	"encoding/hex"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/compress"
+	"s3backup/encrypt"
+	"s3backup/s3client"
+	"s3backup/util"
+	"net/http"
+	"regexp"
+)
+
+// ChecksumMetadataKey is the user metadata key that the md5sum of the source file is recorded under
+const ChecksumMetadataKey = "S3backup-Md5"
+
+// Returns the most recent backup of the upload object and whether its recorded checksum matches the md5sum provided.
+// If manipulate is enabled then backups of the file in every rotation tier under the bucket dir are considered
+func checkSourceUnchanged(svc *s3.S3, uploadObject UploadObject, md5sum string) (string, bool, error) {
+	keys, err := s3client.GetKeysByPrefix(svc, uploadObject.Bucket, uploadObject.BucketDir)
+	if err != nil {
+		return "", false, err
+	}
+
+	// Matches keys created by UploadFile for this file, i.e. <bucketdir><prefix><s3filename>_<timestamp>[<extension>]
+	extension := ""
+	if compressor, err := compress.Get(uploadObject.Compression); err == nil {
+		extension = regexp.QuoteMeta(compressor.Extension())
+	}
+	if len(uploadObject.EncryptionKey) > 0 {
+		extension += regexp.QuoteMeta(encrypt.Extension)
+	}
+	re := regexp.MustCompile("^" + regexp.QuoteMeta(uploadObject.BucketDir) + "[^/]*" +
+		regexp.QuoteMeta(uploadObject.S3FileName) + `_\d{8}T\d{6}` + extension + "$")
+	if !uploadObject.Manipulate {
+		re = regexp.MustCompile("^" + regexp.QuoteMeta(uploadObject.BucketDir+uploadObject.S3FileName) + extension + "$")
+	}
+
+	for key := range keys {
+		if !re.MatchString(key) {
+			delete(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return "", false, nil
+	}
+
+	latestKey := s3client.SortKeysByTime(keys)[0].Key
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(uploadObject.Bucket),
+		Key:    aws.String(latestKey),
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	for metadataKey, value := range head.Metadata {
+		if http.CanonicalHeaderKey(metadataKey) == ChecksumMetadataKey {
+			return latestKey, aws.StringValue(value) == md5sum, nil
+		}
+	}
+
+	return latestKey, false, nil
+}
+
+// Returns the hex encoded md5sum of the file
+func computeHexMD5Sum(pathToFile string) (string, error) {
+	md5sum, err := util.ComputeMD5Sum(pathToFile)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(md5sum), nil
+}
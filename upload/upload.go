@@ -7,8 +7,14 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/compress"
+	"s3backup/encrypt"
 	"s3backup/log"
 	"s3backup/s3client"
+	"s3backup/util"
+	"s3backup/version"
+	"io"
+	"io/ioutil"
 	"math"
 	"os"
 	"regexp"
@@ -16,17 +22,50 @@ import (
 	"time"
 )
 
+// VersionMetadataKey is the user metadata key that the version of s3backup which uploaded the object is recorded under
+const VersionMetadataKey = "S3backup-Version"
+
 // UploadFile returns the name of the file that was uploaded to S3
 // If manipulate name is true then the file the prefix will be applied and timestamp appended to the S3 file name
 func UploadFile(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun bool) (string, error) {
+	result, err := UploadFileWithResult(svc, uploadObject, prefix, dryRun)
+	if err != nil {
+		return "", err
+	}
+	return result.Key, nil
+}
+
+// UploadFileWithResult uploads the file in the same way as UploadFile and returns the result of the upload.
+// The status of the result is skipped if the upload was skipped as the source matched the most recent backup
+func UploadFileWithResult(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun bool) (UploadResult, error) {
+	uploadObject = withRateLimiter(uploadObject)
+	result, err := uploadFileWithRetries(svc, uploadObject, prefix, dryRun)
+
+	if err != nil && uploadObject.AllowSSEFallback && isKMSError(err) {
+		log.Warn.Printf("Upload of '%s' failed as the KMS key is unavailable. FALLING BACK TO SSE-S3, the backup will not be "+
+			"encrypted with the KMS key: %v\n", uploadObject.PathToFile, err)
+		uploadObject.ServerSideEncryption = s3.ServerSideEncryptionAes256
+		uploadObject.KMSKeyID = ""
+		result, err = uploadFileWithRetries(svc, uploadObject, prefix, dryRun)
+	}
+
+	// Only uploads which were attempted are recorded, and only the final attempt if the upload fell back to SSE-S3
+	if result.Status != "" {
+		recordResult(uploadObject, result)
+	}
+	return result, err
+}
+
+// Uploads the file and returns the result of the upload without recording it
+func uploadFileWithResult(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun bool) (UploadResult, error) {
 
 	if svc == nil {
-		return "", errors.New("svc must not be nil")
+		return UploadResult{}, errors.New("svc must not be nil")
 	}
 
 	err := validationCheck(uploadObject)
 	if err != nil {
-		return "", err
+		return UploadResult{}, err
 	}
 
 	log.Info.Println(`
@@ -35,48 +74,223 @@ func UploadFile(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun boo
 	######################################
 	`)
 
-	// Context provides a timeout with AWS SDK calls 'WithContext'
-	ctx := context.Background()
-	var cancelFn func()
-	if uploadObject.Timeout > 0 {
-		ctx, cancelFn = context.WithTimeout(ctx, uploadObject.Timeout)
+	if isStdin(uploadObject) {
+		// Context provides a timeout with AWS SDK calls 'WithContext'
+		ctx, cancelFn := phaseContext(context.Background(), uploadObject.Timeout)
+		defer cancelFn()
+		return uploadStdin(ctx, svc, uploadObject, prefix, dryRun)
+	}
+
+	skip, err := SkipSymlink(uploadObject)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	if skip {
+		return UploadResult{Status: ResultStatusSkipped}, nil
 	}
-	defer cancelFn()
 
 	file, err := os.Open(uploadObject.PathToFile)
 	defer file.Close()
 
 	if err != nil {
-		return "", err
+		return UploadResult{}, err
 	}
 
 	fileInfo, _ := file.Stat()
 	fileSize := fileInfo.Size()
 
-	log.Info.Printf("Uploading '%s' (%d bytes) to s3 bucket '%s'\n", uploadObject.PathToFile, fileSize, uploadObject.Bucket)
+	if uploadObject.MaxFileBytes > 0 && fileSize > uploadObject.MaxFileBytes {
+		if !uploadObject.Force {
+			return UploadResult{}, fmt.Errorf("file '%s' is %d bytes which exceeds the maximum file size of %d bytes, "+
+				"use --force to upload it anyway", uploadObject.PathToFile, fileSize, uploadObject.MaxFileBytes)
+		}
+		log.Warn.Printf("File '%s' is %d bytes which exceeds the maximum file size of %d bytes. "+
+			"Uploading anyway as force has been enabled\n", uploadObject.PathToFile, fileSize, uploadObject.MaxFileBytes)
+	}
 
-	s3FileName := uploadObject.S3FileName
+	s3FileName, err := getS3FileName(uploadObject, prefix)
+	if err != nil {
+		return UploadResult{}, err
+	}
 
-	if uploadObject.Manipulate { // Mutate the file name to comply with GFS
-		s3FileName = fmt.Sprintf("%s%s%s_%s", uploadObject.BucketDir, prefix, uploadObject.S3FileName, time.Now().Format("20060102T150405"))
-	} else {
-		s3FileName = uploadObject.BucketDir + s3FileName
+	if len(uploadObject.Tags) > 0 {
+		keyTime, err := GetKeyTime(uploadObject)
+		if err != nil {
+			return UploadResult{}, err
+		}
+		uploadObject.Tags, err = renderTags(uploadObject.Tags, keyTime, prefix)
+		if err != nil {
+			return UploadResult{}, err
+		}
 	}
 
+	// The path of the file whose contents are uploaded, which differs from the source if it is compressed
+	pathToUpload := uploadObject.PathToFile
+
+	metadata := map[string]*string{VersionMetadataKey: aws.String(version.Version)}
+
+	if uploadObject.Compression != "" {
+		compressor, _ := compress.Get(uploadObject.Compression) // Validated by validationCheck
+		s3FileName += compressor.Extension()
+		metadata[compress.MetadataKey] = aws.String(compressor.Name())
+
+		if dryRun {
+			log.Info.Printf("Skipping %s compression of '%s' as dry run has been enabled\n", compressor.Name(), uploadObject.PathToFile)
+		} else {
+			pathToUpload, err = compressToTempFile(compressor, uploadObject.CompressionLevel, uploadObject.PathToFile)
+			if err != nil {
+				return UploadResult{}, err
+			}
+			defer os.Remove(pathToUpload)
+
+			file.Close()
+			file, err = os.Open(pathToUpload)
+			if err != nil {
+				return UploadResult{}, err
+			}
+			defer file.Close()
+
+			fileInfo, _ = file.Stat()
+			log.Info.Printf("Compressed '%s' with %s from %d bytes to %d bytes\n", uploadObject.PathToFile, compressor.Name(), fileSize, fileInfo.Size())
+			fileSize = fileInfo.Size()
+		}
+	}
+
+	if len(uploadObject.EncryptionKey) > 0 {
+		s3FileName += encrypt.Extension
+		metadata[encrypt.MetadataKey] = aws.String(encrypt.Algorithm)
+
+		if dryRun {
+			log.Info.Printf("Skipping encryption of '%s' as dry run has been enabled\n", uploadObject.PathToFile)
+		} else {
+			encryptedPath, err := encryptToTempFile(uploadObject.EncryptionKey, pathToUpload)
+			if err != nil {
+				return UploadResult{}, err
+			}
+			defer os.Remove(encryptedPath)
+			pathToUpload = encryptedPath
+
+			file.Close()
+			file, err = os.Open(pathToUpload)
+			if err != nil {
+				return UploadResult{}, err
+			}
+			defer file.Close()
+
+			fileInfo, _ = file.Stat()
+			log.Info.Printf("Encrypted '%s' with %s\n", uploadObject.PathToFile, encrypt.Algorithm)
+			fileSize = fileInfo.Size()
+		}
+	}
+
+	uploadObject, err = resolveStorageClassMismatch(svc, uploadObject, s3FileName)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	log.Info.Printf("Uploading '%s' (%d bytes) to s3 bucket '%s'\n", uploadObject.PathToFile, fileSize, uploadObject.Bucket)
+
 	uploadParams := &s3manager.UploadInput{
-		Bucket: aws.String(uploadObject.Bucket),
-		Key:    aws.String(s3FileName),
-		Body:   file,
+		Bucket:   aws.String(uploadObject.Bucket),
+		Key:      aws.String(s3FileName),
+		Body:     file,
+		Metadata: metadata,
 	}
 
-	partSize := int64(uploadObject.PartSize * 1024 * 1024)
+	applyObjectAttributes(uploadParams, uploadObject)
+
+	contentType, err := detectContentType(uploadObject, pathToUpload)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	if contentType != "" {
+		log.Info.Printf("Uploading key: '%s' with content type '%s'\n", s3FileName, contentType)
+		uploadParams.ContentType = aws.String(contentType)
+	}
+
+	// The source is hashed within the hash timeout, which does not count towards the timeout of the upload
+	hashCtx, cancelHash := phaseContext(context.Background(), uploadObject.HashTimeout)
+	defer cancelHash()
+
+	var md5sum string
+	if uploadObject.SkipIfUnchanged {
+		md5sum, err = computeHexMD5SumWithContext(hashCtx, uploadObject.PathToFile)
+		if err != nil {
+			return UploadResult{}, phaseError(hashCtx, PhaseHash, uploadObject.HashTimeout, err)
+		}
+
+		latestKey, unchanged, err := checkSourceUnchanged(svc, uploadObject, md5sum)
+		if err != nil {
+			return UploadResult{}, err
+		}
+
+		if unchanged {
+			log.Info.Printf("No changes, skipping upload of '%s' as it matches the most recent backup: '%s'\n", uploadObject.PathToFile, latestKey)
+			return UploadResult{Key: latestKey, Bytes: fileSize, Checksum: md5sum, Status: ResultStatusSkipped}, nil
+		}
+
+		// Record the checksum so that the next run can determine whether the source has changed
+		metadata[ChecksumMetadataKey] = aws.String(md5sum)
+	}
+
+	partSize := getPartSize(fileSize, int64(uploadObject.PartSize*1024*1024))
 
 	log.Info.Printf("Upload part size is: %d bytes\n", partSize)
 
+	if uploadObject.SkipIfExists && dryRun && uploadObject.Compression != "" {
+		log.Info.Printf("Skipping the check for an existing key: '%s' as the file is not compressed when dry run has been enabled\n", s3FileName)
+	} else if uploadObject.SkipIfExists {
+		exists, err := checkObjectExists(hashCtx, svc, uploadObject.Bucket, s3FileName, uploadObject.PathToFile, pathToUpload, fileSize, partSize)
+		if err != nil {
+			return UploadResult{}, fmt.Errorf("failed to check whether key '%s' already exists: %v", s3FileName, phaseError(hashCtx, PhaseHash, uploadObject.HashTimeout, err))
+		}
+		if exists {
+			log.Info.Printf("Skipping upload of '%s' as key: '%s' already exists with the same size and checksum\n", uploadObject.PathToFile, s3FileName)
+			return UploadResult{Key: s3FileName, Bytes: fileSize, Checksum: md5sum, Status: ResultStatusSkipped}, nil
+		}
+	}
+
+	// When verifying, the file is hashed as it is read by the uploader rather than being read again after the upload.
+	// The body can no longer seek so the uploader buffers each part in memory instead of reading it from the file
+	hasher := newInlineHasher(partSize)
+	if uploadObject.StrongVerify {
+		uploadParams.Body = io.TeeReader(file, hasher)
+	}
+	uploadParams.Body = withProgress(uploadParams.Body, uploadObject.ProgressFn, fileSize)
+
+	// Verifying with a checksum replaces verifying with the ETag
+	checksumAlgorithm := uploadObject.ChecksumAlgorithm
+	if !dryRun {
+		checksumAlgorithm = selectChecksumAlgorithm(svc, uploadObject)
+	}
+	checksums := newChecksumRecorder(checksumAlgorithm)
+	if checksumAlgorithm != "" {
+		uploadParams.ChecksumAlgorithm = aws.String(checksumAlgorithm)
+	}
+
+	// An interrupted upload of the file is resumed by uploading only its missing parts
+	var resumable *resumableUpload
+	if uploadObject.Resume && dryRun {
+		log.Info.Printf("Skipping the check for an interrupted upload of key: '%s' as dry run has been enabled\n", s3FileName)
+	} else if uploadObject.Resume && checksumAlgorithm != "" {
+		log.Warn.Printf("An interrupted upload of key: '%s' cannot be resumed as it is verified with a %s checksum, uploading the file from the start\n",
+			s3FileName, checksumAlgorithm)
+	} else if uploadObject.Resume {
+		resumable, err = findResumableUpload(svc, uploadObject, s3FileName, fileInfo, partSize)
+		if err != nil {
+			return UploadResult{}, err
+		}
+		if resumable != nil {
+			s3FileName = resumable.Key
+			uploadParams.Key = aws.String(s3FileName)
+			partSize = resumable.PartSize
+		}
+	}
+
 	finishedCh := make(chan bool)
 
 	go func() {
-		if fileSize < partSize { // Don't bother checking progress if file size is < 50MiB
+		if fileSize <= partSize { // Don't bother checking progress if the file is uploaded with a single PUT
 			<-finishedCh
 		} else {
 			totalParts := int64(math.Ceil(float64(fileSize) / float64(partSize))) // Round up
@@ -85,20 +299,98 @@ func UploadFile(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun boo
 		}
 	}()
 
-	log.Info.Printf("Uploading is about to begin with a maximum of %d workers\n", uploadObject.NumWorkers)
+	if uploadObject.UploadPartOrdered {
+		log.Info.Println("Uploading is about to begin with a single worker uploading the parts in order")
+	} else {
+		log.Info.Printf("Uploading is about to begin with a maximum of %d workers\n", uploadObject.NumWorkers)
+	}
+
+	recorder := newPartETagRecorder()
 
 	uploader := s3manager.NewUploaderWithClient(svc, func(u *s3manager.Uploader) {
-		u.PartSize = partSize                   // 50MiB part size. Limit of 10,000 parts. http://docs.aws.amazon.com/AmazonS3/latest/dev/mpuoverview.html
-		u.Concurrency = uploadObject.NumWorkers // The total number of workers to upload the file
-		u.LeavePartsOnError = false
+		u.PartSize = uploaderPartSize(fileSize, partSize) // 50MiB part size. Limit of 10,000 parts. http://docs.aws.amazon.com/AmazonS3/latest/dev/mpuoverview.html
+		u.Concurrency = partWorkers(uploadObject)         // The total number of workers to upload the file
+		u.LeavePartsOnError = true                        // The parts of a failed upload are aborted or preserved by handleFailedUpload
+		u.RequestOptions = append(u.RequestOptions, sortCompletedParts)
+		if uploadObject.BufferSize > 0 {
+			u.BufferProvider = s3manager.NewBufferedReadSeekerWriteToPool(uploadObject.BufferSize * 1024)
+		}
+		if uploadObject.StrongVerify {
+			u.RequestOptions = append(u.RequestOptions, recorder.requestOption)
+		}
+		if checksumAlgorithm != "" {
+			u.RequestOptions = append(u.RequestOptions, checksums.requestOption)
+		}
+		u.RequestOptions = append(u.RequestOptions, rateLimitOptions(uploadObject)...)
 	})
 
+	cancelHash()
+
+	// Context provides a timeout with AWS SDK calls 'WithContext'. The timeout starts once the source has been hashed
+	ctx, cancelFn := phaseContext(context.Background(), uploadObject.Timeout)
+	defer cancelFn()
+
 	startTime := time.Now()
 
 	if dryRun {
 		log.Info.Printf("Skipping upload of key: '%s' as dry run has been enabled\n", s3FileName)
 	} else {
-		_, err = uploader.UploadWithContext(ctx, uploadParams) // Upload file
+		var output *s3manager.UploadOutput
+		if resumable != nil {
+			output, err = resumeUpload(ctx, svc, uploadObject, uploadParams, resumable, file, fileSize)
+		} else {
+			output, err = uploader.UploadWithContext(ctx, uploadParams) // Upload file
+		}
+		if err != nil {
+			err = handleFailedUpload(svc, uploadObject, uploadParams, partSize, fileSize, err, ctx.Err() == context.DeadlineExceeded)
+		} else if uploadObject.Resume {
+			clearResumeState(uploadObject, s3FileName)
+		}
+		cancelFn()
+
+		// The uploaded object is verified within the verify timeout, which starts once the upload has completed
+		verifyCtx, cancelVerify := phaseContext(context.Background(), uploadObject.VerifyTimeout)
+		defer cancelVerify()
+
+		if err == nil && checksumAlgorithm != "" {
+			log.Info.Printf("Verifying the %s checksum of key: '%s'\n", checksumAlgorithm, s3FileName)
+			err = phaseError(verifyCtx, PhaseVerify, uploadObject.VerifyTimeout, verifyChecksum(verifyCtx, svc, uploadParams, checksums))
+			if err == nil {
+				log.Info.Printf("Checksum verification passed for key: '%s'\n", s3FileName)
+			}
+		} else if err == nil && uploadObject.StrongVerify {
+			log.Info.Printf("Verifying the ETag of each uploaded part of key: '%s'\n", s3FileName)
+			err = strongVerify(hasher, recorder, aws.StringValue(output.ETag))
+			if err == nil {
+				log.Info.Printf("Strong verification passed for key: '%s'\n", s3FileName)
+			}
+		} else if err == nil && uploadObject.VerifyChecksum {
+			log.Info.Printf("Verifying the ETag of key: '%s' against '%s'\n", s3FileName, pathToUpload)
+			var uploadedMD5 string
+			uploadedMD5, err = verifyUploadedETag(verifyCtx, pathToUpload, partSize, output.UploadID != "", aws.StringValue(output.ETag))
+			err = phaseError(verifyCtx, PhaseVerify, uploadObject.VerifyTimeout, err)
+			if err == nil {
+				log.Info.Printf("Checksum verification passed for key: '%s'\n", s3FileName)
+				if md5sum == "" && uploadObject.Compression == "" && len(uploadObject.EncryptionKey) == 0 {
+					md5sum = uploadedMD5 // Already computed so the results file does not need to read the file again
+				}
+			}
+		}
+
+		if err == nil && uploadObject.QuickVerify {
+			log.Info.Printf("Verifying the first and last bytes of key: '%s' against '%s'\n", s3FileName, pathToUpload)
+			err = quickVerify(verifyCtx, svc, aws.StringValue(uploadParams.Bucket), aws.StringValue(uploadParams.Key), pathToUpload)
+			err = phaseError(verifyCtx, PhaseVerify, uploadObject.VerifyTimeout, err)
+			if err == nil {
+				log.Info.Printf("Quick verification passed for key: '%s'\n", s3FileName)
+			}
+		}
+
+		// The object is only copied onto itself once it has been verified
+		if err == nil && output.UploadID != "" && uploadObject.FinalizeAttributes {
+			log.Info.Printf("Checking the attributes of multipart uploaded key: '%s'\n", s3FileName)
+			err = finalizeObjectAttributes(svc, uploadParams)
+		}
 	}
 	elapsedTime := time.Since(startTime).Seconds()
 
@@ -106,11 +398,23 @@ func UploadFile(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun boo
 
 	finishedCh <- true // Stop checking for upload
 
+	if md5sum == "" && uploadObject.StrongVerify && uploadObject.Compression == "" && len(uploadObject.EncryptionKey) == 0 && !dryRun {
+		md5sum = hasher.MD5() // Already computed inline so the results file does not need to read the file again
+	}
+
+	result := UploadResult{Key: s3FileName, Bytes: fileSize, Duration: elapsedTime, Checksum: md5sum, Status: ResultStatusSuccess}
 	if err != nil {
-		return "", err
+		result.Status = ResultStatusFailed
+		result.Error = err.Error()
+	} else if dryRun {
+		result.Status = ResultStatusDryRun
 	}
 
-	return s3FileName, nil
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
 }
 
 // This function attempts to track the progress of an S3 multipart upload
@@ -121,13 +425,12 @@ func checkUploadProgress(svc *s3.S3, s3FileName string, bucket string, partSize
 	log.Info.Println("Attempting to display progress of upload. This will give a very rough estimate of progress, " +
 		"especially if the upload is being handled by multiple workers. Only a maximum of 1000 parts will be displayed")
 	for { // Loop will only exit once channel has been updated
-		time.Sleep(time.Second * 30) // Sleep first to allow time for multi-part upload to start
 		select {
 		case <-uploadFinishedCh:
 			log.Info.Println("Stopping upload checks as upload has finished processing")
 			// Received a value from the channel which means that the upload has finished
 			return
-		default:
+		case <-time.After(time.Second * 30): // Wait first to allow time for multi-part upload to start
 			uploadId, err := s3client.GetMultiPartUploadIDByKey(svc, bucket, s3FileName)
 			if err != nil {
 				log.Warn.Printf("Failed to retrieve upload id: %v\n", err)
@@ -144,6 +447,73 @@ func checkUploadProgress(svc *s3.S3, s3FileName string, bucket string, partSize
 
 }
 
+// Returns the key of the upload object. If manipulate is enabled then the prefix is applied and the timestamp of the
+// time source appended
+func getS3FileName(uploadObject UploadObject, prefix string) (string, error) {
+	if !uploadObject.Manipulate {
+		return uploadObject.BucketDir + uploadObject.S3FileName, nil
+	}
+
+	keyTime, err := GetKeyTime(uploadObject)
+	if err != nil {
+		return "", err
+	}
+
+	layout := uploadObject.KeyTimeLayout
+	if layout == "" {
+		layout = util.DefaultKeyTimeLayout
+	}
+
+	// Mutate the file name to comply with GFS
+	keyTime = roundKeyTime(keyTime, uploadObject.DateRounding)
+	return fmt.Sprintf("%s%s%s_%s", uploadObject.BucketDir, prefix, uploadObject.S3FileName, keyTime.Format(layout)), nil
+}
+
+// Compresses the file into a temporary file and returns its path. The caller is responsible for removing it
+func compressToTempFile(compressor compress.Compressor, level int, pathToFile string) (string, error) {
+	tmpFile, err := ioutil.TempFile("", "s3backup-*"+compressor.Extension())
+	if err != nil {
+		return "", err
+	}
+	tmpFile.Close()
+
+	log.Info.Printf("Compressing '%s' with %s\n", pathToFile, compressor.Name())
+	if err = compress.CompressFile(compressor, level, pathToFile, tmpFile.Name()); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	return tmpFile.Name(), nil
+}
+
+// Encrypts the file into a temporary file and returns its path. The caller is responsible for removing it
+func encryptToTempFile(material []byte, pathToFile string) (string, error) {
+	tmpFile, err := ioutil.TempFile("", "s3backup-*"+encrypt.Extension)
+	if err != nil {
+		return "", err
+	}
+	tmpFile.Close()
+
+	log.Info.Printf("Encrypting '%s' with %s\n", pathToFile, encrypt.Algorithm)
+	if err = encrypt.EncryptFile(material, pathToFile, tmpFile.Name()); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	return tmpFile.Name(), nil
+}
+
+// Appends the result to the results file of the upload object if one has been specified
+func recordResult(uploadObject UploadObject, result UploadResult) {
+	if uploadObject.ResultsFile == "" {
+		return
+	}
+
+	if err := writeResult(uploadObject.ResultsFile, uploadObject.PathToFile, result); err != nil {
+		log.Warn.Printf("Failed to write upload result to '%s': %v\n", uploadObject.ResultsFile, err)
+	}
+}
+
 func validationCheck(uploadObject UploadObject) error {
 	if uploadObject.BucketDir != "" {
 		matched, _ := regexp.MatchString("^.*/$", uploadObject.BucketDir)
@@ -152,6 +522,12 @@ func validationCheck(uploadObject UploadObject) error {
 		}
 	}
 
+	if isStdin(uploadObject) {
+		if err := validateStdin(uploadObject); err != nil {
+			return err
+		}
+	}
+
 	if uploadObject.S3FileName == "" {
 		return errors.New("s3FileName should not be empty")
 	}
@@ -176,6 +552,131 @@ func validationCheck(uploadObject UploadObject) error {
 		return errors.New("timeout must not be less than 0")
 	}
 
+	if uploadObject.HashTimeout < 0 || uploadObject.VerifyTimeout < 0 {
+		return errors.New("hash timeout and verify timeout must not be less than 0")
+	}
+
+	if len(uploadObject.Tags) > maxTags {
+		return fmt.Errorf("too many tags specified, S3 allows at most %d tags on an object but got %d", maxTags, len(uploadObject.Tags))
+	}
+
+	if uploadObject.KeyTimeLayout != "" {
+		if _, err := util.NewKeyTimeParser(uploadObject.KeyTimeLayout, uploadObject.Location); err != nil {
+			return err
+		}
+	}
+
+	if rounding := strings.ToLower(uploadObject.DateRounding); rounding != "" && rounding != DateRoundingDay && rounding != DateRoundingHour {
+		return fmt.Errorf("date rounding must be either '%s' or '%s'", DateRoundingDay, DateRoundingHour)
+	}
+
+	if uploadObject.ContentType != "" && !validContentType(uploadObject.ContentType) {
+		return fmt.Errorf("invalid content type '%s', expected a media type e.g. text/csv", uploadObject.ContentType)
+	}
+
+	if uploadObject.ACL != "" && !validACL(uploadObject.ACL) {
+		return fmt.Errorf("invalid ACL '%s', expected one of: %s", uploadObject.ACL, strings.Join(s3.ObjectCannedACL_Values(), ", "))
+	}
+
+	if err := ValidateStorageClass(uploadObject.StorageClass); err != nil {
+		return err
+	}
+
+	if err := storageClassMismatchValidationCheck(uploadObject); err != nil {
+		return err
+	}
+
+	if uploadObject.WebsiteRedirectLocation != "" && !validWebsiteRedirectLocation(uploadObject.WebsiteRedirectLocation) {
+		return fmt.Errorf("invalid website redirect location '%s', expected a path beginning with '/' or a URL beginning with http:// or https://", uploadObject.WebsiteRedirectLocation)
+	}
+
+	switch uploadObject.ObjectLockLegalHoldStatus {
+	case "", s3.ObjectLockLegalHoldStatusOn, s3.ObjectLockLegalHoldStatusOff:
+	default:
+		return errors.New("legal hold status must be either ON or OFF")
+	}
+
+	switch uploadObject.ServerSideEncryption {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf("invalid server side encryption '%s', expected one of: %s, %s", uploadObject.ServerSideEncryption, s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms)
+	}
+
+	if uploadObject.KMSKeyID != "" && uploadObject.ServerSideEncryption != s3.ServerSideEncryptionAwsKms {
+		return fmt.Errorf("a KMS key ID requires server side encryption %s", s3.ServerSideEncryptionAwsKms)
+	}
+
+	switch uploadObject.ChecksumAlgorithm {
+	case "", ChecksumAlgorithmCRC32C, ChecksumAlgorithmSHA256:
+	default:
+		return fmt.Errorf("invalid checksum algorithm '%s', expected one of: %s, %s", uploadObject.ChecksumAlgorithm, ChecksumAlgorithmCRC32C, ChecksumAlgorithmSHA256)
+	}
+
+	if uploadObject.Compression != "" {
+		compressor, err := compress.Get(uploadObject.Compression)
+		if err != nil {
+			return err
+		}
+		if !compressor.ValidLevel(uploadObject.CompressionLevel) {
+			return fmt.Errorf("invalid %s compression level: %d", compressor.Name(), uploadObject.CompressionLevel)
+		}
+	} else if uploadObject.CompressionLevel != 0 {
+		return errors.New("compression level must not be specified without a compression algorithm")
+	}
+
+	switch strings.ToLower(uploadObject.TimeSource) {
+	case "", TimeSourceNow, TimeSourceFileMtime:
+	default:
+		return errors.New("time source must be either '" + TimeSourceNow + "' or '" + TimeSourceFileMtime + "'")
+	}
+
+	if uploadObject.Ledger != "" {
+		return errors.New("a ledger is only supported when uploading the files of a directory individually")
+	}
+
+	if uploadObject.DirManifest {
+		return errors.New("a manifest is only supported when uploading the files of a directory individually")
+	}
+
+	if uploadObject.DeadLetter != "" || uploadObject.RetryDeadLetter {
+		return errors.New("a dead letter is only supported when uploading the files of a directory individually")
+	}
+
+	switch strings.ToLower(uploadObject.OnTimeout) {
+	case "", OnTimeoutAbort:
+	case OnTimeoutPreserve:
+		if uploadObject.ResumeStateFile == "" {
+			return errors.New("a resume state file must be specified to preserve the parts of an upload which times out")
+		}
+	default:
+		return errors.New("on timeout must be either '" + OnTimeoutAbort + "' or '" + OnTimeoutPreserve + "'")
+	}
+
+	if len(uploadObject.EncryptionKey) > 0 {
+		if err := encrypt.ValidateMaterial(uploadObject.EncryptionKey); err != nil {
+			return err
+		}
+		if uploadObject.SkipIfExists || uploadObject.Resume {
+			return errors.New("skip if exists and resume are not supported with encryption as every encryption of the source differs")
+		}
+	}
+
+	if uploadObject.Resume && (uploadObject.Compression != "" || uploadObject.StrongVerify || uploadObject.ChecksumAlgorithm != "") {
+		return errors.New("resume is not supported with compression, strong verify or a checksum algorithm as the parts which were already uploaded are not read again")
+	}
+
+	if uploadObject.MaxFileBytes < 0 {
+		return errors.New("max file bytes must not be less than 0")
+	}
+
+	if uploadObject.MaxBytesPerSec < 0 {
+		return errors.New("max bytes per sec must not be less than 0")
+	}
+
+	if uploadObject.MaxRetries < 0 {
+		return errors.New("max retries must not be less than 0")
+	}
+
 	if (uploadObject.PartSize * 1024 * 1024) < (1024 * 1024 * 5) { // 5MiB
 		return errors.New("upload object size must be greater than 5MiB")
 	}
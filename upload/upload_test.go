@@ -1,16 +1,33 @@
 package upload
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"s3backup/compress"
+	"s3backup/encrypt"
 	"s3backup/log"
 	"s3backup/rpolicy"
 	"s3backup/s3client"
+	"s3backup/s3mock"
 	"s3backup/util"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -40,6 +57,10 @@ var bigTestUploadObject UploadObject
 
 var awsForbiddenBucket string
 
+var mockBucket string
+var multipartFileSize int64
+var pathToMultipartFile string
+
 // Setup testing
 func init() {
 	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
@@ -47,10 +68,12 @@ func init() {
 	awsCredentials := os.Getenv("AWS_CRED_FILE")
 	awsProfile := os.Getenv("AWS_PROFILE")
 	awsRegion := os.Getenv("AWS_REGION")
+	awsEndpoint := os.Getenv("AWS_ENDPOINT")
+	awsPartition := os.Getenv("AWS_PARTITION")
 	awsBucket := os.Getenv("AWS_BUCKET_UPLOAD")
 	awsForbiddenBucket = os.Getenv("AWS_BUCKET_FORBIDDEN")
 
-	s3svc, err := s3client.CreateS3Client(awsCredentials, awsProfile, awsRegion)
+	s3svc, err := s3client.CreateS3Client(awsCredentials, awsProfile, false, "", "", awsRegion, awsEndpoint, awsPartition, nil, false)
 	if err != nil {
 		log.Error.Println(err)
 		os.Exit(1)
@@ -119,6 +142,15 @@ func init() {
 		log.Error.Println("failed to create file required for testing")
 	}
 
+	mockBucket = "mockbucket"
+	multipartFileSize = int64(12 * 1024 * 1024) // 12MiB is 3 parts with a part size of 5MiB
+	pathToMultipartFile = "../multipartTestFile"
+
+	err = util.CreateFile(pathToMultipartFile, []byte(strings.Repeat("0123456789abcdef", int(multipartFileSize/16))))
+	if err != nil {
+		log.Error.Println("failed to create file required for testing")
+	}
+
 	// Not critical to run this up but can get costly if no lifecycle policy in place to clean up dead multiparts
 	util.CleanUpMultiPartUploads(svc, bucket)
 
@@ -583,3 +615,5142 @@ func TestUploadNegativeTimeout(t *testing.T) {
 		t.Error("expected error when timeout less than 0")
 	}
 }
+
+// Test 10 - Negative Upload Testing
+//	Upload a file with an unsupported compression algorithm and an invalid compression level
+func TestUploadInvalidCompression(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadBadObject := UploadObject{
+		PathToFile:  pathToTestFile,
+		S3FileName:  s3FileName,
+		Bucket:      mockBucket,
+		Timeout:     timeout,
+		NumWorkers:  5,
+		PartSize:    50,
+		Compression: "lz4",
+	}
+
+	expectedErrString := "unsupported compression algorithm 'lz4'"
+	_, err := UploadFile(mockS3.Client(), testUploadBadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	testUploadBadObject.Compression = "gzip"
+	testUploadBadObject.CompressionLevel = 10
+
+	expectedErrString = "invalid gzip compression level: 10"
+	_, err = UploadFile(mockS3.Client(), testUploadBadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if len(mockS3.Keys(mockBucket)) != 0 {
+		t.Error("expected nothing to be uploaded with an invalid compression")
+	}
+}
+
+//----------------------------------------------
+// Results File Testing (mock S3)
+//	1: Each uploaded file yields a NDJSON line with the expected fields
+//	2: A dry run upload yields a NDJSON line with the dry run status
+//
+//----------------------------------------------
+
+// Test 1 - Results File Testing
+//	Each uploaded file yields a NDJSON line with the expected fields
+func TestUploadResultsFile(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	resultsFile := "../uploadResults.ndjson"
+	os.Remove(resultsFile)
+	defer os.Remove(resultsFile)
+
+	uploadedKeys := []string{}
+	for i := 0; i < 2; i++ {
+		testUploadObject := testUploadObjectNotManipulated
+		testUploadObject.Bucket = mockBucket
+		testUploadObject.S3FileName = s3FileName + strconv.Itoa(i)
+		testUploadObject.ResultsFile = resultsFile
+
+		key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+		if err != nil {
+			t.Error(fmt.Sprintf("expected to upload file without any error: %v", err))
+		}
+		uploadedKeys = append(uploadedKeys, key)
+	}
+
+	results := readResultsFile(t, resultsFile)
+	if len(results) != len(uploadedKeys) {
+		t.Fatal(fmt.Sprintf("expected %d results, got %d", len(uploadedKeys), len(results)))
+	}
+
+	expectedMD5, err := util.ComputeMD5Sum(pathToTestFile)
+	if err != nil {
+		t.Error("expected to be able to generate md5sum on existing file")
+	}
+
+	fileInfo, _ := os.Stat(pathToTestFile)
+
+	for i, result := range results {
+		if result.Key != uploadedKeys[i] || mockS3.Object(mockBucket, result.Key) == nil {
+			t.Error(fmt.Sprintf("expected result key '%s' to be the uploaded key '%s'", result.Key, uploadedKeys[i]))
+		}
+		if result.Bytes != fileInfo.Size() {
+			t.Error(fmt.Sprintf("expected result bytes to be %d, got %d", fileInfo.Size(), result.Bytes))
+		}
+		if result.Checksum != hex.EncodeToString(expectedMD5) {
+			t.Error("expected result checksum to be the md5sum of the uploaded file: " + result.Checksum)
+		}
+		if result.Status != ResultStatusSuccess {
+			t.Error("expected result status to be success: " + result.Status)
+		}
+		if result.Duration < 0 {
+			t.Error("expected result duration not to be negative")
+		}
+	}
+}
+
+// Test 2 - Results File Testing
+//	A dry run upload yields a NDJSON line with the dry run status
+func TestUploadResultsFileDryRun(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	resultsFile := "../uploadResultsDryRun.ndjson"
+	os.Remove(resultsFile)
+	defer os.Remove(resultsFile)
+
+	testUploadObject := testUploadObjectManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.ResultsFile = resultsFile
+
+	prefix := util.GetKeyType(policy, time.Now())
+	key, err := UploadFile(mockS3.Client(), testUploadObject, prefix, true)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected dry run upload without any error: %v", err))
+	}
+
+	results := readResultsFile(t, resultsFile)
+	if len(results) != 1 {
+		t.Fatal(fmt.Sprintf("expected 1 result, got %d", len(results)))
+	}
+
+	if results[0].Key != key || results[0].Status != ResultStatusDryRun {
+		t.Error(fmt.Sprintf("expected dry run result for key '%s', got: %+v", key, results[0]))
+	}
+
+	if len(mockS3.Keys(mockBucket)) != 0 {
+		t.Error("expected nothing to be uploaded by a dry run")
+	}
+}
+
+// Reads every NDJSON line in the results file, failing the test if any line is not a valid result
+func readResultsFile(t *testing.T, resultsFile string) []UploadResult {
+	contents, err := ioutil.ReadFile(resultsFile)
+	if err != nil {
+		t.Fatal("failed to read results file: " + err.Error())
+	}
+
+	results := []UploadResult{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n") {
+		var result UploadResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatal("expected results file line to be valid JSON: " + line)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+//----------------------------------------------
+// Skip If Unchanged Testing (mock S3)
+//	1: Upload is skipped when the file matches the most recent backup
+//	2: Upload proceeds when the file differs from the most recent backup
+//
+//----------------------------------------------
+
+// Test 1 - Skip If Unchanged Testing
+//	Upload is skipped when the file matches the most recent backup
+func TestUploadSkipIfUnchanged(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := testUploadObjectManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.SkipIfUnchanged = true
+
+	firstKey, err := UploadFile(mockS3.Client(), testUploadObject, policy.DailyPrefix, false)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+
+	time.Sleep(time.Second) // Ensure a new upload would produce a distinct key
+
+	// A different tier should still find the previous backup
+	secondKey, err := UploadFile(mockS3.Client(), testUploadObject, policy.WeeklyPrefix, false)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected to skip upload without any error: %v", err))
+	}
+
+	if secondKey != firstKey {
+		t.Error(fmt.Sprintf("expected skipped upload to return the existing key '%s', got '%s'", firstKey, secondKey))
+	}
+
+	if keys := mockS3.Keys(mockBucket); len(keys) != 1 {
+		t.Error(fmt.Sprintf("expected bucket size to be 1, got: %v", keys))
+	}
+}
+
+// Test 2 - Skip If Unchanged Testing
+//	Upload proceeds when the file differs from the most recent backup
+func TestUploadSkipIfUnchangedFileChanged(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	pathToChangingFile := "../changingTestFile"
+	defer os.Remove(pathToChangingFile)
+
+	err := util.CreateFile(pathToChangingFile, []byte("first version of the file"))
+	if err != nil {
+		t.Fatal("failed to create file required for testing")
+	}
+
+	testUploadObject := testUploadObjectManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.PathToFile = pathToChangingFile
+	testUploadObject.SkipIfUnchanged = true
+
+	firstKey, err := UploadFile(mockS3.Client(), testUploadObject, policy.DailyPrefix, false)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+
+	time.Sleep(time.Second) // Ensure the new upload produces a distinct key
+
+	err = util.CreateFile(pathToChangingFile, []byte("second version of the file"))
+	if err != nil {
+		t.Fatal("failed to create file required for testing")
+	}
+
+	secondKey, err := UploadFile(mockS3.Client(), testUploadObject, policy.DailyPrefix, false)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected to upload changed file without any error: %v", err))
+	}
+
+	if secondKey == firstKey {
+		t.Error("expected changed file to be uploaded to a new key")
+	}
+
+	if keys := mockS3.Keys(mockBucket); len(keys) != 2 {
+		t.Error(fmt.Sprintf("expected bucket size to be 2, got: %v", keys))
+	}
+}
+
+//----------------------------------------------
+// Max File Size Testing
+//	1: Upload fails before uploading when the file exceeds the max file size
+//	2: Upload passes the guard when the file is under the max file size
+//	3: Upload passes the guard when the file exceeds the max file size and force is enabled
+//
+//----------------------------------------------
+
+// Test 1 - Max File Size Testing
+//	Upload fails before uploading when the file exceeds the max file size
+func TestUploadExceedsMaxFileSize(t *testing.T) {
+	expectedErrString := "exceeds the maximum file size"
+
+	testUploadObject := bigTestUploadObject
+	testUploadObject.MaxFileBytes = bigFileSize - 1
+
+	_, err := UploadFile(svc, testUploadObject, policy.DailyPrefix, false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error("expected error when file exceeds the max file size")
+	}
+}
+
+// Test 2 - Max File Size Testing
+//	Upload passes the guard when the file is under the max file size
+func TestUploadUnderMaxFileSize(t *testing.T) {
+	maxFileBytes, err := util.ParseByteSize("1KB")
+	if err != nil {
+		t.Fatal("expected to parse human-readable size: " + err.Error())
+	}
+
+	testUploadObject := testUploadObjectManipulated
+	testUploadObject.MaxFileBytes = maxFileBytes
+
+	_, err = UploadFile(svc, testUploadObject, policy.DailyPrefix, true)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected file under the max file size to pass the guard: %v", err))
+	}
+}
+
+// Test 3 - Max File Size Testing
+//	Upload passes the guard when the file exceeds the max file size and force is enabled
+func TestUploadExceedsMaxFileSizeForced(t *testing.T) {
+	testUploadObject := testUploadObjectManipulated
+	testUploadObject.MaxFileBytes = 1
+	testUploadObject.Force = true
+
+	_, err := UploadFile(svc, testUploadObject, policy.DailyPrefix, true)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected forced upload to pass the guard: %v", err))
+	}
+}
+
+//----------------------------------------------
+// Strong Verify Testing (mock S3)
+//	1: A multipart upload passes when every part ETag matches
+//	2: A multipart upload fails when a part ETag does not match
+//	3: A single part upload passes when the object ETag matches
+//
+//----------------------------------------------
+
+// Test 1 - Strong Verify Testing
+//	A multipart upload passes when every part ETag matches
+func TestStrongVerifyMultipart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	_, err := UploadFile(mockS3.Client(), multipartUploadObject(true), "", false)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected strong verification to pass: %v", err))
+	}
+
+	if len(mockS3.Requests("UploadPart")) != 3 {
+		t.Error(fmt.Sprintf("expected 3 parts to be uploaded, got %d", len(mockS3.Requests("UploadPart"))))
+	}
+}
+
+// Test 2 - Strong Verify Testing
+//	A multipart upload fails when a part ETag does not match
+func TestStrongVerifyMismatchedPart(t *testing.T) {
+	expectedErrString := "part(s) [2] do not match"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	// Corrupt the second part as it is received so that its ETag no longer matches the local file
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "UploadPart" && req.Query.Get("partNumber") == "2" {
+			req.Body[0] ^= 0xff
+		}
+		return nil
+	})
+
+	_, err := UploadFile(mockS3.Client(), multipartUploadObject(true), "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected strong verification to detect the mismatched part: %v", err))
+	}
+}
+
+// Test 3 - Strong Verify Testing
+//	A single part upload passes when the object ETag matches
+func TestStrongVerifySinglePart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.StrongVerify = true
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected strong verification to pass: %v", err))
+	}
+}
+
+// Returns an upload object for the multipart test file using the minimum part size of 5MiB
+func multipartUploadObject(strongVerify bool) UploadObject {
+	return UploadObject{
+		PathToFile:   pathToMultipartFile,
+		S3FileName:   "multipartTestFile",
+		Bucket:       mockBucket,
+		Timeout:      timeout,
+		NumWorkers:   3,
+		PartSize:     5,
+		StrongVerify: strongVerify,
+	}
+}
+
+//----------------------------------------------
+// Verify Checksum Testing (mock S3)
+//	1: A single part upload passes when the object ETag matches the md5sum of the file
+//	2: A single part upload fails when the object ETag does not match
+//	3: A multipart upload passes when the object ETag matches the composite ETag of the file
+//	4: A multipart upload fails when a part is corrupted
+//
+//----------------------------------------------
+
+// Test 1 - Verify Checksum Testing
+//	A single part upload passes when the object ETag matches the md5sum of the file
+func TestVerifyChecksumSinglePart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.VerifyChecksum = true
+
+	result, err := UploadFileWithResult(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected checksum verification to pass: %v", err))
+	}
+
+	md5sum, _ := computeHexMD5Sum(testUploadObject.PathToFile)
+	if result.Checksum != md5sum {
+		t.Error(fmt.Sprintf("expected the verified md5sum '%s' to be recorded in the result but got: '%s'", md5sum, result.Checksum))
+	}
+}
+
+// Test 2 - Verify Checksum Testing
+//	A single part upload fails when the object ETag does not match
+func TestVerifyChecksumSinglePartMismatch(t *testing.T) {
+	expectedErrString := "checksum verification failed: object ETag"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	// Corrupt the object as it is received so that its ETag no longer matches the local file
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "PutObject" {
+			req.Body[0] ^= 0xff
+		}
+		return nil
+	})
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.VerifyChecksum = true
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 3 - Verify Checksum Testing
+//	A multipart upload passes when the object ETag matches the composite ETag of the file
+func TestVerifyChecksumMultipart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.VerifyChecksum = true
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected checksum verification to pass: %v", err))
+	}
+
+	if len(mockS3.Requests("UploadPart")) != 3 {
+		t.Error(fmt.Sprintf("expected 3 parts to be uploaded, got %d", len(mockS3.Requests("UploadPart"))))
+	}
+}
+
+// Test 4 - Verify Checksum Testing
+//	A multipart upload fails when a part is corrupted
+func TestVerifyChecksumMultipartMismatch(t *testing.T) {
+	expectedErrString := "does not match the composite ETag"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "UploadPart" && req.Query.Get("partNumber") == "3" {
+			req.Body[0] ^= 0xff
+		}
+		return nil
+	})
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.VerifyChecksum = true
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+// Quick Verify Testing (mock S3)
+//	1: A multipart upload passes when the first and last bytes of the object match the file
+//	2: Quick verification fails when the object is truncated
+//	3: Quick verification fails when the start of the object is corrupted
+//
+//----------------------------------------------
+
+// Test 1 - Quick Verify Testing
+//	A multipart upload passes with a ranged GET of the first and of the last 4KiB of the object
+func TestQuickVerifyMultipart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.QuickVerify = true
+
+	if _, err := UploadFile(mockS3.Client(), testUploadObject, "", false); err != nil {
+		t.Fatal(fmt.Sprintf("expected quick verification to pass: %v", err))
+	}
+
+	ranges := []string{}
+	for _, req := range mockS3.Requests("GetObject") {
+		ranges = append(ranges, req.Header.Get("Range"))
+	}
+	expected := fmt.Sprintf("[bytes=0-4095 bytes=%d-%d]", multipartFileSize-4096, multipartFileSize-1)
+	if fmt.Sprint(ranges) != expected {
+		t.Error(fmt.Sprintf("expected the ranges %s to be fetched but got %v", expected, ranges))
+	}
+}
+
+// Test 2 - Quick Verify Testing
+//	The mock truncates the object to half its size before it is fetched
+func TestQuickVerifyTruncated(t *testing.T) {
+	expectedErrString := fmt.Sprintf("quick verification failed as key: 'multipartTestFile' is %d bytes", multipartFileSize/2)
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if obj := mockS3.Object(mockBucket, req.Key); req.Operation == "GetObject" && obj != nil && int64(len(obj.Body)) == multipartFileSize {
+			obj.Body = obj.Body[:multipartFileSize/2]
+		}
+		return nil
+	})
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.QuickVerify = true
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 3 - Quick Verify Testing
+//	A single part upload whose first byte is corrupted as it is received
+func TestQuickVerifyCorrupted(t *testing.T) {
+	expectedErrString := "quick verification failed as bytes 0-"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "PutObject" {
+			req.Body[0] ^= 0xff
+		}
+		return nil
+	})
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.QuickVerify = true
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+// Compressed Upload Testing (mock S3)
+//	1: A file which is still larger than a part once compressed is uploaded in parts and decompresses to the file
+//	2: A dry run does not compress the file but reports the compressed key
+//
+//----------------------------------------------
+
+// Test 1 - Compressed Upload Testing
+//	Random bytes do not compress so the 12MiB file is still uploaded in 3 parts of 5MiB
+func TestCompressedUploadMultipart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	contents := make([]byte, multipartFileSize)
+	rand.New(rand.NewSource(1255)).Read(contents)
+	pathToFile := filepath.Join(t.TempDir(), "incompressible.bin")
+	if err := ioutil.WriteFile(pathToFile, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.PathToFile = pathToFile
+	testUploadObject.Compression = "gzip"
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the compressed file to be uploaded without any error: %v", err))
+	}
+
+	if key != "multipartTestFile.gz" {
+		t.Error(fmt.Sprintf("expected the extension of the algorithm to be appended to the key but got: %s", key))
+	}
+	if len(mockS3.Requests("UploadPart")) != 3 {
+		t.Error(fmt.Sprintf("expected the compressed file to be uploaded in 3 parts, got %d", len(mockS3.Requests("UploadPart"))))
+	}
+
+	obj := mockS3.Object(mockBucket, key)
+	if obj == nil {
+		t.Fatal("expected the compressed file to be uploaded: " + key)
+	}
+	if obj.Header.Get("X-Amz-Meta-"+compress.MetadataKey) != "gzip" {
+		t.Error(fmt.Sprintf("expected the algorithm to be recorded in the metadata but got: '%s'", obj.Header.Get("X-Amz-Meta-"+compress.MetadataKey)))
+	}
+
+	compressor, _ := compress.Get("gzip")
+	reader, err := compressor.NewReader(bytes.NewReader(obj.Body))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the uploaded object to be gzip compressed: %v", err))
+	}
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil || !bytes.Equal(decompressed, contents) {
+		t.Error(fmt.Sprintf("expected the uploaded object to decompress to the file: %v", err))
+	}
+}
+
+// Test 2 - Compressed Upload Testing
+//	A dry run does not compress the file but reports the compressed key
+func TestCompressedUploadDryRun(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.Compression = "zstd"
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", true)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the dry run to pass without any error: %v", err))
+	}
+
+	if key != "multipartTestFile.zst" {
+		t.Error(fmt.Sprintf("expected the extension of the algorithm to be appended to the key but got: %s", key))
+	}
+	if len(mockS3.Requests("PutObject")) != 0 || len(mockS3.Requests("CreateMultipartUpload")) != 0 {
+		t.Error("expected nothing to be uploaded during a dry run")
+	}
+}
+
+//----------------------------------------------
+// Part Ordering Testing (mock S3)
+//	1: Parts which finish uploading out of order are completed in order of their part number
+//	2: Completed parts are sorted by part number before CompleteMultipartUpload is sent
+//	3: Parts are uploaded one at a time in order of their part number when ordered
+//
+//----------------------------------------------
+
+// Test 1 - Part Ordering Testing
+//	Delay the first part so that it finishes uploading after the other parts
+func TestPartOrderingCompletedInOrder(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	finished := recordFinishedParts(mockS3, "1", time.Millisecond*300)
+
+	_, err := UploadFile(mockS3.Client(), multipartUploadObject(false), "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the parts to be completed without any error: %v", err))
+	}
+
+	if fmt.Sprint(*finished) == "[1 2 3]" {
+		t.Fatal("expected the delayed first part to finish uploading after the other parts")
+	}
+	if parts := completedPartNumbers(t, mockS3); fmt.Sprint(parts) != "[1 2 3]" {
+		t.Error(fmt.Sprintf("expected the parts to be completed in order of their part number but got: %v", parts))
+	}
+}
+
+// Test 2 - Part Ordering Testing
+//	Complete the parts of a multipart upload listed in reverse order
+func TestPartOrderingSortsCompletedParts(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	client := mockS3.Client()
+
+	created, err := client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String(mockBucket), Key: aws.String("reversed")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := []*s3.CompletedPart{}
+	for partNumber := int64(1); partNumber <= 3; partNumber++ {
+		output, err := client.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String(mockBucket),
+			Key:        aws.String("reversed"),
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int64(partNumber),
+			Body:       bytes.NewReader([]byte(fmt.Sprintf("part %d", partNumber))),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append([]*s3.CompletedPart{{ETag: output.ETag, PartNumber: aws.Int64(partNumber)}}, parts...)
+	}
+
+	req, _ := client.CompleteMultipartUploadRequest(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(mockBucket),
+		Key:             aws.String("reversed"),
+		UploadId:        created.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	req.ApplyOptions(sortCompletedParts)
+	if err = req.Send(); err != nil {
+		t.Fatal(fmt.Sprintf("expected the sorted parts to be completed without any error: %v", err))
+	}
+
+	if obj := mockS3.Object(mockBucket, "reversed"); obj == nil || string(obj.Body) != "part 1part 2part 3" {
+		t.Error("expected the parts to be assembled in order of their part number")
+	}
+}
+
+// Test 3 - Part Ordering Testing
+//	Track the parts being uploaded at once while each part is delayed
+func TestPartOrderingUploadPartOrdered(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation != "UploadPart" {
+			return nil
+		}
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond * 50)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return nil
+	})
+	finished := recordFinishedParts(mockS3, "", 0)
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.UploadPartOrdered = true
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the parts to be uploaded in order without any error: %v", err))
+	}
+
+	if maxInFlight != 1 {
+		t.Error(fmt.Sprintf("expected a single part to be uploaded at once but got: %d", maxInFlight))
+	}
+	if fmt.Sprint(*finished) != "[1 2 3]" {
+		t.Error(fmt.Sprintf("expected the parts to be uploaded in order of their part number but got: %v", *finished))
+	}
+}
+
+// Records the part number of each part as it finishes uploading. The part with the delayed part number is delayed
+func recordFinishedParts(mockS3 *s3mock.Server, delayedPartNumber string, delay time.Duration) *[]string {
+	var mu sync.Mutex
+	finished := &[]string{}
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation != "UploadPart" {
+			return nil
+		}
+		if req.Query.Get("partNumber") == delayedPartNumber {
+			time.Sleep(delay)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		*finished = append(*finished, req.Query.Get("partNumber"))
+		return nil
+	})
+	return finished
+}
+
+// Returns the part numbers in the order they were sent to CompleteMultipartUpload
+func completedPartNumbers(t *testing.T, mockS3 *s3mock.Server) []int64 {
+	requests := mockS3.Requests("CompleteMultipartUpload")
+	if len(requests) != 1 {
+		t.Fatal(fmt.Sprintf("expected the upload to be completed once but got: %d", len(requests)))
+	}
+
+	var body struct {
+		Parts []struct {
+			PartNumber int64
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(requests[0].Body, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	partNumbers := []int64{}
+	for _, part := range body.Parts {
+		partNumbers = append(partNumbers, part.PartNumber)
+	}
+	return partNumbers
+}
+
+//----------------------------------------------
+// Progress Testing (mock S3)
+//	1: Progress of a multipart upload increases across the parts to the size of the file without buffering it
+//	2: Progress of a strongly verified upload which is buffered also reaches the size of the file
+//	3: The progress logger logs at most once per interval and once the whole file has been read
+//
+//----------------------------------------------
+
+// Test 1 - Progress Testing
+//	Record every call of the progress function while 3 workers upload the 3 parts
+func TestProgressMultipart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := multipartUploadObject(false)
+	calls := recordProgress(&testUploadObject)
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the upload to pass: %v", err))
+	}
+
+	checkProgress(t, *calls)
+	if len(mockS3.Requests("UploadPart")) != 3 {
+		t.Error(fmt.Sprintf("expected 3 parts to be uploaded, got %d", len(mockS3.Requests("UploadPart"))))
+	}
+
+	// Every part is read to sign the request and again to send it so the progress only counts the first read, which is
+	// checked by the progress increasing to exactly the size of the file
+	wrapped := withProgress(mustOpen(t, pathToMultipartFile), func(int64, int64) {}, multipartFileSize)
+	if _, ok := wrapped.(io.ReaderAt); !ok {
+		t.Error("expected the file to still be read at its offset by the uploader rather than buffered")
+	}
+}
+
+// Test 2 - Progress Testing
+//	The file is read through the inline hasher so the uploader reads it sequentially
+func TestProgressStrongVerify(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := multipartUploadObject(true)
+	calls := recordProgress(&testUploadObject)
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the upload to pass: %v", err))
+	}
+
+	checkProgress(t, *calls)
+}
+
+// Test 3 - Progress Testing
+//	Call the progress logger faster than its interval
+func TestProgressLogger(t *testing.T) {
+	var info bytes.Buffer
+	log.Init(&info, ioutil.Discard, ioutil.Discard)
+	defer log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	progressFn := NewProgressLogger(time.Hour)
+	for transferred := int64(0); transferred <= 100; transferred += 25 {
+		progressFn(transferred, 100)
+	}
+	progressFn(100, 100)
+
+	if lines := strings.Count(info.String(), "Upload progress:"); lines != 1 || !strings.Contains(info.String(), "100.0% (100/100 bytes)") {
+		t.Error(fmt.Sprintf("expected the progress to be logged only once the whole file had been read: %s", info.String()))
+	}
+
+	// The logger is shared by every file of a directory upload
+	info.Reset()
+	progressFn(50, 50)
+	if !strings.Contains(info.String(), "100.0% (50/50 bytes)") {
+		t.Error(fmt.Sprintf("expected the progress of the next file to be logged: %s", info.String()))
+	}
+}
+
+type progressCall struct {
+	transferred int64
+	total       int64
+}
+
+// Sets the progress function of the upload object to record every call
+func recordProgress(uploadObject *UploadObject) *[]progressCall {
+	var mu sync.Mutex
+	calls := &[]progressCall{}
+	uploadObject.ProgressFn = func(transferred int64, total int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		*calls = append(*calls, progressCall{transferred, total})
+	}
+	return calls
+}
+
+// Checks the progress increased across each of the 3 parts to the size of the multipart test file
+func checkProgress(t *testing.T, calls []progressCall) {
+	if len(calls) < 3 {
+		t.Fatal(fmt.Sprintf("expected the progress to be reported at least once per part but got: %v", calls))
+	}
+	for i, call := range calls {
+		if call.total != multipartFileSize {
+			t.Fatal(fmt.Sprintf("expected the total to be the size of the file %d but got: %d", multipartFileSize, call.total))
+		}
+		if i > 0 && call.transferred <= calls[i-1].transferred {
+			t.Fatal(fmt.Sprintf("expected the progress to increase but got %d after %d", call.transferred, calls[i-1].transferred))
+		}
+	}
+	if last := calls[len(calls)-1]; last.transferred != multipartFileSize {
+		t.Error(fmt.Sprintf("expected the progress to reach the size of the file %d but got: %d", multipartFileSize, last.transferred))
+	}
+}
+
+func mustOpen(t *testing.T, pathToFile string) *os.File {
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+//----------------------------------------------
+// Zip Archive Testing (mock S3)
+//	1: A directory is uploaded as a zip archive preserving relative paths
+//	2: A dry run builds the archive without uploading it and records its size
+//	3: Upload fails when the path to file is not a directory
+//	4: Hidden files and directories are excluded by default
+//	5: Hidden files and directories are included when enabled
+//
+//----------------------------------------------
+
+// Test 1 - Zip Archive Testing
+//	A directory is uploaded as a zip archive preserving relative paths
+func TestUploadZip(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := zipUploadObject(t)
+
+	key, err := UploadZip(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload zip archive without any error: %v", err))
+	}
+
+	obj := mockS3.Object(mockBucket, key)
+	if obj == nil {
+		t.Fatal("expected the zip archive to be uploaded: " + key)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(obj.Body), int64(len(obj.Body)))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the uploaded object to be a valid zip archive: %v", err))
+	}
+
+	names := []string{}
+	for _, entry := range reader.File {
+		names = append(names, entry.Name)
+	}
+
+	expected := "[nested/ nested/deep/ nested/deep/file.txt top.txt]"
+	if fmt.Sprint(names) != expected {
+		t.Error(fmt.Sprintf("expected archive entries %s but got %v", expected, names))
+	}
+
+	if obj.Header.Get("X-Amz-Meta-"+ArchiveMetadataKey) != ArchiveFormatZip {
+		t.Error("expected the archive format to be recorded in the object metadata")
+	}
+}
+
+// Test 2 - Zip Archive Testing
+//	A dry run builds the archive without uploading it and records its size
+func TestUploadZipDryRun(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := zipUploadObject(t)
+	testUploadObject.ResultsFile = filepath.Join(t.TempDir(), "results.ndjson")
+
+	_, err := UploadZip(mockS3.Client(), testUploadObject, "", true)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected dry run of zip archive without any error: %v", err))
+	}
+
+	if len(mockS3.Keys(mockBucket)) != 0 {
+		t.Error("expected nothing to be uploaded with dry run enabled")
+	}
+
+	results := readResultsFile(t, testUploadObject.ResultsFile)
+	if len(results) != 1 || results[0].Status != ResultStatusDryRun || results[0].Bytes == 0 || results[0].Checksum == "" {
+		t.Error(fmt.Sprintf("expected a dry run result with the archive size and checksum: %v", results))
+	}
+}
+
+// Test 3 - Zip Archive Testing
+//	Upload fails when the path to file is not a directory
+func TestUploadZipNotDirectory(t *testing.T) {
+	expectedErrString := "path to file must be a directory"
+
+	testUploadObject := zipUploadObject(t)
+	testUploadObject.PathToFile = pathToTestFile
+
+	_, err := UploadZip(svc, testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 4 - Zip Archive Testing
+//	Hidden files and directories are excluded by default
+func TestUploadZipExcludesDotfiles(t *testing.T) {
+	testUploadObject := zipUploadObject(t)
+	addDotfiles(testUploadObject.PathToFile)
+
+	expected := "[nested/ nested/deep/ nested/deep/file.txt top.txt]"
+	if names := uploadZipEntries(t, testUploadObject); fmt.Sprint(names) != expected {
+		t.Error(fmt.Sprintf("expected archive entries %s but got %v", expected, names))
+	}
+
+	dirSize, err := getDirSize(testUploadObject.PathToFile, false)
+	if err != nil || dirSize != int64(len("this is just a little test file")+len("a file in a nested directory")) {
+		t.Error(fmt.Sprintf("expected the size of hidden files to be excluded but got %d: %v", dirSize, err))
+	}
+}
+
+// Test 5 - Zip Archive Testing
+//	Hidden files and directories are included when enabled
+func TestUploadZipIncludesDotfiles(t *testing.T) {
+	testUploadObject := zipUploadObject(t)
+	testUploadObject.IncludeDotfiles = true
+	addDotfiles(testUploadObject.PathToFile)
+
+	expected := "[.DS_Store .git/ .git/config nested/ nested/.hidden nested/deep/ nested/deep/file.txt top.txt]"
+	if names := uploadZipEntries(t, testUploadObject); fmt.Sprint(names) != expected {
+		t.Error(fmt.Sprintf("expected archive entries %s but got %v", expected, names))
+	}
+}
+
+// Adds hidden files and a hidden directory to the directory of a zip upload object
+func addDotfiles(dir string) {
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ".git", "config"), []byte("[core]"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".DS_Store"), []byte("finder metadata"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "nested", ".hidden"), []byte("a hidden file"), 0644)
+}
+
+// Uploads the directory as a zip archive and returns the names of the entries in the archive
+func uploadZipEntries(t *testing.T, testUploadObject UploadObject) []string {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	key, err := UploadZip(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload zip archive without any error: %v", err))
+	}
+
+	body := mockS3.Object(mockBucket, key).Body
+	reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the uploaded object to be a valid zip archive: %v", err))
+	}
+
+	names := []string{}
+	for _, entry := range reader.File {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+// Returns an upload object for a nested directory created in a temporary directory
+func zipUploadObject(t *testing.T) UploadObject {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "nested", "deep"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "top.txt"), []byte("this is just a little test file"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "nested", "deep", "file.txt"), []byte("a file in a nested directory"), 0644)
+
+	return UploadObject{
+		PathToFile: dir,
+		S3FileName: "zipTestDir",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 3,
+		PartSize:   5,
+	}
+}
+
+//----------------------------------------------
+// Time Source Testing (mock S3)
+//	1: An old file on the first of the month is classified as monthly and timestamped with its mtime
+//	2: An old file on a Monday is classified as weekly and timestamped with its mtime
+//	3: Upload fails with an invalid time source
+//	4: A file is timestamped with its mtime in the key time layout
+//	5: Upload fails with an invalid key time layout
+//	6: A file is timestamped with its mtime in the time zone of the upload object
+//	7: A backup run just before midnight uses the same time for its tier, key and tags when the clock passes midnight
+//
+//----------------------------------------------
+
+// Test 1 - Time Source Testing
+//	An old file on the first of the month is classified as monthly and timestamped with its mtime
+func TestTimeSourceFileMtimeMonthly(t *testing.T) {
+	assertFileMtimeKey(t, time.Date(2019, time.March, 1, 10, 30, 0, 0, time.Local), policy.MonthlyPrefix, "")
+}
+
+// Test 2 - Time Source Testing
+//	An old file on a Monday is classified as weekly and timestamped with its mtime
+func TestTimeSourceFileMtimeWeekly(t *testing.T) {
+	assertFileMtimeKey(t, time.Date(2019, time.March, 4, 23, 59, 59, 0, time.Local), policy.WeeklyPrefix, "")
+}
+
+// Test 3 - Time Source Testing
+//	Upload fails with an invalid time source
+func TestTimeSourceInvalid(t *testing.T) {
+	expectedErrString := "time source must be either 'now' or 'filemtime'"
+
+	testUploadObject := testUploadObjectManipulated
+	testUploadObject.TimeSource = "ctime"
+
+	_, err := UploadFile(svc, testUploadObject, policy.DailyPrefix, false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 4 - Time Source Testing
+//	A file is timestamped with its mtime in the key time layout
+func TestTimeSourceKeyTimeLayout(t *testing.T) {
+	assertFileMtimeKey(t, time.Date(2019, time.March, 5, 2, 15, 0, 0, time.Local), policy.DailyPrefix, "2006-01-02_1504")
+}
+
+// Test 5 - Time Source Testing
+//	Upload fails with an invalid key time layout
+func TestTimeSourceInvalidKeyTimeLayout(t *testing.T) {
+	expectedErrString := "invalid key time layout 'Jan 2 2006'"
+
+	testUploadObject := testUploadObjectManipulated
+	testUploadObject.KeyTimeLayout = "Jan 2 2006"
+
+	_, err := UploadFile(svc, testUploadObject, policy.DailyPrefix, false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 6 - Time Source Testing
+//	A file modified on Sunday evening in UTC is timestamped with its mtime on Monday in Sydney
+func TestTimeSourceLocation(t *testing.T) {
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Skip(fmt.Sprintf("time zone database is unavailable: %v", err))
+	}
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	mtime := time.Date(2019, time.March, 3, 20, 0, 0, 0, time.UTC)
+	pathToFile := filepath.Join(t.TempDir(), "archivedFile")
+	if err := ioutil.WriteFile(pathToFile, []byte("this is just a little archived file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(pathToFile, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	testUploadObject := UploadObject{
+		PathToFile: pathToFile,
+		S3FileName: "archivedFile",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 5,
+		PartSize:   50,
+		Manipulate: true,
+		TimeSource: TimeSourceFileMtime,
+
+		Location: sydney,
+	}
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, policy.WeeklyPrefix, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+	if expectedKey := "weekly_archivedFile_20190304T070000"; key != expectedKey {
+		t.Error(fmt.Sprintf("expected the key to be timestamped in Sydney: '%s' but got '%s'", expectedKey, key))
+	}
+}
+
+// Test 7 - Time Source Testing
+//	A backup run just before midnight uses the same time for its tier, key and tags when the clock passes midnight
+func TestTimeSourceFixedAcrossMidnight(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	// Sunday is daily but every later reading of the clock is on Monday which is weekly
+	sunday := time.Date(2019, time.March, 3, 23, 59, 59, 999000000, time.Local)
+	monday := time.Date(2019, time.March, 4, 0, 0, 0, 1000000, time.Local)
+	readings := 0
+	timeNow = func() time.Time {
+		readings++
+		if readings == 1 {
+			return sunday
+		}
+		return monday
+	}
+	defer func() { timeNow = time.Now }()
+
+	pathToFile := filepath.Join(t.TempDir(), "clockFile")
+	if err := ioutil.WriteFile(pathToFile, []byte("this is just a little test file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testUploadObject, keyTime, err := WithKeyTime(UploadObject{
+		PathToFile: pathToFile,
+		S3FileName: "clockFile",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 5,
+		PartSize:   50,
+		Manipulate: true,
+		Tags:       map[string]string{"date": "{date}"},
+	})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to fix the key time without any error: %v", err))
+	}
+
+	prefix := util.GetKeyType(policy, keyTime)
+	if prefix != policy.DailyPrefix {
+		t.Error(fmt.Sprintf("expected the backup to be classified as '%s' but got '%s'", policy.DailyPrefix, prefix))
+	}
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, prefix, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+	if expectedKey := "daily_clockFile_20190303T235959"; key != expectedKey {
+		t.Error(fmt.Sprintf("expected the key to be timestamped with the time of the tier: '%s' but got '%s'", expectedKey, key))
+	}
+	if date := mockS3.Object(mockBucket, key).Tags["date"]; date != "2019-03-03" {
+		t.Error(fmt.Sprintf("expected the date tag to be the date of the tier: '2019-03-03' but got '%s'", date))
+	}
+	if readings != 1 {
+		t.Error(fmt.Sprintf("expected the clock to be read once but it was read %d times", readings))
+	}
+}
+
+// Uploads a file with the modification time and asserts that both the tier and key timestamp reflect the mtime in the
+// key time layout. An empty layout expects the default layout
+func assertFileMtimeKey(t *testing.T, mtime time.Time, expectedPrefix string, layout string) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	pathToFile := filepath.Join(t.TempDir(), "archivedFile")
+	if err := ioutil.WriteFile(pathToFile, []byte("this is just a little archived file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(pathToFile, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	testUploadObject := UploadObject{
+		PathToFile: pathToFile,
+		S3FileName: "archivedFile",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 5,
+		PartSize:   50,
+		Manipulate: true,
+		TimeSource: TimeSourceFileMtime,
+
+		KeyTimeLayout: layout,
+	}
+
+	keyTime, err := GetKeyTime(testUploadObject)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to get the key time without any error: %v", err))
+	}
+
+	prefix := util.GetKeyType(policy, keyTime)
+	if prefix != expectedPrefix {
+		t.Error(fmt.Sprintf("expected file modified at %v to be classified as '%s' but got '%s'", mtime, expectedPrefix, prefix))
+	}
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, prefix, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+
+	if layout == "" {
+		layout = util.DefaultKeyTimeLayout
+	}
+	expectedKey := expectedPrefix + "archivedFile_" + mtime.Format(layout)
+	if key != expectedKey || mockS3.Object(mockBucket, expectedKey) == nil {
+		t.Error(fmt.Sprintf("expected the key to reflect the file mtime: '%s' but got '%s'", expectedKey, key))
+	}
+}
+
+//----------------------------------------------
+// Date Rounding Testing (mock S3)
+//	1: Two backups on the same day rounded to the day share a key and the later backup overwrites the earlier one
+//	2: Backups on different days rounded to the day have distinct keys
+//	3: Backups rounded to the hour share a key within the hour and have distinct keys in different hours
+//	4: Upload fails with an invalid date rounding
+//
+//----------------------------------------------
+
+// Test 1 - Date Rounding Testing
+//	Back up a file modified in the morning and again in the evening of the same day rounded to the day
+func TestDateRoundingSameDay(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	first := uploadRoundedFile(t, mockS3, DateRoundingDay, time.Date(2019, time.March, 5, 2, 15, 0, 0, time.Local), "morning backup")
+	second := uploadRoundedFile(t, mockS3, DateRoundingDay, time.Date(2019, time.March, 5, 23, 40, 0, 0, time.Local), "evening backup")
+
+	expectedKey := "daily_roundedFile_20190305T000000"
+	if first != expectedKey || second != expectedKey {
+		t.Error(fmt.Sprintf("expected both backups to be uploaded to '%s' but got '%s' and '%s'", expectedKey, first, second))
+	}
+	if keys := mockS3.Keys(mockBucket); len(keys) != 1 {
+		t.Error(fmt.Sprintf("expected a single backup for the day but got %v", keys))
+	}
+	if obj := mockS3.Object(mockBucket, expectedKey); obj == nil || string(obj.Body) != "evening backup" {
+		t.Error("expected the later backup to overwrite the earlier backup of the day")
+	}
+}
+
+// Test 2 - Date Rounding Testing
+//	Back up a file modified on two consecutive days rounded to the day
+func TestDateRoundingDifferentDays(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	first := uploadRoundedFile(t, mockS3, DateRoundingDay, time.Date(2019, time.March, 5, 23, 59, 0, 0, time.Local), "first backup")
+	second := uploadRoundedFile(t, mockS3, DateRoundingDay, time.Date(2019, time.March, 6, 0, 1, 0, 0, time.Local), "second backup")
+
+	if first == second {
+		t.Error(fmt.Sprintf("expected backups on different days to have distinct keys but both got '%s'", first))
+	}
+	if keys := mockS3.Keys(mockBucket); len(keys) != 2 {
+		t.Error(fmt.Sprintf("expected a backup for each day but got %v", keys))
+	}
+}
+
+// Test 3 - Date Rounding Testing
+//	Back up a file twice within an hour and again in the next hour rounded to the hour
+func TestDateRoundingHour(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	first := uploadRoundedFile(t, mockS3, DateRoundingHour, time.Date(2019, time.March, 5, 14, 5, 0, 0, time.Local), "first backup")
+	second := uploadRoundedFile(t, mockS3, DateRoundingHour, time.Date(2019, time.March, 5, 14, 55, 0, 0, time.Local), "second backup")
+	third := uploadRoundedFile(t, mockS3, DateRoundingHour, time.Date(2019, time.March, 5, 15, 5, 0, 0, time.Local), "third backup")
+
+	if first != "daily_roundedFile_20190305T140000" || second != first {
+		t.Error(fmt.Sprintf("expected both backups within the hour to be uploaded to 'daily_roundedFile_20190305T140000' but got '%s' and '%s'", first, second))
+	}
+	if third != "daily_roundedFile_20190305T150000" {
+		t.Error(fmt.Sprintf("expected the backup in the next hour to be uploaded to 'daily_roundedFile_20190305T150000' but got '%s'", third))
+	}
+}
+
+// Test 4 - Date Rounding Testing
+//	Upload fails with an invalid date rounding
+func TestDateRoundingInvalid(t *testing.T) {
+	expectedErrString := "date rounding must be either 'day' or 'hour'"
+
+	testUploadObject := testUploadObjectManipulated
+	testUploadObject.DateRounding = "week"
+
+	_, err := UploadFile(svc, testUploadObject, policy.DailyPrefix, false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Uploads the contents as a daily backup of a file with the modification time and the date rounding. Returns the key
+func uploadRoundedFile(t *testing.T, mockS3 *s3mock.Server, dateRounding string, mtime time.Time, contents string) string {
+	pathToFile := filepath.Join(t.TempDir(), "roundedFile")
+	if err := ioutil.WriteFile(pathToFile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(pathToFile, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	testUploadObject := UploadObject{
+		PathToFile: pathToFile,
+		S3FileName: "roundedFile",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 5,
+		PartSize:   50,
+		Manipulate: true,
+		TimeSource: TimeSourceFileMtime,
+
+		DateRounding: dateRounding,
+	}
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, policy.DailyPrefix, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+	return key
+}
+
+//----------------------------------------------
+// Symlink Source Testing (mock S3)
+//	1: The contents of the file a symlink links to are uploaded under the key of the symlink by default
+//	2: The upload of a symlink is skipped with a warning when symlinks are not followed
+//	3: Upload fails with a broken symlink whether or not symlinks are followed
+//
+//----------------------------------------------
+
+// Test 1 - Symlink Source Testing
+//	Upload a symlink to a regular file
+func TestSymlinkSourceFollowed(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := symlinkFileUploadObject(t, true)
+	result, err := UploadFileWithResult(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the symlink without any error: %v", err))
+	}
+
+	if result.Key != "linkedFile" || result.Status != ResultStatusSuccess {
+		t.Error(fmt.Sprintf("expected the symlink to be uploaded to 'linkedFile' but got %+v", result))
+	}
+	if obj := mockS3.Object(mockBucket, "linkedFile"); obj == nil || string(obj.Body) != "the target of the link" {
+		t.Error("expected the contents of the target of the symlink to be uploaded")
+	}
+}
+
+// Test 2 - Symlink Source Testing
+//	Upload a symlink to a regular file with skip symlink enabled
+func TestSymlinkSourceSkipped(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	var warn bytes.Buffer
+	log.Init(ioutil.Discard, &warn, ioutil.Discard)
+	defer log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	testUploadObject := symlinkFileUploadObject(t, true)
+	testUploadObject.SkipSymlink = true
+	result, err := UploadFileWithResult(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the upload of the symlink to be skipped without any error: %v", err))
+	}
+
+	if result.Key != "" || result.Status != ResultStatusSkipped {
+		t.Error(fmt.Sprintf("expected the upload of the symlink to be skipped but got %+v", result))
+	}
+	if keys := mockS3.Keys(mockBucket); len(keys) != 0 {
+		t.Error(fmt.Sprintf("expected nothing to be uploaded but found %v", keys))
+	}
+	if !strings.Contains(warn.String(), "is a symlink to") {
+		t.Error(fmt.Sprintf("expected a warning that the symlink was skipped but got: %s", warn.String()))
+	}
+}
+
+// Test 3 - Symlink Source Testing
+//	Upload a symlink whose target does not exist with and without skip symlink enabled
+func TestSymlinkSourceBroken(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	expectedErrString := "is a broken symlink to"
+	for _, skipSymlink := range []bool{false, true} {
+		testUploadObject := symlinkFileUploadObject(t, false)
+		testUploadObject.SkipSymlink = skipSymlink
+
+		_, err := UploadFileWithResult(mockS3.Client(), testUploadObject, "", false)
+		if err != nil && strings.Contains(err.Error(), expectedErrString) {
+			// Pass
+		} else {
+			t.Error(fmt.Sprintf("expected error containing '%s' with skip symlink %v but got: %v", expectedErrString, skipSymlink, err))
+		}
+	}
+}
+
+// Returns an upload object of a symlink to a regular file, or to a file which does not exist if the target is not created
+func symlinkFileUploadObject(t *testing.T, createTarget bool) UploadObject {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	if createTarget {
+		if err := ioutil.WriteFile(target, []byte("the target of the link"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pathToFile := filepath.Join(dir, "link")
+	if err := os.Symlink(target, pathToFile); err != nil {
+		t.Fatal(err)
+	}
+
+	return UploadObject{
+		PathToFile: pathToFile,
+		S3FileName: "linkedFile",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 5,
+		PartSize:   50,
+	}
+}
+
+//----------------------------------------------
+// Inline Hashing Testing (mock S3)
+//	1: The composite digest computed during the upload matches the multipart ETag of the object
+//	2: Writes spanning part boundaries are hashed into the correct parts
+//	3: Verification fails when the object ETag does not match the composite digest
+//
+//----------------------------------------------
+
+// Test 1 - Inline Hashing Testing
+//	The composite digest computed during the upload matches the multipart ETag of the object
+func TestInlineHashCompositeMatchesMultipartETag(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := multipartUploadObject(true)
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected strong verification to pass: %v", err))
+	}
+
+	expectedETag := computeMultipartETag(t, testUploadObject.PathToFile, int64(testUploadObject.PartSize*1024*1024))
+
+	obj := mockS3.Object(mockBucket, key)
+	if obj == nil || strings.Trim(obj.ETag, "\"") != expectedETag {
+		t.Error(fmt.Sprintf("expected the object ETag to be the multipart ETag '%s' computed from the file", expectedETag))
+	}
+}
+
+// Test 2 - Inline Hashing Testing
+//	Writes spanning part boundaries are hashed into the correct parts
+func TestInlineHasherPartBoundaries(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 25)
+	partSize := int64(64)
+
+	hasher := newInlineHasher(partSize)
+	for _, chunk := range [][]byte{data[:10], data[10:100], data[100:128], data[128:]} {
+		hasher.Write(chunk)
+	}
+
+	expectedMD5s := []string{}
+	composite := md5.New()
+	for offset := int64(0); offset < int64(len(data)); offset += partSize {
+		end := offset + partSize
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		sum := md5.Sum(data[offset:end])
+		expectedMD5s = append(expectedMD5s, hex.EncodeToString(sum[:]))
+		composite.Write(sum[:])
+	}
+	expectedETag := fmt.Sprintf("%s-%d", hex.EncodeToString(composite.Sum(nil)), len(expectedMD5s))
+
+	if strings.Join(hasher.PartMD5s(), ",") != strings.Join(expectedMD5s, ",") {
+		t.Error(fmt.Sprintf("expected part md5s %v but got %v", expectedMD5s, hasher.PartMD5s()))
+	}
+
+	wholeSum := md5.Sum(data)
+	if hasher.MD5() != hex.EncodeToString(wholeSum[:]) {
+		t.Error("expected the md5sum of every byte written")
+	}
+
+	if hasher.CompositeETag() != expectedETag {
+		t.Error(fmt.Sprintf("expected composite ETag '%s' but got '%s'", expectedETag, hasher.CompositeETag()))
+	}
+}
+
+// Test 3 - Inline Hashing Testing
+//	Verification fails when the object ETag does not match the composite digest
+func TestInlineHashCompositeMismatch(t *testing.T) {
+	expectedErrString := "does not match the composite ETag"
+
+	hasher := newInlineHasher(4)
+	hasher.Write([]byte("abcdefgh"))
+
+	recorder := newPartETagRecorder()
+	for i, partMD5 := range hasher.PartMD5s() {
+		recorder.etags[int64(i+1)] = partMD5
+	}
+
+	err := strongVerify(hasher, recorder, "\"d41d8cd98f00b204e9800998ecf8427e-2\"")
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if err = strongVerify(hasher, recorder, hasher.CompositeETag()); err != nil {
+		t.Error(fmt.Sprintf("expected verification to pass with the composite ETag: %v", err))
+	}
+}
+
+// Independently computes the ETag S3 assigns to the file when it is uploaded in parts of the part size
+func computeMultipartETag(t *testing.T, path string, partSize int64) string {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	composite := md5.New()
+	parts := 0
+	for offset := int64(0); offset < int64(len(body)); offset += partSize {
+		end := offset + partSize
+		if end > int64(len(body)) {
+			end = int64(len(body))
+		}
+		sum := md5.Sum(body[offset:end])
+		composite.Write(sum[:])
+		parts++
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(composite.Sum(nil)), parts)
+}
+
+//----------------------------------------------
+// Object Attributes Testing (mock S3)
+//	1: A multipart upload creates an object with the same attributes as a single part upload
+//	2: Attributes ignored by CreateMultipartUpload are applied once the upload completes
+//	3: Only the tags are reapplied when the provider ignored just the tags
+//	4: Upload fails with an invalid ACL
+//	5: The website redirect location is sent with single part and multipart uploads
+//	6: Upload fails with an invalid website redirect location
+//	7: The storage class is sent with single part and multipart uploads
+//	8: Upload fails with an invalid storage class before any request is made
+//
+//----------------------------------------------
+
+// Test 1 - Object Attributes Testing
+//	A multipart upload creates an object with the same attributes as a single part upload
+func TestMultipartAttributesMatchSinglePart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	singlePartKey, multipartKey := uploadWithAttributes(t, mockS3, false)
+	assertSameAttributes(t, mockS3, singlePartKey, multipartKey)
+
+	if len(mockS3.Requests("CopyObject")) != 0 || len(mockS3.Requests("PutObjectTagging")) != 0 {
+		t.Error("expected the attributes not to be reapplied when the provider applied them")
+	}
+}
+
+// Test 2 - Object Attributes Testing
+//	Attributes ignored by CreateMultipartUpload are applied once the upload completes
+func TestMultipartAttributesFinalized(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	// Emulate a provider which only applies attributes to single part uploads
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "CreateMultipartUpload" {
+			for header := range req.Header {
+				if strings.HasPrefix(header, "X-Amz-Meta-") || header == "X-Amz-Tagging" || header == "X-Amz-Acl" {
+					req.Header.Del(header)
+				}
+			}
+		}
+		return nil
+	})
+
+	singlePartKey, multipartKey := uploadWithAttributes(t, mockS3, true)
+	assertSameAttributes(t, mockS3, singlePartKey, multipartKey)
+
+	if len(mockS3.Requests("CopyObject")) != 1 {
+		t.Error(fmt.Sprintf("expected the object to be copied onto itself once, got %d", len(mockS3.Requests("CopyObject"))))
+	}
+}
+
+// Test 3 - Object Attributes Testing
+//	Only the tags are reapplied when the provider ignored just the tags
+func TestMultipartTagsFinalized(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "CreateMultipartUpload" {
+			req.Header.Del("X-Amz-Tagging")
+		}
+		return nil
+	})
+
+	singlePartKey, multipartKey := uploadWithAttributes(t, mockS3, true)
+	assertSameAttributes(t, mockS3, singlePartKey, multipartKey)
+
+	if len(mockS3.Requests("CopyObject")) != 0 || len(mockS3.Requests("PutObjectTagging")) != 1 {
+		t.Error("expected only the tags to be reapplied with PutObjectTagging")
+	}
+}
+
+// Test 4 - Object Attributes Testing
+//	Upload fails with an invalid ACL
+func TestUploadInvalidACL(t *testing.T) {
+	expectedErrString := "invalid ACL 'world-writable'"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.ACL = "world-writable"
+
+	_, err := UploadFile(svc, testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 5 - Object Attributes Testing
+//	The website redirect location is sent with single part and multipart uploads
+func TestUploadWebsiteRedirectLocation(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.WebsiteRedirectLocation = "/downloads/index.html"
+
+	multipartObject := multipartUploadObject(false)
+	multipartObject.WebsiteRedirectLocation = "https://example.com/downloads"
+
+	for _, uploadObject := range []UploadObject{testUploadObject, multipartObject} {
+		key, err := UploadFile(mockS3.Client(), uploadObject, "", false)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to upload '%s' without any error: %v", uploadObject.PathToFile, err))
+		}
+		if redirect := mockS3.Object(mockBucket, key).Header.Get("X-Amz-Website-Redirect-Location"); redirect != uploadObject.WebsiteRedirectLocation {
+			t.Error(fmt.Sprintf("expected key '%s' to redirect to '%s' but got: '%s'", key, uploadObject.WebsiteRedirectLocation, redirect))
+		}
+	}
+
+	puts, creates := mockS3.Requests("PutObject"), mockS3.Requests("CreateMultipartUpload")
+	if len(puts) != 1 || puts[0].Header.Get("X-Amz-Website-Redirect-Location") != "/downloads/index.html" {
+		t.Error("expected the website redirect location to be sent with PutObject")
+	}
+	if len(creates) != 1 || creates[0].Header.Get("X-Amz-Website-Redirect-Location") != "https://example.com/downloads" {
+		t.Error("expected the website redirect location to be sent with CreateMultipartUpload")
+	}
+}
+
+// Test 6 - Object Attributes Testing
+//	Upload fails with an invalid website redirect location
+func TestUploadInvalidWebsiteRedirectLocation(t *testing.T) {
+	expectedErrString := "invalid website redirect location 'downloads/index.html'"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.WebsiteRedirectLocation = "downloads/index.html"
+
+	_, err := UploadFile(svc, testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 7 - Object Attributes Testing
+//	The storage class is sent with single part and multipart uploads
+func TestUploadStorageClass(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.StorageClass = s3.StorageClassStandardIa
+
+	multipartObject := multipartUploadObject(false)
+	multipartObject.StorageClass = s3.StorageClassGlacier
+
+	for _, uploadObject := range []UploadObject{testUploadObject, multipartObject} {
+		key, err := UploadFile(mockS3.Client(), uploadObject, "", false)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to upload '%s' without any error: %v", uploadObject.PathToFile, err))
+		}
+		if storageClass := mockS3.Object(mockBucket, key).Header.Get("X-Amz-Storage-Class"); storageClass != uploadObject.StorageClass {
+			t.Error(fmt.Sprintf("expected key '%s' to be stored in '%s' but got: '%s'", key, uploadObject.StorageClass, storageClass))
+		}
+	}
+
+	if len(mockS3.Requests("CreateMultipartUpload")) != 1 {
+		t.Error("expected the multipart test file to be uploaded with a multipart upload")
+	}
+}
+
+// Test 8 - Object Attributes Testing
+//	Specify a storage class with a typo
+func TestUploadInvalidStorageClass(t *testing.T) {
+	expectedErrString := "invalid storage class 'GLACEIR', expected one of: STANDARD"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.StorageClass = "GLACEIR"
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if len(mockS3.Requests("PutObject")) != 0 {
+		t.Error("expected the upload to fail before any request was made")
+	}
+}
+
+// Uploads the test file with a single part upload and the multipart test file with a multipart upload using the same
+// tags, metadata and ACL. Returns the single part key and the multipart key
+func uploadWithAttributes(t *testing.T, mockS3 *s3mock.Server, finalizeAttributes bool) (string, string) {
+	singlePartObject := testUploadObjectNotManipulated
+	singlePartObject.Bucket = mockBucket
+	multipartObject := multipartUploadObject(false)
+
+	keys := []string{}
+	for _, testUploadObject := range []UploadObject{singlePartObject, multipartObject} {
+		testUploadObject.SkipIfUnchanged = true // Records the checksum of the file in the metadata
+		testUploadObject.Tags = map[string]string{"app": "s3backup", "env": "test"}
+		testUploadObject.ACL = s3.ObjectCannedACLBucketOwnerFullControl
+		testUploadObject.FinalizeAttributes = finalizeAttributes
+
+		key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to upload file without any error: %v", err))
+		}
+		keys = append(keys, key)
+	}
+
+	if len(mockS3.Requests("CreateMultipartUpload")) != 1 {
+		t.Fatal("expected the multipart test file to be uploaded with a multipart upload")
+	}
+
+	return keys[0], keys[1]
+}
+
+func assertSameAttributes(t *testing.T, mockS3 *s3mock.Server, singlePartKey string, multipartKey string) {
+	singlePart := mockS3.Object(mockBucket, singlePartKey)
+	multipart := mockS3.Object(mockBucket, multipartKey)
+
+	if fmt.Sprint(singlePart.Tags) != fmt.Sprint(multipart.Tags) || len(multipart.Tags) != 2 {
+		t.Error(fmt.Sprintf("expected tags %v on the multipart object but got %v", singlePart.Tags, multipart.Tags))
+	}
+
+	if multipart.Header.Get("X-Amz-Acl") != singlePart.Header.Get("X-Amz-Acl") || multipart.Header.Get("X-Amz-Acl") == "" {
+		t.Error(fmt.Sprintf("expected ACL '%s' on the multipart object but got '%s'", singlePart.Header.Get("X-Amz-Acl"), multipart.Header.Get("X-Amz-Acl")))
+	}
+
+	checksum := "X-Amz-Meta-" + ChecksumMetadataKey
+	if singlePart.Header.Get(checksum) == "" || multipart.Header.Get(checksum) == "" {
+		t.Error("expected the checksum metadata on both the single part and multipart objects")
+	}
+}
+
+//----------------------------------------------
+// Chunked Upload Testing (mock S3)
+//	1: Only the chunks around a modified region of a large file are uploaded again
+//	2: Uploading an unchanged file uploads no chunks
+//	3: Chunks reassemble to the stream and respect the minimum and maximum chunk size
+//	4: Upload fails when compression is specified with chunking
+//
+//----------------------------------------------
+
+// Test 1 - Chunked Upload Testing
+//	Only the chunks around a modified region of a large file are uploaded again
+func TestChunkedUploadOnlyChangedChunks(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := chunkedUploadObject(t, 16*1024*1024)
+
+	firstManifest := uploadChunked(t, mockS3, testUploadObject)
+	firstChunkUploads := len(mockS3.Requests("PutObject")) - 1 // Excluding the manifest
+
+	if firstChunkUploads != len(firstManifest.Chunks) || firstChunkUploads < 4 {
+		t.Fatal(fmt.Sprintf("expected every one of the %d chunks to be uploaded, got %d uploads", len(firstManifest.Chunks), firstChunkUploads))
+	}
+
+	// Overwrite a small region in the middle of the file
+	contents, _ := ioutil.ReadFile(testUploadObject.PathToFile)
+	copy(contents[8*1024*1024:], bytes.Repeat([]byte("modified"), 16))
+	ioutil.WriteFile(testUploadObject.PathToFile, contents, 0644)
+
+	secondManifest := uploadChunked(t, mockS3, testUploadObject)
+	secondChunkUploads := len(mockS3.Requests("PutObject")) - firstChunkUploads - 2
+
+	if secondChunkUploads < 1 || secondChunkUploads > 2 {
+		t.Error(fmt.Sprintf("expected only the chunks around the modified region to be uploaded, got %d uploads", secondChunkUploads))
+	}
+
+	changed := 0
+	for _, chunk := range secondManifest.Chunks {
+		if mockS3.Object(mockBucket, chunk.Key) == nil {
+			t.Error(fmt.Sprintf("expected chunk '%s' to be stored", chunk.Key))
+		}
+		if !manifestContains(firstManifest, chunk.Hash) {
+			changed++
+		}
+	}
+	if changed != secondChunkUploads {
+		t.Error(fmt.Sprintf("expected the %d uploaded chunks to be the only chunks not in the previous manifest, got %d", secondChunkUploads, changed))
+	}
+
+	sum := md5.Sum(contents)
+	if secondManifest.MD5 != hex.EncodeToString(sum[:]) || secondManifest.Size != int64(len(contents)) {
+		t.Error("expected the manifest to record the md5sum and size of the modified file")
+	}
+}
+
+// Test 2 - Chunked Upload Testing
+//	Uploading an unchanged file uploads no chunks
+func TestChunkedUploadUnchanged(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := chunkedUploadObject(t, 6*1024*1024)
+
+	firstManifest := uploadChunked(t, mockS3, testUploadObject)
+	uploads := len(mockS3.Requests("PutObject"))
+
+	secondManifest := uploadChunked(t, mockS3, testUploadObject)
+	if len(mockS3.Requests("PutObject")) != uploads+1 {
+		t.Error("expected only the manifest to be uploaded when the file is unchanged")
+	}
+
+	if secondManifest.Root != firstManifest.Root {
+		t.Error("expected the Merkle root to be unchanged when the file is unchanged")
+	}
+
+	obj := mockS3.Object(mockBucket, testUploadObject.S3FileName)
+	if obj.Header.Get("X-Amz-Meta-"+ArchiveMetadataKey) != ArchiveFormatChunks {
+		t.Error("expected the archive format of the manifest to be recorded in the metadata")
+	}
+}
+
+// Test 3 - Chunked Upload Testing
+//	Chunks reassemble to the stream and respect the minimum and maximum chunk size
+func TestChunkerBoundaries(t *testing.T) {
+	data := make([]byte, 3*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	data = append(data, make([]byte, 1024*1024)...) // A run of zeros has no boundaries so is split at the maximum size
+
+	avgSize := int64(64 * 1024)
+	c := newChunker(bytes.NewReader(data), avgSize)
+
+	var reassembled []byte
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		last := len(reassembled)+len(chunk) == len(data)
+		if int64(len(chunk)) > avgSize*4 || (int64(len(chunk)) < avgSize/4 && !last) {
+			t.Error(fmt.Sprintf("expected chunk of %d bytes to be between the minimum and maximum chunk size", len(chunk)))
+		}
+		reassembled = append(reassembled, chunk...)
+	}
+
+	if !bytes.Equal(reassembled, data) {
+		t.Error("expected the chunks to reassemble to the original stream")
+	}
+}
+
+// Test 4 - Chunked Upload Testing
+//	Upload fails when compression is specified with chunking
+func TestChunkedUploadCompression(t *testing.T) {
+	expectedErrString := "compression is not supported with chunked uploads"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.ChunkSize = 1
+	testUploadObject.Compression = "gzip"
+
+	_, err := UploadChunked(svc, testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Writes a file of random contents of the specified size and returns an upload object which uploads it in 1MiB chunks
+func chunkedUploadObject(t *testing.T, size int) UploadObject {
+	contents := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(contents)
+
+	pathToFile := filepath.Join(t.TempDir(), "chunkedTestFile")
+	if err := ioutil.WriteFile(pathToFile, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return UploadObject{
+		PathToFile: pathToFile,
+		S3FileName: "chunkedTestFile",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 3,
+		PartSize:   5,
+		ChunkSize:  1,
+	}
+}
+
+// Uploads the upload object in chunks and returns the manifest which was uploaded
+func uploadChunked(t *testing.T, mockS3 *s3mock.Server, testUploadObject UploadObject) ChunkManifest {
+	key, err := UploadChunked(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file in chunks without any error: %v", err))
+	}
+
+	var manifest ChunkManifest
+	if err = json.Unmarshal(mockS3.Object(mockBucket, key).Body, &manifest); err != nil {
+		t.Fatal(fmt.Sprintf("expected the manifest to be valid JSON: %v", err))
+	}
+	return manifest
+}
+
+func manifestContains(manifest ChunkManifest, hash string) bool {
+	for _, chunk := range manifest.Chunks {
+		if chunk.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+//----------------------------------------------
+// Destination Testing (mock S3)
+//	1: Upload fails over to the secondary destination when the primary always fails
+//	2: The failover destination is not used when the primary succeeds
+//	3: Every primary destination receives the upload
+//	4: Every destination of a primary failing is reported
+//	5: Destinations are parsed with their region and failover marker
+//	6: No more destinations are uploaded to at once than the max destination concurrency
+//	7: Every destination is uploaded to at once without a max destination concurrency
+//	8: A negative max destination concurrency is rejected
+//
+//----------------------------------------------
+
+// Test 1 - Destination Testing
+//	Upload fails over to the secondary destination when the primary always fails
+func TestUploadFailover(t *testing.T) {
+	mockS3 := s3mock.New("primary", "secondary")
+	defer mockS3.Close()
+	failBucket(mockS3, "primary")
+
+	destinations := []Destination{
+		{Bucket: "primary", Region: "us-east-1", Retries: 2, Svc: mockS3.Client()},
+		{Bucket: "secondary", Region: "us-west-2", Failover: true, Svc: mockS3.Client()},
+	}
+
+	results := uploadToDestinations(t, destinations)
+	if len(results) != 1 {
+		t.Fatal(fmt.Sprintf("expected a result for the primary destination, got %d results", len(results)))
+	}
+
+	result := results[0]
+	if result.Err != nil || !result.FailedOver || !result.Uploaded {
+		t.Error(fmt.Sprintf("expected the upload to fail over without any error: %+v", result))
+	}
+	if result.Primary.Bucket != "primary" || result.Destination.Bucket != "secondary" || result.Destination.Region != "us-west-2" {
+		t.Error(fmt.Sprintf("expected the secondary destination to be reported as receiving the upload of the primary: %+v", result))
+	}
+	if mockS3.Object("secondary", result.Key) == nil {
+		t.Error(fmt.Sprintf("expected '%s' to be uploaded to the secondary destination", result.Key))
+	}
+
+	if attempts := len(bucketRequests(mockS3, "PutObject", "primary")); attempts != 3 {
+		t.Error(fmt.Sprintf("expected the primary destination to be tried 3 times but it was tried %d times", attempts))
+	}
+}
+
+// Test 2 - Destination Testing
+//	The failover destination is not used when the primary succeeds
+func TestUploadFailoverUnused(t *testing.T) {
+	mockS3 := s3mock.New("primary", "secondary")
+	defer mockS3.Close()
+
+	destinations := []Destination{
+		{Bucket: "primary", Region: "us-east-1", Retries: 2, Svc: mockS3.Client()},
+		{Bucket: "secondary", Region: "us-west-2", Failover: true, Svc: mockS3.Client()},
+	}
+
+	result := uploadToDestinations(t, destinations)[0]
+	if result.Err != nil || result.FailedOver || result.Destination.Bucket != "primary" {
+		t.Error(fmt.Sprintf("expected the primary destination to receive the upload: %+v", result))
+	}
+	if len(mockS3.Keys("secondary")) != 0 {
+		t.Error("expected nothing to be uploaded to the failover destination")
+	}
+}
+
+// Test 3 - Destination Testing
+//	Every primary destination receives the upload
+func TestUploadMultipleDestinations(t *testing.T) {
+	mockS3 := s3mock.New("primary", "secondary")
+	defer mockS3.Close()
+
+	destinations := []Destination{
+		{Bucket: "primary", Region: "us-east-1", Svc: mockS3.Client()},
+		{Bucket: "secondary", Region: "us-west-2", Svc: mockS3.Client()},
+	}
+
+	results := uploadToDestinations(t, destinations)
+	if len(results) != 2 {
+		t.Fatal(fmt.Sprintf("expected a result for each primary destination, got %d results", len(results)))
+	}
+
+	for i, result := range results {
+		bucket := destinations[i].Bucket
+		if result.Err != nil || result.Destination.Bucket != bucket || mockS3.Object(bucket, result.Key) == nil {
+			t.Error(fmt.Sprintf("expected '%s' to receive the upload: %+v", bucket, result))
+		}
+	}
+}
+
+// Test 4 - Destination Testing
+//	Every destination of the primary fails
+func TestUploadFailoverExhausted(t *testing.T) {
+	expectedErrString := "every destination of primary 'primary' failed"
+
+	mockS3 := s3mock.New("primary", "secondary")
+	defer mockS3.Close()
+	failBucket(mockS3, "primary")
+	failBucket(mockS3, "secondary")
+
+	destinations := []Destination{
+		{Bucket: "primary", Region: "us-east-1", Retries: 1, Svc: mockS3.Client()},
+		{Bucket: "secondary", Region: "us-west-2", Failover: true, Retries: 1, Svc: mockS3.Client()},
+	}
+
+	result := uploadToDestinations(t, destinations)[0]
+	if result.Err != nil && strings.Contains(result.Err.Error(), expectedErrString) && !result.Uploaded {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, result.Err))
+	}
+
+	if attempts := len(mockS3.Requests("PutObject")); attempts != 4 {
+		t.Error(fmt.Sprintf("expected each destination to be tried twice but %d uploads were made", attempts))
+	}
+}
+
+// Test 5 - Destination Testing
+//	Destinations are parsed with their region and failover marker
+func TestParseDestinations(t *testing.T) {
+	destinations, err := ParseDestinations("archive@eu-west-1, failover:dr@us-west-2,failover:local", "us-east-1")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to parse the destinations without any error: %v", err))
+	}
+
+	expected := []Destination{
+		{Bucket: "archive", Region: "eu-west-1"},
+		{Bucket: "dr", Region: "us-west-2", Failover: true},
+		{Bucket: "local", Region: "us-east-1", Failover: true},
+	}
+	if fmt.Sprint(destinations) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected destinations %v but got %v", expected, destinations))
+	}
+
+	for _, invalid := range []string{"bucket@", "@us-east-1", "failover:", "archive,,dr"} {
+		if _, err := ParseDestinations(invalid, "us-east-1"); err == nil {
+			t.Error(fmt.Sprintf("expected destinations '%s' to be invalid", invalid))
+		}
+	}
+
+	expectedErrString := "must follow a primary destination"
+	_, err = UploadToDestinations([]Destination{{Bucket: "dr", Failover: true, Svc: &s3.S3{}}}, testUploadObjectNotManipulated, nil)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Fails every request to the bucket
+// Test 6 - Destination Testing
+//	No more destinations are uploaded to at once than the max destination concurrency
+func TestUploadMaxDestinationConcurrency(t *testing.T) {
+	buckets := []string{"dest1", "dest2", "dest3", "dest4", "dest5"}
+	if peak := peakDestinationConcurrency(t, buckets, 2); peak != 2 {
+		t.Error(fmt.Sprintf("expected at most 2 destinations to upload at once but %d did", peak))
+	}
+}
+
+// Test 7 - Destination Testing
+//	Every destination is uploaded to at once without a max destination concurrency
+func TestUploadUnlimitedDestinationConcurrency(t *testing.T) {
+	buckets := []string{"dest1", "dest2", "dest3", "dest4"}
+	if peak := peakDestinationConcurrency(t, buckets, 0); peak != len(buckets) {
+		t.Error(fmt.Sprintf("expected %d destinations to upload at once but %d did", len(buckets), peak))
+	}
+}
+
+// Test 8 - Destination Testing
+//	Specify a negative max destination concurrency
+func TestUploadNegativeDestinationConcurrency(t *testing.T) {
+	expectedErrString := "the max destination concurrency must not be negative"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.MaxDestinationConcurrency = -1
+
+	_, err := UploadToDestinations([]Destination{{Bucket: mockBucket, Svc: &s3.S3{}}}, testUploadObject,
+		func(svc *s3.S3, uploadObject UploadObject) (string, bool, error) {
+			return "", false, nil
+		})
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+func failBucket(mockS3 *s3mock.Server, bucket string) {
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Bucket == bucket {
+			return &s3mock.Error{StatusCode: 503, Code: "ServiceUnavailable", Message: "mock failure"}
+		}
+		return nil
+	})
+}
+
+func bucketRequests(mockS3 *s3mock.Server, operation string, bucket string) []*s3mock.Request {
+	requests := []*s3mock.Request{}
+	for _, req := range mockS3.Requests(operation) {
+		if req.Bucket == bucket {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+// Uploads the small test file to the destinations without waiting between retries
+func uploadToDestinations(t *testing.T, destinations []Destination) []DestinationResult {
+	destinationRetryDelay = 0
+
+	testUploadObject := testUploadObjectNotManipulated
+	results, err := UploadToDestinations(destinations, testUploadObject, func(svc *s3.S3, uploadObject UploadObject) (string, bool, error) {
+		key, err := UploadFile(svc, uploadObject, "", false)
+		return key, err == nil, err
+	})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected valid destinations: %v", err))
+	}
+	return results
+}
+
+// Uploads the small test file to a destination for each bucket and returns the most destinations which were uploading
+// at once. Each upload is held open so that the uploads overlap
+func peakDestinationConcurrency(t *testing.T, buckets []string, maxConcurrency int) int {
+	mockS3 := s3mock.New(buckets...)
+	defer mockS3.Close()
+
+	var mu sync.Mutex
+	active, peak := 0, 0
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation != "PutObject" {
+			return nil
+		}
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond * 200)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return nil
+	})
+
+	destinations := []Destination{}
+	for _, bucket := range buckets {
+		destinations = append(destinations, Destination{Bucket: bucket, Region: "us-east-1", Svc: mockS3.Client()})
+	}
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.MaxDestinationConcurrency = maxConcurrency
+	results, err := UploadToDestinations(destinations, testUploadObject, func(svc *s3.S3, uploadObject UploadObject) (string, bool, error) {
+		key, err := UploadFile(svc, uploadObject, "", false)
+		return key, err == nil, err
+	})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected valid destinations: %v", err))
+	}
+	for _, result := range results {
+		if !result.Uploaded {
+			t.Error(fmt.Sprintf("expected destination '%s' to receive the upload: %v", result.Primary.Bucket, result.Err))
+		}
+	}
+
+	return peak
+}
+
+//----------------------------------------------
+// Checksum Verification Testing (mock S3)
+//	1: A multipart upload to a bucket encrypted with SSE-KMS is verified with a SHA256 checksum
+//	2: A single part upload to a bucket encrypted with SSE-KMS is verified with a SHA256 checksum
+//	3: A multipart upload is verified with a CRC32C checksum
+//	4: Verification fails when the reported checksum does not match the file
+//	5: Upload fails when the checksum algorithm is invalid
+//
+//----------------------------------------------
+
+// Test 1 - Checksum Verification Testing
+//	A multipart upload to a bucket encrypted with SSE-KMS is verified with a SHA256 checksum
+func TestChecksumVerifySSEKMSMultipart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	mockS3.SetBucketEncryption(mockBucket, s3.ServerSideEncryptionAwsKms)
+
+	key, err := UploadFile(mockS3.Client(), multipartUploadObject(true), "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected verification under SSE-KMS to pass: %v", err))
+	}
+
+	if mockS3.Object(mockBucket, key).Header.Get("X-Amz-Server-Side-Encryption") != s3.ServerSideEncryptionAwsKms {
+		t.Error("expected the object to be encrypted with SSE-KMS")
+	}
+
+	if len(mockS3.Requests("GetObjectAttributes")) != 1 {
+		t.Error("expected the checksum to be verified with GetObjectAttributes")
+	}
+	for _, req := range mockS3.Requests("UploadPart") {
+		if req.Header.Get("X-Amz-Checksum-Sha256") == "" {
+			t.Error(fmt.Sprintf("expected part %s to be uploaded with a SHA256 checksum", req.Query.Get("partNumber")))
+		}
+	}
+}
+
+// Test 2 - Checksum Verification Testing
+//	A single part upload to a bucket encrypted with SSE-KMS is verified with a SHA256 checksum
+func TestChecksumVerifySSEKMSSinglePart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	mockS3.SetBucketEncryption(mockBucket, s3.ServerSideEncryptionAwsKms)
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.StrongVerify = true
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected verification under SSE-KMS to pass: %v", err))
+	}
+
+	puts := mockS3.Requests("PutObject")
+	if len(puts) != 1 || puts[0].Header.Get("X-Amz-Checksum-Sha256") == "" {
+		t.Error("expected the file to be uploaded with a SHA256 checksum")
+	}
+	if len(mockS3.Requests("GetObjectAttributes")) != 1 {
+		t.Error("expected the checksum to be verified with GetObjectAttributes")
+	}
+}
+
+// Test 3 - Checksum Verification Testing
+//	A multipart upload is verified with a CRC32C checksum
+func TestChecksumVerifyCRC32C(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.ChecksumAlgorithm = ChecksumAlgorithmCRC32C
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected CRC32C verification to pass: %v", err))
+	}
+
+	if !strings.HasSuffix(mockS3.Object(mockBucket, key).Header.Get("X-Amz-Checksum-Crc32c"), "-3") {
+		t.Error("expected the object to have a CRC32C checksum of its 3 parts")
+	}
+	if len(mockS3.Requests("GetBucketEncryption")) != 0 {
+		t.Error("expected the default encryption of the bucket not to be checked when the algorithm is specified")
+	}
+}
+
+// Test 4 - Checksum Verification Testing
+//	Replace the checksum reported for the uploaded object
+func TestChecksumVerifyMismatch(t *testing.T) {
+	expectedErrString := "does not match the checksum of the file"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "GetObjectAttributes" {
+			mockS3.SetObjectHeader(mockBucket, req.Key, "X-Amz-Checksum-Sha256", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=-3")
+		}
+		return nil
+	})
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.ChecksumAlgorithm = ChecksumAlgorithmSHA256
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 5 - Checksum Verification Testing
+//	Specify an unsupported checksum algorithm
+func TestChecksumVerifyInvalidAlgorithm(t *testing.T) {
+	expectedErrString := "invalid checksum algorithm 'MD5'"
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.ChecksumAlgorithm = "MD5"
+
+	_, err := UploadFile(svc, testUploadObject, "", true)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+// Ledger Testing (mock S3)
+//	1: A re-run after a directory upload in which a file failed uploads only the file which failed
+//	2: A file which changed since it was recorded in the ledger is uploaded again
+//	3: A truncated ledger entry is skipped and its file uploaded again
+//	4: A ledger entry of another bucket does not skip the upload
+//	5: Upload fails when a ledger is specified with a single file
+//
+//----------------------------------------------
+
+// Test 1 - Ledger Testing
+//	A re-run after a directory upload in which a file failed uploads only the file which failed
+func TestLedgerResumeInterruptedUpload(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	interrupted := true
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if interrupted && req.Operation == "PutObject" && req.Key == "ledgerTestDir/nested/c.txt" {
+			return &s3mock.Error{StatusCode: 503, Code: "ServiceUnavailable", Message: "mock failure"}
+		}
+		return nil
+	})
+
+	testUploadObject := ledgerUploadObject(t)
+	_, err := UploadDir(mockS3.Client(), testUploadObject, false)
+	dirErr, ok := err.(*DirUploadError)
+	if !ok || len(dirErr.Failed) != 1 || dirErr.Failed[filepath.Join(testUploadObject.PathToFile, "nested", "c.txt")] == nil {
+		t.Fatal(fmt.Sprintf("expected the directory upload to fail naming only nested/c.txt but got: %v", err))
+	}
+	interrupted = false
+
+	uploadedBefore := len(mockS3.Requests("PutObject"))
+	results, err := UploadDir(mockS3.Client(), testUploadObject, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to resume the directory upload without any error: %v", err))
+	}
+
+	uploaded := []string{}
+	for _, req := range mockS3.Requests("PutObject")[uploadedBefore:] {
+		uploaded = append(uploaded, req.Key)
+	}
+	if fmt.Sprint(uploaded) != "[ledgerTestDir/nested/c.txt]" {
+		t.Error(fmt.Sprintf("expected only the file which failed to be uploaded but got: %v", uploaded))
+	}
+	if len(results) != 5 {
+		t.Error(fmt.Sprintf("expected a result for each of the 5 files but got: %d", len(results)))
+	}
+	if len(mockS3.Requests("HeadObject")) != 0 || len(mockS3.Requests("ListObjectsV2")) != 0 {
+		t.Error("expected the files recorded in the ledger to be skipped without any request to S3")
+	}
+	if len(mockS3.Keys(mockBucket)) != 5 {
+		t.Error(fmt.Sprintf("expected every file to be uploaded but got: %v", mockS3.Keys(mockBucket)))
+	}
+}
+
+// Test 2 - Ledger Testing
+//	A file which changed since it was recorded in the ledger is uploaded again
+func TestLedgerChangedFileUploaded(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := ledgerUploadObject(t)
+	if _, err := UploadDir(mockS3.Client(), testUploadObject, false); err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the directory without any error: %v", err))
+	}
+
+	ioutil.WriteFile(filepath.Join(testUploadObject.PathToFile, "b.txt"), []byte("the contents of b have changed"), 0644)
+
+	assertLedgerRerunUploads(t, mockS3, testUploadObject, mockBucket, []string{"ledgerTestDir/b.txt"})
+	if string(mockS3.Object(mockBucket, "ledgerTestDir/b.txt").Body) != "the contents of b have changed" {
+		t.Error("expected the changed file to replace the uploaded file")
+	}
+}
+
+// Test 3 - Ledger Testing
+//	A truncated ledger entry is skipped and its file uploaded again
+func TestLedgerTruncatedEntry(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := ledgerUploadObject(t)
+	if _, err := UploadDir(mockS3.Client(), testUploadObject, false); err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the directory without any error: %v", err))
+	}
+
+	// Cut the last entry short as if the run was killed while writing it
+	contents, _ := ioutil.ReadFile(testUploadObject.Ledger)
+	ioutil.WriteFile(testUploadObject.Ledger, contents[:len(contents)-20], 0644)
+
+	assertLedgerRerunUploads(t, mockS3, testUploadObject, mockBucket, []string{"ledgerTestDir/nested/d.txt"})
+}
+
+// Test 4 - Ledger Testing
+//	A ledger entry of another bucket does not skip the upload
+func TestLedgerOtherBucket(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket, "otherbucket")
+	defer mockS3.Close()
+
+	testUploadObject := ledgerUploadObject(t)
+	if _, err := UploadDir(mockS3.Client(), testUploadObject, false); err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the directory without any error: %v", err))
+	}
+
+	testUploadObject.Bucket = "otherbucket"
+	assertLedgerRerunUploads(t, mockS3, testUploadObject, "otherbucket", []string{"ledgerTestDir/a.txt", "ledgerTestDir/b.txt",
+		"ledgerTestDir/e.txt", "ledgerTestDir/nested/c.txt", "ledgerTestDir/nested/d.txt"})
+}
+
+// Test 5 - Ledger Testing
+//	Specify a ledger when uploading a single file
+func TestLedgerSingleFile(t *testing.T) {
+	expectedErrString := "a ledger is only supported when uploading the files of a directory individually"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Ledger = filepath.Join(t.TempDir(), "upload.ledger")
+
+	_, err := UploadFile(svc, testUploadObject, "", true)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Uploads the directory again and asserts that only the expected keys were uploaded to the bucket
+func assertLedgerRerunUploads(t *testing.T, mockS3 *s3mock.Server, uploadObject UploadObject, bucket string, expectedKeys []string) {
+	uploadedBefore := len(bucketRequests(mockS3, "PutObject", bucket))
+	if _, err := UploadDir(mockS3.Client(), uploadObject, false); err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the directory again without any error: %v", err))
+	}
+
+	// Files are uploaded concurrently so they are compared in sorted order
+	uploaded := []string{}
+	for _, req := range bucketRequests(mockS3, "PutObject", bucket)[uploadedBefore:] {
+		uploaded = append(uploaded, req.Key)
+	}
+	sort.Strings(uploaded)
+	if fmt.Sprint(uploaded) != fmt.Sprint(expectedKeys) {
+		t.Error(fmt.Sprintf("expected only %v to be uploaded but got: %v", expectedKeys, uploaded))
+	}
+}
+
+// Returns an upload object for a directory of 5 files created in a temporary directory with a ledger beside it
+func ledgerUploadObject(t *testing.T) UploadObject {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "files", "nested"), 0755)
+	for _, name := range []string{"a.txt", "b.txt", "e.txt", filepath.Join("nested", "c.txt"), filepath.Join("nested", "d.txt")} {
+		ioutil.WriteFile(filepath.Join(dir, "files", name), []byte("the contents of "+name), 0644)
+	}
+
+	return UploadObject{
+		PathToFile: filepath.Join(dir, "files"),
+		S3FileName: "ledgerTestDir",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 3,
+		PartSize:   5,
+		Ledger:     filepath.Join(dir, "upload.ledger"),
+	}
+}
+
+//----------------------------------------------
+// On Timeout Testing (mock S3)
+//	1: A multipart upload which times out with preserve is not aborted and its state is written to the resume state file
+//	2: A multipart upload which times out with abort is aborted and no resume state is written
+//	3: A multipart upload which fails without timing out is aborted even with preserve
+//	4: Upload fails when preserve is specified without a resume state file
+//	5: Upload fails when the on timeout behaviour is invalid
+//
+//----------------------------------------------
+
+// Test 1 - On Timeout Testing
+//	A multipart upload which times out with preserve is not aborted and its state is written to the resume state file
+func TestOnTimeoutPreserve(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := timeoutUploadObject(t, mockS3, OnTimeoutPreserve)
+	if _, err := UploadFile(mockS3.Client(), testUploadObject, "", false); err == nil {
+		t.Fatal("expected the upload to time out")
+	}
+
+	if len(mockS3.Requests("AbortMultipartUpload")) != 0 || mockS3.MultipartUploads() != 1 {
+		t.Error("expected the multipart upload which timed out not to be aborted")
+	}
+
+	state, err := LoadResumeState(testUploadObject.ResumeStateFile)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the resume state file to be written: %v", err))
+	}
+
+	uploadID := mockS3.Requests("UploadPart")[0].Query.Get("uploadId")
+	if state.UploadID != uploadID || state.Bucket != mockBucket || state.Key != "multipartTestFile" ||
+		state.Bytes != multipartFileSize || state.PartSize != 5*1024*1024 || state.PathToFile != pathToMultipartFile {
+		t.Error(fmt.Sprintf("expected the resume state to record the multipart upload '%s': %+v", uploadID, state))
+	}
+	if len(state.Parts) == 0 || state.Parts[0].PartNumber != 1 || state.Parts[0].Size != 5*1024*1024 || state.Parts[0].ETag == "" {
+		t.Error(fmt.Sprintf("expected the resume state to record the first part which was uploaded: %+v", state.Parts))
+	}
+}
+
+// Test 2 - On Timeout Testing
+//	A multipart upload which times out with abort is aborted and no resume state is written
+func TestOnTimeoutAbort(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := timeoutUploadObject(t, mockS3, OnTimeoutAbort)
+	if _, err := UploadFile(mockS3.Client(), testUploadObject, "", false); err == nil {
+		t.Fatal("expected the upload to time out")
+	}
+
+	if len(mockS3.Requests("AbortMultipartUpload")) != 1 || mockS3.MultipartUploads() != 0 {
+		t.Error("expected the multipart upload which timed out to be aborted")
+	}
+	if _, err := os.Stat(testUploadObject.ResumeStateFile); !os.IsNotExist(err) {
+		t.Error("expected no resume state file to be written when aborting")
+	}
+}
+
+// Test 3 - On Timeout Testing
+//	A multipart upload which fails without timing out is aborted even with preserve
+func TestOnTimeoutPreserveFailure(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	mockS3.FailOperation("UploadPart", 500, "InternalError")
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.OnTimeout = OnTimeoutPreserve
+	testUploadObject.ResumeStateFile = filepath.Join(t.TempDir(), "upload.resume.json")
+
+	if _, err := UploadFile(mockS3.Client(), testUploadObject, "", false); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+
+	if mockS3.MultipartUploads() != 0 {
+		t.Error("expected the multipart upload which failed to be aborted")
+	}
+	if _, err := os.Stat(testUploadObject.ResumeStateFile); !os.IsNotExist(err) {
+		t.Error("expected no resume state file to be written for an upload which did not time out")
+	}
+}
+
+// Test 4 - On Timeout Testing
+//	Specify preserve without a resume state file
+func TestOnTimeoutPreserveWithoutStateFile(t *testing.T) {
+	expectedErrString := "a resume state file must be specified to preserve the parts of an upload which times out"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.OnTimeout = OnTimeoutPreserve
+
+	_, err := UploadFile(svc, testUploadObject, "", true)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 5 - On Timeout Testing
+//	Specify an invalid on timeout behaviour
+func TestOnTimeoutInvalid(t *testing.T) {
+	expectedErrString := "on timeout must be either 'abort' or 'preserve'"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.OnTimeout = "resume"
+
+	_, err := UploadFile(svc, testUploadObject, "", true)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns an upload object for the multipart test file which times out after the first part has been uploaded
+func timeoutUploadObject(t *testing.T, mockS3 *s3mock.Server, onTimeout string) UploadObject {
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "UploadPart" && req.Query.Get("partNumber") != "1" {
+			time.Sleep(time.Second * 2)
+		}
+		return nil
+	})
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.NumWorkers = 1
+	testUploadObject.Timeout = time.Second
+	testUploadObject.OnTimeout = onTimeout
+	testUploadObject.ResumeStateFile = filepath.Join(t.TempDir(), "upload.resume.json")
+	return testUploadObject
+}
+
+//----------------------------------------------
+// Phase Timeout Testing (mock S3)
+//	1: A verification which exceeds the verify timeout fails the upload but the uploaded object is kept
+//	2: A hash which exceeds the hash timeout fails before anything is uploaded
+//	3: A slow check of whether the file changed does not count towards the timeout of the upload
+//	4: Upload fails when the hash timeout is negative
+//
+//----------------------------------------------
+
+// Test 1 - Phase Timeout Testing
+//	Verify the checksum of the uploaded object with GetObjectAttributes taking longer than the verify timeout
+func TestPhaseTimeoutVerify(t *testing.T) {
+	expectedErrString := "verify phase exceeded its timeout of 500ms"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "GetObjectAttributes" {
+			time.Sleep(time.Second)
+		}
+		return nil
+	})
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.ChecksumAlgorithm = ChecksumAlgorithmSHA256
+	testUploadObject.Timeout = time.Minute
+	testUploadObject.VerifyTimeout = time.Millisecond * 500
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if mockS3.Object(mockBucket, "multipartTestFile") == nil {
+		t.Error("expected the completed upload to be kept when its verification times out")
+	}
+	if len(mockS3.Requests("AbortMultipartUpload")) != 0 {
+		t.Error("expected the completed upload not to be aborted")
+	}
+}
+
+// Test 2 - Phase Timeout Testing
+//	Hash the file for skip if unchanged with a hash timeout which has already elapsed
+func TestPhaseTimeoutHash(t *testing.T) {
+	expectedErrString := "hash phase exceeded its timeout"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.SkipIfUnchanged = true
+	testUploadObject.Timeout = time.Minute
+	testUploadObject.HashTimeout = time.Nanosecond
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if len(mockS3.Requests("CreateMultipartUpload")) != 0 || len(mockS3.Requests("PutObject")) != 0 {
+		t.Error("expected nothing to be uploaded once hashing timed out")
+	}
+}
+
+// Test 3 - Phase Timeout Testing
+//	Check whether the file changed with a listing which takes longer than the timeout of the upload
+func TestPhaseTimeoutUploadStartsAfterHash(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "ListObjects" {
+			time.Sleep(time.Millisecond * 1500)
+		}
+		return nil
+	})
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.SkipIfUnchanged = true
+	testUploadObject.Timeout = time.Second
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the upload to have its own timeout once the file was hashed: %v", err))
+	}
+	if mockS3.Object(mockBucket, key) == nil {
+		t.Error(fmt.Sprintf("expected key: '%s' to be uploaded", key))
+	}
+}
+
+// Test 4 - Phase Timeout Testing
+//	Specify a negative hash timeout
+func TestPhaseTimeoutNegative(t *testing.T) {
+	expectedErrString := "hash timeout and verify timeout must not be less than 0"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.HashTimeout = -time.Second
+
+	_, err := UploadFile(svc, testUploadObject, "", true)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+// Resume Testing (mock S3)
+//	1: A failed upload keeps its parts and is resumed from the resume state file by uploading only the missing parts
+//	2: A failed upload without a resume state file is found by its key and resumed
+//	3: An interrupted upload of a file which changed since is aborted and the file uploaded from the start
+//	4: Upload fails when resume is specified with compression
+//	5: A part whose bytes changed without the size or modification time of the file changing is uploaded again
+//
+//----------------------------------------------
+
+// Test 1 - Resume Testing
+//	A failed upload keeps its parts and is resumed from the resume state file by uploading only the missing parts
+func TestResumeFromStateFile(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := resumeUploadObject(pathToMultipartFile)
+	testUploadObject.ResumeStateFile = filepath.Join(t.TempDir(), "upload.resume.json")
+	interruptUpload(t, mockS3, testUploadObject)
+
+	state, err := LoadResumeState(testUploadObject.ResumeStateFile)
+	if err != nil || len(state.Parts) != 2 || state.ModTime.IsZero() {
+		t.Fatal(fmt.Sprintf("expected the resume state file to record the 2 uploaded parts and the modification time of the file: %+v %v", state, err))
+	}
+
+	testUploadObject.VerifyChecksum = true
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the upload to be resumed without any error: %v", err))
+	}
+
+	assertResumed(t, mockS3, key, pathToMultipartFile)
+	if _, err := os.Stat(testUploadObject.ResumeStateFile); !os.IsNotExist(err) {
+		t.Error("expected the resume state file to be removed once the upload completed")
+	}
+}
+
+// Test 2 - Resume Testing
+//	A failed upload without a resume state file is found by its key and resumed
+func TestResumeByKey(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := resumeUploadObject(pathToMultipartFile)
+	interruptUpload(t, mockS3, testUploadObject)
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the upload to be resumed without any error: %v", err))
+	}
+
+	assertResumed(t, mockS3, key, pathToMultipartFile)
+}
+
+// Test 3 - Resume Testing
+//	Modify the file after its upload was interrupted
+func TestResumeFileChanged(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	pathToFile := filepath.Join(t.TempDir(), "multipartTestFile")
+	if err := util.CreateFile(pathToFile, []byte(strings.Repeat("0123456789abcdef", int(multipartFileSize/16)))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, withStateFile := range []bool{true, false} {
+		testUploadObject := resumeUploadObject(pathToFile)
+		if withStateFile {
+			testUploadObject.ResumeStateFile = filepath.Join(t.TempDir(), "upload.resume.json")
+		}
+		interruptUpload(t, mockS3, testUploadObject)
+
+		modified := time.Now().Add(time.Hour)
+		if err := os.Chtimes(pathToFile, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+
+		aborts := len(mockS3.Requests("AbortMultipartUpload"))
+		creates := len(mockS3.Requests("CreateMultipartUpload"))
+		if _, err := UploadFile(mockS3.Client(), testUploadObject, "", false); err != nil {
+			t.Fatal(fmt.Sprintf("expected the file to be uploaded from the start without any error: %v", err))
+		}
+
+		if len(mockS3.Requests("AbortMultipartUpload")) != aborts+1 || len(mockS3.Requests("CreateMultipartUpload")) != creates+1 {
+			t.Error(fmt.Sprintf("expected the interrupted upload to be aborted and a new upload started (resume state file: %t)", withStateFile))
+		}
+		if mockS3.MultipartUploads() != 0 {
+			t.Error("expected no multipart upload to be left in progress")
+		}
+	}
+}
+
+// Test 4 - Resume Testing
+//	Specify resume with compression
+func TestResumeWithCompression(t *testing.T) {
+	expectedErrString := "resume is not supported with compression, strong verify or a checksum algorithm"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Resume = true
+	testUploadObject.Compression = "gzip"
+
+	_, err := UploadFile(svc, testUploadObject, "", true)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 5 - Resume Testing
+//	Change the bytes of the first part of the file after its upload was interrupted and restore its modification time
+func TestResumePartChecksumChanged(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	contents := []byte(strings.Repeat("0123456789abcdef", int(multipartFileSize/16)))
+	pathToFile := filepath.Join(t.TempDir(), "multipartTestFile")
+	if err := util.CreateFile(pathToFile, contents); err != nil {
+		t.Fatal(err)
+	}
+
+	testUploadObject := resumeUploadObject(pathToFile)
+	testUploadObject.ResumeStateFile = filepath.Join(t.TempDir(), "upload.resume.json")
+	interruptUpload(t, mockS3, testUploadObject)
+
+	state, err := LoadResumeState(testUploadObject.ResumeStateFile)
+	if err != nil || len(state.Parts) != 2 {
+		t.Fatal(fmt.Sprintf("expected the resume state file to record the 2 uploaded parts: %+v %v", state, err))
+	}
+	for _, part := range state.Parts {
+		offset := (part.PartNumber - 1) * 5 * 1024 * 1024
+		if part.Offset != offset || part.MD5 != fmt.Sprintf("%x", md5.Sum(contents[offset:offset+part.Size])) {
+			t.Error(fmt.Sprintf("expected the resume state to record the offset and md5sum of part %d: %+v", part.PartNumber, part))
+		}
+	}
+
+	copy(contents[1024:], "changed without changing the size")
+	if err := ioutil.WriteFile(pathToFile, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(pathToFile, state.ModTime, state.ModTime); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadParts := len(mockS3.Requests("UploadPart"))
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the upload to be resumed without any error: %v", err))
+	}
+
+	if len(mockS3.Requests("CreateMultipartUpload")) != 1 || len(mockS3.Requests("AbortMultipartUpload")) != 0 {
+		t.Error("expected the interrupted upload to be resumed rather than started again")
+	}
+	resumedParts := []string{}
+	for _, req := range mockS3.Requests("UploadPart")[uploadParts:] {
+		resumedParts = append(resumedParts, req.Query.Get("partNumber"))
+	}
+	if strings.Join(resumedParts, ",") != "1,3" {
+		t.Error(fmt.Sprintf("expected the changed first part and the missing third part to be uploaded but got parts %v", resumedParts))
+	}
+	if obj := mockS3.Object(mockBucket, key); obj == nil || !bytes.Equal(obj.Body, contents) {
+		t.Error(fmt.Sprintf("expected key '%s' to be assembled from the changed contents of the file", key))
+	}
+}
+
+// Returns a resumable upload object for the file of the size of the multipart test file uploaded with 3 parts
+func resumeUploadObject(pathToFile string) UploadObject {
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.PathToFile = pathToFile
+	testUploadObject.NumWorkers = 1
+	testUploadObject.Resume = true
+	return testUploadObject
+}
+
+// Uploads the file with the third part failing once so that the upload fails with the first 2 parts uploaded
+func interruptUpload(t *testing.T, mockS3 *s3mock.Server, uploadObject UploadObject) {
+	t.Helper()
+
+	failed := false
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "UploadPart" && req.Query.Get("partNumber") == "3" && !failed {
+			failed = true
+			return &s3mock.Error{StatusCode: 500, Code: "InternalError", Message: "connection reset"}
+		}
+		return nil
+	})
+
+	aborts := len(mockS3.Requests("AbortMultipartUpload"))
+	if _, err := UploadFile(mockS3.Client(), uploadObject, "", false); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if len(mockS3.Requests("AbortMultipartUpload")) != aborts || mockS3.MultipartUploads() != 1 {
+		t.Fatal("expected the parts of the failed upload to be kept")
+	}
+}
+
+// Fails the test unless the interrupted upload was completed by uploading only its third part
+func assertResumed(t *testing.T, mockS3 *s3mock.Server, key string, pathToFile string) {
+	t.Helper()
+
+	if len(mockS3.Requests("CreateMultipartUpload")) != 1 || len(mockS3.Requests("AbortMultipartUpload")) != 0 {
+		t.Error("expected the interrupted upload to be resumed rather than started again")
+	}
+
+	uploadParts := mockS3.Requests("UploadPart")
+	if len(uploadParts) != 4 || uploadParts[3].Query.Get("partNumber") != "3" { // Parts 1 and 2, the failed part 3 and then part 3 again
+		t.Error(fmt.Sprintf("expected only the missing part to be uploaded when resuming but %d parts were uploaded", len(uploadParts)))
+	}
+
+	contents, _ := ioutil.ReadFile(pathToFile)
+	obj := mockS3.Object(mockBucket, key)
+	if obj == nil || !bytes.Equal(obj.Body, contents) || len(obj.Parts) != 3 {
+		t.Error(fmt.Sprintf("expected key '%s' to be assembled from the 3 parts of the file", key))
+	}
+}
+
+//----------------------------------------------
+// ETag Testing (mock S3)
+//	1: The ETag computed for the multipart test file matches the ETag of the uploaded object
+//	2: The ETag computed for a file no larger than a part matches the ETag of the object uploaded with a single PUT
+//	3: Computing the ETag fails for a directory
+//
+//----------------------------------------------
+
+// Test 1 - ETag Testing
+//	The ETag computed for the multipart test file matches the ETag of the uploaded object
+func TestComputeETagMultipart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	key, err := UploadFile(mockS3.Client(), multipartUploadObject(false), "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the multipart test file without any error: %v", err))
+	}
+
+	etag, err := ComputeETag(pathToMultipartFile, 5*1024*1024)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to compute the ETag without any error: %v", err))
+	}
+
+	if !etag.Multipart || len(etag.PartMD5s) != 3 || etag.ETag != strings.Trim(mockS3.Object(mockBucket, key).ETag, "\"") {
+		t.Error(fmt.Sprintf("expected the computed ETag '%s' to match the ETag of the object '%s'", etag.ETag, mockS3.Object(mockBucket, key).ETag))
+	}
+	for i, part := range mockS3.Object(mockBucket, key).Parts {
+		if strings.Trim(part.ETag, "\"") != etag.PartMD5s[i] {
+			t.Error(fmt.Sprintf("expected the md5sum of part %d to match its ETag '%s'", part.PartNumber, part.ETag))
+		}
+	}
+}
+
+// Test 2 - ETag Testing
+//	The ETag computed for a file no larger than a part matches the ETag of the object uploaded with a single PUT
+func TestComputeETagSinglePart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	// The 12MiB multipart test file is a single part with a part size of 12MiB
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.PartSize = 12
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mockS3.Requests("PutObject")) != 1 {
+		t.Fatal("expected the file to be uploaded with a single PUT")
+	}
+
+	etag, err := ComputeETag(pathToMultipartFile, multipartFileSize)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to compute the ETag without any error: %v", err))
+	}
+
+	if etag.Multipart || etag.ETag != strings.Trim(mockS3.Object(mockBucket, key).ETag, "\"") {
+		t.Error(fmt.Sprintf("expected the ETag of a single part to be the md5sum of the file but got '%s'", etag.ETag))
+	}
+}
+
+// Test 3 - ETag Testing
+//	Compute the ETag of a directory
+func TestComputeETagDirectory(t *testing.T) {
+	expectedErrString := "is not a regular file"
+
+	_, err := ComputeETag(t.TempDir(), 5*1024*1024)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+//----------------------------------------------
+// Tag Template Testing (mock S3)
+//	1: The tokens of tag values are rendered with the date, host and tier of the upload
+//	2: Upload fails when a rendered tag value exceeds the S3 limit
+//	3: Upload fails with an unknown token in a tag value
+//	4: Upload fails with a tag key using the reserved aws: prefix
+//	5: Tags with spaces and the characters + = / are URL encoded and applied intact
+//	6: Upload fails with more than 10 tags before any request is made
+//
+//----------------------------------------------
+
+// Test 1 - Tag Template Testing
+//	The tokens of tag values are rendered with the date, host and tier of the upload
+func TestUploadTagTemplates(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	pathToFile := filepath.Join(t.TempDir(), "tagged")
+	if err := util.CreateFile(pathToFile, []byte("this is just a little test file")); err != nil {
+		t.Fatal(err)
+	}
+	fileTime := time.Date(2024, time.January, 29, 2, 0, 0, 0, time.Local)
+	os.Chtimes(pathToFile, fileTime, fileTime)
+
+	testUploadObject := testUploadObjectManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.PathToFile = pathToFile
+	testUploadObject.TimeSource = TimeSourceFileMtime
+	testUploadObject.Tags = map[string]string{"app": "s3backup", "host": "{host}", "tier": "{tier}", "backup": "{tier} backup of {date}"}
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "weekly_", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+
+	host, _ := os.Hostname()
+	expected := map[string]string{"app": "s3backup", "host": host, "tier": "weekly", "backup": "weekly backup of 2024-01-29"}
+	if fmt.Sprint(mockS3.Object(mockBucket, key).Tags) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected tags %v but got %v", expected, mockS3.Object(mockBucket, key).Tags))
+	}
+
+	if testUploadObject.Tags["tier"] != "{tier}" {
+		t.Error("expected the tag templates of the upload object not to be modified")
+	}
+}
+
+// Test 2 - Tag Template Testing
+//	Upload fails when a rendered tag value exceeds the S3 limit
+func TestUploadTagTemplateTooLong(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	expectedErrString := "expected at most 256 characters but got 260"
+
+	// The value is within the limit until the date is rendered
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.Tags = map[string]string{"backup": strings.Repeat("a", 250) + "{date}"}
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if len(mockS3.Keys(mockBucket)) != 0 {
+		t.Error("expected nothing to be uploaded with an invalid tag")
+	}
+}
+
+// Test 3 - Tag Template Testing
+//	Upload fails with an unknown token in a tag value
+func TestUploadTagTemplateUnknownToken(t *testing.T) {
+	expectedErrString := "unknown token '{user}' in the value of tag 'owner'"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Tags = map[string]string{"owner": "{user}@{host}"}
+
+	_, err := UploadFile(svc, testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 4 - Tag Template Testing
+//	Upload fails with a tag key using the reserved aws: prefix
+func TestUploadTagReservedKey(t *testing.T) {
+	expectedErrString := "invalid tag key 'aws:createdBy', the aws: prefix is reserved"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Tags = map[string]string{"aws:createdBy": "{host}"}
+
+	_, err := UploadFile(svc, testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 5 - Tag Template Testing
+//	Tags with spaces and the characters + = / are URL encoded and applied intact
+func TestUploadTagEncoded(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.Tags = map[string]string{"cost center": "photo+video", "path": "/var/tmp/a=b"}
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+
+	if fmt.Sprint(mockS3.Object(mockBucket, key).Tags) != fmt.Sprint(testUploadObject.Tags) {
+		t.Error(fmt.Sprintf("expected tags %v but got %v", testUploadObject.Tags, mockS3.Object(mockBucket, key).Tags))
+	}
+}
+
+// Test 6 - Tag Template Testing
+//	Upload fails with 11 tags before any request is made
+func TestUploadTooManyTags(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	expectedErrString := "S3 allows at most 10 tags on an object but got 11"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.Tags = map[string]string{}
+	for i := 0; i < 11; i++ {
+		testUploadObject.Tags[fmt.Sprintf("tag%d", i)] = "value"
+	}
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if len(mockS3.Requests("PutObject")) != 0 {
+		t.Error("expected no request to be made with too many tags")
+	}
+}
+
+//----------------------------------------------
+// Server Side Encryption Testing (mock S3)
+//	1: Single part and multipart uploads are encrypted with SSE-KMS and the KMS key and verified with a SHA256 checksum
+//	2: An upload encrypted with SSE-S3 to a bucket encrypted with SSE-KMS by default is verified with the ETag
+//	3: Upload fails with an invalid server side encryption before any request is made
+//	4: Upload fails with a KMS key ID without SSE-KMS
+//	5: An upload which fails as the KMS key is disabled falls back to SSE-S3 when allowed
+//	6: An upload which fails as the KMS key is disabled fails when falling back is not allowed
+//	7: An upload which fails for any other reason does not fall back to SSE-S3
+//
+//----------------------------------------------
+
+// Test 1 - Server Side Encryption Testing
+//	Upload the test file with a single part upload and the multipart test file with a multipart upload
+func TestUploadSSEKMS(t *testing.T) {
+	keyID := "arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	singlePartObject := testUploadObjectNotManipulated
+	singlePartObject.Bucket = mockBucket
+	singlePartObject.StrongVerify = true
+
+	for _, testUploadObject := range []UploadObject{singlePartObject, multipartUploadObject(true)} {
+		testUploadObject.ServerSideEncryption = s3.ServerSideEncryptionAwsKms
+		testUploadObject.KMSKeyID = keyID
+
+		key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to upload '%s' with SSE-KMS without any error: %v", testUploadObject.PathToFile, err))
+		}
+
+		header := mockS3.Object(mockBucket, key).Header
+		if header.Get("X-Amz-Server-Side-Encryption") != s3.ServerSideEncryptionAwsKms ||
+			header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id") != keyID {
+			t.Error(fmt.Sprintf("expected key '%s' to be encrypted with the KMS key: %v", key, header))
+		}
+	}
+
+	if len(mockS3.Requests("PutObject")) != 1 || len(mockS3.Requests("CreateMultipartUpload")) != 1 {
+		t.Fatal("expected a single part upload and a multipart upload")
+	}
+	if len(mockS3.Requests("GetObjectAttributes")) != 2 || len(mockS3.Requests("GetBucketEncryption")) != 0 {
+		t.Error("expected both uploads to be verified with a checksum without checking the default encryption of the bucket")
+	}
+}
+
+// Test 2 - Server Side Encryption Testing
+//	Upload the multipart test file with SSE-S3 to a bucket encrypted with SSE-KMS by default
+func TestUploadSSES3OverridesBucketDefault(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	mockS3.SetBucketEncryption(mockBucket, s3.ServerSideEncryptionAwsKms)
+
+	testUploadObject := multipartUploadObject(true)
+	testUploadObject.ServerSideEncryption = s3.ServerSideEncryptionAes256
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the ETag of an object encrypted with SSE-S3 to be verified: %v", err))
+	}
+
+	if encryption := mockS3.Object(mockBucket, key).Header.Get("X-Amz-Server-Side-Encryption"); encryption != s3.ServerSideEncryptionAes256 {
+		t.Error(fmt.Sprintf("expected the object to be encrypted with SSE-S3 but got: '%s'", encryption))
+	}
+	if len(mockS3.Requests("GetObjectAttributes")) != 0 {
+		t.Error("expected the upload to be verified with the ETag rather than a checksum")
+	}
+}
+
+// Test 3 - Server Side Encryption Testing
+//	Specify an unsupported server side encryption
+func TestUploadInvalidSSE(t *testing.T) {
+	expectedErrString := "invalid server side encryption 'aws:kms:dsse', expected one of: AES256, aws:kms"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	requests := 0
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		requests++
+		return nil
+	})
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.ServerSideEncryption = "aws:kms:dsse"
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if requests != 0 {
+		t.Error(fmt.Sprintf("expected the upload to fail before any request but %d were made", requests))
+	}
+}
+
+// Test 4 - Server Side Encryption Testing
+//	Specify a KMS key ID with SSE-S3
+func TestUploadKMSKeyIDWithoutSSEKMS(t *testing.T) {
+	expectedErrString := "a KMS key ID requires server side encryption aws:kms"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.ServerSideEncryption = s3.ServerSideEncryptionAes256
+	testUploadObject.KMSKeyID = "alias/backups"
+
+	_, err := UploadFile(svc, testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 5 - Server Side Encryption Testing
+//	Upload the test file with a single part upload and the multipart test file with a multipart upload
+func TestUploadSSEFallback(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	failKMSUploads(mockS3, "KMS.DisabledException")
+
+	resultsFile := filepath.Join(t.TempDir(), "results.ndjson")
+
+	singlePartObject := testUploadObjectNotManipulated
+	singlePartObject.Bucket = mockBucket
+
+	for _, testUploadObject := range []UploadObject{singlePartObject, multipartUploadObject(true)} {
+		testUploadObject.ServerSideEncryption = s3.ServerSideEncryptionAwsKms
+		testUploadObject.KMSKeyID = "alias/backups"
+		testUploadObject.AllowSSEFallback = true
+		testUploadObject.ResultsFile = resultsFile
+
+		key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected '%s' to be uploaded with SSE-S3 once the KMS key failed: %v", testUploadObject.PathToFile, err))
+		}
+
+		header := mockS3.Object(mockBucket, key).Header
+		if header.Get("X-Amz-Server-Side-Encryption") != s3.ServerSideEncryptionAes256 || header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id") != "" {
+			t.Error(fmt.Sprintf("expected key '%s' to be encrypted with SSE-S3: %v", key, header))
+		}
+	}
+
+	contents, _ := ioutil.ReadFile(resultsFile)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if len(lines) != 2 || strings.Contains(string(contents), ResultStatusFailed) {
+		t.Error(fmt.Sprintf("expected only the uploads which fell back to be recorded: %s", contents))
+	}
+}
+
+// Test 6 - Server Side Encryption Testing
+//	Upload with SSE-KMS while the KMS key is disabled without allowing a fall back
+func TestUploadSSEFallbackDisabled(t *testing.T) {
+	expectedErrString := "KMS.DisabledException"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	failKMSUploads(mockS3, "KMS.DisabledException")
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.ServerSideEncryption = s3.ServerSideEncryptionAwsKms
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if len(mockS3.Requests("CreateMultipartUpload")) != 1 || len(mockS3.Keys(mockBucket)) != 0 {
+		t.Error("expected the upload to fail without being uploaded again")
+	}
+}
+
+// Test 7 - Server Side Encryption Testing
+//	Upload with SSE-KMS while access to the bucket is denied
+func TestUploadSSEFallbackOtherError(t *testing.T) {
+	expectedErrString := "AccessDenied"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	failKMSUploads(mockS3, "AccessDenied")
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.ServerSideEncryption = s3.ServerSideEncryptionAwsKms
+	testUploadObject.AllowSSEFallback = true
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if len(mockS3.Requests("PutObject")) != 1 {
+		t.Error(fmt.Sprintf("expected the upload not to fall back to SSE-S3 but %d uploads were made", len(mockS3.Requests("PutObject"))))
+	}
+}
+
+// Fails every upload encrypted with SSE-KMS with the error code, as S3 does when it cannot use the KMS key
+func failKMSUploads(mockS3 *s3mock.Server, code string) {
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if (req.Operation == "PutObject" || req.Operation == "CreateMultipartUpload") &&
+			req.Header.Get("X-Amz-Server-Side-Encryption") == s3.ServerSideEncryptionAwsKms {
+			return &s3mock.Error{StatusCode: 400, Code: code, Message: "mock KMS failure"}
+		}
+		return nil
+	})
+}
+
+//----------------------------------------------
+// Dir Manifest Testing (mock S3)
+//	1: A manifest of every file with the Merkle root of their checksums is uploaded beside the directory
+//	2: The Merkle root changes when any single file of the directory changes
+//	3: A dry run computes the manifest without uploading it
+//	4: Upload fails when a manifest is specified with a single file
+//
+//----------------------------------------------
+
+// Test 1 - Dir Manifest Testing
+//	A manifest of every file with the Merkle root of their checksums is uploaded beside the directory
+func TestDirManifestUploaded(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := ledgerUploadObject(t)
+	testUploadObject.Ledger = ""
+	testUploadObject.DirManifest = true
+
+	if _, err := UploadDir(mockS3.Client(), testUploadObject, false); err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the directory without any error: %v", err))
+	}
+
+	manifest := getDirManifest(t, mockS3)
+	if len(manifest.Files) != 5 {
+		t.Fatal(fmt.Sprintf("expected the manifest to list the 5 files but got: %d", len(manifest.Files)))
+	}
+	for _, file := range manifest.Files {
+		obj := mockS3.Object(mockBucket, file.Key)
+		if obj == nil {
+			t.Error(fmt.Sprintf("expected the manifest to list only uploaded files but got: '%s'", file.Key))
+			continue
+		}
+		sum := sha256.Sum256(obj.Body)
+		if file.Hash != hex.EncodeToString(sum[:]) || file.Size != int64(len(obj.Body)) {
+			t.Error(fmt.Sprintf("expected the manifest to record the sha256 and size of '%s'", file.Key))
+		}
+	}
+
+	root, err := DirManifestRoot(manifest.Files)
+	if err != nil || root != manifest.Root {
+		t.Error(fmt.Sprintf("expected the manifest to record the Merkle root of its files '%s' but got '%s': %v", root, manifest.Root, err))
+	}
+}
+
+// Test 2 - Dir Manifest Testing
+//	The Merkle root changes when any single file of the directory changes
+func TestDirManifestRootChanges(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := ledgerUploadObject(t)
+	testUploadObject.Ledger = ""
+	testUploadObject.DirManifest = true
+
+	if _, err := UploadDir(mockS3.Client(), testUploadObject, false); err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the directory without any error: %v", err))
+	}
+	roots := map[string]string{getDirManifest(t, mockS3).Root: "the original directory"}
+
+	for _, name := range []string{"a.txt", "b.txt", "e.txt", filepath.Join("nested", "c.txt"), filepath.Join("nested", "d.txt")} {
+		// Change a single byte without changing the size of the file
+		pathToFile := filepath.Join(testUploadObject.PathToFile, name)
+		contents, _ := ioutil.ReadFile(pathToFile)
+		contents[0] = 'T'
+		ioutil.WriteFile(pathToFile, contents, 0644)
+
+		if _, err := UploadDir(mockS3.Client(), testUploadObject, false); err != nil {
+			t.Fatal(fmt.Sprintf("expected to upload the directory without any error: %v", err))
+		}
+
+		root := getDirManifest(t, mockS3).Root
+		if previous, ok := roots[root]; ok {
+			t.Error(fmt.Sprintf("expected the Merkle root to change when '%s' changed but it matched %s", name, previous))
+		}
+		roots[root] = "the directory after '" + name + "' changed"
+	}
+
+	// Renaming a file without changing its contents also changes the root
+	files := getDirManifest(t, mockS3).Files
+	renamed := append([]ManifestFile{}, files...)
+	renamed[0].Key += ".renamed"
+	root, _ := DirManifestRoot(files)
+	renamedRoot, _ := DirManifestRoot(renamed)
+	if root == renamedRoot {
+		t.Error("expected the Merkle root to change when a file is renamed")
+	}
+}
+
+// Test 3 - Dir Manifest Testing
+//	A dry run computes the manifest without uploading it
+func TestDirManifestDryRun(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := ledgerUploadObject(t)
+	testUploadObject.Ledger = ""
+	testUploadObject.DirManifest = true
+
+	if _, err := UploadDir(mockS3.Client(), testUploadObject, true); err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the directory without any error: %v", err))
+	}
+
+	if mockS3.Object(mockBucket, "ledgerTestDir"+DirManifestSuffix) != nil || len(mockS3.Requests("PutObject")) != 0 {
+		t.Error("expected the manifest not to be uploaded during a dry run")
+	}
+}
+
+// Test 4 - Dir Manifest Testing
+//	Upload fails when a manifest is specified with a single file
+func TestDirManifestSingleFile(t *testing.T) {
+	expectedErrString := "a manifest is only supported when uploading the files of a directory individually"
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.DirManifest = true
+
+	_, err := UploadFile(svc, testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns the manifest uploaded beside the directory of the ledger upload object
+func getDirManifest(t *testing.T, mockS3 *s3mock.Server) DirManifest {
+	obj := mockS3.Object(mockBucket, "ledgerTestDir"+DirManifestSuffix)
+	if obj == nil {
+		t.Fatal("expected the manifest to be uploaded beside the directory")
+	}
+
+	var manifest DirManifest
+	if err := json.Unmarshal(obj.Body, &manifest); err != nil {
+		t.Fatal(fmt.Sprintf("expected the manifest to be valid JSON: %v", err))
+	}
+	return manifest
+}
+
+//----------------------------------------------
+// Skip If Exists Testing (mock S3)
+//	1: A second upload of the file to the same key is skipped
+//	2: A second upload of a multipart file is skipped by comparing its composite ETag
+//	3: The upload is not skipped when the key stores an object of a different size or contents
+//	4: A second upload of a manipulated key with the file modification time as its timestamp is skipped
+//	5: Upload fails when skip if exists is specified with a zip archive
+//
+//----------------------------------------------
+
+// Test 1 - Skip If Exists Testing
+//	A second upload of the file to the same key is skipped
+func TestSkipIfExists(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.SkipIfExists = true
+
+	assertSkippedIfExists(t, mockS3, testUploadObject, "PutObject")
+}
+
+// Test 2 - Skip If Exists Testing
+//	A second upload of a multipart file is skipped by comparing its composite ETag
+func TestSkipIfExistsMultipart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.SkipIfExists = true
+
+	assertSkippedIfExists(t, mockS3, testUploadObject, "CreateMultipartUpload")
+}
+
+// Test 3 - Skip If Exists Testing
+//	Store an object of a different size and then of different contents of the same size under the key
+func TestSkipIfExistsDifferentObject(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.SkipIfExists = true
+
+	contents, err := ioutil.ReadFile(pathToTestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range [][]byte{append(contents, '!'), bytes.ToUpper(contents)} {
+		mockS3.PutObject(mockBucket, s3FileName, body, time.Now())
+		puts := len(mockS3.Requests("PutObject"))
+
+		result, err := UploadFileWithResult(mockS3.Client(), testUploadObject, "", false)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to upload the file without any error: %v", err))
+		}
+		if result.Status != ResultStatusSuccess || len(mockS3.Requests("PutObject")) != puts+1 {
+			t.Error(fmt.Sprintf("expected the file to be uploaded over an object of %d bytes but got status: %s", len(body), result.Status))
+		}
+		if obj := mockS3.Object(mockBucket, s3FileName); !bytes.Equal(obj.Body, contents) {
+			t.Error("expected the key to store the contents of the file")
+		}
+	}
+}
+
+// Test 4 - Skip If Exists Testing
+//	A second upload of a manipulated key with the file modification time as its timestamp is skipped
+func TestSkipIfExistsManipulated(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := testUploadObjectManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.TimeSource = TimeSourceFileMtime
+	testUploadObject.SkipIfExists = true
+
+	assertSkippedIfExists(t, mockS3, testUploadObject, "PutObject")
+}
+
+// Test 5 - Skip If Exists Testing
+//	Upload fails when skip if exists is specified with a zip archive
+func TestSkipIfExistsZip(t *testing.T) {
+	expectedErrString := "skip if exists is not supported with zip archives"
+
+	testUploadObject := zipUploadObject(t)
+	testUploadObject.SkipIfExists = true
+
+	_, err := UploadZip(svc, testUploadObject, "", true)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Uploads the file twice and checks that the second upload was skipped without another request of the operation
+func assertSkippedIfExists(t *testing.T, mockS3 *s3mock.Server, uploadObject UploadObject, operation string) {
+	t.Helper()
+
+	first, err := UploadFileWithResult(mockS3.Client(), uploadObject, "", false)
+	if err != nil || first.Status != ResultStatusSuccess {
+		t.Fatal(fmt.Sprintf("expected the first upload to succeed: %s %v", first.Status, err))
+	}
+
+	second, err := UploadFileWithResult(mockS3.Client(), uploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the second upload to be skipped without any error: %v", err))
+	}
+	if second.Status != ResultStatusSkipped || second.Key != first.Key {
+		t.Error(fmt.Sprintf("expected the second upload of key '%s' to be skipped but got status: %s key: %s", first.Key, second.Status, second.Key))
+	}
+	if requests := len(mockS3.Requests(operation)); requests != 1 {
+		t.Error(fmt.Sprintf("expected a single %s request but got %d", operation, requests))
+	}
+}
+
+//----------------------------------------------
+// Part Boundary Testing (mock S3)
+//	1: An empty file is uploaded with a single PUT
+//	2: A file of exactly the part size is uploaded with a single PUT
+//	3: A file of exactly twice the part size is uploaded in 2 parts without an empty trailing part
+//	4: An interrupted upload of a file which fits in a single part is aborted and the file uploaded with a single PUT
+//
+//----------------------------------------------
+
+// Test 1 - Part Boundary Testing
+//	An empty file is uploaded with a single PUT
+func TestUploadEmptyFile(t *testing.T) {
+	assertPartBoundaryUpload(t, 0, 0)
+}
+
+// Test 2 - Part Boundary Testing
+//	A file of exactly the part size is uploaded with a single PUT
+func TestUploadExactPartSize(t *testing.T) {
+	assertPartBoundaryUpload(t, 5*1024*1024, 0)
+}
+
+// Test 3 - Part Boundary Testing
+//	A file of exactly twice the part size is uploaded in 2 parts without an empty trailing part
+func TestUploadExactMultipleOfPartSize(t *testing.T) {
+	assertPartBoundaryUpload(t, 2*5*1024*1024, 2)
+}
+
+// Test 4 - Part Boundary Testing
+//	An interrupted upload with no parts uploaded is found for an empty file and a file of exactly the part size
+func TestResumeFitsInSinglePart(t *testing.T) {
+	pathToFile := filepath.Join(t.TempDir(), "multipartTestFile")
+
+	for _, size := range []int64{0, 5 * 1024 * 1024} {
+		mockS3 := s3mock.New(mockBucket)
+		rejectEmptyParts(mockS3)
+
+		if err := util.CreateFile(pathToFile, []byte(strings.Repeat("0123456789abcdef", int(size/16)))); err != nil {
+			t.Fatal(err)
+		}
+
+		testUploadObject := resumeUploadObject(pathToFile)
+		_, err := mockS3.Client().CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket: aws.String(mockBucket),
+			Key:    aws.String(testUploadObject.S3FileName),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := UploadFile(mockS3.Client(), testUploadObject, "", false); err != nil {
+			t.Fatal(fmt.Sprintf("expected the file of %d bytes to be uploaded without any error: %v", size, err))
+		}
+		if len(mockS3.Requests("PutObject")) != 1 || len(mockS3.Requests("AbortMultipartUpload")) != 1 || mockS3.MultipartUploads() != 0 {
+			t.Error(fmt.Sprintf("expected the interrupted upload to be aborted and the file of %d bytes uploaded with a single PUT", size))
+		}
+		mockS3.Close()
+	}
+}
+
+// Uploads a file of the size with and without strong verification, which reads the file through a body that cannot
+// seek, and checks that it was uploaded in the expected number of parts with none of them empty
+func assertPartBoundaryUpload(t *testing.T, size int64, expectedParts int) {
+	t.Helper()
+
+	pathToFile := filepath.Join(t.TempDir(), "boundaryTestFile")
+	contents := []byte(strings.Repeat("0123456789abcdef", int(size/16)))
+	if err := util.CreateFile(pathToFile, contents); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, strongVerify := range []bool{false, true} {
+		mockS3 := s3mock.New(mockBucket)
+		rejectEmptyParts(mockS3)
+
+		testUploadObject := multipartUploadObject(strongVerify)
+		testUploadObject.PathToFile = pathToFile
+		testUploadObject.VerifyChecksum = !strongVerify
+
+		key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected the file of %d bytes to be uploaded without any error (strong verify: %t): %v", size, strongVerify, err))
+		}
+
+		obj := mockS3.Object(mockBucket, key)
+		if obj == nil || !bytes.Equal(obj.Body, contents) || len(obj.Parts) != expectedParts {
+			t.Error(fmt.Sprintf("expected the file of %d bytes to be uploaded in %d parts (strong verify: %t)", size, expectedParts, strongVerify))
+		}
+		if expectedParts == 0 && len(mockS3.Requests("PutObject")) != 1 {
+			t.Error(fmt.Sprintf("expected the file of %d bytes to be uploaded with a single PUT (strong verify: %t)", size, strongVerify))
+		}
+		mockS3.Close()
+	}
+}
+
+// Rejects any empty part in the same way as providers which do not accept an empty final part
+func rejectEmptyParts(mockS3 *s3mock.Server) {
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "UploadPart" && len(req.Body) == 0 {
+			return &s3mock.Error{StatusCode: 400, Code: "EntityTooSmall", Message: "empty part"}
+		}
+		return nil
+	})
+}
+
+//----------------------------------------------
+// Directory Upload Testing (mock S3)
+//	1: Files are uploaded concurrently up to the number of workers keyed by their relative paths
+//	2: Every file which failed is named by the error and the remaining files are still uploaded
+//	3: Symlinks are skipped unless follow symlinks is enabled
+//	4: A symlink to a directory which contains it is skipped when following symlinks
+//
+//----------------------------------------------
+
+// Test 1 - Directory Upload Testing
+//	Files are uploaded concurrently up to the number of workers keyed by their relative paths
+func TestUploadDirConcurrent(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation != "PutObject" {
+			return nil
+		}
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return nil
+	})
+
+	testUploadObject := ledgerUploadObject(t)
+	testUploadObject.Ledger = ""
+	testUploadObject.NumWorkers = 2
+
+	results, err := UploadDir(mockS3.Client(), testUploadObject, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the directory without any error: %v", err))
+	}
+
+	keys := []string{}
+	for _, result := range results {
+		keys = append(keys, result.Key)
+	}
+	expected := "[ledgerTestDir/a.txt ledgerTestDir/b.txt ledgerTestDir/e.txt ledgerTestDir/nested/c.txt ledgerTestDir/nested/d.txt]"
+	if fmt.Sprint(keys) != expected {
+		t.Error(fmt.Sprintf("expected the results of %s in the order they were walked but got: %v", expected, keys))
+	}
+	if maxInFlight != 2 {
+		t.Error(fmt.Sprintf("expected 2 files to be uploaded at once but got: %d", maxInFlight))
+	}
+}
+
+// Test 2 - Directory Upload Testing
+//	Fail the upload of 2 files of the directory
+func TestUploadDirAggregateError(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "PutObject" && (req.Key == "ledgerTestDir/b.txt" || req.Key == "ledgerTestDir/nested/d.txt") {
+			return &s3mock.Error{StatusCode: 403, Code: "AccessDenied", Message: "mock failure"}
+		}
+		return nil
+	})
+
+	testUploadObject := ledgerUploadObject(t)
+	testUploadObject.Ledger = ""
+
+	results, err := UploadDir(mockS3.Client(), testUploadObject, false)
+	dirErr, ok := err.(*DirUploadError)
+	if !ok || len(dirErr.Failed) != 2 {
+		t.Fatal(fmt.Sprintf("expected the directory upload to fail naming 2 files but got: %v", err))
+	}
+	for _, name := range []string{"b.txt", filepath.Join("nested", "d.txt")} {
+		pathToFile := filepath.Join(testUploadObject.PathToFile, name)
+		if dirErr.Failed[pathToFile] == nil || !strings.Contains(err.Error(), pathToFile) {
+			t.Error(fmt.Sprintf("expected the error to name '%s' but got: %v", pathToFile, err))
+		}
+	}
+	if len(results) != 3 || len(mockS3.Keys(mockBucket)) != 3 {
+		t.Error(fmt.Sprintf("expected the remaining 3 files to be uploaded but got: %v", mockS3.Keys(mockBucket)))
+	}
+}
+
+// Test 3 - Directory Upload Testing
+//	Upload a directory with a symlink to a file and a directory outside it with and without following symlinks
+func TestUploadDirSymlinks(t *testing.T) {
+	testUploadObject := symlinkUploadObject(t)
+	outside := filepath.Join(filepath.Dir(testUploadObject.PathToFile), "outside")
+	os.MkdirAll(filepath.Join(outside, "linkedDir"), 0755)
+	ioutil.WriteFile(filepath.Join(outside, "linked.txt"), []byte("linked file"), 0644)
+	ioutil.WriteFile(filepath.Join(outside, "linkedDir", "inner.txt"), []byte("inner file"), 0644)
+	if err := os.Symlink(filepath.Join(outside, "linked.txt"), filepath.Join(testUploadObject.PathToFile, "link.txt")); err != nil {
+		t.Skip("symlinks are not supported: " + err.Error())
+	}
+	os.Symlink(filepath.Join(outside, "linkedDir"), filepath.Join(testUploadObject.PathToFile, "nested", "linkDir"))
+
+	for _, followSymlinks := range []bool{false, true} {
+		mockS3 := s3mock.New(mockBucket)
+
+		testUploadObject.FollowSymlinks = followSymlinks
+		if _, err := UploadDir(mockS3.Client(), testUploadObject, false); err != nil {
+			t.Fatal(fmt.Sprintf("expected to upload the directory without any error (follow symlinks: %t): %v", followSymlinks, err))
+		}
+
+		keys := mockS3.Keys(mockBucket)
+		sort.Strings(keys)
+		expected := "[symlinkTestDir/file.txt]"
+		if followSymlinks {
+			expected = "[symlinkTestDir/file.txt symlinkTestDir/link.txt symlinkTestDir/nested/linkDir/inner.txt]"
+		}
+		if fmt.Sprint(keys) != expected {
+			t.Error(fmt.Sprintf("expected keys %s (follow symlinks: %t) but got: %v", expected, followSymlinks, keys))
+		}
+		if followSymlinks && string(mockS3.Object(mockBucket, "symlinkTestDir/link.txt").Body) != "linked file" {
+			t.Error("expected the symlink to be uploaded with the contents of the file it links to")
+		}
+		mockS3.Close()
+	}
+}
+
+// Test 4 - Directory Upload Testing
+//	Follow the symlinks of a directory with a symlink to the directory itself
+func TestUploadDirSymlinkCycle(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := symlinkUploadObject(t)
+	testUploadObject.FollowSymlinks = true
+	if err := os.Symlink(testUploadObject.PathToFile, filepath.Join(testUploadObject.PathToFile, "nested", "loop")); err != nil {
+		t.Skip("symlinks are not supported: " + err.Error())
+	}
+
+	if _, err := UploadDir(mockS3.Client(), testUploadObject, false); err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the directory without any error: %v", err))
+	}
+	if keys := mockS3.Keys(mockBucket); fmt.Sprint(keys) != "[symlinkTestDir/file.txt]" {
+		t.Error(fmt.Sprintf("expected the symlink to the directory to be skipped but got: %v", keys))
+	}
+}
+
+// Returns an upload object for a directory with a single file and an empty nested directory to add symlinks to
+func symlinkUploadObject(t *testing.T) UploadObject {
+	dir := filepath.Join(t.TempDir(), "files")
+	os.MkdirAll(filepath.Join(dir, "nested"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("regular file"), 0644)
+
+	return UploadObject{
+		PathToFile: dir,
+		S3FileName: "symlinkTestDir",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 3,
+		PartSize:   5,
+	}
+}
+
+//----------------------------------------------
+// Dead Letter Testing (mock S3)
+//	1: The files which still fail once retried are written to the dead letter and the remaining files are uploaded
+//	2: A retry of the dead letter uploads only the files it records and removes it once they are uploaded
+//	3: A file which fails is uploaded again up to file retries times
+//	4: Upload fails when retry dead letter is enabled without a dead letter
+//
+//----------------------------------------------
+
+// Test 1 - Dead Letter Testing
+//	Fail every attempt to upload 2 files of the directory with a file retry
+func TestDeadLetterWritten(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	failFiles(mockS3, "ledgerTestDir/b.txt", "ledgerTestDir/nested/d.txt")
+
+	testUploadObject := deadLetterUploadObject(t)
+	testUploadObject.FileRetries = 1
+	retryBaseDelay = 0
+
+	results, err := UploadDir(mockS3.Client(), testUploadObject, false)
+	dirErr, ok := err.(*DirUploadError)
+	if !ok || len(dirErr.Failed) != 2 || dirErr.DeadLetter != testUploadObject.DeadLetter || !strings.Contains(err.Error(), testUploadObject.DeadLetter) {
+		t.Fatal(fmt.Sprintf("expected the directory upload to fail naming 2 files and the dead letter but got: %v", err))
+	}
+	if len(results) != 3 || len(mockS3.Keys(mockBucket)) != 3 {
+		t.Error(fmt.Sprintf("expected the remaining 3 files to be uploaded but got: %v", mockS3.Keys(mockBucket)))
+	}
+
+	entries := readDeadLetter(t, testUploadObject.DeadLetter)
+	if len(entries) != 2 || entries[0].Path != "b.txt" || entries[0].Key != "ledgerTestDir/b.txt" || entries[1].Path != "nested/d.txt" ||
+		!strings.Contains(entries[1].Error, "AccessDenied") {
+		t.Error(fmt.Sprintf("expected b.txt and nested/d.txt to be written to the dead letter but got: %+v", entries))
+	}
+	if count := len(mockS3.Requests("PutObject")); count != 7 {
+		t.Error(fmt.Sprintf("expected each failed file to be attempted twice for 7 requests but got: %d", count))
+	}
+}
+
+// Test 2 - Dead Letter Testing
+//	Retry the dead letter of a directory upload once the file which failed can be uploaded
+func TestDeadLetterRetried(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	failing := failFiles(mockS3, "ledgerTestDir/nested/c.txt")
+
+	testUploadObject := deadLetterUploadObject(t)
+	if _, err := UploadDir(mockS3.Client(), testUploadObject, false); err == nil {
+		t.Fatal("expected the directory upload to fail")
+	}
+	*failing = false
+
+	testUploadObject.RetryDeadLetter = true
+	uploadedBefore := len(mockS3.Requests("PutObject"))
+	results, err := UploadDir(mockS3.Client(), testUploadObject, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to retry the dead letter without any error: %v", err))
+	}
+
+	uploaded := []string{}
+	for _, req := range mockS3.Requests("PutObject")[uploadedBefore:] {
+		uploaded = append(uploaded, req.Key)
+	}
+	if fmt.Sprint(uploaded) != "[ledgerTestDir/nested/c.txt]" || len(results) != 1 {
+		t.Error(fmt.Sprintf("expected only the file in the dead letter to be uploaded but got: %v", uploaded))
+	}
+	if _, err := os.Stat(testUploadObject.DeadLetter); !os.IsNotExist(err) {
+		t.Error("expected the dead letter to be removed once every file it recorded was uploaded")
+	}
+
+	results, err = UploadDir(mockS3.Client(), testUploadObject, false)
+	if err != nil || len(results) != 0 || len(mockS3.Requests("PutObject")) != uploadedBefore+1 {
+		t.Error(fmt.Sprintf("expected nothing to be retried without a dead letter but got: %v %v", results, err))
+	}
+}
+
+// Test 3 - Dead Letter Testing
+//	Upload a directory with a file which fails twice with 403 Forbidden and file retries of 2
+func TestDeadLetterFileRetries(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	var mu sync.Mutex
+	attempts := 0
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Operation == "PutObject" && req.Key == "ledgerTestDir/a.txt" {
+			if attempts++; attempts <= 2 {
+				return &s3mock.Error{StatusCode: 403, Code: "AccessDenied", Message: "mock failure"}
+			}
+		}
+		return nil
+	})
+
+	testUploadObject := deadLetterUploadObject(t)
+	testUploadObject.FileRetries = 2
+	retryBaseDelay = 0
+
+	results, err := UploadDir(mockS3.Client(), testUploadObject, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the failed file to be uploaded by a file retry: %v", err))
+	}
+	if len(results) != 5 || attempts != 3 {
+		t.Error(fmt.Sprintf("expected every file to be uploaded with 3 attempts of a.txt but got %d files and %d attempts", len(results), attempts))
+	}
+	if _, err := os.Stat(testUploadObject.DeadLetter); !os.IsNotExist(err) {
+		t.Error("expected no dead letter to be written when no file failed")
+	}
+}
+
+// Test 4 - Dead Letter Testing
+//	Upload a directory with retry dead letter enabled and no dead letter specified
+func TestDeadLetterRetryRequiresDeadLetter(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	expectedErrString := "a dead letter must be specified to retry the files recorded in it"
+
+	testUploadObject := deadLetterUploadObject(t)
+	testUploadObject.DeadLetter = ""
+	testUploadObject.RetryDeadLetter = true
+
+	_, err := UploadDir(mockS3.Client(), testUploadObject, false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error: '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns an upload object for the directory of the ledger tests with a dead letter instead of a ledger
+func deadLetterUploadObject(t *testing.T) UploadObject {
+	testUploadObject := ledgerUploadObject(t)
+	testUploadObject.DeadLetter = filepath.Join(filepath.Dir(testUploadObject.Ledger), "upload.deadletter")
+	testUploadObject.Ledger = ""
+	return testUploadObject
+}
+
+// Fails every upload of the keys while the returned flag is true
+func failFiles(mockS3 *s3mock.Server, keys ...string) *bool {
+	failing := true
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if !failing || req.Operation != "PutObject" {
+			return nil
+		}
+		for _, key := range keys {
+			if req.Key == key {
+				return &s3mock.Error{StatusCode: 403, Code: "AccessDenied", Message: "mock failure"}
+			}
+		}
+		return nil
+	})
+	return &failing
+}
+
+// Returns the entries of the dead letter at the path
+func readDeadLetter(t *testing.T, path string) []DeadLetterEntry {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the dead letter to be written: %v", err))
+	}
+
+	entries := []DeadLetterEntry{}
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		var entry DeadLetterEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(fmt.Sprintf("expected every line of the dead letter to be JSON: %v", err))
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+//----------------------------------------------
+// Rate Limit Testing (mock S3)
+//	1: The workers of a multipart upload share the rate rather than each being limited to it
+//	2: The files of a directory uploaded at once share the rate
+//	3: Upload fails when the max bytes per sec is negative
+//
+//----------------------------------------------
+
+// Test 1 - Rate Limit Testing
+//	Upload a file of 11MiB in parts of 5MiB with 3 workers limited to 8MiB per second
+func TestUploadRateLimitSharedByWorkers(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	pathToFile := filepath.Join(t.TempDir(), "rateLimitTestFile")
+	contents := []byte(strings.Repeat("0123456789abcdef", 11*1024*1024/16))
+	if err := util.CreateFile(pathToFile, contents); err != nil {
+		t.Fatal(err)
+	}
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.PathToFile = pathToFile
+	testUploadObject.MaxBytesPerSec = 8 * 1024 * 1024
+
+	startTime := time.Now()
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	elapsed := time.Since(startTime)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the rate limited upload to complete without any error: %v", err))
+	}
+
+	obj := mockS3.Object(mockBucket, key)
+	if obj == nil || !bytes.Equal(obj.Body, contents) || len(obj.Parts) != 3 {
+		t.Error("expected the rate limited file to be uploaded intact in 3 parts")
+	}
+	// Each worker limited to 8MiB per second on its own would upload the file in under half a second
+	if elapsed < time.Second {
+		t.Error(fmt.Sprintf("expected the upload of 11MiB at 8MiB per second to take at least 1s but took: %s", elapsed))
+	}
+}
+
+// Test 2 - Rate Limit Testing
+//	Upload a directory of 3 files of 100KB at once limited to 300KB per second
+func TestUploadRateLimitSharedByDirFiles(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	dir := filepath.Join(t.TempDir(), "files")
+	os.MkdirAll(dir, 0755)
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		ioutil.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte{'x'}, 100*1000), 0644)
+	}
+
+	testUploadObject := UploadObject{
+		PathToFile:     dir,
+		S3FileName:     "rateLimitTestDir",
+		Bucket:         mockBucket,
+		Timeout:        timeout,
+		NumWorkers:     3,
+		PartSize:       5,
+		MaxBytesPerSec: 300 * 1000,
+	}
+
+	startTime := time.Now()
+	results, err := UploadDir(mockS3.Client(), testUploadObject, false)
+	elapsed := time.Since(startTime)
+	if err != nil || len(results) != 3 {
+		t.Fatal(fmt.Sprintf("expected the 3 files to be uploaded without any error: %v", err))
+	}
+	// Each file limited to 300KB per second on its own would be uploaded in a third of a second
+	if elapsed < 900*time.Millisecond {
+		t.Error(fmt.Sprintf("expected the upload of 300KB at 300KB per second to take at least 1s but took: %s", elapsed))
+	}
+}
+
+// Test 3 - Rate Limit Testing
+//	Upload a file with a negative max bytes per sec
+func TestUploadRateLimitNegative(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	expectedErrString := "max bytes per sec must not be less than 0"
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.MaxBytesPerSec = -1
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if len(mockS3.Keys(mockBucket)) != 0 {
+		t.Error("expected no object to be uploaded")
+	}
+}
+
+//----------------------------------------------
+// Retry Testing (mock S3)
+//	1: An upload which fails with a 503 twice succeeds on the 3rd attempt
+//	2: A multipart upload whose part fails with a 500 is retried
+//	3: An upload which fails with a 403 is not retried
+//	4: An upload which fails more times than the max retries returns the error
+//	5: An upload which times out is not retried
+//	6: A retried upload of a manipulated file uploads to the key of the first attempt
+//	7: Upload fails when the max retries is negative
+//
+//----------------------------------------------
+
+// Test 1 - Retry Testing
+//	Upload a file to a flaky provider which fails the first 2 uploads with 503 Service Unavailable
+func TestUploadRetrySucceedsOnThirdAttempt(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	flakyUploads(mockS3, "PutObject", 2, &s3mock.Error{StatusCode: 503, Code: "ServiceUnavailable", Message: "Service Unavailable"})
+
+	testUploadObject := retryUploadObject(3)
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the upload to succeed on the 3rd attempt: %v", err))
+	}
+	if mockS3.Object(mockBucket, key) == nil {
+		t.Error("expected the file to be uploaded")
+	}
+	if count := len(mockS3.Requests("PutObject")); count != 3 {
+		t.Error(fmt.Sprintf("expected 3 attempts but got: %d", count))
+	}
+}
+
+// Test 2 - Retry Testing
+//	Upload a file in parts to a provider which fails the first part uploaded with 500 Internal Error
+func TestUploadRetryMultipart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	flakyUploads(mockS3, "UploadPart", 1, &s3mock.Error{StatusCode: 500, Code: "InternalError", Message: "We encountered an internal error"})
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.MaxRetries = 1
+	retryBaseDelay = 0
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the retried multipart upload to succeed: %v", err))
+	}
+	if obj := mockS3.Object(mockBucket, key); obj == nil || len(obj.Parts) != 3 {
+		t.Error("expected the file to be uploaded in 3 parts")
+	}
+	if count := len(mockS3.Requests("CreateMultipartUpload")); count != 2 {
+		t.Error(fmt.Sprintf("expected the multipart upload to be attempted 2 times but got: %d", count))
+	}
+}
+
+// Test 3 - Retry Testing
+//	Upload a file which is denied with 403 Access Denied
+func TestUploadRetryAccessDeniedNotRetried(t *testing.T) {
+	expectedErrString := "AccessDenied"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	flakyUploads(mockS3, "PutObject", 3, &s3mock.Error{StatusCode: 403, Code: "AccessDenied", Message: "Access Denied"})
+
+	testUploadObject := retryUploadObject(3)
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if count := len(mockS3.Requests("PutObject")); count != 1 {
+		t.Error(fmt.Sprintf("expected the denied upload to be attempted once but got: %d", count))
+	}
+}
+
+// Test 4 - Retry Testing
+//	Upload a file which fails with 503 Slow Down 3 times with a max retries of 1
+func TestUploadRetryExhausted(t *testing.T) {
+	expectedErrString := "SlowDown"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	flakyUploads(mockS3, "PutObject", 3, &s3mock.Error{StatusCode: 503, Code: "SlowDown", Message: "Please reduce your request rate"})
+
+	testUploadObject := retryUploadObject(1)
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if count := len(mockS3.Requests("PutObject")); count != 2 {
+		t.Error(fmt.Sprintf("expected 2 attempts but got: %d", count))
+	}
+	if len(mockS3.Keys(mockBucket)) != 0 {
+		t.Error("expected no object to be uploaded")
+	}
+}
+
+// Test 5 - Retry Testing
+//	Upload a file with a timeout of 200ms to a provider which takes 500ms to respond
+func TestUploadRetryTimeoutNotRetried(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "PutObject" {
+			time.Sleep(500 * time.Millisecond)
+		}
+		return nil
+	})
+
+	testUploadObject := retryUploadObject(3)
+	testUploadObject.Timeout = 200 * time.Millisecond
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err == nil {
+		t.Fatal("expected the upload to time out")
+	}
+	if count := len(mockS3.Requests("PutObject")); count != 1 {
+		t.Error(fmt.Sprintf("expected the timed out upload to be attempted once but got: %d", count))
+	}
+}
+
+// Test 6 - Retry Testing
+//	Upload a manipulated file whose first attempt fails with 503 after more than a second
+func TestUploadRetryKeepsKey(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	var mu sync.Mutex
+	attempts := 0
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation != "PutObject" {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			// Fail once the second of the timestamp of the first attempt has passed
+			time.Sleep(1100 * time.Millisecond)
+			return &s3mock.Error{StatusCode: 503, Code: "ServiceUnavailable", Message: "Service Unavailable"}
+		}
+		return nil
+	})
+
+	testUploadObject := retryUploadObject(1)
+	testUploadObject.Manipulate = true
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "daily/", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the retried upload to succeed: %v", err))
+	}
+	requests := mockS3.Requests("PutObject")
+	if len(requests) != 2 || requests[0].Key != requests[1].Key || requests[1].Key != key {
+		t.Error("expected both attempts to upload to the key of the first attempt")
+	}
+}
+
+// Test 7 - Retry Testing
+//	Upload a file with a negative max retries
+func TestUploadRetryNegative(t *testing.T) {
+	expectedErrString := "max retries must not be less than 0"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := retryUploadObject(-1)
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if len(mockS3.Keys(mockBucket)) != 0 {
+		t.Error("expected no object to be uploaded")
+	}
+}
+
+// Returns the small test file upload object to the mock bucket with the max retries, retried without waiting
+func retryUploadObject(maxRetries int) UploadObject {
+	retryBaseDelay = 0
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.MaxRetries = maxRetries
+	return testUploadObject
+}
+
+// Fails the first count requests of the operation with the error
+func flakyUploads(mockS3 *s3mock.Server, operation string, count int, s3Err *s3mock.Error) {
+	var mu sync.Mutex
+	failed := 0
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation != operation {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if failed < count {
+			failed++
+			return s3Err
+		}
+		return nil
+	})
+}
+
+//----------------------------------------------
+// Content Type Testing (mock S3)
+//	1: The content type is detected from the extension of the file
+//	2: The content type is detected from the contents of a file with an unknown extension
+//	3: The content type of the upload object wins over detection
+//	4: A multipart upload is uploaded with the detected content type
+//	5: Upload fails with an invalid content type before any request is made
+//
+//----------------------------------------------
+
+// Test 1 - Content Type Testing
+//	Upload a JSON and a PNG file
+func TestContentTypeFromExtension(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	for name, expected := range map[string]string{"report.json": "application/json", "chart.png": "image/png"} {
+		key := uploadContentTypeFile(t, mockS3, name, []byte("this is just a little test file"), "")
+		if contentType := mockS3.Object(mockBucket, key).Header.Get("Content-Type"); contentType != expected {
+			t.Error(fmt.Sprintf("expected '%s' to be uploaded with content type '%s' but got: '%s'", name, expected, contentType))
+		}
+	}
+}
+
+// Test 2 - Content Type Testing
+//	Upload an HTML document and binary data with an unknown extension
+func TestContentTypeSniffed(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	contents := map[string][]byte{
+		"text/html; charset=utf-8": []byte("<!DOCTYPE html><html><body>backup report</body></html>"),
+		"application/x-gzip":       {0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00},
+		"application/octet-stream": {0x00, 0x01, 0x02, 0x03},
+	}
+	for expected, body := range contents {
+		key := uploadContentTypeFile(t, mockS3, "backup.unknownext", body, "")
+		if contentType := mockS3.Object(mockBucket, key).Header.Get("Content-Type"); contentType != expected {
+			t.Error(fmt.Sprintf("expected the content type to be sniffed as '%s' but got: '%s'", expected, contentType))
+		}
+	}
+}
+
+// Test 3 - Content Type Testing
+//	Upload a JSON file with the content type text/plain
+func TestContentTypeOverride(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	key := uploadContentTypeFile(t, mockS3, "report.json", []byte("{}"), "text/plain")
+	if contentType := mockS3.Object(mockBucket, key).Header.Get("Content-Type"); contentType != "text/plain" {
+		t.Error(fmt.Sprintf("expected the content type of the upload object to win over detection but got: '%s'", contentType))
+	}
+}
+
+// Test 4 - Content Type Testing
+//	Upload a file of 11MiB with a JSON extension in parts of 5MiB
+func TestContentTypeMultipart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	key := uploadContentTypeFile(t, mockS3, "export.json", bytes.Repeat([]byte("{}"), 11*1024*1024/2), "")
+	obj := mockS3.Object(mockBucket, key)
+	if len(obj.Parts) != 3 || obj.Header.Get("Content-Type") != "application/json" {
+		t.Error(fmt.Sprintf("expected the file to be uploaded in 3 parts as application/json but got %d parts as: '%s'", len(obj.Parts), obj.Header.Get("Content-Type")))
+	}
+}
+
+// Test 5 - Content Type Testing
+//	Upload a file with an invalid content type
+func TestContentTypeInvalid(t *testing.T) {
+	expectedErrString := "invalid content type 'text/'"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := testUploadObjectNotManipulated
+	testUploadObject.Bucket = mockBucket
+	testUploadObject.ContentType = "text/"
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if len(mockS3.Requests("PutObject")) != 0 {
+		t.Error("expected no request to be made with an invalid content type")
+	}
+}
+
+// Uploads a file of the name and contents with the content type in parts of 5MiB and returns its key
+func uploadContentTypeFile(t *testing.T, mockS3 *s3mock.Server, name string, contents []byte, contentType string) string {
+	pathToFile := filepath.Join(t.TempDir(), name)
+	if err := util.CreateFile(pathToFile, contents); err != nil {
+		t.Fatal(err)
+	}
+
+	testUploadObject := multipartUploadObject(false)
+	testUploadObject.PathToFile = pathToFile
+	testUploadObject.S3FileName = name
+	testUploadObject.ContentType = contentType
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload '%s' without any error: %v", name, err))
+	}
+	return key
+}
+
+//----------------------------------------------
+// Stdin Testing (mock S3)
+//	1: Content piped to stdin is uploaded in parts of the part size to a manipulated key
+//	2: A small stdin is uploaded with a single PUT and its content type is sniffed
+//	3: Upload fails from stdin without an s3FileName
+//	4: Upload fails from stdin with options which read the file again
+//	5: An upload from stdin which fails with a transient error is not retried
+//	6: Upload fails from stdin to more than one destination
+//
+//----------------------------------------------
+
+// Test 1 - Stdin Testing
+//	Upload 12MiB of random bytes from stdin in parts of 5MiB to a daily key
+func TestUploadStdinMultipart(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	contents := make([]byte, multipartFileSize)
+	rand.Read(contents)
+	testUploadObject := stdinUploadObject(t, contents)
+	testUploadObject.Manipulate = true
+
+	result, err := UploadFileWithResult(mockS3.Client(), testUploadObject, policy.DailyPrefix, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload stdin without any error: %v", err))
+	}
+
+	if !strings.HasPrefix(result.Key, policy.DailyPrefix+"stdinDump_") {
+		t.Error(fmt.Sprintf("expected stdin to be uploaded to a daily key of the s3FileName but got '%s'", result.Key))
+	}
+	obj := mockS3.Object(mockBucket, result.Key)
+	if obj == nil || !bytes.Equal(obj.Body, contents) || len(obj.Parts) != 3 {
+		t.Fatal("expected the content of stdin to be uploaded in 3 parts")
+	}
+
+	md5sum := md5.Sum(contents)
+	if result.Bytes != multipartFileSize || result.Checksum != hex.EncodeToString(md5sum[:]) {
+		t.Error(fmt.Sprintf("expected the result to record %d bytes with the md5sum of stdin but got %d bytes: %s", multipartFileSize, result.Bytes, result.Checksum))
+	}
+}
+
+// Test 2 - Stdin Testing
+//	Upload a short text from stdin
+func TestUploadStdinSinglePut(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	contents := []byte("-- PostgreSQL database dump\n")
+	key, err := UploadFile(mockS3.Client(), stdinUploadObject(t, contents), "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload stdin without any error: %v", err))
+	}
+
+	obj := mockS3.Object(mockBucket, key)
+	if key != "stdinDump" || obj == nil || !bytes.Equal(obj.Body, contents) {
+		t.Fatal("expected the content of stdin to be uploaded to the s3FileName")
+	}
+	if len(mockS3.Requests("PutObject")) != 1 || len(mockS3.Requests("CreateMultipartUpload")) != 0 {
+		t.Error("expected stdin smaller than a part to be uploaded with a single PUT")
+	}
+	if contentType := obj.Header.Get("Content-Type"); contentType != "text/plain; charset=utf-8" {
+		t.Error(fmt.Sprintf("expected the content type of stdin to be sniffed as text but got '%s'", contentType))
+	}
+}
+
+// Test 3 - Stdin Testing
+//	Upload from stdin without an s3FileName
+func TestUploadStdinRequiresS3FileName(t *testing.T) {
+	expectedErrString := "s3FileName must be specified when uploading from stdin"
+
+	testUploadObject := stdinUploadObject(t, []byte("dump"))
+	testUploadObject.S3FileName = ""
+
+	_, err := UploadFile(svc, testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 4 - Stdin Testing
+//	Upload from stdin with compression and skip if unchanged
+func TestUploadStdinUnsupportedOptions(t *testing.T) {
+	expectedErrString := "uploading from stdin is not supported with: compression, skip if unchanged"
+
+	testUploadObject := stdinUploadObject(t, []byte("dump"))
+	testUploadObject.Compression = "gzip"
+	testUploadObject.SkipIfUnchanged = true
+
+	_, err := UploadFile(svc, testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Test 5 - Stdin Testing
+//	Upload from stdin to a provider which fails the first upload with 503 Service Unavailable
+func TestUploadStdinNotRetried(t *testing.T) {
+	expectedErrString := "ServiceUnavailable"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+	flakyUploads(mockS3, "PutObject", 1, &s3mock.Error{StatusCode: 503, Code: "ServiceUnavailable", Message: "Service Unavailable"})
+
+	testUploadObject := stdinUploadObject(t, []byte("dump"))
+	testUploadObject.MaxRetries = 3
+	retryBaseDelay = 0
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if count := len(mockS3.Requests("PutObject")); count != 1 {
+		t.Error(fmt.Sprintf("expected the upload from stdin to be attempted once but got: %d", count))
+	}
+}
+
+// Test 6 - Stdin Testing
+//	Upload from stdin to a primary and a failover destination
+func TestUploadStdinMultipleDestinations(t *testing.T) {
+	expectedErrString := "stdin can only be uploaded to a single destination"
+
+	mockS3 := s3mock.New(mockBucket, "failoverbucket")
+	defer mockS3.Close()
+
+	destinations := []Destination{
+		{Bucket: mockBucket, Svc: mockS3.Client()},
+		{Bucket: "failoverbucket", Failover: true, Svc: mockS3.Client()},
+	}
+	_, err := UploadToDestinations(destinations, stdinUploadObject(t, []byte("dump")),
+		func(destinationSvc *s3.S3, destinationObject UploadObject) (string, bool, error) {
+			key, err := UploadFile(destinationSvc, destinationObject, "", false)
+			return key, err == nil, err
+		})
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if len(mockS3.Keys(mockBucket)) != 0 {
+		t.Error("expected nothing to be uploaded")
+	}
+}
+
+// Returns an upload object which uploads the contents read from stdin in parts of 5MiB. Stdin is restored once the
+// test completes
+func stdinUploadObject(t *testing.T, contents []byte) UploadObject {
+	previous := stdin
+	stdin = io.MultiReader(bytes.NewReader(contents)) // Hides the Seek of the reader as stdin cannot seek
+	t.Cleanup(func() { stdin = previous })
+
+	return UploadObject{
+		PathToFile: StdinPath,
+		S3FileName: "stdinDump",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 3,
+		PartSize:   5,
+	}
+}
+
+//----------------------------------------------
+// Split Upload Testing (mock S3)
+//	1: A file is uploaded as volumes of the split size followed by an index of the volumes
+//	2: The uploaded volumes are removed when a volume fails to upload
+//	3: Upload fails when splitting with compression
+//
+//----------------------------------------------
+
+// Test 1 - Split Upload Testing
+//	Split 2.5MiB of random bytes into volumes of 1MiB
+func TestUploadSplit(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	contents := make([]byte, 5*512*1024)
+	rand.Read(contents)
+
+	key, err := UploadSplit(mockS3.Client(), splitUploadObject(t, contents), "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file split into volumes without any error: %v", err))
+	}
+
+	var index VolumeIndex
+	obj := mockS3.Object(mockBucket, key)
+	if obj == nil || json.Unmarshal(obj.Body, &index) != nil || len(index.Volumes) != 3 {
+		t.Fatal("expected an index of 3 volumes to be uploaded to the key of the backup")
+	}
+	if archiveFormat := obj.Header.Get("X-Amz-Meta-" + ArchiveMetadataKey); archiveFormat != ArchiveFormatVolumes {
+		t.Error(fmt.Sprintf("expected the archive format of the index to be '%s' but got '%s'", ArchiveFormatVolumes, archiveFormat))
+	}
+
+	reassembled := []byte{}
+	for i, volume := range index.Volumes {
+		if volume.Key != s3client.VolumeKey("", key, i+1) {
+			t.Error(fmt.Sprintf("expected volume %d to be stored under the volume dir but got '%s'", i+1, volume.Key))
+		}
+		if obj := mockS3.Object(mockBucket, volume.Key); obj != nil {
+			reassembled = append(reassembled, obj.Body...)
+		}
+	}
+	md5sum := md5.Sum(contents)
+	if !bytes.Equal(reassembled, contents) || index.MD5 != hex.EncodeToString(md5sum[:]) || index.Size != int64(len(contents)) {
+		t.Error("expected the volumes to be the file in order with its size and md5sum recorded in the index")
+	}
+}
+
+// Test 2 - Split Upload Testing
+//	Fail the upload of the second volume
+func TestUploadSplitRemovesVolumesOnFailure(t *testing.T) {
+	expectedErrString := "failed to upload volume"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	mockS3.AddHook(func(req *s3mock.Request) *s3mock.Error {
+		if req.Operation == "PutObject" && strings.HasSuffix(req.Key, ".part002") {
+			return &s3mock.Error{StatusCode: 403, Code: "AccessDenied", Message: "mock failure"}
+		}
+		return nil
+	})
+
+	_, err := UploadSplit(mockS3.Client(), splitUploadObject(t, make([]byte, 5*512*1024)), "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if keys := mockS3.Keys(mockBucket); len(keys) != 0 {
+		t.Error(fmt.Sprintf("expected the uploaded volumes to be removed but got: %v", keys))
+	}
+}
+
+// Test 3 - Split Upload Testing
+//	Split a file with compression
+func TestUploadSplitCompression(t *testing.T) {
+	expectedErrString := "compression is not supported with split uploads"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := splitUploadObject(t, []byte("image"))
+	testUploadObject.Compression = "gzip"
+
+	_, err := UploadSplit(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns an upload object which splits a file of the contents into volumes of 1MiB
+func splitUploadObject(t *testing.T, contents []byte) UploadObject {
+	pathToFile := filepath.Join(t.TempDir(), "image")
+	if err := ioutil.WriteFile(pathToFile, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return UploadObject{
+		PathToFile: pathToFile,
+		S3FileName: "image",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 3,
+		PartSize:   5,
+		SplitSize:  1,
+	}
+}
+
+//----------------------------------------------
+// Encryption Testing (mock S3)
+//	1: A compressed file is encrypted before upload to a key with the extension of the encryption
+//	2: An unchanged encrypted backup is skipped by its recorded checksum
+//	3: Upload fails with key material shorter than the minimum size
+//	4: Upload fails when encrypting a zip archive
+//
+//----------------------------------------------
+
+// Test 1 - Encryption Testing
+//	Upload a text file compressed with gzip and then encrypted
+func TestUploadEncrypted(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	contents := []byte(strings.Repeat("-- PostgreSQL database dump\n", 1000))
+	testUploadObject := encryptedUploadObject(t, contents)
+	testUploadObject.Compression = "gzip"
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload encrypted file without any error: %v", err))
+	}
+	if key != "dump.gz"+encrypt.Extension {
+		t.Error(fmt.Sprintf("expected the extension of the encryption to be appended after the compression but got '%s'", key))
+	}
+
+	obj := mockS3.Object(mockBucket, key)
+	if obj == nil || obj.Header.Get("X-Amz-Meta-"+encrypt.MetadataKey) != encrypt.Algorithm {
+		t.Fatal("expected the encryption algorithm to be recorded in the metadata")
+	}
+
+	decrypted, err := encrypt.NewReader(bytes.NewReader(obj.Body), testUploadObject.EncryptionKey)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the object to be encrypted with the key material: %v", err))
+	}
+	decompressed, err := gzip.NewReader(decrypted)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the object to be compressed before it was encrypted: %v", err))
+	}
+	restored, err := ioutil.ReadAll(decompressed)
+	if err != nil || !bytes.Equal(restored, contents) {
+		t.Error(fmt.Sprintf("expected the decrypted object to match the file: %v", err))
+	}
+}
+
+// Test 2 - Encryption Testing
+//	Upload the same encrypted file twice with skip if unchanged
+func TestUploadEncryptedUnchanged(t *testing.T) {
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := encryptedUploadObject(t, []byte("an unchanged database dump"))
+	testUploadObject.SkipIfUnchanged = true
+
+	first, err := UploadFileWithResult(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload encrypted file without any error: %v", err))
+	}
+	second, err := UploadFileWithResult(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to skip the unchanged encrypted file without any error: %v", err))
+	}
+
+	if second.Status != ResultStatusSkipped || second.Key != first.Key {
+		t.Error(fmt.Sprintf("expected the unchanged file to be skipped as it matches '%s' but got: %s '%s'", first.Key, second.Status, second.Key))
+	}
+}
+
+// Test 3 - Encryption Testing
+//	Upload encrypted with key material of 5 bytes
+func TestUploadEncryptedShortKey(t *testing.T) {
+	expectedErrString := fmt.Sprintf("key material must be at least %d bytes", encrypt.MinMaterialSize)
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := encryptedUploadObject(t, []byte("dump"))
+	testUploadObject.EncryptionKey = []byte("short")
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+	if len(mockS3.Keys(mockBucket)) != 0 {
+		t.Error("expected nothing to be uploaded")
+	}
+}
+
+// Test 4 - Encryption Testing
+//	Upload a directory as an encrypted zip archive
+func TestUploadEncryptedZip(t *testing.T) {
+	expectedErrString := "encryption is not supported with zip archives"
+
+	mockS3 := s3mock.New(mockBucket)
+	defer mockS3.Close()
+
+	testUploadObject := encryptedUploadObject(t, []byte("dump"))
+	testUploadObject.PathToFile = filepath.Dir(testUploadObject.PathToFile)
+
+	_, err := UploadZip(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns an upload object which encrypts a file of the contents
+func encryptedUploadObject(t *testing.T, contents []byte) UploadObject {
+	pathToFile := filepath.Join(t.TempDir(), "dump")
+	if err := ioutil.WriteFile(pathToFile, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return UploadObject{
+		PathToFile:    pathToFile,
+		S3FileName:    "dump",
+		Bucket:        mockBucket,
+		Timeout:       timeout,
+		NumWorkers:    3,
+		PartSize:      5,
+		EncryptionKey: []byte("correct horse battery staple"),
+	}
+}
+
+//----------------------------------------------
+// Storage Class Mismatch Testing (mock S3)
+//	1: An existing object in GLACIER is overwritten in the storage class of the upload by default
+//	2: An existing object in GLACIER is replaced in GLACIER when the storage class is kept
+//	3: Upload fails when the existing object is in another storage class and the existing object is left as it is
+//	4: An existing object without a storage class matches an upload in STANDARD
+//	5: Upload fails with an invalid storage class mismatch
+//
+//----------------------------------------------
+
+// Test 1 - Storage Class Mismatch Testing
+//	Upload in STANDARD over an object in GLACIER
+func TestStorageClassMismatchOverwrite(t *testing.T) {
+	mockS3, testUploadObject := storageClassMismatchUploadObject(t, s3.StorageClassGlacier)
+	defer mockS3.Close()
+
+	testUploadObject.StorageClass = s3.StorageClassStandard
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload over the existing object without any error: %v", err))
+	}
+
+	if storageClass := mockS3.Object(mockBucket, key).Header.Get("X-Amz-Storage-Class"); storageClass != s3.StorageClassStandard {
+		t.Error(fmt.Sprintf("expected the object to be overwritten in STANDARD but got '%s'", storageClass))
+	}
+	if len(mockS3.Requests("HeadObject")) != 0 {
+		t.Error("expected the storage class of the existing object not to be checked")
+	}
+}
+
+// Test 2 - Storage Class Mismatch Testing
+//	Upload in STANDARD over an object in GLACIER keeping its storage class
+func TestStorageClassMismatchKeep(t *testing.T) {
+	mockS3, testUploadObject := storageClassMismatchUploadObject(t, s3.StorageClassGlacier)
+	defer mockS3.Close()
+
+	testUploadObject.StorageClass = s3.StorageClassStandard
+	testUploadObject.StorageClassMismatch = StorageClassMismatchKeep
+
+	key, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload over the existing object without any error: %v", err))
+	}
+
+	obj := mockS3.Object(mockBucket, key)
+	if storageClass := obj.Header.Get("X-Amz-Storage-Class"); storageClass != s3.StorageClassGlacier {
+		t.Error(fmt.Sprintf("expected the object to be uploaded in the GLACIER storage class of the existing object but got '%s'", storageClass))
+	}
+	if string(obj.Body) != "a new backup" {
+		t.Error("expected the existing object to be replaced by the upload")
+	}
+}
+
+// Test 3 - Storage Class Mismatch Testing
+//	Upload in GLACIER over an object in STANDARD_IA failing on the mismatch
+func TestStorageClassMismatchFail(t *testing.T) {
+	expectedErrString := "key 'backup' already exists in storage class STANDARD_IA rather than the storage class GLACIER of the upload"
+
+	mockS3, testUploadObject := storageClassMismatchUploadObject(t, s3.StorageClassStandardIa)
+	defer mockS3.Close()
+
+	testUploadObject.StorageClass = s3.StorageClassGlacier
+	testUploadObject.StorageClassMismatch = StorageClassMismatchFail
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+
+	if _, ok := err.(*StorageClassMismatchError); !ok {
+		t.Error(fmt.Sprintf("expected a storage class mismatch error but got: %T", err))
+	}
+	obj := mockS3.Object(mockBucket, "backup")
+	if string(obj.Body) != "an existing backup" || obj.Header.Get("X-Amz-Storage-Class") != s3.StorageClassStandardIa {
+		t.Error("expected the existing object to be left as it is")
+	}
+	if len(mockS3.Requests("PutObject")) != 0 {
+		t.Error("expected nothing to be uploaded")
+	}
+}
+
+// Test 4 - Storage Class Mismatch Testing
+//	Upload without a storage class over an object whose storage class is not reported
+func TestStorageClassMismatchStandard(t *testing.T) {
+	mockS3, testUploadObject := storageClassMismatchUploadObject(t, "")
+	defer mockS3.Close()
+
+	testUploadObject.StorageClassMismatch = StorageClassMismatchFail
+
+	if _, err := UploadFile(mockS3.Client(), testUploadObject, "", false); err != nil {
+		t.Error(fmt.Sprintf("expected an object without a storage class to match an upload in STANDARD: %v", err))
+	}
+}
+
+// Test 5 - Storage Class Mismatch Testing
+//	Upload with storage class mismatch 'preserve'
+func TestStorageClassMismatchInvalid(t *testing.T) {
+	expectedErrString := "storage class mismatch must be one of 'overwrite', 'keep' or 'fail'"
+
+	mockS3, testUploadObject := storageClassMismatchUploadObject(t, s3.StorageClassGlacier)
+	defer mockS3.Close()
+
+	testUploadObject.StorageClassMismatch = "preserve"
+
+	_, err := UploadFile(mockS3.Client(), testUploadObject, "", false)
+	if err != nil && strings.Contains(err.Error(), expectedErrString) {
+		// Pass
+	} else {
+		t.Error(fmt.Sprintf("expected error containing '%s' but got: %v", expectedErrString, err))
+	}
+}
+
+// Returns a mock S3 storing an existing backup in the storage class and an upload object which uploads over it
+func storageClassMismatchUploadObject(t *testing.T, storageClass string) (*s3mock.Server, UploadObject) {
+	mockS3 := s3mock.New(mockBucket)
+	mockS3.PutObject(mockBucket, "backup", []byte("an existing backup"), time.Now())
+	if storageClass != "" {
+		mockS3.SetObjectHeader(mockBucket, "backup", "X-Amz-Storage-Class", storageClass)
+	}
+
+	pathToFile := filepath.Join(t.TempDir(), "backup")
+	if err := ioutil.WriteFile(pathToFile, []byte("a new backup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return mockS3, UploadObject{
+		PathToFile: pathToFile,
+		S3FileName: "backup",
+		Bucket:     mockBucket,
+		Timeout:    timeout,
+		NumWorkers: 3,
+		PartSize:   5,
+	}
+}
@@ -10,7 +10,72 @@ type UploadObject struct {
 	BucketDir  string
 	Endpoint   string
 	Manipulate bool
-	Timeout    time.Duration
+	Timeout    time.Duration // Maximum time the upload itself may take. Hashing and verifying the source have their own timeouts
+	MaxRetries int           // Times an upload which fails with a transient error, e.g. 5xx or throttling, is retried with exponential backoff
 	NumWorkers int
 	PartSize   int
+	BufferSize int    // Size (KB) of the buffer each part of the file is read through by the uploader. 0 reads the parts unbuffered
+	TimeSource string // The time used for the timestamp of manipulated keys [now|filemtime]. Defaults to now
+
+	KeyTimeLayout string         // The Go time layout of the timestamp appended to manipulated keys. Defaults to 20060102T150405
+	Location      *time.Location // The time zone the timestamp of manipulated keys is formatted and rounded in. Nil uses the local time zone
+	DateRounding  string         // Round the timestamp of manipulated keys down to the start of the [day|hour] so that a backup which runs again within it overwrites the last. Empty leaves it unrounded
+
+	HashTimeout   time.Duration // Maximum time the source is read to hash it for SkipIfUnchanged and SkipIfExists. 0 disables the timeout
+	VerifyTimeout time.Duration // Maximum time the uploaded object is verified for once the upload has completed. 0 disables the timeout
+
+	UploadPartOrdered bool  // Upload the parts strictly in order of their part number with a single worker instead of NumWorkers
+	MaxBytesPerSec    int64 // Maximum bytes per second sent by every worker of the upload combined. 0 leaves the upload unlimited
+
+	OnTimeout       string // What happens to the parts of a multipart upload which times out [abort|preserve]. Defaults to abort
+	ResumeStateFile string // Path of the file the state of a timed out upload is written to so that it can be resumed. Required to preserve
+	Resume          bool   // Resume an interrupted multipart upload of the file by uploading only its missing parts. The parts of a failed upload are kept
+
+	IncludeDotfiles bool   // Include hidden files and directories beginning with '.' when uploading a directory. Skipped by default
+	FollowSymlinks  bool   // Upload the files and walk the directories symlinks link to when uploading a directory. Skipped by default
+	SkipSymlink     bool   // Skip the upload with a warning if PathToFile is itself a symlink rather than uploading the file it links to
+	Ledger          string // Optional path of a local ledger of the files of a directory upload which completed. Recorded files which are unchanged are skipped
+	DirManifest     bool   // Upload a manifest of every file of a directory upload with the Merkle root of their checksums beside the directory
+	DeadLetter      string // Optional path of a local list of the files of a directory upload which still failed once retried. Removed once no file fails
+	RetryDeadLetter bool   // Upload only the files of the directory recorded in the dead letter
+	FileRetries     int    // Times a file of a directory upload which failed for any reason is uploaded again after MaxRetries
+
+	MaxDestinationConcurrency int // Maximum number of destinations UploadToDestinations uploads to at once. 0 uploads to every destination at once
+
+	ResultsFile       string // Optional path of a newline delimited JSON file which the upload result is appended to
+	SkipIfUnchanged   bool   // Skip the upload if the source checksum matches the checksum recorded on the most recent backup
+	SkipIfExists      bool   // Skip the upload if an object with the size and checksum of the source already exists under the final key
+	MaxFileBytes      int64  // Fail the upload if the source is larger than this many bytes. 0 disables the guard
+	Force             bool   // Upload the source even if it exceeds MaxFileBytes
+	StrongVerify      bool   // Verify the ETag of every uploaded part against the md5sum of the corresponding part of the source
+	VerifyChecksum    bool   // Verify the ETag of the uploaded object against the md5sum of the source, which is read again once the upload completes
+	QuickVerify       bool   // Compare the first and last bytes of the uploaded object, fetched with ranged GETs, with the source once the upload completes
+	ChecksumAlgorithm string // Upload with a checksum of the algorithm [CRC32C|SHA256] and verify it with GetObjectAttributes instead of the ETag
+	ChunkSize         int    // Average size (MiB) of the content defined chunks the source is split into by UploadChunked
+	SplitSize         int    // Size (MiB) of the volumes the source is split into by UploadSplit
+
+	Compression      string // Compression algorithm to compress the source with before upload, e.g. gzip, zstd. Empty disables compression
+	CompressionLevel int    // Compression level of the algorithm. 0 selects the default level
+
+	EncryptionKey []byte // Key material the source is encrypted with client side with AES-256-GCM after it is compressed. Empty disables encryption
+
+	ObjectLockLegalHoldStatus string // Legal hold to place on the uploaded object [ON|OFF]. Requires a bucket with object lock enabled
+
+	ServerSideEncryption string // Server side encryption of the uploaded object [AES256|aws:kms]. Empty leaves the object to the default encryption of the bucket
+	KMSKeyID             string // ID or ARN of the KMS key to encrypt the uploaded object with. Requires aws:kms, otherwise the AWS managed key is used
+	AllowSSEFallback     bool   // Upload again encrypted with SSE-S3 if the upload fails as the KMS key is unavailable, e.g. disabled or throttled
+
+	Tags                 map[string]string // Tags to place on the uploaded object. Values may contain the tokens {date}, {host} and {tier}
+	ACL                  string            // Canned ACL to apply to the uploaded object, e.g. bucket-owner-full-control
+	StorageClass         string            // Storage class of the uploaded object, e.g. STANDARD_IA or GLACIER. Empty leaves the object to STANDARD
+	StorageClassMismatch string            // What happens when the final key exists in another storage class [overwrite|keep|fail]. Defaults to overwrite
+	FinalizeAttributes   bool              // Check the attributes of multipart uploaded objects and apply any the provider did not apply
+
+	WebsiteRedirectLocation string // Redirect a website endpoint request for the object to this path in the bucket or URL
+	ContentType             string // Content type of the uploaded object. Empty detects it from the extension or contents of the file
+
+	ProgressFn func(bytesTransferred int64, totalBytes int64) // Optional function called with the progress as the source is read by the uploader
+
+	limiter *rateLimiter // Limits the uploads to MaxBytesPerSec. Shared by every upload run at once from a copy of the upload object
+	keyTime time.Time    // Time of the key fixed by WithKeyTime or by the first attempt of an upload, zero until it is fixed
 }
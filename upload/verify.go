@@ -0,0 +1,289 @@
+package upload
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/log"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Records the ETag S3 returns for each part as it is uploaded so that the parts can be verified
+// once the upload has completed without having to list the parts of the completed object
+type partETagRecorder struct {
+	mu    sync.Mutex
+	etags map[int64]string
+}
+
+func newPartETagRecorder() *partETagRecorder {
+	return &partETagRecorder{etags: make(map[int64]string)}
+}
+
+// Request option which is applied to every request made by the uploader
+func (r *partETagRecorder) requestOption(req *request.Request) {
+	req.Handlers.Complete.PushBack(func(req *request.Request) {
+		if req.Error != nil {
+			return
+		}
+		output, ok := req.Data.(*s3.UploadPartOutput)
+		if !ok {
+			return
+		}
+		input := req.Params.(*s3.UploadPartInput)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.etags[aws.Int64Value(input.PartNumber)] = strings.Trim(aws.StringValue(output.ETag), "\"")
+	})
+}
+
+// Hashes the file inline as it is read by the uploader so that verification does not need to read the file again.
+// The uploader reads a body which cannot seek sequentially in chunks of the part size, so the bytes written to the
+// hasher are split into parts by their offset in the file
+type inlineHasher struct {
+	partSize   int64
+	partOffset int64 // Number of bytes written to the current part
+	whole      hash.Hash
+	part       hash.Hash
+	partMD5s   [][]byte
+	finished   bool
+}
+
+func newInlineHasher(partSize int64) *inlineHasher {
+	return &inlineHasher{partSize: partSize, whole: md5.New(), part: md5.New()}
+}
+
+func (h *inlineHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	h.whole.Write(p)
+	for len(p) > 0 {
+		chunk := h.partSize - h.partOffset
+		if int64(len(p)) < chunk {
+			chunk = int64(len(p))
+		}
+		h.part.Write(p[:chunk])
+		h.partOffset += chunk
+		p = p[chunk:]
+
+		if h.partOffset == h.partSize {
+			h.partMD5s = append(h.partMD5s, h.part.Sum(nil))
+			h.part.Reset()
+			h.partOffset = 0
+		}
+	}
+	return n, nil
+}
+
+// Completes the final part. An empty file has a single empty part
+func (h *inlineHasher) finish() {
+	if h.finished {
+		return
+	}
+	h.finished = true
+	if h.partOffset > 0 || len(h.partMD5s) == 0 {
+		h.partMD5s = append(h.partMD5s, h.part.Sum(nil))
+	}
+}
+
+// Returns the hex encoded md5sum of each part read by the uploader
+func (h *inlineHasher) PartMD5s() []string {
+	h.finish()
+	md5s := []string{}
+	for _, sum := range h.partMD5s {
+		md5s = append(md5s, hex.EncodeToString(sum))
+	}
+	return md5s
+}
+
+// Returns the hex encoded md5sum of every byte read by the uploader
+func (h *inlineHasher) MD5() string {
+	h.finish()
+	return hex.EncodeToString(h.whole.Sum(nil))
+}
+
+// Returns the ETag S3 assigns to the object if it was uploaded with a multipart upload of these parts,
+// which is the md5sum of the concatenated binary md5sums of each part followed by the number of parts
+func (h *inlineHasher) CompositeETag() string {
+	h.finish()
+	composite := md5.New()
+	for _, sum := range h.partMD5s {
+		composite.Write(sum)
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(composite.Sum(nil)), len(h.partMD5s))
+}
+
+// Compares the ETag of every uploaded part against the md5sum of the corresponding part computed inline during the
+// upload along with the composite ETag of the object. If the file was uploaded with a single PUT then the object ETag
+// is compared against the md5sum of the whole file
+func strongVerify(hasher *inlineHasher, recorder *partETagRecorder, objectETag string) error {
+	localMD5s := hasher.PartMD5s()
+	etag := strings.Trim(objectETag, "\"")
+
+	recorder.mu.Lock()
+	remoteETags := make(map[int64]string)
+	for partNumber, etag := range recorder.etags {
+		remoteETags[partNumber] = etag
+	}
+	recorder.mu.Unlock()
+
+	if len(remoteETags) == 0 {
+		if len(localMD5s) > 1 {
+			return fmt.Errorf("strong verification failed: expected %d parts but no part ETags were recorded", len(localMD5s))
+		}
+		if etag != hasher.MD5() {
+			return fmt.Errorf("strong verification failed: object ETag '%s' does not match local md5sum '%s'", etag, hasher.MD5())
+		}
+		return nil
+	}
+
+	if len(remoteETags) != len(localMD5s) {
+		return fmt.Errorf("strong verification failed: uploaded %d parts but expected %d", len(remoteETags), len(localMD5s))
+	}
+
+	mismatchedParts := []int{}
+	for i, localMD5 := range localMD5s {
+		if remoteETags[int64(i+1)] != localMD5 {
+			mismatchedParts = append(mismatchedParts, i+1)
+		}
+	}
+
+	if len(mismatchedParts) > 0 {
+		sort.Ints(mismatchedParts)
+		return fmt.Errorf("strong verification failed: ETags of part(s) %v do not match the local file", mismatchedParts)
+	}
+
+	// Not every S3 compatible provider returns the composite ETag of a multipart upload
+	if strings.Contains(etag, "-") && etag != hasher.CompositeETag() {
+		return fmt.Errorf("strong verification failed: object ETag '%s' does not match the composite ETag '%s'", etag, hasher.CompositeETag())
+	}
+
+	return nil
+}
+
+// Returns the part size used by the uploader for the file. The part size is increased if the file would otherwise be
+// uploaded in more than the maximum number of parts, matching the behaviour of the uploader for files which can seek
+func getPartSize(fileSize int64, partSize int64) int64 {
+	if fileSize/partSize >= int64(s3manager.MaxUploadParts) {
+		return (fileSize / int64(s3manager.MaxUploadParts)) + 1
+	}
+	return partSize
+}
+
+// Returns the part size to configure the uploader with so that a file no larger than a single part is uploaded with a
+// single PUT, including an empty file. A body which cannot seek is read a part at a time, so a file of exactly the
+// part size would fill the first part without reaching the end of the file and be uploaded as a multipart upload of a
+// single part with a composite ETag. A larger file is never uploaded with an empty final part, even if its size is
+// an exact multiple of the part size, as the uploader completes the upload once a read returns no bytes
+func uploaderPartSize(fileSize int64, partSize int64) int64 {
+	if fileSize == partSize {
+		return partSize + 1
+	}
+	return partSize
+}
+
+// LocalETag is the ETag S3 assigns to a local file when it is uploaded with the part size
+type LocalETag struct {
+	ETag      string   // The md5sum of the file if it is uploaded with a single PUT, otherwise the composite ETag
+	PartSize  int64    // The part size used by the uploader, which may be larger than the requested part size
+	Multipart bool     // Whether the file is uploaded with a multipart upload
+	PartMD5s  []string // The hex encoded md5sum of each part, which S3 reports as the ETag of the part
+	MD5       string   // The hex encoded md5sum of the whole file
+}
+
+// ComputeETag returns the ETag S3 assigns to the file when it is uploaded by s3backup with the part size (bytes).
+// A file no larger than a single part is uploaded with a single PUT and its ETag is the md5sum of the file. The ETag
+// only matches an object which is not encrypted with SSE-KMS or SSE-C, as S3 does not use the md5sum for these
+func ComputeETag(pathToFile string, partSize int64) (LocalETag, error) {
+	if partSize < 1 {
+		return LocalETag{}, errors.New("part size must be greater than 0")
+	}
+
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return LocalETag{}, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return LocalETag{}, err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return LocalETag{}, fmt.Errorf("'%s' is not a regular file", pathToFile)
+	}
+
+	partSize = getPartSize(fileInfo.Size(), partSize)
+	return StreamETag(file, partSize, fileInfo.Size() > partSize)
+}
+
+// StreamETag returns the ETag S3 assigns to the bytes read from the reader when they are uploaded in parts of the
+// part size (bytes), or with a single PUT if multipart is false, without buffering more than a part
+func StreamETag(r io.Reader, partSize int64, multipart bool) (LocalETag, error) {
+	if partSize < 1 {
+		return LocalETag{}, errors.New("part size must be greater than 0")
+	}
+
+	hasher := newInlineHasher(partSize)
+	if _, err := io.Copy(hasher, r); err != nil {
+		return LocalETag{}, err
+	}
+
+	etag := LocalETag{PartSize: partSize, Multipart: multipart, PartMD5s: hasher.PartMD5s(), MD5: hasher.MD5()}
+	if etag.Multipart {
+		etag.ETag = hasher.CompositeETag()
+	} else {
+		etag.ETag = etag.MD5
+	}
+	return etag, nil
+}
+
+// Verifies the ETag of the uploaded object against the file, which is read again once the upload has completed. If
+// the file was uploaded with a single PUT then the ETag is compared against the md5sum of the file, otherwise against
+// the composite ETag of the parts of the part size. Returns the hex encoded md5sum of the file
+func verifyUploadedETag(ctx context.Context, pathToFile string, partSize int64, multipart bool, objectETag string) (string, error) {
+	etag := strings.Trim(objectETag, "\"")
+
+	if !multipart {
+		localMD5, err := computeHexMD5SumWithContext(ctx, pathToFile)
+		if err != nil {
+			return "", err
+		}
+		if etag != localMD5 {
+			return localMD5, fmt.Errorf("checksum verification failed: object ETag '%s' does not match local md5sum '%s'", etag, localMD5)
+		}
+		return localMD5, nil
+	}
+
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	localETag, err := StreamETag(&contextReader{ctx: ctx, reader: file}, partSize, true)
+	if err != nil {
+		return "", err
+	}
+
+	// Not every S3 compatible provider returns the composite ETag of a multipart upload
+	if !strings.Contains(etag, "-") {
+		log.Warn.Printf("Unable to verify the checksum of the multipart upload as the ETag '%s' is not a composite ETag\n", etag)
+		return localETag.MD5, nil
+	}
+	if etag != localETag.ETag {
+		return localETag.MD5, fmt.Errorf("checksum verification failed: object ETag '%s' does not match the composite ETag '%s' of %d parts of %d bytes",
+			etag, localETag.ETag, len(localETag.PartMD5s), partSize)
+	}
+	return localETag.MD5, nil
+}
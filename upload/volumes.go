@@ -0,0 +1,274 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/log"
+	"s3backup/s3client"
+	"s3backup/version"
+	"io"
+	"os"
+	"time"
+)
+
+// ArchiveFormatVolumes is the archive format of files uploaded with UploadSplit. The object stored under the key of the
+// backup is a VolumeIndex listing the volumes the file was split into rather than the contents of the file
+const ArchiveFormatVolumes = "volumes"
+
+// VolumeIndexVersion is the version of the index format written by UploadSplit
+const VolumeIndexVersion = 1
+
+// VolumeIndex lists the volumes a file was split into in the order they are reassembled
+type VolumeIndex struct {
+	Version    int           `json:"version"`
+	Size       int64         `json:"size"`
+	MD5        string        `json:"md5"`        // Hex encoded md5sum of the file
+	VolumeSize int64         `json:"volumeSize"` // Size of every volume but the last
+	Volumes    []IndexVolume `json:"volumes"`
+}
+
+// IndexVolume is a single volume of a file uploaded with UploadSplit
+type IndexVolume struct {
+	Key  string `json:"key"`
+	MD5  string `json:"md5"` // Hex encoded md5sum of the volume
+	Size int64  `json:"size"`
+}
+
+// UploadSplit splits the file at the path to file into volumes of the split size which are each uploaded as a separate
+// object under the volume dir, followed by an index of the volumes which is stored under the key of the backup. Unlike
+// a multipart upload, which is stored as a single object, each volume is an object of its own which stays within the
+// object size limit of the provider and can be downloaded on its own. A file smaller than the split size is uploaded
+// as a single volume. Returns the name of the key that the index was uploaded to
+func UploadSplit(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun bool) (string, error) {
+
+	if svc == nil {
+		return "", errors.New("svc must not be nil")
+	}
+
+	err := validationCheck(uploadObject)
+	if err != nil {
+		return "", err
+	}
+
+	err = splitValidationCheck(uploadObject)
+	if err != nil {
+		return "", err
+	}
+
+	uploadObject = withRateLimiter(uploadObject)
+
+	log.Info.Println(`
+	######################################
+	#        Split Upload Started        #
+	######################################
+	`)
+
+	// Context provides a timeout with AWS SDK calls 'WithContext'
+	ctx := context.Background()
+	if uploadObject.Timeout > 0 {
+		var cancelFn func()
+		ctx, cancelFn = context.WithTimeout(ctx, uploadObject.Timeout)
+		defer cancelFn()
+	}
+
+	file, err := os.Open(uploadObject.PathToFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	fileInfo, _ := file.Stat()
+	fileSize := fileInfo.Size()
+
+	if uploadObject.MaxFileBytes > 0 && fileSize > uploadObject.MaxFileBytes {
+		if !uploadObject.Force {
+			return "", fmt.Errorf("file '%s' is %d bytes which exceeds the maximum file size of %d bytes, "+
+				"use --force to upload it anyway", uploadObject.PathToFile, fileSize, uploadObject.MaxFileBytes)
+		}
+		log.Warn.Printf("File '%s' is %d bytes which exceeds the maximum file size of %d bytes. "+
+			"Uploading anyway as force has been enabled\n", uploadObject.PathToFile, fileSize, uploadObject.MaxFileBytes)
+	}
+
+	// The key and tags of the volumes use the same time
+	uploadObject, _, err = WithKeyTime(uploadObject)
+	if err != nil {
+		return "", err
+	}
+
+	s3FileName, err := getS3FileName(uploadObject, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	uploadObject, err = resolveStorageClassMismatch(svc, uploadObject, s3FileName)
+	if err != nil {
+		return "", err
+	}
+
+	if len(uploadObject.Tags) > 0 {
+		keyTime, err := GetKeyTime(uploadObject)
+		if err != nil {
+			return "", err
+		}
+		uploadObject.Tags, err = renderTags(uploadObject.Tags, keyTime, prefix)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	volumeSize := int64(uploadObject.SplitSize) * 1024 * 1024
+	volumeCount := int((fileSize + volumeSize - 1) / volumeSize)
+	if volumeCount == 0 {
+		volumeCount = 1 // An empty file is a single empty volume
+	}
+
+	log.Info.Printf("Uploading '%s' (%d bytes) in %d volumes of up to %d bytes to s3 bucket '%s'\n",
+		uploadObject.PathToFile, fileSize, volumeCount, volumeSize, uploadObject.Bucket)
+
+	startTime := time.Now()
+
+	hash := md5.New()
+	index := VolumeIndex{Version: VolumeIndexVersion, Size: fileSize, VolumeSize: volumeSize}
+
+	uploader := s3manager.NewUploaderWithClient(svc, func(u *s3manager.Uploader) {
+		u.PartSize = uploaderPartSize(volumeSize, int64(uploadObject.PartSize*1024*1024))
+		u.Concurrency = partWorkers(uploadObject)
+		u.RequestOptions = append(u.RequestOptions, sortCompletedParts)
+		u.RequestOptions = append(u.RequestOptions, rateLimitOptions(uploadObject)...)
+	})
+
+	for i := 0; i < volumeCount; i++ {
+		offset := int64(i) * volumeSize
+		size := volumeSize
+		if offset+size > fileSize {
+			size = fileSize - offset
+		}
+
+		volumeHash := md5.New()
+		if _, err = io.Copy(io.MultiWriter(hash, volumeHash), io.NewSectionReader(file, offset, size)); err != nil {
+			break
+		}
+		volume := IndexVolume{Key: s3client.VolumeKey(uploadObject.BucketDir, s3FileName, i+1), MD5: hex.EncodeToString(volumeHash.Sum(nil)), Size: size}
+		index.Volumes = append(index.Volumes, volume)
+
+		if dryRun {
+			log.Info.Printf("Skipping upload of volume: '%s' (%d bytes) as dry run has been enabled\n", volume.Key, size)
+			continue
+		}
+
+		log.Info.Printf("Uploading volume %d of %d: '%s' (%d bytes)\n", i+1, volumeCount, volume.Key, size)
+		uploadParams := &s3manager.UploadInput{
+			Bucket:   aws.String(uploadObject.Bucket),
+			Key:      aws.String(volume.Key),
+			Body:     withProgress(io.NewSectionReader(file, offset, size), uploadObject.ProgressFn, size),
+			Metadata: map[string]*string{VersionMetadataKey: aws.String(version.Version), ChecksumMetadataKey: aws.String(volume.MD5)},
+		}
+		applyObjectAttributes(uploadParams, uploadObject)
+
+		if _, err = uploader.UploadWithContext(ctx, uploadParams); err != nil {
+			err = fmt.Errorf("failed to upload volume '%s': %v", volume.Key, err)
+			break
+		}
+	}
+
+	index.MD5 = hex.EncodeToString(hash.Sum(nil))
+
+	if err == nil {
+		if dryRun {
+			log.Info.Printf("Skipping upload of volume index: '%s' as dry run has been enabled\n", s3FileName)
+		} else {
+			err = putVolumeIndex(ctx, svc, uploadObject, s3FileName, index)
+		}
+	}
+
+	// The volumes of a failed upload are never referenced by an index, so they are removed rather than left behind
+	if err != nil && !dryRun {
+		if removed, deleteErr := s3client.DeleteVolumes(svc, uploadObject.Bucket, uploadObject.BucketDir, s3FileName); deleteErr != nil {
+			log.Error.Printf("Failed to remove the volumes of the failed upload of key: '%s', they must be removed manually. Reason: %v\n", s3FileName, deleteErr)
+		} else if removed > 0 {
+			log.Info.Printf("Removed %d volumes of the failed upload of key: '%s'\n", removed, s3FileName)
+		}
+	}
+
+	elapsedTime := time.Since(startTime).Seconds()
+
+	log.Info.Printf("Total time spent processing upload: %0.2f seconds\n", elapsedTime)
+
+	result := UploadResult{Key: s3FileName, Bytes: fileSize, Duration: elapsedTime, Checksum: index.MD5, Status: ResultStatusSuccess}
+	if err != nil {
+		result.Status = ResultStatusFailed
+		result.Error = err.Error()
+	} else if dryRun {
+		result.Status = ResultStatusDryRun
+	}
+	recordResult(uploadObject, result)
+
+	if err != nil {
+		return "", err
+	}
+
+	return s3FileName, nil
+}
+
+// Uploads the index to the key of the backup along with the attributes of the upload object
+func putVolumeIndex(ctx context.Context, svc *s3.S3, uploadObject UploadObject, s3FileName string, index VolumeIndex) error {
+	body, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	uploadParams := &s3manager.UploadInput{
+		Bucket:      aws.String(uploadObject.Bucket),
+		Key:         aws.String(s3FileName),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+		Metadata:    map[string]*string{ArchiveMetadataKey: aws.String(ArchiveFormatVolumes), VersionMetadataKey: aws.String(version.Version)},
+	}
+
+	applyObjectAttributes(uploadParams, uploadObject)
+
+	log.Info.Printf("Uploading index of %d volumes to key: '%s'\n", len(index.Volumes), s3FileName)
+	_, err = s3manager.NewUploaderWithClient(svc).UploadWithContext(ctx, uploadParams)
+	return err
+}
+
+func splitValidationCheck(uploadObject UploadObject) error {
+	if uploadObject.SplitSize < 1 {
+		return errors.New("split size must be at least 1MiB")
+	}
+
+	if uploadObject.ChunkSize > 0 {
+		return errors.New("split uploads cannot be chunked, specify either a split size or a chunk size")
+	}
+
+	if uploadObject.Compression != "" {
+		return errors.New("compression is not supported with split uploads as each volume must be a range of the file")
+	}
+
+	if len(uploadObject.EncryptionKey) > 0 {
+		return errors.New("encryption is not supported with split uploads as each volume must be a range of the file")
+	}
+
+	if uploadObject.StrongVerify || uploadObject.VerifyChecksum || uploadObject.SkipIfUnchanged || uploadObject.ChecksumAlgorithm != "" {
+		return errors.New("strong verify, verify checksum, checksum algorithm and skip if unchanged are not supported with split uploads as " +
+			"the md5sum of every volume is recorded in the index and verified when the volumes are reassembled")
+	}
+
+	if uploadObject.Resume || uploadObject.SkipIfExists {
+		return errors.New("resume and skip if exists are not supported with split uploads")
+	}
+
+	if isStdin(uploadObject) {
+		return errors.New("stdin cannot be split into volumes as the size of each volume must be known before it is uploaded")
+	}
+
+	return nil
+}
@@ -0,0 +1,290 @@
+package upload
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"s3backup/log"
+	"s3backup/version"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveMetadataKey is the user metadata key that the archive format of an uploaded directory is recorded under
+const ArchiveMetadataKey = "S3backup-Archive"
+
+// ArchiveFormatZip is the archive format of directories uploaded with UploadZip
+const ArchiveFormatZip = "zip"
+
+// UploadZip streams the directory at the path to file into a zip archive which is uploaded to S3 as a single object.
+// The archive is piped directly to the multipart uploader so no temporary file is written. Paths within the archive
+// are relative to the directory. Returns the name of the key that was uploaded which has the '.zip' extension appended
+func UploadZip(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun bool) (string, error) {
+
+	if svc == nil {
+		return "", errors.New("svc must not be nil")
+	}
+
+	err := validationCheck(uploadObject)
+	if err != nil {
+		return "", err
+	}
+
+	err = zipValidationCheck(uploadObject)
+	if err != nil {
+		return "", err
+	}
+
+	uploadObject = withRateLimiter(uploadObject)
+
+	log.Info.Println(`
+	######################################
+	#       Archive Upload Started       #
+	######################################
+	`)
+
+	// Context provides a timeout with AWS SDK calls 'WithContext'
+	ctx := context.Background()
+	if uploadObject.Timeout > 0 {
+		var cancelFn func()
+		ctx, cancelFn = context.WithTimeout(ctx, uploadObject.Timeout)
+		defer cancelFn()
+	}
+
+	dirSize, err := getDirSize(uploadObject.PathToFile, uploadObject.IncludeDotfiles)
+	if err != nil {
+		return "", err
+	}
+
+	if uploadObject.MaxFileBytes > 0 && dirSize > uploadObject.MaxFileBytes {
+		if !uploadObject.Force {
+			return "", fmt.Errorf("directory '%s' is %d bytes which exceeds the maximum file size of %d bytes, "+
+				"use --force to upload it anyway", uploadObject.PathToFile, dirSize, uploadObject.MaxFileBytes)
+		}
+		log.Warn.Printf("Directory '%s' is %d bytes which exceeds the maximum file size of %d bytes. "+
+			"Uploading anyway as force has been enabled\n", uploadObject.PathToFile, dirSize, uploadObject.MaxFileBytes)
+	}
+
+	s3FileName, err := getS3FileName(uploadObject, prefix)
+	if err != nil {
+		return "", err
+	}
+	s3FileName += ".zip"
+
+	uploadObject, err = resolveStorageClassMismatch(svc, uploadObject, s3FileName)
+	if err != nil {
+		return "", err
+	}
+
+	log.Info.Printf("Uploading directory '%s' (%d bytes) as zip archive to s3 bucket '%s'\n", uploadObject.PathToFile, dirSize, uploadObject.Bucket)
+
+	// The md5sum and size of the archive are computed as it is streamed
+	hash := md5.New()
+	counter := &countingWriter{}
+
+	pipeReader, pipeWriter := io.Pipe()
+	zipFinishedCh := make(chan bool)
+	go func() {
+		pipeWriter.CloseWithError(writeZip(io.MultiWriter(pipeWriter, hash, counter), uploadObject.PathToFile, uploadObject.IncludeDotfiles))
+		zipFinishedCh <- true
+	}()
+
+	partSize := int64(uploadObject.PartSize * 1024 * 1024)
+
+	log.Info.Printf("Upload part size is: %d bytes\n", partSize)
+	log.Info.Printf("Uploading is about to begin with a maximum of %d workers\n", uploadObject.NumWorkers)
+
+	uploader := s3manager.NewUploaderWithClient(svc, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = partWorkers(uploadObject)
+		u.LeavePartsOnError = false
+		u.RequestOptions = append(u.RequestOptions, sortCompletedParts)
+		u.RequestOptions = append(u.RequestOptions, rateLimitOptions(uploadObject)...)
+	})
+
+	uploadParams := &s3manager.UploadInput{
+		Bucket:      aws.String(uploadObject.Bucket),
+		Key:         aws.String(s3FileName),
+		Body:        withProgress(pipeReader, uploadObject.ProgressFn, dirSize), // The archive is compressed so the total is an upper bound
+		ContentType: aws.String("application/zip"),
+		Metadata:    map[string]*string{ArchiveMetadataKey: aws.String(ArchiveFormatZip), VersionMetadataKey: aws.String(version.Version)},
+	}
+
+	applyObjectAttributes(uploadParams, uploadObject)
+
+	startTime := time.Now()
+
+	multipart := false
+	if dryRun {
+		log.Info.Printf("Skipping upload of key: '%s' as dry run has been enabled. The archive is still built to determine its size\n", s3FileName)
+		_, err = io.Copy(ioutil.Discard, pipeReader)
+	} else {
+		var output *s3manager.UploadOutput
+		output, err = uploader.UploadWithContext(ctx, uploadParams)
+		multipart = err == nil && output.UploadID != ""
+	}
+	pipeReader.CloseWithError(err) // Stops the zip writer if the upload failed before the archive was fully read
+	<-zipFinishedCh
+
+	if multipart && uploadObject.FinalizeAttributes {
+		log.Info.Printf("Checking the attributes of multipart uploaded key: '%s'\n", s3FileName)
+		err = finalizeObjectAttributes(svc, uploadParams)
+	}
+
+	elapsedTime := time.Since(startTime).Seconds()
+
+	log.Info.Printf("Total time spent processing upload: %0.2f seconds\n", elapsedTime)
+
+	result := UploadResult{Key: s3FileName, Bytes: counter.n, Duration: elapsedTime, Checksum: hex.EncodeToString(hash.Sum(nil)), Status: ResultStatusSuccess}
+	if err != nil {
+		result.Status = ResultStatusFailed
+		result.Error = err.Error()
+	} else if dryRun {
+		result.Status = ResultStatusDryRun
+	}
+	recordResult(uploadObject, result)
+
+	if err != nil {
+		return "", err
+	}
+
+	log.Info.Printf("Uploaded zip archive of '%s' (%d bytes) to key: '%s'\n", uploadObject.PathToFile, counter.n, s3FileName)
+
+	return s3FileName, nil
+}
+
+// Writes every file and directory under the directory to a zip archive with paths relative to the directory.
+// The zip writer records the offset of each entry as it is written and writes the central directory on close,
+// with the size and checksum of each entry written in a data descriptor after its data so the output never needs to seek
+func writeZip(w io.Writer, dir string, includeDotfiles bool) error {
+	zipWriter := zip.NewWriter(w)
+
+	err := walkDir(dir, includeDotfiles, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			log.Warn.Printf("Skipping '%s' as it is not a regular file\n", path)
+			return nil
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+
+		entry, err := zipWriter.CreateHeader(header)
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(entry, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+// Returns the total size of every regular file under the directory which would be included in the archive
+func getDirSize(dir string, includeDotfiles bool) (int64, error) {
+	var size int64
+	err := walkDir(dir, includeDotfiles, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// Walks the directory in the same way as filepath.Walk. Unless include dotfiles is enabled, hidden files beginning
+// with '.' are skipped and hidden directories such as .git are pruned so that their contents are never walked.
+// The directory itself is always walked even if it is hidden
+func walkDir(dir string, includeDotfiles bool, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if !includeDotfiles && info != nil && path != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return walkFn(path, info, err)
+	})
+}
+
+func zipValidationCheck(uploadObject UploadObject) error {
+	fileInfo, err := os.Stat(uploadObject.PathToFile)
+	if err != nil {
+		return err
+	}
+
+	if !fileInfo.IsDir() {
+		return errors.New("path to file must be a directory to upload it as a zip archive")
+	}
+
+	if uploadObject.Compression != "" {
+		return errors.New("compression is not supported with zip archives as the contents are already compressed")
+	}
+
+	if uploadObject.StrongVerify || uploadObject.VerifyChecksum || uploadObject.SkipIfUnchanged {
+		return errors.New("strong verify, verify checksum and skip if unchanged are not supported with zip archives")
+	}
+
+	if len(uploadObject.EncryptionKey) > 0 {
+		return errors.New("encryption is not supported with zip archives as the archive is streamed as it is written")
+	}
+
+	if uploadObject.Resume {
+		return errors.New("resume is not supported with zip archives as the archive is streamed as it is written")
+	}
+
+	if uploadObject.SkipIfExists {
+		return errors.New("skip if exists is not supported with zip archives as the checksum of the archive is not known until it is written")
+	}
+
+	return nil
+}
+
+// Counts the number of bytes written
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
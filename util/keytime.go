@@ -0,0 +1,90 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"s3backup/s3client"
+	"strings"
+	"time"
+)
+
+// DefaultKeyTimeLayout is the layout of the timestamp appended to the key of a backup, e.g. daily_photos_20240131T020000
+const DefaultKeyTimeLayout = "20060102T150405"
+
+// The numeric elements a key time layout may contain and the digits each is formatted as. Longer elements are matched
+// first so that the year 2006 is not taken for the day 02 followed by the two digit year 06
+var keyTimeElements = []struct {
+	element string
+	pattern string
+}{
+	{"2006", `\d{4}`},
+	{".000", `\.\d{3}`},
+	{"01", `\d{2}`},
+	{"02", `\d{2}`},
+	{"15", `\d{2}`},
+	{"04", `\d{2}`},
+	{"05", `\d{2}`},
+	{"06", `\d{2}`},
+}
+
+// NewKeyTimeParser returns a parser of the timestamp in the layout which s3backup appends to the key of a backup after
+// an underscore, optionally followed by the extension of a compressed object or archive, e.g. _20240131T020000.zst.
+// The layout is a Go time layout of fixed width numeric elements such as 2006-01-02_1504, and the timestamp is parsed
+// in the location it was formatted in, the local time zone if the location is nil. The default layout is used if the
+// layout is empty
+func NewKeyTimeParser(layout string, location *time.Location) (s3client.KeyTimeParser, error) {
+	if layout == "" {
+		layout = DefaultKeyTimeLayout
+	}
+	if location == nil {
+		location = time.Local
+	}
+
+	var pattern strings.Builder
+	for rest := layout; rest != ""; {
+		matched := false
+		for _, e := range keyTimeElements {
+			if strings.HasPrefix(rest, e.element) {
+				pattern.WriteString(e.pattern)
+				rest = rest[len(e.element):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			pattern.WriteString(regexp.QuoteMeta(rest[:1]))
+			rest = rest[1:]
+		}
+	}
+	keyTimeRegex, err := regexp.Compile(`_(` + pattern.String() + `)(\.[^/]+)?$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key time layout '%s': %v", layout, err)
+	}
+
+	parse := func(key string) (time.Time, bool) {
+		match := keyTimeRegex.FindStringSubmatch(key)
+		if match == nil {
+			return time.Time{}, false
+		}
+		keyTime, err := time.ParseInLocation(layout, match[1], location)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return keyTime, true
+	}
+
+	// Any element other than the numeric elements, e.g. Jan or 3, formats differently from its literal and is rejected,
+	// as is a layout which does not record the date as its keys would not be ordered by the day they were made
+	for _, t := range []time.Time{
+		time.Date(2001, time.February, 3, 4, 5, 6, 7000000, location),
+		time.Date(2024, time.November, 23, 17, 48, 59, 999000000, location),
+	} {
+		formatted := t.Format(layout)
+		parsed, ok := parse("backup_" + formatted)
+		if !ok || parsed.Format(layout) != formatted || parsed.YearDay() != t.YearDay() || parsed.Year() != t.Year() {
+			return nil, fmt.Errorf("invalid key time layout '%s', expected a layout of the numeric elements 2006 01 02 15 04 05 and optionally .000 "+
+				"which records at least the date e.g. %s", layout, DefaultKeyTimeLayout)
+		}
+	}
+	return parse, nil
+}
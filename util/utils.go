@@ -3,6 +3,7 @@ package util
 import (
 	"crypto/md5"
 	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"s3backup/rpolicy"
@@ -10,9 +11,13 @@ import (
 	"github.com/jinzhu/now"
 	"io"
 	"os"
+	"reflect"
 	"regexp"
+	"runtime"
+	"sort"
 	"time"
 	"strconv"
+	"strings"
 )
 
 // CheckPrefix checks if the prefix of a string matches the specified prefix.
@@ -36,6 +41,12 @@ func CleanUpMultiPartUploads(svc *s3.S3, bucket string) error {
 
 // RetrieveSortedKeysByTime is a helper function to get all sorted keys
 func RetrieveSortedKeysByTime(svc *s3.S3, bucket string, prefix string, bucketDir string) ([]s3client.BucketEntry, error) {
+	return RetrieveSortedKeysByKeyTime(svc, bucket, prefix, bucketDir, nil)
+}
+
+// RetrieveSortedKeysByKeyTime returns every key under the prefix sorted by the time the parser finds in each key, or by
+// the last modified time of keys which do not embed a time
+func RetrieveSortedKeysByKeyTime(svc *s3.S3, bucket string, prefix string, bucketDir string, parse s3client.KeyTimeParser) ([]s3client.BucketEntry, error) {
 	keys, err := s3client.GetKeysByPrefix(svc, bucket, bucketDir + prefix)
 	if err != nil {
 		return nil, err
@@ -45,11 +56,26 @@ func RetrieveSortedKeysByTime(svc *s3.S3, bucket string, prefix string, bucketDi
 	if numKeys == 0 {
 		return nil, nil
 	}
-	return s3client.SortKeysByTime(keys), nil
+	return s3client.SortKeysByKeyTime(keys, parse), nil
 }
 
-// GetKeyType returns the specified key type (_monthly, _weekly, _daily) for a particular time
+// GetKeyType returns the specified key type (_yearly, _monthly, _weekly, _daily) for a particular time. The date of the
+// time is taken in the location of the policy if it has one, otherwise in the location of the time
 func GetKeyType(policy rpolicy.RotationPolicy, keyTime time.Time) string {
+	if policy.ForceTier != "" {
+		// The tier has been forced e.g. for an ad-hoc backup
+		return policy.ForceTier
+	}
+
+	if policy.Location != nil {
+		keyTime = keyTime.In(policy.Location)
+	}
+
+	if policy.YearlyPrefix != "" && keyTime.YearDay() == 1 {
+		// This is a yearly backup as it falls on the first day of the year, which is also the first day of a month
+		return policy.YearlyPrefix
+	}
+
 	monthlyYear, monthlyMonth, monthlyDay := now.New(keyTime).BeginningOfMonth().Date()
 
 	keyTimeYear, keyTimeMonth, keyTimeDay := keyTime.Date()
@@ -68,6 +94,19 @@ func GetKeyType(policy rpolicy.RotationPolicy, keyTime time.Time) string {
 	return policy.DailyPrefix
 }
 
+// ResolveTier returns the prefix of the tier (daily, weekly, monthly or yearly) of the policy. The prefix itself, e.g.
+// monthly_, is also accepted. Returns an error if the tier is not one of the prefixes of the policy
+func ResolveTier(policy rpolicy.RotationPolicy, tier string) (string, error) {
+	tiers := []string{}
+	for _, prefix := range policy.Prefixes() {
+		if tier == prefix || tier+"_" == prefix {
+			return prefix, nil
+		}
+		tiers = append(tiers, strings.TrimSuffix(prefix, "_"))
+	}
+	return "", fmt.Errorf("invalid tier '%s', expected one of the tiers [%s]", tier, strings.Join(tiers, "|"))
+}
+
 // FindKeyInBucket returns true if the specified key exists in the *s3.ListObjectOutput; otherwise false
 func FindKeyInBucket(keyToFind string, bucketContents *s3.ListObjectsOutput) bool {
 	for _, key := range bucketContents.Contents {
@@ -222,3 +261,168 @@ func GetEnvFloat(key string, defaultVal float64) (float64, error) {
 	value, err := strconv.ParseFloat(envvalue, 64)
 	return value, err
 }
+
+// SetFieldsFromEnv sets each string, bool, int and string slice field of the struct pointed to by config from the env-var
+// named by the prefix followed by the upper case name of the field, e.g. S3BACKUP_PATHTOFILE for the field PathToFile.
+// The values of a string slice are separated by a comma. Fields whose env-var hasn't been set keep their current value
+func SetFieldsFromEnv(prefix string, config interface{}) error {
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		key := prefix + strings.ToUpper(v.Type().Field(i).Name)
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(GetEnvString(key, field.String()))
+		case reflect.Bool:
+			value, err := GetEnvBool(key, field.Bool())
+			if err != nil {
+				return fmt.Errorf("invalid value for %s, expected true or false: '%s'", key, os.Getenv(key))
+			}
+			field.SetBool(value)
+		case reflect.Int:
+			value, err := GetEnvInt(key, int(field.Int()))
+			if err != nil {
+				return fmt.Errorf("invalid value for %s, expected an integer: '%s'", key, os.Getenv(key))
+			}
+			field.SetInt(int64(value))
+		case reflect.Slice:
+			if value, ok := os.LookupEnv(key); ok && field.Type().Elem().Kind() == reflect.String {
+				field.Set(reflect.ValueOf(strings.Split(value, ",")))
+			}
+		}
+	}
+	return nil
+}
+
+// ParseByteSize parses a human-readable size such as "500MB", "2GiB" or "1024" into bytes.
+// Units are case insensitive and are powers of 1024 (KB and KiB are both 1024 bytes). A size without a unit is in bytes
+func ParseByteSize(size string) (int64, error) {
+	re := regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)\s*([a-zA-Z]*)\s*$`)
+	matches := re.FindStringSubmatch(size)
+	if matches == nil {
+		return 0, errors.New("invalid size specified: '" + size + "'")
+	}
+
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	multipliers := map[string]float64{
+		"":  1,
+		"b": 1,
+		"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+		"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+		"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+		"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+	}
+
+	multiplier, ok := multipliers[strings.ToLower(matches[2])]
+	if !ok {
+		return 0, errors.New("invalid size unit specified: '" + size + "'")
+	}
+
+	return int64(value * multiplier), nil
+}
+
+// AutoConcurrencyPerCPU is the number of workers used per CPU when the concurrency is "auto"
+const AutoConcurrencyPerCPU = 2
+
+// MaxAutoConcurrency caps the number of workers used when the concurrency is "auto"
+const MaxAutoConcurrency = 32
+
+// ResolveConcurrency returns the number of workers for a concurrency of either an explicit integer or "auto".
+// "auto" resolves to AutoConcurrencyPerCPU workers per available CPU capped at MaxAutoConcurrency
+func ResolveConcurrency(concurrency string) (int, error) {
+	if strings.EqualFold(strings.TrimSpace(concurrency), "auto") {
+		return AutoConcurrency(runtime.NumCPU()), nil
+	}
+
+	workers, err := strconv.Atoi(strings.TrimSpace(concurrency))
+	if err != nil {
+		return 0, errors.New("invalid concurrency specified, expected an integer or 'auto': '" + concurrency + "'")
+	}
+
+	return workers, nil
+}
+
+// AutoConcurrency returns the number of workers used for the number of CPUs when the concurrency is "auto"
+func AutoConcurrency(numCPU int) int {
+	workers := numCPU * AutoConcurrencyPerCPU
+	if workers > MaxAutoConcurrency {
+		return MaxAutoConcurrency
+	}
+	if workers < 1 {
+		return 1
+	}
+	return workers
+}
+
+// TransferProfile is a named combination of transfer settings suited to a kind of network
+type TransferProfile struct {
+	PartSize          int    // Part size of multipart uploads and downloads (MB)
+	ConcurrentWorkers string // Number of workers transferring parts at once
+	BufferSize        int    // Size of the buffer each part is read or written through (KB). 0 disables buffering
+}
+
+// TransferProfiles are the transfer profiles selectable by name
+var TransferProfiles = map[string]TransferProfile{
+	"lan":         {PartSize: 64, ConcurrentWorkers: "16", BufferSize: 1024}, // Large parts over a fast link with a low latency
+	"wan":         {PartSize: 16, ConcurrentWorkers: "8", BufferSize: 256},   // Smaller parts which are cheaper to retry over a slower link
+	"highlatency": {PartSize: 32, ConcurrentWorkers: "32", BufferSize: 512},  // Many parts in flight at once to hide the round trip time
+	"lowmem":      {PartSize: 5, ConcurrentWorkers: "2", BufferSize: 0},      // The minimum part size with few workers and no buffers
+}
+
+// GetTransferProfile returns the transfer profile of the name, which is case insensitive
+func GetTransferProfile(name string) (TransferProfile, error) {
+	profile, ok := TransferProfiles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		names := []string{}
+		for profileName := range TransferProfiles {
+			names = append(names, profileName)
+		}
+		sort.Strings(names)
+		return TransferProfile{}, errors.New("invalid transfer profile '" + name + "', expected one of: " + strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// ParseTags parses a comma separated list of key=value pairs such as "app=myservice,env=prod" into a map
+func ParseTags(tags string) (map[string]string, error) {
+	return ParseKeyValues(tags)
+}
+
+// ParseTagPairs parses key=value pairs such as those of repeated --tag flags into a map. Each pair is a single tag, so
+// its value is everything after the first equals sign. A key specified again replaces the earlier value
+func ParseTagPairs(pairs []string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, errors.New("invalid tag specified, expected key=value: '" + pair + "'")
+		}
+		parsed[key] = strings.TrimSpace(kv[1])
+	}
+	return parsed, nil
+}
+
+// ParseKeyValues parses a comma separated list of key=value pairs such as "s3=https://gateway:9000,sts=https://gateway:9001" into a map
+func ParseKeyValues(pairs string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	if strings.TrimSpace(pairs) == "" {
+		return parsed, nil
+	}
+
+	for _, pair := range strings.Split(pairs, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, errors.New("invalid pair specified, expected key=value: '" + pair + "'")
+		}
+		parsed[key] = strings.TrimSpace(kv[1])
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,363 @@
+package util
+
+import (
+	"fmt"
+	"s3backup/rpolicy"
+	"s3backup/s3client"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	expectedSizes := map[string]int64{
+		"1024":   1024,
+		"10B":    10,
+		"1KB":    1024,
+		"1kib":   1024,
+		"500MB":  500 * 1024 * 1024,
+		"2G":     2 * 1024 * 1024 * 1024,
+		"1.5GiB": 1536 * 1024 * 1024,
+		"1 TB":   1024 * 1024 * 1024 * 1024,
+	}
+
+	for size, expected := range expectedSizes {
+		parsed, err := ParseByteSize(size)
+		if err != nil {
+			t.Error(fmt.Sprintf("expected to parse size '%s' without any error: %v", size, err))
+		}
+		if parsed != expected {
+			t.Error(fmt.Sprintf("expected size '%s' to be %d bytes, got %d", size, expected, parsed))
+		}
+	}
+}
+
+func TestParseByteSizeInvalid(t *testing.T) {
+	for _, size := range []string{"", "MB", "-1GB", "10XB", "1.2.3MB"} {
+		_, err := ParseByteSize(size)
+		if err == nil {
+			t.Error("expected error when parsing invalid size: " + size)
+		}
+	}
+}
+
+func TestResolveConcurrencyAuto(t *testing.T) {
+	for _, concurrency := range []string{"auto", "AUTO", " auto "} {
+		workers, err := ResolveConcurrency(concurrency)
+		if err != nil {
+			t.Error(fmt.Sprintf("expected to resolve concurrency '%s' without any error: %v", concurrency, err))
+		}
+
+		expected := runtime.NumCPU() * AutoConcurrencyPerCPU
+		if expected > MaxAutoConcurrency {
+			expected = MaxAutoConcurrency
+		}
+		if workers != expected {
+			t.Error(fmt.Sprintf("expected concurrency '%s' to resolve to %d workers for %d CPUs, got %d", concurrency, expected, runtime.NumCPU(), workers))
+		}
+	}
+
+	expectedWorkers := map[int]int{1: 2, 4: 8, 16: 32, 64: 32}
+	for numCPU, expected := range expectedWorkers {
+		if workers := AutoConcurrency(numCPU); workers != expected {
+			t.Error(fmt.Sprintf("expected %d CPUs to resolve to %d workers, got %d", numCPU, expected, workers))
+		}
+	}
+}
+
+func TestResolveConcurrencyExplicit(t *testing.T) {
+	for concurrency, expected := range map[string]int{"1": 1, "5": 5, "100": 100} {
+		workers, err := ResolveConcurrency(concurrency)
+		if err != nil {
+			t.Error(fmt.Sprintf("expected to resolve concurrency '%s' without any error: %v", concurrency, err))
+		}
+		if workers != expected {
+			t.Error(fmt.Sprintf("expected concurrency '%s' to resolve to %d workers, got %d", concurrency, expected, workers))
+		}
+	}
+
+	for _, concurrency := range []string{"", "two", "1.5", "auto2"} {
+		if _, err := ResolveConcurrency(concurrency); err == nil {
+			t.Error("expected error when resolving invalid concurrency: " + concurrency)
+		}
+	}
+}
+
+func TestGetTransferProfile(t *testing.T) {
+	documented := map[string]TransferProfile{
+		"lan":         {PartSize: 64, ConcurrentWorkers: "16", BufferSize: 1024},
+		"wan":         {PartSize: 16, ConcurrentWorkers: "8", BufferSize: 256},
+		"highlatency": {PartSize: 32, ConcurrentWorkers: "32", BufferSize: 512},
+		"lowmem":      {PartSize: 5, ConcurrentWorkers: "2", BufferSize: 0},
+	}
+
+	for name, expected := range documented {
+		for _, spelling := range []string{name, strings.ToUpper(name), " " + name + " "} {
+			profile, err := GetTransferProfile(spelling)
+			if err != nil {
+				t.Error(fmt.Sprintf("expected to resolve transfer profile '%s' without any error: %v", spelling, err))
+			}
+			if profile != expected {
+				t.Error(fmt.Sprintf("expected transfer profile '%s' to resolve to %+v, got %+v", spelling, expected, profile))
+			}
+		}
+	}
+
+	if len(TransferProfiles) != len(documented) {
+		t.Error(fmt.Sprintf("expected only the %d documented transfer profiles but got %d", len(documented), len(TransferProfiles)))
+	}
+
+	for _, invalid := range []string{"", "fast", "lan,wan"} {
+		if _, err := GetTransferProfile(invalid); err == nil {
+			t.Error("expected error when resolving invalid transfer profile: " + invalid)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags("app=myservice, env = prod,empty=")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to parse tags without any error: %v", err))
+	}
+
+	expected := map[string]string{"app": "myservice", "env": "prod", "empty": ""}
+	if fmt.Sprint(tags) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected tags %v but got %v", expected, tags))
+	}
+
+	for _, invalid := range []string{"app", "=value", "app=myservice,"} {
+		if _, err := ParseTags(invalid); err == nil {
+			t.Error("expected error when parsing invalid tags: " + invalid)
+		}
+	}
+}
+
+func TestParseTagPairs(t *testing.T) {
+	tags, err := ParseTagPairs([]string{"app=myservice", " env = prod", "query=a=b", "app=override", "empty="})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to parse tags without any error: %v", err))
+	}
+
+	expected := map[string]string{"app": "override", "env": "prod", "query": "a=b", "empty": ""}
+	if fmt.Sprint(tags) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected tags %v but got %v", expected, tags))
+	}
+
+	for _, invalid := range []string{"app", "=value", ""} {
+		if _, err := ParseTagPairs([]string{invalid}); err == nil {
+			t.Error("expected error when parsing invalid tag: " + invalid)
+		}
+	}
+}
+
+func TestGetKeyTypeForceTier(t *testing.T) {
+	policy := rpolicy.RotationPolicy{DailyPrefix: "daily_", WeeklyPrefix: "weekly_", MonthlyPrefix: "monthly_"}
+
+	// The first of the month, a Monday and any other day
+	dates := []time.Time{
+		time.Date(2024, time.June, 1, 2, 0, 0, 0, time.UTC),
+		time.Date(2024, time.June, 3, 2, 0, 0, 0, time.UTC),
+		time.Date(2024, time.June, 5, 2, 0, 0, 0, time.UTC),
+	}
+	expected := []string{"monthly_", "weekly_", "daily_"}
+	for i, date := range dates {
+		if prefix := GetKeyType(policy, date); prefix != expected[i] {
+			t.Error(fmt.Sprintf("expected %s to be classified as '%s' but got '%s'", date, expected[i], prefix))
+		}
+	}
+
+	for _, forced := range expected {
+		policy.ForceTier = forced
+		for _, date := range dates {
+			if prefix := GetKeyType(policy, date); prefix != forced {
+				t.Error(fmt.Sprintf("expected %s to be classified as the forced tier '%s' but got '%s'", date, forced, prefix))
+			}
+		}
+	}
+}
+
+func TestGetKeyTypeYearly(t *testing.T) {
+	policy := rpolicy.RotationPolicy{DailyPrefix: "daily_", WeeklyPrefix: "weekly_", MonthlyPrefix: "monthly_", YearlyPrefix: "yearly_"}
+
+	// January 1st 2024 is also the first of a month and a Monday
+	dates := []time.Time{
+		time.Date(2024, time.January, 1, 2, 0, 0, 0, time.UTC),
+		time.Date(2024, time.February, 1, 2, 0, 0, 0, time.UTC),
+		time.Date(2024, time.January, 8, 2, 0, 0, 0, time.UTC),
+		time.Date(2024, time.January, 2, 2, 0, 0, 0, time.UTC),
+	}
+	expected := []string{"yearly_", "monthly_", "weekly_", "daily_"}
+	for i, date := range dates {
+		if prefix := GetKeyType(policy, date); prefix != expected[i] {
+			t.Error(fmt.Sprintf("expected %s to be classified as '%s' but got '%s'", date, expected[i], prefix))
+		}
+	}
+
+	// Without a yearly tier January 1st is a monthly backup
+	policy.YearlyPrefix = ""
+	if prefix := GetKeyType(policy, dates[0]); prefix != "monthly_" {
+		t.Error(fmt.Sprintf("expected %s to be classified as 'monthly_' without a yearly tier but got '%s'", dates[0], prefix))
+	}
+}
+
+func TestGetKeyTypeTimezone(t *testing.T) {
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Skip(fmt.Sprintf("time zone database is unavailable: %v", err))
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(fmt.Sprintf("time zone database is unavailable: %v", err))
+	}
+
+	policy := rpolicy.RotationPolicy{DailyPrefix: "daily_", WeeklyPrefix: "weekly_", MonthlyPrefix: "monthly_", YearlyPrefix: "yearly_"}
+
+	tests := []struct {
+		location *time.Location
+		keyTime  time.Time
+		expected string
+	}{
+		// Sunday 31st March in UTC is already Monday 1st April in Sydney
+		{time.UTC, time.Date(2024, time.March, 31, 23, 30, 0, 0, time.UTC), "daily_"},
+		{sydney, time.Date(2024, time.March, 31, 23, 30, 0, 0, time.UTC), "monthly_"},
+		// Sunday 30th June at 13:59 UTC is a minute before midnight in Sydney and Monday 1st July a minute later
+		{sydney, time.Date(2024, time.June, 30, 13, 59, 0, 0, time.UTC), "daily_"},
+		{sydney, time.Date(2024, time.June, 30, 14, 1, 0, 0, time.UTC), "monthly_"},
+		// Monday 1st January in UTC is still New Year's Eve in New York
+		{time.UTC, time.Date(2024, time.January, 1, 3, 0, 0, 0, time.UTC), "yearly_"},
+		{newYork, time.Date(2024, time.January, 1, 3, 0, 0, 0, time.UTC), "daily_"},
+		// After daylight saving time begins on 10th March New York is UTC-4, so 04:30 UTC is 00:30 on Monday
+		{newYork, time.Date(2024, time.March, 11, 4, 30, 0, 0, time.UTC), "weekly_"},
+		// After daylight saving time ends on 3rd November New York is UTC-5, so 04:30 UTC is 23:30 on Sunday
+		{newYork, time.Date(2024, time.November, 4, 4, 30, 0, 0, time.UTC), "daily_"},
+		{newYork, time.Date(2024, time.November, 4, 5, 30, 0, 0, time.UTC), "weekly_"},
+	}
+	for _, test := range tests {
+		policy.Location = test.location
+		if prefix := GetKeyType(policy, test.keyTime); prefix != test.expected {
+			t.Error(fmt.Sprintf("expected %s to be classified as '%s' in %s but got '%s'", test.keyTime, test.expected, test.location, prefix))
+		}
+	}
+}
+
+func TestResolveTier(t *testing.T) {
+	policy := rpolicy.RotationPolicy{DailyPrefix: "daily_", WeeklyPrefix: "weekly_", MonthlyPrefix: "monthly_"}
+
+	for tier, expected := range map[string]string{"daily": "daily_", "weekly": "weekly_", "monthly": "monthly_", "monthly_": "monthly_"} {
+		prefix, err := ResolveTier(policy, tier)
+		if err != nil || prefix != expected {
+			t.Error(fmt.Sprintf("expected tier '%s' to resolve to '%s' but got '%s': %v", tier, expected, prefix, err))
+		}
+	}
+
+	yearlyPolicy := policy
+	yearlyPolicy.YearlyPrefix = "yearly_"
+	if prefix, err := ResolveTier(yearlyPolicy, "yearly"); err != nil || prefix != "yearly_" {
+		t.Error(fmt.Sprintf("expected tier 'yearly' to resolve to 'yearly_' but got '%s': %v", prefix, err))
+	}
+
+	for _, invalid := range []string{"yearly", "Monthly", "month", ""} {
+		if _, err := ResolveTier(policy, invalid); err == nil {
+			t.Error("expected error when resolving invalid tier: " + invalid)
+		}
+	}
+}
+
+func TestNewKeyTimeParser(t *testing.T) {
+	expectedTimes := map[string]map[string]time.Time{
+		"": {
+			"daily_portfolio_20240131T020000":     time.Date(2024, time.January, 31, 2, 0, 0, 0, time.Local),
+			"daily_portfolio_20240130T020000.zst": time.Date(2024, time.January, 30, 2, 0, 0, 0, time.Local),
+		},
+		"2006-01-02_1504": {
+			"weekly_db_2024-02-05_2330":        time.Date(2024, time.February, 5, 23, 30, 0, 0, time.Local),
+			"weekly_db_2024-02-05_2330.tar.gz": time.Date(2024, time.February, 5, 23, 30, 0, 0, time.Local),
+		},
+		"060102.150405.000": {
+			"daily_logs_240131.020000.123": time.Date(2024, time.January, 31, 2, 0, 0, 123000000, time.Local),
+		},
+	}
+
+	for layout, keys := range expectedTimes {
+		parse, err := NewKeyTimeParser(layout, nil)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected layout '%s' to be valid: %v", layout, err))
+		}
+		for key, expected := range keys {
+			if keyTime, ok := parse(key); !ok || !keyTime.Equal(expected) {
+				t.Error(fmt.Sprintf("expected key '%s' to be parsed with layout '%s' as %s but got %s", key, layout, expected, keyTime))
+			}
+		}
+	}
+
+	parse, _ := NewKeyTimeParser("2006-01-02_1504", nil)
+	for _, key := range []string{"weekly_db_20240205T233000", "weekly_db_2024-13-05_2330", "weekly_db_2024-02-05_2330/nested", "weekly_db"} {
+		if _, ok := parse(key); ok {
+			t.Error("expected key not to be parsed: " + key)
+		}
+	}
+}
+
+func TestNewKeyTimeParserInvalid(t *testing.T) {
+	for _, layout := range []string{"Jan 2 2006", "150405", "2006-01", "20060102T030405PM", "2006-01-02 MST"} {
+		if _, err := NewKeyTimeParser(layout, nil); err == nil {
+			t.Error("expected error when creating a parser of invalid layout: " + layout)
+		}
+	}
+}
+
+func TestNewKeyTimeParserLocation(t *testing.T) {
+	zone := "Australia/Sydney"
+	if time.Local.String() == zone {
+		zone = "Pacific/Honolulu" // The time zone of --timezone must differ from the local time zone
+	}
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		t.Skip(fmt.Sprintf("time zone database is unavailable: %v", err))
+	}
+
+	// The keys were timestamped in the time zone of --timezone 12 and 36 hours before the rotation
+	runTime := time.Date(2024, time.January, 31, 12, 0, 0, 0, time.UTC)
+	keys := map[string]time.Duration{
+		"daily_db_" + runTime.Add(-time.Hour*12).In(location).Format(DefaultKeyTimeLayout): time.Hour * 12,
+		"daily_db_" + runTime.Add(-time.Hour*36).In(location).Format(DefaultKeyTimeLayout): time.Hour * 36,
+	}
+
+	parse, err := NewKeyTimeParser(DefaultKeyTimeLayout, location)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the default layout to be valid: %v", err))
+	}
+	for key, expectedAge := range keys {
+		keyTime, ok := parse(key)
+		if !ok {
+			t.Fatal("expected key to be parsed: " + key)
+		}
+		if age := runTime.Sub(keyTime); age != expectedAge {
+			t.Error(fmt.Sprintf("expected key '%s' parsed in %s to be %s old but got %s", key, location, expectedAge, age))
+		}
+	}
+}
+
+func TestSortKeysByKeyTime(t *testing.T) {
+	parse, _ := NewKeyTimeParser("2006-01-02_1504", nil)
+
+	// The backups were copied into the bucket in a different order than they were made, so the key time and last
+	// modified time of each key disagree
+	now := time.Now()
+	keys := map[string]time.Time{
+		"daily_db_2024-01-29_0200": now.Add(-time.Hour),
+		"daily_db_2024-01-31_0200": now.Add(-time.Hour * 3),
+		"daily_db_2024-01-30_0200": now.Add(-time.Hour * 2),
+		"daily_db_manual":          now.Add(-time.Hour * 4),
+	}
+
+	expected := "[daily_db_2024-01-31_0200 daily_db_2024-01-30_0200 daily_db_2024-01-29_0200 daily_db_manual]"
+	var sortedKeys []string
+	for _, entry := range s3client.SortKeysByKeyTime(keys, parse) {
+		sortedKeys = append(sortedKeys, entry.Key)
+	}
+	if fmt.Sprint(sortedKeys) != expected {
+		t.Error(fmt.Sprintf("expected keys to be sorted by key time as %s but got %v", expected, sortedKeys))
+	}
+}
@@ -0,0 +1,20 @@
+package version
+
+import "fmt"
+
+// Name is the product name used in the User-Agent of every request made to S3
+const Name = "s3backup"
+
+// Build information injected at build time with ldflags, e.g.
+// go build -ldflags "-X s3backup/version.Version=1.4.0 -X s3backup/version.Commit=$(git rev-parse --short HEAD) -X s3backup/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// String returns the version, commit and build date of the running build
+func String() string {
+	return fmt.Sprintf("%s %s (commit: %s, built: %s)", Name, Version, Commit, BuildDate)
+}
+